@@ -110,3 +110,23 @@ Namespace
 {{- .Release.Namespace }}
 {{- end }}
 {{- end }}
+
+{{/*
+Proxy environment variables of containers calling the Emma API
+*/}}
+{{- define "emma-csi-driver.proxyEnv" -}}
+{{- with .Values.emma.proxy }}
+{{- if .httpsProxy }}
+- name: HTTPS_PROXY
+  value: {{ .httpsProxy | quote }}
+{{- end }}
+{{- if .httpProxy }}
+- name: HTTP_PROXY
+  value: {{ .httpProxy | quote }}
+{{- end }}
+{{- if .noProxy }}
+- name: NO_PROXY
+  value: {{ .noProxy | quote }}
+{{- end }}
+{{- end }}
+{{- end }}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/emma-csi-driver/pkg/driver"
+)
+
+// runFeaturez prints the capabilities and optional features a running controller or node
+// plugin serves on its /featurez endpoint
+func runFeaturez(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("featurez", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: emma-csi-admin featurez [flags]\n\n"+
+			"Reads /featurez from a driver's metrics server, e.g. after\n"+
+			"kubectl port-forward -n kube-system emma-csi-controller-0 8080\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	address := fs.String("url", "http://localhost:8080/featurez", "URL of the driver's /featurez endpoint")
+	timeout := fs.Duration("timeout", 10*time.Second, "Request timeout")
+	output := fs.String("output", "text", "Output format (text or json)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unsupported --output %q (supported: text, json)", *output)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, *address, nil)
+	if err != nil {
+		return fmt.Errorf("invalid --url: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", *address, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", *address, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s: %s", *address, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var features driver.Features
+	if err := json.Unmarshal(body, &features); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", *address, err)
+	}
+	if *output == "json" {
+		return printJSON(features)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Driver:\t%s %s\n", features.Driver, features.Version)
+	for _, capabilities := range []struct {
+		name   string
+		values []string
+	}{
+		{"Plugin capabilities", features.PluginCapabilities},
+		{"Controller capabilities", features.ControllerCapabilities},
+		{"Node capabilities", features.NodeCapabilities},
+	} {
+		if len(capabilities.values) > 0 {
+			fmt.Fprintf(w, "%s:\t%s\n", capabilities.name, strings.Join(capabilities.values, ", "))
+		}
+	}
+	names := make([]string, 0, len(features.Features))
+	for name := range features.Features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		state := "disabled"
+		if features.Features[name] {
+			state = "enabled"
+		}
+		fmt.Fprintf(w, "Feature %s:\t%s\n", name, state)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"syscall"
+
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/emma-csi-driver/pkg/emma"
+	"github.com/emma-csi-driver/pkg/kube"
+)
+
+var version = "dev"
+
+// command is an emma-csi-admin subcommand
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"list":           {usage: "List driver-managed Emma volumes with their attachment state", run: runList},
+	"inspect":        {usage: "Show an Emma volume's details, attachment and tags", run: runInspect},
+	"detach":         {usage: "Force-detach a volume stuck on a VM", run: runDetach},
+	"delete-orphans": {usage: "Delete driver-managed Emma volumes no PersistentVolume refers to", run: runDeleteOrphans},
+	"featurez":       {usage: "Print the capabilities and features a running driver advertises", run: runFeaturez},
+	"pv":             {usage: "Print PersistentVolume manifests statically provisioning existing Emma volumes", run: runPV},
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" || os.Args[1] == "help" {
+		usage()
+		os.Exit(2)
+	}
+	if os.Args[1] == "version" {
+		fmt.Println(version)
+		return
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if err := cmd.run(ctx, os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: emma-csi-admin <command> [flags]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr, "  %-16s %s\n\nRun emma-csi-admin <command> -h for the flags of a command.\n", "version", "Print the version")
+}
+
+// clientFlags are the Emma API flags shared by commands, defaulting to the environment
+// variables the driver pods use
+type clientFlags struct {
+	apiURL       *string
+	clientID     *string
+	clientSecret *string
+}
+
+func addClientFlags(fs *flag.FlagSet) clientFlags {
+	return clientFlags{
+		apiURL:       fs.String("emma-api-url", envOr("EMMA_API_URL", "https://api.emma.ms/external"), "Emma API base URL (env EMMA_API_URL)"),
+		clientID:     fs.String("client-id", os.Getenv("EMMA_CLIENT_ID"), "Emma API client ID (env EMMA_CLIENT_ID)"),
+		clientSecret: fs.String("client-secret", os.Getenv("EMMA_CLIENT_SECRET"), "Emma API client secret (env EMMA_CLIENT_SECRET)"),
+	}
+}
+
+// client returns an Emma client for the flags
+func (f clientFlags) client() (*emma.Client, error) {
+	if *f.clientID == "" || *f.clientSecret == "" {
+		return nil, fmt.Errorf("missing Emma credentials: set --client-id and --client-secret or EMMA_CLIENT_ID and EMMA_CLIENT_SECRET")
+	}
+	emma.SetVersion(version)
+	return emma.NewClient(*f.apiURL, *f.clientID, *f.clientSecret)
+}
+
+// addKubeconfigFlag adds the kubeconfig flag of commands reading Kubernetes objects
+func addKubeconfigFlag(fs *flag.FlagSet) *string {
+	return fs.String("kubeconfig", os.Getenv("KUBECONFIG"), "Path to kubeconfig file (env KUBECONFIG, defaults to in-cluster config)")
+}
+
+// kubeClient returns a Kubernetes client for a kubeconfig flag
+func kubeClient(kubeconfig string) (kubernetes.Interface, error) {
+	client, err := kube.NewClient(kubeconfig)
+	if err != nil && kubeconfig == "" {
+		return nil, fmt.Errorf("%w; outside a cluster, set --kubeconfig", err)
+	}
+	return client, err
+}
+
+// parseVolumeID parses an Emma volume ID argument
+func parseVolumeID(arg string) (int32, error) {
+	id, err := strconv.ParseInt(arg, 10, 32)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid volume ID %q: expected a positive numeric Emma volume ID", arg)
+	}
+	return int32(id), nil
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/emma-csi-driver/pkg/driver"
+	"github.com/emma-csi-driver/pkg/emma"
+)
+
+// runDeleteOrphans deletes the cluster's Emma volumes that no PersistentVolume refers to,
+// such as volumes left behind by deleted PVs with a Retain policy or interrupted provisioning.
+// Only volumes tagged with the cluster ID are considered, and unless --dry-run=false is given
+// nothing is deleted.
+func runDeleteOrphans(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("delete-orphans", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: emma-csi-admin delete-orphans [flags]\n\n"+
+			"Deletes Emma volumes created by this cluster that no PersistentVolume of the cluster refers to.\n"+
+			"Only volumes tagged with --cluster-id are considered, which the controller writes with\n"+
+			"--tag-volumes and --cluster-id. Attached and protected volumes are never deleted.\n"+
+			"Unless --dry-run=false is given, the volumes that would be deleted are only printed.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	client := addClientFlags(fs)
+	kubeconfig := addKubeconfigFlag(fs)
+	dryRun := fs.Bool("dry-run", true, "Only print what would be deleted; set to false to delete the orphaned volumes")
+	clusterID := fs.String("cluster-id", "", "ID of the cluster, as passed to the controller's --cluster-id (required)")
+	dataCenterID := fs.String("datacenter", "", "Only consider volumes in this datacenter")
+	minAge := fs.Duration("min-age", time.Hour, "Keep volumes created more recently, which provisioning may not have recorded in a PV yet")
+	includeRetained := fs.Bool("include-retained", false, "Also delete volumes tagged with the Retain reclaim policy")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	// Other clusters sharing the Emma project have PVs this cluster cannot see
+	if *clusterID == "" {
+		return fmt.Errorf("--cluster-id is required: only volumes tagged with this cluster's ID are safe to consider")
+	}
+
+	emmaClient, err := client.client()
+	if err != nil {
+		return err
+	}
+	kubeClient, err := kubeClient(*kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	// PVs are listed before volumes, so a volume provisioned in between is seen without its PV
+	// only if it is also younger than --min-age
+	pvs, err := kubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list PersistentVolumes: %w", err)
+	}
+	volumes, err := emmaClient.ListVolumesFiltered(ctx, emma.ListVolumesOptions{DataCenterID: *dataCenterID})
+	if err != nil {
+		return fmt.Errorf("failed to list volumes: %w", err)
+	}
+
+	orphans := driver.FindOrphanedVolumes(volumes, driver.PersistentVolumeHandles(pvs.Items), driver.OrphanOptions{
+		ClusterID:       *clusterID,
+		MinAge:          *minAge,
+		IncludeRetained: *includeRetained,
+	}, time.Now())
+	if len(orphans) == 0 {
+		fmt.Printf("No orphaned volumes tagged with cluster ID %q found\n", *clusterID)
+		return nil
+	}
+
+	var deleted, skipped, failed int
+	for _, orphan := range orphans {
+		volume := orphan.Volume
+		describe := fmt.Sprintf("volume %d %q (%dGB %s in %s, PVC %s)", volume.ID, volume.Name, volume.SizeGB, volume.Type, volume.DataCenterID, owningClaim(volume))
+		switch {
+		case orphan.Skip != "":
+			fmt.Printf("Keeping %s: %s\n", describe, orphan.Skip)
+			skipped++
+		case *dryRun:
+			fmt.Printf("Would delete %s (dry run)\n", describe)
+			deleted++
+		default:
+			if err := emmaClient.DeleteVolume(ctx, volume.ID); err != nil {
+				fmt.Printf("Failed to delete %s: %v\n", describe, err)
+				failed++
+				continue
+			}
+			fmt.Printf("Deleted %s\n", describe)
+			deleted++
+		}
+	}
+
+	if *dryRun {
+		fmt.Printf("Would delete %d of %d orphaned volumes, kept %d; run with --dry-run=false to delete them\n", deleted, len(orphans), skipped)
+		return nil
+	}
+	fmt.Printf("Deleted %d of %d orphaned volumes, kept %d\n", deleted, len(orphans), skipped)
+	if failed > 0 {
+		return fmt.Errorf("failed to delete %d volumes", failed)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/emma-csi-driver/pkg/driver"
+	"github.com/emma-csi-driver/pkg/emma"
+)
+
+// runPV prints PersistentVolume manifests for the Emma volumes given by ID, or for all
+// unattached volumes matching the filters with --all
+func runPV(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("pv", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: emma-csi-admin pv [flags] [volume-id...]\n\nPrints PersistentVolume YAML for existing Emma volumes, ready for kubectl apply -f -.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	client := addClientFlags(fs)
+	all := fs.Bool("all", false, "Generate PVs for every unattached volume matching --datacenter and --volume-name-prefix instead of the given IDs")
+	dataCenterID := fs.String("datacenter", "", "Only include volumes in this datacenter")
+	volumeNamePrefix := fs.String("volume-name-prefix", "", "Only include volumes whose Emma name starts with this prefix")
+
+	namePrefix := fs.String("pv-name-prefix", "emma-", "Prefix of the PV names, followed by the Emma volume ID")
+	storageClass := fs.String("storage-class", "", "storageClassName of the PVs; PVCs must request the same class to bind (empty binds PVCs without a class)")
+	fsType := fs.String("fs-type", "ext4", "Filesystem type of the volumes (ext4 or xfs)")
+	reclaimPolicy := fs.String("reclaim-policy", string(v1.PersistentVolumeReclaimRetain), "Reclaim policy of the PVs (Retain or Delete; Delete removes the Emma volume with its PV)")
+	accessMode := fs.String("access-mode", string(v1.ReadWriteOnce), "Access mode of the PVs (ReadWriteOnce or ReadWriteOncePod)")
+	sizeUnit := fs.String("emma-size-unit", "GiB", "Unit of Emma volume sizes, matching the controller's --emma-size-unit (GiB or GB)")
+	expectFormatted := fs.Bool("expect-formatted", true, "Mark the volumes as holding a filesystem, so the node refuses to format them if none is found")
+	claim := fs.String("claim", "", "Pre-bind the PV to this namespace/name PVC (a single volume ID only)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	opts := driver.StaticVolumeOptions{
+		NamePrefix:       *namePrefix,
+		StorageClassName: *storageClass,
+		FSType:           *fsType,
+		ReclaimPolicy:    v1.PersistentVolumeReclaimPolicy(*reclaimPolicy),
+		AccessMode:       v1.PersistentVolumeAccessMode(*accessMode),
+		ExpectFormatted:  *expectFormatted,
+	}
+	if *fsType != "ext4" && *fsType != "xfs" {
+		return fmt.Errorf("unsupported --fs-type %q (supported: ext4, xfs)", *fsType)
+	}
+	if opts.ReclaimPolicy != v1.PersistentVolumeReclaimRetain && opts.ReclaimPolicy != v1.PersistentVolumeReclaimDelete {
+		return fmt.Errorf("unsupported --reclaim-policy %q (supported: Retain, Delete)", *reclaimPolicy)
+	}
+	if opts.AccessMode != v1.ReadWriteOnce && opts.AccessMode != v1.ReadWriteOncePod {
+		return fmt.Errorf("unsupported --access-mode %q (supported: ReadWriteOnce, ReadWriteOncePod)", *accessMode)
+	}
+	unit, err := driver.ParseSizeUnit(*sizeUnit)
+	if err != nil {
+		return err
+	}
+	opts.SizeUnit = unit
+	if *claim != "" {
+		namespace, name, ok := strings.Cut(*claim, "/")
+		if !ok || namespace == "" || name == "" {
+			return fmt.Errorf("invalid --claim %q: expected namespace/name", *claim)
+		}
+		if *all || fs.NArg() != 1 {
+			return fmt.Errorf("--claim needs exactly one volume ID")
+		}
+		opts.ClaimNamespace, opts.ClaimName = namespace, name
+	}
+	if *all == (fs.NArg() > 0) {
+		fs.Usage()
+		return fmt.Errorf("give volume IDs or --all")
+	}
+
+	emmaClient, err := client.client()
+	if err != nil {
+		return err
+	}
+	volumes, err := selectVolumes(ctx, emmaClient, fs.Args(), *all, emma.ListVolumesOptions{
+		DataCenterID: *dataCenterID,
+		NamePrefix:   *volumeNamePrefix,
+	})
+	if err != nil {
+		return err
+	}
+	if len(volumes) == 0 {
+		return fmt.Errorf("no matching volumes")
+	}
+
+	for i, volume := range volumes {
+		pv, err := driver.StaticPersistentVolume(volume, opts)
+		if err != nil {
+			return err
+		}
+		manifest, err := yaml.Marshal(pv)
+		if err != nil {
+			return fmt.Errorf("failed to encode PV of volume %d: %w", volume.ID, err)
+		}
+		if i > 0 {
+			fmt.Println("---")
+		}
+		fmt.Printf("# Emma volume %d %q: %dGB %s in %s, %s\n", volume.ID, volume.Name, volume.SizeGB, volume.Type, volume.DataCenterID, volume.Status)
+		fmt.Print(string(manifest))
+	}
+	return nil
+}
+
+// selectVolumes returns the volumes of the given IDs, or every unattached volume matching
+// the filters. Volumes given by ID are returned even when attached, with a warning.
+func selectVolumes(ctx context.Context, client *emma.Client, ids []string, all bool, filter emma.ListVolumesOptions) ([]*emma.VolumeResponse, error) {
+	if all {
+		listed, err := client.ListVolumesFiltered(ctx, filter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list volumes: %w", err)
+		}
+		var volumes []*emma.VolumeResponse
+		for _, volume := range listed {
+			if volume.AttachedToID != nil {
+				fmt.Fprintf(os.Stderr, "Skipping volume %d %q: attached to VM %d\n", volume.ID, volume.Name, *volume.AttachedToID)
+				continue
+			}
+			volumes = append(volumes, volume)
+		}
+		return volumes, nil
+	}
+
+	volumes := make([]*emma.VolumeResponse, 0, len(ids))
+	for _, arg := range ids {
+		id, err := parseVolumeID(arg)
+		if err != nil {
+			return nil, err
+		}
+		volume, err := client.GetVolume(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get volume %d: %w", id, err)
+		}
+		if volume.AttachedToID != nil {
+			fmt.Fprintf(os.Stderr, "Warning: volume %d is attached to VM %d; detach it before a pod uses the PV\n", volume.ID, *volume.AttachedToID)
+		}
+		volumes = append(volumes, volume)
+	}
+	return volumes, nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/emma-csi-driver/pkg/driver"
+	"github.com/emma-csi-driver/pkg/emma"
+)
+
+// runList lists the driver's Emma volumes with their attachment state and owning PVC
+func runList(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	client := addClientFlags(fs)
+	dataCenterID := fs.String("datacenter", "", "Only list volumes in this datacenter")
+	namePrefix := fs.String("volume-name-prefix", driver.DefaultVolumeNamePrefix, "Name prefix of volumes created by the driver, matching the provisioner's --volume-name-prefix")
+	all := fs.Bool("all", false, "Also list volumes not created by the driver")
+	output := fs.String("output", "table", "Output format (table or json)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("unsupported --output %q (supported: table, json)", *output)
+	}
+
+	emmaClient, err := client.client()
+	if err != nil {
+		return err
+	}
+	listed, err := emmaClient.ListVolumesFiltered(ctx, emma.ListVolumesOptions{DataCenterID: *dataCenterID})
+	if err != nil {
+		return fmt.Errorf("failed to list volumes: %w", err)
+	}
+	volumes := make([]*emma.VolumeResponse, 0, len(listed))
+	for _, volume := range listed {
+		if *all || driver.ManagedVolume(volume, *namePrefix) {
+			volumes = append(volumes, volume)
+		}
+	}
+
+	if *output == "json" {
+		return printJSON(volumes)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSTATUS\tSIZE\tTYPE\tDATACENTER\tATTACHED TO\tPVC")
+	for _, volume := range volumes {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%dGB\t%s\t%s\t%s\t%s\n", volume.ID, volume.Name, volume.Status, volume.SizeGB,
+			volume.Type, volume.DataCenterID, attachedTo(volume), owningClaim(volume))
+	}
+	return w.Flush()
+}
+
+// runInspect prints the details of one Emma volume
+func runInspect(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: emma-csi-admin inspect [flags] <volume-id>\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	client := addClientFlags(fs)
+	output := fs.String("output", "text", "Output format (text or json)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unsupported --output %q (supported: text, json)", *output)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("give one volume ID")
+	}
+	id, err := parseVolumeID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	emmaClient, err := client.client()
+	if err != nil {
+		return err
+	}
+	volume, err := emmaClient.GetVolume(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to get volume %d: %w", id, err)
+	}
+	if *output == "json" {
+		return printJSON(volume)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID:\t%d\n", volume.ID)
+	fmt.Fprintf(w, "Name:\t%s\n", volume.Name)
+	fmt.Fprintf(w, "Status:\t%s\n", volume.Status)
+	fmt.Fprintf(w, "Size:\t%dGB\n", volume.SizeGB)
+	fmt.Fprintf(w, "Type:\t%s\n", volume.Type)
+	fmt.Fprintf(w, "Datacenter:\t%s\n", volume.DataCenterID)
+	fmt.Fprintf(w, "Created:\t%s\n", volume.CreatedAt)
+	if volume.ProviderVolumeID != "" {
+		fmt.Fprintf(w, "Provider volume:\t%s\n", volume.ProviderVolumeID)
+	}
+	if volume.AttachedToID == nil {
+		fmt.Fprintf(w, "Attached to:\t-\n")
+	} else if vm, err := emmaClient.GetVM(ctx, *volume.AttachedToID); err != nil {
+		fmt.Fprintf(w, "Attached to:\tVM %d (%v)\n", *volume.AttachedToID, err)
+	} else {
+		fmt.Fprintf(w, "Attached to:\tVM %d %q (%s)\n", vm.GetId(), vm.GetName(), vm.GetStatus())
+	}
+	if attachment := volume.Attachment; attachment != nil {
+		fmt.Fprintf(w, "Device serial:\t%s\n", attachment.Serial)
+		if attachment.LUN != nil {
+			fmt.Fprintf(w, "Device LUN:\t%d\n", *attachment.LUN)
+		}
+	}
+	fmt.Fprintf(w, "PVC:\t%s\n", owningClaim(volume))
+	for _, tag := range volume.Tags {
+		fmt.Fprintf(w, "Tag:\t%s=%s\n", tag.Key, tag.Value)
+	}
+	return w.Flush()
+}
+
+// runDetach force-detaches a volume from the VM it is attached to
+func runDetach(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("detach", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: emma-csi-admin detach [flags] <volume-id>\n\n"+
+			"Detaches a volume in Emma regardless of Kubernetes. Make sure no pod uses it: data not flushed by the VM is lost.\n"+
+			"Unless --dry-run=false is given, the volume that would be detached is only printed.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	client := addClientFlags(fs)
+	dryRun := fs.Bool("dry-run", true, "Only print what would be detached; set to false to detach the volume")
+	timeout := fs.Duration("timeout", 5*time.Minute, "How long to wait for the volume to be detached (0 does not wait)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("give one volume ID")
+	}
+	id, err := parseVolumeID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	emmaClient, err := client.client()
+	if err != nil {
+		return err
+	}
+	volume, err := emmaClient.GetVolume(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to get volume %d: %w", id, err)
+	}
+	if volume.AttachedToID == nil {
+		fmt.Printf("Volume %d is not attached (status %s)\n", volume.ID, volume.Status)
+		return nil
+	}
+	vmID := *volume.AttachedToID
+	if *dryRun {
+		fmt.Printf("Would detach volume %d from VM %d (dry run); run with --dry-run=false to detach it\n", volume.ID, vmID)
+		return nil
+	}
+
+	if err := emmaClient.DetachVolume(ctx, vmID, volume.ID); err != nil {
+		return fmt.Errorf("failed to detach volume %d from VM %d: %w", volume.ID, vmID, err)
+	}
+	if *timeout > 0 {
+		if err := emmaClient.WaitForVolumeDetachment(ctx, volume.ID, *timeout); err != nil {
+			return fmt.Errorf("volume %d did not detach from VM %d: %w", volume.ID, vmID, err)
+		}
+	}
+	fmt.Printf("Detached volume %d from VM %d\n", volume.ID, vmID)
+	fmt.Println("If a VolumeAttachment still refers to the volume, delete it so Kubernetes stops treating the volume as attached.")
+	return nil
+}
+
+// attachedTo returns the VM a volume is attached to, or "-"
+func attachedTo(volume *emma.VolumeResponse) string {
+	if volume.AttachedToID == nil {
+		return "-"
+	}
+	return "VM " + strconv.Itoa(int(*volume.AttachedToID))
+}
+
+// owningClaim returns the PVC a volume was provisioned for from its tags, or "-"
+func owningClaim(volume *emma.VolumeResponse) string {
+	name, ok := volume.Tag(driver.TagPVCName)
+	if !ok {
+		return "-"
+	}
+	if namespace, ok := volume.Tag(driver.TagPVCNamespace); ok {
+		return namespace + "/" + name
+	}
+	return name
+}
+
+func printJSON(value interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
@@ -0,0 +1,11 @@
+// Command emma-csi-driver runs the Emma CSI driver's controller plugin, node plugin or
+// both, as selected with --mode. Without --mode it runs both in one process.
+package main
+
+import "github.com/emma-csi-driver/pkg/app"
+
+var version = "dev"
+
+func main() {
+	app.Main(app.ModeAll, version)
+}
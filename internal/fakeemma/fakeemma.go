@@ -0,0 +1,236 @@
+// Package fakeemma provides an in-memory Emma API for the driver's tests
+package fakeemma
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	emmasdk "github.com/emma-community/emma-go-sdk"
+
+	"github.com/emma-csi-driver/pkg/emma"
+)
+
+// API is an in-memory driver.EmmaVolumeAPI for unit tests and the csi-sanity harness.
+// Volume actions take effect immediately, so waits succeed unless WaitErr is set.
+type API struct {
+	mu      sync.Mutex
+	nextID  int32
+	volumes map[int32]*emma.VolumeResponse
+
+	// DataCenters are the valid datacenters, nil accepts any
+	DataCenters map[string]bool
+	Configs     []emmasdk.VolumeConfiguration
+	Clusters    []emmasdk.Kubernetes
+
+	// VMs are listed by ListVMs and, when set, are the only VMs volumes attach to
+	VMs []emmasdk.Vm
+
+	// CreateErr, AttachErr and WaitErr fail the matching calls when set
+	CreateErr error
+	AttachErr error
+	WaitErr   error
+
+	// ReserveErr fails ReserveWaiter when set, as if the waiter limit were reached
+	ReserveErr error
+
+	// calls records the volume-changing API calls in order
+	calls []string
+}
+
+// New creates a fake with no volumes
+func New() *API {
+	return &API{nextID: 100, volumes: make(map[int32]*emma.VolumeResponse)}
+}
+
+// Calls returns the volume-changing API calls made so far, in order
+func (f *API) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// AddVolume stores a volume and returns it
+func (f *API) AddVolume(volume emma.VolumeResponse) *emma.VolumeResponse {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.volumes[volume.ID] = &volume
+	return &volume
+}
+
+// RemoveVolume drops a stored volume without recording an API call, as if it was
+// deleted outside the driver
+func (f *API) RemoveVolume(volumeID int32) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.volumes, volumeID)
+}
+
+// Volume returns a copy of a stored volume, or nil
+func (f *API) Volume(volumeID int32) *emma.VolumeResponse {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if volume, ok := f.volumes[volumeID]; ok {
+		copied := *volume
+		return &copied
+	}
+	return nil
+}
+
+func (f *API) record(format string, args ...interface{}) {
+	f.calls = append(f.calls, fmt.Sprintf(format, args...))
+}
+
+func (f *API) CreateVolume(ctx context.Context, name string, sizeGB int32, volumeType string, dataCenterID string, tags []emma.VolumeTag) (*emma.VolumeResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("create %s", name)
+	if f.CreateErr != nil {
+		return nil, f.CreateErr
+	}
+	f.nextID++
+	volume := &emma.VolumeResponse{ID: f.nextID, Name: name, SizeGB: sizeGB, Type: volumeType, Status: "AVAILABLE", DataCenterID: dataCenterID, Tags: tags}
+	f.volumes[volume.ID] = volume
+	copied := *volume
+	return &copied, nil
+}
+
+func (f *API) GetVolume(ctx context.Context, volumeID int32) (*emma.VolumeResponse, error) {
+	if volume := f.Volume(volumeID); volume != nil {
+		return volume, nil
+	}
+	return nil, fmt.Errorf("%w: %d", emma.ErrVolumeNotFound, volumeID)
+}
+
+func (f *API) ListVolumes(ctx context.Context) ([]*emma.VolumeResponse, error) {
+	return f.ListVolumesFiltered(ctx, emma.ListVolumesOptions{})
+}
+
+func (f *API) ListVolumesFiltered(ctx context.Context, opts emma.ListVolumesOptions) ([]*emma.VolumeResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var volumes []*emma.VolumeResponse
+	for _, volume := range f.volumes {
+		if opts.DataCenterID != "" && volume.DataCenterID != opts.DataCenterID {
+			continue
+		}
+		if !strings.HasPrefix(volume.Name, opts.NamePrefix) {
+			continue
+		}
+		copied := *volume
+		volumes = append(volumes, &copied)
+	}
+	return volumes, nil
+}
+
+func (f *API) DeleteVolume(ctx context.Context, volumeID int32) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("delete %d", volumeID)
+	delete(f.volumes, volumeID)
+	return nil
+}
+
+func (f *API) ResizeVolume(ctx context.Context, volumeID int32, newSizeGB int32) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("resize %d %d", volumeID, newSizeGB)
+	volume, ok := f.volumes[volumeID]
+	if !ok {
+		return fmt.Errorf("volume %d not found", volumeID)
+	}
+	volume.SizeGB = newSizeGB
+	return nil
+}
+
+func (f *API) AttachVolume(ctx context.Context, vmID int32, volumeID int32) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("attach %d %d", volumeID, vmID)
+	if f.AttachErr != nil {
+		return f.AttachErr
+	}
+	if f.VMs != nil && !f.hasVM(vmID) {
+		return fmt.Errorf("%w: VM %d", emma.ErrVMNotFound, vmID)
+	}
+	volume, ok := f.volumes[volumeID]
+	if !ok {
+		return fmt.Errorf("volume %d not found", volumeID)
+	}
+	volume.AttachedToID = &vmID
+	volume.Status = "ACTIVE"
+	return nil
+}
+
+func (f *API) DetachVolume(ctx context.Context, vmID int32, volumeID int32) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("detach %d %d", volumeID, vmID)
+	volume, ok := f.volumes[volumeID]
+	if !ok {
+		return fmt.Errorf("volume %d not found", volumeID)
+	}
+	volume.AttachedToID = nil
+	volume.Status = "AVAILABLE"
+	return nil
+}
+
+func (f *API) WaitForVolumeStatus(ctx context.Context, volumeID int32, desiredStatus string, timeout time.Duration) error {
+	return f.WaitErr
+}
+
+func (f *API) WaitForVolumeAttachment(ctx context.Context, volumeID int32, vmID int32, timeout time.Duration) error {
+	return f.WaitErr
+}
+
+func (f *API) WaitForVolumeDetachment(ctx context.Context, volumeID int32, timeout time.Duration) error {
+	return f.WaitErr
+}
+
+func (f *API) WaitForVolumeSettled(ctx context.Context, volumeID int32, timeout time.Duration) (*emma.VolumeResponse, error) {
+	if f.WaitErr != nil {
+		return nil, f.WaitErr
+	}
+	return f.GetVolume(ctx, volumeID)
+}
+
+func (f *API) ReserveWaiter(ctx context.Context) (context.Context, func(), error) {
+	return ctx, func() {}, f.ReserveErr
+}
+
+func (f *API) ValidateDataCenter(ctx context.Context, dataCenterID string) error {
+	if f.DataCenters != nil && !f.DataCenters[dataCenterID] {
+		return fmt.Errorf("data center %s not found", dataCenterID)
+	}
+	return nil
+}
+
+func (f *API) GetVolumeConfigs(ctx context.Context) ([]emmasdk.VolumeConfiguration, error) {
+	return f.Configs, nil
+}
+
+func (f *API) ListKubernetesClusters(ctx context.Context) ([]emmasdk.Kubernetes, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("list clusters")
+	return f.Clusters, nil
+}
+
+func (f *API) ListVMs(ctx context.Context) ([]emmasdk.Vm, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("list vms")
+	return f.VMs, nil
+}
+
+// hasVM reports whether a VM exists, f.mu held
+func (f *API) hasVM(vmID int32) bool {
+	for _, vm := range f.VMs {
+		if vm.GetId() == vmID {
+			return true
+		}
+	}
+	return false
+}
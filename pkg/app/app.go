@@ -0,0 +1,205 @@
+// Package app runs the Emma CSI driver's controller and node plugins. Both run from the
+// same code, selected with --mode, so one binary can serve either or both of them.
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"k8s.io/klog/v2"
+
+	"github.com/emma-csi-driver/pkg/driver"
+	"github.com/emma-csi-driver/pkg/emma"
+	"github.com/emma-csi-driver/pkg/logging"
+	"github.com/emma-csi-driver/pkg/metrics"
+)
+
+// Mode selects the CSI services the driver serves
+type Mode string
+
+const (
+	// ModeController serves the identity and controller services
+	ModeController Mode = "controller"
+
+	// ModeNode serves the identity and node services
+	ModeNode Mode = "node"
+
+	// ModeAll serves every service from one process on one endpoint, for local testing
+	ModeAll Mode = "all"
+)
+
+const (
+	// defaultControllerEndpoint is the socket shared with the controller's sidecars
+	defaultControllerEndpoint = "unix:///var/lib/csi/sockets/pluginproxy/csi.sock"
+
+	// defaultNodeEndpoint is the socket registered with kubelet
+	defaultNodeEndpoint = "unix:///csi/csi.sock"
+)
+
+// ParseMode parses a --mode value
+func ParseMode(s string) (Mode, error) {
+	switch mode := Mode(s); mode {
+	case ModeController, ModeNode, ModeAll:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown mode %q, expected controller, node or all", s)
+	}
+}
+
+// RunsController reports whether the mode serves the controller service
+func (m Mode) RunsController() bool {
+	return m == ModeController || m == ModeAll
+}
+
+// RunsNode reports whether the mode serves the node service
+func (m Mode) RunsNode() bool {
+	return m == ModeNode || m == ModeAll
+}
+
+// defaultEndpoint returns the CSI endpoint of the mode when --endpoint is not set
+func (m Mode) defaultEndpoint() string {
+	if m == ModeController {
+		return defaultControllerEndpoint
+	}
+	return defaultNodeEndpoint
+}
+
+// Main parses the command line and runs the driver until it receives a shutdown signal,
+// then drains in-flight CSI calls and returns. defaultMode is used when --mode is not set
+// and version is reported to the Emma API.
+func Main(defaultMode Mode, version string) {
+	klog.InitFlags(nil)
+	opts := registerFlags(flag.CommandLine, defaultMode)
+	flag.Parse()
+
+	// Configure logging
+	logging.SetGlobalLogLevel(opts.logLevel)
+	logging.SetJSONMode(opts.jsonLogs)
+
+	mode, err := ParseMode(opts.mode)
+	if err != nil {
+		klog.Fatalf("Invalid --mode: %v", err)
+	}
+	if opts.endpoint == "" {
+		opts.endpoint = mode.defaultEndpoint()
+	}
+
+	component := string(mode)
+	if mode == ModeAll {
+		component = "driver"
+	}
+	logger := logging.NewLogger(component)
+
+	if mode.RunsController() {
+		if opts.clientID == "" {
+			klog.Fatal("client-id is required")
+		}
+		if opts.clientSecret == "" {
+			klog.Fatal("client-secret is required")
+		}
+	}
+	// The controller service uses a fixed node ID
+	driverNodeID := "controller"
+	if mode.RunsNode() {
+		if opts.node.nodeID == "" {
+			// Try to get node ID from environment or metadata service
+			opts.node.nodeID = os.Getenv("NODE_ID")
+			if opts.node.nodeID == "" {
+				klog.Fatal("node-id is required (set via flag or NODE_ID environment variable)")
+			}
+		}
+		driverNodeID = opts.node.nodeID
+	}
+
+	emma.SetVersion(version)
+
+	// Shutdown signals cancel ctx, which stops the driver, leader election and reconcilers
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.Info("Emma CSI Driver starting", map[string]interface{}{
+		"version":  version,
+		"mode":     mode,
+		"endpoint": opts.endpoint,
+		"nodeId":   driverNodeID,
+		"apiURL":   opts.emmaAPIURL,
+		"logLevel": opts.logLevel,
+		"jsonLogs": opts.jsonLogs,
+	})
+
+	// Configure histogram buckets before any metrics are recorded
+	bucketConfig, err := opts.bucketConfig()
+	if err != nil {
+		klog.Fatalf("Invalid histogram buckets: %v", err)
+	}
+	metrics.ConfigureBuckets(bucketConfig)
+
+	tlsOpts, err := opts.emmaTLSOptions()
+	if err != nil {
+		klog.Fatalf("Invalid Emma API TLS options: %v", err)
+	}
+
+	// The controller requires the Emma API client, nodes use it when credentials are set
+	var emmaClient *emma.Client
+	if opts.clientID != "" && opts.clientSecret != "" {
+		logger.Info("Initializing Emma API client")
+		emmaClient, err = emma.NewClientWithTLSOptions(opts.emmaAPIURL, opts.clientID, opts.clientSecret, tlsOpts)
+		if err != nil {
+			logger.Error("Failed to initialize Emma API client", err)
+			klog.Fatalf("Failed to initialize Emma API client: %v", err)
+		}
+		logger.Info("Emma API client initialized successfully")
+	}
+
+	if mode.RunsNode() {
+		prepareNode(opts, emmaClient, logging.NewLogger("node"))
+	}
+
+	// Initialize CSI driver
+	// The driver serves the metrics server for as long as it runs
+	driverOpts := []driver.Option{
+		driver.WithMetrics(opts.metricsAddr),
+		driver.WithGRPCOptions(opts.grpcLimits.ServerOptions()...),
+	}
+	if mode.RunsController() && opts.controller.leaderElection {
+		// Only the replica holding the controller Lease changes volumes
+		driverOpts = append(driverOpts, driver.WithLeaderGate(metrics.IsLeader))
+	}
+	if opts.endpointTLS.Enabled() {
+		tlsConfig, err := opts.endpointTLS.Config()
+		if err != nil {
+			klog.Fatalf("Invalid CSI endpoint TLS options: %v", err)
+		}
+		driverOpts = append(driverOpts, driver.WithEndpointTLS(tlsConfig))
+		logger.Info("CSI endpoint TLS enabled", map[string]interface{}{
+			"mutualTLS": opts.endpointTLS.ClientCAFile != "",
+		})
+	}
+	drv, err := driver.NewDriver(driverNodeID, opts.endpoint, driverOpts...)
+	if err != nil {
+		logger.Error("Failed to create driver", err)
+		klog.Fatalf("Failed to create driver: %v", err)
+	}
+	drv.SetShutdownTimeout(opts.shutdownTimeout)
+	drv.SetIdentityService(driver.NewIdentityService(drv))
+
+	if mode.RunsController() {
+		setupController(ctx, drv, opts, emmaClient, tlsOpts, logging.NewLogger("controller"))
+	}
+	if mode.RunsNode() {
+		setupNode(drv, opts, emmaClient, logging.NewLogger("node"))
+	}
+
+	logger.Info("Starting driver", map[string]interface{}{"mode": mode})
+
+	// Serve until a shutdown signal, then drain the in-flight calls
+	if err := drv.Run(ctx); err != nil {
+		logger.Error("Failed to run driver", err)
+		klog.Fatalf("Failed to run driver: %v", err)
+	}
+	logger.Info("Emma CSI Driver stopped")
+}
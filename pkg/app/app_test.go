@@ -0,0 +1,64 @@
+package app
+
+import (
+	"flag"
+	"testing"
+)
+
+// TestParseMode tests the --mode values and the services each mode serves
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		value          string
+		wantErr        bool
+		wantController bool
+		wantNode       bool
+		wantEndpoint   string
+	}{
+		{value: "controller", wantController: true, wantEndpoint: defaultControllerEndpoint},
+		{value: "node", wantNode: true, wantEndpoint: defaultNodeEndpoint},
+		{value: "all", wantController: true, wantNode: true, wantEndpoint: defaultNodeEndpoint},
+		{value: "", wantErr: true},
+		{value: "Controller", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			mode, err := ParseMode(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMode(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if mode.RunsController() != tt.wantController || mode.RunsNode() != tt.wantNode {
+				t.Errorf("mode %s runs controller=%v node=%v, want %v and %v", mode, mode.RunsController(), mode.RunsNode(), tt.wantController, tt.wantNode)
+			}
+			if endpoint := mode.defaultEndpoint(); endpoint != tt.wantEndpoint {
+				t.Errorf("expected default endpoint %s, got %s", tt.wantEndpoint, endpoint)
+			}
+		})
+	}
+}
+
+// TestRegisterFlags tests that controller and node flags share one command line
+func TestRegisterFlags(t *testing.T) {
+	fs := flag.NewFlagSet("emma-csi-driver", flag.ContinueOnError)
+	opts := registerFlags(fs, ModeNode)
+	err := fs.Parse([]string{"--node-id=42", "--datacenter-id=aws-eu-west-2", "--client-id=id", "--max-volumes-per-node=8"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if opts.mode != string(ModeNode) {
+		t.Errorf("expected the default mode node, got %s", opts.mode)
+	}
+	if opts.node.nodeID != "42" || opts.node.maxVolumesPerNode != 8 {
+		t.Errorf("unexpected node options: %+v", opts.node)
+	}
+	if opts.controller.dataCenterID != "aws-eu-west-2" || opts.clientID != "id" {
+		t.Errorf("unexpected controller options: datacenter %s, client %s", opts.controller.dataCenterID, opts.clientID)
+	}
+	if opts.node.resolvedVMID() != "42" {
+		t.Errorf("expected the numeric node ID as VM ID, got %q", opts.node.resolvedVMID())
+	}
+}
@@ -0,0 +1,352 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/emma-csi-driver/pkg/driver"
+	"github.com/emma-csi-driver/pkg/emma"
+	"github.com/emma-csi-driver/pkg/kube"
+	"github.com/emma-csi-driver/pkg/logging"
+	"github.com/emma-csi-driver/pkg/metrics"
+	"github.com/emma-csi-driver/pkg/notify"
+)
+
+// publicEmmaAPIURL is the public Emma API, which is always verified
+const publicEmmaAPIURL = "https://api.emma.ms"
+
+// nodeCacheWarmupTimeout bounds the startup pass that pre-resolves node VM IDs
+const nodeCacheWarmupTimeout = 2 * time.Minute
+
+// setupController checks the Emma account, then configures the controller service from
+// the flags and registers it with the driver. Background reconcilers and leader election
+// run until ctx is canceled.
+func setupController(ctx context.Context, drv *driver.Driver, opts *options, emmaClient *emma.Client, tlsOpts emma.TLSOptions, logger *logging.Logger) {
+	c := &opts.controller
+
+	regionalURLs, err := emma.ParseRegionalEndpoints(c.emmaRegionalURLs)
+	if err != nil {
+		klog.Fatalf("Invalid --emma-regional-api-urls: %v", err)
+	}
+	if len(regionalURLs) > 0 {
+		logger.Info("Regional Emma API endpoints configured", map[string]interface{}{
+			"endpoints": regionalURLs,
+		})
+	}
+
+	retryPolicy := emma.DefaultRetryPolicy()
+	retryPolicy.MaxRetries = c.emmaAPIMaxRetries
+	retryPolicy.InitialBackoff = c.emmaAPIRetryBackoff
+
+	// Every Emma account's client shares the same TLS, rate limit, retry and endpoint settings
+	clientPool := emma.NewClientPool(opts.emmaAPIURL, emma.PoolOptions{
+		TLS:   tlsOpts,
+		QPS:   float32(c.emmaAPIQPS),
+		Burst: c.emmaAPIBurst,
+		Configure: func(client *emma.Client) {
+			client.SetMaxWaiters(c.maxVolumeWaiters)
+			client.SetRetryPolicy(retryPolicy)
+			client.SetDataCenterCacheTTL(c.dataCenterCacheTTL)
+			if len(regionalURLs) > 0 {
+				client.SetRegionalEndpoints(regionalURLs, c.emmaEndpointCooldown)
+			}
+		},
+	})
+	clientPool.Add(opts.clientID, opts.clientSecret, emma.DefaultAccount, emmaClient)
+
+	discoverDataCenters(ctx, emmaClient, logger)
+	checkPermissions(ctx, emmaClient, logger)
+
+	// Validate default datacenter if specified
+	if c.dataCenterID != "" {
+		logger.Info("Validating default datacenter", map[string]interface{}{
+			"datacenter": c.dataCenterID,
+		})
+		if err := emmaClient.ValidateDataCenter(ctx, c.dataCenterID); err != nil {
+			logger.Error("Invalid default datacenter", err)
+			klog.Fatalf("Invalid default datacenter %s: %v", c.dataCenterID, err)
+		}
+		logger.Info("Default datacenter validated successfully")
+	}
+
+	controllerService := driver.NewControllerService(drv, emmaClient)
+	// StorageClasses referencing a secret with clientId and clientSecret use their own Emma account
+	controllerService.SetClientResolver(func(ctx context.Context, clientID, clientSecret string) (driver.EmmaVolumeAPI, error) {
+		return clientPool.Get(ctx, clientID, clientSecret)
+	})
+	controllerService.SetDeleteDetachGracePeriod(c.deleteDetachGrace)
+	controllerService.SetDeleteLimits(c.deleteParallelism, float32(c.deleteAPIQPS), c.deleteAPIBurst)
+
+	sizeUnit, err := driver.ParseSizeUnit(c.emmaSizeUnit)
+	if err != nil {
+		klog.Fatalf("Invalid --emma-size-unit: %v", err)
+	}
+	controllerService.SetSizeUnit(sizeUnit)
+
+	defaultSizeBytes, err := driver.ParseVolumeSize(c.defaultVolumeSize)
+	if err != nil {
+		klog.Fatalf("Invalid --default-volume-size: %v", err)
+	}
+	var minSizeBytes int64
+	if c.minVolumeSize != "" {
+		if minSizeBytes, err = driver.ParseVolumeSize(c.minVolumeSize); err != nil {
+			klog.Fatalf("Invalid --min-volume-size: %v", err)
+		}
+	}
+	controllerService.SetVolumeSizePolicy(defaultSizeBytes, minSizeBytes)
+	controllerService.SetDefaultDataCenter(c.dataCenterID)
+
+	roundingPolicy, err := driver.ParseSizeRoundingPolicy(c.sizeRounding)
+	if err != nil {
+		klog.Fatalf("Invalid --volume-size-rounding: %v", err)
+	}
+	controllerService.SetSizeRounding(roundingPolicy)
+
+	controllerService.SetAttachFailureRemediation(c.attachRemediation)
+	controllerService.SetVolumeTagging(c.tagVolumes)
+	controllerService.SetClusterID(c.clusterID)
+
+	resolutionMode, err := driver.ParseNodeResolutionMode(c.nodeResolutionMode)
+	if err != nil {
+		klog.Fatalf("Invalid --node-resolution-mode: %v", err)
+	}
+	if resolutionMode.NeedsKubeClient() && !c.kubeClient {
+		klog.Fatalf("--node-resolution-mode=%s requires --kubernetes-client", resolutionMode)
+	}
+	controllerService.SetNodeResolutionMode(resolutionMode)
+	controllerService.SetNodeCacheTTL(c.nodeCacheTTL)
+
+	if c.webhookURL != "" {
+		notifier, err := notify.NewWebhookNotifier(c.webhookURL, c.webhookSecret)
+		if err != nil {
+			klog.Fatalf("Invalid --webhook-url: %v", err)
+		}
+		controllerService.SetNotifier(notifier)
+		logger.Info("Volume lifecycle webhook configured", map[string]interface{}{
+			"signed": c.webhookSecret != "",
+		})
+	}
+	metrics.SetAttachAgeSource(controllerService.AttachTimes)
+
+	var reconcilers []func(context.Context)
+	if c.kubeClient {
+		logger.Info("Initializing Kubernetes client")
+		client, err := kube.NewClient(opts.kubeconfig)
+		if err != nil {
+			logger.Error("Failed to initialize Kubernetes client", err)
+			klog.Fatalf("Failed to initialize Kubernetes client: %v", err)
+		}
+		controllerService.SetKubeClient(client)
+
+		// Pre-resolve node VM IDs in the background so the first attach after a restart is fast
+		reconcilers = append(reconcilers, func(ctx context.Context) {
+			warmCtx, cancel := context.WithTimeout(ctx, nodeCacheWarmupTimeout)
+			defer cancel()
+			resolved, err := controllerService.WarmNodeCache(warmCtx)
+			if err != nil {
+				logger.Warn("Node cache warm-up incomplete", map[string]interface{}{"error": err.Error(), "resolved": resolved})
+				return
+			}
+			logger.Info("Node cache warmed up", map[string]interface{}{"resolved": resolved})
+		})
+	}
+
+	// Background reconcilers and mutating Controller calls run on the leader only, so
+	// replicas do not repeat Emma API calls or change the same volume at once
+	if c.leaderElection {
+		startLeaderElection(ctx, opts, logger, reconcilers)
+	} else {
+		for _, reconciler := range reconcilers {
+			go reconciler(ctx)
+		}
+	}
+
+	drv.SetEmmaClient(emmaClient)
+	drv.SetControllerService(controllerService)
+	logger.Info("Controller service configured")
+}
+
+// discoverDataCenters logs the datacenters available to the Emma account and publishes
+// them on /configz
+func discoverDataCenters(ctx context.Context, emmaClient *emma.Client, logger *logging.Logger) {
+	logger.Info("Discovering available datacenters")
+	datacenters, err := emmaClient.GetDataCenters(ctx)
+	if err != nil {
+		logger.Warn("Failed to discover datacenters", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ids := make([]string, 0, len(datacenters))
+	summaries := make([]map[string]interface{}, 0, len(datacenters))
+	for _, dc := range datacenters {
+		ids = append(ids, dc.GetId())
+		summary := map[string]interface{}{
+			"id":       dc.GetId(),
+			"name":     dc.GetName(),
+			"location": dc.GetLocationName(),
+			"provider": dc.GetProviderName(),
+		}
+		summaries = append(summaries, summary)
+		logger.Debug("Datacenter available", summary)
+	}
+	logger.Info("Available datacenters discovered", map[string]interface{}{
+		"count": len(datacenters),
+		"ids":   ids,
+	})
+	metrics.SetConfigz("datacenters", summaries)
+}
+
+// checkPermissions verifies the credentials can reach every API area the driver uses.
+// Missing permissions are logged and exported, operations in their area fail later.
+func checkPermissions(ctx context.Context, emmaClient *emma.Client, logger *logging.Logger) {
+	logger.Info("Checking Emma API permissions")
+	permissions := make(map[string]string)
+	failedAreas := []string{}
+	for _, check := range emmaClient.CheckPermissions(ctx) {
+		metrics.SetAPIPermissionReady(check.Area, check.Err == nil)
+		if check.Err != nil {
+			permissions[check.Area] = check.Err.Error()
+			failedAreas = append(failedAreas, check.Area)
+			logger.Error("Emma API permission check failed; operations in this area will fail", check.Err, map[string]interface{}{
+				"area": check.Area,
+			})
+			continue
+		}
+		permissions[check.Area] = "ok"
+	}
+	metrics.SetConfigz("apiPermissions", permissions)
+	if len(failedAreas) > 0 {
+		logger.Warn("Emma API credentials are missing permissions", map[string]interface{}{
+			"failedAreas": failedAreas,
+		})
+	} else {
+		logger.Info("Emma API permission checks passed")
+	}
+}
+
+// startLeaderElection campaigns for the controller Lease, running the reconcilers for as
+// long as this replica leads. Canceling ctx releases the Lease.
+func startLeaderElection(ctx context.Context, opts *options, logger *logging.Logger, reconcilers []func(context.Context)) {
+	c := &opts.controller
+	identity, err := os.Hostname()
+	if err != nil {
+		klog.Fatalf("Failed to determine leader election identity: %v", err)
+	}
+	namespace := c.leaderElectionNamespace
+	if namespace == "" {
+		namespace = podNamespace()
+	}
+
+	client, err := kube.NewClient(opts.kubeconfig)
+	if err != nil {
+		logger.Error("Failed to initialize Kubernetes client for leader election", err)
+		klog.Fatalf("Failed to initialize Kubernetes client for leader election: %v", err)
+	}
+
+	cfg := kube.LeaderElectionConfig{
+		Namespace:     namespace,
+		Name:          c.leaderElectionLeaseName,
+		Identity:      identity,
+		LeaseDuration: c.leaderElectionLeaseDuration,
+		RenewDeadline: c.leaderElectionRenewDeadline,
+		RetryPeriod:   c.leaderElectionRetryPeriod,
+	}
+	metrics.EnableLeaderElection(identity)
+	metrics.SetConfigz("leaderElection", cfg)
+
+	onStartedLeading := func(ctx context.Context) {
+		metrics.SetLeader(true)
+		logger.Info("Became controller leader, starting background reconcilers", map[string]interface{}{"identity": identity})
+		for _, reconciler := range reconcilers {
+			go reconciler(ctx)
+		}
+	}
+	onStoppedLeading := func() {
+		if metrics.IsLeader() {
+			logger.Warn("Lost controller leadership, background reconcilers stopped", map[string]interface{}{"identity": identity})
+		}
+		metrics.SetLeader(false)
+	}
+	if err := kube.RunLeaderElection(ctx, client, cfg, onStartedLeading, onStoppedLeading); err != nil {
+		klog.Fatalf("Failed to start leader election: %v", err)
+	}
+	logger.Info("Leader election started", map[string]interface{}{
+		"lease":    namespace + "/" + c.leaderElectionLeaseName,
+		"identity": identity,
+	})
+}
+
+// podNamespace returns the namespace of the pod from the POD_NAMESPACE environment
+// variable or the service account, defaulting to kube-system
+func podNamespace() string {
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		return namespace
+	}
+	if data, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace"); err == nil {
+		if namespace := strings.TrimSpace(string(data)); namespace != "" {
+			return namespace
+		}
+	}
+	return "kube-system"
+}
+
+// bucketConfig builds the histogram bucket configuration from the command line flags
+func (o *options) bucketConfig() (metrics.BucketConfig, error) {
+	var cfg metrics.BucketConfig
+	var err error
+
+	if cfg.Operation, err = metrics.ParseBuckets(o.operationBuckets); err != nil {
+		return cfg, fmt.Errorf("operation-duration-buckets: %w", err)
+	}
+	if cfg.APIRequest, err = metrics.ParseBuckets(o.apiBuckets); err != nil {
+		return cfg, fmt.Errorf("api-duration-buckets: %w", err)
+	}
+	if cfg.Attach, err = metrics.ParseBuckets(o.attachBuckets); err != nil {
+		return cfg, fmt.Errorf("attach-duration-buckets: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// emmaTLSOptions builds the Emma API TLS options from the command line flags
+func (o *options) emmaTLSOptions() (emma.TLSOptions, error) {
+	var opts emma.TLSOptions
+	var err error
+
+	if opts.MinVersion, err = emma.ParseTLSVersion(o.emmaTLSMinVersion); err != nil {
+		return opts, fmt.Errorf("emma-tls-min-version: %w", err)
+	}
+	if opts.PinnedKeys, err = emma.ParsePinnedKeys(o.emmaTLSPins); err != nil {
+		return opts, fmt.Errorf("emma-tls-pinned-keys: %w", err)
+	}
+	if o.emmaTLSCABundle != "" {
+		if opts.RootCAs, err = emma.LoadCABundle(o.emmaTLSCABundle); err != nil {
+			return opts, fmt.Errorf("emma-tls-ca-bundle: %w", err)
+		}
+	}
+	if o.emmaTLSClientCert != "" || o.emmaTLSClientKey != "" {
+		cert, err := emma.LoadClientCertificate(o.emmaTLSClientCert, o.emmaTLSClientKey)
+		if err != nil {
+			return opts, fmt.Errorf("emma-tls-client-cert: %w", err)
+		}
+		opts.Certificates = []tls.Certificate{cert}
+	}
+	if o.emmaTLSInsecure {
+		// Only ever for self-hosted test endpoints, never the public API
+		if strings.HasPrefix(o.emmaAPIURL, publicEmmaAPIURL) {
+			return opts, fmt.Errorf("emma-tls-insecure-skip-verify is not allowed for %s", publicEmmaAPIURL)
+		}
+		klog.Warningf("Emma API certificate verification is DISABLED for %s, do not use this in production", o.emmaAPIURL)
+		opts.InsecureSkipVerify = true
+	}
+
+	return opts, opts.Validate()
+}
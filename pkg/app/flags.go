@@ -0,0 +1,233 @@
+package app
+
+import (
+	"flag"
+	"strconv"
+	"time"
+
+	"github.com/emma-csi-driver/pkg/driver"
+	"github.com/emma-csi-driver/pkg/emma"
+	"github.com/emma-csi-driver/pkg/kube"
+)
+
+// options holds the command line flags of every mode. Controller and node flags are
+// registered in all modes, so one command line works for any binary.
+type options struct {
+	mode         string
+	endpoint     string
+	logLevel     string
+	jsonLogs     bool
+	metricsAddr  string
+	kubeconfig   string
+	emmaAPIURL   string
+	clientID     string
+	clientSecret string
+
+	shutdownTimeout time.Duration
+	grpcLimits      driver.ServerLimits
+	endpointTLS     driver.EndpointTLS
+
+	operationBuckets string
+	apiBuckets       string
+	attachBuckets    string
+
+	emmaTLSMinVersion string
+	emmaTLSPins       string
+	emmaTLSCABundle   string
+	emmaTLSClientCert string
+	emmaTLSClientKey  string
+	emmaTLSInsecure   bool
+
+	controller controllerOptions
+	node       nodeOptions
+}
+
+// controllerOptions holds the flags of the controller service
+type controllerOptions struct {
+	dataCenterID string
+	kubeClient   bool
+
+	deleteParallelism int
+	deleteAPIQPS      float64
+	deleteAPIBurst    int
+	deleteDetachGrace time.Duration
+
+	emmaSizeUnit string
+
+	defaultVolumeSize string
+	minVolumeSize     string
+	sizeRounding      string
+
+	emmaRegionalURLs     string
+	emmaEndpointCooldown time.Duration
+
+	attachRemediation bool
+	maxVolumeWaiters  int
+	tagVolumes        bool
+	clusterID         string
+
+	emmaAPIQPS   float64
+	emmaAPIBurst int
+
+	emmaAPIMaxRetries   int
+	emmaAPIRetryBackoff time.Duration
+
+	dataCenterCacheTTL time.Duration
+
+	nodeResolutionMode string
+	nodeCacheTTL       time.Duration
+
+	webhookURL    string
+	webhookSecret string
+
+	leaderElection              bool
+	leaderElectionNamespace     string
+	leaderElectionLeaseName     string
+	leaderElectionLeaseDuration time.Duration
+	leaderElectionRenewDeadline time.Duration
+	leaderElectionRetryPeriod   time.Duration
+}
+
+// nodeOptions holds the flags of the node service
+type nodeOptions struct {
+	nodeID               string
+	mkfsLazyInit         bool
+	mkfsNoDiscard        bool
+	formatIonice         string
+	formatNice           int
+	maxConcurrentFormats int
+	unstageFlush         string
+
+	labelNode           bool
+	vmID                string
+	allowedMountOptions string
+	discoverVMID        bool
+
+	udevMode       string
+	busRescan      bool
+	deviceStrategy string
+
+	maxVolumesPerNode int64
+
+	deviceCacheFile string
+	stateFile       string
+
+	mountDirMode    string
+	mountDirSELinux string
+}
+
+// registerFlags registers the flags of every mode on fs
+func registerFlags(fs *flag.FlagSet, defaultMode Mode) *options {
+	o := &options{}
+	fs.StringVar(&o.mode, "mode", string(defaultMode), "Services to run: controller, node or all (controller and node in one process, for local testing)")
+	fs.StringVar(&o.endpoint, "endpoint", "", "CSI endpoint: unix:///path, tcp://host:port (tcp://[::1]:port for IPv6), fd://N or fd:// for systemd socket activation (defaults to "+defaultControllerEndpoint+" for the controller, "+defaultNodeEndpoint+" otherwise)")
+	fs.StringVar(&o.logLevel, "log-level", "info", "Log level (debug, info, warn, error)")
+	fs.BoolVar(&o.jsonLogs, "json-logs", false, "Enable JSON log formatting")
+	fs.StringVar(&o.metricsAddr, "metrics-addr", ":8080", "Metrics server address")
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "Path to kubeconfig file (defaults to in-cluster config)")
+	fs.StringVar(&o.emmaAPIURL, "emma-api-url", "https://api.emma.ms/external", "Emma API base URL")
+	fs.StringVar(&o.clientID, "client-id", "", "Emma API client ID, required by the controller; optional on nodes, which with credentials resolve their topology from their Emma VM instead of EMMA_DATACENTER_ID")
+	fs.StringVar(&o.clientSecret, "client-secret", "", "Emma API client secret")
+	limits := driver.DefaultServerLimits()
+	o.grpcLimits = limits
+	fs.IntVar(&o.grpcLimits.MaxRecvMsgSize, "grpc-max-recv-msg-size", limits.MaxRecvMsgSize, "Largest CSI request in bytes the gRPC server accepts")
+	fs.Func("grpc-max-concurrent-streams", "Maximum concurrent CSI calls on each gRPC connection (0 is unlimited)", func(value string) error {
+		streams, err := strconv.ParseUint(value, 10, 32)
+		o.grpcLimits.MaxConcurrentStreams = uint32(streams)
+		return err
+	})
+	fs.IntVar(&o.grpcLimits.MaxInFlight, "grpc-max-in-flight", limits.MaxInFlight, "Maximum CSI calls handled at once, calls beyond it fail with a retryable Unavailable error (0 is unlimited)")
+	fs.DurationVar(&o.grpcLimits.KeepaliveTime, "grpc-keepalive-time", limits.KeepaliveTime, "How long a gRPC connection is idle before the server pings the client")
+	fs.DurationVar(&o.grpcLimits.KeepaliveTimeout, "grpc-keepalive-timeout", limits.KeepaliveTimeout, "How long the server waits for a keepalive ping ack before closing the connection")
+	fs.DurationVar(&o.grpcLimits.KeepaliveMinTime, "grpc-keepalive-min-time", limits.KeepaliveMinTime, "Shortest interval between client keepalive pings; clients pinging more often are disconnected")
+	fs.BoolVar(&o.grpcLimits.KeepalivePermitWithoutStream, "grpc-keepalive-permit-without-stream", limits.KeepalivePermitWithoutStream, "Allow client keepalive pings on connections without active calls")
+	fs.StringVar(&o.endpointTLS.CertFile, "endpoint-tls-cert", "", "PEM server certificate serving a tcp:// endpoint over TLS, reloaded when it changes (requires --endpoint-tls-key)")
+	fs.StringVar(&o.endpointTLS.KeyFile, "endpoint-tls-key", "", "PEM private key of --endpoint-tls-cert")
+	fs.StringVar(&o.endpointTLS.ClientCAFile, "endpoint-tls-client-ca", "", "PEM CA certificates that must sign client certificates of the tcp:// endpoint, requiring mutual TLS (empty accepts clients without certificates)")
+	fs.DurationVar(&o.shutdownTimeout, "shutdown-timeout", driver.DefaultShutdownTimeout, "How long a shutdown waits for in-flight CSI calls to finish before canceling them; keep it below the pod's termination grace period (0 waits without limit)")
+
+	fs.StringVar(&o.operationBuckets, "operation-duration-buckets", "", "Comma-separated histogram buckets in seconds for CSI operation durations (empty uses defaults)")
+	fs.StringVar(&o.apiBuckets, "api-duration-buckets", "", "Comma-separated histogram buckets in seconds for Emma API request durations (empty uses defaults)")
+	fs.StringVar(&o.attachBuckets, "attach-duration-buckets", "", "Comma-separated histogram buckets in seconds for volume attach/detach durations (empty uses defaults)")
+
+	fs.StringVar(&o.emmaTLSMinVersion, "emma-tls-min-version", "1.2", "Minimum TLS version for Emma API connections (1.2 or 1.3)")
+	fs.StringVar(&o.emmaTLSPins, "emma-tls-pinned-keys", "", "Comma-separated base64 SHA-256 public key pins (sha256/...) one of which must appear in the Emma API certificate chain (empty disables pinning)")
+	fs.StringVar(&o.emmaTLSCABundle, "emma-tls-ca-bundle", "", "PEM file of CA certificates trusted for Emma API connections in addition to the system roots, for endpoints behind a private CA")
+	fs.StringVar(&o.emmaTLSClientCert, "emma-tls-client-cert", "", "PEM client certificate presented to Emma API endpoints requiring mutual TLS (requires --emma-tls-client-key)")
+	fs.StringVar(&o.emmaTLSClientKey, "emma-tls-client-key", "", "PEM private key of --emma-tls-client-cert")
+	fs.BoolVar(&o.emmaTLSInsecure, "emma-tls-insecure-skip-verify", false, "Skip Emma API certificate verification, for test endpoints only; refused for the public Emma API")
+
+	c := &o.controller
+	fs.StringVar(&c.dataCenterID, "datacenter-id", "", "Default datacenter ID of volumes whose StorageClass sets no dataCenterId and that have no topology requirements")
+	fs.BoolVar(&c.kubeClient, "kubernetes-client", false, "Use a Kubernetes client to check node state before attaching volumes")
+
+	fs.IntVar(&c.deleteParallelism, "delete-parallelism", 4, "Maximum number of DeleteVolume calls processed at once, others wait in queue")
+	fs.Float64Var(&c.deleteAPIQPS, "delete-api-qps", 0, "Emma API calls per second budgeted for DeleteVolume (0 disables the budget)")
+	fs.IntVar(&c.deleteAPIBurst, "delete-api-burst", 5, "Burst size of the DeleteVolume Emma API call budget")
+	fs.DurationVar(&c.deleteDetachGrace, "delete-detach-grace", 30*time.Second, "How long DeleteVolume waits for an attached volume to be released by normal unpublish before forcing a detach, without holding a delete-parallelism slot (0 disables)")
+
+	fs.StringVar(&c.emmaSizeUnit, "emma-size-unit", "GiB", "Unit of Emma volume sizes used for byte conversions (GiB or GB)")
+
+	fs.StringVar(&c.defaultVolumeSize, "default-volume-size", "1Gi", "Size of volumes requested without a capacity, unless the StorageClass sets defaultSize")
+	fs.StringVar(&c.minVolumeSize, "min-volume-size", "", "Smallest size new volumes are created with, smaller requests are raised to it (empty disables)")
+	fs.StringVar(&c.sizeRounding, "volume-size-rounding", "round-up", "How requested sizes become the power of 2 sizes Emma accepts: round-up, strict (reject other sizes) or exact (no rounding)")
+
+	fs.StringVar(&c.emmaRegionalURLs, "emma-regional-api-urls", "", "Comma-separated datacenter=URL pairs routing a datacenter's volume operations to a regional Emma API endpoint")
+	fs.DurationVar(&c.emmaEndpointCooldown, "emma-regional-api-cooldown", emma.DefaultEndpointCooldown, "How long a failing regional Emma API endpoint is bypassed in favor of the global endpoint")
+
+	fs.BoolVar(&c.attachRemediation, "attach-failure-remediation", false, "When a volume goes FAILED during attach, detach it, wait for AVAILABLE and retry the attach once before failing")
+
+	fs.IntVar(&c.maxVolumeWaiters, "max-volume-waiters", 0, "Maximum number of concurrent waits for Emma volume state changes; operations beyond it fail with a retryable error (0 is unlimited)")
+
+	fs.BoolVar(&c.tagVolumes, "tag-volumes", false, "Tag new Emma volumes with the reclaimPolicy and ttl StorageClass parameters, their PVC and PV and the cluster ID so external cleanup tooling can expire leftovers")
+	fs.StringVar(&c.clusterID, "cluster-id", "", "ID of this Kubernetes cluster, tagged on new volumes with --tag-volumes so emma-csi-admin delete-orphans only considers this cluster's volumes")
+
+	fs.Float64Var(&c.emmaAPIQPS, "emma-api-qps", 0, "Emma API requests per second allowed for each Emma account (0 is unlimited)")
+	fs.IntVar(&c.emmaAPIBurst, "emma-api-burst", 10, "Burst size of each Emma account's API request rate limit")
+
+	fs.IntVar(&c.emmaAPIMaxRetries, "emma-api-max-retries", 3, "Retries of idempotent Emma API requests rejected with 429 or failing with a gateway error (0 disables retries)")
+	fs.DurationVar(&c.emmaAPIRetryBackoff, "emma-api-retry-backoff", time.Second, "Wait before the first Emma API retry without Retry-After, doubled for each further retry")
+
+	fs.DurationVar(&c.dataCenterCacheTTL, "datacenter-cache-ttl", emma.DefaultDataCenterCacheTTL, "How long Emma datacenter lookups, such as CreateVolume's datacenter validation, are cached (0 disables the cache)")
+
+	fs.StringVar(&c.nodeResolutionMode, "node-resolution-mode", string(driver.NodeResolutionClusters), "How node IDs that are not Emma VM IDs are resolved: clusters (search Emma managed Kubernetes clusters), vms (match VM names), annotation (node VM ID label or annotation only, requires --kubernetes-client; other modes ignore the label) or numeric-only")
+	fs.DurationVar(&c.nodeCacheTTL, "node-cache-ttl", driver.DefaultNodeCacheTTL, "How long node name to Emma VM ID resolutions are cached (0 caches them until the VM ID stops matching)")
+
+	fs.StringVar(&c.webhookURL, "webhook-url", "", "URL that volume lifecycle events (created, deleted, attach failed, expansion completed) are posted to as JSON (empty disables webhooks)")
+	fs.StringVar(&c.webhookSecret, "webhook-secret", "", "Secret used to sign webhook requests with HMAC-SHA256 in the X-Emma-CSI-Signature header (empty sends unsigned requests)")
+
+	fs.BoolVar(&c.leaderElection, "leader-election", false, "Elect a leader among controller replicas through a Lease; only the leader runs background reconcilers and serves mutating Controller calls")
+	fs.StringVar(&c.leaderElectionNamespace, "leader-election-namespace", "", "Namespace of the leader election Lease (defaults to the pod's namespace)")
+	fs.StringVar(&c.leaderElectionLeaseName, "leader-election-lease-name", "emma-csi-controller", "Name of the leader election Lease")
+	fs.DurationVar(&c.leaderElectionLeaseDuration, "leader-election-lease-duration", kube.DefaultLeaseDuration, "How long followers wait before taking over an unrenewed Lease")
+	fs.DurationVar(&c.leaderElectionRenewDeadline, "leader-election-renew-deadline", kube.DefaultRenewDeadline, "How long the leader keeps retrying to renew the Lease before giving up leadership")
+	fs.DurationVar(&c.leaderElectionRetryPeriod, "leader-election-retry-period", kube.DefaultRetryPeriod, "How often replicas try to acquire or renew the Lease")
+
+	n := &o.node
+	fs.StringVar(&n.nodeID, "node-id", "", "Node ID (VM ID in Emma)")
+	fs.BoolVar(&n.mkfsLazyInit, "mkfs-lazy-init", false, "Defer ext4 inode table and journal initialization when formatting")
+	fs.BoolVar(&n.mkfsNoDiscard, "mkfs-no-discard", false, "Skip discarding device blocks when formatting")
+	fs.StringVar(&n.formatIonice, "format-ionice-class", "", "Run mkfs under ionice with this class (1 realtime, 2 best-effort, 3 idle), empty disables")
+	fs.IntVar(&n.formatNice, "format-nice", 0, "Run mkfs under nice with this adjustment, 0 disables")
+	fs.IntVar(&n.maxConcurrentFormats, "max-concurrent-formats", 0, "Maximum number of concurrent mkfs runs, 0 means unlimited; staging waits up to a minute for a free run before failing with Unavailable")
+	fs.StringVar(&n.unstageFlush, "unstage-flush", "none", "Default flush before unmount on unstage: none, sync or fsfreeze (fsfreeze also freezes/thaws xfs)")
+
+	fs.BoolVar(&n.labelNode, "label-node", false, "Label the Kubernetes Node with Emma metadata (emma.ms/vm-id, emma.ms/datacenter, emma.ms/provider) at startup")
+	fs.StringVar(&n.vmID, "vm-id", "", "Emma VM ID of this node, used for node labels (defaults to EMMA_VM_ID environment variable)")
+	fs.StringVar(&n.allowedMountOptions, "allowed-mount-options", "", "Comma-separated unsafe mount options (suid, dev) to allow from volume capabilities; by default they are stripped and nosuid,nodev are added")
+	fs.BoolVar(&n.discoverVMID, "discover-vm-id", false, "Find the node's Emma VM by matching the node name, hostname and interface addresses against the Emma VMs and managed cluster nodes (requires client-id and client-secret), falling back to --vm-id")
+
+	fs.StringVar(&n.udevMode, "udev-mode", "auto", "Run udevadm trigger/settle during device discovery: auto (if udevadm is in PATH), enabled or disabled (sysfs scanning only)")
+	fs.BoolVar(&n.busRescan, "bus-rescan", true, "Rescan SCSI hosts and NVMe controllers while waiting for an attached disk to appear")
+	fs.StringVar(&n.deviceStrategy, "device-strategy", "auto", "How to find disks attached without serial, LUN or provider ID: auto (detect from DMI), generic, virtio, aws-nvme, gcp-pd or azure-lun")
+
+	fs.Int64Var(&n.maxVolumesPerNode, "max-volumes-per-node", 0, "Number of volumes reported as attachable to the node, 0 discovers the instance type's limit from the cloud instance metadata (at most the Emma limit of 16)")
+
+	fs.StringVar(&n.deviceCacheFile, "device-cache-file", "", "File persisting the device of each staged volume, tried before device discovery on restage and expansion (empty disables)")
+	fs.StringVar(&n.stateFile, "state-file", "", "File persisting the staging path and unstage settings of each staged volume, reconciled at startup together with the device cache (empty keeps the state in memory)")
+
+	fs.StringVar(&n.mountDirMode, "mount-dir-mode", "0750", "Octal mode of staging and target directories created by the driver, applied regardless of umask")
+	fs.StringVar(&n.mountDirSELinux, "mount-dir-selinux-context", "", "SELinux context set with chcon on created staging and target directories (empty disables)")
+
+	return o
+}
@@ -0,0 +1,175 @@
+package app
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/emma-csi-driver/pkg/driver"
+	"github.com/emma-csi-driver/pkg/emma"
+	"github.com/emma-csi-driver/pkg/kube"
+	"github.com/emma-csi-driver/pkg/logging"
+	"github.com/emma-csi-driver/pkg/mount"
+)
+
+// prepareNode resolves the node's Emma VM and labels its Kubernetes Node before the
+// driver starts. emmaClient is nil without Emma API credentials.
+func prepareNode(opts *options, emmaClient *emma.Client, logger *logging.Logger) {
+	n := &opts.node
+	if n.discoverVMID {
+		if emmaClient == nil {
+			klog.Fatal("discover-vm-id requires client-id and client-secret")
+		}
+		discoverNodeVMID(n, emmaClient, logger)
+	}
+
+	if n.labelNode {
+		labelKubernetesNode(opts, logger)
+	}
+}
+
+// setupNode configures the node service from the flags and registers it with the driver
+func setupNode(drv *driver.Driver, opts *options, emmaClient *emma.Client, logger *logging.Logger) {
+	n := &opts.node
+
+	nodeService := driver.NewNodeService(drv)
+	dirMode, err := mount.ParseDirMode(n.mountDirMode)
+	if err != nil {
+		klog.Fatalf("Invalid mount-dir-mode: %v", err)
+	}
+	udev, err := mount.ParseUdevMode(n.udevMode)
+	if err != nil {
+		klog.Fatalf("Invalid udev-mode: %v", err)
+	}
+	nodeService.SetMounterOptions(mount.MounterOptions{
+		Format: mount.FormatOptions{
+			LazyInit:      n.mkfsLazyInit,
+			NoDiscard:     n.mkfsNoDiscard,
+			IoniceClass:   n.formatIonice,
+			Nice:          n.formatNice,
+			MaxConcurrent: n.maxConcurrentFormats,
+		},
+		Dirs:        mount.DirOptions{Mode: dirMode, SELinuxContext: n.mountDirSELinux},
+		DisableUdev: !udev,
+	})
+	if n.allowedMountOptions != "" {
+		nodeService.SetAllowedMountOptions(strings.Split(n.allowedMountOptions, ","))
+	}
+	if err := nodeService.SetUnstageFlushMode(n.unstageFlush); err != nil {
+		klog.Fatalf("Invalid unstage-flush: %v", err)
+	}
+	mount.SetBusRescan(n.busRescan)
+	if _, err := mount.ConfigureDeviceStrategy(n.deviceStrategy); err != nil {
+		klog.Fatalf("Invalid device-strategy: %v", err)
+	}
+	// The device cache is loaded first, so the state reconcile drops stale devices too
+	if n.deviceCacheFile != "" {
+		if err := nodeService.SetDeviceCacheFile(n.deviceCacheFile); err != nil {
+			klog.Fatalf("Invalid device-cache-file: %v", err)
+		}
+	}
+	if n.stateFile != "" {
+		if err := nodeService.SetStateFile(n.stateFile); err != nil {
+			klog.Fatalf("Invalid state-file: %v", err)
+		}
+	}
+	if n.maxVolumesPerNode > 0 {
+		nodeService.SetMaxVolumesPerNode(n.maxVolumesPerNode)
+	} else {
+		nodeService.DiscoverMaxVolumesPerNode(context.Background())
+	}
+	if emmaClient != nil {
+		configureEmmaTopology(n, nodeService, emmaClient, logger)
+	}
+
+	drv.SetNodeService(nodeService)
+	logger.Info("Node service configured")
+}
+
+// labelKubernetesNode labels the Node object with Emma metadata. Failures are logged but not fatal.
+func labelKubernetesNode(opts *options, logger *logging.Logger) {
+	n := &opts.node
+	client, err := kube.NewClient(opts.kubeconfig)
+	if err != nil {
+		logger.Error("Failed to create Kubernetes client for node labeling", err)
+		return
+	}
+
+	labels := kube.EmmaNodeLabels(n.resolvedVMID(), os.Getenv("EMMA_DATACENTER_ID"))
+	if len(labels) == 0 {
+		logger.Warn("No Emma metadata available to label node", map[string]interface{}{"nodeId": n.nodeID})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := kube.LabelNode(ctx, client, n.nodeID, labels); err != nil {
+		logger.Error("Failed to label node", err)
+		return
+	}
+
+	logger.Info("Labeled node with Emma metadata", map[string]interface{}{
+		"nodeId": n.nodeID,
+		"labels": labels,
+	})
+}
+
+// resolvedVMID returns the Emma VM ID of this node from --vm-id, EMMA_VM_ID or a numeric
+// node ID, or "" when unknown
+func (n *nodeOptions) resolvedVMID() string {
+	id := n.vmID
+	if id == "" {
+		id = os.Getenv("EMMA_VM_ID")
+	}
+	if id == "" {
+		// Node IDs that are numeric are VM IDs
+		if _, err := strconv.ParseInt(n.nodeID, 10, 32); err == nil {
+			id = n.nodeID
+		}
+	}
+	return id
+}
+
+// configureEmmaTopology has the node service resolve its topology from its Emma VM.
+// Without a VM ID the topology falls back to EMMA_DATACENTER_ID.
+func configureEmmaTopology(n *nodeOptions, nodeService *driver.NodeService, emmaClient *emma.Client, logger *logging.Logger) {
+	id, err := strconv.ParseInt(n.resolvedVMID(), 10, 32)
+	if err != nil {
+		logger.Warn("Emma VM ID of the node unknown, topology falls back to EMMA_DATACENTER_ID", map[string]interface{}{"nodeId": n.nodeID})
+		return
+	}
+
+	nodeService.SetEmmaClient(emmaClient, int32(id))
+	logger.Info("Node topology resolved from Emma VM", map[string]interface{}{"vmId": id})
+}
+
+// discoverNodeVMID finds the node's Emma VM and uses its ID as --vm-id. When discovery
+// fails the VM ID from --vm-id, EMMA_VM_ID or a numeric node ID is kept.
+func discoverNodeVMID(n *nodeOptions, emmaClient *emma.Client, logger *logging.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	id, err := driver.DiscoverVMID(ctx, emmaClient, driver.LocalNodeIdentity(n.nodeID))
+	if err != nil {
+		logger.Warn("Failed to discover the node's Emma VM, using the configured VM ID", map[string]interface{}{
+			"error":        err.Error(),
+			"fallbackVmId": n.resolvedVMID(),
+		})
+		return
+	}
+
+	discovered := strconv.FormatInt(int64(id), 10)
+	if configured := n.resolvedVMID(); configured != "" && configured != discovered {
+		logger.Warn("Discovered Emma VM differs from the configured VM ID, using the discovered one", map[string]interface{}{
+			"configuredVmId": configured,
+			"discoveredVmId": discovered,
+		})
+	}
+	n.vmID = discovered
+	logger.Info("Discovered the node's Emma VM", map[string]interface{}{"nodeId": n.nodeID, "vmId": id})
+}
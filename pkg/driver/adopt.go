@@ -0,0 +1,187 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/emma-csi-driver/pkg/emma"
+	"github.com/emma-csi-driver/pkg/kube"
+	"github.com/emma-csi-driver/pkg/logging"
+)
+
+const (
+	// paramExistingVolumeID and paramExistingVolumeName make CreateVolume import an existing
+	// Emma volume, by ID or by exact name, instead of creating one
+	paramExistingVolumeID   = "existingVolumeId"
+	paramExistingVolumeName = "existingVolumeName"
+	// paramFormatExistingVolume lets the node format an adopted volume that is still blank
+	paramFormatExistingVolume = "formatExistingVolume"
+
+	// volumeContextAdopted marks volumes imported from an existing Emma volume
+	volumeContextAdopted = "adopted"
+)
+
+// adoptionTarget is an existing Emma volume a CreateVolume request imports, by ID or name
+type adoptionTarget struct {
+	id   string
+	name string
+}
+
+// set reports whether the request imports a volume
+func (t adoptionTarget) set() bool {
+	return t.id != "" || t.name != ""
+}
+
+func (t adoptionTarget) String() string {
+	if t.id != "" {
+		return t.id
+	}
+	return "named " + t.name
+}
+
+// adoptionTarget returns the existing volume a CreateVolume request imports. Annotations
+// of the PVC, available with a Kubernetes client and the provisioner's
+// --extra-create-metadata, take precedence over the StorageClass parameters so a single
+// StorageClass can import many volumes.
+func (s *ControllerService) adoptionTarget(ctx context.Context, params map[string]string) (adoptionTarget, error) {
+	target := adoptionTarget{id: params[paramExistingVolumeID], name: params[paramExistingVolumeName]}
+
+	if s.kubeClient != nil && params[paramPVCName] != "" && params[paramPVCNamespace] != "" {
+		pvc, err := s.kubeClient.CoreV1().PersistentVolumeClaims(params[paramPVCNamespace]).Get(ctx, params[paramPVCName], metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			logging.Klog(ctx).V(4).Infof("PVC %s/%s not found, not checking it for an existing volume", params[paramPVCNamespace], params[paramPVCName])
+		case err != nil:
+			// Creating a new volume when the claim asked to import one would hide its data
+			return adoptionTarget{}, status.Errorf(codes.Unavailable, "failed to read PVC %s/%s annotations: %v", params[paramPVCNamespace], params[paramPVCName], err)
+		default:
+			id, name := pvc.Annotations[kube.AnnotationExistingVolumeID], pvc.Annotations[kube.AnnotationExistingVolumeName]
+			if id != "" || name != "" {
+				target = adoptionTarget{id: id, name: name}
+			}
+		}
+	}
+
+	if target.id != "" && target.name != "" {
+		return adoptionTarget{}, status.Errorf(codes.InvalidArgument, "set only one of %s and %s", paramExistingVolumeID, paramExistingVolumeName)
+	}
+	return target, nil
+}
+
+// adoptVolume returns an existing Emma volume as the result of a CreateVolume request, after
+// checking it satisfies the requested capacity, type and datacenter and is not attached.
+// Nothing is created or changed in Emma.
+func (s *ControllerService) adoptVolume(ctx context.Context, req *csi.CreateVolumeRequest, target adoptionTarget, fsType string, nodeParams map[string]string) (*csi.CreateVolumeResponse, error) {
+	volume, err := s.findAdoptedVolume(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	params := req.GetParameters()
+	volumeType := params[paramType]
+	if volumeType == "" {
+		volumeType = volume.Type
+	}
+	dataCenters := adoptableDataCenters(params, req.GetAccessibilityRequirements(), volume.DataCenterID)
+	if err := existingVolumeConflict(volume, req.GetCapacityRange(), volumeType, dataCenters, s.sizeUnit); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "cannot adopt volume: %v", err)
+	}
+	if volume.Status != "AVAILABLE" {
+		return nil, status.Errorf(codes.FailedPrecondition, "cannot adopt volume %d in status %s: detach it so it is AVAILABLE first", volume.ID, volume.Status)
+	}
+
+	expectFormatted := true
+	if value, ok := params[paramFormatExistingVolume]; ok {
+		format, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q: must be true or false", paramFormatExistingVolume, value)
+		}
+		expectFormatted = !format
+	}
+
+	logging.Klog(ctx).Infof("Adopting existing Emma volume %d (%s) for %s", volume.ID, volume.Name, req.GetName())
+	resp := s.createVolumeResponse(volume, volume.DataCenterID, volume.DataCenterID, fsType, nodeParams, s.sizeUnit.ToBytes(volume.SizeGB))
+	// The volume holds data: the node refuses to format it unless blank volumes were allowed
+	resp.Volume.VolumeContext[volumeContextExpectFormatted] = strconv.FormatBool(expectFormatted)
+	resp.Volume.VolumeContext[volumeContextAdopted] = "true"
+	return resp, nil
+}
+
+// findAdoptedVolume looks up the volume to adopt by ID or exact name
+func (s *ControllerService) findAdoptedVolume(ctx context.Context, target adoptionTarget) (*emma.VolumeResponse, error) {
+	if target.id != "" {
+		volumeID, err := strconv.ParseInt(target.id, 10, 32)
+		if err != nil || volumeID <= 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q: must be an Emma volume ID", paramExistingVolumeID, target.id)
+		}
+		volume, err := s.api(ctx).GetVolume(ctx, int32(volumeID))
+		if errors.Is(err, emma.ErrVolumeNotFound) {
+			return nil, status.Errorf(codes.InvalidArgument, "existing volume %d not found", volumeID)
+		}
+		if err != nil {
+			return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "failed to get existing volume: %v", err)
+		}
+		return volume, nil
+	}
+
+	volumes, err := s.api(ctx).ListVolumesFiltered(ctx, emma.ListVolumesOptions{NamePrefix: target.name})
+	if err != nil {
+		return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "failed to look up existing volume: %v", err)
+	}
+	var found *emma.VolumeResponse
+	for _, volume := range volumes {
+		if volume.Name != target.name {
+			continue
+		}
+		if found != nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "several Emma volumes are named %s (%d and %d), adopt one by %s", target.name, found.ID, volume.ID, paramExistingVolumeID)
+		}
+		found = volume
+	}
+	if found == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "no existing volume named %s", target.name)
+	}
+	return found, nil
+}
+
+// adoptableDataCenters returns the datacenters an adopted volume may be in: the
+// dataCenterId or allowedDataCenters parameters and the requisite topology, or the volume's
+// own datacenter when nothing restricts it
+func adoptableDataCenters(params map[string]string, requirements *csi.TopologyRequirement, volumeDataCenter string) []string {
+	allowed := splitDataCenters(params[paramAllowedDataCenters])
+	if dc := params[paramDataCenterID]; dc != "" {
+		allowed = []string{dc}
+	}
+
+	var requisite []string
+	for _, topology := range requirements.GetRequisite() {
+		if dc, ok := topology.GetSegments()[TopologyKeyDataCenter]; ok {
+			requisite = append(requisite, dc)
+		}
+	}
+
+	switch {
+	case len(allowed) == 0 && len(requisite) == 0:
+		return []string{volumeDataCenter}
+	case len(allowed) == 0:
+		return requisite
+	case len(requisite) == 0:
+		return allowed
+	}
+	var both []string
+	for _, dc := range allowed {
+		for _, r := range requisite {
+			if dc == r {
+				both = append(both, dc)
+			}
+		}
+	}
+	return both
+}
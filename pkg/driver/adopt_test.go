@@ -0,0 +1,112 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/emma-csi-driver/internal/fakeemma"
+	"github.com/emma-csi-driver/pkg/emma"
+	"github.com/emma-csi-driver/pkg/kube"
+)
+
+// TestControllerAdoptVolume tests importing existing Emma volumes instead of creating new ones
+func TestControllerAdoptVolume(t *testing.T) {
+	attachedTo := int32(7)
+	fakeAPI := fakeemma.New()
+	fakeAPI.AddVolume(emma.VolumeResponse{ID: 11, Name: "legacy-db", SizeGB: 600, Type: "ssd", DataCenterID: "dc-1", Status: "AVAILABLE"})
+	fakeAPI.AddVolume(emma.VolumeResponse{ID: 12, Name: "legacy-web", SizeGB: 10, Type: "hdd", DataCenterID: "dc-2", Status: "ACTIVE", AttachedToID: &attachedTo})
+	fakeAPI.AddVolume(emma.VolumeResponse{ID: 13, Name: "dup", SizeGB: 10, Type: "ssd", DataCenterID: "dc-1", Status: "AVAILABLE"})
+	fakeAPI.AddVolume(emma.VolumeResponse{ID: 14, Name: "dup", SizeGB: 10, Type: "ssd", DataCenterID: "dc-1", Status: "AVAILABLE"})
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, fakeAPI)
+
+	tests := []struct {
+		name       string
+		params     map[string]string
+		capRange   *csi.CapacityRange
+		expectedID string
+		formatted  string
+		expected   codes.Code
+	}{
+		{name: "by ID", params: map[string]string{paramExistingVolumeID: "11"}, capRange: &csi.CapacityRange{RequiredBytes: 600 * gib}, expectedID: "11", formatted: "true"},
+		{name: "by name", params: map[string]string{paramExistingVolumeName: "legacy-db", paramType: "SSD", paramDataCenterID: "dc-1"}, expectedID: "11", formatted: "true"},
+		{name: "blank allowed", params: map[string]string{paramExistingVolumeID: "11", paramFormatExistingVolume: "true"}, expectedID: "11", formatted: "false"},
+		{name: "invalid format option", params: map[string]string{paramExistingVolumeID: "11", paramFormatExistingVolume: "maybe"}, expected: codes.InvalidArgument},
+		{name: "too small", params: map[string]string{paramExistingVolumeID: "11"}, capRange: &csi.CapacityRange{RequiredBytes: 1024 * gib}, expected: codes.InvalidArgument},
+		{name: "over the limit", params: map[string]string{paramExistingVolumeID: "11"}, capRange: &csi.CapacityRange{LimitBytes: 512 * gib}, expected: codes.InvalidArgument},
+		{name: "other type", params: map[string]string{paramExistingVolumeID: "11", paramType: "hdd"}, expected: codes.InvalidArgument},
+		{name: "other datacenter", params: map[string]string{paramExistingVolumeID: "11", paramAllowedDataCenters: "dc-2,dc-3"}, expected: codes.InvalidArgument},
+		{name: "attached", params: map[string]string{paramExistingVolumeID: "12"}, expected: codes.FailedPrecondition},
+		{name: "ambiguous name", params: map[string]string{paramExistingVolumeName: "dup"}, expected: codes.FailedPrecondition},
+		{name: "missing", params: map[string]string{paramExistingVolumeID: "99"}, expected: codes.InvalidArgument},
+		{name: "invalid ID", params: map[string]string{paramExistingVolumeID: "vol-1"}, expected: codes.InvalidArgument},
+		{name: "ID and name", params: map[string]string{paramExistingVolumeID: "11", paramExistingVolumeName: "legacy-db"}, expected: codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := service.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:               "pvc-" + tt.name,
+				CapacityRange:      tt.capRange,
+				VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+				Parameters:         tt.params,
+			})
+			if status.Code(err) != tt.expected {
+				t.Fatalf("expected %v, got %v", tt.expected, err)
+			}
+			if tt.expected != codes.OK {
+				return
+			}
+			volume := resp.GetVolume()
+			if volume.GetVolumeId() != tt.expectedID || volume.GetCapacityBytes() != 600*gib {
+				t.Errorf("expected volume %s with 600GiB, got %s with %d bytes", tt.expectedID, volume.GetVolumeId(), volume.GetCapacityBytes())
+			}
+			if volume.GetVolumeContext()[volumeContextExpectFormatted] != tt.formatted || volume.GetVolumeContext()[volumeContextAdopted] != "true" {
+				t.Errorf("expected an adopted volume with %s=%s, got %v", volumeContextExpectFormatted, tt.formatted, volume.GetVolumeContext())
+			}
+		})
+	}
+
+	if len(fakeAPI.Calls()) != 0 {
+		t.Errorf("expected adoption to change nothing in Emma, got %v", fakeAPI.Calls())
+	}
+}
+
+// TestAdoptionTargetFromPVC tests that PVC annotations select the volume to adopt
+func TestAdoptionTargetFromPVC(t *testing.T) {
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, fakeemma.New())
+	service.SetKubeClient(fake.NewSimpleClientset(
+		&v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "db", Annotations: map[string]string{kube.AnnotationExistingVolumeName: "legacy-db"}}},
+		&v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "plain", Namespace: "db"}},
+	))
+
+	tests := []struct {
+		name     string
+		params   map[string]string
+		expected adoptionTarget
+	}{
+		{name: "annotation", params: map[string]string{paramPVCNamespace: "db", paramPVCName: "data"}, expected: adoptionTarget{name: "legacy-db"}},
+		{name: "annotation wins", params: map[string]string{paramPVCNamespace: "db", paramPVCName: "data", paramExistingVolumeID: "11"}, expected: adoptionTarget{name: "legacy-db"}},
+		{name: "StorageClass parameter", params: map[string]string{paramPVCNamespace: "db", paramPVCName: "plain", paramExistingVolumeID: "11"}, expected: adoptionTarget{id: "11"}},
+		{name: "missing PVC", params: map[string]string{paramPVCNamespace: "db", paramPVCName: "gone"}},
+		{name: "no metadata", params: map[string]string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := service.adoptionTarget(context.Background(), tt.params)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if target != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, target)
+			}
+		})
+	}
+}
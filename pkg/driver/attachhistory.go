@@ -0,0 +1,89 @@
+package driver
+
+import (
+	"sync"
+	"time"
+)
+
+// attachHistory remembers the node each volume was last attached to so that
+// re-attachment to the same node can be preferred, and when the current
+// attachment was established. It is kept in memory only and is best effort:
+// after a controller restart, volumes attached earlier have no last node or
+// attach time until the controller attaches them again.
+type attachHistory struct {
+	mu         sync.RWMutex
+	lastNode   map[string]string
+	attachedAt map[string]time.Time
+	now        func() time.Time
+}
+
+// newAttachHistory creates an empty attach history
+func newAttachHistory() *attachHistory {
+	return &attachHistory{
+		lastNode:   make(map[string]string),
+		attachedAt: make(map[string]time.Time),
+		now:        time.Now,
+	}
+}
+
+// Record stores nodeID as the last node of volumeID and reports whether the
+// volume was previously attached to a different node. The attach time is
+// reset unless the volume is still attached to the same node.
+func (h *attachHistory) Record(volumeID, nodeID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	previous, ok := h.lastNode[volumeID]
+	h.lastNode[volumeID] = nodeID
+	if _, attached := h.attachedAt[volumeID]; !attached || previous != nodeID {
+		h.attachedAt[volumeID] = h.now()
+	}
+	return ok && previous != nodeID
+}
+
+// Detached clears the attach time of volumeID, keeping its last node
+func (h *attachHistory) Detached(volumeID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.attachedAt, volumeID)
+}
+
+// Last returns the node volumeID was last attached to
+func (h *attachHistory) Last(volumeID string) (string, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	nodeID, ok := h.lastNode[volumeID]
+	return nodeID, ok
+}
+
+// AttachedAt returns when the current attachment of volumeID was established
+func (h *attachHistory) AttachedAt(volumeID string) (time.Time, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	t, ok := h.attachedAt[volumeID]
+	return t, ok
+}
+
+// AttachTimes returns a copy of the attach times of all attached volumes
+func (h *attachHistory) AttachTimes() map[string]time.Time {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	times := make(map[string]time.Time, len(h.attachedAt))
+	for volumeID, t := range h.attachedAt {
+		times[volumeID] = t
+	}
+	return times
+}
+
+// Forget drops the history of a deleted volume
+func (h *attachHistory) Forget(volumeID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.lastNode, volumeID)
+	delete(h.attachedAt, volumeID)
+}
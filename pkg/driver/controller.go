@@ -2,18 +2,31 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	emmasdk "github.com/emma-community/emma-go-sdk"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
 
 	"github.com/emma-csi-driver/pkg/emma"
+	"github.com/emma-csi-driver/pkg/kube"
 	"github.com/emma-csi-driver/pkg/logging"
 	"github.com/emma-csi-driver/pkg/metrics"
+	"github.com/emma-csi-driver/pkg/mount"
+	"github.com/emma-csi-driver/pkg/notify"
 )
 
 const (
@@ -22,6 +35,58 @@ const (
 	paramDataCenterID = "dataCenterId"
 	paramFSType       = "fsType"
 
+	// paramAllowedDataCenters lists the datacenters, comma-separated, a volume may be created in
+	// when dataCenterId is not set; the one matching the selected node's topology is used
+	paramAllowedDataCenters = "allowedDataCenters"
+
+	// paramFallbackDataCenterIDs lists datacenters, in order, to try when the primary one cannot provision
+	paramFallbackDataCenterIDs = "fallbackDataCenterIds"
+
+	// volumeContextFallbackFrom records the datacenter that failed when a fallback datacenter was used
+	volumeContextFallbackFrom = "fallbackFromDataCenterId"
+
+	// paramUnstageFlush selects how the node flushes the filesystem before unmounting (none, sync, fsfreeze)
+	paramUnstageFlush = "unstageFlush"
+
+	// paramForceReformat lets the node format a device holding a different filesystem than fsType,
+	// destroying its data; without it staging such a volume fails
+	paramForceReformat = "forceReformat"
+
+	// paramDefaultSize is the size of volumes requested without a capacity, e.g. "10Gi"
+	paramDefaultSize = "defaultSize"
+
+	// paramEncrypted makes the node encrypt the volume with LUKS, using the passphrase from the
+	// node-stage secret
+	paramEncrypted = "encrypted"
+
+	// secretEncryptionPassphrase is the node-stage (and node-expand) secret key holding the
+	// LUKS passphrase of encrypted volumes
+	secretEncryptionPassphrase = "encryptionPassphrase"
+
+	// volumeContextLastAttachedNode is the node a volume was last attached to, a hint for re-attachment
+	volumeContextLastAttachedNode = "lastAttachedNode"
+
+	// volumeContextExpectFormatted tells the node whether the volume already holds a filesystem
+	// ("true", never format it) or is new ("false", format if blank)
+	volumeContextExpectFormatted = "expectFormatted"
+
+	// volumeContextAttachedAt is when the current attachment was established (RFC 3339), as tracked by the controller
+	volumeContextAttachedAt = "attachedAt"
+
+	// Volume context keys for performance characteristics reported by Emma
+	volumeContextIOPS       = "iops"
+	volumeContextThroughput = "throughputMBps"
+
+	// volumeContextProviderVolumeID is the volume's identifier at the underlying cloud provider,
+	// kept on the PV so the node can find the device when the publish context lacks it
+	volumeContextProviderVolumeID = "providerVolumeId"
+
+	// Volume context keys recording the requested and provisioned capacity in bytes, and a note
+	// when the volume was provisioned larger than requested
+	volumeContextRequestedBytes   = "requestedBytes"
+	volumeContextProvisionedBytes = "provisionedBytes"
+	volumeContextSizeNote         = "sizeNote"
+
 	// Default values
 	defaultVolumeType = "ssd"
 	defaultFSType     = "ext4"
@@ -31,79 +96,196 @@ const (
 	volumeAttachTimeout = 5 * time.Minute
 	volumeDetachTimeout = 5 * time.Minute
 	volumeResizeTimeout = 5 * time.Minute
-
-	// Size constants
-	bytesPerGB = 1024 * 1024 * 1024
+	// volumeSettleTimeout bounds waiting for a BUSY or PROCESSING volume before changing it
+	volumeSettleTimeout = 3 * time.Minute
+
+	// defaultDeleteDetachGrace is how long DeleteVolume waits for an attached volume
+	// to be released by the normal unpublish before forcing a detach
+	defaultDeleteDetachGrace = 30 * time.Second
+	// deleteGracePollInterval is the delay between volume polls during the delete grace wait
+	deleteGracePollInterval = 5 * time.Second
 )
 
 // ControllerService implements the CSI Controller service
 type ControllerService struct {
 	driver     *Driver
-	emmaClient *emma.Client
+	emmaClient EmmaVolumeAPI
 	logger     *logging.Logger
+
+	// Cache of Kubernetes node name to Emma VM ID resolutions of the controller's own client
+	nodeCache *nodeVMCache
+
+	// Caches of the clients resolved from CSI secrets, whose accounts see other VMs
+	nodeCachesMutex sync.Mutex
+	nodeCaches      map[EmmaVolumeAPI]*nodeVMCache
+
+	// Optional Kubernetes client used to check node state
+	kubeClient kubernetes.Interface
+
+	// Last attached node per volume, exposed as a re-attachment hint
+	attachHistory *attachHistory
+
+	// deleteDetachGrace bounds the wait for normal unpublish in DeleteVolume
+	deleteDetachGrace time.Duration
+
+	// deleteExecutor bounds concurrent deletions and their Emma API calls
+	deleteExecutor *deleteExecutor
+
+	// sizeUnit is the unit of Emma volume sizes, used for all byte conversions
+	sizeUnit SizeUnit
+
+	// defaultVolumeSize is the size in bytes of volumes requested without a capacity range
+	defaultVolumeSize int64
+
+	// minVolumeSize is the smallest size in bytes new volumes are created with, 0 for none
+	minVolumeSize int64
+
+	// sizeRounding turns requested sizes into sizes Emma accepts
+	sizeRounding SizeRoundingPolicy
+
+	// defaultDataCenter is used for volumes with neither a dataCenterId parameter nor topology requirements
+	defaultDataCenter string
+
+	// attachFailureRemediation detaches and retries an attach once when Emma reports the volume FAILED
+	attachFailureRemediation bool
+
+	// volumeLocks rejects concurrent operations on the same volume
+	volumeLocks *volumeLocks
+
+	// tagVolumes writes reclaim policy, expiry, owner and cluster tags on new volumes
+	tagVolumes bool
+
+	// clusterID is tagged on new volumes when tagging is enabled
+	clusterID string
+
+	// nodeResolution controls how node IDs that are not Emma VM IDs are resolved
+	nodeResolution NodeResolutionMode
+
+	// notifier receives volume lifecycle events, such as a webhook
+	notifier notify.Notifier
+
+	// clientResolver returns the Emma client of credentials in CSI secrets, nil rejects them
+	clientResolver EmmaClientResolver
 }
 
 // NewControllerService creates a new controller service
-func NewControllerService(driver *Driver, emmaClient *emma.Client) *ControllerService {
+func NewControllerService(driver *Driver, emmaClient EmmaVolumeAPI) *ControllerService {
 	return &ControllerService{
 		driver:     driver,
 		emmaClient: emmaClient,
 		logger:     logging.NewLogger("controller-service"),
+		nodeCache:  newNodeVMCache(DefaultNodeCacheTTL),
+		nodeCaches: make(map[EmmaVolumeAPI]*nodeVMCache),
+
+		attachHistory:     newAttachHistory(),
+		deleteDetachGrace: defaultDeleteDetachGrace,
+		deleteExecutor:    newDeleteExecutor(defaultDeleteParallelism, 0, 0),
+		sizeUnit:          SizeUnitGiB,
+		defaultVolumeSize: defaultVolumeSizeBytes,
+		sizeRounding:      SizeRoundingRoundUp,
+		volumeLocks:       newVolumeLocks(),
+		nodeResolution:    NodeResolutionClusters,
+		notifier:          notify.Nop(),
 	}
 }
 
+// SetKubeClient sets the optional Kubernetes client
+func (s *ControllerService) SetKubeClient(client kubernetes.Interface) {
+	s.kubeClient = client
+}
+
+// SetDeleteDetachGracePeriod sets how long DeleteVolume waits for normal unpublish, 0 forces detach immediately
+func (s *ControllerService) SetDeleteDetachGracePeriod(grace time.Duration) {
+	s.deleteDetachGrace = grace
+}
+
+// SetDeleteLimits sets the DeleteVolume parallelism and the Emma API call budget (qps <= 0 disables the budget)
+func (s *ControllerService) SetDeleteLimits(parallelism int, qps float32, burst int) {
+	s.deleteExecutor = newDeleteExecutor(parallelism, qps, burst)
+}
+
+// SetVolumeTagging enables tagging new volumes from the reclaimPolicy and ttl StorageClass parameters
+func (s *ControllerService) SetVolumeTagging(enabled bool) {
+	s.tagVolumes = enabled
+}
+
+// SetClusterID sets the cluster ID tagged on new volumes with volume tagging enabled, so
+// orphan cleanup only considers the volumes of this cluster
+func (s *ControllerService) SetClusterID(clusterID string) {
+	s.clusterID = clusterID
+}
+
+// SetAttachFailureRemediation enables detaching and retrying an attach once when the volume goes FAILED
+func (s *ControllerService) SetAttachFailureRemediation(enabled bool) {
+	s.attachFailureRemediation = enabled
+}
+
+// SetDefaultDataCenter sets the datacenter of volumes with neither a dataCenterId parameter
+// nor topology requirements
+func (s *ControllerService) SetDefaultDataCenter(dataCenterID string) {
+	s.defaultDataCenter = dataCenterID
+}
+
+// SetSizeUnit sets the unit Emma uses for volume sizes
+func (s *ControllerService) SetSizeUnit(unit SizeUnit) {
+	s.sizeUnit = unit
+}
+
 // CreateVolume creates a new volume
-func (s *ControllerService) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
-	timer := metrics.NewOperationTimer("CreateVolume")
-	opLog := s.logger.WithOperation("CreateVolume").WithField("volumeName", req.GetName())
+func (s *ControllerService) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (_ *csi.CreateVolumeResponse, err error) {
+	opLog := s.logger.WithContext(ctx).WithOperation("CreateVolume").WithField("volumeName", req.GetName())
+	phases := newOperationPhases("CreateVolume", "validate")
+	defer func() { err = phases.finish(opLog, err) }()
 
 	opLog.Info("CreateVolume request received")
-	klog.V(4).Infof("CreateVolume called with request: %+v", req)
+	logging.Klog(ctx).V(4).Infof("CreateVolume called with request: %+v", stripSecrets(req))
 
 	// Validate request
 	if req.GetName() == "" {
-		timer.ObserveError()
 		opLog.Error("Volume name is required", nil)
 		return nil, status.Error(codes.InvalidArgument, "volume name is required")
 	}
 
+	// Volumes are keyed by name until Emma assigns an ID
+	unlock, err := s.volumeLocks.lock(req.GetName(), "CreateVolume")
+	if err != nil {
+		opLog.Error("Operation already in progress", err)
+		return nil, err
+	}
+	defer unlock()
+
 	if req.GetVolumeCapabilities() == nil || len(req.GetVolumeCapabilities()) == 0 {
-		timer.ObserveError()
 		opLog.Error("Volume capabilities are required", nil)
 		return nil, status.Error(codes.InvalidArgument, "volume capabilities are required")
 	}
 
 	// Validate volume capabilities
 	if err := s.validateVolumeCapabilities(req.GetVolumeCapabilities()); err != nil {
-		timer.ObserveError()
 		opLog.Error("Invalid volume capabilities", err)
 		return nil, status.Errorf(codes.InvalidArgument, "invalid volume capabilities: %v", err)
 	}
 
-	// Parse capacity (required range in bytes)
-	capacityBytes := req.GetCapacityRange().GetRequiredBytes()
-	if capacityBytes == 0 {
-		capacityBytes = req.GetCapacityRange().GetLimitBytes()
-	}
-	if capacityBytes == 0 {
-		timer.ObserveError()
-		opLog.Error("Volume capacity is required", nil)
-		return nil, status.Error(codes.InvalidArgument, "volume capacity is required")
+	// Refuse content sources rather than silently provisioning an empty volume
+	if err := validateContentSource(req.GetVolumeContentSource()); err != nil {
+		opLog.Error("Unsupported volume content source", err)
+		return nil, err
 	}
 
-	// Convert to GB (round up)
-	requestedGB := int32((capacityBytes + bytesPerGB - 1) / bytesPerGB)
-	if requestedGB < 1 {
-		requestedGB = 1
+	// StorageClasses may use their own Emma credentials
+	ctx, err = s.withCredentials(ctx, req.GetSecrets())
+	if err != nil {
+		opLog.Error("Invalid Emma credentials in secret", err)
+		return nil, err
 	}
 
-	// Emma requires disk sizes to be powers of 2 (1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024, 2048)
-	// Round up to the nearest power of 2
-	sizeGB := roundUpToPowerOfTwo(requestedGB)
-
-	if sizeGB != requestedGB {
-		opLog.WithField("requestedGB", requestedGB).WithField("actualGB", sizeGB).Info("Rounded volume size to nearest power of 2")
-		klog.Infof("Volume size rounded: %dGB → %dGB (Emma requires powers of 2)", requestedGB, sizeGB)
+	// Parse capacity (required range in bytes), applying the default and minimum sizes
+	capacityBytes, err := s.requestedCapacity(req.GetCapacityRange(), req.GetParameters())
+	if err != nil {
+		opLog.Error("Invalid volume capacity", err)
+		return nil, err
+	}
+	if req.GetCapacityRange().GetRequiredBytes() == 0 && req.GetCapacityRange().GetLimitBytes() == 0 {
+		opLog.WithField("capacityBytes", capacityBytes).Info("No capacity requested, using the default volume size")
 	}
 
 	// Parse StorageClass parameters
@@ -113,16 +295,6 @@ func (s *ControllerService) CreateVolume(ctx context.Context, req *csi.CreateVol
 		volumeType = t
 	}
 
-	dataCenterID := ""
-	if dc, ok := params[paramDataCenterID]; ok && dc != "" {
-		dataCenterID = dc
-	}
-	if dataCenterID == "" {
-		timer.ObserveError()
-		opLog.Error("DataCenter ID parameter is required", nil)
-		return nil, status.Error(codes.InvalidArgument, "dataCenterId parameter is required")
-	}
-
 	fsType := defaultFSType
 	if fs, ok := params[paramFSType]; ok && fs != "" {
 		fsType = fs
@@ -130,17 +302,123 @@ func (s *ControllerService) CreateVolume(ctx context.Context, req *csi.CreateVol
 
 	// Validate filesystem type
 	if fsType != "ext4" && fsType != "xfs" {
-		timer.ObserveError()
 		opLog.WithField("fsType", fsType).Error("Unsupported filesystem type", nil)
 		return nil, status.Errorf(codes.InvalidArgument, "unsupported filesystem type: %s (supported: ext4, xfs)", fsType)
 	}
 
+	// Parameters the node applies when staging, passed on in the volume context
+	nodeParams := make(map[string]string)
+	if unstageFlush := params[paramUnstageFlush]; unstageFlush != "" {
+		if err := mount.ValidateFlushMode(unstageFlush); err != nil {
+			opLog.WithField(paramUnstageFlush, unstageFlush).Error("Invalid unstage flush mode", err)
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s parameter: %v", paramUnstageFlush, err)
+		}
+		nodeParams[paramUnstageFlush] = unstageFlush
+	}
+	if forceReformat := params[paramForceReformat]; forceReformat != "" {
+		if _, err := strconv.ParseBool(forceReformat); err != nil {
+			opLog.WithField(paramForceReformat, forceReformat).Error("Invalid force reformat parameter", err)
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s parameter %q: must be true or false", paramForceReformat, forceReformat)
+		}
+		nodeParams[paramForceReformat] = forceReformat
+	}
+	if encrypted := params[paramEncrypted]; encrypted != "" {
+		if _, err := strconv.ParseBool(encrypted); err != nil {
+			opLog.WithField(paramEncrypted, encrypted).Error("Invalid encrypted parameter", err)
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s parameter %q: must be true or false", paramEncrypted, encrypted)
+		}
+		nodeParams[paramEncrypted] = encrypted
+	}
+
+	// Import an existing Emma volume instead of creating one
+	target, err := s.adoptionTarget(ctx, params)
+	if err != nil {
+		opLog.Error("Invalid existing volume reference", err)
+		return nil, err
+	}
+	if target.set() {
+		resp, err := s.adoptVolume(ctx, req, target, fsType, nodeParams)
+		if err != nil {
+			opLog.WithField("existingVolume", target.String()).Error("Failed to adopt existing volume", err)
+			return nil, err
+		}
+		opLog.WithVolumeID(resp.GetVolume().GetVolumeId()).Complete("Existing volume adopted")
+		return resp, nil
+	}
+
+	// Convert to Emma size units (round up)
+	requestedGB := s.sizeUnit.FromBytes(capacityBytes)
+
+	// Emma requires disk sizes to be powers of 2 (1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024, 2048),
+	// applied according to the size rounding policy
+	sizeGB, err := s.provisionedSize(capacityBytes, req.GetCapacityRange())
+	if err != nil {
+		opLog.WithField("requestedGB", requestedGB).WithField("sizeRounding", string(s.sizeRounding)).Error("Volume size not provisionable", err)
+		return nil, err
+	}
+
+	if sizeGB != requestedGB {
+		opLog.WithField("requestedGB", requestedGB).WithField("actualGB", sizeGB).Info("Rounded volume size to nearest power of 2")
+		logging.Klog(ctx).Infof("Volume size rounded: %dGB → %dGB (Emma requires powers of 2)", requestedGB, sizeGB)
+	}
+
+	// The datacenter comes from the StorageClass or, when unset, from the topology
+	// of the node selected by the scheduler (WaitForFirstConsumer), limited to the
+	// allowed datacenters, or else the controller's default datacenter
+	dataCenterID, err := selectDataCenter(params[paramDataCenterID], splitDataCenters(params[paramAllowedDataCenters]), s.defaultDataCenter, req.GetAccessibilityRequirements())
+	if err != nil {
+		opLog.Error("Failed to select data center", err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var tags []emma.VolumeTag
+	if params[paramReclaimPolicy] != "" || params[paramTTL] != "" {
+		if !s.tagVolumes {
+			opLog.Error("Volume tagging is disabled", nil)
+			return nil, status.Errorf(codes.InvalidArgument, "%s and %s parameters require the controller to run with --tag-volumes", paramReclaimPolicy, paramTTL)
+		}
+		if tags, err = volumeTags(params, time.Now()); err != nil {
+			opLog.Error("Invalid volume tag parameters", err)
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	if s.tagVolumes {
+		tags = append(tags, kubernetesMetadataTags(params)...)
+		if s.clusterID != "" {
+			tags = append(tags, emma.VolumeTag{Key: TagClusterID, Value: s.clusterID})
+		}
+	}
+
 	// Validate data center
-	if err := s.emmaClient.ValidateDataCenter(ctx, dataCenterID); err != nil {
-		timer.ObserveError()
+	if err := s.api(ctx).ValidateDataCenter(ctx, dataCenterID); err != nil {
 		opLog.WithField("dataCenterId", dataCenterID).Error("Invalid data center", err)
-		return nil, status.Errorf(codes.InvalidArgument, "invalid data center: %v", err)
+		code := emmaErrorCode(err, codes.InvalidArgument)
+		if code == codes.NotFound {
+			// NotFound from CreateVolume means a missing content source, not a bad parameter
+			code = codes.InvalidArgument
+		}
+		return nil, status.Errorf(code, "invalid data center: %v", err)
+	}
+
+	// Reject type and size combinations Emma does not offer before creating anything
+	if err := s.validateVolumeOffering(ctx, dataCenterID, volumeType, sizeGB); err != nil {
+		opLog.WithField("dataCenterId", dataCenterID).
+			WithField("volumeType", volumeType).
+			WithField("sizeGB", sizeGB).
+			Error("Volume type and size not offered", err)
+		return nil, err
+	}
+
+	// Reserve the wait for AVAILABLE before creating anything, so a busy controller rejects
+	// the request instead of leaving a volume it cannot wait for
+	ctx, releaseWaiter, err := s.api(ctx).ReserveWaiter(ctx)
+	if err != nil {
+		opLog.Error("Volume waiter limit reached", err)
+		return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "controller busy, retry later: %v", err)
 	}
+	defer releaseWaiter()
+
+	candidates := candidateDataCenters(dataCenterID, params[paramFallbackDataCenterIDs], req.GetAccessibilityRequirements())
 
 	opLog.WithField("sizeGB", sizeGB).
 		WithField("volumeType", volumeType).
@@ -148,320 +426,656 @@ func (s *ControllerService) CreateVolume(ctx context.Context, req *csi.CreateVol
 		WithField("fsType", fsType).
 		Info("Creating volume via Emma API")
 
-	// Create volume via Emma API
+	// A retried CreateVolume finds the volume it created before a crash or timeout
+	phases.Begin("lookupExisting")
+	existing, err := s.findVolumeByName(ctx, req.GetName())
+	if err != nil {
+		opLog.Error("Failed to look up existing volume", err)
+		return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "failed to look up existing volume: %v", err)
+	}
+	if existing != nil {
+		existingLog := opLog.WithVolumeID(strconv.Itoa(int(existing.ID)))
+		if err := existingVolumeConflict(existing, req.GetCapacityRange(), volumeType, candidates, s.sizeUnit); err != nil {
+			existingLog.Error("Volume with the same name exists with different parameters", err)
+			return nil, status.Errorf(codes.AlreadyExists, "volume %s already exists: %v", req.GetName(), err)
+		}
+		if existing.Status != "AVAILABLE" {
+			existingLog.WithField("status", existing.Status).Info("Volume already exists, waiting for AVAILABLE status")
+			phases.Begin("waitAvailable")
+			if err := s.api(ctx).WaitForVolumeStatus(ctx, existing.ID, "AVAILABLE", volumeCreateTimeout); err != nil {
+				existingLog.Error("Existing volume did not become available", err)
+				return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "volume creation timeout: %v", err)
+			}
+		}
+		existingLog.Complete("Volume already exists")
+		return s.createVolumeResponse(existing, dataCenterID, dataCenterID, fsType, nodeParams, capacityBytes), nil
+	}
+
+	// Create volume via Emma API, falling back to the next allowed datacenter when one is out
+	// of capacity
+	phases.Begin("create")
 	startTime := time.Now()
-	klog.Infof("Calling Emma API to create volume: name=%s, size=%dGB, type=%s, datacenter=%s",
-		req.GetName(), sizeGB, volumeType, dataCenterID)
+	var volume *emma.VolumeResponse
+	createdIn := dataCenterID
+	for i, dc := range candidates {
+		if i > 0 {
+			if verr := s.api(ctx).ValidateDataCenter(ctx, dc); verr != nil {
+				opLog.WithField("dataCenterId", dc).WithField("error", verr.Error()).Warn("Skipping invalid fallback data center")
+				continue
+			}
+			opLog.WithField("dataCenterId", dc).Info("Retrying volume in fallback data center")
+			metrics.RecordDataCenterFallback(dataCenterID, dc)
+		}
 
-	volume, err := s.emmaClient.CreateVolume(ctx, req.GetName(), sizeGB, volumeType, dataCenterID)
+		logging.Klog(ctx).Infof("Calling Emma API to create volume: name=%s, size=%dGB, type=%s, datacenter=%s",
+			req.GetName(), sizeGB, volumeType, dc)
+
+		volume, err = s.api(ctx).CreateVolume(ctx, req.GetName(), sizeGB, volumeType, dc, tags)
+		createdIn = dc
+		if err == nil || !errors.Is(err, emma.ErrDataCenterUnavailable) {
+			break
+		}
+		opLog.WithField("dataCenterId", dc).WithField("error", err.Error()).Warn("Data center could not provision volume")
+	}
 	if err != nil {
-		timer.ObserveError()
 		opLog.Error("Failed to create volume via Emma API", err)
-		return nil, status.Errorf(codes.Internal, "failed to create volume: %v", err)
+		return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "failed to create volume: %v", err)
 	}
 
 	createDuration := time.Since(startTime)
-	klog.Infof("Emma API returned volume ID %d (took %v), waiting for AVAILABLE status", volume.ID, createDuration)
+	logging.Klog(ctx).Infof("Emma API returned volume ID %d (took %v), waiting for AVAILABLE status", volume.ID, createDuration)
 	opLog.WithVolumeID(strconv.Itoa(int(volume.ID))).Info("Volume created, waiting for AVAILABLE status")
 
 	// Wait for volume to become AVAILABLE
+	phases.Begin("waitAvailable")
 	waitStart := time.Now()
-	if err := s.emmaClient.WaitForVolumeStatus(ctx, volume.ID, "AVAILABLE", volumeCreateTimeout); err != nil {
+	if err := s.api(ctx).WaitForVolumeStatus(ctx, volume.ID, "AVAILABLE", volumeCreateTimeout); err != nil {
 		// Try to clean up the volume
-		_ = s.emmaClient.DeleteVolume(ctx, volume.ID)
-		timer.ObserveError()
+		_ = s.api(ctx).DeleteVolume(ctx, volume.ID)
 		opLog.WithVolumeID(strconv.Itoa(int(volume.ID))).Error("Volume creation timeout", err)
-		return nil, status.Errorf(codes.Internal, "volume creation timeout: %v", err)
+		return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "volume creation timeout: %v", err)
 	}
 
 	waitDuration := time.Since(waitStart)
 	totalDuration := time.Since(startTime)
-	klog.Infof("Volume %d is AVAILABLE (wait: %v, total: %v)", volume.ID, waitDuration, totalDuration)
+	logging.Klog(ctx).Infof("Volume %d is AVAILABLE (wait: %v, total: %v)", volume.ID, waitDuration, totalDuration)
 
-	timer.ObserveSuccess()
 	opLog.WithVolumeID(strconv.Itoa(int(volume.ID))).Complete("Volume created successfully")
+	s.notify(notify.Event{
+		Type:          notify.EventVolumeCreated,
+		VolumeID:      strconv.Itoa(int(volume.ID)),
+		VolumeName:    req.GetName(),
+		DataCenterID:  createdIn,
+		CapacityBytes: s.sizeUnit.ToBytes(volume.SizeGB),
+	})
+
+	response := s.createVolumeResponse(volume, dataCenterID, createdIn, fsType, nodeParams, capacityBytes)
+	metrics.RecordVolumeSizeOverhead(response.GetVolume().GetAccessibleTopology()[0].GetSegments()[TopologyKeyDataCenter],
+		response.GetVolume().GetCapacityBytes()-capacityBytes)
+	return response, nil
+}
 
-	// Build CSI volume response
-	csiVolume := &csi.Volume{
-		VolumeId:      strconv.Itoa(int(volume.ID)),
-		CapacityBytes: int64(volume.SizeGB) * bytesPerGB,
-		VolumeContext: map[string]string{
-			paramType:         volume.Type,
-			paramDataCenterID: volume.DataCenterID,
-			paramFSType:       fsType,
-		},
+// createVolumeResponse builds the CreateVolume response for an available volume. createdIn
+// is the datacenter the volume was requested in, used when Emma does not report one.
+// nodeParams are the StorageClass parameters the node applies, copied to the volume context.
+// requestedBytes is the capacity the volume was requested with.
+func (s *ControllerService) createVolumeResponse(volume *emma.VolumeResponse, dataCenterID, createdIn, fsType string, nodeParams map[string]string, requestedBytes int64) *csi.CreateVolumeResponse {
+	volumeContext := buildVolumeContext(volume)
+	s.addSizeContext(volumeContext, requestedBytes, s.sizeUnit.ToBytes(volume.SizeGB))
+	volumeContext[paramFSType] = fsType
+	// Emma volumes are always provisioned blank
+	volumeContext[volumeContextExpectFormatted] = "false"
+	for key, value := range nodeParams {
+		volumeContext[key] = value
+	}
+	if volume.DataCenterID != "" && volume.DataCenterID != dataCenterID {
+		volumeContext[volumeContextFallbackFrom] = dataCenterID
+	}
+
+	if volume.DataCenterID != "" {
+		createdIn = volume.DataCenterID
 	}
 
 	return &csi.CreateVolumeResponse{
-		Volume: csiVolume,
-	}, nil
+		Volume: &csi.Volume{
+			VolumeId:      strconv.Itoa(int(volume.ID)),
+			CapacityBytes: s.sizeUnit.ToBytes(volume.SizeGB),
+			VolumeContext: volumeContext,
+			AccessibleTopology: []*csi.Topology{
+				{Segments: map[string]string{TopologyKeyDataCenter: createdIn}},
+			},
+		},
+	}
+}
+
+// findVolumeByName returns the Emma volume with the given name, or nil if there is none
+func (s *ControllerService) findVolumeByName(ctx context.Context, name string) (*emma.VolumeResponse, error) {
+	volumes, err := s.api(ctx).ListVolumesFiltered(ctx, emma.ListVolumesOptions{NamePrefix: name})
+	if err != nil {
+		return nil, err
+	}
+
+	var found *emma.VolumeResponse
+	for _, volume := range volumes {
+		if volume.Name != name {
+			continue
+		}
+		if found != nil {
+			logging.Klog(ctx).Warningf("Multiple Emma volumes named %s (%d and %d), using %d", name, found.ID, volume.ID, found.ID)
+			continue
+		}
+		found = volume
+	}
+	return found, nil
+}
+
+// existingVolumeConflict reports why an existing volume with the requested name does not
+// satisfy a CreateVolume request, or nil if it can be returned as the result
+func existingVolumeConflict(volume *emma.VolumeResponse, capRange *csi.CapacityRange, volumeType string, dataCenters []string, unit SizeUnit) error {
+	capacity := unit.ToBytes(volume.SizeGB)
+	if capacity < capRange.GetRequiredBytes() {
+		return fmt.Errorf("existing volume %d has %d bytes, less than the required %d", volume.ID, capacity, capRange.GetRequiredBytes())
+	}
+	if limit := capRange.GetLimitBytes(); limit > 0 && capacity > limit {
+		return fmt.Errorf("existing volume %d has %d bytes, more than the limit %d", volume.ID, capacity, limit)
+	}
+	if volume.Type != "" && !strings.EqualFold(volume.Type, volumeType) {
+		return fmt.Errorf("existing volume %d has type %s, requested %s", volume.ID, volume.Type, volumeType)
+	}
+	if volume.DataCenterID != "" {
+		for _, dc := range dataCenters {
+			if dc == volume.DataCenterID {
+				return nil
+			}
+		}
+		return fmt.Errorf("existing volume %d is in datacenter %s, requested %s", volume.ID, volume.DataCenterID, strings.Join(dataCenters, ", "))
+	}
+	return nil
 }
 
 // DeleteVolume deletes a volume
 func (s *ControllerService) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
-	timer := metrics.NewOperationTimer("DeleteVolume")
-	opLog := s.logger.WithOperation("DeleteVolume").WithVolumeID(req.GetVolumeId())
+	opLog := s.logger.WithContext(ctx).WithOperation("DeleteVolume").WithVolumeID(req.GetVolumeId())
 
 	opLog.Info("DeleteVolume request received")
-	klog.V(4).Infof("DeleteVolume called with request: %+v", req)
+	logging.Klog(ctx).V(4).Infof("DeleteVolume called with request: %+v", stripSecrets(req))
 
 	// Validate request
 	if req.GetVolumeId() == "" {
-		timer.ObserveError()
 		opLog.Error("Volume ID is required", nil)
 		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
 	}
 
-	// Parse volume ID
-	volumeID, err := strconv.ParseInt(req.GetVolumeId(), 10, 32)
+	// An ID that is not an Emma volume ID names no volume of this driver, so like a
+	// missing volume there is nothing to delete
+	volumeID, err := parseVolumeID(req.GetVolumeId())
 	if err != nil {
-		timer.ObserveError()
-		opLog.Error("Invalid volume ID", err)
-		return nil, status.Errorf(codes.InvalidArgument, "invalid volume ID: %v", err)
+		opLog.WithField("error", err.Error()).Warn("Volume ID is not an Emma volume ID, nothing to delete")
+		return &csi.DeleteVolumeResponse{}, nil
 	}
 
+	// StorageClasses may use their own Emma credentials
+	ctx, err = s.withCredentials(ctx, req.GetSecrets())
+	if err != nil {
+		opLog.Error("Invalid Emma credentials in secret", err)
+		return nil, err
+	}
+
+	unlock, err := s.volumeLocks.lock(req.GetVolumeId(), "DeleteVolume")
+	if err != nil {
+		opLog.Error("Operation already in progress", err)
+		return nil, err
+	}
+	defer func() { unlock() }()
+
+	// Queue behind other deletions so bursts do not trip Emma API rate limits
+	release, err := s.deleteExecutor.acquire(ctx)
+	if err != nil {
+		opLog.Error("Delete queue is busy", err)
+		return nil, status.Errorf(codes.Unavailable, "delete queue is busy: %v", err)
+	}
+	defer func() { release() }()
+
 	opLog.Info("Deleting volume")
 
 	// Check if volume exists
-	volume, err := s.emmaClient.GetVolume(ctx, int32(volumeID))
+	if err := s.deleteExecutor.waitBudget(ctx); err != nil {
+		return nil, status.Errorf(codes.Unavailable, "%v", err)
+	}
+	volume, err := s.api(ctx).GetVolume(ctx, int32(volumeID))
 	if err != nil {
 		// If volume doesn't exist, consider it already deleted
-		if status.Code(err) == codes.NotFound {
-			timer.ObserveSuccess()
+		if errors.Is(err, emma.ErrVolumeNotFound) {
 			opLog.Info("Volume not found, considering it already deleted")
 			return &csi.DeleteVolumeResponse{}, nil
 		}
-		timer.ObserveError()
 		opLog.Error("Failed to get volume", err)
-		return nil, status.Errorf(codes.Internal, "failed to get volume: %v", err)
+		return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "failed to get volume: %v", err)
+	}
+
+	// Refuse before detaching, so a protected volume stays in use
+	if volume, err = s.deletableVolume(ctx, volume); err != nil {
+		opLog.Error("Volume cannot be deleted", err)
+		return nil, err
+	}
+
+	// A pod may still be terminating, give the normal unpublish a bounded chance to
+	// release the attachment before forcing a detach that races kubelet's unstage
+	if volume.AttachedToID != nil && s.deleteDetachGrace > 0 {
+		opLog.WithField("vmId", *volume.AttachedToID).
+			WithField("gracePeriod", s.deleteDetachGrace.String()).
+			Info("Volume is still attached, waiting for normal unpublish")
+
+		// Let the unpublish take the volume, and other deletions the worker, while waiting
+		unlock()
+		unlock = func() {}
+		release()
+		release = func() {}
+		if err := s.waitForUnpublish(ctx, int32(volumeID)); err != nil {
+			if errors.Is(err, emma.ErrVolumeNotFound) {
+				opLog.Info("Volume not found, considering it already deleted")
+				return &csi.DeleteVolumeResponse{}, nil
+			}
+			if ctx.Err() != nil {
+				return nil, status.Errorf(codes.DeadlineExceeded, "volume %d is still attached: %v", volumeID, ctx.Err())
+			}
+			logging.Klog(ctx).V(4).Infof("Volume %d not released by normal unpublish: %v", volumeID, err)
+		}
+		relock, err := s.volumeLocks.lock(req.GetVolumeId(), "DeleteVolume")
+		if err != nil {
+			opLog.Error("Operation already in progress", err)
+			return nil, err
+		}
+		unlock = relock
+		reacquired, err := s.deleteExecutor.acquire(ctx)
+		if err != nil {
+			opLog.Error("Delete queue is busy", err)
+			return nil, status.Errorf(codes.Unavailable, "delete queue is busy: %v", err)
+		}
+		release = reacquired
+
+		if err := s.deleteExecutor.waitBudget(ctx); err != nil {
+			return nil, status.Errorf(codes.Unavailable, "%v", err)
+		}
+		volume, err = s.api(ctx).GetVolume(ctx, int32(volumeID))
+		if err != nil {
+			// Another DeleteVolume may have finished while the lock was released
+			if errors.Is(err, emma.ErrVolumeNotFound) {
+				opLog.Info("Volume not found, considering it already deleted")
+				return &csi.DeleteVolumeResponse{}, nil
+			}
+			opLog.Error("Failed to get volume", err)
+			return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "failed to get volume: %v", err)
+		}
+
+		// The volume may have been changed or tagged protected while unlocked
+		if volume, err = s.deletableVolume(ctx, volume); err != nil {
+			opLog.Error("Volume cannot be deleted", err)
+			return nil, err
+		}
 	}
 
 	// Ensure volume is detached
 	if volume.AttachedToID != nil {
 		opLog.WithField("vmId", *volume.AttachedToID).Info("Volume is attached, detaching first")
 
-		// Detach volume
-		if err := s.emmaClient.DetachVolume(ctx, *volume.AttachedToID, int32(volumeID)); err != nil {
-			timer.ObserveError()
+		// Detach volume, reserving the wait for the detachment first
+		if err := s.deleteExecutor.waitBudget(ctx); err != nil {
+			return nil, status.Errorf(codes.Unavailable, "%v", err)
+		}
+		ctx, releaseWaiter, err := s.api(ctx).ReserveWaiter(ctx)
+		if err != nil {
+			opLog.Error("Volume waiter limit reached", err)
+			return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "controller busy, retry later: %v", err)
+		}
+		defer releaseWaiter()
+		if err := s.api(ctx).DetachVolume(ctx, *volume.AttachedToID, int32(volumeID)); err != nil {
 			opLog.Error("Failed to detach volume before deletion", err)
-			return nil, status.Errorf(codes.Internal, "failed to detach volume before deletion: %v", err)
+			return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "failed to detach volume before deletion: %v", err)
 		}
 
 		// Wait for detachment
-		if err := s.emmaClient.WaitForVolumeDetachment(ctx, int32(volumeID), volumeDetachTimeout); err != nil {
-			timer.ObserveError()
+		if err := s.api(ctx).WaitForVolumeDetachment(ctx, int32(volumeID), volumeDetachTimeout); err != nil {
 			opLog.Error("Volume detachment timeout", err)
-			return nil, status.Errorf(codes.Internal, "volume detachment timeout: %v", err)
+			return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "volume detachment timeout: %v", err)
 		}
 	}
 
 	// Delete volume via Emma API
-	if err := s.emmaClient.DeleteVolume(ctx, int32(volumeID)); err != nil {
-		timer.ObserveError()
+	if err := s.deleteExecutor.waitBudget(ctx); err != nil {
+		return nil, status.Errorf(codes.Unavailable, "%v", err)
+	}
+	if err := s.api(ctx).DeleteVolume(ctx, int32(volumeID)); err != nil {
 		opLog.Error("Failed to delete volume via Emma API", err)
-		return nil, status.Errorf(codes.Internal, "failed to delete volume: %v", err)
+		return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "failed to delete volume: %v", err)
 	}
 
-	timer.ObserveSuccess()
+	s.attachHistory.Forget(req.GetVolumeId())
 	opLog.Complete("Volume deleted successfully")
+	s.notify(notify.Event{
+		Type:         notify.EventVolumeDeleted,
+		VolumeID:     req.GetVolumeId(),
+		VolumeName:   volume.Name,
+		DataCenterID: volume.DataCenterID,
+	})
 
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
+// deletableVolume waits for a volume to leave transient states and refuses a protected volume
+func (s *ControllerService) deletableVolume(ctx context.Context, volume *emma.VolumeResponse) (*emma.VolumeResponse, error) {
+	volume, err := s.settleVolume(ctx, volume)
+	if err != nil {
+		return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "cannot delete volume: %v", err)
+	}
+	if err := checkNotProtected(volume); err != nil {
+		return nil, err
+	}
+	return volume, nil
+}
+
+// waitForUnpublish polls an attached volume for up to the delete grace period until the
+// normal unpublish detaches it. Each poll counts against the delete API call budget.
+func (s *ControllerService) waitForUnpublish(ctx context.Context, volumeID int32) error {
+	deadline := time.Now().Add(s.deleteDetachGrace)
+	for {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return fmt.Errorf("volume %d still attached after %v", volumeID, s.deleteDetachGrace)
+		}
+		if wait > deleteGracePollInterval {
+			wait = deleteGracePollInterval
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+
+		if err := s.deleteExecutor.waitBudget(ctx); err != nil {
+			return err
+		}
+		volume, err := s.api(ctx).GetVolume(ctx, volumeID)
+		if err != nil {
+			return err
+		}
+		if volume.AttachedToID == nil {
+			return nil
+		}
+	}
+}
+
 // ControllerPublishVolume attaches a volume to a node
-func (s *ControllerService) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
-	timer := metrics.NewOperationTimer("ControllerPublishVolume")
+func (s *ControllerService) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (_ *csi.ControllerPublishVolumeResponse, err error) {
 	attachTimer := time.Now()
-	opLog := s.logger.WithOperation("ControllerPublishVolume").
+	opLog := s.logger.WithContext(ctx).WithOperation("ControllerPublishVolume").
 		WithVolumeID(req.GetVolumeId()).
 		WithNodeID(req.GetNodeId())
+	phases := newOperationPhases("ControllerPublishVolume", "validate")
+	defer func() { err = phases.finish(opLog, err) }()
 
 	opLog.Info("ControllerPublishVolume request received")
-	klog.V(4).Infof("ControllerPublishVolume called with request: %+v", req)
+	logging.Klog(ctx).V(4).Infof("ControllerPublishVolume called with request: %+v", stripSecrets(req))
 
 	// Validate request
 	if req.GetVolumeId() == "" {
-		timer.ObserveError()
 		opLog.Error("Volume ID is required", nil)
 		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
 	}
 
 	if req.GetNodeId() == "" {
-		timer.ObserveError()
 		opLog.Error("Node ID is required", nil)
 		return nil, status.Error(codes.InvalidArgument, "node ID is required")
 	}
 
 	if req.GetVolumeCapability() == nil {
-		timer.ObserveError()
 		opLog.Error("Volume capability is required", nil)
 		return nil, status.Error(codes.InvalidArgument, "volume capability is required")
 	}
 
 	// Validate volume capability
 	if err := s.validateVolumeCapabilities([]*csi.VolumeCapability{req.GetVolumeCapability()}); err != nil {
-		timer.ObserveError()
 		opLog.Error("Invalid volume capability", err)
 		return nil, status.Errorf(codes.InvalidArgument, "invalid volume capability: %v", err)
 	}
 
 	// Parse volume ID and node ID (VM ID)
-	volumeID, err := strconv.ParseInt(req.GetVolumeId(), 10, 32)
+	volumeID, err := parseVolumeID(req.GetVolumeId())
 	if err != nil {
-		timer.ObserveError()
 		opLog.Error("Invalid volume ID", err)
-		return nil, status.Errorf(codes.InvalidArgument, "invalid volume ID: %v", err)
+		return nil, err
+	}
+
+	// StorageClasses may use their own Emma credentials
+	ctx, err = s.withCredentials(ctx, req.GetSecrets())
+	if err != nil {
+		opLog.Error("Invalid Emma credentials in secret", err)
+		return nil, err
+	}
+
+	unlock, err := s.volumeLocks.lock(req.GetVolumeId(), "ControllerPublishVolume")
+	if err != nil {
+		opLog.Error("Operation already in progress", err)
+		return nil, err
 	}
+	defer unlock()
 
 	// Resolve node ID to VM ID (handles both integer VM IDs and node names)
+	phases.Begin("resolveNode")
 	vmID, err := s.resolveNodeIDToVMID(ctx, req.GetNodeId())
 	if err != nil {
-		timer.ObserveError()
 		opLog.WithField("nodeId", req.GetNodeId()).Error("Failed to resolve node ID to VM ID", err)
-		return nil, status.Errorf(codes.InvalidArgument, "failed to resolve node ID: %v", err)
+		return nil, status.Errorf(emmaErrorCode(err, codes.InvalidArgument), "failed to resolve node ID: %v", err)
 	}
 
 	opLog.WithField("vmId", vmID).Info("Attaching volume to node")
+	phases.Begin("getVolume")
 
 	// Check if volume is already attached to this node
-	volume, err := s.emmaClient.GetVolume(ctx, int32(volumeID))
+	volume, err := s.api(ctx).GetVolume(ctx, int32(volumeID))
 	if err != nil {
-		timer.ObserveError()
 		opLog.Error("Failed to get volume", err)
-		return nil, status.Errorf(codes.Internal, "failed to get volume: %v", err)
+		return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "failed to get volume: %v", err)
+	}
+
+	if volume, err = s.settleVolume(ctx, volume); err != nil {
+		opLog.Error("Volume did not leave transient state", err)
+		return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "cannot attach volume: %v", err)
 	}
 
 	if volume.AttachedToID != nil {
 		if *volume.AttachedToID == int32(vmID) {
-			timer.ObserveSuccess()
+			s.recordAttachNode(ctx, req.GetVolumeId(), req.GetNodeId())
 			opLog.Info("Volume is already attached to this node")
-			return &csi.ControllerPublishVolumeResponse{
-				PublishContext: map[string]string{
-					"devicePath": fmt.Sprintf("/dev/disk/by-id/virtio-%d", volumeID),
-				},
-			}, nil
+			return &csi.ControllerPublishVolumeResponse{PublishContext: publishContext(volume, s.sizeUnit)}, nil
 		}
-		timer.ObserveError()
 		opLog.WithField("attachedToVmId", *volume.AttachedToID).Error("Volume is already attached to another node", nil)
 		return nil, status.Errorf(codes.FailedPrecondition, "volume %d is already attached to another node", volumeID)
 	}
 
+	// Fail fast if the node is going away so the workload reschedules quickly
+	if err := s.checkNodeNotDeleting(ctx, req.GetNodeId()); err != nil {
+		opLog.Error("Refusing to attach volume to node being deleted", err)
+		return nil, err
+	}
+
+	// Reserve the wait for the attachment before attaching, so a busy controller rejects the
+	// request instead of attaching a volume it cannot wait for
+	ctx, releaseWaiter, err := s.api(ctx).ReserveWaiter(ctx)
+	if err != nil {
+		opLog.Error("Volume waiter limit reached", err)
+		return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "controller busy, retry later: %v", err)
+	}
+	defer releaseWaiter()
+
 	// Attach volume to VM via Emma API
+	phases.Begin("attach")
 	opLog.Info("Initiating volume attach via Emma API")
-	if err := s.emmaClient.AttachVolume(ctx, int32(vmID), int32(volumeID)); err != nil {
-		timer.ObserveError()
+	err = s.api(ctx).AttachVolume(ctx, int32(vmID), int32(volumeID))
+	if errors.Is(err, emma.ErrVMNotFound) {
+		// The node may have been recreated with the same name but a new VM ID
+		opLog.WithField("vmId", vmID).Warn("VM not found during attach, re-resolving node")
+		newVMID, resolveErr := s.reresolveNodeID(ctx, req.GetNodeId(), vmID)
+		if resolveErr != nil {
+			opLog.Error("Failed to re-resolve node ID after VM not found", resolveErr)
+			return nil, status.Errorf(codes.NotFound, "VM %d for node %s not found and re-resolution failed: %v", vmID, req.GetNodeId(), resolveErr)
+		}
+		vmID = newVMID
+		opLog.WithField("vmId", vmID).Info("Retrying volume attach with re-resolved VM ID")
+		err = s.api(ctx).AttachVolume(ctx, int32(vmID), int32(volumeID))
+	}
+	if err != nil {
 		opLog.Error("Failed to attach volume via Emma API", err)
-		return nil, status.Errorf(codes.Internal, "failed to attach volume: %v", err)
+		s.notifyAttachFailed(req, err)
+		return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "failed to attach volume: %v", err)
 	}
 
 	// Wait for attachment to complete
+	phases.Begin("waitAttached")
 	opLog.Info("Waiting for volume attachment to complete")
-	if err := s.emmaClient.WaitForVolumeAttachment(ctx, int32(volumeID), int32(vmID), volumeAttachTimeout); err != nil {
-		timer.ObserveError()
+	err = s.api(ctx).WaitForVolumeAttachment(ctx, int32(volumeID), int32(vmID), volumeAttachTimeout)
+	if errors.Is(err, emma.ErrVolumeFailed) && s.attachFailureRemediation {
+		phases.Begin("remediate")
+		opLog.Warn("Volume failed during attach, detaching and retrying once")
+		if err = s.remediateFailedAttach(ctx, vmID, int32(volumeID)); err != nil {
+			opLog.Error("Volume attach remediation failed", err)
+			s.notifyAttachFailed(req, err)
+			return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "volume failed during attach: %v", err)
+		}
+	}
+	if err != nil {
 		opLog.Error("Volume attachment timeout", err)
-		return nil, status.Errorf(codes.Internal, "volume attachment timeout: %v", err)
+		s.notifyAttachFailed(req, err)
+		return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "volume attachment timeout: %v", err)
 	}
 
 	// Record attach duration
 	metrics.RecordVolumeAttach(time.Since(attachTimer))
-	timer.ObserveSuccess()
+	s.recordAttachNode(ctx, req.GetVolumeId(), req.GetNodeId())
 	opLog.Complete("Volume attached successfully")
 
-	// Return device path information
-	return &csi.ControllerPublishVolumeResponse{
-		PublishContext: map[string]string{
-			"devicePath": fmt.Sprintf("/dev/disk/by-id/virtio-%d", volumeID),
-		},
-	}, nil
+	// Re-read the volume for the attachment details Emma reports once it is attached
+	if attached, getErr := s.api(ctx).GetVolume(ctx, int32(volumeID)); getErr == nil {
+		volume = attached
+	} else {
+		opLog.WithField("error", getErr.Error()).Warn("Failed to read attachment details, node will fall back to device heuristics")
+	}
+	if isAzureVolume(volume) && (volume.Attachment == nil || volume.Attachment.LUN == nil) {
+		opLog.Warn("Emma did not report the LUN of a volume attached to an Azure VM, node will fall back to device heuristics")
+	}
+
+	return &csi.ControllerPublishVolumeResponse{PublishContext: publishContext(volume, s.sizeUnit)}, nil
 }
 
 // ControllerUnpublishVolume detaches a volume from a node
 func (s *ControllerService) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
-	timer := metrics.NewOperationTimer("ControllerUnpublishVolume")
 	detachTimer := time.Now()
-	opLog := s.logger.WithOperation("ControllerUnpublishVolume").
+	opLog := s.logger.WithContext(ctx).WithOperation("ControllerUnpublishVolume").
 		WithVolumeID(req.GetVolumeId()).
 		WithNodeID(req.GetNodeId())
 
 	opLog.Info("ControllerUnpublishVolume request received")
-	klog.V(4).Infof("ControllerUnpublishVolume called with request: %+v", req)
+	logging.Klog(ctx).V(4).Infof("ControllerUnpublishVolume called with request: %+v", stripSecrets(req))
 
 	// Validate request
 	if req.GetVolumeId() == "" {
-		timer.ObserveError()
 		opLog.Error("Volume ID is required", nil)
 		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
 	}
 
 	if req.GetNodeId() == "" {
-		timer.ObserveError()
 		opLog.Error("Node ID is required", nil)
 		return nil, status.Error(codes.InvalidArgument, "node ID is required")
 	}
 
 	// Parse volume ID and node ID (VM ID)
-	volumeID, err := strconv.ParseInt(req.GetVolumeId(), 10, 32)
+	volumeID, err := parseVolumeID(req.GetVolumeId())
 	if err != nil {
-		timer.ObserveError()
 		opLog.Error("Invalid volume ID", err)
-		return nil, status.Errorf(codes.InvalidArgument, "invalid volume ID: %v", err)
+		return nil, err
 	}
 
+	// StorageClasses may use their own Emma credentials
+	ctx, err = s.withCredentials(ctx, req.GetSecrets())
+	if err != nil {
+		opLog.Error("Invalid Emma credentials in secret", err)
+		return nil, err
+	}
+
+	unlock, err := s.volumeLocks.lock(req.GetVolumeId(), "ControllerUnpublishVolume")
+	if err != nil {
+		opLog.Error("Operation already in progress", err)
+		return nil, err
+	}
+	defer unlock()
+
 	// Resolve node ID to VM ID (handles both integer VM IDs and node names)
 	vmID, err := s.resolveNodeIDToVMID(ctx, req.GetNodeId())
 	if err != nil {
-		timer.ObserveError()
 		opLog.WithField("nodeId", req.GetNodeId()).Error("Failed to resolve node ID to VM ID", err)
-		return nil, status.Errorf(codes.InvalidArgument, "failed to resolve node ID: %v", err)
+		return nil, status.Errorf(emmaErrorCode(err, codes.InvalidArgument), "failed to resolve node ID: %v", err)
 	}
 
 	opLog.WithField("vmId", vmID).Info("Detaching volume from node")
 
 	// Check if volume is already detached
-	volume, err := s.emmaClient.GetVolume(ctx, int32(volumeID))
+	volume, err := s.api(ctx).GetVolume(ctx, int32(volumeID))
 	if err != nil {
 		// If volume doesn't exist, consider it already detached
-		if status.Code(err) == codes.NotFound {
-			timer.ObserveSuccess()
+		if errors.Is(err, emma.ErrVolumeNotFound) {
+			s.attachHistory.Detached(req.GetVolumeId())
 			opLog.Info("Volume not found, considering it already detached")
 			return &csi.ControllerUnpublishVolumeResponse{}, nil
 		}
-		timer.ObserveError()
 		opLog.Error("Failed to get volume", err)
-		return nil, status.Errorf(codes.Internal, "failed to get volume: %v", err)
+		return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "failed to get volume: %v", err)
 	}
 
 	if volume.AttachedToID == nil {
-		timer.ObserveSuccess()
+		s.attachHistory.Detached(req.GetVolumeId())
 		opLog.Info("Volume is already detached")
 		return &csi.ControllerUnpublishVolumeResponse{}, nil
 	}
 
 	if *volume.AttachedToID != int32(vmID) {
-		timer.ObserveSuccess()
+		// A cached node name resolution may be stale if the node was recreated
+		if newVMID, ok := s.refreshNodeResolution(ctx, req.GetNodeId(), vmID); ok && newVMID == *volume.AttachedToID {
+			opLog.WithField("vmId", newVMID).Info("Node re-resolved to the VM the volume is attached to")
+			vmID = newVMID
+		}
+	}
+
+	if *volume.AttachedToID != int32(vmID) {
 		opLog.WithField("attachedToVmId", *volume.AttachedToID).Info("Volume is not attached to this node, skipping detachment")
 		return &csi.ControllerUnpublishVolumeResponse{}, nil
 	}
 
+	// Reserve the wait for the detachment before detaching
+	ctx, releaseWaiter, err := s.api(ctx).ReserveWaiter(ctx)
+	if err != nil {
+		opLog.Error("Volume waiter limit reached", err)
+		return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "controller busy, retry later: %v", err)
+	}
+	defer releaseWaiter()
+
 	// Detach volume from VM via Emma API
 	opLog.Info("Initiating volume detach via Emma API")
-	if err := s.emmaClient.DetachVolume(ctx, int32(vmID), int32(volumeID)); err != nil {
-		timer.ObserveError()
+	if err := s.api(ctx).DetachVolume(ctx, int32(vmID), int32(volumeID)); err != nil {
 		opLog.Error("Failed to detach volume via Emma API", err)
-		return nil, status.Errorf(codes.Internal, "failed to detach volume: %v", err)
+		return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "failed to detach volume: %v", err)
 	}
 
 	// Wait for detachment to complete
 	opLog.Info("Waiting for volume detachment to complete")
-	if err := s.emmaClient.WaitForVolumeDetachment(ctx, int32(volumeID), volumeDetachTimeout); err != nil {
-		timer.ObserveError()
+	if err := s.api(ctx).WaitForVolumeDetachment(ctx, int32(volumeID), volumeDetachTimeout); err != nil {
 		opLog.Error("Volume detachment timeout", err)
-		return nil, status.Errorf(codes.Internal, "volume detachment timeout: %v", err)
+		return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "volume detachment timeout: %v", err)
 	}
 
+	s.attachHistory.Detached(req.GetVolumeId())
+
 	// Record detach duration
 	metrics.RecordVolumeDetach(time.Since(detachTimer))
-	timer.ObserveSuccess()
 	opLog.Complete("Volume detached successfully")
 
 	return &csi.ControllerUnpublishVolumeResponse{}, nil
@@ -469,7 +1083,7 @@ func (s *ControllerService) ControllerUnpublishVolume(ctx context.Context, req *
 
 // ValidateVolumeCapabilities validates volume capabilities
 func (s *ControllerService) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
-	klog.V(4).Infof("ValidateVolumeCapabilities called with request: %+v", req)
+	logging.Klog(ctx).V(4).Infof("ValidateVolumeCapabilities called with request: %+v", stripSecrets(req))
 
 	// Validate request
 	if req.GetVolumeId() == "" {
@@ -481,26 +1095,26 @@ func (s *ControllerService) ValidateVolumeCapabilities(ctx context.Context, req
 	}
 
 	// Parse volume ID
-	volumeID, err := strconv.ParseInt(req.GetVolumeId(), 10, 32)
+	volumeID, err := parseVolumeID(req.GetVolumeId())
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "invalid volume ID: %v", err)
+		return nil, err
 	}
 
 	// Check if volume exists
-	_, err = s.emmaClient.GetVolume(ctx, int32(volumeID))
+	_, err = s.api(ctx).GetVolume(ctx, int32(volumeID))
 	if err != nil {
-		return nil, status.Errorf(codes.NotFound, "volume %d not found: %v", volumeID, err)
+		return nil, status.Errorf(emmaErrorCode(err, codes.NotFound), "volume %d not found: %v", volumeID, err)
 	}
 
 	// Validate capabilities
 	if err := s.validateVolumeCapabilities(req.GetVolumeCapabilities()); err != nil {
-		klog.V(4).Infof("Volume capabilities validation failed: %v", err)
+		logging.Klog(ctx).V(4).Infof("Volume capabilities validation failed: %v", err)
 		return &csi.ValidateVolumeCapabilitiesResponse{
 			Message: err.Error(),
 		}, nil
 	}
 
-	klog.V(4).Infof("Volume %d capabilities validated successfully", volumeID)
+	logging.Klog(ctx).V(4).Infof("Volume %d capabilities validated successfully", volumeID)
 
 	return &csi.ValidateVolumeCapabilitiesResponse{
 		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
@@ -513,58 +1127,151 @@ func (s *ControllerService) ValidateVolumeCapabilities(ctx context.Context, req
 
 // ListVolumes lists volumes
 func (s *ControllerService) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
-	klog.V(4).Infof("ListVolumes called with request: %+v", req)
+	logging.Klog(ctx).V(4).Infof("ListVolumes called with request: %+v", stripSecrets(req))
+
+	if req.GetMaxEntries() < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "max_entries must not be negative, got %d", req.GetMaxEntries())
+	}
 
 	// List volumes via Emma API
-	volumes, err := s.emmaClient.ListVolumes(ctx)
+	volumes, err := s.api(ctx).ListVolumes(ctx)
+	if err != nil {
+		return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "failed to list volumes: %v", err)
+	}
+
+	page, nextToken, err := paginateVolumes(volumes, req.GetStartingToken(), req.GetMaxEntries())
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to list volumes: %v", err)
+		return nil, err
+	}
+
+	// Emma reports the VM a volume is attached to; map unknown VMs to node names
+	for _, vol := range page {
+		if vol.AttachedToID != nil && s.publishedNodeID(ctx, strconv.Itoa(int(vol.ID)), *vol.AttachedToID) == "" {
+			s.refreshNodeCache(ctx)
+			break
+		}
 	}
 
 	// Convert to CSI volume entries
-	entries := make([]*csi.ListVolumesResponse_Entry, 0, len(volumes))
-	for _, vol := range volumes {
+	entries := make([]*csi.ListVolumesResponse_Entry, 0, len(page))
+	for _, vol := range page {
+		publishedNodeIDs, err := s.publishedNodeIDs(ctx, vol)
+		if err != nil {
+			return nil, err
+		}
 		entry := &csi.ListVolumesResponse_Entry{
-			Volume: &csi.Volume{
-				VolumeId:      strconv.Itoa(int(vol.ID)),
-				CapacityBytes: int64(vol.SizeGB) * bytesPerGB,
-				VolumeContext: map[string]string{
-					paramType:         vol.Type,
-					paramDataCenterID: vol.DataCenterID,
-				},
+			Volume: s.csiVolume(vol),
+			Status: &csi.ListVolumesResponse_VolumeStatus{
+				PublishedNodeIds: publishedNodeIDs,
 			},
 		}
 
-		// Add status information if available
+		// Add condition information if available
 		if vol.Status != "" {
-			entry.Status = &csi.ListVolumesResponse_VolumeStatus{
-				VolumeCondition: &csi.VolumeCondition{
-					Message: fmt.Sprintf("Status: %s", vol.Status),
-				},
-			}
+			entry.Status.VolumeCondition = volumeCondition(vol)
 		}
 
 		entries = append(entries, entry)
 	}
 
-	klog.V(4).Infof("Listed %d volumes", len(entries))
+	logging.Klog(ctx).V(4).Infof("Listed %d of %d volumes (next token %q)", len(entries), len(volumes), nextToken)
 
 	return &csi.ListVolumesResponse{
-		Entries: entries,
+		Entries:   entries,
+		NextToken: nextToken,
 	}, nil
 }
 
+// paginateVolumes returns one page of volumes ordered by ID, and the token of the next page.
+// The Emma API lists all volumes at once, so the token is the ID of the last volume
+// returned rather than an offset; volumes created or deleted between pages then neither
+// shift later pages nor get listed twice.
+func paginateVolumes(volumes []*emma.VolumeResponse, startingToken string, maxEntries int32) ([]*emma.VolumeResponse, string, error) {
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].ID < volumes[j].ID })
+
+	start := 0
+	if startingToken != "" {
+		after, err := strconv.ParseInt(startingToken, 10, 32)
+		if err != nil {
+			return nil, "", status.Errorf(codes.Aborted, "invalid starting_token %q", startingToken)
+		}
+		start = sort.Search(len(volumes), func(i int) bool { return int64(volumes[i].ID) > after })
+	}
+
+	page := volumes[start:]
+	if maxEntries == 0 || int(maxEntries) >= len(page) {
+		return page, "", nil
+	}
+	page = page[:maxEntries]
+	return page, strconv.FormatInt(int64(page[len(page)-1].ID), 10), nil
+}
+
 // GetCapacity returns available capacity
 func (s *ControllerService) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
-	klog.V(4).Infof("GetCapacity called with request: %+v", req)
+	logging.Klog(ctx).V(4).Infof("GetCapacity called with request: %+v", stripSecrets(req))
 
-	// Not supported
-	return nil, status.Error(codes.Unimplemented, "GetCapacity not supported")
+	// Emma has no storage pools or quota API, so the free capacity is unknown and only the
+	// largest volume Emma offers for the type in the datacenter is reported. Datacenters
+	// that do not offer the type report zero, which keeps the scheduler away from their nodes.
+	params := req.GetParameters()
+	volumeType := defaultVolumeType
+	if t := params[paramType]; t != "" {
+		volumeType = t
+	}
+	dataCenterID := req.GetAccessibleTopology().GetSegments()[TopologyKeyDataCenter]
+	if dataCenterID == "" {
+		dataCenterID = params[paramDataCenterID]
+	}
+
+	configs, err := s.api(ctx).GetVolumeConfigs(ctx)
+	if err != nil {
+		return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "failed to get volume configs: %v", err)
+	}
+
+	maxGB := maxVolumeConfigSize(configs, dataCenterID, volumeType)
+	logging.Klog(ctx).V(4).Infof("Capacity for type %s in datacenter %q: %dGB", volumeType, dataCenterID, maxGB)
+
+	return &csi.GetCapacityResponse{
+		MaximumVolumeSize: wrapperspb.Int64(s.sizeUnit.ToBytes(maxGB)),
+	}, nil
+}
+
+// validateVolumeOffering checks a volume type and size against the volume configurations
+// Emma offers in the datacenter. Validation is skipped when the configurations cannot be
+// listed, leaving the Emma API to reject the volume.
+func (s *ControllerService) validateVolumeOffering(ctx context.Context, dataCenterID, volumeType string, sizeGB int32) error {
+	configs, err := s.api(ctx).GetVolumeConfigs(ctx)
+	if err != nil {
+		logging.Klog(ctx).Warningf("Skipping volume type and size validation, failed to get volume configs: %v", err)
+		return nil
+	}
+	if err := emma.NewVolumeOfferings(configs).Validate(dataCenterID, volumeType, sizeGB); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return nil
+}
+
+// maxVolumeConfigSize returns the largest volume size offered for a volume type in a
+// datacenter, or in any datacenter when dataCenterID is empty
+func maxVolumeConfigSize(configs []emmasdk.VolumeConfiguration, dataCenterID, volumeType string) int32 {
+	var maxGB int32
+	for _, config := range configs {
+		if dataCenterID != "" && config.GetDataCenterId() != dataCenterID {
+			continue
+		}
+		if !strings.EqualFold(config.GetVolumeType(), volumeType) {
+			continue
+		}
+		if config.GetVolumeGb() > maxGB {
+			maxGB = config.GetVolumeGb()
+		}
+	}
+	return maxGB
 }
 
 // ControllerGetCapabilities returns controller capabilities
 func (s *ControllerService) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
-	klog.V(4).Info("ControllerGetCapabilities called")
+	logging.Klog(ctx).V(4).Info("ControllerGetCapabilities called")
 
 	return &csi.ControllerGetCapabilitiesResponse{
 		Capabilities: []*csi.ControllerServiceCapability{
@@ -596,13 +1303,48 @@ func (s *ControllerService) ControllerGetCapabilities(ctx context.Context, req *
 					},
 				},
 			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_GET_VOLUME,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+					},
+				},
+			},
 		},
 	}, nil
 }
 
 // CreateSnapshot creates a snapshot
 func (s *ControllerService) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
-	klog.V(4).Infof("CreateSnapshot called with request: %+v", req)
+	logging.Klog(ctx).V(4).Infof("CreateSnapshot called with request: %+v", stripSecrets(req))
 
 	// Not supported
 	return nil, status.Error(codes.Unimplemented, "CreateSnapshot not supported")
@@ -610,7 +1352,7 @@ func (s *ControllerService) CreateSnapshot(ctx context.Context, req *csi.CreateS
 
 // DeleteSnapshot deletes a snapshot
 func (s *ControllerService) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
-	klog.V(4).Infof("DeleteSnapshot called with request: %+v", req)
+	logging.Klog(ctx).V(4).Infof("DeleteSnapshot called with request: %+v", stripSecrets(req))
 
 	// Not supported
 	return nil, status.Error(codes.Unimplemented, "DeleteSnapshot not supported")
@@ -618,7 +1360,7 @@ func (s *ControllerService) DeleteSnapshot(ctx context.Context, req *csi.DeleteS
 
 // ListSnapshots lists snapshots
 func (s *ControllerService) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
-	klog.V(4).Infof("ListSnapshots called with request: %+v", req)
+	logging.Klog(ctx).V(4).Infof("ListSnapshots called with request: %+v", stripSecrets(req))
 
 	// Not supported
 	return nil, status.Error(codes.Unimplemented, "ListSnapshots not supported")
@@ -626,7 +1368,7 @@ func (s *ControllerService) ListSnapshots(ctx context.Context, req *csi.ListSnap
 
 // ControllerExpandVolume expands a volume
 func (s *ControllerService) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
-	klog.V(4).Infof("ControllerExpandVolume called with request: %+v", req)
+	logging.Klog(ctx).V(4).Infof("ControllerExpandVolume called with request: %+v", stripSecrets(req))
 
 	// Validate request
 	if req.GetVolumeId() == "" {
@@ -637,90 +1379,222 @@ func (s *ControllerService) ControllerExpandVolume(ctx context.Context, req *csi
 		return nil, status.Error(codes.InvalidArgument, "capacity range is required")
 	}
 
+	// Validate volume capability if provided
+	if req.GetVolumeCapability() != nil {
+		if err := s.validateVolumeCapabilities([]*csi.VolumeCapability{req.GetVolumeCapability()}); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid volume capability: %v", err)
+		}
+	}
+
 	// Parse volume ID
-	volumeID, err := strconv.ParseInt(req.GetVolumeId(), 10, 32)
+	volumeID, err := parseVolumeID(req.GetVolumeId())
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "invalid volume ID: %v", err)
+		return nil, err
 	}
 
-	// Get current volume
-	volume, err := s.emmaClient.GetVolume(ctx, int32(volumeID))
+	// StorageClasses may use their own Emma credentials
+	ctx, err = s.withCredentials(ctx, req.GetSecrets())
 	if err != nil {
-		return nil, status.Errorf(codes.NotFound, "volume %d not found: %v", volumeID, err)
+		return nil, err
 	}
 
-	// Parse new capacity
-	newCapacityBytes := req.GetCapacityRange().GetRequiredBytes()
-	if newCapacityBytes == 0 {
-		newCapacityBytes = req.GetCapacityRange().GetLimitBytes()
+	unlock, err := s.volumeLocks.lock(req.GetVolumeId(), "ControllerExpandVolume")
+	if err != nil {
+		return nil, err
 	}
-	if newCapacityBytes == 0 {
-		return nil, status.Error(codes.InvalidArgument, "new capacity is required")
+	defer unlock()
+
+	// Get current volume
+	volume, err := s.api(ctx).GetVolume(ctx, int32(volumeID))
+	if err != nil {
+		return nil, status.Errorf(emmaErrorCode(err, codes.NotFound), "volume %d not found: %v", volumeID, err)
 	}
 
-	// Convert to GB (round up)
-	newSizeGB := int32((newCapacityBytes + bytesPerGB - 1) / bytesPerGB)
-	if newSizeGB < 1 {
-		newSizeGB = 1
+	if volume, err = s.settleVolume(ctx, volume); err != nil {
+		return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "cannot expand volume: %v", err)
 	}
 
-	// Validate new size is larger than current size
-	if newSizeGB <= volume.SizeGB {
-		return nil, status.Errorf(codes.InvalidArgument, "new size (%dGB) must be larger than current size (%dGB)", newSizeGB, volume.SizeGB)
+	newSizeGB, noop, err := s.expansionSizeGB(volume.SizeGB, req.GetCapacityRange())
+	if err != nil {
+		return nil, err
 	}
 
-	klog.V(4).Infof("Expanding volume %d from %dGB to %dGB", volumeID, volume.SizeGB, newSizeGB)
+	// Block volumes have no filesystem for the node to grow
+	nodeExpansionRequired := req.GetVolumeCapability().GetBlock() == nil
 
-	// Resize volume via Emma API
-	if err := s.emmaClient.ResizeVolume(ctx, int32(volumeID), newSizeGB); err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to resize volume: %v", err)
+	if noop {
+		logging.Klog(ctx).V(4).Infof("Volume %d already has the requested size (%dGB), nothing to expand", volumeID, volume.SizeGB)
+		return &csi.ControllerExpandVolumeResponse{
+			CapacityBytes:         s.sizeUnit.ToBytes(volume.SizeGB),
+			NodeExpansionRequired: nodeExpansionRequired,
+		}, nil
 	}
 
-	// Wait for resize to complete (volume should return to AVAILABLE or ACTIVE state)
-	targetStatus := "AVAILABLE"
-	if volume.AttachedToID != nil {
-		targetStatus = "ACTIVE"
+	logging.Klog(ctx).V(4).Infof("Expanding volume %d from %dGB to %dGB", volumeID, volume.SizeGB, newSizeGB)
+
+	// Reserve the wait for the resize before resizing, so a busy controller rejects the
+	// request instead of resizing a volume it cannot wait for
+	ctx, releaseWaiter, err := s.api(ctx).ReserveWaiter(ctx)
+	if err != nil {
+		return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "controller busy, retry later: %v", err)
+	}
+	defer releaseWaiter()
+
+	// Resize volume via Emma API
+	if err := s.api(ctx).ResizeVolume(ctx, int32(volumeID), newSizeGB); err != nil {
+		return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "failed to resize volume: %v", err)
 	}
 
-	if err := s.emmaClient.WaitForVolumeStatus(ctx, int32(volumeID), targetStatus, volumeResizeTimeout); err != nil {
-		return nil, status.Errorf(codes.Internal, "volume resize timeout: %v", err)
+	// Wait for resize to complete (volume should return to AVAILABLE or ACTIVE state)
+	targetStatus := expansionTargetStatus(volume)
+	if err := s.api(ctx).WaitForVolumeStatus(ctx, int32(volumeID), targetStatus, volumeResizeTimeout); err != nil {
+		return nil, status.Errorf(emmaErrorCode(err, codes.Internal), "volume resize timeout: %v", err)
 	}
 
-	klog.V(4).Infof("Volume %d expanded successfully to %dGB", volumeID, newSizeGB)
+	logging.Klog(ctx).V(4).Infof("Volume %d expanded successfully to %dGB", volumeID, newSizeGB)
+	s.notify(notify.Event{
+		Type:          notify.EventVolumeExpansionCompleted,
+		VolumeID:      req.GetVolumeId(),
+		VolumeName:    volume.Name,
+		DataCenterID:  volume.DataCenterID,
+		CapacityBytes: s.sizeUnit.ToBytes(newSizeGB),
+	})
 
 	return &csi.ControllerExpandVolumeResponse{
-		CapacityBytes:         int64(newSizeGB) * bytesPerGB,
-		NodeExpansionRequired: true, // Node needs to expand the filesystem
+		CapacityBytes:         s.sizeUnit.ToBytes(newSizeGB),
+		NodeExpansionRequired: nodeExpansionRequired,
 	}, nil
 }
 
+// validateContentSource rejects volume content sources the Emma API cannot provision from.
+// Emma has no volume snapshot or volume copy API, so neither snapshots can be restored
+// nor volumes cloned, and CLONE_VOLUME is not advertised.
+func validateContentSource(source *csi.VolumeContentSource) error {
+	if source == nil {
+		return nil
+	}
+
+	if snapshot := source.GetSnapshot(); snapshot != nil {
+		return status.Errorf(codes.InvalidArgument,
+			"cannot restore from snapshot %q: the Emma API does not support volume snapshots", snapshot.GetSnapshotId())
+	}
+
+	if volume := source.GetVolume(); volume != nil {
+		return status.Errorf(codes.InvalidArgument,
+			"cannot clone volume %q: the Emma API does not support copying volumes", volume.GetVolumeId())
+	}
+
+	return status.Errorf(codes.InvalidArgument, "unsupported volume content source: %v", source)
+}
+
+// expansionSizeGB converts the requested capacity range to a size in Emma units, rounded
+// and checked against the limit like new volumes, and checks it against the current volume
+// size. It returns noop=true when the volume already has the requested size. Shrinking is
+// rejected with OutOfRange.
+func (s *ControllerService) expansionSizeGB(currentGB int32, capRange *csi.CapacityRange) (int32, bool, error) {
+	newCapacityBytes := capRange.GetRequiredBytes()
+	if newCapacityBytes == 0 {
+		newCapacityBytes = capRange.GetLimitBytes()
+	}
+	if newCapacityBytes <= 0 {
+		return 0, false, status.Error(codes.InvalidArgument, "new capacity is required")
+	}
+
+	newSizeGB, err := s.provisionedSize(newCapacityBytes, capRange)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if newSizeGB == currentGB {
+		return newSizeGB, true, nil
+	}
+
+	if newSizeGB < currentGB {
+		return 0, false, status.Errorf(codes.OutOfRange, "new size (%dGB) is smaller than current size (%dGB), shrinking is not supported", newSizeGB, currentGB)
+	}
+
+	return newSizeGB, false, nil
+}
+
+// settleVolume waits out transient states such as BUSY, in which Emma rejects changes to
+// the volume, and returns the settled volume
+func (s *ControllerService) settleVolume(ctx context.Context, volume *emma.VolumeResponse) (*emma.VolumeResponse, error) {
+	if !emma.IsTransientVolumeStatus(volume.Status) {
+		return volume, nil
+	}
+	logging.Klog(ctx).V(4).Infof("Volume %d is %s, waiting for it to settle", volume.ID, volume.Status)
+	return s.api(ctx).WaitForVolumeSettled(ctx, volume.ID, volumeSettleTimeout)
+}
+
+// expansionTargetStatus returns the status a volume returns to after a resize
+func expansionTargetStatus(volume *emma.VolumeResponse) string {
+	if volume.AttachedToID != nil {
+		return "ACTIVE"
+	}
+	return "AVAILABLE"
+}
+
 // ControllerGetVolume gets volume information
 func (s *ControllerService) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
-	klog.V(4).Infof("ControllerGetVolume called with request: %+v", req)
+	logging.Klog(ctx).V(4).Infof("ControllerGetVolume called with request: %+v", stripSecrets(req))
 
-	// Not supported
-	return nil, status.Error(codes.Unimplemented, "ControllerGetVolume not supported")
+	volumeID, err := parseVolumeID(req.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+
+	vol, err := s.api(ctx).GetVolume(ctx, int32(volumeID))
+	if err != nil {
+		return nil, status.Errorf(emmaErrorCode(err, codes.NotFound), "volume %d not found: %v", volumeID, err)
+	}
+
+	if vol.AttachedToID != nil && s.publishedNodeID(ctx, req.GetVolumeId(), *vol.AttachedToID) == "" {
+		s.refreshNodeCache(ctx)
+	}
+	publishedNodeIDs, err := s.publishedNodeIDs(ctx, vol)
+	if err != nil {
+		return nil, err
+	}
+
+	volumeStatus := &csi.ControllerGetVolumeResponse_VolumeStatus{
+		VolumeCondition:  volumeCondition(vol),
+		PublishedNodeIds: publishedNodeIDs,
+	}
+
+	return &csi.ControllerGetVolumeResponse{
+		Volume: s.csiVolume(vol),
+		Status: volumeStatus,
+	}, nil
 }
 
 // ControllerModifyVolume modifies a volume (not supported)
 func (s *ControllerService) ControllerModifyVolume(ctx context.Context, req *csi.ControllerModifyVolumeRequest) (*csi.ControllerModifyVolumeResponse, error) {
-	klog.V(4).Infof("ControllerModifyVolume called with request: %+v", req)
+	logging.Klog(ctx).V(4).Infof("ControllerModifyVolume called with request: %+v", stripSecrets(req))
 
 	// Not supported
 	return nil, status.Error(codes.Unimplemented, "ControllerModifyVolume not supported")
 }
 
+// supportedAccessModes are the access modes of a volume attached to a single node. The
+// CSI v1.5 single writer modes back ReadWriteOncePod, the multi writer mode ReadWriteOnce
+// for sidecars advertising SINGLE_NODE_MULTI_WRITER.
+var supportedAccessModes = map[csi.VolumeCapability_AccessMode_Mode]bool{
+	csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER:        true,
+	csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER: true,
+	csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER:  true,
+}
+
 // validateVolumeCapabilities validates that the requested capabilities are supported
 func (s *ControllerService) validateVolumeCapabilities(caps []*csi.VolumeCapability) error {
 	for _, cap := range caps {
-		// Validate access mode - only ReadWriteOnce is supported
+		// Validate access mode - Emma volumes attach to one VM at a time
 		accessMode := cap.GetAccessMode()
 		if accessMode == nil {
 			return fmt.Errorf("access mode is required")
 		}
 
-		if accessMode.GetMode() != csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER {
-			return fmt.Errorf("unsupported access mode: %v (only ReadWriteOnce is supported)", accessMode.GetMode())
+		if !supportedAccessModes[accessMode.GetMode()] {
+			return fmt.Errorf("unsupported access mode: %v (only ReadWriteOnce and ReadWriteOncePod are supported)", accessMode.GetMode())
 		}
 
 		// Validate access type (block or mount)
@@ -750,13 +1624,227 @@ func (s *ControllerService) validateVolumeCapabilities(caps []*csi.VolumeCapabil
 	return nil
 }
 
+// csiVolume returns the CSI volume for an Emma volume, with the attach history of the
+// controller added to its context
+func (s *ControllerService) csiVolume(vol *emma.VolumeResponse) *csi.Volume {
+	volume := &csi.Volume{
+		VolumeId:      strconv.Itoa(int(vol.ID)),
+		CapacityBytes: s.sizeUnit.ToBytes(vol.SizeGB),
+		VolumeContext: buildVolumeContext(vol),
+	}
+	if node, ok := s.attachHistory.Last(volume.VolumeId); ok {
+		volume.VolumeContext[volumeContextLastAttachedNode] = node
+	}
+
+	// Attachments made before the controller started have no known attach time
+	if vol.AttachedToID == nil {
+		s.attachHistory.Detached(volume.VolumeId)
+	}
+	if attachedAt, ok := s.attachHistory.AttachedAt(volume.VolumeId); ok {
+		volume.VolumeContext[volumeContextAttachedAt] = attachedAt.UTC().Format(time.RFC3339)
+	}
+
+	return volume
+}
+
+// publishedNodeIDs returns the node a volume is attached to, in the form the CO uses as node
+// ID. It fails with Unavailable when the node of the VM is unknown: reporting another ID
+// would tell the attacher the volume is not published to its node, and it would mark a live
+// VolumeAttachment detached.
+func (s *ControllerService) publishedNodeIDs(ctx context.Context, vol *emma.VolumeResponse) ([]string, error) {
+	if vol.AttachedToID == nil {
+		return nil, nil
+	}
+	if node := s.publishedNodeID(ctx, strconv.Itoa(int(vol.ID)), *vol.AttachedToID); node != "" {
+		return []string{node}, nil
+	}
+	// Node IDs are VM IDs in the numeric-only mode
+	if s.nodeResolution == NodeResolutionNumericOnly {
+		return []string{strconv.Itoa(int(*vol.AttachedToID))}, nil
+	}
+	return nil, status.Errorf(codes.Unavailable, "volume %d is attached to VM %d, which no known node resolves to", vol.ID, *vol.AttachedToID)
+}
+
+// publishedNodeID maps the VM a volume is attached to back to a node ID, or "" when the
+// VM's node is unknown. Emma only knows the VM, so the node comes from the controller's
+// attach history if that node still resolves to the VM, or else from the node cache.
+func (s *ControllerService) publishedNodeID(ctx context.Context, volumeID string, vmID int32) string {
+	if node, ok := s.attachHistory.Last(volumeID); ok {
+		if nodeVMID, err := strconv.ParseInt(node, 10, 32); err == nil && int32(nodeVMID) == vmID {
+			return node
+		}
+		if cached, ok := s.nodeVMs(ctx).Get(node); ok && cached == vmID {
+			return node
+		}
+	}
+	if node, ok := s.nodeVMs(ctx).NodeForVM(vmID); ok {
+		return node
+	}
+	return ""
+}
+
+// refreshNodeCache caches the VM ID of every node name Emma knows under the node resolution
+// mode. It lists the VMs at most once per node cache TTL.
+func (s *ControllerService) refreshNodeCache(ctx context.Context) {
+	cache := s.nodeVMs(ctx)
+	if !cache.StartRefresh() {
+		return
+	}
+	nodeVMs, err := s.emmaNodeVMs(ctx)
+	if err != nil {
+		cache.AbortRefresh()
+		logging.Klog(ctx).Warningf("Failed to map VMs to nodes: %v", err)
+		return
+	}
+	for name, vmID := range nodeVMs {
+		cache.Set(name, vmID)
+	}
+}
+
+// volumeCondition reports a volume as abnormal when Emma has it in a failed state
+func volumeCondition(vol *emma.VolumeResponse) *csi.VolumeCondition {
+	switch strings.ToUpper(vol.Status) {
+	case "FAILED", "ERROR":
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("Emma reports volume %d in %s state", vol.ID, vol.Status),
+		}
+	}
+	return &csi.VolumeCondition{
+		Message: fmt.Sprintf("Status: %s", vol.Status),
+	}
+}
+
+// buildVolumeContext returns the CSI volume context for an Emma volume, including
+// performance characteristics and the cloud provider volume ID when Emma reports them
+func buildVolumeContext(volume *emma.VolumeResponse) map[string]string {
+	volumeContext := map[string]string{
+		paramType:         volume.Type,
+		paramDataCenterID: volume.DataCenterID,
+	}
+
+	if volume.IOPS != nil {
+		volumeContext[volumeContextIOPS] = strconv.Itoa(int(*volume.IOPS))
+	}
+	if volume.ThroughputMBps != nil {
+		volumeContext[volumeContextThroughput] = strconv.Itoa(int(*volume.ThroughputMBps))
+	}
+	if volume.ProviderVolumeID != "" {
+		volumeContext[volumeContextProviderVolumeID] = volume.ProviderVolumeID
+	}
+
+	return volumeContext
+}
+
+// selectDataCenter returns the datacenter for a new volume. The dataCenterId parameter wins
+// when it satisfies the requisite topology; otherwise the first preferred, then requisite,
+// topology segment is used, restricted to the allowed datacenters when there are any.
+// Without topology requirements the default datacenter is used, or the first allowed one.
+func selectDataCenter(param string, allowed []string, defaultDC string, requirements *csi.TopologyRequirement) (string, error) {
+	var requisite []string
+	for _, topology := range requirements.GetRequisite() {
+		if dc, ok := topology.GetSegments()[TopologyKeyDataCenter]; ok {
+			requisite = append(requisite, dc)
+		}
+	}
+
+	if param != "" {
+		if len(requisite) == 0 {
+			return param, nil
+		}
+		for _, dc := range requisite {
+			if dc == param {
+				return param, nil
+			}
+		}
+		return "", fmt.Errorf("dataCenterId parameter %s is not in the requisite topology %v", param, requisite)
+	}
+
+	isAllowed := func(dc string) bool {
+		return len(allowed) == 0 || slices.Contains(allowed, dc)
+	}
+
+	var topology []string
+	for _, preferred := range requirements.GetPreferred() {
+		if dc, ok := preferred.GetSegments()[TopologyKeyDataCenter]; ok && dc != "" {
+			topology = append(topology, dc)
+		}
+	}
+	topology = append(topology, requisite...)
+	for _, dc := range topology {
+		if dc != "" && isAllowed(dc) {
+			return dc, nil
+		}
+	}
+	if len(topology) > 0 {
+		return "", fmt.Errorf("none of the %s %v matches the topology %v", paramAllowedDataCenters, allowed, topology)
+	}
+
+	if defaultDC != "" && isAllowed(defaultDC) {
+		return defaultDC, nil
+	}
+	if len(allowed) > 0 {
+		return allowed[0], nil
+	}
+
+	return "", errors.New("dataCenterId parameter, a datacenter topology requirement or the controller's --datacenter-id is required")
+}
+
+// splitDataCenters parses a comma-separated list of datacenter IDs
+func splitDataCenters(value string) []string {
+	var dataCenters []string
+	for _, dc := range strings.Split(value, ",") {
+		if dc = strings.TrimSpace(dc); dc != "" {
+			dataCenters = append(dataCenters, dc)
+		}
+	}
+	return dataCenters
+}
+
+// candidateDataCenters returns the datacenters to try for a new volume in order: the primary one
+// followed by the StorageClass fallbacks that are allowed by the topology requirements
+func candidateDataCenters(primary, fallbacks string, requirements *csi.TopologyRequirement) []string {
+	allowed := map[string]bool{}
+	for _, topology := range requirements.GetRequisite() {
+		if dc, ok := topology.GetSegments()[TopologyKeyDataCenter]; ok {
+			allowed[dc] = true
+		}
+	}
+
+	candidates := []string{primary}
+	seen := map[string]bool{primary: true}
+	for _, dc := range strings.Split(fallbacks, ",") {
+		dc = strings.TrimSpace(dc)
+		if dc == "" || seen[dc] {
+			continue
+		}
+		if len(allowed) > 0 && !allowed[dc] {
+			continue
+		}
+		seen[dc] = true
+		candidates = append(candidates, dc)
+	}
+
+	return candidates
+}
+
+// maxVolumeSizeGB is the largest volume size Emma accepts
+const maxVolumeSizeGB int32 = 2048
+
 // roundUpToPowerOfTwo rounds up a size to the nearest power of 2
 // Emma requires disk sizes to be powers of 2: 1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024, 2048 GB
+// Sizes above the maximum are capped at it, which also keeps the doubling below from
+// overflowing int32.
 func roundUpToPowerOfTwo(size int32) int32 {
 	if size <= 0 {
 		return 1
 	}
 
+	// Cap at 2048 GB (Emma's maximum)
+	if size >= maxVolumeSizeGB {
+		return maxVolumeSizeGB
+	}
+
 	// If already a power of 2, return as is
 	if size&(size-1) == 0 {
 		return size
@@ -768,50 +1856,169 @@ func roundUpToPowerOfTwo(size int32) int32 {
 		power *= 2
 	}
 
-	// Cap at 2048 GB (Emma's maximum)
-	if power > 2048 {
-		return 2048
-	}
-
 	return power
 }
 
-// resolveNodeIDToVMID resolves a Kubernetes node ID (which may be a name) to an Emma VM ID
-func (s *ControllerService) resolveNodeIDToVMID(ctx context.Context, nodeID string) (int32, error) {
-	// First, try to parse as integer (direct VM ID)
-	if vmID, err := strconv.ParseInt(nodeID, 10, 32); err == nil {
-		return int32(vmID), nil
-	}
-
+// lookupNodeInClusters resolves a node name to a VM ID by searching the Emma Kubernetes clusters
+func (s *ControllerService) lookupNodeInClusters(ctx context.Context, nodeID string) (int32, error) {
 	// If not an integer, treat as node name and look it up in Kubernetes clusters
-	klog.V(4).Infof("Node ID '%s' is not a number, looking up node in Kubernetes clusters", nodeID)
+	logging.Klog(ctx).V(4).Infof("Node ID '%s' is not a number, looking up node in Kubernetes clusters", nodeID)
 
 	// Get all Kubernetes clusters
-	clusters, err := s.emmaClient.ListKubernetesClusters(ctx)
+	clusters, err := s.api(ctx).ListKubernetesClusters(ctx)
 	if err != nil {
 		return 0, fmt.Errorf("failed to list Kubernetes clusters: %w", err)
 	}
 
 	// Search through all clusters for a node with matching name
 	for _, cluster := range clusters {
-		klog.V(5).Infof("Searching cluster '%s' (ID: %d) for node '%s'",
+		logging.Klog(ctx).V(5).Infof("Searching cluster '%s' (ID: %d) for node '%s'",
 			cluster.GetName(), cluster.GetId(), nodeID)
 
 		// Check all node groups in the cluster
 		for _, nodeGroup := range cluster.GetNodeGroups() {
-			klog.V(5).Infof("Checking node group '%s'", nodeGroup.GetName())
+			logging.Klog(ctx).V(5).Infof("Checking node group '%s'", nodeGroup.GetName())
 
 			// Check all nodes in the node group
 			for _, node := range nodeGroup.GetNodes() {
 				if node.GetName() == nodeID {
 					vmID := node.GetId()
-					klog.V(4).Infof("Found node '%s' with VM ID %d in cluster '%s'",
+					logging.Klog(ctx).V(4).Infof("Found node '%s' with VM ID %d in cluster '%s'",
 						nodeID, vmID, cluster.GetName())
+					s.nodeVMs(ctx).Set(nodeID, vmID)
 					return vmID, nil
 				}
 			}
 		}
 	}
 
-	return 0, fmt.Errorf("node not found with name: %s", nodeID)
+	return 0, fmt.Errorf("%w: %s", errNodeNotInClusters, nodeID)
+}
+
+// nodeVMIDLabel parses the Emma VM ID label from a node's labels
+func nodeVMIDLabel(nodeName string, labels map[string]string) (int32, bool) {
+	value, ok := labels[kube.LabelVMID]
+	if !ok {
+		return 0, false
+	}
+
+	vmID, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		klog.Warningf("Node %s has invalid %s label %q", nodeName, kube.LabelVMID, value)
+		return 0, false
+	}
+
+	return int32(vmID), true
+}
+
+// remediateFailedAttach detaches a volume that went FAILED while attaching, waits for the
+// detachment to show up and retries the attach once
+func (s *ControllerService) remediateFailedAttach(ctx context.Context, vmID, volumeID int32) error {
+	err := s.retryFailedAttach(ctx, vmID, volumeID)
+	if err != nil {
+		metrics.RecordAttachRemediation("failure")
+		return fmt.Errorf("attach remediation failed: %w", err)
+	}
+	metrics.RecordAttachRemediation("success")
+	return nil
+}
+
+// retryFailedAttach performs the detach, wait and attach steps of an attach remediation
+func (s *ControllerService) retryFailedAttach(ctx context.Context, vmID, volumeID int32) error {
+	if err := s.api(ctx).DetachVolume(ctx, vmID, volumeID); err != nil {
+		return fmt.Errorf("failed to detach volume: %w", err)
+	}
+	// The volume keeps reporting FAILED until Emma processes the detach
+	if err := s.api(ctx).WaitForVolumeDetachment(ctx, volumeID, volumeDetachTimeout); err != nil {
+		return fmt.Errorf("volume did not detach: %w", err)
+	}
+	if err := s.api(ctx).AttachVolume(ctx, vmID, volumeID); err != nil {
+		return fmt.Errorf("failed to re-attach volume: %w", err)
+	}
+	return s.api(ctx).WaitForVolumeAttachment(ctx, volumeID, vmID, volumeAttachTimeout)
+}
+
+// AttachTimes returns when the current attachment of each attached volume was established
+func (s *ControllerService) AttachTimes() map[string]time.Time {
+	return s.attachHistory.AttachTimes()
+}
+
+// recordAttachNode remembers the node a volume is attached to and counts moves between nodes
+func (s *ControllerService) recordAttachNode(ctx context.Context, volumeID, nodeID string) {
+	if s.attachHistory.Record(volumeID, nodeID) {
+		logging.Klog(ctx).V(4).Infof("Volume %s attached to a different node than last time: %s", volumeID, nodeID)
+		metrics.RecordAttachNodeChange()
+	}
+}
+
+// checkNodeNotDeleting returns an Unavailable error if the Kubernetes node is being deleted.
+// It is a no-op without a Kubernetes client or when the node ID is a numeric VM ID.
+func (s *ControllerService) checkNodeNotDeleting(ctx context.Context, nodeID string) error {
+	if s.kubeClient == nil {
+		return nil
+	}
+	if _, err := strconv.ParseInt(nodeID, 10, 32); err == nil {
+		return nil
+	}
+
+	node, err := s.kubeClient.CoreV1().Nodes().Get(ctx, nodeID, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return status.Errorf(codes.Unavailable, "node %s no longer exists", nodeID)
+		}
+		// Don't block attach on Kubernetes API problems
+		logging.Klog(ctx).Warningf("Failed to get node %s, skipping deletion check: %v", nodeID, err)
+		return nil
+	}
+
+	if deleting, reason := kube.IsNodeBeingDeleted(node); deleting {
+		return status.Errorf(codes.Unavailable, "node %s is being deleted: %s", nodeID, reason)
+	}
+
+	return nil
+}
+
+// refreshNodeResolution looks a node name up again after its cached VM ID turned out not
+// to match Emma, returning false if the node ID is a VM ID or the lookup fails
+func (s *ControllerService) refreshNodeResolution(ctx context.Context, nodeID string, cachedVMID int32) (int32, bool) {
+	if _, err := strconv.ParseInt(nodeID, 10, 32); err == nil {
+		return 0, false
+	}
+	s.nodeVMs(ctx).Invalidate(nodeID)
+	vmID, err := s.resolveNodeIDToVMID(ctx, nodeID)
+	if err != nil {
+		logging.Klog(ctx).V(4).Infof("Failed to re-resolve node '%s' after VM ID %d mismatch: %v", nodeID, cachedVMID, err)
+		return 0, false
+	}
+	return vmID, true
+}
+
+// reresolveNodeID drops a stale node name resolution and looks the node up again.
+// It fails if the node ID is a numeric VM ID or still resolves to the same missing VM.
+func (s *ControllerService) reresolveNodeID(ctx context.Context, nodeID string, staleVMID int32) (int32, error) {
+	if _, err := strconv.ParseInt(nodeID, 10, 32); err == nil {
+		return 0, fmt.Errorf("node ID %s is a VM ID and cannot be re-resolved", nodeID)
+	}
+
+	s.nodeVMs(ctx).Invalidate(nodeID)
+
+	vmID, err := s.resolveNodeIDToVMID(ctx, nodeID)
+	if err != nil {
+		return 0, err
+	}
+	if vmID == staleVMID {
+		// The node label may not have been updated yet, search Emma directly
+		s.nodeVMs(ctx).Invalidate(nodeID)
+		vmID, err = s.lookupNode(ctx, nodeID)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if vmID == staleVMID {
+		s.nodeVMs(ctx).Invalidate(nodeID)
+		return 0, fmt.Errorf("node %s still resolves to missing VM %d", nodeID, staleVMID)
+	}
+
+	logging.Klog(ctx).Infof("Node '%s' re-resolved from stale VM ID %d to VM ID %d", nodeID, staleVMID, vmID)
+	return vmID, nil
 }
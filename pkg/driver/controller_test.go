@@ -2,37 +2,33 @@ package driver
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/quick"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	emmasdk "github.com/emma-community/emma-go-sdk"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/emma-csi-driver/internal/fakeemma"
+	"github.com/emma-csi-driver/pkg/emma"
+	"github.com/emma-csi-driver/pkg/kube"
+	"github.com/emma-csi-driver/pkg/notify"
 )
 
-// mockEmmaClient is a mock implementation of the Emma API client for testing
-type mockEmmaClient struct {
-	createVolumeFunc            func(ctx context.Context, name string, sizeGB int32, volumeType string, dataCenterID string) (*mockVolume, error)
-	getVolumeFunc               func(ctx context.Context, volumeID int32) (*mockVolume, error)
-	deleteVolumeFunc            func(ctx context.Context, volumeID int32) error
-	attachVolumeFunc            func(ctx context.Context, vmID int32, volumeID int32) error
-	detachVolumeFunc            func(ctx context.Context, vmID int32, volumeID int32) error
-	resizeVolumeFunc            func(ctx context.Context, volumeID int32, newSizeGB int32) error
-	validateDataCenterFunc      func(ctx context.Context, dataCenterID string) error
-	waitForVolumeStatusFunc     func(ctx context.Context, volumeID int32, status string, timeout interface{}) error
-	waitForVolumeAttachmentFunc func(ctx context.Context, volumeID int32, vmID int32, timeout interface{}) error
-	waitForVolumeDetachmentFunc func(ctx context.Context, volumeID int32, timeout interface{}) error
-	listVolumesFunc             func(ctx context.Context) ([]*mockVolume, error)
-}
-
-type mockVolume struct {
-	ID           int32
-	Name         string
-	SizeGB       int32
-	Type         string
-	Status       string
-	AttachedToID *int32
-	DataCenterID string
-}
+// gib is one binary gigabyte in bytes
+const gib = int64(SizeUnitGiB)
 
 // TestControllerCreateVolume tests the CreateVolume method
 func TestControllerCreateVolume(t *testing.T) {
@@ -242,6 +238,36 @@ func TestValidateVolumeCapabilities(t *testing.T) {
 			},
 			expectError: false,
 		},
+		{
+			name: "valid ReadWriteOncePod",
+			caps: []*csi.VolumeCapability{
+				{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{
+							FsType: "ext4",
+						},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+					},
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "valid single node multi writer block",
+			caps: []*csi.VolumeCapability{
+				{
+					AccessType: &csi.VolumeCapability_Block{
+						Block: &csi.VolumeCapability_BlockVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
+					},
+				},
+			},
+			expectError: false,
+		},
 		{
 			name: "invalid access mode ReadWriteMany",
 			caps: []*csi.VolumeCapability{
@@ -309,10 +335,15 @@ func TestControllerGetCapabilities(t *testing.T) {
 
 	// Verify expected capabilities
 	expectedCaps := map[csi.ControllerServiceCapability_RPC_Type]bool{
-		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME:     true,
-		csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME: true,
-		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME:            true,
-		csi.ControllerServiceCapability_RPC_LIST_VOLUMES:             true,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME:         true,
+		csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME:     true,
+		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME:                true,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES:                 true,
+		csi.ControllerServiceCapability_RPC_GET_CAPACITY:                 true,
+		csi.ControllerServiceCapability_RPC_GET_VOLUME:                   true,
+		csi.ControllerServiceCapability_RPC_VOLUME_CONDITION:             true,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES: true,
+		csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER:     true,
 	}
 
 	for _, cap := range resp.Capabilities {
@@ -340,16 +371,6 @@ func TestControllerUnimplementedMethods(t *testing.T) {
 
 	service := NewControllerService(driver, nil)
 
-	t.Run("GetCapacity", func(t *testing.T) {
-		_, err := service.GetCapacity(context.Background(), &csi.GetCapacityRequest{})
-		if err == nil {
-			t.Error("expected error for unimplemented method")
-		}
-		if status.Code(err) != codes.Unimplemented {
-			t.Errorf("expected Unimplemented error, got %v", status.Code(err))
-		}
-	})
-
 	t.Run("CreateSnapshot", func(t *testing.T) {
 		_, err := service.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{})
 		if err == nil {
@@ -370,3 +391,1668 @@ func TestControllerUnimplementedMethods(t *testing.T) {
 		}
 	})
 }
+
+// TestControllerExpandVolumeValidation tests request validation that happens before any Emma API call
+func TestControllerExpandVolumeValidation(t *testing.T) {
+	driver := &Driver{
+		name:    "csi.emma.ms",
+		version: "1.0.0",
+	}
+
+	service := NewControllerService(driver, nil)
+
+	tests := []struct {
+		name      string
+		req       *csi.ControllerExpandVolumeRequest
+		errorCode codes.Code
+	}{
+		{
+			name: "missing volume ID",
+			req: &csi.ControllerExpandVolumeRequest{
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 10 * gib},
+			},
+			errorCode: codes.InvalidArgument,
+		},
+		{
+			name: "missing capacity range",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId: "123",
+			},
+			errorCode: codes.InvalidArgument,
+		},
+		{
+			name: "unsupported access mode",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId:      "123",
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 10 * gib},
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+					},
+				},
+			},
+			errorCode: codes.InvalidArgument,
+		},
+		{
+			name: "unsupported filesystem type",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId:      "123",
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 10 * gib},
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{FsType: "ntfs"},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+			},
+			errorCode: codes.InvalidArgument,
+		},
+		{
+			name: "invalid volume ID",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId:      "not-a-number",
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 10 * gib},
+			},
+			errorCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.ControllerExpandVolume(context.Background(), tt.req)
+			if err == nil {
+				t.Fatal("expected error but got none")
+			}
+			if status.Code(err) != tt.errorCode {
+				t.Errorf("expected error code %v, got %v", tt.errorCode, status.Code(err))
+			}
+		})
+	}
+}
+
+// TestValidateContentSource tests that unsupported content sources are rejected
+func TestValidateContentSource(t *testing.T) {
+	tests := []struct {
+		name        string
+		source      *csi.VolumeContentSource
+		expectError bool
+	}{
+		{name: "no content source"},
+		{
+			name: "snapshot source",
+			source: &csi.VolumeContentSource{
+				Type: &csi.VolumeContentSource_Snapshot{
+					Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: "snap-1"},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "volume source",
+			source: &csi.VolumeContentSource{
+				Type: &csi.VolumeContentSource_Volume{
+					Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: "123"},
+				},
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateContentSource(tt.source)
+			if tt.expectError {
+				if status.Code(err) != codes.InvalidArgument {
+					t.Errorf("expected InvalidArgument, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// TestExpansionSizeGB tests capacity rounding, no-op and shrink detection for volume expansion
+func TestExpansionSizeGB(t *testing.T) {
+	tests := []struct {
+		name        string
+		rounding    SizeRoundingPolicy
+		currentGB   int32
+		capRange    *csi.CapacityRange
+		expectedGB  int32
+		expectNoop  bool
+		expectError bool
+		errorCode   codes.Code
+	}{
+		{
+			name:       "grow to exact GB",
+			currentGB:  10,
+			capRange:   &csi.CapacityRange{RequiredBytes: 20 * gib},
+			expectedGB: 20,
+		},
+		{
+			name:       "partial GB rounds up",
+			currentGB:  10,
+			capRange:   &csi.CapacityRange{RequiredBytes: 10*gib + 1},
+			expectedGB: 11,
+		},
+		{
+			name:       "limit bytes used when required bytes unset",
+			currentGB:  10,
+			capRange:   &csi.CapacityRange{LimitBytes: 16 * gib},
+			expectedGB: 16,
+		},
+		{
+			name:       "equal size is a no-op",
+			currentGB:  10,
+			capRange:   &csi.CapacityRange{RequiredBytes: 10 * gib},
+			expectedGB: 10,
+			expectNoop: true,
+		},
+		{
+			name:       "equal size after rounding is a no-op",
+			currentGB:  10,
+			capRange:   &csi.CapacityRange{RequiredBytes: 10*gib - 1},
+			expectedGB: 10,
+			expectNoop: true,
+		},
+		{
+			name:        "shrink is rejected",
+			currentGB:   10,
+			capRange:    &csi.CapacityRange{RequiredBytes: 5 * gib},
+			expectError: true,
+			errorCode:   codes.OutOfRange,
+		},
+		{
+			name:        "rounded size above limit is rejected",
+			currentGB:   10,
+			capRange:    &csi.CapacityRange{RequiredBytes: 12*gib + 1, LimitBytes: 12*gib + 1},
+			expectError: true,
+			errorCode:   codes.OutOfRange,
+		},
+		{
+			name:        "zero capacity",
+			currentGB:   10,
+			capRange:    &csi.CapacityRange{},
+			expectError: true,
+			errorCode:   codes.InvalidArgument,
+		},
+		{
+			name:       "rounded up to a power of 2",
+			rounding:   SizeRoundingRoundUp,
+			currentGB:  8,
+			capRange:   &csi.CapacityRange{RequiredBytes: 10 * gib},
+			expectedGB: 16,
+		},
+		{
+			name:       "rounded size already provisioned is a no-op",
+			rounding:   SizeRoundingRoundUp,
+			currentGB:  16,
+			capRange:   &csi.CapacityRange{RequiredBytes: 12 * gib},
+			expectedGB: 16,
+			expectNoop: true,
+		},
+		{
+			name:        "rounded size above the limit is rejected",
+			rounding:    SizeRoundingRoundUp,
+			currentGB:   8,
+			capRange:    &csi.CapacityRange{RequiredBytes: 10 * gib, LimitBytes: 12 * gib},
+			expectError: true,
+			errorCode:   codes.OutOfRange,
+		},
+		{
+			name:        "strict rounding rejects other sizes",
+			rounding:    SizeRoundingStrict,
+			currentGB:   8,
+			capRange:    &csi.CapacityRange{RequiredBytes: 10 * gib},
+			expectError: true,
+			errorCode:   codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewControllerService(&Driver{name: "csi.emma.ms"}, fakeemma.New())
+			service.SetSizeRounding(SizeRoundingExact)
+			if tt.rounding != "" {
+				service.SetSizeRounding(tt.rounding)
+			}
+			sizeGB, noop, err := service.expansionSizeGB(tt.currentGB, tt.capRange)
+			if tt.expectError {
+				if err == nil {
+					t.Fatal("expected error but got none")
+				}
+				if status.Code(err) != tt.errorCode {
+					t.Errorf("expected error code %v, got %v", tt.errorCode, status.Code(err))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if sizeGB != tt.expectedGB {
+				t.Errorf("expected size %dGB, got %dGB", tt.expectedGB, sizeGB)
+			}
+			if noop != tt.expectNoop {
+				t.Errorf("expected noop=%v, got %v", tt.expectNoop, noop)
+			}
+		})
+	}
+}
+
+// TestExpansionTargetStatus tests the status awaited after a resize
+func TestExpansionTargetStatus(t *testing.T) {
+	vmID := int32(456)
+
+	if got := expansionTargetStatus(&emma.VolumeResponse{ID: 123}); got != "AVAILABLE" {
+		t.Errorf("expected AVAILABLE for detached volume, got %s", got)
+	}
+	if got := expansionTargetStatus(&emma.VolumeResponse{ID: 123, AttachedToID: &vmID}); got != "ACTIVE" {
+		t.Errorf("expected ACTIVE for attached volume, got %s", got)
+	}
+}
+
+// TestBuildVolumeContext tests that performance characteristics are surfaced in the volume context
+func TestBuildVolumeContext(t *testing.T) {
+	iops := int32(3000)
+	throughput := int32(125)
+
+	t.Run("without performance data", func(t *testing.T) {
+		ctx := buildVolumeContext(&emma.VolumeResponse{Type: "ssd", DataCenterID: "aws-eu-west-2"})
+		if ctx[paramType] != "ssd" || ctx[paramDataCenterID] != "aws-eu-west-2" {
+			t.Errorf("unexpected volume context: %v", ctx)
+		}
+		if _, ok := ctx[volumeContextIOPS]; ok {
+			t.Errorf("expected no %s key, got %v", volumeContextIOPS, ctx)
+		}
+		if _, ok := ctx[volumeContextThroughput]; ok {
+			t.Errorf("expected no %s key, got %v", volumeContextThroughput, ctx)
+		}
+		if _, ok := ctx[volumeContextProviderVolumeID]; ok {
+			t.Errorf("expected no %s key, got %v", volumeContextProviderVolumeID, ctx)
+		}
+	})
+
+	t.Run("with performance data", func(t *testing.T) {
+		ctx := buildVolumeContext(&emma.VolumeResponse{
+			Type:             "ssd-plus",
+			DataCenterID:     "aws-eu-west-2",
+			IOPS:             &iops,
+			ThroughputMBps:   &throughput,
+			ProviderVolumeID: "vol-0abc",
+		})
+		if ctx[volumeContextIOPS] != "3000" {
+			t.Errorf("expected iops 3000, got %q", ctx[volumeContextIOPS])
+		}
+		if ctx[volumeContextThroughput] != "125" {
+			t.Errorf("expected throughput 125, got %q", ctx[volumeContextThroughput])
+		}
+		if ctx[volumeContextProviderVolumeID] != "vol-0abc" {
+			t.Errorf("expected provider volume ID vol-0abc, got %q", ctx[volumeContextProviderVolumeID])
+		}
+	})
+}
+
+// TestRoundUpToPowerOfTwo tests rounding sizes up to the powers of two Emma accepts
+func TestRoundUpToPowerOfTwo(t *testing.T) {
+	tests := []struct {
+		size     int32
+		expected int32
+	}{
+		{math.MinInt32, 1},
+		{-1, 1},
+		{0, 1},
+		{1, 1},
+		{2, 2},
+		{3, 4},
+		{5, 8},
+		{9, 16},
+		{17, 32},
+		{33, 64},
+		{65, 128},
+		{129, 256},
+		{255, 256},
+		{257, 512},
+		{513, 1024},
+		{1023, 1024},
+		{1024, 1024},
+		{1025, 2048},
+		{2047, 2048},
+		{2048, 2048},
+		{2049, 2048},
+		{4096, 2048},
+		{1 << 30, 2048},
+		{1<<30 + 1, 2048},
+		{math.MaxInt32, 2048},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(int(tt.size)), func(t *testing.T) {
+			if got := roundUpToPowerOfTwo(tt.size); got != tt.expected {
+				t.Errorf("roundUpToPowerOfTwo(%d) = %d, expected %d", tt.size, got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestRoundUpToPowerOfTwoProperties checks that every size rounds to a power of two no
+// larger than the maximum, and never below the size unless capped
+func TestRoundUpToPowerOfTwoProperties(t *testing.T) {
+	property := func(size int32) bool {
+		got := roundUpToPowerOfTwo(size)
+		if got < 1 || got > maxVolumeSizeGB || got&(got-1) != 0 {
+			return false
+		}
+		if size <= maxVolumeSizeGB && got < size {
+			return false
+		}
+		// The smallest such power of two: half of it would be too small
+		return got == 1 || got/2 < size
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 10000}); err != nil {
+		t.Error(err)
+	}
+
+	// Every size up to the maximum, which random sampling rarely hits
+	for size := int32(1); size <= maxVolumeSizeGB; size++ {
+		if !property(size) {
+			t.Fatalf("property failed for size %d: got %d", size, roundUpToPowerOfTwo(size))
+		}
+	}
+}
+
+// TestNodeVMCache tests caching and invalidation of node name resolutions
+func TestNodeVMCache(t *testing.T) {
+	driver := &Driver{
+		name:    "csi.emma.ms",
+		version: "1.0.0",
+	}
+
+	service := NewControllerService(driver, nil)
+	service.nodeCache.Set("worker-1", 101)
+
+	vmID, err := service.resolveNodeIDToVMID(context.Background(), "worker-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if vmID != 101 {
+		t.Errorf("expected cached VM ID 101, got %d", vmID)
+	}
+
+	service.nodeCache.Invalidate("worker-1")
+	if _, ok := service.nodeCache.Get("worker-1"); ok {
+		t.Error("expected cache entry to be invalidated")
+	}
+
+	// Numeric node IDs are VM IDs and are never re-resolved
+	if _, err := service.reresolveNodeID(context.Background(), "101", 101); err == nil {
+		t.Error("expected error when re-resolving a numeric node ID")
+	}
+}
+
+// TestCheckNodeNotDeleting tests fast-fail of attaches to nodes being removed
+func TestCheckNodeNotDeleting(t *testing.T) {
+	now := metav1.Now()
+	client := fake.NewSimpleClientset(
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "healthy"}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "deleting", DeletionTimestamp: &now, Finalizers: []string{"test"}}},
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "scaling-down"},
+			Spec: v1.NodeSpec{
+				Taints: []v1.Taint{{Key: "ToBeDeletedByClusterAutoscaler", Effect: v1.TaintEffectNoSchedule}},
+			},
+		},
+	)
+
+	driver := &Driver{
+		name:    "csi.emma.ms",
+		version: "1.0.0",
+	}
+
+	service := NewControllerService(driver, nil)
+	service.SetKubeClient(client)
+
+	tests := []struct {
+		name        string
+		nodeID      string
+		expectError bool
+	}{
+		{name: "healthy node", nodeID: "healthy"},
+		{name: "numeric VM ID is not checked", nodeID: "12345"},
+		{name: "node with deletion timestamp", nodeID: "deleting", expectError: true},
+		{name: "node tainted by cluster autoscaler", nodeID: "scaling-down", expectError: true},
+		{name: "node no longer exists", nodeID: "gone", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := service.checkNodeNotDeleting(context.Background(), tt.nodeID)
+			if tt.expectError {
+				if status.Code(err) != codes.Unavailable {
+					t.Errorf("expected Unavailable error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// TestCandidateDataCenters tests fallback datacenter selection
+func TestCandidateDataCenters(t *testing.T) {
+	topology := func(dcs ...string) *csi.TopologyRequirement {
+		req := &csi.TopologyRequirement{}
+		for _, dc := range dcs {
+			req.Requisite = append(req.Requisite, &csi.Topology{
+				Segments: map[string]string{TopologyKeyDataCenter: dc},
+			})
+		}
+		return req
+	}
+
+	tests := []struct {
+		name         string
+		primary      string
+		fallbacks    string
+		requirements *csi.TopologyRequirement
+		expected     []string
+	}{
+		{
+			name:     "no fallbacks",
+			primary:  "dc-1",
+			expected: []string{"dc-1"},
+		},
+		{
+			name:      "fallbacks in order without duplicates",
+			primary:   "dc-1",
+			fallbacks: "dc-2, dc-1,,dc-3,dc-2",
+			expected:  []string{"dc-1", "dc-2", "dc-3"},
+		},
+		{
+			name:         "fallbacks filtered by topology",
+			primary:      "dc-1",
+			fallbacks:    "dc-2,dc-3",
+			requirements: topology("dc-1", "dc-3"),
+			expected:     []string{"dc-1", "dc-3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := candidateDataCenters(tt.primary, tt.fallbacks, tt.requirements)
+			if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestSelectDataCenter tests datacenter selection from parameters and topology requirements
+func TestSelectDataCenter(t *testing.T) {
+	segment := func(dc string) *csi.Topology {
+		return &csi.Topology{Segments: map[string]string{TopologyKeyDataCenter: dc}}
+	}
+
+	tests := []struct {
+		name         string
+		param        string
+		allowed      []string
+		defaultDC    string
+		requirements *csi.TopologyRequirement
+		expected     string
+		expectError  bool
+	}{
+		{name: "parameter only", param: "dc-1", expected: "dc-1"},
+		{
+			name:         "parameter within requisite",
+			param:        "dc-2",
+			requirements: &csi.TopologyRequirement{Requisite: []*csi.Topology{segment("dc-1"), segment("dc-2")}},
+			expected:     "dc-2",
+		},
+		{
+			name:         "parameter outside requisite",
+			param:        "dc-3",
+			requirements: &csi.TopologyRequirement{Requisite: []*csi.Topology{segment("dc-1")}},
+			expectError:  true,
+		},
+		{
+			name: "preferred topology",
+			requirements: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{segment("dc-1"), segment("dc-2")},
+				Preferred: []*csi.Topology{segment("dc-2"), segment("dc-1")},
+			},
+			expected: "dc-2",
+		},
+		{
+			name:         "requisite topology",
+			requirements: &csi.TopologyRequirement{Requisite: []*csi.Topology{segment("dc-1")}},
+			expected:     "dc-1",
+		},
+		{
+			name:         "allowed matches preferred",
+			allowed:      []string{"dc-3", "dc-1"},
+			requirements: &csi.TopologyRequirement{Preferred: []*csi.Topology{segment("dc-2"), segment("dc-1")}},
+			expected:     "dc-1",
+		},
+		{
+			name:         "allowed matches no topology",
+			allowed:      []string{"dc-3"},
+			requirements: &csi.TopologyRequirement{Requisite: []*csi.Topology{segment("dc-1")}},
+			expectError:  true,
+		},
+		{name: "default datacenter", defaultDC: "dc-4", expected: "dc-4"},
+		{
+			name:         "topology before default",
+			defaultDC:    "dc-4",
+			requirements: &csi.TopologyRequirement{Requisite: []*csi.Topology{segment("dc-1")}},
+			expected:     "dc-1",
+		},
+		{name: "default not allowed", allowed: []string{"dc-3", "dc-1"}, defaultDC: "dc-4", expected: "dc-3"},
+		{name: "default allowed", allowed: []string{"dc-3", "dc-4"}, defaultDC: "dc-4", expected: "dc-4"},
+		{name: "nothing", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectDataCenter(tt.param, tt.allowed, tt.defaultDC, tt.requirements)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestExistingVolumeConflict tests matching a retried CreateVolume against an existing volume
+func TestExistingVolumeConflict(t *testing.T) {
+	volume := &emma.VolumeResponse{ID: 9, Name: "pvc-1", SizeGB: 4, Type: "ssd", DataCenterID: "dc-1"}
+
+	tests := []struct {
+		name        string
+		capRange    *csi.CapacityRange
+		volumeType  string
+		dataCenters []string
+		expectError bool
+	}{
+		{name: "rounded up size matches", capRange: &csi.CapacityRange{RequiredBytes: 3 * gib}, volumeType: "ssd", dataCenters: []string{"dc-1"}},
+		{name: "exact size matches", capRange: &csi.CapacityRange{RequiredBytes: 4 * gib, LimitBytes: 4 * gib}, volumeType: "SSD", dataCenters: []string{"dc-1"}},
+		{name: "fallback datacenter matches", capRange: &csi.CapacityRange{RequiredBytes: 4 * gib}, volumeType: "ssd", dataCenters: []string{"dc-2", "dc-1"}},
+		{name: "larger size requested", capRange: &csi.CapacityRange{RequiredBytes: 8 * gib}, volumeType: "ssd", dataCenters: []string{"dc-1"}, expectError: true},
+		{name: "above limit", capRange: &csi.CapacityRange{RequiredBytes: 1 * gib, LimitBytes: 2 * gib}, volumeType: "ssd", dataCenters: []string{"dc-1"}, expectError: true},
+		{name: "different type", capRange: &csi.CapacityRange{RequiredBytes: 4 * gib}, volumeType: "hdd", dataCenters: []string{"dc-1"}, expectError: true},
+		{name: "different datacenter", capRange: &csi.CapacityRange{RequiredBytes: 4 * gib}, volumeType: "ssd", dataCenters: []string{"dc-2"}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := existingVolumeConflict(volume, tt.capRange, tt.volumeType, tt.dataCenters, SizeUnitGiB)
+			if tt.expectError && err == nil {
+				t.Error("expected conflict, got nil")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("unexpected conflict: %v", err)
+			}
+		})
+	}
+}
+
+// TestVolumeCondition tests that failed Emma volume states are reported as abnormal
+func TestVolumeCondition(t *testing.T) {
+	tests := []struct {
+		status   string
+		abnormal bool
+	}{
+		{status: "AVAILABLE", abnormal: false},
+		{status: "ACTIVE", abnormal: false},
+		{status: "BUSY", abnormal: false},
+		{status: "FAILED", abnormal: true},
+		{status: "ERROR", abnormal: true},
+		{status: "failed", abnormal: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			condition := volumeCondition(&emma.VolumeResponse{ID: 42, Status: tt.status})
+			if condition.GetAbnormal() != tt.abnormal {
+				t.Errorf("expected abnormal=%v, got %v", tt.abnormal, condition.GetAbnormal())
+			}
+			if !strings.Contains(condition.GetMessage(), tt.status) {
+				t.Errorf("expected message to contain %q, got %q", tt.status, condition.GetMessage())
+			}
+		})
+	}
+}
+
+// TestMaxVolumeConfigSize tests capacity selection from Emma volume configurations
+func TestMaxVolumeConfigSize(t *testing.T) {
+	config := func(dc, volumeType string, gb int32) emmasdk.VolumeConfiguration {
+		return emmasdk.VolumeConfiguration{DataCenterId: &dc, VolumeType: &volumeType, VolumeGb: &gb}
+	}
+	configs := []emmasdk.VolumeConfiguration{
+		config("dc-1", "ssd", 100),
+		config("dc-1", "ssd", 1000),
+		config("dc-1", "hdd", 4000),
+		config("dc-2", "ssd", 500),
+	}
+
+	tests := []struct {
+		name       string
+		dataCenter string
+		volumeType string
+		expected   int32
+	}{
+		{name: "largest in datacenter", dataCenter: "dc-1", volumeType: "ssd", expected: 1000},
+		{name: "type filter", dataCenter: "dc-1", volumeType: "hdd", expected: 4000},
+		{name: "type is case insensitive", dataCenter: "dc-2", volumeType: "SSD", expected: 500},
+		{name: "any datacenter", volumeType: "ssd", expected: 1000},
+		{name: "type not offered", dataCenter: "dc-2", volumeType: "hdd", expected: 0},
+		{name: "unknown datacenter", dataCenter: "dc-3", volumeType: "ssd", expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := maxVolumeConfigSize(configs, tt.dataCenter, tt.volumeType); got != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestControllerGetCapacity tests that the largest offered size is reported as the maximum
+// volume size, not as free capacity
+func TestControllerGetCapacity(t *testing.T) {
+	config := func(dc, volumeType string, gb int32) emmasdk.VolumeConfiguration {
+		return emmasdk.VolumeConfiguration{DataCenterId: &dc, VolumeType: &volumeType, VolumeGb: &gb}
+	}
+	fakeAPI := fakeemma.New()
+	fakeAPI.Configs = []emmasdk.VolumeConfiguration{config("dc-1", "ssd", 8), config("dc-1", "ssd", 1024)}
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, fakeAPI)
+
+	tests := []struct {
+		name     string
+		segments map[string]string
+		expected int64
+	}{
+		{name: "offered", segments: map[string]string{TopologyKeyDataCenter: "dc-1"}, expected: 1024 * gib},
+		{name: "not offered", segments: map[string]string{TopologyKeyDataCenter: "dc-2"}, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := service.GetCapacity(context.Background(), &csi.GetCapacityRequest{
+				AccessibleTopology: &csi.Topology{Segments: tt.segments},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.GetMaximumVolumeSize() == nil || resp.GetMaximumVolumeSize().GetValue() != tt.expected {
+				t.Errorf("expected maximum volume size %d, got %v", tt.expected, resp.GetMaximumVolumeSize())
+			}
+			if resp.GetAvailableCapacity() != 0 {
+				t.Errorf("expected no available capacity, got %d", resp.GetAvailableCapacity())
+			}
+		})
+	}
+}
+
+// TestResolveNodeIDFromLabel tests VM ID resolution from the node label set by the node plugin
+func TestResolveNodeIDFromLabel(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "labeled", Labels: map[string]string{kube.LabelVMID: "4242"}}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "invalid", Labels: map[string]string{kube.LabelVMID: "abc"}}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "unlabeled"}},
+	)
+
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, nil)
+	service.SetKubeClient(client)
+	service.SetNodeResolutionMode(NodeResolutionAnnotation)
+
+	tests := []struct {
+		name     string
+		nodeID   string
+		expected int32
+		found    bool
+	}{
+		{name: "labeled node", nodeID: "labeled", expected: 4242, found: true},
+		{name: "invalid label", nodeID: "invalid"},
+		{name: "unlabeled node", nodeID: "unlabeled"},
+		{name: "missing node", nodeID: "gone"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vmID, found := service.vmIDFromNodeLabel(context.Background(), tt.nodeID)
+			if found != tt.found || vmID != tt.expected {
+				t.Errorf("expected (%d, %v), got (%d, %v)", tt.expected, tt.found, vmID, found)
+			}
+		})
+	}
+
+	vmID, err := service.resolveNodeIDToVMID(context.Background(), "labeled")
+	if err != nil || vmID != 4242 {
+		t.Fatalf("expected VM ID 4242, got %d (err: %v)", vmID, err)
+	}
+	if cached, ok := service.nodeCache.Get("labeled"); !ok || cached != 4242 {
+		t.Errorf("expected label resolution to be cached, got %d, %v", cached, ok)
+	}
+}
+
+// TestWarmNodeCache tests pre-resolving labeled cluster nodes at startup
+func TestWarmNodeCache(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1", Labels: map[string]string{kube.LabelVMID: "101"}}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-2", Labels: map[string]string{kube.LabelVMID: "102"}}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "invalid", Labels: map[string]string{kube.LabelVMID: "abc"}}},
+	)
+
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, nil)
+	if resolved, err := service.WarmNodeCache(context.Background()); err != nil || resolved != 0 {
+		t.Fatalf("expected no-op without Kubernetes client, got %d (err: %v)", resolved, err)
+	}
+
+	service.SetKubeClient(client)
+	service.SetNodeResolutionMode(NodeResolutionAnnotation)
+	resolved, err := service.WarmNodeCache(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resolved != 2 {
+		t.Errorf("expected 2 nodes resolved, got %d", resolved)
+	}
+	if vmID, ok := service.nodeCache.Get("worker-2"); !ok || vmID != 102 {
+		t.Errorf("expected worker-2 cached as 102, got %d, %v", vmID, ok)
+	}
+	if _, ok := service.nodeCache.Get("invalid"); ok {
+		t.Error("expected node with invalid label not to be cached")
+	}
+
+	// Other modes do not trust labels the node plugin may have written
+	service = NewControllerService(&Driver{name: "csi.emma.ms"}, nil)
+	service.SetKubeClient(client)
+	service.SetNodeResolutionMode(NodeResolutionVMs)
+	if resolved, err := service.WarmNodeCache(context.Background()); err != nil || resolved != 0 {
+		t.Errorf("expected no label resolutions in mode %s, got %d (err: %v)", NodeResolutionVMs, resolved, err)
+	}
+}
+
+// TestAttachHistory tests last attached node tracking
+func TestAttachHistory(t *testing.T) {
+	history := newAttachHistory()
+
+	if _, ok := history.Last("1"); ok {
+		t.Error("expected no history for new volume")
+	}
+	if history.Record("1", "worker-1") {
+		t.Error("first attachment should not count as a node change")
+	}
+	if history.Record("1", "worker-1") {
+		t.Error("re-attachment to the same node should not count as a node change")
+	}
+	if !history.Record("1", "worker-2") {
+		t.Error("attachment to another node should count as a node change")
+	}
+	if node, ok := history.Last("1"); !ok || node != "worker-2" {
+		t.Errorf("expected last node worker-2, got %q", node)
+	}
+
+	history.Forget("1")
+	if _, ok := history.Last("1"); ok {
+		t.Error("expected history to be forgotten")
+	}
+}
+
+// TestAttachHistoryTimes tests attach time tracking across re-attachment and detachment
+func TestAttachHistoryTimes(t *testing.T) {
+	history := newAttachHistory()
+	now := time.Unix(1000, 0)
+	history.now = func() time.Time { return now }
+
+	history.Record("1", "worker-1")
+	now = now.Add(time.Minute)
+	history.Record("1", "worker-1")
+	if attachedAt, ok := history.AttachedAt("1"); !ok || !attachedAt.Equal(time.Unix(1000, 0)) {
+		t.Errorf("re-attachment to the same node should keep attach time, got %v", attachedAt)
+	}
+
+	history.Record("1", "worker-2")
+	if attachedAt, _ := history.AttachedAt("1"); !attachedAt.Equal(now) {
+		t.Errorf("attachment to another node should reset attach time, got %v", attachedAt)
+	}
+
+	history.Detached("1")
+	if _, ok := history.AttachedAt("1"); ok {
+		t.Error("expected no attach time after detach")
+	}
+	if node, ok := history.Last("1"); !ok || node != "worker-2" {
+		t.Errorf("detach should keep last node, got %q", node)
+	}
+
+	history.Record("2", "worker-1")
+	if times := history.AttachTimes(); len(times) != 1 {
+		t.Errorf("expected 1 attached volume, got %d", len(times))
+	}
+}
+
+// TestCSIVolumeWithoutAttachHistory tests that volumes attached before the controller
+// started are listed without a made-up attach time or last node
+func TestCSIVolumeWithoutAttachHistory(t *testing.T) {
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, fakeemma.New())
+	vmID := int32(7)
+
+	volume := service.csiVolume(&emma.VolumeResponse{ID: 5, SizeGB: 4, Status: "ACTIVE", AttachedToID: &vmID})
+	for _, key := range []string{volumeContextAttachedAt, volumeContextLastAttachedNode} {
+		if value, ok := volume.GetVolumeContext()[key]; ok {
+			t.Errorf("expected no %s without history, got %q", key, value)
+		}
+	}
+	if times := service.AttachTimes(); len(times) != 0 {
+		t.Errorf("expected no attach ages without history, got %v", times)
+	}
+}
+
+// TestDeleteExecutor tests DeleteVolume parallelism and API call budget
+func TestDeleteExecutor(t *testing.T) {
+	executor := newDeleteExecutor(1, 0, 0)
+
+	release, err := executor.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := executor.acquire(ctx); err == nil {
+		t.Error("expected error when all delete workers are busy")
+	}
+
+	release()
+	release, err = executor.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("expected free worker after release, got %v", err)
+	}
+	release()
+
+	// Without a budget API calls are never delayed
+	if err := executor.waitBudget(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// With a budget the burst is consumed and the next call has to wait
+	budgeted := newDeleteExecutor(1, 0.1, 1)
+	if err := budgeted.waitBudget(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := budgeted.waitBudget(ctx); err == nil {
+		t.Error("expected error when the API call budget is exhausted")
+	}
+}
+
+// mountCapability is a single node writer filesystem volume capability
+func mountCapability() *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+}
+
+// TestControllerCreateVolumeWithFake tests CreateVolume against the fake Emma API
+func TestControllerCreateVolumeWithFake(t *testing.T) {
+	fakeAPI := fakeemma.New()
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, fakeAPI)
+
+	req := &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 3 * gib},
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+		Parameters:         map[string]string{paramType: "ssd", paramDataCenterID: "dc-1"},
+	}
+
+	resp, err := service.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	volume := resp.GetVolume()
+	if volume.GetCapacityBytes() != 4*gib {
+		t.Errorf("expected size rounded up to 4GiB, got %d", volume.GetCapacityBytes())
+	}
+	if volume.GetVolumeContext()[volumeContextExpectFormatted] != "false" {
+		t.Errorf("expected new volume to be formatted on first use, got context %v", volume.GetVolumeContext())
+	}
+	if ctx := volume.GetVolumeContext(); ctx[volumeContextRequestedBytes] != strconv.FormatInt(3*gib, 10) || ctx[volumeContextProvisionedBytes] != strconv.FormatInt(4*gib, 10) {
+		t.Errorf("expected requested and provisioned bytes in the volume context, got %v", ctx)
+	}
+	if note := volume.GetVolumeContext()[volumeContextSizeNote]; !strings.Contains(note, "provisioned 4Gi for a 3Gi request (1Gi overhead)") {
+		t.Errorf("expected a rounding note, got %q", note)
+	}
+	if dc := volume.GetAccessibleTopology()[0].GetSegments()[TopologyKeyDataCenter]; dc != "dc-1" {
+		t.Errorf("expected accessible topology dc-1, got %q", dc)
+	}
+
+	// A retry returns the same volume instead of creating another
+	retry, err := service.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error on retry: %v", err)
+	}
+	if retry.GetVolume().GetVolumeId() != volume.GetVolumeId() {
+		t.Errorf("expected retry to return volume %s, got %s", volume.GetVolumeId(), retry.GetVolume().GetVolumeId())
+	}
+	if !reflect.DeepEqual(fakeAPI.Calls(), []string{"create pvc-1"}) {
+		t.Errorf("expected a single create call, got %v", fakeAPI.Calls())
+	}
+
+	// The same name with an incompatible size conflicts
+	req.CapacityRange = &csi.CapacityRange{RequiredBytes: 16 * gib}
+	if _, err := service.CreateVolume(context.Background(), req); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected AlreadyExists, got %v", err)
+	}
+
+	// Emma failures surface as errors and create nothing
+	fakeAPI.CreateErr = fmt.Errorf("boom")
+	req.Name = "pvc-2"
+	if _, err := service.CreateVolume(context.Background(), req); status.Code(err) != codes.Internal {
+		t.Errorf("expected Internal, got %v", err)
+	}
+}
+
+// TestControllerCreateVolumeOfferings tests that volume types and sizes Emma does not offer are rejected before creating
+func TestControllerCreateVolumeOfferings(t *testing.T) {
+	config := func(dc, volumeType string, gb int32) emmasdk.VolumeConfiguration {
+		return emmasdk.VolumeConfiguration{DataCenterId: &dc, VolumeType: &volumeType, VolumeGb: &gb}
+	}
+	fakeAPI := fakeemma.New()
+	fakeAPI.Configs = []emmasdk.VolumeConfiguration{config("dc-1", "ssd", 8), config("dc-1", "ssd", 1024)}
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, fakeAPI)
+
+	tests := []struct {
+		name       string
+		volumeType string
+		sizeGB     int64
+		dataCenter string
+		expected   codes.Code
+	}{
+		{name: "offered", volumeType: "ssd", sizeGB: 16, dataCenter: "dc-1", expected: codes.OK},
+		{name: "type not offered", volumeType: "hdd", sizeGB: 16, dataCenter: "dc-1", expected: codes.InvalidArgument},
+		{name: "size too large", volumeType: "ssd", sizeGB: 2048, dataCenter: "dc-1", expected: codes.InvalidArgument},
+		{name: "unlisted datacenter", volumeType: "hdd", sizeGB: 16, dataCenter: "dc-2", expected: codes.OK},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:               fmt.Sprintf("pvc-%d", i),
+				CapacityRange:      &csi.CapacityRange{RequiredBytes: tt.sizeGB * gib},
+				VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+				Parameters:         map[string]string{paramType: tt.volumeType, paramDataCenterID: tt.dataCenter},
+			})
+			if status.Code(err) != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+	if len(fakeAPI.Calls()) != 2 {
+		t.Errorf("expected only offered volumes to be created, got %v", fakeAPI.Calls())
+	}
+}
+
+// TestControllerCreateVolumeNodeParams tests that node staging parameters reach the volume context
+func TestControllerCreateVolumeNodeParams(t *testing.T) {
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, fakeemma.New())
+	create := func(name string, params map[string]string) (*csi.CreateVolumeResponse, error) {
+		params[paramDataCenterID] = "dc-1"
+		return service.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+			Name:               name,
+			CapacityRange:      &csi.CapacityRange{RequiredBytes: gib},
+			VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+			Parameters:         params,
+		})
+	}
+
+	resp, err := create("pvc-1", map[string]string{paramForceReformat: "true", paramUnstageFlush: "sync", paramEncrypted: "true"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	volumeContext := resp.GetVolume().GetVolumeContext()
+	if volumeContext[paramForceReformat] != "true" || volumeContext[paramUnstageFlush] != "sync" || volumeContext[paramEncrypted] != "true" {
+		t.Errorf("expected forceReformat, unstageFlush and encrypted in the volume context, got %v", volumeContext)
+	}
+
+	resp, err = create("pvc-2", map[string]string{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := resp.GetVolume().GetVolumeContext()[paramForceReformat]; ok {
+		t.Errorf("expected no %s without the parameter, got %v", paramForceReformat, resp.GetVolume().GetVolumeContext())
+	}
+
+	if _, err := create("pvc-3", map[string]string{paramForceReformat: "always"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for an invalid %s, got %v", paramForceReformat, err)
+	}
+	if _, err := create("pvc-4", map[string]string{paramEncrypted: "luks"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for an invalid %s, got %v", paramEncrypted, err)
+	}
+}
+
+// TestControllerPublishUnpublishWithFake tests attach and detach against the fake Emma API
+func TestControllerPublishUnpublishWithFake(t *testing.T) {
+	fakeAPI := fakeemma.New()
+	fakeAPI.AddVolume(emma.VolumeResponse{
+		ID: 5, Name: "pvc-1", SizeGB: 4, Type: "ssd", Status: "AVAILABLE", DataCenterID: "dc-1",
+		ProviderVolumeID: "vol-0abc", Attachment: &emma.VolumeAttachment{Serial: "vol0abc"},
+	})
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, fakeAPI)
+
+	var publishContext map[string]string
+	publish := func(nodeID string) error {
+		resp, err := service.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+			VolumeId:         "5",
+			NodeId:           nodeID,
+			VolumeCapability: mountCapability(),
+		})
+		publishContext = resp.GetPublishContext()
+		return err
+	}
+	expectedContext := map[string]string{"providerVolumeId": "vol-0abc", "serial": "vol0abc", "sizeBytes": strconv.FormatInt(4*gib, 10)}
+
+	if err := publish("7"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if attached := fakeAPI.Volume(5).AttachedToID; attached == nil || *attached != 7 {
+		t.Fatalf("expected volume attached to VM 7, got %v", attached)
+	}
+	if !reflect.DeepEqual(publishContext, expectedContext) {
+		t.Errorf("expected publish context %v, got %v", expectedContext, publishContext)
+	}
+
+	// Publishing again to the same node is idempotent
+	if err := publish("7"); err != nil {
+		t.Errorf("unexpected error republishing: %v", err)
+	}
+	if !reflect.DeepEqual(publishContext, expectedContext) {
+		t.Errorf("expected publish context %v on republish, got %v", expectedContext, publishContext)
+	}
+	if err := publish("8"); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition for another node, got %v", err)
+	}
+	if !reflect.DeepEqual(fakeAPI.Calls(), []string{"attach 5 7"}) {
+		t.Errorf("expected a single attach call, got %v", fakeAPI.Calls())
+	}
+
+	if _, err := service.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{VolumeId: "5", NodeId: "7"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if attached := fakeAPI.Volume(5).AttachedToID; attached != nil {
+		t.Errorf("expected volume detached, still attached to %d", *attached)
+	}
+}
+
+// TestControllerWaiterLimitBeforeAttachDetach tests that attach, detach and expansion are
+// rejected before Emma is called when no volume wait can be reserved
+func TestControllerWaiterLimitBeforeAttachDetach(t *testing.T) {
+	vmID := int32(7)
+	tests := []struct {
+		name string
+		call func(*ControllerService) error
+	}{
+		{
+			name: "publish",
+			call: func(service *ControllerService) error {
+				_, err := service.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{VolumeId: "5", NodeId: "8", VolumeCapability: mountCapability()})
+				return err
+			},
+		},
+		{
+			name: "unpublish",
+			call: func(service *ControllerService) error {
+				_, err := service.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{VolumeId: "6", NodeId: "7"})
+				return err
+			},
+		},
+		{
+			name: "delete attached",
+			call: func(service *ControllerService) error {
+				_, err := service.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "6"})
+				return err
+			},
+		},
+		{
+			name: "expand",
+			call: func(service *ControllerService) error {
+				_, err := service.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{VolumeId: "5", CapacityRange: &csi.CapacityRange{RequiredBytes: 8 * gib}})
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeAPI := fakeemma.New()
+			fakeAPI.AddVolume(emma.VolumeResponse{ID: 5, Name: "pvc-1", SizeGB: 4, Type: "ssd", Status: "AVAILABLE", DataCenterID: "dc-1"})
+			fakeAPI.AddVolume(emma.VolumeResponse{ID: 6, Name: "pvc-2", SizeGB: 4, Type: "ssd", Status: "ACTIVE", AttachedToID: &vmID, DataCenterID: "dc-1"})
+			fakeAPI.ReserveErr = fmt.Errorf("%w: limit of 1 reached", emma.ErrTooManyWaiters)
+			service := NewControllerService(&Driver{name: "csi.emma.ms"}, fakeAPI)
+			service.SetDeleteDetachGracePeriod(0)
+
+			if err := tt.call(service); status.Code(err) != codes.ResourceExhausted {
+				t.Errorf("expected ResourceExhausted, got %v", err)
+			}
+			if calls := fakeAPI.Calls(); len(calls) != 0 {
+				t.Errorf("expected no attach or detach, got %v", calls)
+			}
+		})
+	}
+}
+
+// dataCenterErrAPI is a fake Emma API failing volume creation in some datacenters
+type dataCenterErrAPI struct {
+	*fakeemma.API
+	errs map[string]error
+}
+
+func (f dataCenterErrAPI) CreateVolume(ctx context.Context, name string, sizeGB int32, volumeType string, dataCenterID string, tags []emma.VolumeTag) (*emma.VolumeResponse, error) {
+	if err := f.errs[dataCenterID]; err != nil {
+		return nil, err
+	}
+	return f.API.CreateVolume(ctx, name, sizeGB, volumeType, dataCenterID, tags)
+}
+
+// TestControllerCreateVolumeFallback tests that only a datacenter out of capacity makes
+// CreateVolume try the fallback datacenters
+func TestControllerCreateVolumeFallback(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		code       codes.Code
+		dataCenter string
+	}{
+		{name: "out of capacity", err: fmt.Errorf("%w: dc-1", emma.ErrDataCenterUnavailable), code: codes.OK, dataCenter: "dc-2"},
+		{name: "server error", err: &emma.APIError{Op: "create volume", StatusCode: http.StatusGatewayTimeout}, code: codes.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeAPI := fakeemma.New()
+			service := NewControllerService(&Driver{name: "csi.emma.ms"}, dataCenterErrAPI{API: fakeAPI, errs: map[string]error{"dc-1": tt.err}})
+
+			resp, err := service.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:               "pvc-1",
+				CapacityRange:      &csi.CapacityRange{RequiredBytes: 4 * gib},
+				VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+				Parameters:         map[string]string{paramType: "ssd", paramDataCenterID: "dc-1", paramFallbackDataCenterIDs: "dc-2"},
+			})
+			if status.Code(err) != tt.code {
+				t.Fatalf("expected %v, got %v", tt.code, err)
+			}
+			if err != nil {
+				if calls := fakeAPI.Calls(); len(calls) != 0 {
+					t.Errorf("expected no volume created in a fallback datacenter, got %v", calls)
+				}
+				return
+			}
+			if dc := resp.GetVolume().GetAccessibleTopology()[0].GetSegments()[TopologyKeyDataCenter]; dc != tt.dataCenter {
+				t.Errorf("expected the volume in %s, got %s", tt.dataCenter, dc)
+			}
+		})
+	}
+}
+
+// failingAttachAPI is a fake Emma API whose volumes go FAILED during the first attach waits
+type failingAttachAPI struct {
+	*fakeemma.API
+	failures  int
+	detachErr error
+}
+
+func (f *failingAttachAPI) WaitForVolumeAttachment(ctx context.Context, volumeID int32, vmID int32, timeout time.Duration) error {
+	if f.failures > 0 {
+		f.failures--
+		return fmt.Errorf("%w: volume %d", emma.ErrVolumeFailed, volumeID)
+	}
+	return f.API.WaitForVolumeAttachment(ctx, volumeID, vmID, timeout)
+}
+
+func (f *failingAttachAPI) DetachVolume(ctx context.Context, vmID int32, volumeID int32) error {
+	if f.detachErr != nil {
+		return f.detachErr
+	}
+	return f.API.DetachVolume(ctx, vmID, volumeID)
+}
+
+// TestControllerPublishRemediatesFailedAttach tests the detach and retry of an attach that
+// left the volume FAILED, and that errors report the remediation outcome
+func TestControllerPublishRemediatesFailedAttach(t *testing.T) {
+	tests := []struct {
+		name          string
+		failures      int
+		detachErr     error
+		remediation   bool
+		expectedCalls []string
+		expectedError string
+	}{
+		{
+			name:          "retry succeeds",
+			failures:      1,
+			remediation:   true,
+			expectedCalls: []string{"attach 5 7", "detach 5 7", "attach 5 7"},
+		},
+		{
+			name:          "retry fails again",
+			failures:      2,
+			remediation:   true,
+			expectedCalls: []string{"attach 5 7", "detach 5 7", "attach 5 7"},
+			expectedError: "volume failed during attach: attach remediation failed",
+		},
+		{
+			name:          "detach fails",
+			failures:      1,
+			detachErr:     errors.New("detach rejected"),
+			remediation:   true,
+			expectedCalls: []string{"attach 5 7"},
+			expectedError: "volume failed during attach: attach remediation failed: failed to detach volume: detach rejected",
+		},
+		{
+			name:          "remediation disabled",
+			failures:      1,
+			expectedCalls: []string{"attach 5 7"},
+			expectedError: "volume attachment timeout",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeAPI := fakeemma.New()
+			fakeAPI.AddVolume(emma.VolumeResponse{ID: 5, Name: "pvc-1", SizeGB: 4, Type: "ssd", Status: "AVAILABLE", DataCenterID: "dc-1"})
+			service := NewControllerService(&Driver{name: "csi.emma.ms"}, &failingAttachAPI{API: fakeAPI, failures: tt.failures, detachErr: tt.detachErr})
+			service.SetAttachFailureRemediation(tt.remediation)
+
+			_, err := service.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+				VolumeId:         "5",
+				NodeId:           "7",
+				VolumeCapability: mountCapability(),
+			})
+			if tt.expectedError == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.expectedError != "" && (err == nil || !strings.Contains(err.Error(), tt.expectedError)) {
+				t.Errorf("expected error containing %q, got %v", tt.expectedError, err)
+			}
+			if !reflect.DeepEqual(fakeAPI.Calls(), tt.expectedCalls) {
+				t.Errorf("expected calls %v, got %v", tt.expectedCalls, fakeAPI.Calls())
+			}
+		})
+	}
+}
+
+// recordingNotifier records the types of volume lifecycle events
+type recordingNotifier struct {
+	events []notify.Event
+}
+
+func (r *recordingNotifier) Notify(event notify.Event) {
+	r.events = append(r.events, event)
+}
+
+// TestControllerNotifications tests the lifecycle events sent for volume operations
+func TestControllerNotifications(t *testing.T) {
+	fakeAPI := fakeemma.New()
+	notifier := &recordingNotifier{}
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, fakeAPI)
+	service.SetNotifier(notifier)
+
+	created, err := service.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: gib},
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+		Parameters:         map[string]string{paramDataCenterID: "dc-1"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	volumeID := created.GetVolume().GetVolumeId()
+
+	fakeAPI.AttachErr = errors.New("attach rejected")
+	if _, err := service.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+		VolumeId: volumeID, NodeId: "7", VolumeCapability: mountCapability(),
+	}); err == nil {
+		t.Fatal("expected the attach to fail")
+	}
+	if _, err := service.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+		VolumeId: volumeID, CapacityRange: &csi.CapacityRange{RequiredBytes: 2 * gib},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := service.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: volumeID}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var types []string
+	for _, event := range notifier.events {
+		types = append(types, event.Type)
+		if event.VolumeID != volumeID || event.Driver != "csi.emma.ms" || event.Time.IsZero() {
+			t.Errorf("unexpected %s event %+v", event.Type, event)
+		}
+	}
+	expected := []string{notify.EventVolumeCreated, notify.EventVolumeAttachFailed, notify.EventVolumeExpansionCompleted, notify.EventVolumeDeleted}
+	if !reflect.DeepEqual(types, expected) {
+		t.Fatalf("expected events %v, got %v", expected, types)
+	}
+	if failed := notifier.events[1]; failed.NodeID != "7" || !strings.Contains(failed.Error, "attach rejected") {
+		t.Errorf("expected the attach failure to name the node and error, got %+v", failed)
+	}
+	if expanded := notifier.events[2]; expanded.CapacityBytes < 2*gib {
+		t.Errorf("expected the expanded capacity of at least 2GiB, got %d", expanded.CapacityBytes)
+	}
+}
+
+// TestControllerExpandAndDeleteWithFake tests expansion and deletion against the fake Emma API
+func TestControllerExpandAndDeleteWithFake(t *testing.T) {
+	fakeAPI := fakeemma.New()
+	vmID := int32(7)
+	fakeAPI.AddVolume(emma.VolumeResponse{ID: 5, Name: "pvc-1", SizeGB: 4, Type: "ssd", Status: "ACTIVE", AttachedToID: &vmID, DataCenterID: "dc-1"})
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, fakeAPI)
+	service.SetDeleteDetachGracePeriod(0)
+
+	resp, err := service.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+		VolumeId:      "5",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 6 * gib},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	size := fakeAPI.Volume(5).SizeGB
+	if resp.GetCapacityBytes() != int64(size)*gib || resp.GetCapacityBytes() < 6*gib {
+		t.Errorf("expected capacity of at least 6GiB matching Emma size %dGB, got %d", size, resp.GetCapacityBytes())
+	}
+	if !resp.GetNodeExpansionRequired() {
+		t.Error("expected node expansion for a filesystem volume")
+	}
+
+	// Deleting an attached volume detaches it first
+	if _, err := service.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "5"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{fmt.Sprintf("resize 5 %d", size), "detach 5 7", "delete 5"}
+	if !reflect.DeepEqual(fakeAPI.Calls(), expected) {
+		t.Errorf("expected calls %v, got %v", expected, fakeAPI.Calls())
+	}
+}
+
+// graceWaitAPI is a fake Emma API that runs duringGrace on the first volume poll of the
+// DeleteVolume grace wait
+type graceWaitAPI struct {
+	*fakeemma.API
+	gets        int
+	duringGrace func(volumeID int32)
+}
+
+func (f *graceWaitAPI) GetVolume(ctx context.Context, volumeID int32) (*emma.VolumeResponse, error) {
+	f.gets++
+	if f.gets == 2 {
+		f.duringGrace(volumeID)
+	}
+	return f.API.GetVolume(ctx, volumeID)
+}
+
+// TestControllerDeleteVolumeDeletedDuringGrace tests that a volume deleted while DeleteVolume
+// waited for its unpublish counts as deleted
+func TestControllerDeleteVolumeDeletedDuringGrace(t *testing.T) {
+	fakeAPI := fakeemma.New()
+	vmID := int32(7)
+	fakeAPI.AddVolume(emma.VolumeResponse{ID: 5, Name: "pvc-1", SizeGB: 4, Type: "ssd", Status: "ACTIVE", AttachedToID: &vmID, DataCenterID: "dc-1"})
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, &graceWaitAPI{API: fakeAPI, duringGrace: fakeAPI.RemoveVolume})
+	service.SetDeleteDetachGracePeriod(10 * time.Millisecond)
+
+	if _, err := service.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "5"}); err != nil {
+		t.Fatalf("expected success for a volume deleted meanwhile, got %v", err)
+	}
+	if calls := fakeAPI.Calls(); len(calls) != 0 {
+		t.Errorf("expected no detach or delete, got %v", calls)
+	}
+}
+
+// TestControllerDeleteVolumeChangedDuringGrace tests that a volume changed while DeleteVolume
+// waited for its unpublish is settled and checked for protection again
+func TestControllerDeleteVolumeChangedDuringGrace(t *testing.T) {
+	vmID := int32(7)
+	tests := []struct {
+		name   string
+		change func(fakeAPI *fakeemma.API)
+		code   codes.Code
+	}{
+		{
+			name: "tagged protected",
+			change: func(fakeAPI *fakeemma.API) {
+				fakeAPI.AddVolume(emma.VolumeResponse{ID: 5, Name: "pvc-1", SizeGB: 4, Type: "ssd", Status: "ACTIVE", AttachedToID: &vmID, DataCenterID: "dc-1",
+					Tags: []emma.VolumeTag{{Key: TagProtected, Value: "true"}}})
+			},
+			code: codes.FailedPrecondition,
+		},
+		{
+			name: "busy",
+			change: func(fakeAPI *fakeemma.API) {
+				fakeAPI.AddVolume(emma.VolumeResponse{ID: 5, Name: "pvc-1", SizeGB: 4, Type: "ssd", Status: "BUSY", AttachedToID: &vmID, DataCenterID: "dc-1"})
+				fakeAPI.WaitErr = fmt.Errorf("%w: volume 5", emma.ErrVolumeBusy)
+			},
+			code: codes.Unavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeAPI := fakeemma.New()
+			fakeAPI.AddVolume(emma.VolumeResponse{ID: 5, Name: "pvc-1", SizeGB: 4, Type: "ssd", Status: "ACTIVE", AttachedToID: &vmID, DataCenterID: "dc-1"})
+			service := NewControllerService(&Driver{name: "csi.emma.ms"}, &graceWaitAPI{API: fakeAPI, duringGrace: func(int32) { tt.change(fakeAPI) }})
+			service.SetDeleteDetachGracePeriod(10 * time.Millisecond)
+
+			_, err := service.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "5"})
+			if status.Code(err) != tt.code {
+				t.Errorf("expected %v, got %v", tt.code, err)
+			}
+			if calls := fakeAPI.Calls(); len(calls) != 0 {
+				t.Errorf("expected no detach or delete, got %v", calls)
+			}
+		})
+	}
+}
+
+// TestControllerDeleteVolumeGraceReleasesWorker tests that the DeleteVolume grace wait
+// leaves the delete worker to other deletions
+func TestControllerDeleteVolumeGraceReleasesWorker(t *testing.T) {
+	fakeAPI := fakeemma.New()
+	vmID := int32(7)
+	fakeAPI.AddVolume(emma.VolumeResponse{ID: 5, Name: "pvc-1", SizeGB: 4, Type: "ssd", Status: "ACTIVE", AttachedToID: &vmID, DataCenterID: "dc-1"})
+
+	var service *ControllerService
+	workerFree := false
+	api := &graceWaitAPI{API: fakeAPI, duringGrace: func(volumeID int32) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if release, err := service.deleteExecutor.acquire(ctx); err == nil {
+			workerFree = true
+			release()
+		}
+	}}
+	service = NewControllerService(&Driver{name: "csi.emma.ms"}, api)
+	service.SetDeleteDetachGracePeriod(10 * time.Millisecond)
+	service.SetDeleteLimits(1, 0, 0)
+
+	if _, err := service.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "5"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !workerFree {
+		t.Error("expected the delete worker to be free during the grace wait")
+	}
+	if api.gets < 3 {
+		t.Errorf("expected the initial get, a grace poll and a refetch, got %d gets", api.gets)
+	}
+	expected := []string{"detach 5 7", "delete 5"}
+	if !reflect.DeepEqual(fakeAPI.Calls(), expected) {
+		t.Errorf("expected calls %v, got %v", expected, fakeAPI.Calls())
+	}
+}
+
+// TestControllerListVolumesPagination tests paging through volumes with starting_token and max_entries
+func TestControllerListVolumesPagination(t *testing.T) {
+	fakeAPI := fakeemma.New()
+	for _, id := range []int32{30, 10, 50, 20, 40} {
+		fakeAPI.AddVolume(emma.VolumeResponse{ID: id, Name: fmt.Sprintf("pvc-%d", id), SizeGB: 1, Status: "AVAILABLE", DataCenterID: "dc-1"})
+	}
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, fakeAPI)
+
+	var pages [][]string
+	token := ""
+	for {
+		resp, err := service.ListVolumes(context.Background(), &csi.ListVolumesRequest{StartingToken: token, MaxEntries: 2})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var ids []string
+		for _, entry := range resp.GetEntries() {
+			ids = append(ids, entry.GetVolume().GetVolumeId())
+		}
+		pages = append(pages, ids)
+		token = resp.GetNextToken()
+		if token == "" {
+			break
+		}
+		// A volume deleted between pages does not shift the next page
+		if token == "20" {
+			fakeAPI.RemoveVolume(10)
+		}
+	}
+	expected := [][]string{{"10", "20"}, {"30", "40"}, {"50"}}
+	if !reflect.DeepEqual(pages, expected) {
+		t.Errorf("expected pages %v, got %v", expected, pages)
+	}
+
+	resp, err := service.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+	if err != nil || len(resp.GetEntries()) != 4 || resp.GetNextToken() != "" {
+		t.Errorf("expected all 4 volumes without a next token, got %d entries, token %q, err %v", len(resp.GetEntries()), resp.GetNextToken(), err)
+	}
+
+	tests := []struct {
+		name string
+		req  *csi.ListVolumesRequest
+		code codes.Code
+	}{
+		{"invalid token", &csi.ListVolumesRequest{StartingToken: "abc"}, codes.Aborted},
+		{"negative max entries", &csi.ListVolumesRequest{MaxEntries: -1}, codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.ListVolumes(context.Background(), tt.req)
+			if status.Code(err) != tt.code {
+				t.Errorf("expected %v, got %v", tt.code, err)
+			}
+		})
+	}
+}
+
+// TestControllerListVolumesPublishedNodes tests that Emma VM attachments are reported as node IDs
+func TestControllerListVolumesPublishedNodes(t *testing.T) {
+	fakeAPI := fakeemma.New()
+	vm := func(id int32) *int32 { return &id }
+	fakeAPI.AddVolume(emma.VolumeResponse{ID: 1, Name: "pvc-1", SizeGB: 1, Status: "ACTIVE", AttachedToID: vm(7)})
+	fakeAPI.AddVolume(emma.VolumeResponse{ID: 2, Name: "pvc-2", SizeGB: 1, Status: "ACTIVE", AttachedToID: vm(8)})
+	fakeAPI.AddVolume(emma.VolumeResponse{ID: 4, Name: "pvc-4", SizeGB: 1, Status: "AVAILABLE"})
+	fakeAPI.AddVolume(emma.VolumeResponse{ID: 5, Name: "pvc-5", SizeGB: 1, Status: "ACTIVE", AttachedToID: vm(8)})
+
+	nodeB := emmasdk.KubernetesNodeGroupsInnerNodesInner{Id: vm(8), Name: emmasdk.PtrString("node-b")}
+	fakeAPI.Clusters = []emmasdk.Kubernetes{{
+		NodeGroups: []emmasdk.KubernetesNodeGroupsInner{{Nodes: []emmasdk.KubernetesNodeGroupsInnerNodesInner{nodeB}}},
+	}}
+
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, fakeAPI)
+	service.nodeCache.Set("node-a", 7)
+	service.attachHistory.Record("1", "node-a")
+	// Volume 5 was last published to node-a by this controller but has since moved
+	service.attachHistory.Record("5", "node-a")
+
+	resp, err := service.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := make(map[string][]string)
+	for _, entry := range resp.GetEntries() {
+		if entry.GetStatus() == nil {
+			t.Fatalf("expected a status for volume %s", entry.GetVolume().GetVolumeId())
+		}
+		got[entry.GetVolume().GetVolumeId()] = entry.GetStatus().GetPublishedNodeIds()
+	}
+	expected := map[string][]string{
+		"1": {"node-a"},
+		"2": {"node-b"},
+		"4": nil,
+		"5": {"node-b"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected published nodes %v, got %v", expected, got)
+	}
+
+	getResp, err := service.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: "2"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if nodes := getResp.GetStatus().GetPublishedNodeIds(); !reflect.DeepEqual(nodes, []string{"node-b"}) {
+		t.Errorf("expected ControllerGetVolume to report node-b, got %v", nodes)
+	}
+}
+
+// TestControllerListVolumesUnknownNode tests that a volume attached to a VM no node resolves
+// to fails the listing instead of reporting the volume as published to another node ID
+func TestControllerListVolumesUnknownNode(t *testing.T) {
+	vm := func(id int32) *int32 { return &id }
+	for _, tt := range []struct {
+		mode     NodeResolutionMode
+		code     codes.Code
+		expected []string
+	}{
+		{mode: NodeResolutionClusters, code: codes.Unavailable},
+		{mode: NodeResolutionVMs, code: codes.Unavailable},
+		{mode: NodeResolutionNumericOnly, code: codes.OK, expected: []string{"9"}},
+	} {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			fakeAPI := fakeemma.New()
+			fakeAPI.AddVolume(emma.VolumeResponse{ID: 3, Name: "pvc-3", SizeGB: 1, Status: "ACTIVE", AttachedToID: vm(9)})
+			service := NewControllerService(&Driver{name: "csi.emma.ms"}, fakeAPI)
+			service.SetNodeResolutionMode(tt.mode)
+
+			listResp, err := service.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+			if status.Code(err) != tt.code {
+				t.Fatalf("expected %v from ListVolumes, got %v", tt.code, err)
+			}
+			getResp, getErr := service.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: "3"})
+			if status.Code(getErr) != tt.code {
+				t.Fatalf("expected %v from ControllerGetVolume, got %v", tt.code, getErr)
+			}
+			if err != nil {
+				return
+			}
+			if nodes := listResp.GetEntries()[0].GetStatus().GetPublishedNodeIds(); !reflect.DeepEqual(nodes, tt.expected) {
+				t.Errorf("expected ListVolumes to report %v, got %v", tt.expected, nodes)
+			}
+			if nodes := getResp.GetStatus().GetPublishedNodeIds(); !reflect.DeepEqual(nodes, tt.expected) {
+				t.Errorf("expected ControllerGetVolume to report %v, got %v", tt.expected, nodes)
+			}
+		})
+	}
+}
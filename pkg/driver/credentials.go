@@ -0,0 +1,70 @@
+package driver
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// Keys of the CSI secrets holding Emma credentials, referenced by a StorageClass through the
+// csi.storage.k8s.io/provisioner-secret-name, controller-publish-secret-name and
+// controller-expand-secret-name parameters
+const (
+	secretClientID     = "clientId"
+	secretClientSecret = "clientSecret"
+)
+
+// EmmaClientResolver returns the Emma client of a set of credentials, such as a ClientPool
+type EmmaClientResolver func(ctx context.Context, clientID, clientSecret string) (EmmaVolumeAPI, error)
+
+// emmaClientKey is the context key of the Emma client resolved for a request
+type emmaClientKey struct{}
+
+// SetClientResolver lets StorageClasses use their own Emma credentials through CSI secrets.
+// Without a resolver, requests carrying credentials are rejected.
+func (s *ControllerService) SetClientResolver(resolver EmmaClientResolver) {
+	s.clientResolver = resolver
+}
+
+// withCredentials resolves the Emma client of a request's secrets and returns a context
+// carrying it. Requests without credentials use the controller's own client.
+func (s *ControllerService) withCredentials(ctx context.Context, secrets map[string]string) (context.Context, error) {
+	clientID, clientSecret := secrets[secretClientID], secrets[secretClientSecret]
+	if clientID == "" && clientSecret == "" {
+		return ctx, nil
+	}
+	if clientID == "" || clientSecret == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "secret must contain both %s and %s", secretClientID, secretClientSecret)
+	}
+	if s.clientResolver == nil {
+		return nil, status.Error(codes.FailedPrecondition, "this controller does not support per-StorageClass Emma credentials")
+	}
+
+	client, err := s.clientResolver(ctx, clientID, clientSecret)
+	if err != nil {
+		return nil, status.Errorf(emmaErrorCode(err, codes.Unauthenticated), "failed to create Emma client from secret: %v", err)
+	}
+	return context.WithValue(ctx, emmaClientKey{}, client), nil
+}
+
+// api returns the Emma client of a request: the one resolved from its secrets, or the
+// controller's own client
+func (s *ControllerService) api(ctx context.Context) EmmaVolumeAPI {
+	if client, ok := ctx.Value(emmaClientKey{}).(EmmaVolumeAPI); ok {
+		return client
+	}
+	return s.emmaClient
+}
+
+// stripSecrets returns a copy of a CSI request without its secrets, for logging
+func stripSecrets(req protoadapt.MessageV1) protoadapt.MessageV1 {
+	stripped := proto.Clone(protoadapt.MessageV2Of(req))
+	message := stripped.ProtoReflect()
+	if field := message.Descriptor().Fields().ByName("secrets"); field != nil {
+		message.Clear(field)
+	}
+	return protoadapt.MessageV1Of(stripped)
+}
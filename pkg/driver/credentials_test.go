@@ -0,0 +1,97 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"github.com/emma-csi-driver/internal/fakeemma"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestControllerCredentialsFromSecrets tests that requests carrying Emma credentials use the client of those credentials
+func TestControllerCredentialsFromSecrets(t *testing.T) {
+	defaultAPI, tenantAPI := fakeemma.New(), fakeemma.New()
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, defaultAPI)
+
+	create := func(name string, secrets map[string]string) error {
+		_, err := service.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+			Name:               name,
+			CapacityRange:      &csi.CapacityRange{RequiredBytes: gib},
+			VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+			Parameters:         map[string]string{paramDataCenterID: "dc-1"},
+			Secrets:            secrets,
+		})
+		return err
+	}
+	tenant := map[string]string{secretClientID: "tenant", secretClientSecret: "s3cret"}
+
+	// Without a resolver, credentials are refused rather than silently using the default account
+	if err := create("pvc-0", tenant); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition without a resolver, got %v", err)
+	}
+
+	var resolved []string
+	service.SetClientResolver(func(ctx context.Context, clientID, clientSecret string) (EmmaVolumeAPI, error) {
+		resolved = append(resolved, clientID)
+		if clientSecret != "s3cret" {
+			return nil, fmt.Errorf("invalid credentials")
+		}
+		return tenantAPI, nil
+	})
+
+	tests := []struct {
+		name     string
+		secrets  map[string]string
+		expected codes.Code
+	}{
+		{name: "pvc-1", expected: codes.OK},
+		{name: "pvc-2", secrets: tenant, expected: codes.OK},
+		{name: "pvc-3", secrets: map[string]string{secretClientID: "tenant"}, expected: codes.InvalidArgument},
+		{name: "pvc-4", secrets: map[string]string{secretClientID: "tenant", secretClientSecret: "wrong"}, expected: codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		if err := create(tt.name, tt.secrets); status.Code(err) != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, err)
+		}
+	}
+
+	if strings.Join(defaultAPI.Calls(), ",") != "create pvc-1" {
+		t.Errorf("expected only pvc-1 in the default account, got %v", defaultAPI.Calls())
+	}
+	if strings.Join(tenantAPI.Calls(), ",") != "create pvc-2" {
+		t.Errorf("expected pvc-2 in the tenant account, got %v", tenantAPI.Calls())
+	}
+	if len(resolved) != 2 {
+		t.Errorf("expected the resolver to be asked for complete credentials only, got %v", resolved)
+	}
+
+	// Deleting with the same secret reaches the tenant account
+	volumes, _ := tenantAPI.ListVolumes(context.Background())
+	_, err := service.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{
+		VolumeId: fmt.Sprint(volumes[0].ID),
+		Secrets:  tenant,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tenantAPI.Volume(volumes[0].ID) != nil {
+		t.Errorf("expected the volume to be deleted from the tenant account")
+	}
+}
+
+// TestStripSecrets tests that secrets are removed from logged requests without modifying the request
+func TestStripSecrets(t *testing.T) {
+	req := &csi.DeleteVolumeRequest{VolumeId: "42", Secrets: map[string]string{secretClientSecret: "s3cret"}}
+
+	logged := fmt.Sprintf("%+v", stripSecrets(req))
+	if strings.Contains(logged, "s3cret") || !strings.Contains(logged, "42") {
+		t.Errorf("expected the volume ID without secrets, got %s", logged)
+	}
+	if req.GetSecrets()[secretClientSecret] != "s3cret" {
+		t.Error("expected the request to keep its secrets")
+	}
+}
@@ -0,0 +1,65 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/client-go/util/flowcontrol"
+
+	"github.com/emma-csi-driver/pkg/metrics"
+)
+
+const (
+	// defaultDeleteParallelism is the default number of DeleteVolume calls running at once
+	defaultDeleteParallelism = 4
+)
+
+// deleteExecutor funnels DeleteVolume calls through a bounded set of workers and
+// an Emma API call budget so that mass deletion does not trip API rate limits
+type deleteExecutor struct {
+	slots   chan struct{}
+	limiter flowcontrol.RateLimiter
+}
+
+// newDeleteExecutor creates an executor running at most parallelism deletions at once.
+// A positive qps budgets the Emma API calls made by deletions, with bursts up to burst.
+func newDeleteExecutor(parallelism int, qps float32, burst int) *deleteExecutor {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	e := &deleteExecutor{
+		slots: make(chan struct{}, parallelism),
+	}
+	if qps > 0 {
+		if burst < 1 {
+			burst = 1
+		}
+		e.limiter = flowcontrol.NewTokenBucketRateLimiter(qps, burst)
+	}
+	return e
+}
+
+// acquire waits for a free deletion worker and returns a function releasing it
+func (e *deleteExecutor) acquire(ctx context.Context) (func(), error) {
+	metrics.AddDeleteQueueWaiting(1)
+	defer metrics.AddDeleteQueueWaiting(-1)
+
+	select {
+	case e.slots <- struct{}{}:
+		return func() { <-e.slots }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("timed out waiting for a delete worker: %w", ctx.Err())
+	}
+}
+
+// waitBudget waits until the API call budget allows another Emma API call
+func (e *deleteExecutor) waitBudget(ctx context.Context) error {
+	if e.limiter == nil {
+		return nil
+	}
+	if err := e.limiter.Wait(ctx); err != nil {
+		return fmt.Errorf("timed out waiting for Emma API call budget: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+// deviceCache persists the device each staged volume was found at, so that restaging after
+// a kubelet restart and expansion skip the device discovery scan. A nil cache is disabled.
+type deviceCache struct {
+	path    string
+	mutex   sync.Mutex
+	devices map[string]string
+}
+
+// loadDeviceCache opens the cache file at path, starting empty when it does not exist
+func loadDeviceCache(path string) (*deviceCache, error) {
+	c := &deviceCache{
+		path:    path,
+		devices: make(map[string]string),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device cache %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &c.devices); err != nil {
+		// A corrupt cache only costs a discovery scan per volume
+		klog.Warningf("Ignoring unreadable device cache %s: %v", path, err)
+		c.devices = make(map[string]string)
+	}
+	return c, nil
+}
+
+// Get returns the cached device of a volume
+func (c *deviceCache) Get(volumeID string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	device, ok := c.devices[volumeID]
+	return device, ok
+}
+
+// Set records the device of a volume
+func (c *deviceCache) Set(volumeID, device string) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.devices[volumeID] == device {
+		return
+	}
+	c.devices[volumeID] = device
+	c.save()
+}
+
+// Delete removes the device of a volume
+func (c *deviceCache) Delete(volumeID string) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.devices[volumeID]; !ok {
+		return
+	}
+	delete(c.devices, volumeID)
+	c.save()
+}
+
+// save writes the cache file through a rename, so a crash never leaves a partial file.
+// Failures are logged, the cache only saves time.
+func (c *deviceCache) save() {
+	data, err := json.Marshal(c.devices)
+	if err != nil {
+		klog.Warningf("Failed to encode device cache: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		klog.Warningf("Failed to create device cache directory: %v", err)
+		return
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		klog.Warningf("Failed to write device cache %s: %v", tmp, err)
+		return
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		klog.Warningf("Failed to replace device cache %s: %v", c.path, err)
+	}
+}
@@ -0,0 +1,81 @@
+package driver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/emma-csi-driver/pkg/mount"
+)
+
+// TestDeviceCachePersists tests that cached devices survive reloading the cache file
+func TestDeviceCachePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "devices.json")
+
+	cache, err := loadDeviceCache(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cache.Set("123", "/dev/vdb")
+	cache.Set("456", "/dev/vdc")
+	cache.Delete("456")
+
+	reloaded, err := loadDeviceCache(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(reloaded.devices, map[string]string{"123": "/dev/vdb"}) {
+		t.Errorf("unexpected reloaded devices %v", reloaded.devices)
+	}
+
+	if err := os.WriteFile(path, []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	corrupt, err := loadDeviceCache(path)
+	if err != nil {
+		t.Fatalf("expected a corrupt cache to be ignored, got %v", err)
+	}
+	if _, ok := corrupt.Get("123"); ok {
+		t.Error("expected an empty cache after a corrupt file")
+	}
+
+	var disabled *deviceCache
+	disabled.Set("123", "/dev/vdb")
+	if _, ok := disabled.Get("123"); ok {
+		t.Error("expected a nil cache to be disabled")
+	}
+}
+
+// TestFindDeviceUsesCache tests that a cached device skips discovery until it changes
+func TestFindDeviceUsesCache(t *testing.T) {
+	mounter := &fakeMounter{device: "/dev/vdb"}
+	service := NewNodeService(&Driver{name: "csi.emma.ms"})
+	service.mounter = mounter
+	if err := service.SetDeviceCacheFile(filepath.Join(t.TempDir(), "devices.json")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	service.deviceCache.Set("123", "/dev/vdb")
+	if device, err := service.findDevice(context.Background(), "123", mount.DeviceHints{}); err != nil || device != "/dev/vdb" {
+		t.Errorf("expected cached /dev/vdb, got %q, %v", device, err)
+	}
+	if len(mounter.calls) != 0 {
+		t.Errorf("expected no discovery for a cached device, got %v", mounter.calls)
+	}
+
+	// The volume was reattached as another device
+	service.deviceCache.Set("123", "/dev/vdc")
+	if device, err := service.findDevice(context.Background(), "123", mount.DeviceHints{}); err != nil || device != "/dev/vdb" {
+		t.Errorf("expected rediscovered /dev/vdb, got %q, %v", device, err)
+	}
+	if !reflect.DeepEqual(mounter.calls, []string{"discover 123"}) {
+		t.Errorf("expected one discovery, got %v", mounter.calls)
+	}
+
+	service.forgetStagedVolume("123")
+	if _, ok := service.deviceCache.Get("123"); ok {
+		t.Error("expected unstaging to drop the cached device")
+	}
+}
@@ -2,11 +2,20 @@ package driver
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
+	"net/http"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	emma "github.com/emma-community/emma-go-sdk"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"k8s.io/klog/v2"
+
+	"github.com/emma-csi-driver/pkg/metrics"
+	"github.com/emma-csi-driver/pkg/mount"
 )
 
 const (
@@ -15,6 +24,22 @@ const (
 
 	// DriverVersion is the version of the CSI driver
 	DriverVersion = "v1.0.0"
+
+	// TopologyKeyDataCenter is the topology segment key for the Emma datacenter
+	TopologyKeyDataCenter = "topology.csi.emma.ms/datacenter"
+
+	// TopologyKeyProvider is the topology segment key for the cloud provider of the datacenter
+	TopologyKeyProvider = "topology.csi.emma.ms/provider"
+
+	// TopologyKeyLocation is the topology segment key for the location of the datacenter
+	TopologyKeyLocation = "topology.csi.emma.ms/location"
+
+	// DefaultShutdownTimeout is how long Stop waits for in-flight CSI calls, below the
+	// default 30 second termination grace period of pods
+	DefaultShutdownTimeout = 25 * time.Second
+
+	// metricsShutdownTimeout bounds how long scrapes in progress delay Run's return
+	metricsShutdownTimeout = 5 * time.Second
 )
 
 // EmmaClient defines the interface for Emma API operations
@@ -39,10 +64,62 @@ type Driver struct {
 
 	// Server
 	srv *NonBlockingGRPCServer
+
+	// listener, when set, is served instead of opening the endpoint
+	listener net.Listener
+
+	// shutdownTimeout bounds how long Stop waits for in-flight CSI calls
+	shutdownTimeout time.Duration
+
+	// grpcOptions are added to the gRPC server's options
+	grpcOptions []grpc.ServerOption
+
+	// metricsAddr, when set, is where Run serves the metrics server
+	metricsAddr string
+
+	// mounter, when set, is the mounter of node services created for the driver
+	mounter mount.Mounter
+
+	// tlsConfig, when set, secures the tcp:// endpoint
+	tlsConfig *tls.Config
+}
+
+// Option configures a Driver created by NewDriver
+type Option func(*Driver)
+
+// WithGRPCOptions adds options to the gRPC server serving the CSI services
+func WithGRPCOptions(opts ...grpc.ServerOption) Option {
+	return func(d *Driver) {
+		d.grpcOptions = append(d.grpcOptions, opts...)
+	}
+}
+
+// WithMetrics has Run serve the Prometheus metrics, /configz and /featurez on addr for as
+// long as it runs
+func WithMetrics(addr string) Option {
+	return func(d *Driver) {
+		d.metricsAddr = addr
+	}
+}
+
+// WithEndpointTLS serves the CSI endpoint over TLS, which requires a tcp:// endpoint.
+// Client certificates are verified as the config requires, see EndpointTLS.Config.
+func WithEndpointTLS(config *tls.Config) Option {
+	return func(d *Driver) {
+		d.tlsConfig = config
+	}
+}
+
+// WithMounter sets the mounter of node services created for the driver, e.g. a
+// mount.FakeMounter to run without block devices
+func WithMounter(mounter mount.Mounter) Option {
+	return func(d *Driver) {
+		d.mounter = mounter
+	}
 }
 
 // NewDriver creates a new Emma CSI driver
-func NewDriver(nodeID, endpoint string) (*Driver, error) {
+func NewDriver(nodeID, endpoint string, opts ...Option) (*Driver, error) {
 	if nodeID == "" {
 		return nil, fmt.Errorf("nodeID is required")
 	}
@@ -52,12 +129,25 @@ func NewDriver(nodeID, endpoint string) (*Driver, error) {
 
 	klog.Infof("Creating Emma CSI driver: %s version: %s", DriverName, DriverVersion)
 
-	return &Driver{
+	d := &Driver{
 		name:     DriverName,
 		version:  DriverVersion,
 		nodeID:   nodeID,
 		endpoint: endpoint,
-	}, nil
+
+		shutdownTimeout: DefaultShutdownTimeout,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if d.tlsConfig != nil {
+		if !isTCPEndpoint(endpoint) {
+			return nil, fmt.Errorf("TLS requires a tcp:// endpoint, not %s", endpoint)
+		}
+		d.grpcOptions = append(d.grpcOptions, grpc.Creds(credentials.NewTLS(d.tlsConfig)))
+	}
+	d.srv = NewNonBlockingGRPCServer(d.grpcOptions...)
+	return d, nil
 }
 
 // SetControllerService sets the controller service
@@ -80,27 +170,69 @@ func (d *Driver) SetEmmaClient(client EmmaClient) {
 	d.emmaClient = client
 }
 
-// Run starts the CSI driver gRPC server
-func (d *Driver) Run() error {
-	klog.Infof("Starting Emma CSI driver on endpoint: %s", d.endpoint)
+// SetListener makes Run serve an already open listener instead of opening the endpoint,
+// for embedding the driver in test binaries
+func (d *Driver) SetListener(listener net.Listener) {
+	d.listener = listener
+}
 
-	// Create gRPC server
-	d.srv = NewNonBlockingGRPCServer()
+// SetShutdownTimeout sets how long Stop waits for in-flight CSI calls before canceling
+// them, 0 waits without limit
+func (d *Driver) SetShutdownTimeout(timeout time.Duration) {
+	d.shutdownTimeout = timeout
+}
 
-	// Start the server
-	if err := d.srv.Start(d.endpoint, d.identityService, d.controllerService, d.nodeService); err != nil {
-		return err
+// Run serves the CSI services until ctx is canceled or Stop is called, then returns nil
+// once the in-flight calls are drained. It returns an error when the server cannot start
+// or fails while serving.
+func (d *Driver) Run(ctx context.Context) error {
+	if d.metricsAddr != "" {
+		metrics.SetFeaturez(func() interface{} { return d.Features(context.Background()) })
+		metricsServer, err := metrics.StartMetricsServer(d.metricsAddr)
+		if err != nil {
+			return fmt.Errorf("failed to start metrics server: %w", err)
+		}
+		defer stopMetricsServer(metricsServer)
 	}
 
-	// Block forever - signal handler will stop the server
+	if d.listener != nil {
+		klog.Infof("Starting Emma CSI driver on listener: %s", d.listener.Addr())
+		d.srv.StartWithListener(d.listener, d.identityService, d.controllerService, d.nodeService)
+	} else {
+		klog.Infof("Starting Emma CSI driver on endpoint: %s", d.endpoint)
+		if err := d.srv.Start(d.endpoint, d.identityService, d.controllerService, d.nodeService); err != nil {
+			return err
+		}
+	}
 	klog.Info("Emma CSI driver is running")
-	select {}
+
+	healthCtx, stopHealth := context.WithCancel(ctx)
+	defer stopHealth()
+	d.updateHealth(healthCtx)
+	go d.watchHealth(healthCtx)
+
+	select {
+	case <-ctx.Done():
+		d.Stop()
+		return nil
+	case err := <-d.srv.Done():
+		return err
+	}
 }
 
-// Stop stops the CSI driver
+// Stop stops accepting CSI calls and waits up to the shutdown timeout for the in-flight
+// ones to finish, so attach and create operations are not cut off midway
 func (d *Driver) Stop() {
 	klog.Info("Stopping Emma CSI driver")
-	if d.srv != nil {
-		d.srv.Stop()
+	d.srv.GracefulStop(d.shutdownTimeout)
+	klog.Info("Emma CSI driver stopped")
+}
+
+// stopMetricsServer stops the metrics server, waiting briefly for scrapes in progress
+func stopMetricsServer(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		klog.Warningf("Metrics server did not stop cleanly: %v", err)
 	}
 }
@@ -0,0 +1,48 @@
+package driver
+
+import (
+	"testing"
+
+	"google.golang.org/grpc"
+
+	"github.com/emma-csi-driver/pkg/mount"
+)
+
+// TestNewDriverOptions tests the functional options of NewDriver
+func TestNewDriverOptions(t *testing.T) {
+	mounter := mount.NewFakeMounter()
+	drv, err := NewDriver("node-1", "unix:///tmp/csi.sock",
+		WithMounter(mounter),
+		WithGRPCOptions(grpc.MaxRecvMsgSize(1<<20), grpc.MaxSendMsgSize(1<<20)),
+		WithMetrics(":9090"),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if service := NewNodeService(drv); service.mounter != mounter {
+		t.Errorf("expected node services to use the mounter from WithMounter, got %T", service.mounter)
+	}
+	if len(drv.srv.options) != 2 {
+		t.Errorf("expected 2 extra gRPC server options, got %d", len(drv.srv.options))
+	}
+	if drv.metricsAddr != ":9090" {
+		t.Errorf("expected metrics on :9090, got %q", drv.metricsAddr)
+	}
+	if drv.shutdownTimeout != DefaultShutdownTimeout {
+		t.Errorf("expected the default shutdown timeout, got %s", drv.shutdownTimeout)
+	}
+
+	// Without options node services use the system mounter
+	drv, err = NewDriver("node-1", "unix:///tmp/csi.sock")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, fake := NewNodeService(drv).mounter.(*mount.FakeMounter); fake || drv.metricsAddr != "" {
+		t.Errorf("expected the system mounter and no metrics server without options")
+	}
+
+	if _, err := NewDriver("", "unix:///tmp/csi.sock"); err == nil {
+		t.Error("expected an error without node ID")
+	}
+}
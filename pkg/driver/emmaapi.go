@@ -0,0 +1,36 @@
+package driver
+
+import (
+	"context"
+	"time"
+
+	emmasdk "github.com/emma-community/emma-go-sdk"
+
+	"github.com/emma-csi-driver/pkg/emma"
+)
+
+// EmmaVolumeAPI defines the Emma API operations used by the controller service
+type EmmaVolumeAPI interface {
+	CreateVolume(ctx context.Context, name string, sizeGB int32, volumeType string, dataCenterID string, tags []emma.VolumeTag) (*emma.VolumeResponse, error)
+	GetVolume(ctx context.Context, volumeID int32) (*emma.VolumeResponse, error)
+	ListVolumes(ctx context.Context) ([]*emma.VolumeResponse, error)
+	ListVolumesFiltered(ctx context.Context, opts emma.ListVolumesOptions) ([]*emma.VolumeResponse, error)
+	DeleteVolume(ctx context.Context, volumeID int32) error
+	ResizeVolume(ctx context.Context, volumeID int32, newSizeGB int32) error
+	AttachVolume(ctx context.Context, vmID int32, volumeID int32) error
+	DetachVolume(ctx context.Context, vmID int32, volumeID int32) error
+
+	WaitForVolumeStatus(ctx context.Context, volumeID int32, desiredStatus string, timeout time.Duration) error
+	WaitForVolumeAttachment(ctx context.Context, volumeID int32, vmID int32, timeout time.Duration) error
+	WaitForVolumeDetachment(ctx context.Context, volumeID int32, timeout time.Duration) error
+	WaitForVolumeSettled(ctx context.Context, volumeID int32, timeout time.Duration) (*emma.VolumeResponse, error)
+	ReserveWaiter(ctx context.Context) (context.Context, func(), error)
+
+	ValidateDataCenter(ctx context.Context, dataCenterID string) error
+	GetVolumeConfigs(ctx context.Context) ([]emmasdk.VolumeConfiguration, error)
+	ListKubernetesClusters(ctx context.Context) ([]emmasdk.Kubernetes, error)
+	ListVMs(ctx context.Context) ([]emmasdk.Vm, error)
+}
+
+// The Emma API client is the production implementation
+var _ EmmaVolumeAPI = (*emma.Client)(nil)
@@ -0,0 +1,58 @@
+package driver
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/emma-csi-driver/pkg/emma"
+)
+
+// emmaErrorCode returns the gRPC code for an Emma client error, using fallback
+// for errors that don't carry a more specific meaning. The code decides how the
+// sidecars and kubelet retry: NotFound and InvalidArgument are final, Aborted and
+// Unavailable are retried soon, ResourceExhausted makes the provisioner reschedule.
+func emmaErrorCode(err error, fallback codes.Code) codes.Code {
+	switch {
+	case errors.Is(err, emma.ErrPermissionDenied):
+		return codes.PermissionDenied
+	case errors.Is(err, emma.ErrUnauthorized):
+		return codes.Unauthenticated
+	case errors.Is(err, emma.ErrTooManyWaiters):
+		return codes.ResourceExhausted
+	case errors.Is(err, emma.ErrVolumeBusy):
+		return codes.Unavailable
+	case errors.Is(err, emma.ErrVolumeNotFound), errors.Is(err, emma.ErrVMNotFound):
+		return codes.NotFound
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	}
+
+	switch emma.Category(err) {
+	case emma.CategoryInvalidRequest:
+		return codes.InvalidArgument
+	case emma.CategoryUnauthenticated:
+		return codes.Unauthenticated
+	case emma.CategoryPermissionDenied:
+		return codes.PermissionDenied
+	case emma.CategoryNotFound:
+		return codes.NotFound
+	case emma.CategoryConflict:
+		// Another operation on the resource is in progress
+		return codes.Aborted
+	case emma.CategoryFailedPrecondition:
+		return codes.FailedPrecondition
+	case emma.CategoryQuotaExceeded, emma.CategoryRateLimited:
+		return codes.ResourceExhausted
+	case emma.CategoryTimeout:
+		return codes.DeadlineExceeded
+	case emma.CategoryUnavailable:
+		return codes.Unavailable
+	case emma.CategoryInternal:
+		return codes.Internal
+	}
+	return fallback
+}
@@ -0,0 +1,52 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/emma-csi-driver/pkg/emma"
+)
+
+// TestEmmaErrorCode tests mapping of Emma client errors to gRPC codes
+func TestEmmaErrorCode(t *testing.T) {
+	apiError := func(statusCode int, body string) error {
+		return fmt.Errorf("wrapped: %w", &emma.APIError{Op: "create volume", StatusCode: statusCode, Body: body})
+	}
+
+	tests := []struct {
+		name     string
+		err      error
+		fallback codes.Code
+		expected codes.Code
+	}{
+		{name: "permission denied", err: fmt.Errorf("wrapped: %w", emma.ErrPermissionDenied), fallback: codes.Internal, expected: codes.PermissionDenied},
+		{name: "unauthorized", err: fmt.Errorf("wrapped: %w", emma.ErrUnauthorized), fallback: codes.Internal, expected: codes.Unauthenticated},
+		{name: "volume not found", err: fmt.Errorf("%w: 5", emma.ErrVolumeNotFound), fallback: codes.Internal, expected: codes.NotFound},
+		{name: "VM not found", err: fmt.Errorf("%w: VM 3", emma.ErrVMNotFound), fallback: codes.Internal, expected: codes.NotFound},
+		{name: "deadline", err: fmt.Errorf("wait: %w", context.DeadlineExceeded), fallback: codes.Internal, expected: codes.DeadlineExceeded},
+		{name: "bad request", err: apiError(http.StatusBadRequest, `{"message":"sizeGb must be positive"}`), fallback: codes.Internal, expected: codes.InvalidArgument},
+		{name: "quota exceeded", err: apiError(http.StatusBadRequest, `{"message":"Volume quota exceeded"}`), fallback: codes.Internal, expected: codes.ResourceExhausted},
+		{name: "already attached code", err: &emma.APIError{StatusCode: http.StatusConflict, Code: "ALREADY_ATTACHED"}, fallback: codes.Internal, expected: codes.FailedPrecondition},
+		{name: "quota code", err: &emma.APIError{StatusCode: http.StatusForbidden, Code: "QUOTA_EXCEEDED"}, fallback: codes.Internal, expected: codes.ResourceExhausted},
+		{name: "not found status", err: apiError(http.StatusNotFound, ""), fallback: codes.Internal, expected: codes.NotFound},
+		{name: "conflict", err: apiError(http.StatusConflict, "VM is busy"), fallback: codes.Internal, expected: codes.Aborted},
+		{name: "rate limited", err: apiError(http.StatusTooManyRequests, ""), fallback: codes.Internal, expected: codes.ResourceExhausted},
+		{name: "service unavailable", err: apiError(http.StatusServiceUnavailable, ""), fallback: codes.Internal, expected: codes.Unavailable},
+		{name: "gateway timeout", err: apiError(http.StatusGatewayTimeout, ""), fallback: codes.Internal, expected: codes.DeadlineExceeded},
+		{name: "server error", err: apiError(http.StatusInternalServerError, ""), fallback: codes.NotFound, expected: codes.Internal},
+		{name: "unmapped status uses fallback", err: apiError(http.StatusTeapot, ""), fallback: codes.Internal, expected: codes.Internal},
+		{name: "other error uses fallback", err: fmt.Errorf("boom"), fallback: codes.NotFound, expected: codes.NotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := emmaErrorCode(tt.err, tt.fallback); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package driver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// EndpointTLS configures TLS on a tcp:// CSI endpoint
+type EndpointTLS struct {
+	// CertFile and KeyFile hold the PEM server certificate and key. They are reloaded when
+	// the files change, so rotated certificates are picked up without a restart.
+	CertFile string
+	KeyFile  string
+
+	// ClientCAFile, when set, holds the PEM CA certificates client certificates must be
+	// signed by; clients without one are rejected (mutual TLS)
+	ClientCAFile string
+
+	// MinVersion is the oldest TLS version accepted, TLS 1.2 when zero
+	MinVersion uint16
+}
+
+// Enabled reports whether a server certificate is configured
+func (t EndpointTLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != "" || t.ClientCAFile != ""
+}
+
+// Config loads the certificates and returns the server TLS configuration
+func (t EndpointTLS) Config() (*tls.Config, error) {
+	if t.CertFile == "" || t.KeyFile == "" {
+		return nil, errors.New("both a server certificate and a key file are required")
+	}
+	reloader := &certReloader{certFile: t.CertFile, keyFile: t.KeyFile}
+	if _, err := reloader.certificate(); err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:     t.MinVersion,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return reloader.certificate() },
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	if t.ClientCAFile != "" {
+		data, err := os.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		// Only the given CAs are trusted, never the system roots
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no PEM certificates found in client CA file %s", t.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// certReloader serves a certificate and key pair, reloading it when either file changes
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modified time.Time
+}
+
+// certificate returns the current certificate, reloaded if the files changed since the
+// last load. A failed reload keeps serving the previous certificate.
+func (r *certReloader) certificate() (*tls.Certificate, error) {
+	modified, err := latestModTime(r.certFile, r.keyFile)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cert != nil && (err != nil || !modified.After(r.modified)) {
+		return r.cert, nil
+	}
+
+	cert, loadErr := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if loadErr != nil {
+		if r.cert != nil {
+			klog.Warningf("Failed to reload CSI endpoint certificate, keeping the previous one: %v", loadErr)
+			return r.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load server certificate: %w", loadErr)
+	}
+	if r.cert != nil {
+		klog.Infof("Reloaded CSI endpoint certificate from %s", r.certFile)
+	}
+	r.cert, r.modified = &cert, modified
+	return r.cert, nil
+}
+
+// latestModTime returns the latest modification time of the files
+func latestModTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// isTCPEndpoint reports whether an endpoint listens on TCP
+func isTCPEndpoint(endpoint string) bool {
+	proto, _, err := parseEndpoint(endpoint)
+	return err == nil && strings.HasPrefix(proto, "tcp")
+}
@@ -0,0 +1,179 @@
+package driver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// writeCertificate writes a PEM certificate and key for 127.0.0.1 to dir, signed by parent
+// or self-signed as a CA when parent is nil
+func writeCertificate(t *testing.T, dir, name string, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, interface{}(key)
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert.Leaf, _ = x509.ParseCertificate(der)
+	return cert
+}
+
+// TestEndpointTLS tests serving the CSI endpoint with TLS and mutual TLS
+func TestEndpointTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := writeCertificate(t, dir, "ca", nil)
+	writeCertificate(t, dir, "server", &ca)
+	client := writeCertificate(t, dir, "client", &ca)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+
+	endpointTLS := EndpointTLS{
+		CertFile:     filepath.Join(dir, "server.crt"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+	}
+	config, err := endpointTLS.Config()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv, err := NewDriver("node-1", "tcp://"+listener.Addr().String(), WithEndpointTLS(config))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	drv.SetIdentityService(NewIdentityService(drv))
+	drv.SetListener(listener)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- drv.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	tests := []struct {
+		name         string
+		certificates []tls.Certificate
+		wantErr      bool
+	}{
+		{name: "client certificate", certificates: []tls.Certificate{client}},
+		{name: "no client certificate", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creds := credentials.NewTLS(&tls.Config{RootCAs: roots, Certificates: tt.certificates})
+			conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(creds))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			callCtx, callCancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer callCancel()
+			_, err = csi.NewIdentityClient(conn).GetPluginInfo(callCtx, &csi.GetPluginInfoRequest{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetPluginInfo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := NewDriver("node-1", "unix:///tmp/csi.sock", WithEndpointTLS(config)); err == nil {
+		t.Error("expected TLS on a unix socket to be rejected")
+	}
+	if _, err := (EndpointTLS{CertFile: endpointTLS.CertFile}).Config(); err == nil {
+		t.Error("expected an error for a certificate without key")
+	}
+	if _, err := (EndpointTLS{CertFile: endpointTLS.CertFile, KeyFile: endpointTLS.KeyFile, ClientCAFile: endpointTLS.KeyFile}).Config(); err == nil {
+		t.Error("expected an error for a client CA file without certificates")
+	}
+}
+
+// TestCertReloader tests that rotated certificates are served without a restart
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca := writeCertificate(t, dir, "ca", nil)
+	first := writeCertificate(t, dir, "server", &ca)
+	reloader := &certReloader{certFile: filepath.Join(dir, "server.crt"), keyFile: filepath.Join(dir, "server.key")}
+
+	cert, err := reloader.certificate()
+	if err != nil || !cert.Leaf.Equal(first.Leaf) {
+		t.Fatalf("expected the first certificate, got error %v", err)
+	}
+
+	second := writeCertificate(t, dir, "server", &ca)
+	later := time.Now().Add(time.Minute)
+	for _, path := range []string{reloader.certFile, reloader.keyFile} {
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cert, err = reloader.certificate(); err != nil || !cert.Leaf.Equal(second.Leaf) {
+		t.Errorf("expected the rotated certificate, got error %v", err)
+	}
+
+	// A broken rotation keeps the previous certificate
+	if err := os.WriteFile(reloader.keyFile, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	evenLater := later.Add(time.Minute)
+	if err := os.Chtimes(reloader.keyFile, evenLater, evenLater); err != nil {
+		t.Fatal(err)
+	}
+	if cert, err = reloader.certificate(); err != nil || !cert.Leaf.Equal(second.Leaf) {
+		t.Errorf("expected the previous certificate after a failed reload, got error %v", err)
+	}
+}
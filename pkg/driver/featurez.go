@@ -0,0 +1,98 @@
+package driver
+
+import (
+	"context"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+
+	"github.com/emma-csi-driver/pkg/notify"
+)
+
+// Features is what a running driver advertises to Kubernetes and which optional features
+// its configuration enables, as served on /featurez
+type Features struct {
+	Driver  string `json:"driver"`
+	Version string `json:"version"`
+
+	// PluginCapabilities, ControllerCapabilities and NodeCapabilities are the capabilities
+	// the CSI services return, omitted for services this process does not run
+	PluginCapabilities     []string `json:"pluginCapabilities,omitempty"`
+	ControllerCapabilities []string `json:"controllerCapabilities,omitempty"`
+	NodeCapabilities       []string `json:"nodeCapabilities,omitempty"`
+
+	// Features maps optional features to whether they are enabled
+	Features map[string]bool `json:"features"`
+}
+
+// featureReporter is implemented by services with optional features
+type featureReporter interface {
+	features() map[string]bool
+}
+
+// Features returns the capabilities the registered services advertise and the optional
+// features they enable, so admins can check them before deploying dependent sidecars
+func (d *Driver) Features(ctx context.Context) Features {
+	features := Features{
+		Driver:   d.name,
+		Version:  d.version,
+		Features: make(map[string]bool),
+	}
+
+	if d.identityService != nil {
+		if resp, err := d.identityService.GetPluginCapabilities(ctx, &csi.GetPluginCapabilitiesRequest{}); err == nil {
+			for _, capability := range resp.GetCapabilities() {
+				if service := capability.GetService(); service != nil {
+					features.PluginCapabilities = append(features.PluginCapabilities, service.GetType().String())
+					if service.GetType() == csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS {
+						features.Features["topology"] = true
+					}
+				}
+			}
+		}
+	}
+	if d.controllerService != nil {
+		if resp, err := d.controllerService.ControllerGetCapabilities(ctx, &csi.ControllerGetCapabilitiesRequest{}); err == nil {
+			for _, capability := range resp.GetCapabilities() {
+				features.ControllerCapabilities = append(features.ControllerCapabilities, capability.GetRpc().GetType().String())
+			}
+		}
+	}
+	if d.nodeService != nil {
+		if resp, err := d.nodeService.NodeGetCapabilities(ctx, &csi.NodeGetCapabilitiesRequest{}); err == nil {
+			for _, capability := range resp.GetCapabilities() {
+				features.NodeCapabilities = append(features.NodeCapabilities, capability.GetRpc().GetType().String())
+			}
+		}
+	}
+
+	for _, service := range []interface{}{d.controllerService, d.nodeService} {
+		if reporter, ok := service.(featureReporter); ok {
+			for name, enabled := range reporter.features() {
+				features.Features[name] = enabled
+			}
+		}
+	}
+	return features
+}
+
+// features reports the optional controller features
+func (s *ControllerService) features() map[string]bool {
+	return map[string]bool{
+		"snapshots":                false,
+		"cloning":                  false,
+		"webhooks":                 s.notifier != notify.Nop(),
+		"volumeTagging":            s.tagVolumes,
+		"attachFailureRemediation": s.attachFailureRemediation,
+		"minVolumeSize":            s.minVolumeSize > 0,
+	}
+}
+
+// features reports the optional node features
+func (s *NodeService) features() map[string]bool {
+	return map[string]bool{
+		"encryption":      true,
+		"blockVolumes":    false,
+		"deviceCache":     s.deviceCache != nil,
+		"persistentState": s.state.persistent(),
+	}
+}
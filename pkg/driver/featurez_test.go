@@ -0,0 +1,48 @@
+package driver
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestDriverFeatures tests that /featurez reports the advertised capabilities and enabled features
+func TestDriverFeatures(t *testing.T) {
+	drv := &Driver{name: "csi.emma.ms", version: "1.0.0"}
+	controller := NewControllerService(drv, nil)
+	drv.SetIdentityService(NewIdentityService(drv))
+	drv.SetControllerService(controller)
+
+	features := drv.Features(context.Background())
+	if !slices.Contains(features.PluginCapabilities, "VOLUME_ACCESSIBILITY_CONSTRAINTS") || !features.Features["topology"] {
+		t.Errorf("expected topology to be advertised, got %+v", features)
+	}
+	if !slices.Contains(features.ControllerCapabilities, "CREATE_DELETE_VOLUME") {
+		t.Errorf("expected CREATE_DELETE_VOLUME, got %v", features.ControllerCapabilities)
+	}
+	if len(features.NodeCapabilities) != 0 {
+		t.Errorf("expected no node capabilities without a node service, got %v", features.NodeCapabilities)
+	}
+	if features.Features["webhooks"] || features.Features["snapshots"] {
+		t.Errorf("expected webhooks and snapshots to be disabled, got %v", features.Features)
+	}
+
+	controller.SetNotifier(&recordingNotifier{})
+	if !drv.Features(context.Background()).Features["webhooks"] {
+		t.Error("expected webhooks to be enabled with a notifier")
+	}
+
+	node := NewNodeService(drv)
+	drv.SetNodeService(node)
+	if err := node.SetDeviceCacheFile(filepath.Join(t.TempDir(), "devices.json")); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.SetStateFile(filepath.Join(t.TempDir(), "state.json")); err != nil {
+		t.Fatal(err)
+	}
+	features = drv.Features(context.Background())
+	if !slices.Contains(features.NodeCapabilities, "STAGE_UNSTAGE_VOLUME") || !features.Features["deviceCache"] || !features.Features["persistentState"] || !features.Features["encryption"] {
+		t.Errorf("expected node capabilities and features, got %+v", features)
+	}
+}
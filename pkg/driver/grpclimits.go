@@ -0,0 +1,107 @@
+package driver
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+
+	"github.com/emma-csi-driver/pkg/logging"
+)
+
+// ServerLimits bounds what clients of the CSI endpoint can make the gRPC server hold: the
+// size of requests, idle and misbehaving connections, and the number of calls handled at
+// once. Zero values leave the gRPC defaults in place.
+type ServerLimits struct {
+	// MaxRecvMsgSize is the largest request in bytes the server accepts
+	MaxRecvMsgSize int
+
+	// MaxConcurrentStreams caps the concurrent calls on each connection
+	MaxConcurrentStreams uint32
+
+	// MaxInFlight caps the calls handled at once across all connections; calls beyond it
+	// fail with Unavailable, which the sidecars retry
+	MaxInFlight int
+
+	// KeepaliveTime is how long a connection is idle before the server pings the client,
+	// and KeepaliveTimeout how long it then waits for the ping ack before closing it
+	KeepaliveTime    time.Duration
+	KeepaliveTimeout time.Duration
+
+	// KeepaliveMinTime is the shortest interval between client pings the server tolerates;
+	// clients pinging more often are disconnected
+	KeepaliveMinTime time.Duration
+
+	// KeepalivePermitWithoutStream allows client pings on connections without calls
+	KeepalivePermitWithoutStream bool
+}
+
+// DefaultServerLimits returns the limits of the CSI endpoint: requests of at most 4MiB,
+// unlimited concurrent calls, and the gRPC keepalive enforcement defaults with pings from
+// idle sidecar connections allowed
+func DefaultServerLimits() ServerLimits {
+	return ServerLimits{
+		MaxRecvMsgSize:               4 << 20,
+		KeepaliveTime:                2 * time.Hour,
+		KeepaliveTimeout:             20 * time.Second,
+		KeepaliveMinTime:             5 * time.Minute,
+		KeepalivePermitWithoutStream: true,
+	}
+}
+
+// ServerOptions returns the gRPC server options enforcing the limits, for WithGRPCOptions
+func (l ServerLimits) ServerOptions() []grpc.ServerOption {
+	var opts []grpc.ServerOption
+	if l.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(l.MaxRecvMsgSize))
+	}
+	if l.MaxConcurrentStreams > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(l.MaxConcurrentStreams))
+	}
+	if l.MaxInFlight > 0 {
+		opts = append(opts, grpc.ChainUnaryInterceptor(limitInFlight(l.MaxInFlight)))
+	}
+	opts = append(opts,
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    l.KeepaliveTime,
+			Timeout: l.KeepaliveTimeout,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             l.KeepaliveMinTime,
+			PermitWithoutStream: l.KeepalivePermitWithoutStream,
+		}),
+	)
+	return opts
+}
+
+// limitInFlight returns an interceptor failing calls with Unavailable while max calls are
+// being handled
+func limitInFlight(max int) grpc.UnaryServerInterceptor {
+	slots := make(chan struct{}, max)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			return handler(ctx, req)
+		default:
+			return nil, status.Errorf(codes.Unavailable, "%s rejected: %d CSI calls already in progress", info.FullMethod, max)
+		}
+	}
+}
+
+// recoverPanic turns a panic in a handler into an Internal error for that call, so one
+// malformed request cannot take down the plugin
+func recoverPanic(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			klog.Errorf("Panic in %s (CSI request ID %s): %v\n%s", info.FullMethod, logging.CSIRequestIDFromContext(ctx), r, debug.Stack())
+			resp, err = nil, status.Errorf(codes.Internal, "internal error handling %s: %v", info.FullMethod, r)
+		}
+	}()
+	return handler(ctx, req)
+}
@@ -0,0 +1,101 @@
+package driver
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// panickingIdentity is an identity service whose Probe panics
+type panickingIdentity struct {
+	csi.UnimplementedIdentityServer
+}
+
+func (*panickingIdentity) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	panic("malformed request")
+}
+
+// startLimitedServer serves the services with limits on a local port until the test ends
+func startLimitedServer(t *testing.T, limits ServerLimits, ids csi.IdentityServer, cs csi.ControllerServer) *grpc.ClientConn {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewNonBlockingGRPCServer(limits.ServerOptions()...)
+	srv.StartWithListener(listener, ids, cs, nil)
+	t.Cleanup(func() { srv.GracefulStop(time.Second) })
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// TestServerLimits tests the message size and in-flight limits and panic recovery
+func TestServerLimits(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("panic becomes Internal", func(t *testing.T) {
+		conn := startLimitedServer(t, DefaultServerLimits(), &panickingIdentity{}, nil)
+		client := csi.NewIdentityClient(conn)
+		for i := 0; i < 2; i++ {
+			if _, err := client.Probe(ctx, &csi.ProbeRequest{}); status.Code(err) != codes.Internal {
+				t.Fatalf("expected Internal, got %v", err)
+			}
+		}
+	})
+
+	t.Run("oversized request", func(t *testing.T) {
+		limits := DefaultServerLimits()
+		limits.MaxRecvMsgSize = 1024
+		conn := startLimitedServer(t, limits, nil, &csi.UnimplementedControllerServer{})
+		_, err := csi.NewControllerClient(conn).ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+			VolumeId:      "1",
+			VolumeContext: map[string]string{"padding": strings.Repeat("x", 2048)},
+		})
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("expected ResourceExhausted, got %v", err)
+		}
+	})
+
+	t.Run("in-flight limit", func(t *testing.T) {
+		limits := DefaultServerLimits()
+		limits.MaxInFlight = 1
+		identity := &blockingIdentity{release: make(chan struct{})}
+		conn := startLimitedServer(t, limits, identity, nil)
+		client := csi.NewIdentityClient(conn)
+
+		first := make(chan error, 1)
+		go func() {
+			_, err := client.Probe(ctx, &csi.ProbeRequest{})
+			first <- err
+		}()
+
+		// Retry until the first call holds the only slot
+		var err error
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if _, err = client.GetPluginCapabilities(ctx, &csi.GetPluginCapabilitiesRequest{}); status.Code(err) == codes.Unavailable {
+				break
+			}
+		}
+		if status.Code(err) != codes.Unavailable {
+			t.Errorf("expected Unavailable beyond the in-flight limit, got %v", err)
+		}
+
+		close(identity.release)
+		if err := <-first; err != nil {
+			t.Errorf("expected the first call to succeed, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package driver
+
+import (
+	"context"
+	"time"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// healthCheckInterval is how often the gRPC health service status is refreshed
+	healthCheckInterval = 30 * time.Second
+
+	// healthCheckTimeout bounds the Emma token check of a refresh
+	healthCheckTimeout = 10 * time.Second
+
+	// Health service names of the CSI services, their gRPC service names
+	healthServiceIdentity   = "csi.v1.Identity"
+	healthServiceController = "csi.v1.Controller"
+	healthServiceNode       = "csi.v1.Node"
+)
+
+// tokenChecker is implemented by Emma clients that can verify their access token
+type tokenChecker interface {
+	CheckToken(ctx context.Context) error
+}
+
+// healthStatus returns the serving status of each registered CSI service, and of the
+// whole server under "". Identity must be registered with the controller or node service,
+// and the controller additionally needs a valid Emma access token.
+func (d *Driver) healthStatus(ctx context.Context) map[string]healthpb.HealthCheckResponse_ServingStatus {
+	statuses := make(map[string]healthpb.HealthCheckResponse_ServingStatus)
+	serving := func(ok bool) healthpb.HealthCheckResponse_ServingStatus {
+		if ok {
+			return healthpb.HealthCheckResponse_SERVING
+		}
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	}
+
+	healthy := d.identityService != nil && (d.controllerService != nil || d.nodeService != nil)
+	if d.identityService != nil {
+		statuses[healthServiceIdentity] = serving(true)
+	}
+	if d.controllerService != nil {
+		tokenValid := true
+		if checker, ok := d.emmaClient.(tokenChecker); ok {
+			checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			if err := checker.CheckToken(checkCtx); err != nil {
+				klog.Warningf("Health check: Emma API access token unavailable: %v", err)
+				tokenValid = false
+			}
+			cancel()
+		}
+		statuses[healthServiceController] = serving(tokenValid)
+		healthy = healthy && tokenValid
+	}
+	if d.nodeService != nil {
+		statuses[healthServiceNode] = serving(true)
+	}
+	statuses[""] = serving(healthy)
+	return statuses
+}
+
+// updateHealth publishes the current serving status on the gRPC health service
+func (d *Driver) updateHealth(ctx context.Context) {
+	for service, status := range d.healthStatus(ctx) {
+		d.srv.SetServingStatus(service, status)
+	}
+}
+
+// watchHealth refreshes the gRPC health service status until ctx is canceled
+func (d *Driver) watchHealth(ctx context.Context) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.updateHealth(ctx)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"github.com/emma-csi-driver/internal/fakeemma"
+	"testing"
+
+	emma "github.com/emma-community/emma-go-sdk"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// tokenClient is an Emma client whose access token check fails with err
+type tokenClient struct {
+	err error
+}
+
+func (c *tokenClient) GetDataCenters(ctx context.Context) ([]emma.DataCenter, error) {
+	return nil, nil
+}
+
+func (c *tokenClient) CheckToken(ctx context.Context) error {
+	return c.err
+}
+
+// TestHealthStatus tests the statuses reported by the gRPC health service
+func TestHealthStatus(t *testing.T) {
+	const serving, notServing = healthpb.HealthCheckResponse_SERVING, healthpb.HealthCheckResponse_NOT_SERVING
+
+	tests := []struct {
+		name       string
+		controller bool
+		node       bool
+		identity   bool
+		client     EmmaClient
+		expected   map[string]healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{
+			name:     "node plugin",
+			identity: true, node: true,
+			expected: map[string]healthpb.HealthCheckResponse_ServingStatus{"": serving, healthServiceIdentity: serving, healthServiceNode: serving},
+		},
+		{
+			name:     "controller with valid token",
+			identity: true, controller: true, client: &tokenClient{},
+			expected: map[string]healthpb.HealthCheckResponse_ServingStatus{"": serving, healthServiceIdentity: serving, healthServiceController: serving},
+		},
+		{
+			name:     "controller without token",
+			identity: true, controller: true, client: &tokenClient{err: errors.New("401 Unauthorized")},
+			expected: map[string]healthpb.HealthCheckResponse_ServingStatus{"": notServing, healthServiceIdentity: serving, healthServiceController: notServing},
+		},
+		{
+			name:     "identity only",
+			identity: true,
+			expected: map[string]healthpb.HealthCheckResponse_ServingStatus{"": notServing, healthServiceIdentity: serving},
+		},
+		{
+			name:     "no identity service",
+			node:     true,
+			expected: map[string]healthpb.HealthCheckResponse_ServingStatus{"": notServing, healthServiceNode: serving},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drv, err := NewDriver("node-1", "unix:///tmp/csi.sock")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.identity {
+				drv.SetIdentityService(NewIdentityService(drv))
+			}
+			if tt.controller {
+				drv.SetControllerService(NewControllerService(drv, fakeemma.New()))
+				drv.SetEmmaClient(tt.client)
+			}
+			if tt.node {
+				drv.SetNodeService(NewNodeService(drv))
+			}
+
+			statuses := drv.healthStatus(context.Background())
+			if len(statuses) != len(tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, statuses)
+			}
+			for service, expected := range tt.expected {
+				if statuses[service] != expected {
+					t.Errorf("service %q: expected %s, got %s", service, expected, statuses[service])
+				}
+			}
+		})
+	}
+}
@@ -6,7 +6,8 @@ import (
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	"k8s.io/klog/v2"
+
+	"github.com/emma-csi-driver/pkg/logging"
 )
 
 // IdentityService implements the CSI Identity service
@@ -23,7 +24,7 @@ func NewIdentityService(driver *Driver) *IdentityService {
 
 // GetPluginInfo returns plugin information
 func (s *IdentityService) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
-	klog.V(4).Info("GetPluginInfo called")
+	logging.Klog(ctx).V(4).Info("GetPluginInfo called")
 
 	if s.driver.name == "" {
 		return nil, status.Error(codes.Unavailable, "driver name not configured")
@@ -41,7 +42,7 @@ func (s *IdentityService) GetPluginInfo(ctx context.Context, req *csi.GetPluginI
 
 // GetPluginCapabilities returns plugin capabilities
 func (s *IdentityService) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
-	klog.V(4).Info("GetPluginCapabilities called")
+	logging.Klog(ctx).V(4).Info("GetPluginCapabilities called")
 
 	return &csi.GetPluginCapabilitiesResponse{
 		Capabilities: []*csi.PluginCapability{
@@ -65,7 +66,7 @@ func (s *IdentityService) GetPluginCapabilities(ctx context.Context, req *csi.Ge
 
 // Probe checks if the plugin is ready
 func (s *IdentityService) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
-	klog.V(4).Info("Probe called")
+	logging.Klog(ctx).V(4).Info("Probe called")
 
 	// Perform Emma API health check if client is available
 	if s.driver.emmaClient != nil {
@@ -73,10 +74,10 @@ func (s *IdentityService) Probe(ctx context.Context, req *csi.ProbeRequest) (*cs
 		// This verifies authentication and API connectivity
 		_, err := s.driver.emmaClient.GetDataCenters(ctx)
 		if err != nil {
-			klog.Errorf("Emma API health check failed: %v", err)
+			logging.Klog(ctx).Errorf("Emma API health check failed: %v", err)
 			return &csi.ProbeResponse{}, nil
 		}
-		klog.V(4).Info("Emma API health check passed")
+		logging.Klog(ctx).V(4).Info("Emma API health check passed")
 	}
 
 	return &csi.ProbeResponse{}, nil
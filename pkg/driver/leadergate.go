@@ -0,0 +1,41 @@
+package driver
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// mutatingControllerMethods are the Controller calls that change volumes or snapshots in
+// Emma. Reads such as ListVolumes and GetCapacity are served by every replica.
+var mutatingControllerMethods = map[string]bool{
+	"CreateVolume":              true,
+	"DeleteVolume":              true,
+	"ControllerPublishVolume":   true,
+	"ControllerUnpublishVolume": true,
+	"CreateSnapshot":            true,
+	"DeleteSnapshot":            true,
+	"ControllerExpandVolume":    true,
+	"ControllerModifyVolume":    true,
+}
+
+// WithLeaderGate has only the replica holding the controller Lease serve mutating
+// Controller calls. Followers fail them with Unavailable, which the sidecars retry, so two
+// replicas never change the same volume at once.
+func WithLeaderGate(isLeader func() bool) Option {
+	return WithGRPCOptions(grpc.ChainUnaryInterceptor(leaderOnly(isLeader)))
+}
+
+// leaderOnly returns an interceptor failing mutating Controller calls with Unavailable
+// while isLeader reports false
+func leaderOnly(isLeader func() bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		service, method := csiMethod(info.FullMethod)
+		if service == "Controller" && mutatingControllerMethods[method] && !isLeader() {
+			return nil, status.Errorf(codes.Unavailable, "%s rejected: this controller replica is not the leader", info.FullMethod)
+		}
+		return handler(ctx, req)
+	}
+}
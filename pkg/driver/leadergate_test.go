@@ -0,0 +1,39 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestLeaderOnly tests that followers reject mutating Controller calls and serve the rest
+func TestLeaderOnly(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		leader   bool
+		expected codes.Code
+	}{
+		{name: "leader creates", method: "/csi.v1.Controller/CreateVolume", leader: true, expected: codes.OK},
+		{name: "follower rejects create", method: "/csi.v1.Controller/CreateVolume", expected: codes.Unavailable},
+		{name: "follower rejects publish", method: "/csi.v1.Controller/ControllerPublishVolume", expected: codes.Unavailable},
+		{name: "follower rejects expand", method: "/csi.v1.Controller/ControllerExpandVolume", expected: codes.Unavailable},
+		{name: "follower lists volumes", method: "/csi.v1.Controller/ListVolumes", expected: codes.OK},
+		{name: "follower probes", method: "/csi.v1.Identity/Probe", expected: codes.OK},
+		{name: "follower stages on the node", method: "/csi.v1.Node/NodeStageVolume", expected: codes.OK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			interceptor := leaderOnly(func() bool { return tt.leader })
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+			_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			if status.Code(err) != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
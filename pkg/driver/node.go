@@ -2,13 +2,19 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"k8s.io/klog/v2"
 
+	"github.com/emma-csi-driver/pkg/logging"
+	"github.com/emma-csi-driver/pkg/metrics"
 	"github.com/emma-csi-driver/pkg/mount"
 )
 
@@ -16,29 +22,163 @@ import (
 type NodeService struct {
 	driver  *Driver
 	mounter mount.Mounter
+
+	// allowedMountOptions are unsafe mount options permitted by the operator
+	allowedMountOptions map[string]bool
+
+	// unstageFlushMode is the default flush mode applied before unmounting on unstage
+	unstageFlushMode string
+
+	// state remembers the staging path and unstage settings of each staged volume. Unless a
+	// state file is set it is not persisted, after a restart the default flush mode applies.
+	state *nodeState
+
+	// deviceCache persists the device of each staged volume, nil when disabled
+	deviceCache *deviceCache
+
+	// volumeLocks rejects concurrent operations on the same volume, e.g. kubelet retries
+	// racing a slow mkfs
+	volumeLocks *volumeLocks
+
+	// maxVolumesPerNode is the number of volumes NodeGetInfo reports as attachable
+	maxVolumesPerNode int64
+
+	// topology resolves the node's topology from its Emma VM, nil without an Emma client
+	topology *nodeTopology
 }
 
-// NewNodeService creates a new node service
+// NewNodeService creates a new node service using the mounter set with WithMounter, by
+// default the system mounter
 func NewNodeService(driver *Driver) *NodeService {
+	mounter := driver.mounter
+	if mounter == nil {
+		mounter = mount.NewMounter()
+	}
 	return &NodeService{
-		driver:  driver,
-		mounter: mount.NewMounter(),
+		driver:           driver,
+		mounter:          mounter,
+		unstageFlushMode: mount.FlushModeNone,
+		state:            newNodeState(),
+		volumeLocks:      newVolumeLocks(),
+
+		maxVolumesPerNode: defaultMaxVolumesPerNode,
+	}
+}
+
+// SetMounterOptions replaces the mounter with one that formats devices and creates mount
+// directories using opts
+func (s *NodeService) SetMounterOptions(opts mount.MounterOptions) {
+	s.mounter = mount.NewMounterWithOptions(opts)
+}
+
+// SetMounter replaces the mounter, e.g. with a mount.FakeMounter to run without block devices
+func (s *NodeService) SetMounter(mounter mount.Mounter) {
+	s.mounter = mounter
+}
+
+// pathResizer is implemented by mounters that resize filesystems through their mount
+// path, as xfs requires
+type pathResizer interface {
+	ResizeFilesystemAtPath(mountPath, fstype string) error
+}
+
+// SetAllowedMountOptions permits unsafe mount options (e.g. suid, dev) that are otherwise stripped
+func (s *NodeService) SetAllowedMountOptions(options []string) {
+	allowed := make(map[string]bool, len(options))
+	for _, option := range options {
+		if option = strings.ToLower(strings.TrimSpace(option)); option != "" {
+			allowed[option] = true
+		}
+	}
+	s.allowedMountOptions = allowed
+}
+
+// sanitizeMountOptions replaces unsafe mount options that are not explicitly allowed with
+// their safe negation
+func (s *NodeService) sanitizeMountOptions(ctx context.Context, volumeID string, options []string) []string {
+	kept, removed := mount.SanitizeMountOptions(options, s.allowedMountOptions)
+	if len(removed) > 0 {
+		logging.Klog(ctx).Warningf("Removed unsafe mount options %v from volume %s (allow with --allowed-mount-options)", removed, volumeID)
+	}
+	return kept
+}
+
+// SetUnstageFlushMode sets the default flush mode applied before unmounting on unstage
+func (s *NodeService) SetUnstageFlushMode(mode string) error {
+	if err := mount.ValidateFlushMode(mode); err != nil {
+		return err
+	}
+	s.unstageFlushMode = mode
+	return nil
+}
+
+// SetDeviceCacheFile persists the device of each staged volume in path, which restaging and
+// expansion try before discovering the device
+func (s *NodeService) SetDeviceCacheFile(path string) error {
+	cache, err := loadDeviceCache(path)
+	if err != nil {
+		return err
 	}
+	s.deviceCache = cache
+	return nil
 }
 
-// NodeStageVolume stages a volume
+// SetStateFile persists the node state in path and loads the state saved there, dropping
+// volumes that are no longer staged along with their cached devices
+func (s *NodeService) SetStateFile(path string) error {
+	state, err := loadNodeState(path)
+	if err != nil {
+		return err
+	}
+	s.state = state
+	s.reconcileState()
+	return nil
+}
+
+// reconcileState drops volumes whose staging path is no longer mounted, e.g. after a node
+// reboot or an unstage while the plugin was down
+func (s *NodeService) reconcileState() {
+	var kept, dropped int
+	for _, volumeID := range s.state.VolumeIDs() {
+		volume, _ := s.state.Get(volumeID)
+		if volume.StagingPath != "" {
+			notMnt, err := s.mounter.IsLikelyNotMountPoint(volume.StagingPath)
+			if err != nil && !os.IsNotExist(err) {
+				klog.Warningf("Failed to check staging path %s of volume %s, keeping its state: %v", volume.StagingPath, volumeID, err)
+				kept++
+				continue
+			}
+			if err == nil && !notMnt {
+				kept++
+				continue
+			}
+		}
+		klog.V(4).Infof("Volume %s is no longer staged, dropping its state", volumeID)
+		s.forgetStagedVolume(volumeID)
+		dropped++
+	}
+	klog.Infof("Node state: %d staged volumes, dropped %d no longer staged", kept, dropped)
+}
+
+// NodeStageVolume formats and mounts a volume at the staging path
 func (s *NodeService) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
 	volumeID := req.GetVolumeId()
 	stagingTargetPath := req.GetStagingTargetPath()
 
-	klog.Infof("NodeStageVolume: Starting staging for volume %s to %s", volumeID, stagingTargetPath)
-	klog.V(4).Infof("NodeStageVolume called with full request: %+v", req)
+	logging.Klog(ctx).Infof("NodeStageVolume: Starting staging for volume %s to %s", volumeID, stagingTargetPath)
+	logging.Klog(ctx).V(4).Infof("NodeStageVolume called with full request: %+v", stripSecrets(req))
 
 	// Validate request
 	if volumeID == "" {
 		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
 	}
 
+	unlock, err := s.volumeLocks.lock(volumeID, "NodeStageVolume")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
 	if stagingTargetPath == "" {
 		return nil, status.Error(codes.InvalidArgument, "staging target path is required")
 	}
@@ -61,6 +201,32 @@ func (s *NodeService) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 		return nil, status.Errorf(codes.InvalidArgument, "unsupported filesystem type: %s", fsType)
 	}
 
+	// Per-volume flush mode from the StorageClass, falling back to the node default
+	flushMode := s.unstageFlushMode
+	if mode, ok := req.GetVolumeContext()[paramUnstageFlush]; ok && mode != "" {
+		if err := mount.ValidateFlushMode(mode); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %v", paramUnstageFlush, err)
+		}
+		flushMode = mode
+	}
+	expectFormatted, err := parseExpectFormatted(req.GetVolumeContext())
+	if err != nil {
+		return nil, err
+	}
+	forceReformat, err := parseForceReformat(req.GetVolumeContext())
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := parseEncrypted(req.GetVolumeContext())
+	if err != nil {
+		return nil, err
+	}
+	passphrase := req.GetSecrets()[secretEncryptionPassphrase]
+	if encrypted && passphrase == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %s is encrypted but the node-stage secret has no %s key; set csi.storage.k8s.io/node-stage-secret-name and -namespace on the StorageClass", volumeID, secretEncryptionPassphrase)
+	}
+	s.rememberStagedVolume(volumeID, stagingTargetPath, fsType, flushMode)
+
 	// Check if already staged
 	notMnt, err := s.mounter.IsLikelyNotMountPoint(stagingTargetPath)
 	if err != nil && !os.IsNotExist(err) {
@@ -68,39 +234,84 @@ func (s *NodeService) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 	}
 
 	if !notMnt {
-		klog.V(4).Infof("Volume %s is already staged at %s", volumeID, stagingTargetPath)
+		logging.Klog(ctx).V(4).Infof("Volume %s is already staged at %s", volumeID, stagingTargetPath)
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
 	// Discover the device path for the volume
-	klog.Infof("NodeStageVolume: Discovering device path for volume %s", volumeID)
-	devicePath, err := s.mounter.GetDevicePath(volumeID)
+	logging.Klog(ctx).Infof("NodeStageVolume: Discovering device path for volume %s", volumeID)
+	hints, err := deviceHints(req.GetPublishContext(), req.GetVolumeContext())
 	if err != nil {
-		klog.Errorf("NodeStageVolume: Failed to find device for volume %s: %v", volumeID, err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	devicePath, err := s.findDevice(ctx, volumeID, hints)
+	if err != nil {
+		logging.Klog(ctx).Errorf("NodeStageVolume: Failed to find device for volume %s: %v", volumeID, err)
 		return nil, status.Errorf(codes.Internal, "failed to find device for volume %s: %v", volumeID, err)
 	}
 
-	klog.Infof("NodeStageVolume: Found device %s for volume %s", devicePath, volumeID)
+	logging.Klog(ctx).Infof("NodeStageVolume: Found device %s for volume %s", devicePath, volumeID)
+	discoveredDevice := devicePath
+
+	// Encrypted volumes hold a LUKS container, the filesystem lives on its decrypted device
+	if encrypted {
+		devicePath, err = s.mounter.OpenEncryptedDevice(devicePath, mount.EncryptedDeviceName(volumeID), passphrase, forceReformat, expectFormatted)
+		if err != nil {
+			if errors.Is(err, mount.ErrNotFormatted) {
+				return nil, status.Errorf(codes.FailedPrecondition, "volume %s is expected to contain data: %v", volumeID, err)
+			}
+			if errors.Is(err, mount.ErrFilesystemMismatch) {
+				return nil, status.Errorf(codes.FailedPrecondition, "volume %s was not encrypted: %v; set the %s StorageClass parameter or PV volume attribute to \"true\" to encrypt it and destroy its data", volumeID, err, paramForceReformat)
+			}
+			return nil, status.Errorf(codes.Internal, "failed to open encrypted device: %v", err)
+		}
+		logging.Klog(ctx).Infof("NodeStageVolume: Opened encrypted device %s for volume %s", devicePath, volumeID)
+	}
 
 	// Get mount options
 	mountOptions := []string{}
 	if mnt := volumeCapability.GetMount(); mnt != nil {
-		mountOptions = mnt.MountFlags
+		mountOptions = s.sanitizeMountOptions(ctx, volumeID, mnt.MountFlags)
 	}
 
-	// Format and mount the device
-	klog.V(4).Infof("Formatting and mounting device %s to %s with fstype %s", devicePath, stagingTargetPath, fsType)
-	if err := s.mounter.FormatAndMount(devicePath, stagingTargetPath, fsType, mountOptions); err != nil {
+	// Volumes expected to hold data are never formatted, whatever blkid reports
+	if expectFormatted {
+		logging.Klog(ctx).V(4).Infof("Mounting formatted device %s to %s with fstype %s", devicePath, stagingTargetPath, fsType)
+		if err := s.mounter.MountFormatted(devicePath, stagingTargetPath, fsType, mountOptions); err != nil {
+			if errors.Is(err, mount.ErrNotFormatted) {
+				return nil, status.Errorf(codes.FailedPrecondition, "volume %s is expected to contain data: %v", volumeID, err)
+			}
+			return nil, status.Errorf(codes.Internal, "failed to mount device: %v", err)
+		}
+		s.deviceCache.Set(volumeID, discoveredDevice)
+		logging.Klog(ctx).Infof("Successfully staged volume %s at %s", volumeID, stagingTargetPath)
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
+	// Format and mount the device, reformatting a different filesystem only when the StorageClass forces it
+	logging.Klog(ctx).V(4).Infof("Formatting and mounting device %s to %s with fstype %s", devicePath, stagingTargetPath, fsType)
+	formatAndMount := s.mounter.FormatAndMount
+	if forceReformat {
+		formatAndMount = s.mounter.ReformatAndMount
+	}
+	if err := formatAndMount(devicePath, stagingTargetPath, fsType, mountOptions); err != nil {
+		if errors.Is(err, mount.ErrFilesystemMismatch) {
+			return nil, status.Errorf(codes.FailedPrecondition, "volume %s was not formatted: %v; set the %s StorageClass parameter or PV volume attribute to \"true\" to reformat it and destroy its data", volumeID, err, paramForceReformat)
+		}
+		if errors.Is(err, mount.ErrFormatBusy) {
+			return nil, status.Errorf(codes.Unavailable, "failed to format volume %s: %v", volumeID, err)
+		}
 		return nil, status.Errorf(codes.Internal, "failed to format and mount device: %v", err)
 	}
 
-	klog.Infof("Successfully staged volume %s at %s", volumeID, stagingTargetPath)
+	s.deviceCache.Set(volumeID, discoveredDevice)
+	logging.Klog(ctx).Infof("Successfully staged volume %s at %s", volumeID, stagingTargetPath)
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
 // NodeUnstageVolume unstages a volume
 func (s *NodeService) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
-	klog.V(4).Infof("NodeUnstageVolume called with request: %+v", req)
+	logging.Klog(ctx).V(4).Infof("NodeUnstageVolume called with request: %+v", stripSecrets(req))
 
 	// Validate request
 	volumeID := req.GetVolumeId()
@@ -108,6 +319,12 @@ func (s *NodeService) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
 	}
 
+	unlock, err := s.volumeLocks.lock(volumeID, "NodeUnstageVolume")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
 	stagingTargetPath := req.GetStagingTargetPath()
 	if stagingTargetPath == "" {
 		return nil, status.Error(codes.InvalidArgument, "staging target path is required")
@@ -117,37 +334,106 @@ func (s *NodeService) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 	notMnt, err := s.mounter.IsLikelyNotMountPoint(stagingTargetPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			klog.V(4).Infof("Staging path %s does not exist, nothing to unstage", stagingTargetPath)
+			logging.Klog(ctx).V(4).Infof("Staging path %s does not exist, nothing to unstage", stagingTargetPath)
+			if err := s.closeEncryptedDevice(volumeID); err != nil {
+				return nil, err
+			}
+			s.forgetStagedVolume(volumeID)
 			return &csi.NodeUnstageVolumeResponse{}, nil
 		}
 		return nil, status.Errorf(codes.Internal, "failed to check if %s is a mount point: %v", stagingTargetPath, err)
 	}
 
 	if notMnt {
-		klog.V(4).Infof("Staging path %s is not a mount point, nothing to unstage", stagingTargetPath)
+		logging.Klog(ctx).V(4).Infof("Staging path %s is not a mount point, nothing to unstage", stagingTargetPath)
+		// A failed stage may have left the encrypted device open
+		if err := s.closeEncryptedDevice(volumeID); err != nil {
+			return nil, err
+		}
+		s.forgetStagedVolume(volumeID)
 		return &csi.NodeUnstageVolumeResponse{}, nil
 	}
 
+	// Flush data to the device before unmounting so a quick detach does not lose writes
+	if err := s.flushBeforeUnmount(ctx, volumeID, stagingTargetPath); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to flush volume %s before unmount: %v", volumeID, err)
+	}
+
 	// Unmount the volume
-	klog.V(4).Infof("Unmounting volume %s from %s", volumeID, stagingTargetPath)
+	logging.Klog(ctx).V(4).Infof("Unmounting volume %s from %s", volumeID, stagingTargetPath)
 	if err := s.mounter.Unmount(stagingTargetPath); err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to unmount volume: %v", err)
 	}
 
+	// Close the LUKS container of an encrypted volume, the controller detaches the device next
+	if err := s.closeEncryptedDevice(volumeID); err != nil {
+		return nil, err
+	}
+
 	// Clean up the staging directory
-	klog.V(4).Infof("Removing staging directory %s", stagingTargetPath)
+	logging.Klog(ctx).V(4).Infof("Removing staging directory %s", stagingTargetPath)
 	if err := os.Remove(stagingTargetPath); err != nil && !os.IsNotExist(err) {
-		klog.Warningf("Failed to remove staging directory %s: %v", stagingTargetPath, err)
+		logging.Klog(ctx).Warningf("Failed to remove staging directory %s: %v", stagingTargetPath, err)
 		// Don't fail the operation if we can't remove the directory
 	}
 
-	klog.Infof("Successfully unstaged volume %s from %s", volumeID, stagingTargetPath)
+	s.forgetStagedVolume(volumeID)
+	logging.Klog(ctx).Infof("Successfully unstaged volume %s from %s", volumeID, stagingTargetPath)
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
-// NodePublishVolume publishes a volume
+// flushBeforeUnmount applies the volume's flush mode to the filesystem mounted at path
+func (s *NodeService) flushBeforeUnmount(ctx context.Context, volumeID, path string) error {
+	staged, ok := s.state.Get(volumeID)
+	if !ok || staged.FlushMode == "" {
+		staged.FlushMode = s.unstageFlushMode
+	}
+
+	switch staged.FlushMode {
+	case mount.FlushModeSync:
+		logging.Klog(ctx).V(4).Infof("Syncing filesystem at %s before unmount", path)
+		return s.mounter.SyncFilesystem(path)
+	case mount.FlushModeFreeze:
+		logging.Klog(ctx).V(4).Infof("Syncing filesystem at %s before unmount", path)
+		if err := s.mounter.SyncFilesystem(path); err != nil {
+			return err
+		}
+		// Only xfs needs the freeze to flush its log, and the fs type is unknown after a restart
+		if staged.FSType == "xfs" {
+			logging.Klog(ctx).V(4).Infof("Freezing and thawing xfs filesystem at %s before unmount", path)
+			return s.mounter.FreezeFilesystem(path)
+		}
+	}
+
+	return nil
+}
+
+// closeEncryptedDevice closes the LUKS container of a volume if it is open
+func (s *NodeService) closeEncryptedDevice(volumeID string) error {
+	if err := s.mounter.CloseEncryptedDevice(mount.EncryptedDeviceName(volumeID)); err != nil {
+		return status.Errorf(codes.Internal, "failed to close encrypted device of volume %s: %v", volumeID, err)
+	}
+	return nil
+}
+
+// rememberStagedVolume records the staging path and unstage settings of a volume
+func (s *NodeService) rememberStagedVolume(volumeID, stagingPath, fsType, flushMode string) {
+	staged, _ := s.state.Get(volumeID)
+	staged.StagingPath = stagingPath
+	staged.FSType = fsType
+	staged.FlushMode = flushMode
+	s.state.Set(volumeID, staged)
+}
+
+// forgetStagedVolume drops the state and cached device of a volume
+func (s *NodeService) forgetStagedVolume(volumeID string) {
+	s.deviceCache.Delete(volumeID)
+	s.state.Delete(volumeID)
+}
+
+// NodePublishVolume bind mounts a staged volume at the target path
 func (s *NodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
-	klog.V(4).Infof("NodePublishVolume called with request: %+v", req)
+	logging.Klog(ctx).V(4).Infof("NodePublishVolume called with request: %+v", stripSecrets(req))
 
 	// Validate request
 	volumeID := req.GetVolumeId()
@@ -155,6 +441,12 @@ func (s *NodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
 	}
 
+	unlock, err := s.volumeLocks.lock(volumeID, "NodePublishVolume")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
 	stagingTargetPath := req.GetStagingTargetPath()
 	if stagingTargetPath == "" {
 		return nil, status.Error(codes.InvalidArgument, "staging target path is required")
@@ -177,7 +469,7 @@ func (s *NodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 	}
 
 	if !notMnt {
-		klog.V(4).Infof("Volume %s is already published at %s", volumeID, targetPath)
+		logging.Klog(ctx).V(4).Infof("Volume %s is already published at %s", volumeID, targetPath)
 		return &csi.NodePublishVolumeResponse{}, nil
 	}
 
@@ -188,22 +480,22 @@ func (s *NodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 	}
 
 	if mnt := volumeCapability.GetMount(); mnt != nil {
-		mountOptions = append(mountOptions, mnt.MountFlags...)
+		mountOptions = append(mountOptions, s.sanitizeMountOptions(ctx, volumeID, mnt.MountFlags)...)
 	}
 
 	// Bind mount from staging path to target path
-	klog.V(4).Infof("Bind mounting from %s to %s with options %v", stagingTargetPath, targetPath, mountOptions)
+	logging.Klog(ctx).V(4).Infof("Bind mounting from %s to %s with options %v", stagingTargetPath, targetPath, mountOptions)
 	if err := s.mounter.Mount(stagingTargetPath, targetPath, "", mountOptions); err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to bind mount volume: %v", err)
 	}
 
-	klog.Infof("Successfully published volume %s at %s", volumeID, targetPath)
+	logging.Klog(ctx).Infof("Successfully published volume %s at %s", volumeID, targetPath)
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
 // NodeUnpublishVolume unpublishes a volume
 func (s *NodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
-	klog.V(4).Infof("NodeUnpublishVolume called with request: %+v", req)
+	logging.Klog(ctx).V(4).Infof("NodeUnpublishVolume called with request: %+v", stripSecrets(req))
 
 	// Validate request
 	volumeID := req.GetVolumeId()
@@ -211,6 +503,12 @@ func (s *NodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
 	}
 
+	unlock, err := s.volumeLocks.lock(volumeID, "NodeUnpublishVolume")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
 	targetPath := req.GetTargetPath()
 	if targetPath == "" {
 		return nil, status.Error(codes.InvalidArgument, "target path is required")
@@ -220,37 +518,37 @@ func (s *NodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 	notMnt, err := s.mounter.IsLikelyNotMountPoint(targetPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			klog.V(4).Infof("Target path %s does not exist, nothing to unpublish", targetPath)
+			logging.Klog(ctx).V(4).Infof("Target path %s does not exist, nothing to unpublish", targetPath)
 			return &csi.NodeUnpublishVolumeResponse{}, nil
 		}
 		return nil, status.Errorf(codes.Internal, "failed to check if %s is a mount point: %v", targetPath, err)
 	}
 
 	if notMnt {
-		klog.V(4).Infof("Target path %s is not a mount point, nothing to unpublish", targetPath)
+		logging.Klog(ctx).V(4).Infof("Target path %s is not a mount point, nothing to unpublish", targetPath)
 		return &csi.NodeUnpublishVolumeResponse{}, nil
 	}
 
 	// Unmount the volume
-	klog.V(4).Infof("Unmounting volume %s from %s", volumeID, targetPath)
+	logging.Klog(ctx).V(4).Infof("Unmounting volume %s from %s", volumeID, targetPath)
 	if err := s.mounter.Unmount(targetPath); err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to unmount volume: %v", err)
 	}
 
 	// Clean up the target directory
-	klog.V(4).Infof("Removing target directory %s", targetPath)
+	logging.Klog(ctx).V(4).Infof("Removing target directory %s", targetPath)
 	if err := os.Remove(targetPath); err != nil && !os.IsNotExist(err) {
-		klog.Warningf("Failed to remove target directory %s: %v", targetPath, err)
+		logging.Klog(ctx).Warningf("Failed to remove target directory %s: %v", targetPath, err)
 		// Don't fail the operation if we can't remove the directory
 	}
 
-	klog.Infof("Successfully unpublished volume %s from %s", volumeID, targetPath)
+	logging.Klog(ctx).Infof("Successfully unpublished volume %s from %s", volumeID, targetPath)
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
 // NodeGetVolumeStats gets volume statistics
 func (s *NodeService) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
-	klog.V(4).Infof("NodeGetVolumeStats called with request: %+v", req)
+	logging.Klog(ctx).V(4).Infof("NodeGetVolumeStats called with request: %+v", stripSecrets(req))
 
 	// Validate request
 	volumeID := req.GetVolumeId()
@@ -278,7 +576,7 @@ func (s *NodeService) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVo
 		return nil, status.Errorf(codes.Internal, "failed to get volume stats: %v", err)
 	}
 
-	klog.V(4).Infof("Volume %s stats: total=%d, used=%d, available=%d",
+	logging.Klog(ctx).V(4).Infof("Volume %s stats: total=%d, used=%d, available=%d",
 		volumeID, stats.TotalBytes, stats.UsedBytes, stats.AvailableBytes)
 
 	return &csi.NodeGetVolumeStatsResponse{
@@ -299,9 +597,9 @@ func (s *NodeService) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVo
 	}, nil
 }
 
-// NodeExpandVolume expands a volume on the node
+// NodeExpandVolume grows the filesystem of a volume
 func (s *NodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
-	klog.V(4).Infof("NodeExpandVolume called with request: %+v", req)
+	logging.Klog(ctx).V(4).Infof("NodeExpandVolume called with request: %+v", stripSecrets(req))
 
 	// Validate request
 	volumeID := req.GetVolumeId()
@@ -309,22 +607,32 @@ func (s *NodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
 	}
 
+	unlock, err := s.volumeLocks.lock(volumeID, "NodeExpandVolume")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
 	volumePath := req.GetVolumePath()
 	if volumePath == "" {
 		return nil, status.Error(codes.InvalidArgument, "volume path is required")
 	}
-
-	volumeCapability := req.GetVolumeCapability()
-	if volumeCapability == nil {
-		return nil, status.Error(codes.InvalidArgument, "volume capability is required")
+	if _, err := os.Stat(volumePath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound, "volume path %s does not exist", volumePath)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to stat volume path %s: %v", volumePath, err)
 	}
 
-	// Get filesystem type
+	// Get filesystem type. The capability is optional, without it the filesystem the
+	// volume was staged with is used.
 	fsType := "ext4" // default
-	if mnt := volumeCapability.GetMount(); mnt != nil {
-		if mnt.FsType != "" {
+	if volumeCapability := req.GetVolumeCapability(); volumeCapability != nil {
+		if mnt := volumeCapability.GetMount(); mnt != nil && mnt.FsType != "" {
 			fsType = mnt.FsType
 		}
+	} else if staged, ok := s.state.Get(volumeID); ok && staged.FSType != "" {
+		fsType = staged.FSType
 	}
 
 	// Validate filesystem type
@@ -332,15 +640,25 @@ func (s *NodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 		return nil, status.Errorf(codes.InvalidArgument, "unsupported filesystem type: %s", fsType)
 	}
 
-	klog.V(4).Infof("Expanding filesystem on volume %s at %s (fstype: %s)", volumeID, volumePath, fsType)
+	logging.Klog(ctx).V(4).Infof("Expanding filesystem on volume %s at %s (fstype: %s)", volumeID, volumePath, fsType)
+
+	// The LUKS container of an encrypted volume grows first, its decrypted device holds the filesystem
+	encryptedPath, err := s.mounter.ResizeEncryptedDevice(mount.EncryptedDeviceName(volumeID), req.GetSecrets()[secretEncryptionPassphrase])
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to resize encrypted device: %v", err)
+	}
 
 	// For ext4, we need the device path
 	// For xfs, we need the mount path
 	if fsType == "ext4" {
 		// Get device path from volume ID
-		devicePath, err := s.mounter.GetDevicePath(volumeID)
-		if err != nil {
-			return nil, status.Errorf(codes.Internal, "failed to find device for volume %s: %v", volumeID, err)
+		devicePath := encryptedPath
+		if devicePath == "" {
+			devicePath, err = s.findDevice(ctx, volumeID, mount.DeviceHints{})
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to find device for volume %s: %v", volumeID, err)
+			}
+			s.deviceCache.Set(volumeID, devicePath)
 		}
 
 		if err := s.mounter.ResizeFilesystem(devicePath, fsType); err != nil {
@@ -348,12 +666,16 @@ func (s *NodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 		}
 	} else if fsType == "xfs" {
 		// For xfs, use the mount path
-		if err := s.mounter.(*mount.LinuxMounter).ResizeFilesystemAtPath(volumePath, fsType); err != nil {
+		resizer, ok := s.mounter.(pathResizer)
+		if !ok {
+			return nil, status.Error(codes.Internal, "mounter cannot resize filesystems by mount path")
+		}
+		if err := resizer.ResizeFilesystemAtPath(volumePath, fsType); err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to resize filesystem: %v", err)
 		}
 	}
 
-	klog.Infof("Successfully expanded filesystem on volume %s", volumeID)
+	logging.Klog(ctx).Infof("Successfully expanded filesystem on volume %s", volumeID)
 
 	// Return the new capacity if provided
 	capacity := req.GetCapacityRange().GetRequiredBytes()
@@ -362,9 +684,50 @@ func (s *NodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 	}, nil
 }
 
+// findDevice returns the device of a volume, trying the device cache before discovery.
+// A cached device the hints do not identify is rediscovered.
+func (s *NodeService) findDevice(ctx context.Context, volumeID string, hints mount.DeviceHints) (string, error) {
+	if device, ok := s.deviceCache.Get(volumeID); ok {
+		err := s.mounter.CheckDevice(device, hints)
+		if err == nil {
+			logging.Klog(ctx).V(4).Infof("Using cached device %s for volume %s", device, volumeID)
+			return device, nil
+		}
+		logging.Klog(ctx).Infof("Cached device of volume %s changed, discovering it again: %v", volumeID, err)
+	}
+	return s.discoverDevice(volumeID, hints)
+}
+
+// discoverDevice finds the device path of a volume, recording the discovery duration
+func (s *NodeService) discoverDevice(volumeID string, hints mount.DeviceHints) (string, error) {
+	start := time.Now()
+	devicePath, err := s.mounter.GetDevicePath(volumeID, hints)
+	metrics.RecordDeviceDiscovery(err, time.Since(start))
+	return devicePath, err
+}
+
+// volumeCapabilityFSType returns the filesystem type label of a volume capability. Types
+// the driver does not support are labelled "other", so the label values stay bounded
+// whatever a request asks for.
+func volumeCapabilityFSType(capability *csi.VolumeCapability) string {
+	if capability == nil {
+		return "unknown"
+	}
+	if capability.GetBlock() != nil {
+		return "block"
+	}
+	switch fsType := capability.GetMount().GetFsType(); fsType {
+	case "":
+		return "ext4"
+	case "ext4", "xfs":
+		return fsType
+	}
+	return "other"
+}
+
 // NodeGetCapabilities returns node capabilities
 func (s *NodeService) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
-	klog.V(4).Info("NodeGetCapabilities called")
+	logging.Klog(ctx).V(4).Info("NodeGetCapabilities called")
 
 	return &csi.NodeGetCapabilitiesResponse{
 		Capabilities: []*csi.NodeServiceCapability{
@@ -389,34 +752,79 @@ func (s *NodeService) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetC
 					},
 				},
 			},
+			{
+				Type: &csi.NodeServiceCapability_Rpc{
+					Rpc: &csi.NodeServiceCapability_RPC{
+						Type: csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+					},
+				},
+			},
 		},
 	}, nil
 }
 
 // NodeGetInfo returns node information
 func (s *NodeService) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
-	klog.V(4).Info("NodeGetInfo called")
+	logging.Klog(ctx).V(4).Info("NodeGetInfo called")
 
-	// Get datacenter information from environment or metadata
-	// In Emma.ms, the datacenter can be retrieved from VM metadata
-	// For now, we'll use an environment variable
-	datacenterID := os.Getenv("EMMA_DATACENTER_ID")
+	// Get datacenter information from the node's Emma VM or the environment
+	segments, err := s.accessibleTopology(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to resolve node topology: %v", err)
+	}
 
 	response := &csi.NodeGetInfoResponse{
 		NodeId: s.driver.nodeID,
 		// Maximum number of volumes that can be attached to this node
-		MaxVolumesPerNode: 16, // Emma.ms platform limit
+		MaxVolumesPerNode: s.maxVolumesPerNode,
 	}
 
 	// Add topology information if datacenter is available
-	if datacenterID != "" {
+	if len(segments) > 0 {
 		response.AccessibleTopology = &csi.Topology{
-			Segments: map[string]string{
-				"topology.csi.emma.ms/datacenter": datacenterID,
-			},
+			Segments: segments,
 		}
-		klog.V(4).Infof("Node %s is in datacenter %s", s.driver.nodeID, datacenterID)
+		logging.Klog(ctx).V(4).Infof("Node %s is in datacenter %s", s.driver.nodeID, segments[TopologyKeyDataCenter])
 	}
 
 	return response, nil
 }
+
+// parseForceReformat returns the forceReformat volume context setting, false when unset
+func parseForceReformat(volumeContext map[string]string) (bool, error) {
+	value, ok := volumeContext[paramForceReformat]
+	if !ok || value == "" {
+		return false, nil
+	}
+	force, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, status.Errorf(codes.InvalidArgument, "invalid %s %q: must be true or false", paramForceReformat, value)
+	}
+	return force, nil
+}
+
+// parseExpectFormatted returns the expectFormatted volume context hint, false when unset
+func parseExpectFormatted(volumeContext map[string]string) (bool, error) {
+	value, ok := volumeContext[volumeContextExpectFormatted]
+	if !ok || value == "" {
+		return false, nil
+	}
+	expect, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, status.Errorf(codes.InvalidArgument, "invalid %s %q: must be true or false", volumeContextExpectFormatted, value)
+	}
+	return expect, nil
+}
+
+// parseEncrypted returns the encrypted volume context setting, false when unset
+func parseEncrypted(volumeContext map[string]string) (bool, error) {
+	value, ok := volumeContext[paramEncrypted]
+	if !ok || value == "" {
+		return false, nil
+	}
+	encrypted, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, status.Errorf(codes.InvalidArgument, "invalid %s %q: must be true or false", paramEncrypted, value)
+	}
+	return encrypted, nil
+}
@@ -1,11 +1,22 @@
 package driver
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+
+	"github.com/emma-csi-driver/pkg/logging"
+	"github.com/emma-csi-driver/pkg/mount"
 )
 
 // TestNodeGetCapabilities tests the NodeGetCapabilities method
@@ -28,9 +39,10 @@ func TestNodeGetCapabilities(t *testing.T) {
 
 	// Verify expected capabilities
 	expectedCaps := map[csi.NodeServiceCapability_RPC_Type]bool{
-		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME: true,
-		csi.NodeServiceCapability_RPC_EXPAND_VOLUME:        true,
-		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS:     true,
+		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME:     true,
+		csi.NodeServiceCapability_RPC_EXPAND_VOLUME:            true,
+		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS:         true,
+		csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER: true,
 	}
 
 	for _, cap := range resp.Capabilities {
@@ -313,3 +325,312 @@ func TestNodeExpandVolume(t *testing.T) {
 		})
 	}
 }
+
+// fakeMounter records filesystem flush calls and implements mount.Mounter
+type fakeMounter struct {
+	mount.Mounter
+	calls []string
+
+	// device is the device discovered for every volume
+	device string
+
+	// mounted lists the paths that are mount points
+	mounted map[string]bool
+}
+
+func (f *fakeMounter) SyncFilesystem(path string) error {
+	f.calls = append(f.calls, "sync")
+	return nil
+}
+
+func (f *fakeMounter) FreezeFilesystem(path string) error {
+	f.calls = append(f.calls, "freeze")
+	return nil
+}
+
+func (f *fakeMounter) IsLikelyNotMountPoint(path string) (bool, error) {
+	return !f.mounted[path], nil
+}
+
+func (f *fakeMounter) CloseEncryptedDevice(name string) error {
+	f.calls = append(f.calls, "close "+name)
+	return nil
+}
+
+func (f *fakeMounter) GetDevicePath(volumeID string, hints mount.DeviceHints) (string, error) {
+	f.calls = append(f.calls, "discover "+volumeID)
+	return f.device, nil
+}
+
+func (f *fakeMounter) CheckDevice(device string, hints mount.DeviceHints) error {
+	if device != f.device {
+		return fmt.Errorf("%s is not a block device", device)
+	}
+	return nil
+}
+
+// TestFlushBeforeUnmount tests the flush applied before unmounting on unstage
+func TestFlushBeforeUnmount(t *testing.T) {
+	tests := []struct {
+		name        string
+		defaultMode string
+		staged      *stagedVolume
+		expected    []string
+	}{
+		{
+			name:        "default none",
+			defaultMode: mount.FlushModeNone,
+			expected:    nil,
+		},
+		{
+			name:        "default sync for unknown volume",
+			defaultMode: mount.FlushModeSync,
+			expected:    []string{"sync"},
+		},
+		{
+			name:        "fsfreeze on xfs",
+			defaultMode: mount.FlushModeNone,
+			staged:      &stagedVolume{FSType: "xfs", FlushMode: mount.FlushModeFreeze},
+			expected:    []string{"sync", "freeze"},
+		},
+		{
+			name:        "fsfreeze on ext4 only syncs",
+			defaultMode: mount.FlushModeNone,
+			staged:      &stagedVolume{FSType: "ext4", FlushMode: mount.FlushModeFreeze},
+			expected:    []string{"sync"},
+		},
+		{
+			name:        "volume none overrides default",
+			defaultMode: mount.FlushModeSync,
+			staged:      &stagedVolume{FSType: "ext4", FlushMode: mount.FlushModeNone},
+			expected:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mounter := &fakeMounter{}
+			service := NewNodeService(&Driver{name: "csi.emma.ms"})
+			service.mounter = mounter
+			if err := service.SetUnstageFlushMode(tt.defaultMode); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.staged != nil {
+				service.rememberStagedVolume("123", "/mnt/staging", tt.staged.FSType, tt.staged.FlushMode)
+			}
+
+			if err := service.flushBeforeUnmount(context.Background(), "123", "/mnt/staging"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(mounter.calls, tt.expected) {
+				t.Errorf("expected calls %v, got %v", tt.expected, mounter.calls)
+			}
+		})
+	}
+
+	service := NewNodeService(&Driver{name: "csi.emma.ms"})
+	if err := service.SetUnstageFlushMode("invalid"); err == nil {
+		t.Error("expected error for invalid flush mode")
+	}
+}
+
+// TestVolumeCapabilityFSType tests the filesystem type label of node operation metrics
+func TestVolumeCapabilityFSType(t *testing.T) {
+	tests := []struct {
+		name       string
+		capability *csi.VolumeCapability
+		expected   string
+	}{
+		{name: "no capability", expected: "unknown"},
+		{
+			name:       "block",
+			capability: &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}},
+			expected:   "block",
+		},
+		{
+			name:       "mount default",
+			capability: &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}},
+			expected:   "ext4",
+		},
+		{
+			name:       "mount xfs",
+			capability: &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "xfs"}}},
+			expected:   "xfs",
+		},
+		{
+			name:       "mount unsupported type",
+			capability: &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "btrfs"}}},
+			expected:   "other",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := volumeCapabilityFSType(tt.capability); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestParseExpectFormatted tests the expectFormatted volume context hint
+func TestParseExpectFormatted(t *testing.T) {
+	tests := []struct {
+		name        string
+		context     map[string]string
+		expected    bool
+		expectError bool
+	}{
+		{name: "unset", context: nil, expected: false},
+		{name: "new volume", context: map[string]string{volumeContextExpectFormatted: "false"}, expected: false},
+		{name: "existing data", context: map[string]string{volumeContextExpectFormatted: "true"}, expected: true},
+		{name: "invalid", context: map[string]string{volumeContextExpectFormatted: "maybe"}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseExpectFormatted(tt.context)
+			if tt.expectError {
+				if status.Code(err) != codes.InvalidArgument {
+					t.Errorf("expected InvalidArgument, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestParseForceReformat tests the forceReformat volume context setting
+func TestParseForceReformat(t *testing.T) {
+	tests := []struct {
+		name        string
+		context     map[string]string
+		expected    bool
+		expectError bool
+	}{
+		{name: "unset", context: nil, expected: false},
+		{name: "disabled", context: map[string]string{paramForceReformat: "false"}, expected: false},
+		{name: "enabled", context: map[string]string{paramForceReformat: "true"}, expected: true},
+		{name: "invalid", context: map[string]string{paramForceReformat: "yes please"}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseForceReformat(tt.context)
+			if tt.expectError {
+				if status.Code(err) != codes.InvalidArgument {
+					t.Errorf("expected InvalidArgument, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestParseEncrypted tests the encrypted volume context setting
+func TestParseEncrypted(t *testing.T) {
+	tests := []struct {
+		name        string
+		context     map[string]string
+		expected    bool
+		expectError bool
+	}{
+		{name: "unset", context: nil, expected: false},
+		{name: "disabled", context: map[string]string{paramEncrypted: "false"}, expected: false},
+		{name: "enabled", context: map[string]string{paramEncrypted: "true"}, expected: true},
+		{name: "invalid", context: map[string]string{paramEncrypted: "luks2"}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseEncrypted(tt.context)
+			if tt.expectError {
+				if status.Code(err) != codes.InvalidArgument {
+					t.Errorf("expected InvalidArgument, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestNodeStageVolumeEncryptedWithoutPassphrase tests that encrypted volumes need the node-stage secret
+func TestNodeStageVolumeEncryptedWithoutPassphrase(t *testing.T) {
+	service := NewNodeService(&Driver{name: "csi.emma.ms"})
+	service.mounter = &fakeMounter{}
+
+	_, err := service.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "123",
+		StagingTargetPath: "/mnt/staging",
+		VolumeCapability:  &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}},
+		VolumeContext:     map[string]string{paramEncrypted: "true"},
+		Secrets:           map[string]string{"other": "value"},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument without %s, got %v", secretEncryptionPassphrase, err)
+	}
+}
+
+// TestNodeUnstageVolumeClosesEncryptedDevice tests that unstaging closes a leftover LUKS container
+func TestNodeUnstageVolumeClosesEncryptedDevice(t *testing.T) {
+	mounter := &fakeMounter{}
+	service := NewNodeService(&Driver{name: "csi.emma.ms"})
+	service.mounter = mounter
+
+	_, err := service.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{
+		VolumeId:          "123",
+		StagingTargetPath: "/mnt/staging",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"close emma-csi-123"}; !reflect.DeepEqual(mounter.calls, expected) {
+		t.Errorf("expected calls %v, got %v", expected, mounter.calls)
+	}
+}
+
+// TestNodeLogsCarryCSIRequestID tests that node log entries of a call carry its CSI request ID
+func TestNodeLogsCarryCSIRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	klog.LogToStderr(false)
+	klog.SetOutput(&logs)
+	defer func() {
+		klog.SetOutput(os.Stderr)
+		klog.LogToStderr(true)
+	}()
+
+	mounter := mount.NewFakeMounter()
+	service := NewNodeService(&Driver{name: "csi.emma.ms"})
+	service.mounter = mounter
+	targetPath := filepath.Join(t.TempDir(), "target")
+	if err := mounter.Mount("/mnt/staging", targetPath, "", []string{"bind"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx := logging.WithCSIRequestID(context.Background(), "req-123")
+	if _, err := service.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "123", TargetPath: targetPath}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	klog.Flush()
+	if !strings.Contains(logs.String(), "[csiRequestId=req-123] Successfully unpublished volume 123") {
+		t.Errorf("expected the unpublish log entry with csiRequestId=req-123, got %q", logs.String())
+	}
+}
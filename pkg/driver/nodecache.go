@@ -0,0 +1,126 @@
+package driver
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultNodeCacheTTL is how long a node name resolution is trusted before it is looked up
+// again, so nodes recreated under the same name are picked up without a failed attach
+const DefaultNodeCacheTTL = 10 * time.Minute
+
+// nodeVMCache caches Kubernetes node name to Emma VM ID resolutions
+type nodeVMCache struct {
+	mutex   sync.RWMutex
+	entries map[string]nodeVMCacheEntry
+
+	// ttl is how long an entry stays valid, 0 keeps entries until invalidated
+	ttl time.Duration
+
+	// refreshed is when the last refresh from a full listing of Emma's VMs started
+	refreshed time.Time
+
+	// now returns the current time, replaced in tests
+	now func() time.Time
+}
+
+// nodeVMCacheEntry is a cached resolution and when it expires
+type nodeVMCacheEntry struct {
+	vmID    int32
+	expires time.Time
+}
+
+// newNodeVMCache creates an empty node name cache
+func newNodeVMCache(ttl time.Duration) *nodeVMCache {
+	return &nodeVMCache{
+		entries: make(map[string]nodeVMCacheEntry),
+		ttl:     ttl,
+		now:     time.Now,
+	}
+}
+
+// SetTTL sets how long new entries stay valid
+func (c *nodeVMCache) SetTTL(ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ttl = ttl
+}
+
+// TTL returns how long new entries stay valid
+func (c *nodeVMCache) TTL() time.Duration {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.ttl
+}
+
+// Get returns the cached VM ID for a node name
+func (c *nodeVMCache) Get(nodeName string) (int32, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	entry, ok := c.entries[nodeName]
+	if !ok || c.expired(entry) {
+		return 0, false
+	}
+	return entry.vmID, true
+}
+
+// Set stores the VM ID for a node name
+func (c *nodeVMCache) Set(nodeName string, vmID int32) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry := nodeVMCacheEntry{vmID: vmID}
+	if c.ttl > 0 {
+		entry.expires = c.now().Add(c.ttl)
+	}
+	c.entries[nodeName] = entry
+}
+
+// Invalidate removes a node name from the cache so the next lookup re-resolves it
+func (c *nodeVMCache) Invalidate(nodeName string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.entries, nodeName)
+}
+
+// NodeForVM returns the cached node name of a VM ID
+func (c *nodeVMCache) NodeForVM(vmID int32) (string, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for nodeName, entry := range c.entries {
+		if entry.vmID == vmID && !c.expired(entry) {
+			return nodeName, true
+		}
+	}
+	return "", false
+}
+
+// StartRefresh reports whether a refresh from a full VM listing is due and, if so, marks it
+// started. Refreshes happen at most once per TTL, or per DefaultNodeCacheTTL when entries do
+// not expire, so paging through volumes lists the VMs once rather than on every page.
+func (c *nodeVMCache) StartRefresh() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	interval := c.ttl
+	if interval == 0 {
+		interval = DefaultNodeCacheTTL
+	}
+	now := c.now()
+	if !c.refreshed.IsZero() && now.Before(c.refreshed.Add(interval)) {
+		return false
+	}
+	c.refreshed = now
+	return true
+}
+
+// AbortRefresh clears a refresh started with StartRefresh that failed, so the next caller
+// retries it
+func (c *nodeVMCache) AbortRefresh() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.refreshed = time.Time{}
+}
+
+// expired reports whether an entry has outlived the TTL. The caller holds the mutex.
+func (c *nodeVMCache) expired(entry nodeVMCacheEntry) bool {
+	return !entry.expires.IsZero() && c.now().After(entry.expires)
+}
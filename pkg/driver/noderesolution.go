@@ -0,0 +1,215 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/emma-csi-driver/pkg/kube"
+	"github.com/emma-csi-driver/pkg/logging"
+)
+
+// NodeResolutionMode controls how node IDs that are not Emma VM IDs are resolved
+type NodeResolutionMode string
+
+const (
+	// NodeResolutionClusters searches every Emma managed Kubernetes cluster in the account
+	// for the node name, then matches the VM names
+	NodeResolutionClusters NodeResolutionMode = "clusters"
+
+	// NodeResolutionVMs matches the node name against the names of the account's VMs, for
+	// self-managed clusters on Emma VMs
+	NodeResolutionVMs NodeResolutionMode = "vms"
+
+	// NodeResolutionAnnotation only uses the VM ID label or annotation of the Kubernetes node.
+	// Whoever can change the label decides which VM a node's volumes attach to, so the label
+	// is only trusted when an operator chooses this mode.
+	NodeResolutionAnnotation NodeResolutionMode = "annotation"
+
+	// NodeResolutionNumericOnly requires node IDs to be Emma VM IDs
+	NodeResolutionNumericOnly NodeResolutionMode = "numeric-only"
+)
+
+// errNodeNotInClusters is returned when no Emma managed Kubernetes cluster has the node
+var errNodeNotInClusters = errors.New("node not found in Emma managed Kubernetes clusters")
+
+// ParseNodeResolutionMode parses a --node-resolution-mode value
+func ParseNodeResolutionMode(value string) (NodeResolutionMode, error) {
+	switch mode := NodeResolutionMode(value); mode {
+	case NodeResolutionClusters, NodeResolutionVMs, NodeResolutionAnnotation, NodeResolutionNumericOnly:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid node resolution mode %q: expected clusters, vms, annotation or numeric-only", value)
+}
+
+// NeedsKubeClient reports whether the mode reads Kubernetes nodes
+func (m NodeResolutionMode) NeedsKubeClient() bool {
+	return m == NodeResolutionAnnotation
+}
+
+// SetNodeResolutionMode sets how node IDs that are not Emma VM IDs are resolved
+func (s *ControllerService) SetNodeResolutionMode(mode NodeResolutionMode) {
+	s.nodeResolution = mode
+}
+
+// SetNodeCacheTTL sets how long node name resolutions are cached, 0 caches them until a
+// lookup with the cached VM ID fails
+func (s *ControllerService) SetNodeCacheTTL(ttl time.Duration) {
+	s.nodeCache.SetTTL(ttl)
+}
+
+// nodeVMs returns the node cache of the Emma client of a request. VMs differ between Emma
+// accounts, so resolutions made with per-StorageClass credentials are kept apart.
+func (s *ControllerService) nodeVMs(ctx context.Context) *nodeVMCache {
+	client, ok := ctx.Value(emmaClientKey{}).(EmmaVolumeAPI)
+	if !ok || client == s.emmaClient {
+		return s.nodeCache
+	}
+	s.nodeCachesMutex.Lock()
+	defer s.nodeCachesMutex.Unlock()
+	cache, ok := s.nodeCaches[client]
+	if !ok {
+		cache = newNodeVMCache(s.nodeCache.TTL())
+		s.nodeCaches[client] = cache
+	}
+	return cache
+}
+
+// resolveNodeIDToVMID resolves a Kubernetes node ID (which may be a name) to an Emma VM ID
+func (s *ControllerService) resolveNodeIDToVMID(ctx context.Context, nodeID string) (int32, error) {
+	// First, try to parse as integer (direct VM ID)
+	if vmID, err := strconv.ParseInt(nodeID, 10, 32); err == nil {
+		return int32(vmID), nil
+	}
+
+	if s.nodeResolution == NodeResolutionNumericOnly {
+		return 0, fmt.Errorf("node ID %q is not an Emma VM ID and node resolution mode is %s; run the node plugin with its VM ID as --node-id or choose another --node-resolution-mode", nodeID, s.nodeResolution)
+	}
+
+	if vmID, ok := s.nodeVMs(ctx).Get(nodeID); ok {
+		logging.Klog(ctx).V(5).Infof("Node '%s' resolved to VM ID %d from cache", nodeID, vmID)
+		return vmID, nil
+	}
+
+	return s.lookupNode(ctx, nodeID)
+}
+
+// lookupNode resolves a node name to a VM ID by searching Emma as the resolution mode allows
+func (s *ControllerService) lookupNode(ctx context.Context, nodeID string) (int32, error) {
+	switch s.nodeResolution {
+	case NodeResolutionVMs:
+		return s.lookupNodeInVMs(ctx, nodeID)
+	case NodeResolutionAnnotation:
+		if s.kubeClient == nil {
+			return 0, fmt.Errorf("node resolution mode %s requires a Kubernetes client (--kubernetes-client)", s.nodeResolution)
+		}
+		if vmID, ok := s.vmIDFromNodeLabel(ctx, nodeID); ok {
+			logging.Klog(ctx).V(4).Infof("Node '%s' resolved to VM ID %d from node label", nodeID, vmID)
+			s.nodeVMs(ctx).Set(nodeID, vmID)
+			return vmID, nil
+		}
+		return 0, fmt.Errorf("node %s has no valid %s label or annotation; the node plugin sets the label when run with --label-node", nodeID, kube.LabelVMID)
+	case NodeResolutionNumericOnly:
+		return 0, fmt.Errorf("node ID %q is not an Emma VM ID and node resolution mode is %s", nodeID, s.nodeResolution)
+	default:
+		vmID, err := s.lookupNodeInClusters(ctx, nodeID)
+		if errors.Is(err, errNodeNotInClusters) {
+			// Nodes outside the managed clusters may still be Emma VMs with the node's name
+			logging.Klog(ctx).V(4).Infof("Node '%s' is in no Emma managed Kubernetes cluster, matching VM names", nodeID)
+			return s.lookupNodeInVMs(ctx, nodeID)
+		}
+		return vmID, err
+	}
+}
+
+// lookupNodeInVMs resolves a node name to the ID of the only Emma VM with that name
+func (s *ControllerService) lookupNodeInVMs(ctx context.Context, nodeID string) (int32, error) {
+	logging.Klog(ctx).V(4).Infof("Node ID '%s' is not a number, looking up a VM with that name", nodeID)
+
+	vms, err := s.api(ctx).ListVMs(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list VMs: %w", err)
+	}
+
+	var matches []int32
+	for _, vm := range vms {
+		if vm.GetName() == nodeID {
+			matches = append(matches, vm.GetId())
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return 0, fmt.Errorf("no Emma VM is named %s", nodeID)
+	case 1:
+		logging.Klog(ctx).V(4).Infof("Found VM ID %d named '%s'", matches[0], nodeID)
+		s.nodeVMs(ctx).Set(nodeID, matches[0])
+		return matches[0], nil
+	default:
+		return 0, fmt.Errorf("%d Emma VMs are named %s (IDs %v); label the node with %s and use node resolution mode %s instead", len(matches), nodeID, matches, kube.LabelVMID, NodeResolutionAnnotation)
+	}
+}
+
+// emmaNodeVMs returns the VM ID of each node name Emma knows under the resolution mode:
+// the nodes of the managed Kubernetes clusters, or the VM names. Other modes never ask Emma.
+func (s *ControllerService) emmaNodeVMs(ctx context.Context) (map[string]int32, error) {
+	nodeVMs := make(map[string]int32)
+
+	switch s.nodeResolution {
+	case NodeResolutionClusters:
+		clusters, err := s.api(ctx).ListKubernetesClusters(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list Kubernetes clusters: %w", err)
+		}
+		for _, cluster := range clusters {
+			for _, nodeGroup := range cluster.GetNodeGroups() {
+				for _, node := range nodeGroup.GetNodes() {
+					if node.GetName() != "" {
+						nodeVMs[node.GetName()] = node.GetId()
+					}
+				}
+			}
+		}
+	case NodeResolutionVMs:
+		vms, err := s.api(ctx).ListVMs(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list VMs: %w", err)
+		}
+		seen := make(map[string]int)
+		for _, vm := range vms {
+			seen[vm.GetName()]++
+			nodeVMs[vm.GetName()] = vm.GetId()
+		}
+		// Ambiguous names are left to lookupNodeInVMs, which reports them
+		for name, count := range seen {
+			if count > 1 || name == "" {
+				delete(nodeVMs, name)
+			}
+		}
+	}
+
+	return nodeVMs, nil
+}
+
+// vmIDFromNodeLabel reads the Emma VM ID label, or annotation, of a Kubernetes node, if a
+// Kubernetes client is configured
+func (s *ControllerService) vmIDFromNodeLabel(ctx context.Context, nodeID string) (int32, bool) {
+	if s.kubeClient == nil {
+		return 0, false
+	}
+
+	node, err := s.kubeClient.CoreV1().Nodes().Get(ctx, nodeID, metav1.GetOptions{})
+	if err != nil {
+		logging.Klog(ctx).V(4).Infof("Failed to get node %s for VM ID label: %v", nodeID, err)
+		return 0, false
+	}
+
+	if vmID, ok := nodeVMIDLabel(nodeID, node.Labels); ok {
+		return vmID, true
+	}
+	return nodeVMIDLabel(nodeID, node.Annotations)
+}
@@ -0,0 +1,257 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	emmasdk "github.com/emma-community/emma-go-sdk"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/emma-csi-driver/internal/fakeemma"
+	"github.com/emma-csi-driver/pkg/emma"
+	"github.com/emma-csi-driver/pkg/kube"
+)
+
+func TestParseNodeResolutionMode(t *testing.T) {
+	for _, value := range []string{"clusters", "vms", "annotation", "numeric-only"} {
+		if mode, err := ParseNodeResolutionMode(value); err != nil || string(mode) != value {
+			t.Errorf("expected %s to parse, got %q, %v", value, mode, err)
+		}
+	}
+	if _, err := ParseNodeResolutionMode("labels"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
+
+// TestResolveNodeIDModes tests which sources each node resolution mode consults
+func TestResolveNodeIDModes(t *testing.T) {
+	id := func(id int32) *int32 { return &id }
+	vm := func(vmID int32, name string) emmasdk.Vm {
+		return emmasdk.Vm{Id: id(vmID), Name: emmasdk.PtrString(name)}
+	}
+	newKubeClient := func() kubernetes.Interface {
+		return fake.NewSimpleClientset(
+			&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "labeled", Labels: map[string]string{kube.LabelVMID: "300"}}},
+			&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "annotated", Annotations: map[string]string{kube.LabelVMID: "301"}}},
+			&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}},
+		)
+	}
+
+	tests := []struct {
+		name       string
+		mode       NodeResolutionMode
+		kubeClient bool
+		nodeID     string
+		expected   int32
+		errSubstr  string
+		calls      []string
+	}{
+		{name: "numeric ID in any mode", mode: NodeResolutionNumericOnly, nodeID: "42", expected: 42},
+		{name: "numeric-only rejects names", mode: NodeResolutionNumericOnly, nodeID: "worker-1", errSubstr: "not an Emma VM ID"},
+		{name: "clusters searches clusters", mode: NodeResolutionClusters, nodeID: "worker-1", expected: 100, calls: []string{"list clusters"}},
+		{name: "clusters falls back to VM names", mode: NodeResolutionClusters, nodeID: "standalone", expected: 203, calls: []string{"list clusters", "list vms"}},
+		{name: "clusters reports unknown names", mode: NodeResolutionClusters, nodeID: "gone", errSubstr: "no Emma VM is named gone", calls: []string{"list clusters", "list vms"}},
+		{name: "clusters ignores the label", mode: NodeResolutionClusters, kubeClient: true, nodeID: "labeled", errSubstr: "no Emma VM is named labeled", calls: []string{"list clusters", "list vms"}},
+		{name: "vms ignores the label", mode: NodeResolutionVMs, kubeClient: true, nodeID: "labeled", errSubstr: "no Emma VM is named labeled", calls: []string{"list vms"}},
+		{name: "vms matches the VM name", mode: NodeResolutionVMs, nodeID: "worker-1", expected: 200, calls: []string{"list vms"}},
+		{name: "vms rejects ambiguous names", mode: NodeResolutionVMs, nodeID: "dup", errSubstr: "2 Emma VMs are named dup", calls: []string{"list vms"}},
+		{name: "vms reports unknown names", mode: NodeResolutionVMs, nodeID: "gone", errSubstr: "no Emma VM is named gone", calls: []string{"list vms"}},
+		{name: "annotation reads the label", mode: NodeResolutionAnnotation, kubeClient: true, nodeID: "labeled", expected: 300},
+		{name: "annotation reads the annotation", mode: NodeResolutionAnnotation, kubeClient: true, nodeID: "annotated", expected: 301},
+		{name: "annotation never searches Emma", mode: NodeResolutionAnnotation, kubeClient: true, nodeID: "worker-1", errSubstr: "has no valid"},
+		{name: "annotation without a Kubernetes client", mode: NodeResolutionAnnotation, nodeID: "worker-1", errSubstr: "requires a Kubernetes client"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeAPI := fakeemma.New()
+			fakeAPI.Clusters = []emmasdk.Kubernetes{{
+				NodeGroups: []emmasdk.KubernetesNodeGroupsInner{{Nodes: []emmasdk.KubernetesNodeGroupsInnerNodesInner{
+					{Id: id(100), Name: emmasdk.PtrString("worker-1")},
+				}}},
+			}}
+			fakeAPI.VMs = []emmasdk.Vm{vm(200, "worker-1"), vm(201, "dup"), vm(202, "dup"), vm(203, "standalone")}
+
+			service := NewControllerService(&Driver{name: "csi.emma.ms"}, fakeAPI)
+			service.SetNodeResolutionMode(tt.mode)
+			if tt.kubeClient {
+				service.SetKubeClient(newKubeClient())
+			}
+
+			vmID, err := service.resolveNodeIDToVMID(context.Background(), tt.nodeID)
+			if tt.errSubstr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errSubstr) {
+					t.Errorf("expected error containing %q, got %v", tt.errSubstr, err)
+				}
+			} else if err != nil || vmID != tt.expected {
+				t.Errorf("expected VM ID %d, got %d (err: %v)", tt.expected, vmID, err)
+			}
+			if !reflect.DeepEqual(fakeAPI.Calls(), tt.calls) {
+				t.Errorf("expected Emma calls %v, got %v", tt.calls, fakeAPI.Calls())
+			}
+		})
+	}
+}
+
+// TestRefreshNodeCacheModes tests that ListVolumes node mapping follows the resolution mode
+func TestRefreshNodeCacheModes(t *testing.T) {
+	id := func(id int32) *int32 { return &id }
+	for _, mode := range []NodeResolutionMode{NodeResolutionVMs, NodeResolutionNumericOnly} {
+		fakeAPI := fakeemma.New()
+		fakeAPI.VMs = []emmasdk.Vm{{Id: id(200), Name: emmasdk.PtrString("worker-1")}}
+		service := NewControllerService(&Driver{name: "csi.emma.ms"}, fakeAPI)
+		service.SetNodeResolutionMode(mode)
+
+		service.refreshNodeCache(context.Background())
+		vmID, ok := service.nodeCache.Get("worker-1")
+		if mode == NodeResolutionVMs && (!ok || vmID != 200) {
+			t.Errorf("%s: expected worker-1 cached as VM 200, got %d, %v", mode, vmID, ok)
+		}
+		if mode == NodeResolutionNumericOnly && (ok || len(fakeAPI.Calls()) != 0) {
+			t.Errorf("%s: expected no Emma calls or cache entries, got calls %v", mode, fakeAPI.Calls())
+		}
+	}
+}
+
+// TestNodeCacheTTL tests that node name resolutions expire and are looked up again
+func TestNodeCacheTTL(t *testing.T) {
+	id := func(id int32) *int32 { return &id }
+	fakeAPI := fakeemma.New()
+	fakeAPI.VMs = []emmasdk.Vm{{Id: id(200), Name: emmasdk.PtrString("worker-1")}}
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, fakeAPI)
+	service.SetNodeResolutionMode(NodeResolutionVMs)
+
+	now := time.Now()
+	service.nodeCache.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if vmID, err := service.resolveNodeIDToVMID(context.Background(), "worker-1"); err != nil || vmID != 200 {
+			t.Fatalf("expected VM 200, got %d, %v", vmID, err)
+		}
+	}
+	if len(fakeAPI.Calls()) != 1 {
+		t.Errorf("expected the second lookup to be cached, got calls %v", fakeAPI.Calls())
+	}
+
+	// The node was recreated under the same name
+	fakeAPI.VMs = []emmasdk.Vm{{Id: id(201), Name: emmasdk.PtrString("worker-1")}}
+	now = now.Add(DefaultNodeCacheTTL + time.Second)
+	if _, ok := service.nodeCache.NodeForVM(200); ok {
+		t.Error("expected the expired entry to be ignored")
+	}
+	if vmID, err := service.resolveNodeIDToVMID(context.Background(), "worker-1"); err != nil || vmID != 201 {
+		t.Errorf("expected the expired entry to resolve to VM 201, got %d, %v", vmID, err)
+	}
+
+	// Without a TTL entries are kept until invalidated
+	service.SetNodeCacheTTL(0)
+	service.nodeCache.Set("worker-2", 300)
+	now = now.Add(24 * time.Hour)
+	if vmID, ok := service.nodeCache.Get("worker-2"); !ok || vmID != 300 {
+		t.Errorf("expected worker-2 to stay cached, got %d, %v", vmID, ok)
+	}
+}
+
+// TestNodeCachePerClient tests that resolutions made with credentials from CSI secrets are
+// not shared with other Emma accounts
+func TestNodeCachePerClient(t *testing.T) {
+	id := func(id int32) *int32 { return &id }
+	fakeAPI := fakeemma.New()
+	fakeAPI.VMs = []emmasdk.Vm{{Id: id(200), Name: emmasdk.PtrString("worker-1")}}
+	otherAPI := fakeemma.New()
+	otherAPI.VMs = []emmasdk.Vm{{Id: id(500), Name: emmasdk.PtrString("worker-1")}}
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, fakeAPI)
+	service.SetNodeResolutionMode(NodeResolutionVMs)
+
+	otherCtx := context.WithValue(context.Background(), emmaClientKey{}, EmmaVolumeAPI(otherAPI))
+	if vmID, err := service.resolveNodeIDToVMID(otherCtx, "worker-1"); err != nil || vmID != 500 {
+		t.Fatalf("expected VM 500 from the other account, got %d, %v", vmID, err)
+	}
+	if vmID, err := service.resolveNodeIDToVMID(context.Background(), "worker-1"); err != nil || vmID != 200 {
+		t.Errorf("expected VM 200 from the controller's account, got %d, %v", vmID, err)
+	}
+	if vmID, err := service.resolveNodeIDToVMID(otherCtx, "worker-1"); err != nil || vmID != 500 {
+		t.Errorf("expected the other account's cached VM 500, got %d, %v", vmID, err)
+	}
+	if len(otherAPI.Calls()) != 1 || len(fakeAPI.Calls()) != 1 {
+		t.Errorf("expected one lookup per account, got %v and %v", fakeAPI.Calls(), otherAPI.Calls())
+	}
+}
+
+// TestUnpublishRefreshesStaleNodeResolution tests that detach re-resolves a node whose
+// cached VM ID does not match the VM the volume is attached to
+func TestUnpublishRefreshesStaleNodeResolution(t *testing.T) {
+	id := func(id int32) *int32 { return &id }
+	fakeAPI := fakeemma.New()
+	fakeAPI.VMs = []emmasdk.Vm{{Id: id(201), Name: emmasdk.PtrString("worker-1")}}
+	fakeAPI.AddVolume(emma.VolumeResponse{ID: 7, Status: "ACTIVE", AttachedToID: id(201)})
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, fakeAPI)
+	service.SetNodeResolutionMode(NodeResolutionVMs)
+	service.nodeCache.Set("worker-1", 200)
+
+	_, err := service.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{VolumeId: "7", NodeId: "worker-1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if volume := fakeAPI.Volume(7); volume.AttachedToID != nil {
+		t.Errorf("expected the volume to be detached from VM 201, still attached to %d", *volume.AttachedToID)
+	}
+	if vmID, ok := service.nodeCache.Get("worker-1"); !ok || vmID != 201 {
+		t.Errorf("expected worker-1 re-cached as VM 201, got %d, %v", vmID, ok)
+	}
+}
+
+// TestListVolumesRefreshesNodeCacheOncePerTTL tests that paging through volumes attached to
+// VMs without a node lists Emma's VMs once per node cache TTL rather than on every page
+func TestListVolumesRefreshesNodeCacheOncePerTTL(t *testing.T) {
+	id := func(id int32) *int32 { return &id }
+	fakeAPI := fakeemma.New()
+	fakeAPI.VMs = []emmasdk.Vm{{Id: id(200), Name: emmasdk.PtrString("worker-1")}}
+	for _, volumeID := range []int32{1, 2, 3} {
+		fakeAPI.AddVolume(emma.VolumeResponse{ID: volumeID, Name: fmt.Sprintf("pvc-%d", volumeID), SizeGB: 1, Status: "ACTIVE", AttachedToID: id(999), DataCenterID: "dc-1"})
+	}
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, fakeAPI)
+	service.SetNodeResolutionMode(NodeResolutionVMs)
+	now := time.Now()
+	service.nodeCache.now = func() time.Time { return now }
+
+	listVMCalls := func() int {
+		count := 0
+		for _, call := range fakeAPI.Calls() {
+			if call == "list vms" {
+				count++
+			}
+		}
+		return count
+	}
+	// VM 999 stays unknown, so every page fails until a node resolves to it
+	listAll := func() {
+		for _, token := range []string{"", "1", "2"} {
+			_, err := service.ListVolumes(context.Background(), &csi.ListVolumesRequest{MaxEntries: 1, StartingToken: token})
+			if status.Code(err) != codes.Unavailable {
+				t.Fatalf("expected Unavailable for a volume on an unknown VM, got %v", err)
+			}
+		}
+	}
+
+	listAll()
+	if calls := listVMCalls(); calls != 1 {
+		t.Errorf("expected one VM listing for all pages, got %d", calls)
+	}
+
+	now = now.Add(DefaultNodeCacheTTL + time.Second)
+	listAll()
+	if calls := listVMCalls(); calls != 2 {
+		t.Errorf("expected another VM listing after the TTL, got %d", calls)
+	}
+}
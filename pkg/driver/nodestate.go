@@ -0,0 +1,142 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+// nodeStateVersion is the format version of the node state file
+const nodeStateVersion = 1
+
+// stagedVolume is what the node remembers about a staged volume. Its device is kept in
+// the device cache.
+type stagedVolume struct {
+	// StagingPath is where the volume is mounted
+	StagingPath string `json:"stagingPath,omitempty"`
+
+	// FSType and FlushMode are the settings needed to unstage the volume
+	FSType    string `json:"fsType,omitempty"`
+	FlushMode string `json:"flushMode,omitempty"`
+}
+
+// nodeStateFile is the JSON layout of the node state file
+type nodeStateFile struct {
+	Version int                     `json:"version"`
+	Volumes map[string]stagedVolume `json:"volumes"`
+}
+
+// nodeState tracks the volumes staged on the node. With a path it is persisted there, so
+// unstaging after a restart keeps the settings of each volume and the startup reconcile
+// knows which volumes are still staged.
+type nodeState struct {
+	path    string
+	mutex   sync.Mutex
+	volumes map[string]stagedVolume
+}
+
+// newNodeState creates an in-memory node state
+func newNodeState() *nodeState {
+	return &nodeState{volumes: make(map[string]stagedVolume)}
+}
+
+// loadNodeState opens the node state file at path, starting empty when it does not exist
+func loadNodeState(path string) (*nodeState, error) {
+	s := newNodeState()
+	s.path = path
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read node state %s: %w", path, err)
+	}
+	var file nodeStateFile
+	if err := json.Unmarshal(data, &file); err != nil || file.Version != nodeStateVersion {
+		// An unreadable state only costs the flush mode of volumes staged before a restart
+		klog.Warningf("Ignoring unreadable node state %s (version %d): %v", path, file.Version, err)
+		return s, nil
+	}
+	for volumeID, volume := range file.Volumes {
+		s.volumes[volumeID] = volume
+	}
+	return s, nil
+}
+
+// persistent reports whether the state is saved to a file
+func (s *nodeState) persistent() bool {
+	return s.path != ""
+}
+
+// Get returns the state of a volume
+func (s *nodeState) Get(volumeID string) (stagedVolume, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	volume, ok := s.volumes[volumeID]
+	return volume, ok
+}
+
+// Set records the state of a volume
+func (s *nodeState) Set(volumeID string, volume stagedVolume) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if existing, ok := s.volumes[volumeID]; ok && existing == volume {
+		return
+	}
+	s.volumes[volumeID] = volume
+	s.save()
+}
+
+// Delete removes the state of a volume
+func (s *nodeState) Delete(volumeID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.volumes[volumeID]; !ok {
+		return
+	}
+	delete(s.volumes, volumeID)
+	s.save()
+}
+
+// VolumeIDs returns the IDs of all volumes in the state, sorted
+func (s *nodeState) VolumeIDs() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	volumeIDs := make([]string, 0, len(s.volumes))
+	for volumeID := range s.volumes {
+		volumeIDs = append(volumeIDs, volumeID)
+	}
+	sort.Strings(volumeIDs)
+	return volumeIDs
+}
+
+// save writes the state file through a rename, so a crash never leaves a partial file.
+// Failures are logged, the state only saves time. The caller holds the mutex.
+func (s *nodeState) save() {
+	if !s.persistent() {
+		return
+	}
+	data, err := json.Marshal(nodeStateFile{Version: nodeStateVersion, Volumes: s.volumes})
+	if err != nil {
+		klog.Warningf("Failed to encode node state: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		klog.Warningf("Failed to create node state directory: %v", err)
+		return
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		klog.Warningf("Failed to write node state %s: %v", tmp, err)
+		return
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		klog.Warningf("Failed to replace node state %s: %v", s.path, err)
+	}
+}
@@ -0,0 +1,91 @@
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/emma-csi-driver/pkg/mount"
+)
+
+// TestNodeStatePersists tests that the state of staged volumes survives reloading the file
+func TestNodeStatePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "state.json")
+
+	state, err := loadNodeState(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	state.Set("123", stagedVolume{StagingPath: "/mnt/123", FSType: "xfs", FlushMode: mount.FlushModeFreeze})
+	state.Set("456", stagedVolume{StagingPath: "/mnt/456"})
+	state.Delete("456")
+
+	reloaded, err := loadNodeState(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]stagedVolume{
+		"123": {StagingPath: "/mnt/123", FSType: "xfs", FlushMode: mount.FlushModeFreeze},
+	}
+	if !reflect.DeepEqual(reloaded.volumes, expected) {
+		t.Errorf("expected reloaded volumes %+v, got %+v", expected, reloaded.volumes)
+	}
+
+	for _, content := range []string{"{not json", `{"version": 99, "volumes": {"123": {"stagingPath": "/mnt/123"}}}`} {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		ignored, err := loadNodeState(path)
+		if err != nil {
+			t.Fatalf("expected an unreadable state to be ignored, got %v", err)
+		}
+		if ids := ignored.VolumeIDs(); len(ids) != 0 {
+			t.Errorf("expected an empty state after %q, got %v", content, ids)
+		}
+	}
+
+	if newNodeState().persistent() {
+		t.Error("expected a state without a file not to be persistent")
+	}
+}
+
+// TestSetStateFileReconciles tests that volumes no longer mounted are dropped at startup
+func TestSetStateFileReconciles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	state, err := loadNodeState(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	state.Set("mounted", stagedVolume{StagingPath: "/mnt/mounted"})
+	state.Set("unmounted", stagedVolume{StagingPath: "/mnt/unmounted"})
+	state.Set("unknown", stagedVolume{FSType: "ext4"})
+
+	service := NewNodeService(&Driver{name: "csi.emma.ms"})
+	service.mounter = &fakeMounter{mounted: map[string]bool{"/mnt/mounted": true}}
+	if err := service.SetDeviceCacheFile(filepath.Join(t.TempDir(), "devices.json")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	service.deviceCache.Set("mounted", "/dev/vdb")
+	service.deviceCache.Set("unmounted", "/dev/vdc")
+	if err := service.SetStateFile(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ids := service.state.VolumeIDs(); !reflect.DeepEqual(ids, []string{"mounted"}) {
+		t.Errorf("expected only the mounted volume to be kept, got %v", ids)
+	}
+	if _, ok := service.deviceCache.Get("unmounted"); ok {
+		t.Error("expected the cached device of the unmounted volume to be dropped")
+	}
+	if device, _ := service.deviceCache.Get("mounted"); device != "/dev/vdb" {
+		t.Errorf("expected the cached device of the mounted volume to be kept, got %q", device)
+	}
+
+	reloaded, err := loadNodeState(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ids := reloaded.VolumeIDs(); !reflect.DeepEqual(ids, []string{"mounted"}) {
+		t.Errorf("expected the reconciled state to be saved, got %v", ids)
+	}
+}
@@ -0,0 +1,113 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	emmasdk "github.com/emma-community/emma-go-sdk"
+	"k8s.io/klog/v2"
+)
+
+// EmmaVMAPI defines the Emma API operations used by the node service
+type EmmaVMAPI interface {
+	GetVM(ctx context.Context, vmID int32) (*emmasdk.Vm, error)
+}
+
+// invalidTopologyChars are the characters not allowed in a Kubernetes label value, which
+// topology segment values become on the Node
+var invalidTopologyChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// nodeTopology resolves the node's topology segments from its Emma VM, once
+type nodeTopology struct {
+	client EmmaVMAPI
+	vmID   int32
+
+	mutex    sync.Mutex
+	segments map[string]string
+}
+
+// SetEmmaClient has NodeGetInfo resolve the datacenter, provider and location of the node
+// from its Emma VM instead of the EMMA_DATACENTER_ID environment variable
+func (s *NodeService) SetEmmaClient(client EmmaVMAPI, vmID int32) {
+	s.topology = &nodeTopology{client: client, vmID: vmID}
+}
+
+// accessibleTopology returns the node's topology segments, nil when none are known. With an
+// Emma client they are looked up from the node's VM, falling back to EMMA_DATACENTER_ID
+// when the lookup fails.
+func (s *NodeService) accessibleTopology(ctx context.Context) (map[string]string, error) {
+	datacenterID := os.Getenv("EMMA_DATACENTER_ID")
+	if s.topology == nil {
+		if datacenterID == "" {
+			return nil, nil
+		}
+		return map[string]string{TopologyKeyDataCenter: datacenterID}, nil
+	}
+
+	segments, err := s.topology.resolve(ctx)
+	if err == nil {
+		return segments, nil
+	}
+	if datacenterID != "" {
+		klog.Warningf("Failed to resolve node topology from Emma VM %d, using EMMA_DATACENTER_ID: %v", s.topology.vmID, err)
+		return map[string]string{TopologyKeyDataCenter: datacenterID}, nil
+	}
+	return nil, err
+}
+
+// resolve looks up the topology segments of the VM, caching them once found
+func (t *nodeTopology) resolve(ctx context.Context) (map[string]string, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.segments != nil {
+		return t.segments, nil
+	}
+
+	vm, err := t.client.GetVM(ctx, t.vmID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Emma VM %d: %w", t.vmID, err)
+	}
+	segments := vmTopology(vm)
+	if segments[TopologyKeyDataCenter] == "" {
+		return nil, fmt.Errorf("emma VM %d reports no datacenter", t.vmID)
+	}
+	klog.Infof("Node topology from Emma VM %d: %v", t.vmID, segments)
+	t.segments = segments
+	return segments, nil
+}
+
+// vmTopology returns the topology segments of an Emma VM. The provider is the prefix of
+// the {provider}-{region} datacenter ID, as in the emma.ms/provider node label.
+func vmTopology(vm *emmasdk.Vm) map[string]string {
+	segments := make(map[string]string)
+	datacenterID := vm.DataCenter.GetId()
+	if datacenterID == "" {
+		return segments
+	}
+	segments[TopologyKeyDataCenter] = datacenterID
+
+	provider, _, ok := strings.Cut(datacenterID, "-")
+	if !ok || provider == "" {
+		provider = vm.Provider.GetName()
+	}
+	if value := topologyValue(provider); value != "" {
+		segments[TopologyKeyProvider] = value
+	}
+	if value := topologyValue(vm.Location.GetName()); value != "" {
+		segments[TopologyKeyLocation] = value
+	}
+	return segments
+}
+
+// topologyValue turns a name such as "Frankfurt am Main" into a valid label value
+func topologyValue(name string) string {
+	value := invalidTopologyChars.ReplaceAllString(strings.ToLower(strings.TrimSpace(name)), "-")
+	if len(value) > 63 {
+		value = value[:63]
+	}
+	return strings.Trim(value, "-._")
+}
@@ -0,0 +1,138 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	emmasdk "github.com/emma-community/emma-go-sdk"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeVMAPI returns a fixed VM and counts the lookups
+type fakeVMAPI struct {
+	vm    *emmasdk.Vm
+	err   error
+	calls int
+}
+
+func (f *fakeVMAPI) GetVM(ctx context.Context, vmID int32) (*emmasdk.Vm, error) {
+	f.calls++
+	return f.vm, f.err
+}
+
+// testVM returns an Emma VM in a datacenter
+func testVM(datacenterID, provider, location string) *emmasdk.Vm {
+	vm := emmasdk.NewVm()
+	vm.DataCenter = &emmasdk.VmDataCenter{Id: &datacenterID}
+	vm.Provider = &emmasdk.VmProvider{Name: &provider}
+	vm.Location = &emmasdk.VmLocation{Name: &location}
+	return vm
+}
+
+// TestVMTopology tests that VM metadata becomes valid topology segments
+func TestVMTopology(t *testing.T) {
+	tests := []struct {
+		name     string
+		vm       *emmasdk.Vm
+		expected map[string]string
+	}{
+		{
+			name: "provider from datacenter ID",
+			vm:   testVM("aws-eu-central-1", "Amazon EC2", "Frankfurt am Main"),
+			expected: map[string]string{
+				TopologyKeyDataCenter: "aws-eu-central-1",
+				TopologyKeyProvider:   "aws",
+				TopologyKeyLocation:   "frankfurt-am-main",
+			},
+		},
+		{
+			name: "provider name without prefix",
+			vm:   testVM("dc1", "Emma Cloud", ""),
+			expected: map[string]string{
+				TopologyKeyDataCenter: "dc1",
+				TopologyKeyProvider:   "emma-cloud",
+			},
+		},
+		{
+			name:     "no datacenter",
+			vm:       emmasdk.NewVm(),
+			expected: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if segments := vmTopology(tt.vm); !reflect.DeepEqual(segments, tt.expected) {
+				t.Errorf("expected segments %v, got %v", tt.expected, segments)
+			}
+		})
+	}
+}
+
+// TestNodeGetInfoEmmaTopology tests that NodeGetInfo reports the topology of the node's VM
+func TestNodeGetInfoEmmaTopology(t *testing.T) {
+	tests := []struct {
+		name         string
+		api          *fakeVMAPI
+		datacenterID string
+		expected     map[string]string
+		expectedCode codes.Code
+	}{
+		{
+			name: "resolved from VM",
+			api:  &fakeVMAPI{vm: testVM("gcp-europe-west3", "Google", "Frankfurt")},
+			expected: map[string]string{
+				TopologyKeyDataCenter: "gcp-europe-west3",
+				TopologyKeyProvider:   "gcp",
+				TopologyKeyLocation:   "frankfurt",
+			},
+		},
+		{
+			name:         "lookup failure falls back to the environment",
+			api:          &fakeVMAPI{err: errors.New("unavailable")},
+			datacenterID: "aws-eu-west-2",
+			expected:     map[string]string{TopologyKeyDataCenter: "aws-eu-west-2"},
+		},
+		{
+			name:         "lookup failure without fallback",
+			api:          &fakeVMAPI{err: errors.New("unavailable")},
+			expectedCode: codes.Unavailable,
+		},
+		{
+			name:         "VM without datacenter",
+			api:          &fakeVMAPI{vm: emmasdk.NewVm()},
+			expectedCode: codes.Unavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("EMMA_DATACENTER_ID", tt.datacenterID)
+			service := NewNodeService(&Driver{name: "csi.emma.ms", nodeID: "node-1"})
+			service.SetEmmaClient(tt.api, 42)
+
+			for i := 0; i < 2; i++ {
+				resp, err := service.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+				if tt.expectedCode != codes.OK {
+					if status.Code(err) != tt.expectedCode {
+						t.Fatalf("expected %v, got %v", tt.expectedCode, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !reflect.DeepEqual(resp.GetAccessibleTopology().GetSegments(), tt.expected) {
+					t.Errorf("expected segments %v, got %v", tt.expected, resp.GetAccessibleTopology().GetSegments())
+				}
+			}
+			if tt.api.err == nil && tt.expectedCode == codes.OK && tt.api.calls != 1 {
+				t.Errorf("expected the VM to be looked up once, got %d lookups", tt.api.calls)
+			}
+		})
+	}
+}
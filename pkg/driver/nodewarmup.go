@@ -0,0 +1,67 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// WarmNodeCache pre-resolves the VM IDs of all Kubernetes nodes so that the first
+// attach after a controller restart does not have to walk the Emma clusters.
+// In the annotation resolution mode nodes are resolved from their VM ID label or
+// annotation, in the other modes in a single Emma call chosen by the mode. It returns the
+// number of nodes cached and is a no-op without a Kubernetes client.
+func (s *ControllerService) WarmNodeCache(ctx context.Context) (int, error) {
+	if s.kubeClient == nil {
+		return 0, nil
+	}
+
+	nodes, err := s.kubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list Kubernetes nodes: %w", err)
+	}
+
+	resolved := 0
+	pending := make(map[string]bool)
+	for _, node := range nodes.Items {
+		if s.nodeResolution != NodeResolutionAnnotation {
+			pending[node.Name] = true
+			continue
+		}
+		if vmID, ok := nodeVMIDLabel(node.Name, node.Labels); ok {
+			s.nodeCache.Set(node.Name, vmID)
+			resolved++
+			continue
+		}
+		if vmID, ok := nodeVMIDLabel(node.Name, node.Annotations); ok {
+			s.nodeCache.Set(node.Name, vmID)
+			resolved++
+			continue
+		}
+		pending[node.Name] = true
+	}
+
+	if len(pending) == 0 || s.emmaClient == nil {
+		return resolved, nil
+	}
+
+	nodeVMs, err := s.emmaNodeVMs(ctx)
+	if err != nil {
+		return resolved, err
+	}
+	for name := range pending {
+		if vmID, ok := nodeVMs[name]; ok {
+			s.nodeCache.Set(name, vmID)
+			delete(pending, name)
+			resolved++
+		}
+	}
+
+	for name := range pending {
+		klog.V(4).Infof("Node %s not resolved by node resolution mode %s during cache warm-up", name, s.nodeResolution)
+	}
+
+	return resolved, nil
+}
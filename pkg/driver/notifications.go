@@ -0,0 +1,33 @@
+package driver
+
+import (
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+
+	"github.com/emma-csi-driver/pkg/notify"
+)
+
+// SetNotifier sets where volume lifecycle events are sent
+func (s *ControllerService) SetNotifier(notifier notify.Notifier) {
+	s.notifier = notifier
+}
+
+// notify sends a volume lifecycle event stamped with the driver name and current time
+func (s *ControllerService) notify(event notify.Event) {
+	event.Time = time.Now().UTC()
+	if s.driver != nil {
+		event.Driver = s.driver.name
+	}
+	s.notifier.Notify(event)
+}
+
+// notifyAttachFailed sends an attach failure event for a publish request
+func (s *ControllerService) notifyAttachFailed(req *csi.ControllerPublishVolumeRequest, err error) {
+	s.notify(notify.Event{
+		Type:     notify.EventVolumeAttachFailed,
+		VolumeID: req.GetVolumeId(),
+		NodeID:   req.GetNodeId(),
+		Error:    err.Error(),
+	})
+}
@@ -0,0 +1,110 @@
+package driver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/emma-csi-driver/pkg/emma"
+)
+
+// DefaultVolumeNamePrefix is the prefix of the volume names the external-provisioner passes
+// to CreateVolume, which become the Emma volume names
+const DefaultVolumeNamePrefix = "pvc-"
+
+// driverTagPrefix is the prefix of the tags the driver sets on the volumes it creates
+const driverTagPrefix = "csi.emma.ms/"
+
+// ManagedVolume reports whether an Emma volume was provisioned through the driver: its name
+// has the provisioner's volume name prefix or it carries one of the driver's tags
+func ManagedVolume(volume *emma.VolumeResponse, namePrefix string) bool {
+	if namePrefix != "" && strings.HasPrefix(volume.Name, namePrefix) {
+		return true
+	}
+	for _, tag := range volume.Tags {
+		if strings.HasPrefix(strings.ToLower(tag.Key), driverTagPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// PersistentVolumeHandles maps the volume handles of the driver's PersistentVolumes to the
+// PV names
+func PersistentVolumeHandles(pvs []v1.PersistentVolume) map[string]string {
+	handles := make(map[string]string, len(pvs))
+	for _, pv := range pvs {
+		if csiSource := pv.Spec.CSI; csiSource != nil && csiSource.Driver == DriverName {
+			handles[csiSource.VolumeHandle] = pv.Name
+		}
+	}
+	return handles
+}
+
+// OrphanOptions decides which managed volumes without a PersistentVolume may be deleted
+type OrphanOptions struct {
+	// ClusterID selects the volumes provisioned by this cluster, those tagged with it. Other
+	// clusters sharing the Emma project have PVs this cluster cannot see, so their volumes
+	// are never considered, and neither are volumes without the tag.
+	ClusterID string
+
+	// MinAge protects volumes a CreateVolume call may still be returning to the provisioner
+	MinAge time.Duration
+
+	// IncludeRetained also deletes volumes tagged with the Retain reclaim policy
+	IncludeRetained bool
+}
+
+// Orphan is a managed Emma volume no PersistentVolume refers to
+type Orphan struct {
+	Volume *emma.VolumeResponse
+
+	// Skip is why the volume must not be deleted, empty when it may be
+	Skip string
+}
+
+// FindOrphanedVolumes returns the volumes of the cluster no PersistentVolume refers to, with
+// the reason to keep those that are attached, protected, retained or too recent. Without a
+// cluster ID no volume is considered.
+func FindOrphanedVolumes(volumes []*emma.VolumeResponse, pvHandles map[string]string, opts OrphanOptions, now time.Time) []Orphan {
+	if opts.ClusterID == "" {
+		return nil
+	}
+	var orphans []Orphan
+	for _, volume := range volumes {
+		if clusterID, _ := volume.Tag(TagClusterID); clusterID != opts.ClusterID {
+			continue
+		}
+		if _, ok := pvHandles[strconv.Itoa(int(volume.ID))]; ok {
+			continue
+		}
+		orphans = append(orphans, Orphan{Volume: volume, Skip: orphanSkipReason(volume, opts, now)})
+	}
+	return orphans
+}
+
+// orphanSkipReason returns why an orphaned volume must not be deleted, or ""
+func orphanSkipReason(volume *emma.VolumeResponse, opts OrphanOptions, now time.Time) string {
+	if volume.AttachedToID != nil {
+		return fmt.Sprintf("attached to VM %d", *volume.AttachedToID)
+	}
+	if err := checkNotProtected(volume); err != nil {
+		return fmt.Sprintf("carries the %q tag", TagProtected)
+	}
+	if policy, _ := volume.Tag(TagReclaimPolicy); strings.EqualFold(policy, "Retain") && !opts.IncludeRetained {
+		return "reclaim policy is Retain"
+	}
+	if opts.MinAge > 0 {
+		createdAt, err := time.Parse(time.RFC3339, volume.CreatedAt)
+		if err != nil {
+			return fmt.Sprintf("unknown creation time %q", volume.CreatedAt)
+		}
+		if age := now.Sub(createdAt); age < opts.MinAge {
+			return fmt.Sprintf("created %s ago", age.Round(time.Second))
+		}
+	}
+	return ""
+}
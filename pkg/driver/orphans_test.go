@@ -0,0 +1,84 @@
+package driver
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/emma-csi-driver/pkg/emma"
+)
+
+// TestFindOrphanedVolumes tests which volumes of the cluster without a PersistentVolume may be deleted
+func TestFindOrphanedVolumes(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-48 * time.Hour).Format(time.RFC3339)
+	vm := int32(7)
+
+	ours := emma.VolumeTag{Key: TagClusterID, Value: "prod"}
+	tagged := func(tags ...emma.VolumeTag) []emma.VolumeTag { return append([]emma.VolumeTag{ours}, tags...) }
+	volumes := []*emma.VolumeResponse{
+		{ID: 1, Name: "pvc-bound", CreatedAt: old, Tags: tagged()},
+		{ID: 2, Name: "pvc-orphan", CreatedAt: old, Tags: tagged()},
+		{ID: 3, Name: "manual", CreatedAt: old},
+		{ID: 4, Name: "renamed", CreatedAt: old, Tags: tagged(emma.VolumeTag{Key: TagPVName, Value: "pvc-gone"})},
+		{ID: 5, Name: "pvc-attached", CreatedAt: old, AttachedToID: &vm, Tags: tagged()},
+		{ID: 6, Name: "pvc-protected", CreatedAt: old, Tags: tagged(emma.VolumeTag{Key: TagProtected})},
+		{ID: 7, Name: "pvc-retained", CreatedAt: old, Tags: tagged(emma.VolumeTag{Key: TagReclaimPolicy, Value: "Retain"})},
+		{ID: 8, Name: "pvc-new", CreatedAt: now.Add(-time.Minute).Format(time.RFC3339), Tags: tagged()},
+		{ID: 9, Name: "pvc-undated", Tags: tagged()},
+		{ID: 10, Name: "pvc-untagged", CreatedAt: old},
+		{ID: 11, Name: "pvc-other-cluster", CreatedAt: old, Tags: []emma.VolumeTag{{Key: TagClusterID, Value: "staging"}}},
+	}
+	pvs := []v1.PersistentVolume{
+		{ObjectMeta: metav1.ObjectMeta{Name: "pvc-bound"}, Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: v1.PersistentVolumeSource{CSI: &v1.CSIPersistentVolumeSource{Driver: DriverName, VolumeHandle: "1"}}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "other-driver"}, Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: v1.PersistentVolumeSource{CSI: &v1.CSIPersistentVolumeSource{Driver: "ebs.csi.aws.com", VolumeHandle: "2"}}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "hostpath"}},
+	}
+
+	tests := []struct {
+		name     string
+		opts     OrphanOptions
+		expected map[int32]bool
+	}{
+		{
+			name:     "defaults",
+			opts:     OrphanOptions{ClusterID: "prod", MinAge: time.Hour},
+			expected: map[int32]bool{2: true, 4: true, 5: false, 6: false, 7: false, 8: false, 9: false},
+		},
+		{
+			name:     "retained and without minimum age",
+			opts:     OrphanOptions{ClusterID: "prod", IncludeRetained: true},
+			expected: map[int32]bool{2: true, 4: true, 5: false, 6: false, 7: true, 8: true, 9: true},
+		},
+		{
+			name:     "other cluster",
+			opts:     OrphanOptions{ClusterID: "staging"},
+			expected: map[int32]bool{11: true},
+		},
+		{
+			name:     "without cluster ID",
+			opts:     OrphanOptions{},
+			expected: map[int32]bool{},
+		},
+	}
+
+	handles := PersistentVolumeHandles(pvs)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orphans := FindOrphanedVolumes(volumes, handles, tt.opts, now)
+			if len(orphans) != len(tt.expected) {
+				t.Fatalf("expected %d orphans, got %+v", len(tt.expected), orphans)
+			}
+			for _, orphan := range orphans {
+				deletable, ok := tt.expected[orphan.Volume.ID]
+				if !ok {
+					t.Errorf("volume %d is not an orphan", orphan.Volume.ID)
+				} else if deletable != (orphan.Skip == "") {
+					t.Errorf("volume %d: expected deletable %v, got skip reason %q", orphan.Volume.ID, deletable, orphan.Skip)
+				}
+			}
+		})
+	}
+}
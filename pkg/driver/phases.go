@@ -0,0 +1,112 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+
+	"github.com/emma-csi-driver/pkg/logging"
+)
+
+// phaseErrorDomain is the ErrorInfo domain of phase details attached to controller errors
+const phaseErrorDomain = "csi.emma.ms"
+
+// phaseTiming is how long one phase of an operation took
+type phaseTiming struct {
+	name     string
+	duration time.Duration
+}
+
+// operationPhases times the phases of a long-running controller operation, so a failure
+// can say where the time went: the Emma waits of Create and Publish take minutes.
+type operationPhases struct {
+	operation    string
+	current      string
+	currentStart time.Time
+	done         []phaseTiming
+	now          func() time.Time
+}
+
+// newOperationPhases starts timing an operation in its first phase
+func newOperationPhases(operation, firstPhase string) *operationPhases {
+	p := &operationPhases{operation: operation, now: time.Now}
+	p.Begin(firstPhase)
+	return p
+}
+
+// Begin ends the current phase and starts the next one
+func (p *operationPhases) Begin(phase string) {
+	now := p.now()
+	if p.current != "" {
+		p.done = append(p.done, phaseTiming{name: p.current, duration: now.Sub(p.currentStart)})
+	}
+	p.current = phase
+	p.currentStart = now
+}
+
+// timings returns the finished phases followed by the current one
+func (p *operationPhases) timings() []phaseTiming {
+	return append(p.done[:len(p.done):len(p.done)], phaseTiming{name: p.current, duration: p.now().Sub(p.currentStart)})
+}
+
+// Summary describes each phase and its duration, e.g. "create=1.2s waitAvailable=3m0s"
+func (p *operationPhases) Summary() string {
+	var parts []string
+	for _, t := range p.timings() {
+		parts = append(parts, fmt.Sprintf("%s=%s", t.name, t.duration.Round(time.Millisecond)))
+	}
+	return strings.Join(parts, " ")
+}
+
+// finish logs the phase timings of a successful operation, or annotates a failed
+// operation's gRPC status with them, keeping the details it already has. Failures in the first phase, which is request
+// validation, are returned unchanged.
+func (p *operationPhases) finish(opLog *logging.OperationLogger, err error) error {
+	if err == nil {
+		opLog.WithField("phases", p.Summary()).Debug("Operation phase timings")
+		return nil
+	}
+	if len(p.done) == 0 {
+		return err
+	}
+
+	st := status.Convert(err)
+	metadata := map[string]string{
+		"operation":   p.operation,
+		"failedPhase": p.current,
+	}
+	var total time.Duration
+	for _, t := range p.timings() {
+		metadata[t.name+"Ms"] = fmt.Sprintf("%d", t.duration.Milliseconds())
+		total += t.duration
+	}
+	metadata["totalMs"] = fmt.Sprintf("%d", total.Milliseconds())
+
+	// Keep the code and any details the status already carries, only extend the message
+	proto := st.Proto()
+	proto.Message = fmt.Sprintf("%s [failed in %s; phases: %s]", st.Message(), p.current, p.Summary())
+	annotated := status.FromProto(proto)
+	if withDetails, detailErr := annotated.WithDetails(&errdetails.ErrorInfo{
+		Reason:   phaseReason(p.current),
+		Domain:   phaseErrorDomain,
+		Metadata: metadata,
+	}); detailErr == nil {
+		annotated = withDetails
+	}
+	return annotated.Err()
+}
+
+// phaseReason converts a phase name such as waitAvailable to an ErrorInfo reason such as WAIT_AVAILABLE
+func phaseReason(phase string) string {
+	var b strings.Builder
+	for i, r := range phase {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			b.WriteByte('_')
+		}
+		b.WriteRune(r)
+	}
+	return strings.ToUpper(b.String())
+}
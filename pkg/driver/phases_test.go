@@ -0,0 +1,147 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/emma-csi-driver/internal/fakeemma"
+	"github.com/emma-csi-driver/pkg/emma"
+	"github.com/emma-csi-driver/pkg/logging"
+)
+
+func TestOperationPhases(t *testing.T) {
+	now := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	phases := &operationPhases{operation: "CreateVolume", now: func() time.Time { return now }}
+	phases.Begin("validate")
+	now = now.Add(5 * time.Millisecond)
+	phases.Begin("create")
+	now = now.Add(1200 * time.Millisecond)
+	phases.Begin("waitAvailable")
+	now = now.Add(3 * time.Minute)
+
+	if summary := phases.Summary(); summary != "validate=5ms create=1.2s waitAvailable=3m0s" {
+		t.Errorf("unexpected summary %q", summary)
+	}
+
+	opLog := logging.NewLogger("test").WithOperation("CreateVolume")
+	err := phases.finish(opLog, status.Error(codes.DeadlineExceeded, "volume creation timeout"))
+
+	st := status.Convert(err)
+	if st.Code() != codes.DeadlineExceeded {
+		t.Errorf("expected code to be preserved, got %v", st.Code())
+	}
+	if !strings.Contains(st.Message(), "failed in waitAvailable") || !strings.Contains(st.Message(), "waitAvailable=3m0s") {
+		t.Errorf("expected phases in message, got %q", st.Message())
+	}
+
+	var info *errdetails.ErrorInfo
+	for _, detail := range st.Details() {
+		if d, ok := detail.(*errdetails.ErrorInfo); ok {
+			info = d
+		}
+	}
+	if info == nil {
+		t.Fatal("expected an ErrorInfo detail")
+	}
+	if info.GetReason() != "WAIT_AVAILABLE" || info.GetDomain() != phaseErrorDomain {
+		t.Errorf("unexpected reason %q or domain %q", info.GetReason(), info.GetDomain())
+	}
+	expected := map[string]string{
+		"operation":       "CreateVolume",
+		"failedPhase":     "waitAvailable",
+		"validateMs":      "5",
+		"createMs":        "1200",
+		"waitAvailableMs": "180000",
+		"totalMs":         "181205",
+	}
+	for key, value := range expected {
+		if got := info.GetMetadata()[key]; got != value {
+			t.Errorf("metadata %s: expected %q, got %q", key, value, got)
+		}
+	}
+}
+
+func TestOperationPhasesKeepStatusDetails(t *testing.T) {
+	phases := newOperationPhases("ControllerPublishVolume", "validate")
+	phases.Begin("attach")
+	opLog := logging.NewLogger("test").WithOperation("ControllerPublishVolume")
+
+	original, err := status.New(codes.Unavailable, "volume busy").WithDetails(&errdetails.RetryInfo{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	st := status.Convert(phases.finish(opLog, original.Err()))
+	if st.Code() != codes.Unavailable || !strings.HasPrefix(st.Message(), "volume busy [failed in attach;") {
+		t.Errorf("expected the code and message kept, got %v %q", st.Code(), st.Message())
+	}
+	var retryInfo, errorInfo bool
+	for _, detail := range st.Details() {
+		switch detail.(type) {
+		case *errdetails.RetryInfo:
+			retryInfo = true
+		case *errdetails.ErrorInfo:
+			errorInfo = true
+		}
+	}
+	if !retryInfo || !errorInfo {
+		t.Errorf("expected the original RetryInfo and the phase ErrorInfo, got %v", st.Details())
+	}
+}
+
+func TestOperationPhasesValidationErrorsUnchanged(t *testing.T) {
+	phases := newOperationPhases("CreateVolume", "validate")
+	opLog := logging.NewLogger("test").WithOperation("CreateVolume")
+
+	original := status.Error(codes.InvalidArgument, "volume name is required")
+	if err := phases.finish(opLog, original); err != original {
+		t.Errorf("expected validation error unchanged, got %v", err)
+	}
+	if err := phases.finish(opLog, nil); err != nil {
+		t.Errorf("expected nil for success, got %v", err)
+	}
+}
+
+func TestPhaseReason(t *testing.T) {
+	tests := map[string]string{
+		"validate":       "VALIDATE",
+		"waitAvailable":  "WAIT_AVAILABLE",
+		"lookupExisting": "LOOKUP_EXISTING",
+	}
+	for phase, expected := range tests {
+		if got := phaseReason(phase); got != expected {
+			t.Errorf("phaseReason(%q) = %q, want %q", phase, got, expected)
+		}
+	}
+}
+
+// TestControllerPublishPhaseDetails tests that a failed attach wait reports its phase
+func TestControllerPublishPhaseDetails(t *testing.T) {
+	fakeAPI := fakeemma.New()
+	fakeAPI.AddVolume(emma.VolumeResponse{ID: 5, Name: "pvc-1", SizeGB: 4, Type: "ssd", Status: "AVAILABLE", DataCenterID: "dc-1"})
+	fakeAPI.WaitErr = errors.New("timeout waiting for volume 5 to attach")
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, fakeAPI)
+
+	_, err := service.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+		VolumeId:         "5",
+		NodeId:           "7",
+		VolumeCapability: mountCapability(),
+	})
+	st := status.Convert(err)
+	if st.Code() != codes.Internal {
+		t.Fatalf("expected Internal, got %v", err)
+	}
+	if !strings.Contains(st.Message(), "failed in waitAttached") {
+		t.Errorf("expected the failed phase in the message, got %q", st.Message())
+	}
+	if len(st.Details()) != 1 {
+		t.Errorf("expected one status detail, got %v", st.Details())
+	}
+}
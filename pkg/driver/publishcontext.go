@@ -0,0 +1,77 @@
+package driver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/emma-csi-driver/pkg/emma"
+	"github.com/emma-csi-driver/pkg/mount"
+)
+
+const (
+	// publishContextSerial is the disk serial number of the attached volume
+	publishContextSerial = "serial"
+
+	// publishContextProviderVolumeID is the cloud provider's identifier of the attached volume
+	publishContextProviderVolumeID = "providerVolumeId"
+
+	// publishContextLUN is the SCSI LUN of the attached volume on Azure VMs
+	publishContextLUN = "lun"
+
+	// publishContextSizeBytes is the size of the attached volume, which the node checks
+	// candidate devices against. Unlike the volume context it is current after an expansion.
+	publishContextSizeBytes = "sizeBytes"
+)
+
+// publishContext returns the attachment details Emma reports for a volume, which let the
+// node find the device without guessing, and its size in bytes
+func publishContext(volume *emma.VolumeResponse, sizeUnit SizeUnit) map[string]string {
+	publishContext := make(map[string]string)
+	if volume.SizeGB > 0 {
+		publishContext[publishContextSizeBytes] = strconv.FormatInt(sizeUnit.ToBytes(volume.SizeGB), 10)
+	}
+	if volume.ProviderVolumeID != "" {
+		publishContext[publishContextProviderVolumeID] = volume.ProviderVolumeID
+	}
+	if volume.Attachment != nil && volume.Attachment.Serial != "" {
+		publishContext[publishContextSerial] = volume.Attachment.Serial
+	}
+	if volume.Attachment != nil && volume.Attachment.LUN != nil {
+		publishContext[publishContextLUN] = strconv.Itoa(int(*volume.Attachment.LUN))
+	}
+	return publishContext
+}
+
+// deviceHints returns the device discovery hints in a publish context, taking the provider
+// volume ID from the volume context when the publish context lacks it
+func deviceHints(publishContext, volumeContext map[string]string) (mount.DeviceHints, error) {
+	hints := mount.DeviceHints{
+		Serial:           publishContext[publishContextSerial],
+		ProviderVolumeID: publishContext[publishContextProviderVolumeID],
+	}
+	if hints.ProviderVolumeID == "" {
+		hints.ProviderVolumeID = volumeContext[volumeContextProviderVolumeID]
+	}
+	if value, ok := publishContext[publishContextLUN]; ok {
+		lun, err := strconv.Atoi(value)
+		if err != nil || lun < 0 {
+			return mount.DeviceHints{}, fmt.Errorf("invalid %s %q in publish context", publishContextLUN, value)
+		}
+		hints.LUN = &lun
+	}
+	if value, ok := publishContext[publishContextSizeBytes]; ok {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || size < 0 {
+			return mount.DeviceHints{}, fmt.Errorf("invalid %s %q in publish context", publishContextSizeBytes, value)
+		}
+		hints.SizeBytes = size
+	}
+	return hints, nil
+}
+
+// isAzureVolume reports whether a volume lives on Azure, where only the LUN identifies its device
+func isAzureVolume(volume *emma.VolumeResponse) bool {
+	return strings.HasPrefix(strings.ToLower(volume.DataCenterID), "azure") ||
+		strings.Contains(strings.ToLower(volume.ProviderVolumeID), "/providers/microsoft.compute/")
+}
@@ -0,0 +1,55 @@
+package driver
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/emma-csi-driver/pkg/emma"
+	"github.com/emma-csi-driver/pkg/mount"
+)
+
+func TestPublishContextDeviceHints(t *testing.T) {
+	lun := int32(3)
+	volume := &emma.VolumeResponse{
+		ID:               5,
+		SizeGB:           8,
+		DataCenterID:     "azure-westeurope",
+		ProviderVolumeID: "/subscriptions/s/resourceGroups/rg/providers/Microsoft.Compute/disks/d",
+		Attachment:       &emma.VolumeAttachment{Serial: "60022480abc", LUN: &lun},
+	}
+
+	hints, err := deviceHints(publishContext(volume, SizeUnitGiB), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedLUN := 3
+	expected := mount.DeviceHints{Serial: "60022480abc", ProviderVolumeID: volume.ProviderVolumeID, LUN: &expectedLUN, SizeBytes: 8 << 30}
+	if !reflect.DeepEqual(hints, expected) {
+		t.Errorf("expected hints %+v, got %+v", expected, hints)
+	}
+	if !isAzureVolume(volume) {
+		t.Error("expected an Azure volume")
+	}
+
+	if hints, err := deviceHints(nil, nil); err != nil || !reflect.DeepEqual(hints, mount.DeviceHints{}) {
+		t.Errorf("expected empty hints for an empty publish context, got %+v, %v", hints, err)
+	}
+	// A PV provisioned while Emma reported the provider ID still resolves when the publish context lacks it
+	hints, err = deviceHints(nil, buildVolumeContext(&emma.VolumeResponse{Type: "ssd", ProviderVolumeID: "vol-0abc"}))
+	if err != nil || hints.ProviderVolumeID != "vol-0abc" {
+		t.Errorf("expected provider volume ID from the volume context, got %+v, %v", hints, err)
+	}
+	hints, err = deviceHints(map[string]string{publishContextProviderVolumeID: "vol-0new"}, map[string]string{volumeContextProviderVolumeID: "vol-0old"})
+	if err != nil || hints.ProviderVolumeID != "vol-0new" {
+		t.Errorf("expected the publish context to win, got %+v, %v", hints, err)
+	}
+
+	for _, value := range []string{"x", "-1", ""} {
+		if _, err := deviceHints(map[string]string{publishContextLUN: value}, nil); err == nil {
+			t.Errorf("expected an error for LUN %q", value)
+		}
+		if _, err := deviceHints(map[string]string{publishContextSizeBytes: value}, nil); err == nil {
+			t.Errorf("expected an error for size %q", value)
+		}
+	}
+}
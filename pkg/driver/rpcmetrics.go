@@ -0,0 +1,48 @@
+package driver
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"github.com/emma-csi-driver/pkg/metrics"
+)
+
+// csiMethodPrefix is the prefix of the full method names of every CSI service
+const csiMethodPrefix = "/csi.v1."
+
+// volumeCapabilityRequest is implemented by requests carrying the capability of a volume
+type volumeCapabilityRequest interface {
+	GetVolumeCapability() *csi.VolumeCapability
+}
+
+// recordMetrics records the count and duration of every CSI call by method and gRPC status
+// code. Node calls carrying a volume capability are also recorded by filesystem type.
+func recordMetrics(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !strings.HasPrefix(info.FullMethod, csiMethodPrefix) {
+		return handler(ctx, req)
+	}
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	duration := time.Since(start)
+
+	service, operation := csiMethod(info.FullMethod)
+	code := status.Code(err).String()
+	metrics.RecordOperation(operation, code, duration)
+	if capabilityReq, ok := req.(volumeCapabilityRequest); ok && service == "Node" {
+		metrics.RecordNodeOperation(operation, volumeCapabilityFSType(capabilityReq.GetVolumeCapability()), code, duration)
+	}
+	return resp, err
+}
+
+// csiMethod splits a full CSI method name such as /csi.v1.Node/NodeStageVolume into the
+// service and the method
+func csiMethod(fullMethod string) (string, string) {
+	service, method, _ := strings.Cut(strings.TrimPrefix(fullMethod, csiMethodPrefix), "/")
+	return service, method
+}
@@ -0,0 +1,116 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// sampleCount returns the number of samples of a metric family with the given labels
+func sampleCount(t *testing.T, name string, labels map[string]string) uint64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	var count uint64
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, metric := range family.GetMetric() {
+			values := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				values[label.GetName()] = label.GetValue()
+			}
+			for key, value := range labels {
+				if values[key] != value {
+					continue metrics
+				}
+			}
+			if metric.GetCounter() != nil {
+				count += uint64(metric.GetCounter().GetValue())
+			} else {
+				count += metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return count
+}
+
+// TestRecordMetrics tests that every CSI call is recorded with its gRPC status code
+func TestRecordMetrics(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		req        interface{}
+		err        error
+		operation  string
+		code       string
+		nodeFSType string
+	}{
+		{
+			name:      "controller call",
+			method:    "/csi.v1.Controller/CreateVolume",
+			req:       &csi.CreateVolumeRequest{},
+			operation: "CreateVolume",
+			code:      "OK",
+		},
+		{
+			name:   "node call with a capability",
+			method: "/csi.v1.Node/NodeStageVolume",
+			req: &csi.NodeStageVolumeRequest{VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "xfs"}},
+			}},
+			err:        status.Error(codes.NotFound, "volume not found"),
+			operation:  "NodeStageVolume",
+			code:       "NotFound",
+			nodeFSType: "xfs",
+		},
+		{
+			name:      "node call without a capability",
+			method:    "/csi.v1.Node/NodeUnstageVolume",
+			req:       &csi.NodeUnstageVolumeRequest{},
+			operation: "NodeUnstageVolume",
+			code:      "OK",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			operationLabels := map[string]string{"operation": tt.operation, "status": tt.code}
+			before := sampleCount(t, "emma_csi_operations_total", operationLabels)
+			nodeBefore := sampleCount(t, "emma_csi_node_operation_duration_seconds", map[string]string{"operation": tt.operation})
+
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, tt.err }
+			if _, err := recordMetrics(context.Background(), tt.req, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler); err != tt.err {
+				t.Fatalf("expected the handler's error, got %v", err)
+			}
+
+			if after := sampleCount(t, "emma_csi_operations_total", operationLabels); after != before+1 {
+				t.Errorf("expected one more %s call with status %s, got %d -> %d", tt.operation, tt.code, before, after)
+			}
+			nodeAfter := sampleCount(t, "emma_csi_node_operation_duration_seconds", map[string]string{"operation": tt.operation})
+			if tt.nodeFSType == "" && nodeAfter != nodeBefore {
+				t.Errorf("expected no node operation sample, got %d -> %d", nodeBefore, nodeAfter)
+			}
+			if tt.nodeFSType != "" && sampleCount(t, "emma_csi_node_operation_duration_seconds", map[string]string{"operation": tt.operation, "fs_type": tt.nodeFSType, "status": tt.code}) == 0 {
+				t.Errorf("expected a node operation sample for %s on %s", tt.operation, tt.nodeFSType)
+			}
+		})
+	}
+
+	// Calls outside the CSI services, such as health checks, are not recorded
+	before := sampleCount(t, "emma_csi_operations_total", map[string]string{"operation": "Check"})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+	recordMetrics(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}, handler)
+	if after := sampleCount(t, "emma_csi_operations_total", map[string]string{"operation": "Check"}); after != before {
+		t.Errorf("expected health checks not to be recorded, got %d -> %d", before, after)
+	}
+}
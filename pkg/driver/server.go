@@ -5,107 +5,271 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/protoadapt"
 	"k8s.io/klog/v2"
+
+	"github.com/emma-csi-driver/pkg/logging"
 )
 
 // NonBlockingGRPCServer is a non-blocking gRPC server
 type NonBlockingGRPCServer struct {
+	mu     sync.Mutex
 	server *grpc.Server
 	wg     sync.WaitGroup
+
+	// inFlight counts the RPCs being handled
+	inFlight atomic.Int64
+
+	// done receives the result of serving once the server stops
+	done chan error
+
+	// options are added to the server's default options
+	options []grpc.ServerOption
+
+	// health is the standard gRPC health service, NOT_SERVING until set otherwise
+	health *health.Server
 }
 
-// NewNonBlockingGRPCServer creates a new non-blocking gRPC server
-func NewNonBlockingGRPCServer() *NonBlockingGRPCServer {
-	return &NonBlockingGRPCServer{}
+// NewNonBlockingGRPCServer creates a new non-blocking gRPC server with additional options
+func NewNonBlockingGRPCServer(opts ...grpc.ServerOption) *NonBlockingGRPCServer {
+	s := &NonBlockingGRPCServer{done: make(chan error, 1), options: opts, health: health.NewServer()}
+	s.health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	return s
 }
 
-// Start starts the gRPC server
+// Start listens on endpoint and serves gRPC requests in the background
 func (s *NonBlockingGRPCServer) Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) error {
-	s.wg.Add(1)
-	go s.serve(endpoint, ids, cs, ns)
+	listener, err := listen(endpoint)
+	if err != nil {
+		return err
+	}
+	klog.Infof("Listening for connections on %s", listener.Addr())
+	s.StartWithListener(listener, ids, cs, ns)
 	return nil
 }
 
-// Stop stops the gRPC server
+// StartWithListener serves gRPC requests on an already open listener in the background,
+// such as one passed in by a test harness
+func (s *NonBlockingGRPCServer) StartWithListener(listener net.Listener, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(s.trackInFlight, logGRPC, recordMetrics, recoverPanic),
+	}
+	server := grpc.NewServer(append(opts, s.options...)...)
+
+	if ids != nil {
+		csi.RegisterIdentityServer(server, ids)
+	}
+	if cs != nil {
+		csi.RegisterControllerServer(server, cs)
+	}
+	if ns != nil {
+		csi.RegisterNodeServer(server, ns)
+	}
+	healthpb.RegisterHealthServer(server, s.health)
+
+	s.mu.Lock()
+	s.server = server
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go s.serve(server, listener)
+}
+
+// Done returns a channel receiving the result of serving once the server stops: nil
+// after Stop, otherwise the error that ended serving
+func (s *NonBlockingGRPCServer) Done() <-chan error {
+	return s.done
+}
+
+// SetServingStatus sets the status the gRPC health service reports for a service, "" for
+// the whole server
+func (s *NonBlockingGRPCServer) SetServingStatus(service string, status healthpb.HealthCheckResponse_ServingStatus) {
+	s.health.SetServingStatus(service, status)
+}
+
+// InFlight returns the number of RPCs being handled
+func (s *NonBlockingGRPCServer) InFlight() int64 {
+	return s.inFlight.Load()
+}
+
+// Stop stops the gRPC server after the in-flight RPCs finish
 func (s *NonBlockingGRPCServer) Stop() {
-	if s.server != nil {
-		s.server.GracefulStop()
+	s.GracefulStop(0)
+}
+
+// GracefulStop stops accepting connections and waits for the in-flight RPCs to finish.
+// RPCs still running after timeout are canceled; a timeout of 0 waits without limit.
+func (s *NonBlockingGRPCServer) GracefulStop(timeout time.Duration) {
+	s.mu.Lock()
+	server := s.server
+	s.mu.Unlock()
+
+	// Report NOT_SERVING from now on, so probes see the server going away while it drains
+	s.health.Shutdown()
+
+	if server != nil {
+		if inFlight := s.InFlight(); inFlight > 0 {
+			klog.Infof("Waiting for %d in-flight CSI calls to finish", inFlight)
+		}
+
+		stopped := make(chan struct{})
+		go func() {
+			server.GracefulStop()
+			close(stopped)
+		}()
+
+		var expired <-chan time.Time
+		if timeout > 0 {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			expired = timer.C
+		}
+		select {
+		case <-stopped:
+		case <-expired:
+			klog.Warningf("%d CSI calls still running after %s, canceling them", s.InFlight(), timeout)
+			server.Stop()
+			<-stopped
+		}
 	}
 	s.wg.Wait()
 }
 
-// serve starts serving gRPC requests
-func (s *NonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
+// serve serves gRPC requests until the server is stopped
+func (s *NonBlockingGRPCServer) serve(server *grpc.Server, listener net.Listener) {
 	defer s.wg.Done()
 
+	err := server.Serve(listener)
+	if err != nil {
+		klog.Errorf("Failed to serve gRPC server: %v", err)
+	}
+	s.done <- err
+}
+
+// trackInFlight counts the RPCs being handled, so shutdown can report what it waits for
+func (s *NonBlockingGRPCServer) trackInFlight(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	return handler(ctx, req)
+}
+
+// systemdListenFDsStart is the first file descriptor systemd passes to a socket-activated process
+const systemdListenFDsStart = 3
+
+// listen opens the listener of an endpoint
+func listen(endpoint string) (net.Listener, error) {
 	proto, addr, err := parseEndpoint(endpoint)
 	if err != nil {
-		klog.Fatalf("Failed to parse endpoint: %v", err)
+		return nil, err
 	}
 
-	if proto == "unix" {
+	switch proto {
+	case "fd":
+		return fdListener(addr)
+	case "unix":
 		// Remove existing socket file
 		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
-			klog.Fatalf("Failed to remove existing socket file %s: %v", addr, err)
+			return nil, fmt.Errorf("failed to remove existing socket file %s: %w", addr, err)
 		}
 	}
 
 	listener, err := net.Listen(proto, addr)
 	if err != nil {
-		klog.Fatalf("Failed to listen on %s://%s: %v", proto, addr, err)
-	}
-
-	opts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(logGRPC),
+		return nil, fmt.Errorf("failed to listen on %s://%s: %w", proto, addr, err)
 	}
-	s.server = grpc.NewServer(opts...)
+	return listener, nil
+}
 
-	if ids != nil {
-		csi.RegisterIdentityServer(s.server, ids)
-	}
-	if cs != nil {
-		csi.RegisterControllerServer(s.server, cs)
-	}
-	if ns != nil {
-		csi.RegisterNodeServer(s.server, ns)
+// fdListener returns a listener for an inherited listening socket: file descriptor addr, or
+// with an empty addr the first socket passed by systemd socket activation
+func fdListener(addr string) (net.Listener, error) {
+	fd := systemdListenFDsStart
+	if addr == "" {
+		if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+			return nil, fmt.Errorf("sockets passed by systemd are for process %s, not %d", pid, os.Getpid())
+		}
+		if count, err := strconv.Atoi(os.Getenv("LISTEN_FDS")); err != nil || count < 1 {
+			return nil, fmt.Errorf("endpoint fd:// needs a socket passed by systemd socket activation (LISTEN_FDS is %q)", os.Getenv("LISTEN_FDS"))
+		}
+	} else {
+		fd, _ = strconv.Atoi(addr)
 	}
 
-	klog.Infof("Listening for connections on %s://%s", proto, addr)
-	if err := s.server.Serve(listener); err != nil {
-		klog.Fatalf("Failed to serve gRPC server: %v", err)
+	// FileListener duplicates the descriptor, so the file can be closed
+	file := os.NewFile(uintptr(fd), "csi-endpoint")
+	defer file.Close()
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("file descriptor %d is not a listening socket: %w", fd, err)
 	}
+	return listener, nil
 }
 
-// parseEndpoint parses the endpoint string
+// parseEndpoint splits an endpoint into protocol and address. Endpoints are unix://path or a
+// bare path, tcp://host:port with IPv6 hosts in brackets (tcp://[::1]:10000), tcp4:// and
+// tcp6:// to pin the address family, and fd://N or fd:// for an inherited listening socket.
 func parseEndpoint(endpoint string) (string, string, error) {
-	if strings.HasPrefix(endpoint, "unix://") || strings.HasPrefix(endpoint, "tcp://") {
-		s := strings.SplitN(endpoint, "://", 2)
-		if len(s) != 2 {
-			return "", "", fmt.Errorf("invalid endpoint: %s", endpoint)
+	proto, addr, found := strings.Cut(endpoint, "://")
+	if !found {
+		// Default to unix socket
+		return "unix", endpoint, nil
+	}
+
+	switch proto = strings.ToLower(proto); proto {
+	case "unix":
+		if addr == "" {
+			return "", "", fmt.Errorf("invalid endpoint %s: missing socket path", endpoint)
+		}
+	case "tcp", "tcp4", "tcp6":
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return "", "", fmt.Errorf("invalid endpoint %s: %w (IPv6 addresses need brackets, e.g. tcp://[::1]:10000)", endpoint, err)
+		}
+	case "fd":
+		if addr != "" {
+			if fd, err := strconv.Atoi(addr); err != nil || fd < 0 {
+				return "", "", fmt.Errorf("invalid endpoint %s: %q is not a file descriptor number", endpoint, addr)
+			}
 		}
-		return s[0], s[1], nil
+	default:
+		return "", "", fmt.Errorf("invalid endpoint %s: unsupported protocol %q (supported: unix, tcp, tcp4, tcp6, fd)", endpoint, proto)
 	}
-	// Default to unix socket
-	return "unix", endpoint, nil
+	return proto, addr, nil
 }
 
-// logGRPC logs gRPC requests
+// logGRPC logs gRPC requests. Each call is assigned a CSI request ID, carried by its
+// context to the structured logs and the Emma API requests it makes.
 func logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	klog.V(4).Infof("gRPC call: %s", info.FullMethod)
-	klog.V(5).Infof("gRPC request: %+v", req)
+	id := logging.NewCSIRequestID()
+	ctx = logging.WithCSIRequestID(ctx, id)
+
+	klog.V(4).Infof("gRPC call: %s (CSI request ID %s)", info.FullMethod, id)
+	klog.V(5).Infof("gRPC request (CSI request ID %s): %+v", id, loggable(req))
 
 	resp, err := handler(ctx, req)
 
 	if err != nil {
-		klog.Errorf("gRPC error: %v", err)
+		klog.Errorf("gRPC error (CSI request ID %s): %v", id, err)
 	} else {
-		klog.V(5).Infof("gRPC response: %+v", resp)
+		klog.V(5).Infof("gRPC response (CSI request ID %s): %+v", id, loggable(resp))
 	}
 
 	return resp, err
 }
+
+// loggable returns a gRPC message without its secrets, for logging
+func loggable(message interface{}) interface{} {
+	if m, ok := message.(protoadapt.MessageV1); ok {
+		return stripSecrets(m)
+	}
+	return message
+}
@@ -0,0 +1,251 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/emma-csi-driver/pkg/logging"
+)
+
+// TestParseEndpoint tests endpoint parsing
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint      string
+		expectedProto string
+		expectedAddr  string
+		expectError   bool
+	}{
+		{endpoint: "unix:///csi/csi.sock", expectedProto: "unix", expectedAddr: "/csi/csi.sock"},
+		{endpoint: "/csi/csi.sock", expectedProto: "unix", expectedAddr: "/csi/csi.sock"},
+		{endpoint: "tcp://127.0.0.1:10000", expectedProto: "tcp", expectedAddr: "127.0.0.1:10000"},
+		{endpoint: "tcp://[::1]:10000", expectedProto: "tcp", expectedAddr: "[::1]:10000"},
+		{endpoint: "tcp6://[::]:10000", expectedProto: "tcp6", expectedAddr: "[::]:10000"},
+		{endpoint: "tcp4://:10000", expectedProto: "tcp4", expectedAddr: ":10000"},
+		{endpoint: "TCP://localhost:10000", expectedProto: "tcp", expectedAddr: "localhost:10000"},
+		{endpoint: "fd://3", expectedProto: "fd", expectedAddr: "3"},
+		{endpoint: "fd://", expectedProto: "fd", expectedAddr: ""},
+		{endpoint: "tcp://::1:10000", expectError: true},
+		{endpoint: "tcp://localhost", expectError: true},
+		{endpoint: "fd://stdin", expectError: true},
+		{endpoint: "fd://-1", expectError: true},
+		{endpoint: "unix://", expectError: true},
+		{endpoint: "http://localhost:10000", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			proto, addr, err := parseEndpoint(tt.endpoint)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error, got %s %s", proto, addr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if proto != tt.expectedProto || addr != tt.expectedAddr {
+				t.Errorf("expected %s %s, got %s %s", tt.expectedProto, tt.expectedAddr, proto, addr)
+			}
+		})
+	}
+}
+
+// TestListenInheritedFD tests listening on an already open socket passed as fd://N
+func TestListenInheritedFD(t *testing.T) {
+	inherited, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inherited.Close()
+	file, err := inherited.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	listener, err := listen("fd://" + strconv.Itoa(int(file.Fd())))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer listener.Close()
+	if listener.Addr().String() != inherited.Addr().String() {
+		t.Errorf("expected listener on %s, got %s", inherited.Addr(), listener.Addr())
+	}
+
+	t.Setenv("LISTEN_FDS", "")
+	if _, err := listen("fd://"); err == nil {
+		t.Error("expected error for fd:// without socket activation")
+	}
+}
+
+// TestStartWithListener tests serving the CSI services on a listener opened by the caller
+func TestStartWithListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := NewNonBlockingGRPCServer()
+	srv.StartWithListener(listener, NewIdentityService(&Driver{name: "csi.emma.ms", version: "1.0.0"}), nil, nil)
+	defer srv.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	resp, err := csi.NewIdentityClient(conn).GetPluginInfo(context.Background(), &csi.GetPluginInfoRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.GetName() != "csi.emma.ms" {
+		t.Errorf("expected plugin name csi.emma.ms, got %s", resp.GetName())
+	}
+}
+
+// blockingIdentity is an identity service whose Probe blocks until release is closed or
+// the call is canceled
+type blockingIdentity struct {
+	csi.UnimplementedIdentityServer
+	release chan struct{}
+}
+
+func (b *blockingIdentity) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	select {
+	case <-b.release:
+		return &csi.ProbeResponse{}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// TestGracefulStop tests that stopping drains in-flight calls, and cancels them after the timeout
+func TestGracefulStop(t *testing.T) {
+	tests := []struct {
+		name       string
+		timeout    time.Duration
+		release    bool
+		expectDone bool
+	}{
+		{name: "in-flight call finishes", timeout: time.Minute, release: true, expectDone: true},
+		{name: "timeout cancels the call", timeout: 50 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			identity := &blockingIdentity{release: make(chan struct{})}
+			srv := NewNonBlockingGRPCServer()
+			srv.StartWithListener(listener, identity, nil, nil)
+
+			conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			probed := make(chan error, 1)
+			go func() {
+				_, err := csi.NewIdentityClient(conn).Probe(context.Background(), &csi.ProbeRequest{})
+				probed <- err
+			}()
+			for srv.InFlight() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+
+			stopped := make(chan struct{})
+			go func() {
+				srv.GracefulStop(tt.timeout)
+				close(stopped)
+			}()
+			if tt.release {
+				close(identity.release)
+			}
+
+			select {
+			case <-stopped:
+			case <-time.After(10 * time.Second):
+				t.Fatal("GracefulStop did not return")
+			}
+			if err := <-probed; (err == nil) != tt.expectDone {
+				t.Errorf("expected the in-flight call to finish: %v, got error %v", tt.expectDone, err)
+			}
+			if err := <-srv.Done(); err != nil {
+				t.Errorf("expected serving to end without error, got %v", err)
+			}
+		})
+	}
+}
+
+// TestRunStopsOnCancel tests that Run drains and returns once its context is canceled
+func TestRunStopsOnCancel(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv, err := NewDriver("node-1", "tcp://"+listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv.SetIdentityService(NewIdentityService(drv))
+	drv.SetListener(listener)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- drv.Run(ctx) }()
+	cancel()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("expected Run to return nil, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
+
+// TestLogGRPCRequestID tests that every call is handed a context with its own CSI request ID
+func TestLogGRPCRequestID(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Identity/Probe"}
+	seen := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		_, err := logGRPC(context.Background(), &csi.ProbeRequest{}, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			id := logging.CSIRequestIDFromContext(ctx)
+			if id == "" || seen[id] {
+				t.Errorf("CSI request ID %q missing or reused", id)
+			}
+			seen[id] = true
+			return &csi.ProbeResponse{}, nil
+		})
+		if err != nil {
+			t.Fatalf("logGRPC() error = %v", err)
+		}
+	}
+}
+
+// TestLoggable tests that logged gRPC messages carry no secrets
+func TestLoggable(t *testing.T) {
+	req := &csi.NodeStageVolumeRequest{VolumeId: "42", Secrets: map[string]string{"passphrase": "s3cret"}}
+	logged := fmt.Sprintf("%+v", loggable(req))
+	if strings.Contains(logged, "s3cret") || !strings.Contains(logged, "42") {
+		t.Errorf("expected the volume ID without secrets, got %s", logged)
+	}
+	if logged := fmt.Sprintf("%+v", loggable(nil)); logged != "<nil>" {
+		t.Errorf("expected nil to be logged as is, got %s", logged)
+	}
+}
@@ -0,0 +1,93 @@
+package driver
+
+import (
+	"fmt"
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/emma-csi-driver/pkg/emma"
+)
+
+// annotationProvisionedBy is the PV annotation naming the CSI driver managing a volume, also
+// set by the external-provisioner on dynamically provisioned PVs
+const annotationProvisionedBy = "pv.kubernetes.io/provisioned-by"
+
+// StaticVolumeOptions configures the PersistentVolumes generated for existing Emma volumes
+type StaticVolumeOptions struct {
+	// NamePrefix is prepended to the Emma volume ID to name the PV
+	NamePrefix string
+
+	StorageClassName string
+	FSType           string
+	ReclaimPolicy    v1.PersistentVolumeReclaimPolicy
+	AccessMode       v1.PersistentVolumeAccessMode
+
+	// SizeUnit converts Emma volume sizes to the PV capacity
+	SizeUnit SizeUnit
+
+	// ExpectFormatted sets the expectFormatted hint, so the node never formats volumes
+	// expected to hold a filesystem
+	ExpectFormatted bool
+
+	// ClaimNamespace and ClaimName pre-bind the PV to a PVC when set
+	ClaimNamespace string
+	ClaimName      string
+}
+
+// StaticPersistentVolume returns a PersistentVolume statically provisioning an existing Emma
+// volume, with the volume handle, capacity and datacenter topology the driver expects
+func StaticPersistentVolume(volume *emma.VolumeResponse, opts StaticVolumeOptions) (*v1.PersistentVolume, error) {
+	if volume.DataCenterID == "" {
+		return nil, fmt.Errorf("volume %d has no datacenter", volume.ID)
+	}
+
+	pv := &v1.PersistentVolume{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "PersistentVolume"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("%s%d", opts.NamePrefix, volume.ID),
+			Annotations: map[string]string{annotationProvisionedBy: DriverName},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			Capacity: v1.ResourceList{
+				v1.ResourceStorage: *resource.NewQuantity(opts.SizeUnit.ToBytes(volume.SizeGB), resource.BinarySI),
+			},
+			AccessModes:                   []v1.PersistentVolumeAccessMode{opts.AccessMode},
+			PersistentVolumeReclaimPolicy: opts.ReclaimPolicy,
+			StorageClassName:              opts.StorageClassName,
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{
+					Driver:       DriverName,
+					VolumeHandle: strconv.Itoa(int(volume.ID)),
+					FSType:       opts.FSType,
+					VolumeAttributes: map[string]string{
+						volumeContextExpectFormatted: strconv.FormatBool(opts.ExpectFormatted),
+					},
+				},
+			},
+			NodeAffinity: &v1.VolumeNodeAffinity{
+				Required: &v1.NodeSelector{
+					NodeSelectorTerms: []v1.NodeSelectorTerm{{
+						MatchExpressions: []v1.NodeSelectorRequirement{{
+							Key:      TopologyKeyDataCenter,
+							Operator: v1.NodeSelectorOpIn,
+							Values:   []string{volume.DataCenterID},
+						}},
+					}},
+				},
+			},
+		},
+	}
+
+	if opts.ClaimName != "" {
+		pv.Spec.ClaimRef = &v1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "PersistentVolumeClaim",
+			Namespace:  opts.ClaimNamespace,
+			Name:       opts.ClaimName,
+		}
+	}
+	return pv, nil
+}
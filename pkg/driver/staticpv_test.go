@@ -0,0 +1,66 @@
+package driver
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/emma-csi-driver/pkg/emma"
+)
+
+// TestStaticPersistentVolume tests the PersistentVolumes generated for existing Emma volumes
+func TestStaticPersistentVolume(t *testing.T) {
+	volume := &emma.VolumeResponse{ID: 42, Name: "legacy-db", SizeGB: 100, Type: "ssd", DataCenterID: "aws-eu-west-2", Status: "AVAILABLE"}
+	opts := StaticVolumeOptions{
+		NamePrefix:       "emma-",
+		StorageClassName: "emma-ssd",
+		FSType:           "xfs",
+		ReclaimPolicy:    v1.PersistentVolumeReclaimRetain,
+		AccessMode:       v1.ReadWriteOnce,
+		SizeUnit:         SizeUnitGiB,
+		ExpectFormatted:  true,
+		ClaimNamespace:   "db",
+		ClaimName:        "data",
+	}
+
+	pv, err := StaticPersistentVolume(volume, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pv.Name != "emma-42" || pv.Annotations[annotationProvisionedBy] != DriverName {
+		t.Errorf("unexpected metadata: %+v", pv.ObjectMeta)
+	}
+	capacity := pv.Spec.Capacity[v1.ResourceStorage]
+	if capacity.String() != "100Gi" {
+		t.Errorf("expected 100Gi capacity, got %s", capacity.String())
+	}
+	csiSource := pv.Spec.CSI
+	if csiSource.Driver != DriverName || csiSource.VolumeHandle != "42" || csiSource.FSType != "xfs" {
+		t.Errorf("unexpected CSI source: %+v", csiSource)
+	}
+	if csiSource.VolumeAttributes[volumeContextExpectFormatted] != "true" {
+		t.Errorf("expected expectFormatted to be true, got %v", csiSource.VolumeAttributes)
+	}
+	requirement := pv.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions[0]
+	if requirement.Key != TopologyKeyDataCenter || len(requirement.Values) != 1 || requirement.Values[0] != "aws-eu-west-2" {
+		t.Errorf("unexpected node affinity: %+v", requirement)
+	}
+	if pv.Spec.ClaimRef == nil || pv.Spec.ClaimRef.Namespace != "db" || pv.Spec.ClaimRef.Name != "data" {
+		t.Errorf("expected the PV to be pre-bound to db/data, got %+v", pv.Spec.ClaimRef)
+	}
+
+	// GB sized volumes report their decimal capacity
+	opts.SizeUnit, opts.ClaimName = SizeUnitGB, ""
+	pv, err = StaticPersistentVolume(volume, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	capacity = pv.Spec.Capacity[v1.ResourceStorage]
+	if capacity.Value() != 100*1000*1000*1000 || pv.Spec.ClaimRef != nil {
+		t.Errorf("expected an unbound PV of 100GB, got %s bound to %+v", capacity.String(), pv.Spec.ClaimRef)
+	}
+
+	if _, err := StaticPersistentVolume(&emma.VolumeResponse{ID: 43}, opts); err == nil {
+		t.Error("expected an error for a volume without a datacenter")
+	}
+}
@@ -0,0 +1,59 @@
+package driver
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// SizeUnit is the number of bytes in one unit of the Emma volume size fields (volumeGb, sizeGb)
+type SizeUnit int64
+
+const (
+	// SizeUnitGiB treats Emma sizes as binary gigabytes (1024^3 bytes)
+	SizeUnitGiB SizeUnit = 1024 * 1024 * 1024
+
+	// SizeUnitGB treats Emma sizes as decimal gigabytes (1000^3 bytes)
+	SizeUnitGB SizeUnit = 1000 * 1000 * 1000
+)
+
+// ParseSizeUnit parses a size unit name (GiB or GB)
+func ParseSizeUnit(name string) (SizeUnit, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "gib":
+		return SizeUnitGiB, nil
+	case "gb":
+		return SizeUnitGB, nil
+	default:
+		return 0, fmt.Errorf("unsupported size unit %q (supported: GiB, GB)", name)
+	}
+}
+
+// String returns the unit name
+func (u SizeUnit) String() string {
+	if u == SizeUnitGB {
+		return "GB"
+	}
+	return "GiB"
+}
+
+// FromBytes converts bytes to whole units, rounding up and returning at least 1. Sizes
+// beyond int32 are clamped to math.MaxInt32 instead of wrapping around.
+func (u SizeUnit) FromBytes(bytes int64) int32 {
+	units := bytes / int64(u)
+	if bytes%int64(u) > 0 {
+		units++
+	}
+	if units < 1 {
+		units = 1
+	}
+	if units > math.MaxInt32 {
+		units = math.MaxInt32
+	}
+	return int32(units)
+}
+
+// ToBytes converts units to bytes
+func (u SizeUnit) ToBytes(units int32) int64 {
+	return int64(units) * int64(u)
+}
@@ -0,0 +1,111 @@
+package driver
+
+import (
+	"math"
+	"testing"
+)
+
+// TestSizeUnitConversions tests byte conversions for GiB and GB Emma size units
+func TestSizeUnitConversions(t *testing.T) {
+	tests := []struct {
+		name          string
+		unit          SizeUnit
+		bytes         int64
+		expectedUnits int32
+		expectedBytes int64
+	}{
+		{
+			name:          "GiB exact",
+			unit:          SizeUnitGiB,
+			bytes:         10 * 1024 * 1024 * 1024,
+			expectedUnits: 10,
+			expectedBytes: 10 * 1024 * 1024 * 1024,
+		},
+		{
+			name:          "GiB rounds up",
+			unit:          SizeUnitGiB,
+			bytes:         10*1024*1024*1024 + 1,
+			expectedUnits: 11,
+			expectedBytes: 11 * 1024 * 1024 * 1024,
+		},
+		{
+			name:          "GB exact",
+			unit:          SizeUnitGB,
+			bytes:         10 * 1000 * 1000 * 1000,
+			expectedUnits: 10,
+			expectedBytes: 10 * 1000 * 1000 * 1000,
+		},
+		{
+			name:          "10GiB request in GB units",
+			unit:          SizeUnitGB,
+			bytes:         10 * 1024 * 1024 * 1024,
+			expectedUnits: 11,
+			expectedBytes: 11 * 1000 * 1000 * 1000,
+		},
+		{
+			name:          "zero bytes is at least one unit",
+			unit:          SizeUnitGB,
+			bytes:         0,
+			expectedUnits: 1,
+			expectedBytes: 1000 * 1000 * 1000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			units := tt.unit.FromBytes(tt.bytes)
+			if units != tt.expectedUnits {
+				t.Errorf("expected %d units, got %d", tt.expectedUnits, units)
+			}
+			if got := tt.unit.ToBytes(units); got != tt.expectedBytes {
+				t.Errorf("expected %d bytes, got %d", tt.expectedBytes, got)
+			}
+			if got := tt.unit.ToBytes(units); got < tt.bytes {
+				t.Errorf("provisioned %d bytes is less than requested %d", got, tt.bytes)
+			}
+		})
+	}
+}
+
+// TestSizeUnitFromBytesClamps tests that sizes beyond int32 units are clamped instead of wrapping around
+func TestSizeUnitFromBytesClamps(t *testing.T) {
+	for _, bytes := range []int64{int64(math.MaxInt32)*int64(SizeUnitGB) + 1, math.MaxInt64} {
+		if got := SizeUnitGB.FromBytes(bytes); got != math.MaxInt32 {
+			t.Errorf("FromBytes(%d) = %d, expected %d", bytes, got, math.MaxInt32)
+		}
+	}
+}
+
+// TestParseSizeUnit tests parsing of size unit names
+func TestParseSizeUnit(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    SizeUnit
+		expectError bool
+	}{
+		{input: "GiB", expected: SizeUnitGiB},
+		{input: "gib", expected: SizeUnitGiB},
+		{input: "GB", expected: SizeUnitGB},
+		{input: " gb ", expected: SizeUnitGB},
+		{input: "TB", expectError: true},
+		{input: "", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			unit, err := ParseSizeUnit(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error for %q", tt.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if unit != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, unit)
+			}
+		})
+	}
+}
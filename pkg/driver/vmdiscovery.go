@@ -0,0 +1,149 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	emmasdk "github.com/emma-community/emma-go-sdk"
+	"k8s.io/klog/v2"
+)
+
+// EmmaVMDiscoveryAPI defines the Emma API operations used to discover the node's VM
+type EmmaVMDiscoveryAPI interface {
+	ListVMs(ctx context.Context) ([]emmasdk.Vm, error)
+	ListKubernetesClusters(ctx context.Context) ([]emmasdk.Kubernetes, error)
+}
+
+// NodeIdentity is what the node plugin knows about the VM it runs on
+type NodeIdentity struct {
+	// Names are the Kubernetes node name and the hostname
+	Names []string
+
+	// IPs are the addresses of the VM's network interfaces
+	IPs []string
+}
+
+// LocalNodeIdentity collects the node name, the hostname and the global unicast addresses
+// of the network interfaces. The node plugin runs in the host network namespace.
+func LocalNodeIdentity(nodeName string) NodeIdentity {
+	identity := NodeIdentity{}
+	if nodeName != "" {
+		identity.Names = append(identity.Names, nodeName)
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		identity.Names = append(identity.Names, hostname)
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		klog.V(4).Infof("Failed to list interface addresses: %v", err)
+		return identity
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.IsGlobalUnicast() {
+			identity.IPs = append(identity.IPs, ipNet.IP.String())
+		}
+	}
+	return identity
+}
+
+// DiscoverVMID finds the Emma VM the node runs on by matching its names against the names
+// of the account's VMs and managed Kubernetes cluster nodes, then its addresses against
+// their network addresses. A match must be unambiguous.
+func DiscoverVMID(ctx context.Context, api EmmaVMDiscoveryAPI, identity NodeIdentity) (int32, error) {
+	byName := make(map[int32]bool)
+	byIP := make(map[int32]bool)
+
+	match := func(id int32, name string, ips []string) {
+		if id == 0 {
+			return
+		}
+		for _, candidate := range identity.Names {
+			if nameMatches(name, candidate) {
+				byName[id] = true
+			}
+		}
+		for _, ip := range ips {
+			for _, candidate := range identity.IPs {
+				if ip == candidate {
+					byIP[id] = true
+				}
+			}
+		}
+	}
+
+	vms, err := api.ListVMs(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list VMs: %w", err)
+	}
+	for _, vm := range vms {
+		match(vm.GetId(), vm.GetName(), networkIPs(vm.GetNetworks()))
+	}
+
+	// Managed cluster nodes may be missing from the VM list
+	clusters, err := api.ListKubernetesClusters(ctx)
+	if err != nil {
+		klog.V(4).Infof("Failed to list Kubernetes clusters for VM discovery: %v", err)
+	}
+	for _, cluster := range clusters {
+		for _, nodeGroup := range cluster.GetNodeGroups() {
+			for _, node := range nodeGroup.GetNodes() {
+				match(node.GetId(), node.GetName(), networkIPs(node.GetNetworks()))
+			}
+		}
+	}
+
+	for _, matched := range []struct {
+		by  string
+		ids map[int32]bool
+	}{{"name", byName}, {"address", byIP}} {
+		switch ids := sortedVMIDs(matched.ids); len(ids) {
+		case 0:
+			continue
+		case 1:
+			klog.Infof("Discovered Emma VM %d by %s", ids[0], matched.by)
+			return ids[0], nil
+		default:
+			return 0, fmt.Errorf("%d Emma VMs match the node by %s (IDs %v), set the VM ID explicitly", len(ids), matched.by, ids)
+		}
+	}
+	return 0, fmt.Errorf("no Emma VM matches names %v or addresses %v", identity.Names, identity.IPs)
+}
+
+// nameMatches compares a VM name with a node name or hostname, ignoring case and the
+// domain of fully qualified hostnames
+func nameMatches(vmName, candidate string) bool {
+	if vmName == "" || candidate == "" {
+		return false
+	}
+	if strings.EqualFold(vmName, candidate) {
+		return true
+	}
+	short, _, _ := strings.Cut(candidate, ".")
+	return strings.EqualFold(vmName, short)
+}
+
+// networkIPs returns the addresses of Emma VM networks
+func networkIPs(networks []emmasdk.KubernetesNodeGroupsInnerNodesInnerNetworksInner) []string {
+	var ips []string
+	for _, network := range networks {
+		if ip := network.GetIp(); ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// sortedVMIDs returns the keys of a VM ID set in order
+func sortedVMIDs(set map[int32]bool) []int32 {
+	ids := make([]int32, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
@@ -0,0 +1,69 @@
+package driver
+
+import (
+	"context"
+	"github.com/emma-csi-driver/internal/fakeemma"
+	"testing"
+
+	emmasdk "github.com/emma-community/emma-go-sdk"
+)
+
+// discoveryVM returns an Emma VM with a name and network addresses
+func discoveryVM(id int32, name string, ips ...string) emmasdk.Vm {
+	vm := emmasdk.Vm{Id: emmasdk.PtrInt32(id), Name: emmasdk.PtrString(name)}
+	for _, ip := range ips {
+		vm.Networks = append(vm.Networks, emmasdk.KubernetesNodeGroupsInnerNodesInnerNetworksInner{Ip: emmasdk.PtrString(ip)})
+	}
+	return vm
+}
+
+// TestDiscoverVMID tests matching the node against Emma VMs and managed cluster nodes
+func TestDiscoverVMID(t *testing.T) {
+	clusterNode := emmasdk.KubernetesNodeGroupsInnerNodesInner{
+		Id:       emmasdk.PtrInt32(30),
+		Name:     emmasdk.PtrString("managed-node"),
+		Networks: []emmasdk.KubernetesNodeGroupsInnerNodesInnerNetworksInner{{Ip: emmasdk.PtrString("10.0.0.30")}},
+	}
+	api := fakeemma.New()
+	api.VMs = []emmasdk.Vm{
+		discoveryVM(10, "worker-1", "10.0.0.10", "203.0.113.10"),
+		discoveryVM(11, "worker-2", "10.0.0.11"),
+		discoveryVM(12, "twin", "10.0.0.12"),
+		discoveryVM(13, "twin", "10.0.0.13"),
+	}
+	api.Clusters = []emmasdk.Kubernetes{{NodeGroups: []emmasdk.KubernetesNodeGroupsInner{{Nodes: []emmasdk.KubernetesNodeGroupsInnerNodesInner{clusterNode}}}}}
+
+	tests := []struct {
+		name        string
+		identity    NodeIdentity
+		expected    int32
+		expectError bool
+	}{
+		{name: "node name", identity: NodeIdentity{Names: []string{"worker-1"}}, expected: 10},
+		{name: "fully qualified hostname", identity: NodeIdentity{Names: []string{"k8s-node", "Worker-2.example.internal"}}, expected: 11},
+		{name: "managed cluster node", identity: NodeIdentity{Names: []string{"managed-node"}}, expected: 30},
+		{name: "address", identity: NodeIdentity{Names: []string{"unknown"}, IPs: []string{"172.17.0.1", "203.0.113.10"}}, expected: 10},
+		{name: "cluster node address", identity: NodeIdentity{IPs: []string{"10.0.0.30"}}, expected: 30},
+		{name: "name wins over address", identity: NodeIdentity{Names: []string{"worker-2"}, IPs: []string{"10.0.0.10"}}, expected: 11},
+		{name: "ambiguous name", identity: NodeIdentity{Names: []string{"twin"}, IPs: []string{"10.0.0.12"}}, expectError: true},
+		{name: "no match", identity: NodeIdentity{Names: []string{"unknown"}, IPs: []string{"192.0.2.1"}}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := DiscoverVMID(context.Background(), api, tt.identity)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected an error, got VM %d", id)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if id != tt.expected {
+				t.Errorf("expected VM %d, got %d", tt.expected, id)
+			}
+		})
+	}
+}
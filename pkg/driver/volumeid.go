@@ -0,0 +1,46 @@
+package driver
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// VolumeIDTroubleshootingURL documents the expected volume handle format and common mistakes
+const VolumeIDTroubleshootingURL = "https://github.com/emma-community/emma-csi-driver/blob/main/docs/TROUBLESHOOTING.md#invalid-volume-id"
+
+// parseVolumeID parses a CSI volume ID into an Emma volume ID. The error is an
+// InvalidArgument status whose message explains the expected format and the
+// likely cause of the mismatch.
+func parseVolumeID(volumeID string) (int64, error) {
+	id, err := strconv.ParseInt(volumeID, 10, 32)
+	if err == nil && id > 0 {
+		return id, nil
+	}
+
+	return 0, status.Errorf(codes.InvalidArgument,
+		"invalid volume ID %q: expected a positive numeric Emma volume ID (e.g. \"12345\"); %s; see %s",
+		volumeID, volumeIDHint(volumeID, err), VolumeIDTroubleshootingURL)
+}
+
+// volumeIDHint returns the most likely cause of an unparsable volume ID
+func volumeIDHint(volumeID string, err error) string {
+	var numErr *strconv.NumError
+	switch {
+	case errors.As(err, &numErr) && errors.Is(numErr.Err, strconv.ErrRange):
+		return "the value is out of range for an Emma volume ID"
+	case err == nil:
+		return "Emma volume IDs start at 1"
+	case strings.HasPrefix(volumeID, "pvc-") || strings.HasPrefix(volumeID, "pv-"):
+		return "this looks like a Kubernetes PV/PVC name; for a statically provisioned PV set spec.csi.volumeHandle to the Emma volume ID"
+	case strings.Contains(volumeID, "/"):
+		return "this looks like a path or another driver's handle; check that the PV uses the csi.emma.ms driver and a numeric volumeHandle"
+	case strings.TrimSpace(volumeID) != volumeID:
+		return "the volume ID contains surrounding whitespace, check the PV volumeHandle"
+	default:
+		return "check the volumeHandle of statically provisioned PVs"
+	}
+}
@@ -0,0 +1,52 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestParseVolumeID tests volume ID parsing and the hints in its errors
+func TestParseVolumeID(t *testing.T) {
+	tests := []struct {
+		name         string
+		volumeID     string
+		expected     int64
+		expectedHint string
+	}{
+		{name: "numeric ID", volumeID: "12345", expected: 12345},
+		{name: "PV name", volumeID: "pvc-0b6f4a3e", expectedHint: "volumeHandle"},
+		{name: "path", volumeID: "projects/1/volumes/2", expectedHint: "csi.emma.ms"},
+		{name: "out of range", volumeID: "99999999999", expectedHint: "out of range"},
+		{name: "zero", volumeID: "0", expectedHint: "start at 1"},
+		{name: "whitespace", volumeID: " 123", expectedHint: "whitespace"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := parseVolumeID(tt.volumeID)
+			if tt.expectedHint == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if id != tt.expected {
+					t.Errorf("expected %d, got %d", tt.expected, id)
+				}
+				return
+			}
+
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("expected InvalidArgument, got %v", err)
+			}
+			msg := status.Convert(err).Message()
+			if !strings.Contains(msg, tt.expectedHint) {
+				t.Errorf("expected hint containing %q, got %q", tt.expectedHint, msg)
+			}
+			if !strings.Contains(msg, VolumeIDTroubleshootingURL) {
+				t.Errorf("expected troubleshooting link in %q", msg)
+			}
+		})
+	}
+}
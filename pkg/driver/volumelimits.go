@@ -0,0 +1,207 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// defaultMaxVolumesPerNode is the Emma platform limit of volumes attached to one VM
+const defaultMaxVolumesPerNode = 16
+
+// Instance metadata endpoints, replaced in tests
+var (
+	// linkLocalMetadataURL serves the AWS and Azure instance metadata
+	linkLocalMetadataURL = "http://169.254.169.254"
+
+	// gcpMetadataURL serves the GCP instance metadata
+	gcpMetadataURL = "http://metadata.google.internal"
+)
+
+// metadataTimeout bounds each instance metadata request, which never answers off its cloud
+const metadataTimeout = time.Second
+
+// awsXenFamilies are the EC2 instance families on the Xen hypervisor, which allow 40 EBS
+// attachments instead of sharing 28 with the network interfaces as on Nitro
+var awsXenFamilies = map[string]bool{
+	"c1": true, "c3": true, "c4": true, "d2": true, "g2": true, "g3": true, "h1": true,
+	"i2": true, "i3": true, "m1": true, "m2": true, "m3": true, "m4": true, "p2": true,
+	"p3": true, "r3": true, "r4": true, "t1": true, "t2": true, "x1": true, "x1e": true,
+}
+
+// azureVCPUs extracts the vCPU count from Azure sizes such as Standard_D4s_v5 or Standard_B2ms
+var azureVCPUs = regexp.MustCompile(`^(?:Standard|Basic)_[A-Z]+?(\d+)`)
+
+// gcpSharedCoreTypes are the GCP machine types limited to 16 persistent disks
+var gcpSharedCoreTypes = map[string]bool{
+	"e2-micro": true, "e2-small": true, "e2-medium": true, "f1-micro": true, "g1-small": true,
+}
+
+// instanceType is the cloud instance type of the node's VM
+type instanceType struct {
+	provider string
+	name     string
+
+	// networkInterfaces counts the attached network interfaces, which take attachment
+	// slots on AWS Nitro instances
+	networkInterfaces int
+}
+
+// SetMaxVolumesPerNode sets the number of volumes NodeGetInfo reports as attachable
+func (s *NodeService) SetMaxVolumesPerNode(limit int64) {
+	s.maxVolumesPerNode = limit
+}
+
+// DiscoverMaxVolumesPerNode looks up the node's instance type in the cloud instance metadata
+// and lowers the reported volume limit to the instance type's attachment limit. The Emma
+// platform limit is kept when the instance type or its limit is unknown.
+func (s *NodeService) DiscoverMaxVolumesPerNode(ctx context.Context) int64 {
+	client := &http.Client{Timeout: metadataTimeout}
+	instance, err := lookupInstanceType(ctx, client)
+	if err != nil {
+		klog.Infof("Instance type not discovered, reporting the Emma limit of %d volumes per node: %v", s.maxVolumesPerNode, err)
+		return s.maxVolumesPerNode
+	}
+
+	limit, ok := instanceVolumeLimit(instance)
+	if !ok {
+		klog.Infof("No attachment limit known for %s instance type %s, reporting %d volumes per node", instance.provider, instance.name, s.maxVolumesPerNode)
+		return s.maxVolumesPerNode
+	}
+	if limit < s.maxVolumesPerNode {
+		s.maxVolumesPerNode = limit
+	}
+	klog.Infof("Node is a %s %s instance, reporting %d volumes per node", instance.provider, instance.name, s.maxVolumesPerNode)
+	return s.maxVolumesPerNode
+}
+
+// instanceVolumeLimit returns the number of data volumes an instance type can attach
+// besides its boot disk
+func instanceVolumeLimit(instance instanceType) (int64, bool) {
+	switch instance.provider {
+	case "aws":
+		family := strings.SplitN(instance.name, ".", 2)[0]
+		if awsXenFamilies[family] {
+			return 39, true
+		}
+		// Nitro instances share 28 attachments between the root volume, network
+		// interfaces and EBS volumes
+		interfaces := instance.networkInterfaces
+		if interfaces == 0 {
+			interfaces = 1
+		}
+		return int64(28 - 1 - interfaces), true
+	case "azure":
+		// Most sizes allow two data disks per vCPU, the smallest ones two
+		match := azureVCPUs.FindStringSubmatch(instance.name)
+		if match == nil {
+			return 0, false
+		}
+		vcpus, err := strconv.Atoi(match[1])
+		if err != nil || vcpus == 0 {
+			return 0, false
+		}
+		limit := int64(2 * vcpus)
+		if limit > 64 {
+			limit = 64
+		}
+		return limit, true
+	case "gcp":
+		if gcpSharedCoreTypes[instance.name] {
+			return 15, true
+		}
+		return 127, true
+	}
+	return 0, false
+}
+
+// lookupInstanceType asks the AWS, Azure and GCP instance metadata services for the
+// instance type, returning the first that answers
+func lookupInstanceType(ctx context.Context, client *http.Client) (instanceType, error) {
+	var errs []string
+	for _, lookup := range []func(context.Context, *http.Client) (instanceType, error){
+		awsInstanceType, azureInstanceType, gcpInstanceType,
+	} {
+		instance, err := lookup(ctx, client)
+		if err == nil {
+			return instance, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return instanceType{}, fmt.Errorf("no instance metadata service answered: %s", strings.Join(errs, "; "))
+}
+
+// awsInstanceType reads the instance type and network interfaces from IMDSv2
+func awsInstanceType(ctx context.Context, client *http.Client) (instanceType, error) {
+	token, err := getMetadata(ctx, client, http.MethodPut, linkLocalMetadataURL+"/latest/api/token",
+		map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "60"})
+	if err != nil {
+		return instanceType{}, fmt.Errorf("aws: %w", err)
+	}
+	headers := map[string]string{"X-aws-ec2-metadata-token": token}
+	name, err := getMetadata(ctx, client, http.MethodGet, linkLocalMetadataURL+"/latest/meta-data/instance-type", headers)
+	if err != nil {
+		return instanceType{}, fmt.Errorf("aws: %w", err)
+	}
+	instance := instanceType{provider: "aws", name: name}
+	if macs, err := getMetadata(ctx, client, http.MethodGet, linkLocalMetadataURL+"/latest/meta-data/network/interfaces/macs/", headers); err == nil {
+		instance.networkInterfaces = len(strings.Fields(macs))
+	}
+	return instance, nil
+}
+
+// azureInstanceType reads the VM size from the Azure instance metadata service
+func azureInstanceType(ctx context.Context, client *http.Client) (instanceType, error) {
+	name, err := getMetadata(ctx, client, http.MethodGet, linkLocalMetadataURL+"/metadata/instance/compute/vmSize?api-version=2021-02-01&format=text",
+		map[string]string{"Metadata": "true"})
+	if err != nil {
+		return instanceType{}, fmt.Errorf("azure: %w", err)
+	}
+	return instanceType{provider: "azure", name: name}, nil
+}
+
+// gcpInstanceType reads the machine type from the GCP metadata server
+func gcpInstanceType(ctx context.Context, client *http.Client) (instanceType, error) {
+	machineType, err := getMetadata(ctx, client, http.MethodGet, gcpMetadataURL+"/computeMetadata/v1/instance/machine-type",
+		map[string]string{"Metadata-Flavor": "Google"})
+	if err != nil {
+		return instanceType{}, fmt.Errorf("gcp: %w", err)
+	}
+	// projects/<number>/machineTypes/<type>
+	return instanceType{provider: "gcp", name: machineType[strings.LastIndex(machineType, "/")+1:]}, nil
+}
+
+// getMetadata fetches a non-empty text value from an instance metadata service
+func getMetadata(ctx context.Context, client *http.Client, method, url string, headers map[string]string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return "", err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s %s: status %d", method, url, resp.StatusCode)
+	}
+	value := strings.TrimSpace(string(body))
+	if value == "" {
+		return "", fmt.Errorf("%s %s: empty response", method, url)
+	}
+	return value, nil
+}
@@ -0,0 +1,115 @@
+package driver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestInstanceVolumeLimit tests the attachment limits of known instance types
+func TestInstanceVolumeLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		instance instanceType
+		expected int64
+		ok       bool
+	}{
+		{name: "aws nitro", instance: instanceType{provider: "aws", name: "t3.small", networkInterfaces: 2}, expected: 25, ok: true},
+		{name: "aws nitro without interfaces", instance: instanceType{provider: "aws", name: "m5.large"}, expected: 26, ok: true},
+		{name: "aws xen", instance: instanceType{provider: "aws", name: "t2.micro", networkInterfaces: 1}, expected: 39, ok: true},
+		{name: "azure burstable", instance: instanceType{provider: "azure", name: "Standard_B1s"}, expected: 2, ok: true},
+		{name: "azure general purpose", instance: instanceType{provider: "azure", name: "Standard_D4s_v5"}, expected: 8, ok: true},
+		{name: "azure capped", instance: instanceType{provider: "azure", name: "Standard_M416ms_v2"}, expected: 64, ok: true},
+		{name: "azure unknown size", instance: instanceType{provider: "azure", name: "Custom"}, ok: false},
+		{name: "gcp shared core", instance: instanceType{provider: "gcp", name: "e2-small"}, expected: 15, ok: true},
+		{name: "gcp standard", instance: instanceType{provider: "gcp", name: "n2-standard-4"}, expected: 127, ok: true},
+		{name: "unknown provider", instance: instanceType{provider: "openstack", name: "m1.small"}, ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit, ok := instanceVolumeLimit(tt.instance)
+			if ok != tt.ok || limit != tt.expected {
+				t.Errorf("expected %d, %v, got %d, %v", tt.expected, tt.ok, limit, ok)
+			}
+		})
+	}
+}
+
+// TestDiscoverMaxVolumesPerNode tests that the instance type limit lowers the Emma limit
+func TestDiscoverMaxVolumesPerNode(t *testing.T) {
+	defer func(linkLocal, gcp string) { linkLocalMetadataURL, gcpMetadataURL = linkLocal, gcp }(linkLocalMetadataURL, gcpMetadataURL)
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		expected int64
+	}{
+		{
+			name: "aws keeps the emma limit",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+					_, _ = w.Write([]byte("token"))
+				case r.Header.Get("X-aws-ec2-metadata-token") != "token":
+					w.WriteHeader(http.StatusUnauthorized)
+				case r.URL.Path == "/latest/meta-data/instance-type":
+					_, _ = w.Write([]byte("m5.large"))
+				case r.URL.Path == "/latest/meta-data/network/interfaces/macs/":
+					_, _ = w.Write([]byte("0e:00:00:00:00:01/\n0e:00:00:00:00:02/\n"))
+				default:
+					http.NotFound(w, r)
+				}
+			},
+			expected: 16,
+		},
+		{
+			name: "small azure size",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/metadata/instance/compute/vmSize" && r.Header.Get("Metadata") == "true" {
+					_, _ = w.Write([]byte("Standard_B2s"))
+					return
+				}
+				http.NotFound(w, r)
+			},
+			expected: 4,
+		},
+		{
+			name: "gcp machine type",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/computeMetadata/v1/instance/machine-type" && r.Header.Get("Metadata-Flavor") == "Google" {
+					_, _ = w.Write([]byte("projects/123/machineTypes/e2-micro"))
+					return
+				}
+				http.NotFound(w, r)
+			},
+			expected: 15,
+		},
+		{
+			name:     "no metadata service",
+			handler:  http.NotFound,
+			expected: 16,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+			linkLocalMetadataURL, gcpMetadataURL = server.URL, server.URL
+
+			service := NewNodeService(&Driver{name: "csi.emma.ms"})
+			if limit := service.DiscoverMaxVolumesPerNode(context.Background()); limit != tt.expected {
+				t.Errorf("expected %d volumes per node, got %d", tt.expected, limit)
+			}
+			resp, err := service.NodeGetInfo(context.Background(), nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.MaxVolumesPerNode != tt.expected {
+				t.Errorf("expected NodeGetInfo to report %d volumes, got %d", tt.expected, resp.MaxVolumesPerNode)
+			}
+		})
+	}
+}
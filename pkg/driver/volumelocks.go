@@ -0,0 +1,49 @@
+package driver
+
+import (
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// volumeLocks tracks the operation in flight for each volume, so concurrent CSI calls for
+// the same volume are rejected instead of racing each other against the Emma API
+type volumeLocks struct {
+	mu       sync.Mutex
+	inFlight map[string]string
+}
+
+// newVolumeLocks creates an empty volume lock set
+func newVolumeLocks() *volumeLocks {
+	return &volumeLocks{inFlight: make(map[string]string)}
+}
+
+// TryAcquire marks an operation in flight for a volume. It returns false and the operation
+// already holding the volume if there is one.
+func (l *volumeLocks) TryAcquire(volumeID, operation string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if holder, ok := l.inFlight[volumeID]; ok {
+		return holder, false
+	}
+	l.inFlight[volumeID] = operation
+	return "", true
+}
+
+// Release marks a volume as having no operation in flight
+func (l *volumeLocks) Release(volumeID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.inFlight, volumeID)
+}
+
+// lock acquires a volume for an operation, returning a function releasing it or an
+// Aborted error, which the sidecars retry, when another operation holds the volume
+func (l *volumeLocks) lock(volumeID, operation string) (func(), error) {
+	if holder, ok := l.TryAcquire(volumeID, operation); !ok {
+		return nil, status.Errorf(codes.Aborted, "an operation (%s) for volume %s is already in progress", holder, volumeID)
+	}
+	return func() { l.Release(volumeID) }, nil
+}
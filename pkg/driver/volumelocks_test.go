@@ -0,0 +1,121 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestVolumeLocks tests that a volume can only be held by one operation at a time
+func TestVolumeLocks(t *testing.T) {
+	locks := newVolumeLocks()
+
+	if _, ok := locks.TryAcquire("1", "DeleteVolume"); !ok {
+		t.Fatal("expected to acquire free volume")
+	}
+	if holder, ok := locks.TryAcquire("1", "ControllerUnpublishVolume"); ok || holder != "DeleteVolume" {
+		t.Errorf("expected volume held by DeleteVolume, got holder %q, acquired %v", holder, ok)
+	}
+	if _, ok := locks.TryAcquire("2", "ControllerPublishVolume"); !ok {
+		t.Error("expected to acquire another volume")
+	}
+
+	locks.Release("1")
+	if _, ok := locks.TryAcquire("1", "ControllerUnpublishVolume"); !ok {
+		t.Error("expected to acquire released volume")
+	}
+}
+
+// TestControllerVolumeLockAborted tests that controller RPCs return Aborted for a busy volume
+func TestControllerVolumeLockAborted(t *testing.T) {
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, nil)
+	service.volumeLocks.TryAcquire("42", "DeleteVolume")
+
+	capability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{name: "DeleteVolume", call: func() error {
+			_, err := service.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "42"})
+			return err
+		}},
+		{name: "ControllerPublishVolume", call: func() error {
+			_, err := service.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{VolumeId: "42", NodeId: "node", VolumeCapability: capability})
+			return err
+		}},
+		{name: "ControllerUnpublishVolume", call: func() error {
+			_, err := service.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{VolumeId: "42", NodeId: "node"})
+			return err
+		}},
+		{name: "ControllerExpandVolume", call: func() error {
+			_, err := service.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{VolumeId: "42", CapacityRange: &csi.CapacityRange{RequiredBytes: gib}})
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := status.Code(tt.call()); code != codes.Aborted {
+				t.Errorf("expected Aborted, got %v", code)
+			}
+		})
+	}
+}
+
+// TestNodeVolumeLockAborted tests that node RPCs return Aborted for a busy volume
+func TestNodeVolumeLockAborted(t *testing.T) {
+	mounter := &fakeMounter{device: "/dev/vdb"}
+	service := NewNodeService(&Driver{name: "csi.emma.ms"})
+	service.mounter = mounter
+	service.volumeLocks.TryAcquire("42", "NodeStageVolume")
+
+	capability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{name: "NodeStageVolume", call: func() error {
+			_, err := service.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{VolumeId: "42", StagingTargetPath: "/mnt/staging", VolumeCapability: capability})
+			return err
+		}},
+		{name: "NodeUnstageVolume", call: func() error {
+			_, err := service.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: "42", StagingTargetPath: "/mnt/staging"})
+			return err
+		}},
+		{name: "NodePublishVolume", call: func() error {
+			_, err := service.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{VolumeId: "42", StagingTargetPath: "/mnt/staging", TargetPath: "/mnt/target", VolumeCapability: capability})
+			return err
+		}},
+		{name: "NodeUnpublishVolume", call: func() error {
+			_, err := service.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: "42", TargetPath: "/mnt/target"})
+			return err
+		}},
+		{name: "NodeExpandVolume", call: func() error {
+			_, err := service.NodeExpandVolume(context.Background(), &csi.NodeExpandVolumeRequest{VolumeId: "42", VolumePath: "/mnt/target", VolumeCapability: capability})
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := status.Code(tt.call()); code != codes.Aborted {
+				t.Errorf("expected Aborted, got %v", code)
+			}
+		})
+	}
+	if len(mounter.calls) != 0 {
+		t.Errorf("expected no mounter calls for a busy volume, got %v", mounter.calls)
+	}
+}
@@ -0,0 +1,146 @@
+package driver
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// defaultVolumeSizeBytes is the size of volumes requested without a capacity range, unless
+// the StorageClass or --default-volume-size sets another
+const defaultVolumeSizeBytes int64 = 1 << 30
+
+// ParseVolumeSize parses a Kubernetes quantity such as 10Gi or 500M into bytes
+func ParseVolumeSize(value string) (int64, error) {
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", value, err)
+	}
+	if quantity.Sign() <= 0 {
+		return 0, fmt.Errorf("invalid size %q: must be positive", value)
+	}
+	return quantity.Value(), nil
+}
+
+// SizeRoundingPolicy controls how requested sizes are turned into the power of two sizes
+// Emma accepts
+type SizeRoundingPolicy string
+
+const (
+	// SizeRoundingRoundUp rounds requested sizes up to the next power of two
+	SizeRoundingRoundUp SizeRoundingPolicy = "round-up"
+
+	// SizeRoundingStrict rejects requested sizes that are not a power of two
+	SizeRoundingStrict SizeRoundingPolicy = "strict"
+
+	// SizeRoundingExact provisions the requested size as is, for when Emma accepts any size
+	SizeRoundingExact SizeRoundingPolicy = "exact"
+)
+
+// ParseSizeRoundingPolicy parses a --volume-size-rounding value
+func ParseSizeRoundingPolicy(value string) (SizeRoundingPolicy, error) {
+	switch policy := SizeRoundingPolicy(value); policy {
+	case SizeRoundingRoundUp, SizeRoundingStrict, SizeRoundingExact:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid size rounding policy %q: expected round-up, strict or exact", value)
+}
+
+// Size returns the size to provision for a requested size in Emma units. Sizes above the
+// largest Emma volume are rejected with OutOfRange rather than capped.
+func (p SizeRoundingPolicy) Size(requestedGB int32) (int32, error) {
+	if requestedGB > maxVolumeSizeGB {
+		return 0, status.Errorf(codes.OutOfRange, "requested size (%dGB) exceeds the maximum volume size of %dGB", requestedGB, maxVolumeSizeGB)
+	}
+	switch p {
+	case SizeRoundingExact:
+		return requestedGB, nil
+	case SizeRoundingStrict:
+		if requestedGB&(requestedGB-1) != 0 {
+			return 0, status.Errorf(codes.InvalidArgument, "requested size (%dGB) is not a power of 2 and size rounding is strict, request %dGB or %dGB",
+				requestedGB, roundUpToPowerOfTwo(requestedGB)/2, roundUpToPowerOfTwo(requestedGB))
+		}
+		return requestedGB, nil
+	default:
+		return roundUpToPowerOfTwo(requestedGB), nil
+	}
+}
+
+// SetSizeRounding sets how requested sizes are rounded to sizes Emma accepts
+func (s *ControllerService) SetSizeRounding(policy SizeRoundingPolicy) {
+	s.sizeRounding = policy
+}
+
+// provisionedSize returns the size in Emma units to create a volume of capacityBytes with,
+// checking the rounded size against the limit of the capacity range
+func (s *ControllerService) provisionedSize(capacityBytes int64, capRange *csi.CapacityRange) (int32, error) {
+	sizeGB, err := s.sizeRounding.Size(s.sizeUnit.FromBytes(capacityBytes))
+	if err != nil {
+		return 0, err
+	}
+	if limit := capRange.GetLimitBytes(); limit > 0 && s.sizeUnit.ToBytes(sizeGB) > limit {
+		return 0, status.Errorf(codes.OutOfRange, "rounded size (%dGB) exceeds the capacity limit of %d bytes", sizeGB, limit)
+	}
+	return sizeGB, nil
+}
+
+// addSizeContext records the requested and provisioned capacity in a volume context, with
+// a note explaining the overhead when the volume is larger than requested
+func (s *ControllerService) addSizeContext(volumeContext map[string]string, requestedBytes, provisionedBytes int64) {
+	volumeContext[volumeContextRequestedBytes] = strconv.FormatInt(requestedBytes, 10)
+	volumeContext[volumeContextProvisionedBytes] = strconv.FormatInt(provisionedBytes, 10)
+	if provisionedBytes > requestedBytes {
+		volumeContext[volumeContextSizeNote] = fmt.Sprintf("provisioned %s for a %s request (%s overhead): Emma sizes are whole %s powers of 2, size rounding is %s",
+			formatBytes(provisionedBytes), formatBytes(requestedBytes), formatBytes(provisionedBytes-requestedBytes), s.sizeUnit, s.sizeRounding)
+	}
+}
+
+// formatBytes formats bytes as a Kubernetes quantity such as 512Gi
+func formatBytes(bytes int64) string {
+	return resource.NewQuantity(bytes, resource.BinarySI).String()
+}
+
+// SetVolumeSizePolicy sets the size of volumes requested without a capacity range and the
+// minimum size of new volumes, in bytes. A minimum of 0 disables it.
+func (s *ControllerService) SetVolumeSizePolicy(defaultBytes, minBytes int64) {
+	s.defaultVolumeSize = defaultBytes
+	s.minVolumeSize = minBytes
+}
+
+// requestedCapacity returns the bytes to provision for a capacity range: its required
+// bytes, else its limit, else the default size of the StorageClass or the driver. Smaller
+// sizes are raised to the minimum size, as long as the limit allows.
+func (s *ControllerService) requestedCapacity(capRange *csi.CapacityRange, params map[string]string) (int64, error) {
+	required := capRange.GetRequiredBytes()
+	limit := capRange.GetLimitBytes()
+	if required < 0 || limit < 0 {
+		return 0, status.Error(codes.InvalidArgument, "capacity range must not be negative")
+	}
+
+	capacity := required
+	if capacity == 0 {
+		capacity = limit
+	}
+	if capacity == 0 {
+		capacity = s.defaultVolumeSize
+		if value := params[paramDefaultSize]; value != "" {
+			size, err := ParseVolumeSize(value)
+			if err != nil {
+				return 0, status.Errorf(codes.InvalidArgument, "invalid %s parameter: %v", paramDefaultSize, err)
+			}
+			capacity = size
+		}
+	}
+
+	if capacity < s.minVolumeSize {
+		if limit > 0 && s.minVolumeSize > limit {
+			return 0, status.Errorf(codes.OutOfRange, "capacity limit of %d bytes is below the minimum volume size of %d bytes", limit, s.minVolumeSize)
+		}
+		capacity = s.minVolumeSize
+	}
+	return capacity, nil
+}
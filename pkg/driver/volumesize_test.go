@@ -0,0 +1,156 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestParseVolumeSize tests parsing sizes given as Kubernetes quantities
+func TestParseVolumeSize(t *testing.T) {
+	tests := []struct {
+		value       string
+		expected    int64
+		expectError bool
+	}{
+		{value: "1Gi", expected: gib},
+		{value: "500M", expected: 500 * 1000 * 1000},
+		{value: "1073741824", expected: gib},
+		{value: "0", expectError: true},
+		{value: "-1Gi", expectError: true},
+		{value: "lots", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			size, err := ParseVolumeSize(tt.value)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error, got %d", size)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if size != tt.expected {
+				t.Errorf("expected %d bytes, got %d", tt.expected, size)
+			}
+		})
+	}
+}
+
+// TestRequestedCapacity tests the default and minimum volume sizes
+func TestRequestedCapacity(t *testing.T) {
+	tests := []struct {
+		name         string
+		defaultBytes int64
+		minBytes     int64
+		capRange     *csi.CapacityRange
+		params       map[string]string
+		expected     int64
+		errorCode    codes.Code
+	}{
+		{name: "required bytes", defaultBytes: gib, capRange: &csi.CapacityRange{RequiredBytes: 10 * gib}, expected: 10 * gib},
+		{name: "limit only", defaultBytes: gib, capRange: &csi.CapacityRange{LimitBytes: 4 * gib}, expected: 4 * gib},
+		{name: "no capacity range uses the driver default", defaultBytes: 2 * gib, expected: 2 * gib},
+		{name: "empty capacity range uses the driver default", defaultBytes: gib, capRange: &csi.CapacityRange{}, expected: gib},
+		{name: "StorageClass default", defaultBytes: gib, params: map[string]string{paramDefaultSize: "8Gi"}, expected: 8 * gib},
+		{name: "StorageClass default is ignored for sized requests", defaultBytes: gib, capRange: &csi.CapacityRange{RequiredBytes: 2 * gib}, params: map[string]string{paramDefaultSize: "8Gi"}, expected: 2 * gib},
+		{name: "invalid StorageClass default", defaultBytes: gib, params: map[string]string{paramDefaultSize: "big"}, errorCode: codes.InvalidArgument},
+		{name: "raised to the minimum", defaultBytes: gib, minBytes: 4 * gib, capRange: &csi.CapacityRange{RequiredBytes: gib}, expected: 4 * gib},
+		{name: "default raised to the minimum", defaultBytes: gib, minBytes: 4 * gib, expected: 4 * gib},
+		{name: "minimum above the limit", defaultBytes: gib, minBytes: 4 * gib, capRange: &csi.CapacityRange{RequiredBytes: gib, LimitBytes: 2 * gib}, errorCode: codes.OutOfRange},
+		{name: "negative", defaultBytes: gib, capRange: &csi.CapacityRange{RequiredBytes: -1}, errorCode: codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewControllerService(&Driver{name: "csi.emma.ms"}, nil)
+			service.SetVolumeSizePolicy(tt.defaultBytes, tt.minBytes)
+
+			capacity, err := service.requestedCapacity(tt.capRange, tt.params)
+			if tt.errorCode != codes.OK {
+				if status.Code(err) != tt.errorCode {
+					t.Fatalf("expected %v, got %v", tt.errorCode, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if capacity != tt.expected {
+				t.Errorf("expected %d bytes, got %d", tt.expected, capacity)
+			}
+		})
+	}
+}
+
+// TestSizeRoundingPolicy tests turning requested sizes into provisioned sizes under each rounding policy
+func TestSizeRoundingPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    SizeRoundingPolicy
+		capRange  *csi.CapacityRange
+		expected  int32
+		errorCode codes.Code
+	}{
+		{name: "round-up", policy: SizeRoundingRoundUp, capRange: &csi.CapacityRange{RequiredBytes: 600 * gib}, expected: 1024},
+		{name: "round-up power of 2", policy: SizeRoundingRoundUp, capRange: &csi.CapacityRange{RequiredBytes: 512 * gib}, expected: 512},
+		{name: "round-up above the limit", policy: SizeRoundingRoundUp, capRange: &csi.CapacityRange{RequiredBytes: 600 * gib, LimitBytes: 800 * gib}, errorCode: codes.OutOfRange},
+		{name: "round-up within the limit", policy: SizeRoundingRoundUp, capRange: &csi.CapacityRange{RequiredBytes: 600 * gib, LimitBytes: 1024 * gib}, expected: 1024},
+		{name: "round-up above the maximum", policy: SizeRoundingRoundUp, capRange: &csi.CapacityRange{RequiredBytes: 3000 * gib}, errorCode: codes.OutOfRange},
+		{name: "strict power of 2", policy: SizeRoundingStrict, capRange: &csi.CapacityRange{RequiredBytes: 256 * gib}, expected: 256},
+		{name: "strict rejects other sizes", policy: SizeRoundingStrict, capRange: &csi.CapacityRange{RequiredBytes: 600 * gib}, errorCode: codes.InvalidArgument},
+		{name: "exact", policy: SizeRoundingExact, capRange: &csi.CapacityRange{RequiredBytes: 600 * gib, LimitBytes: 600 * gib}, expected: 600},
+		{name: "exact above the maximum", policy: SizeRoundingExact, capRange: &csi.CapacityRange{RequiredBytes: 1 << 62}, errorCode: codes.OutOfRange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewControllerService(&Driver{name: "csi.emma.ms"}, nil)
+			service.SetSizeRounding(tt.policy)
+
+			sizeGB, err := service.provisionedSize(tt.capRange.GetRequiredBytes(), tt.capRange)
+			if tt.errorCode != codes.OK {
+				if status.Code(err) != tt.errorCode {
+					t.Fatalf("expected %v, got %v", tt.errorCode, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if sizeGB != tt.expected {
+				t.Errorf("expected %dGB, got %dGB", tt.expected, sizeGB)
+			}
+		})
+	}
+
+	if _, err := ParseSizeRoundingPolicy("nearest"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
+
+// TestAddSizeContext tests that a size note is only added to volumes provisioned larger than requested
+func TestAddSizeContext(t *testing.T) {
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, nil)
+
+	volumeContext := map[string]string{}
+	service.addSizeContext(volumeContext, 300*gib, 512*gib)
+	expected := "provisioned 512Gi for a 300Gi request (212Gi overhead): Emma sizes are whole GiB powers of 2, size rounding is round-up"
+	if volumeContext[volumeContextSizeNote] != expected {
+		t.Errorf("expected note %q, got %q", expected, volumeContext[volumeContextSizeNote])
+	}
+
+	volumeContext = map[string]string{}
+	service.addSizeContext(volumeContext, 512*gib, 512*gib)
+	if _, ok := volumeContext[volumeContextSizeNote]; ok {
+		t.Errorf("expected no note for an exact size, got %v", volumeContext)
+	}
+	if volumeContext[volumeContextProvisionedBytes] != "549755813888" {
+		t.Errorf("expected provisioned bytes, got %v", volumeContext)
+	}
+}
@@ -0,0 +1,122 @@
+package driver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/emma-csi-driver/pkg/emma"
+)
+
+const (
+	// paramReclaimPolicy mirrors the StorageClass reclaimPolicy, which CSI does not pass to CreateVolume
+	paramReclaimPolicy = "reclaimPolicy"
+
+	// paramTTL is how long after creation external cleanup tooling may expire the volume
+	paramTTL = "ttl"
+
+	// TagReclaimPolicy records the PV reclaim policy on the Emma volume
+	TagReclaimPolicy = "csi.emma.ms/reclaim-policy"
+
+	// TagExpiresAt records when a volume with a TTL may be expired, in RFC 3339
+	TagExpiresAt = "csi.emma.ms/expires-at"
+
+	// TagPVCName, TagPVCNamespace and TagPVName record the Kubernetes objects that own the volume
+	TagPVCName      = "csi.emma.ms/pvc-name"
+	TagPVCNamespace = "csi.emma.ms/pvc-namespace"
+	TagPVName       = "csi.emma.ms/pv-name"
+
+	// TagClusterID records the ID of the cluster that provisioned the volume, the only
+	// cluster whose cleanup tooling may treat it as an orphan
+	TagClusterID = "csi.emma.ms/cluster-id"
+
+	// TagProtected marks a volume DeleteVolume must refuse to delete
+	TagProtected = "protected"
+)
+
+// volumeTags returns the Emma tags for a new volume from its StorageClass parameters
+func volumeTags(params map[string]string, now time.Time) ([]emma.VolumeTag, error) {
+	var tags []emma.VolumeTag
+
+	if policy := params[paramReclaimPolicy]; policy != "" {
+		switch strings.ToLower(policy) {
+		case "retain":
+			policy = "Retain"
+		case "delete":
+			policy = "Delete"
+		default:
+			return nil, fmt.Errorf("invalid %s %q: expected Retain or Delete", paramReclaimPolicy, policy)
+		}
+		tags = append(tags, emma.VolumeTag{Key: TagReclaimPolicy, Value: policy})
+	}
+
+	if value := params[paramTTL]; value != "" {
+		ttl, err := parseTTL(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", paramTTL, value, err)
+		}
+		tags = append(tags, emma.VolumeTag{Key: TagExpiresAt, Value: now.Add(ttl).UTC().Format(time.RFC3339)})
+	}
+
+	return tags, nil
+}
+
+// Parameters the external-provisioner adds to CreateVolume with --extra-create-metadata
+const (
+	paramPVCName      = "csi.storage.k8s.io/pvc/name"
+	paramPVCNamespace = "csi.storage.k8s.io/pvc/namespace"
+	paramPVName       = "csi.storage.k8s.io/pv/name"
+)
+
+// kubernetesMetadataTags returns tags naming the PVC and PV a new volume is provisioned for,
+// so the Emma volume of a claim can be found and its cost attributed
+func kubernetesMetadataTags(params map[string]string) []emma.VolumeTag {
+	var tags []emma.VolumeTag
+	for _, metadata := range []struct{ param, tag string }{
+		{paramPVCNamespace, TagPVCNamespace},
+		{paramPVCName, TagPVCName},
+		{paramPVName, TagPVName},
+	} {
+		if value := params[metadata.param]; value != "" {
+			tags = append(tags, emma.VolumeTag{Key: metadata.tag, Value: value})
+		}
+	}
+	return tags
+}
+
+// parseTTL parses a Go duration or a whole number of days such as "30d"
+func parseTTL(value string) (time.Duration, error) {
+	var ttl time.Duration
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("expected a duration such as 720h or 30d")
+		}
+		ttl = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if ttl, err = time.ParseDuration(value); err != nil {
+			return 0, fmt.Errorf("expected a duration such as 720h or 30d")
+		}
+	}
+	if ttl <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return ttl, nil
+}
+
+// checkNotProtected refuses to delete a volume carrying the protected tag, unless its value is false
+func checkNotProtected(volume *emma.VolumeResponse) error {
+	value, ok := volume.Tag(TagProtected)
+	if !ok {
+		return nil
+	}
+	if protected, err := strconv.ParseBool(value); err == nil && !protected {
+		return nil
+	}
+	return status.Errorf(codes.FailedPrecondition, "volume %d carries the %q tag and will not be deleted; remove the tag in Emma to allow deletion", volume.ID, TagProtected)
+}
@@ -0,0 +1,186 @@
+package driver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/emma-csi-driver/internal/fakeemma"
+	"github.com/emma-csi-driver/pkg/emma"
+)
+
+func TestVolumeTags(t *testing.T) {
+	now := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		params      map[string]string
+		expected    []emma.VolumeTag
+		expectError bool
+	}{
+		{name: "no tag parameters", params: map[string]string{paramType: "ssd"}},
+		{
+			name:     "reclaim policy is normalized",
+			params:   map[string]string{paramReclaimPolicy: "retain"},
+			expected: []emma.VolumeTag{{Key: TagReclaimPolicy, Value: "Retain"}},
+		},
+		{
+			name:   "reclaim policy and TTL in days",
+			params: map[string]string{paramReclaimPolicy: "Delete", paramTTL: "30d"},
+			expected: []emma.VolumeTag{
+				{Key: TagReclaimPolicy, Value: "Delete"},
+				{Key: TagExpiresAt, Value: "2025-02-14T10:00:00Z"},
+			},
+		},
+		{
+			name:     "TTL as Go duration",
+			params:   map[string]string{paramTTL: "36h"},
+			expected: []emma.VolumeTag{{Key: TagExpiresAt, Value: "2025-01-16T22:00:00Z"}},
+		},
+		{name: "invalid reclaim policy", params: map[string]string{paramReclaimPolicy: "Recycle"}, expectError: true},
+		{name: "invalid TTL", params: map[string]string{paramTTL: "soon"}, expectError: true},
+		{name: "zero TTL", params: map[string]string{paramTTL: "0d"}, expectError: true},
+		{name: "negative TTL", params: map[string]string{paramTTL: "-1h"}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags, err := volumeTags(tt.params, now)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error, got tags %v", tags)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(tags, tt.expected) {
+				t.Errorf("expected tags %v, got %v", tt.expected, tags)
+			}
+		})
+	}
+}
+
+// TestKubernetesMetadataTags tests tagging volumes with the provisioner's PVC and PV metadata
+func TestKubernetesMetadataTags(t *testing.T) {
+	tags := kubernetesMetadataTags(map[string]string{
+		paramType:         "ssd",
+		paramPVCName:      "data-postgres-0",
+		paramPVCNamespace: "db",
+		paramPVName:       "pvc-1234",
+	})
+	expected := []emma.VolumeTag{
+		{Key: TagPVCNamespace, Value: "db"},
+		{Key: TagPVCName, Value: "data-postgres-0"},
+		{Key: TagPVName, Value: "pvc-1234"},
+	}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("expected tags %v, got %v", expected, tags)
+	}
+
+	// Without --extra-create-metadata the provisioner passes no metadata
+	if tags := kubernetesMetadataTags(map[string]string{paramType: "ssd"}); tags != nil {
+		t.Errorf("expected no tags, got %v", tags)
+	}
+}
+
+func TestCheckNotProtected(t *testing.T) {
+	tests := []struct {
+		name      string
+		tags      []emma.VolumeTag
+		protected bool
+	}{
+		{name: "no tags"},
+		{name: "other tags", tags: []emma.VolumeTag{{Key: TagReclaimPolicy, Value: "Retain"}}},
+		{name: "protected without value", tags: []emma.VolumeTag{{Key: "protected"}}, protected: true},
+		{name: "protected true", tags: []emma.VolumeTag{{Key: "Protected", Value: "true"}}, protected: true},
+		{name: "protected false", tags: []emma.VolumeTag{{Key: "protected", Value: "false"}}},
+		{name: "protected with other value", tags: []emma.VolumeTag{{Key: "protected", Value: "prod-db"}}, protected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkNotProtected(&emma.VolumeResponse{ID: 5, Tags: tt.tags})
+			if tt.protected && status.Code(err) != codes.FailedPrecondition {
+				t.Errorf("expected FailedPrecondition, got %v", err)
+			}
+			if !tt.protected && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// TestControllerVolumeTagsWithFake tests tagging on create and the protected tag on delete
+func TestControllerVolumeTagsWithFake(t *testing.T) {
+	fakeAPI := fakeemma.New()
+	service := NewControllerService(&Driver{name: "csi.emma.ms"}, fakeAPI)
+	service.SetDeleteDetachGracePeriod(0)
+
+	req := &csi.CreateVolumeRequest{
+		Name:               "pvc-tagged",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: gib},
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+		Parameters:         map[string]string{paramDataCenterID: "dc-1", paramReclaimPolicy: "Retain", paramTTL: "7d"},
+	}
+
+	// Tag parameters are refused while tagging is disabled
+	if _, err := service.CreateVolume(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument with tagging disabled, got %v", err)
+	}
+
+	// Nothing is tagged while tagging is disabled, not even the owner and cluster
+	service.SetClusterID("prod")
+	untagged, err := service.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               "pvc-untagged",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: gib},
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+		Parameters:         map[string]string{paramDataCenterID: "dc-1", paramPVCNamespace: "db", paramPVCName: "data"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	untaggedID, _ := parseVolumeID(untagged.GetVolume().GetVolumeId())
+	if tags := fakeAPI.Volume(int32(untaggedID)).Tags; len(tags) != 0 {
+		t.Errorf("expected no tags with tagging disabled, got %v", tags)
+	}
+	fakeAPI.RemoveVolume(int32(untaggedID))
+
+	service.SetVolumeTagging(true)
+	resp, err := service.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	volumeID, _ := parseVolumeID(resp.GetVolume().GetVolumeId())
+	volume := fakeAPI.Volume(int32(volumeID))
+	if policy, _ := volume.Tag(TagReclaimPolicy); policy != "Retain" {
+		t.Errorf("expected reclaim policy tag Retain, got %v", volume.Tags)
+	}
+	if _, ok := volume.Tag(TagExpiresAt); !ok {
+		t.Errorf("expected an expiry tag, got %v", volume.Tags)
+	}
+	if clusterID, _ := volume.Tag(TagClusterID); clusterID != "prod" {
+		t.Errorf("expected cluster ID tag prod, got %v", volume.Tags)
+	}
+
+	// A volume tagged protected in Emma is neither detached nor deleted
+	vmID := int32(7)
+	protected := *volume
+	protected.Tags = append(volume.Tags, emma.VolumeTag{Key: TagProtected, Value: "true"})
+	protected.AttachedToID = &vmID
+	fakeAPI.AddVolume(protected)
+
+	_, err = service.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: resp.GetVolume().GetVolumeId()})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition for a protected volume, got %v", err)
+	}
+	if expected := []string{"create pvc-untagged", "create pvc-tagged"}; !reflect.DeepEqual(fakeAPI.Calls(), expected) {
+		t.Errorf("expected calls %v, got %v", expected, fakeAPI.Calls())
+	}
+}
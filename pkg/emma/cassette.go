@@ -0,0 +1,227 @@
+package emma
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Cassette modes
+const (
+	// CassetteModeRecord sends requests to the Emma API and records them in the cassette
+	CassetteModeRecord = "record"
+
+	// CassetteModeReplay answers requests from the cassette without network access
+	CassetteModeReplay = "replay"
+)
+
+// redacted replaces credentials and tokens in recorded bodies
+const redacted = "REDACTED"
+
+// sensitiveFields are JSON fields whose values are never written to a cassette
+var sensitiveFields = []string{"clientId", "clientSecret", "accessToken", "refreshToken"}
+
+// Cassette is a recording of Emma API interactions
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is one recorded request and its response
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest identifies a recorded request. The URL holds the path and query only, so
+// a cassette replays against any host serving the same base path.
+type RecordedRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Body   string `json:"body,omitempty"`
+}
+
+// RecordedResponse is a recorded response
+type RecordedResponse struct {
+	StatusCode int               `json:"statusCode"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       string            `json:"body,omitempty"`
+}
+
+// CassetteTransport records Emma API interactions to a cassette file or replays them, so
+// behavior captured once against the real API can be tested without credentials.
+// Replay answers each request with the first unused interaction of the same method and
+// URL, so repeated polls return the recorded sequence of states.
+type CassetteTransport struct {
+	path string
+	mode string
+	next http.RoundTripper
+
+	mutex    sync.Mutex
+	cassette Cassette
+	used     []bool
+}
+
+// NewCassetteTransport creates a transport recording to or replaying from the cassette at
+// path. Recording sends requests through next, or http.DefaultTransport when nil, and
+// starts a new cassette.
+func NewCassetteTransport(path, mode string, next http.RoundTripper) (*CassetteTransport, error) {
+	t := &CassetteTransport{path: path, mode: mode, next: next}
+	switch mode {
+	case CassetteModeRecord:
+		if t.next == nil {
+			t.next = http.DefaultTransport
+		}
+	case CassetteModeReplay:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cassette: %w", err)
+		}
+		if err := json.Unmarshal(data, &t.cassette); err != nil {
+			return nil, fmt.Errorf("failed to parse cassette %s: %w", path, err)
+		}
+		t.used = make([]bool, len(t.cassette.Interactions))
+	default:
+		return nil, fmt.Errorf("unsupported cassette mode %q (supported: %s, %s)", mode, CassetteModeRecord, CassetteModeReplay)
+	}
+	return t, nil
+}
+
+// RoundTrip records or replays a request
+func (t *CassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(&req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	recorded := RecordedRequest{Method: req.Method, URL: req.URL.RequestURI(), Body: redactBody(body)}
+
+	if t.mode == CassetteModeReplay {
+		return t.replay(req, recorded)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := readBody(&resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	headers := make(map[string]string)
+	for name := range resp.Header {
+		headers[name] = resp.Header.Get(name)
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.cassette.Interactions = append(t.cassette.Interactions, Interaction{
+		Request:  recorded,
+		Response: RecordedResponse{StatusCode: resp.StatusCode, Headers: headers, Body: redactBody(respBody)},
+	})
+	if err := t.save(); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Unused returns the recorded interactions that were not replayed, which indicate the
+// client no longer makes a request it used to
+func (t *CassetteTransport) Unused() []RecordedRequest {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	var unused []RecordedRequest
+	for i, interaction := range t.cassette.Interactions {
+		if !t.used[i] {
+			unused = append(unused, interaction.Request)
+		}
+	}
+	return unused
+}
+
+// replay answers a request from the cassette
+func (t *CassetteTransport) replay(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for i, interaction := range t.cassette.Interactions {
+		if t.used[i] || interaction.Request.Method != recorded.Method || interaction.Request.URL != recorded.URL {
+			continue
+		}
+		t.used[i] = true
+
+		header := make(http.Header)
+		for name, value := range interaction.Response.Headers {
+			header.Set(name, value)
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", interaction.Response.StatusCode, http.StatusText(interaction.Response.StatusCode)),
+			StatusCode:    interaction.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(strings.NewReader(interaction.Response.Body)),
+			ContentLength: int64(len(interaction.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("no recorded interaction left for %s %s in cassette %s", recorded.Method, recorded.URL, t.path)
+}
+
+// save writes the cassette through a rename, so an interrupted recording keeps the
+// interactions recorded so far. The caller holds the mutex.
+func (t *CassetteTransport) save() error {
+	data, err := json.MarshalIndent(t.cassette, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode cassette: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(t.path), 0755); err != nil {
+		return fmt.Errorf("failed to create cassette directory: %w", err)
+	}
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return os.Rename(tmp, t.path)
+}
+
+// readBody reads and replaces a request or response body, so it can still be sent or returned
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+	data, err := io.ReadAll(*body)
+	(*body).Close()
+	if err != nil {
+		return nil, err
+	}
+	*body = io.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
+
+// redactBody replaces the values of sensitive fields in a JSON object body
+func redactBody(body []byte) string {
+	var object map[string]interface{}
+	if err := json.Unmarshal(body, &object); err != nil {
+		return string(body)
+	}
+	changed := false
+	for _, field := range sensitiveFields {
+		if _, ok := object[field]; ok {
+			object[field] = redacted
+			changed = true
+		}
+	}
+	if !changed {
+		return string(body)
+	}
+	data, err := json.Marshal(object)
+	if err != nil {
+		return string(body)
+	}
+	return string(data)
+}
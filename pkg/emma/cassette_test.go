@@ -0,0 +1,96 @@
+package emma
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestCassetteRecordReplay tests that interactions recorded against a server replay without it
+func TestCassetteRecordReplay(t *testing.T) {
+	var polls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/issue-token":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"accessToken":"secret-access","refreshToken":"secret-refresh","expiresIn":600}`))
+		case "/v1/volumes/42":
+			status := "BUSY"
+			if polls.Add(1) > 1 {
+				status = "AVAILABLE"
+			}
+			w.Header().Set("X-Request-Id", "req-"+status)
+			_ = json.NewEncoder(w).Encode(VolumeResponse{ID: 42, Status: status})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "cassettes", "volume.json")
+	recorder, err := NewCassetteTransport(path, CassetteModeRecord, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client, err := NewClientWithTransport(server.URL, "client-id", "client-secret", recorder)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, expected := range []string{"BUSY", "AVAILABLE"} {
+		volume, err := client.GetVolume(context.Background(), 42)
+		if err != nil || volume.Status != expected {
+			t.Fatalf("expected recorded status %s, got %+v, %v", expected, volume, err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"client-secret", "client-id", "secret-access", "secret-refresh", "Bearer"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("expected %q to be redacted from the cassette", secret)
+		}
+	}
+
+	// Replay against a base URL nothing listens on
+	replayer, err := NewCassetteTransport(path, CassetteModeReplay, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client, err = NewClientWithTransport("http://127.0.0.1:1", "", "", replayer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// A used up cassette is not a transient failure
+	client.SetRetryPolicy(RetryPolicy{})
+	for _, expected := range []string{"BUSY", "AVAILABLE"} {
+		volume, err := client.GetVolume(context.Background(), 42)
+		if err != nil || volume.Status != expected {
+			t.Fatalf("expected replayed status %s, got %+v, %v", expected, volume, err)
+		}
+	}
+	if unused := replayer.Unused(); len(unused) != 0 {
+		t.Errorf("expected every interaction to be replayed, got %v unused", unused)
+	}
+	if _, err := client.GetVolume(context.Background(), 42); err == nil {
+		t.Error("expected an error once the recorded interactions are used up")
+	}
+}
+
+// TestNewCassetteTransportErrors tests that invalid modes and missing cassettes are rejected
+func TestNewCassetteTransportErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewCassetteTransport(filepath.Join(dir, "missing.json"), CassetteModeReplay, nil); err == nil {
+		t.Error("expected an error replaying a missing cassette")
+	}
+	if _, err := NewCassetteTransport(filepath.Join(dir, "cassette.json"), "rewind", nil); err == nil {
+		t.Error("expected an error for an unsupported mode")
+	}
+}
@@ -4,23 +4,52 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	emma "github.com/emma-community/emma-go-sdk"
-	"k8s.io/klog/v2"
+	"k8s.io/client-go/util/flowcontrol"
 
 	"github.com/emma-csi-driver/pkg/logging"
 	"github.com/emma-csi-driver/pkg/metrics"
 )
 
-// Client wraps the Emma SDK client with CSI-specific functionality
+var (
+	// ErrVolumeNotFound is returned when a volume does not exist (HTTP 404)
+	ErrVolumeNotFound = errors.New("volume not found")
+
+	// ErrVMNotFound is returned when a VM action targets a VM that no longer exists
+	ErrVMNotFound = errors.New("VM not found")
+
+	// ErrUnauthorized is returned when the Emma API rejects the access token (HTTP 401)
+	// even after obtaining a fresh one
+	ErrUnauthorized = errors.New("unauthorized")
+
+	// ErrPermissionDenied is returned when the credentials lack permission for an operation (HTTP 403).
+	// This is permanent for the given credentials and must not trigger a token refresh.
+	ErrPermissionDenied = errors.New("permission denied")
+
+	// ErrDataCenterUnavailable is returned when Emma reports that a datacenter is out of
+	// capacity for a volume, so provisioning may succeed in another datacenter
+	ErrDataCenterUnavailable = errors.New("datacenter unavailable")
+
+	// ErrVolumeFailed is returned by the wait helpers when Emma reports the volume as FAILED
+	ErrVolumeFailed = errors.New("volume failed")
+)
+
+// Client wraps the Emma SDK client with CSI-specific functionality.
+// It is safe for concurrent use: the SDK APIClient and its configuration are only read
+// after construction, and the access token is attached to each SDK call's context
+// rather than stored in shared state.
 type Client struct {
 	apiClient    *emma.APIClient
-	ctx          context.Context
 	baseURL      string
 	httpClient   *http.Client
 	accessToken  string
@@ -30,14 +59,48 @@ type Client struct {
 	clientID     string
 	clientSecret string
 	logger       *logging.Logger
+
+	// endpoints routes volume requests to regional API base URLs, nil when not configured
+	endpoints *regionalEndpoints
+
+	// waiters bounds concurrent volume state waits, nil when unlimited
+	waiters chan struct{}
+
+	// account labels the client's metrics when it is one of several in a ClientPool
+	account string
+
+	// limiter bounds the client's raw API requests, nil when unlimited
+	limiter flowcontrol.RateLimiter
+
+	// retry retries the client's SDK and raw requests, nil when the client has none
+	retry *RetryTransport
+
+	// vmActionRetry is the retry policy of VM attach and detach actions
+	vmActionRetry RetryPolicy
+
+	// dataCenters caches datacenter lookups, nil when disabled
+	dataCenters *dataCenterCache
+
+	// volumeConfigs caches the volume configurations, nil when the datacenter cache is disabled
+	volumeConfigs *volumeConfigsCache
+
+	// pollInterval is the delay between volume state polls, zero for defaultPollInterval
+	pollInterval time.Duration
 }
 
 // VolumeCreateRequest represents a volume creation request
 type VolumeCreateRequest struct {
-	Name         string `json:"name"`
-	VolumeGb     int32  `json:"volumeGb"`
-	VolumeType   string `json:"volumeType"`
-	DataCenterID string `json:"dataCenterId"`
+	Name         string      `json:"name"`
+	VolumeGb     int32       `json:"volumeGb"`
+	VolumeType   string      `json:"volumeType"`
+	DataCenterID string      `json:"dataCenterId"`
+	Tags         []VolumeTag `json:"tags,omitempty"`
+}
+
+// VolumeTag is a key/value tag on a volume, shaped like Emma's resource tags
+type VolumeTag struct {
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
 }
 
 // VolumeResponse represents a volume from the API
@@ -50,6 +113,38 @@ type VolumeResponse struct {
 	AttachedToID *int32 `json:"attachedToId,omitempty"`
 	DataCenterID string `json:"dataCenterId"`
 	CreatedAt    string `json:"createdAt"`
+
+	// Performance characteristics, only present when Emma reports them for the volume type
+	IOPS           *int32 `json:"iops,omitempty"`
+	ThroughputMBps *int32 `json:"throughputMbps,omitempty"`
+
+	Tags []VolumeTag `json:"tags,omitempty"`
+
+	// ProviderVolumeID is the volume's identifier at the underlying cloud provider, such
+	// as an EBS volume ID or a GCP disk name, when Emma reports it
+	ProviderVolumeID string `json:"providerVolumeId,omitempty"`
+
+	// Attachment describes how an attached volume appears on its VM, when Emma reports it
+	Attachment *VolumeAttachment `json:"attachment,omitempty"`
+}
+
+// VolumeAttachment is the device information Emma reports for an attached volume
+type VolumeAttachment struct {
+	// Serial is the disk serial number the VM sees
+	Serial string `json:"serial,omitempty"`
+
+	// LUN is the SCSI logical unit number of the disk, reported for Azure VMs
+	LUN *int32 `json:"lun,omitempty"`
+}
+
+// Tag returns the value of a volume tag, matching the key case-insensitively
+func (v *VolumeResponse) Tag(key string) (string, bool) {
+	for _, tag := range v.Tags {
+		if strings.EqualFold(tag.Key, key) {
+			return tag.Value, true
+		}
+	}
+	return "", false
 }
 
 // VMActionRequest represents a VM action request
@@ -60,8 +155,27 @@ type VMActionRequest struct {
 
 // NewClient creates a new Emma API client using the SDK
 func NewClient(baseURL, clientID, clientSecret string) (*Client, error) {
+	return NewClientWithTLSOptions(baseURL, clientID, clientSecret, TLSOptions{})
+}
+
+// NewClientWithTLSOptions creates a new Emma API client whose SDK and raw HTTP
+// requests are both secured with the given TLS options
+func NewClientWithTLSOptions(baseURL, clientID, clientSecret string, tlsOpts TLSOptions) (*Client, error) {
+	return NewClientWithTransport(baseURL, clientID, clientSecret, newTransport(tlsOpts))
+}
+
+// NewClientWithTransport creates a new Emma API client sending its SDK and raw HTTP
+// requests through transport, such as a CassetteTransport in tests. Requests are retried
+// under DefaultRetryPolicy, VM actions under VMActionRetryPolicy.
+func NewClientWithTransport(baseURL, clientID, clientSecret string, transport http.RoundTripper) (*Client, error) {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	retry := NewRetryTransport(&headerTransport{next: transport}, DefaultRetryPolicy())
+
 	// Issue token
 	config := emma.NewConfiguration()
+	config.HTTPClient = &http.Client{Transport: retry}
 	if baseURL != "" {
 		config.Servers = emma.ServerConfigurations{
 			{
@@ -79,9 +193,6 @@ func NewClient(baseURL, clientID, clientSecret string) (*Client, error) {
 		return nil, fmt.Errorf("failed to issue token: %w", err)
 	}
 
-	// Create authenticated context
-	ctx := context.WithValue(context.Background(), emma.ContextAccessToken, tokenResp.GetAccessToken())
-
 	// Determine base URL
 	if baseURL == "" {
 		baseURL = "https://api.emma.ms/external"
@@ -91,19 +202,36 @@ func NewClient(baseURL, clientID, clientSecret string) (*Client, error) {
 	logger.Info("Emma API client initialized successfully")
 
 	return &Client{
-		apiClient:    apiClient,
-		ctx:          ctx,
-		baseURL:      baseURL,
-		httpClient:   &http.Client{Timeout: 30 * time.Second},
-		accessToken:  tokenResp.GetAccessToken(),
-		refreshToken: tokenResp.GetRefreshToken(),
-		tokenExpiry:  time.Now().Add(time.Duration(tokenResp.GetExpiresIn()) * time.Second),
-		clientID:     clientID,
-		clientSecret: clientSecret,
-		logger:       logger,
+		apiClient:     apiClient,
+		baseURL:       baseURL,
+		httpClient:    &http.Client{Transport: retry},
+		accessToken:   tokenResp.GetAccessToken(),
+		refreshToken:  tokenResp.GetRefreshToken(),
+		tokenExpiry:   time.Now().Add(time.Duration(tokenResp.GetExpiresIn()) * time.Second),
+		clientID:      clientID,
+		clientSecret:  clientSecret,
+		logger:        logger,
+		retry:         retry,
+		vmActionRetry: VMActionRetryPolicy(),
+		dataCenters:   newDataCenterCache(DefaultDataCenterCacheTTL),
+		volumeConfigs: &volumeConfigsCache{},
 	}, nil
 }
 
+// SetRetryPolicy replaces the retry policy of the client's requests other than VM actions.
+// It must be called before the client is used.
+func (c *Client) SetRetryPolicy(policy RetryPolicy) {
+	if c.retry != nil {
+		c.retry.SetPolicy(policy)
+	}
+}
+
+// SetVMActionRetryPolicy replaces the retry policy of VM attach and detach actions. It
+// must be called before the client is used.
+func (c *Client) SetVMActionRetryPolicy(policy RetryPolicy) {
+	c.vmActionRetry = policy
+}
+
 // getAccessToken returns a valid access token, refreshing if necessary
 func (c *Client) getAccessToken(ctx context.Context) (string, error) {
 	c.tokenMutex.RLock()
@@ -125,7 +253,8 @@ func (c *Client) getAccessToken(ctx context.Context) (string, error) {
 		return c.accessToken, nil
 	}
 
-	klog.Infof("Access token expired or expiring soon (expiry: %v), refreshing...", c.tokenExpiry)
+	logger := c.logger.WithContext(ctx)
+	logging.Klog(ctx).Infof("Access token expired or expiring soon (expiry: %v), refreshing...", c.tokenExpiry)
 
 	// Try refresh token first
 	if c.refreshToken != "" {
@@ -135,49 +264,163 @@ func (c *Client) getAccessToken(ctx context.Context) (string, error) {
 			c.accessToken = tokenResp.GetAccessToken()
 			c.refreshToken = tokenResp.GetRefreshToken()
 			c.tokenExpiry = time.Now().Add(time.Duration(tokenResp.GetExpiresIn()) * time.Second)
-			c.ctx = context.WithValue(context.Background(), emma.ContextAccessToken, c.accessToken)
-			klog.Infof("Access token refreshed successfully (new expiry: %v)", c.tokenExpiry)
-			c.logger.Info("Access token refreshed successfully")
+			logging.Klog(ctx).Infof("Access token refreshed successfully (new expiry: %v)", c.tokenExpiry)
+			logger.Info("Access token refreshed successfully")
 			return c.accessToken, nil
 		}
-		klog.Warningf("Failed to refresh token, will re-authenticate: %v", err)
-		c.logger.Warn("Token refresh failed, re-authenticating", map[string]interface{}{"error": err.Error()})
+		logging.Klog(ctx).Warningf("Failed to refresh token, will re-authenticate: %v", err)
+		logger.Warn("Token refresh failed, re-authenticating", map[string]interface{}{"error": err.Error()})
 	}
 
 	// If refresh fails, re-authenticate with credentials
-	klog.Info("Re-authenticating with credentials")
-	c.logger.Info("Re-authenticating with Emma API")
+	logging.Klog(ctx).Info("Re-authenticating with credentials")
+	logger.Info("Re-authenticating with Emma API")
 	credentials := emma.NewCredentials(c.clientID, c.clientSecret)
 	tokenResp, _, err := c.apiClient.AuthenticationAPI.IssueToken(context.Background()).Credentials(*credentials).Execute()
 	if err != nil {
-		c.logger.Error("Re-authentication failed", err)
+		logger.Error("Re-authentication failed", err)
 		return "", fmt.Errorf("failed to issue new token: %w", err)
 	}
 
 	c.accessToken = tokenResp.GetAccessToken()
 	c.refreshToken = tokenResp.GetRefreshToken()
 	c.tokenExpiry = time.Now().Add(time.Duration(tokenResp.GetExpiresIn()) * time.Second)
-	c.ctx = context.WithValue(context.Background(), emma.ContextAccessToken, c.accessToken)
-	klog.Infof("Re-authenticated successfully (new expiry: %v)", c.tokenExpiry)
-	c.logger.Info("Re-authenticated successfully")
+	logging.Klog(ctx).Infof("Re-authenticated successfully (new expiry: %v)", c.tokenExpiry)
+	logger.Info("Re-authenticated successfully")
 
 	return c.accessToken, nil
 }
 
-// doRequest executes an authenticated HTTP request for endpoints not in SDK
+// CheckToken makes sure the client holds a valid access token, refreshing or reissuing it
+// when it expires soon
+func (c *Client) CheckToken(ctx context.Context) error {
+	_, err := c.getAccessToken(ctx)
+	return err
+}
+
+// authContext returns ctx carrying a valid access token for SDK calls
+func (c *Client) authContext(ctx context.Context) (context.Context, error) {
+	token, err := c.getAccessToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access token: %w", err)
+	}
+	return context.WithValue(ctx, emma.ContextAccessToken, token), nil
+}
+
+// doRequest executes an authenticated HTTP request for endpoints not in SDK.
+// A 401 response invalidates the cached token and the request is retried once with a
+// fresh token; a 403 response is returned as ErrPermissionDenied without any retry.
 func (c *Client) doRequest(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
-	timer := metrics.NewAPIRequestTimer(method, path)
+	return c.doRegionalRequest(ctx, "", method, path, body)
+}
 
-	var bodyReader io.Reader
+// doRegionalRequest is doRequest routed to the regional endpoint of a datacenter when one
+// is configured. If the regional endpoint fails it is bypassed for a while, and idempotent
+// requests are resent to the global endpoint straight away.
+func (c *Client) doRegionalRequest(ctx context.Context, dataCenterID, method, path string, body interface{}) (*http.Response, error) {
+	logger := c.logger.WithContext(ctx)
+
+	var bodyBytes []byte
 	if body != nil {
-		bodyBytes, err := json.Marshal(body)
+		var err error
+		bodyBytes, err = json.Marshal(body)
 		if err != nil {
 			return nil, fmt.Errorf("failed to marshal request body: %w", err)
 		}
+	}
+
+	baseURL := c.baseURL
+	if regionalURL := c.endpoints.baseURL(dataCenterID); regionalURL != "" {
+		baseURL = regionalURL
+	}
+
+	regionalCtx := ctx
+	if baseURL != c.baseURL && idempotentMethod(method) {
+		regionalCtx = c.withoutRegionalRetries(ctx)
+	}
+	resp, err := c.sendRequest(regionalCtx, baseURL, method, path, bodyBytes)
+	if baseURL != c.baseURL && regionalFailure(ctx, resp, err) {
+		c.endpoints.markUnhealthy(dataCenterID)
+		fields := map[string]interface{}{
+			"datacenter": dataCenterID,
+			"endpoint":   baseURL,
+			"method":     method,
+			"path":       path,
+		}
+		if err != nil {
+			fields["error"] = err.Error()
+		} else {
+			fields["status"] = resp.StatusCode
+		}
+		logger.Warn("Regional Emma API endpoint failed, falling back to the global endpoint", fields)
+
+		if idempotentMethod(method) {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			baseURL = c.baseURL
+			resp, err = c.sendRequest(ctx, baseURL, method, path, bodyBytes)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// If we get 401 Unauthorized, the token might have expired
+	// Drop it and retry once with a fresh token
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		metrics.RecordAuthFailure("unauthorized")
+		logger.Warn("Received 401 Unauthorized, refreshing token and retrying", map[string]interface{}{
+			"method": method,
+			"path":   path,
+		})
+		c.invalidateAccessToken()
+
+		resp, err = c.sendRequest(ctx, baseURL, method, path, bodyBytes)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized {
+			respBody, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, fmt.Errorf("%w: %s %s: %w", ErrUnauthorized, method, path, newAPIError("", resp, respBody))
+		}
+	}
+
+	// 403 Forbidden means the credentials are valid but lack the required scope
+	if resp.StatusCode == http.StatusForbidden {
+		respBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		metrics.RecordAuthFailure("forbidden")
+		logger.Error("Received 403 Forbidden, credentials lack permission", nil, map[string]interface{}{
+			"method":    method,
+			"path":      path,
+			"requestId": RequestID(resp),
+		})
+		return nil, fmt.Errorf("%w: %s %s: %w", ErrPermissionDenied, method, path, newAPIError("", resp, respBody))
+	}
+
+	return resp, nil
+}
+
+// sendRequest sends a single authenticated HTTP request to an API base URL
+func (c *Client) sendRequest(ctx context.Context, baseURL, method, path string, bodyBytes []byte) (*http.Response, error) {
+	// Query parameters are not part of the metrics endpoint label
+	if c.limiter != nil {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return nil, fmt.Errorf("rate limit wait: %w", err)
+		}
+	}
+
+	timer := metrics.NewAPIRequestTimer(c.accountLabel(), method, strings.SplitN(path, "?", 2)[0])
+
+	var bodyReader io.Reader
+	if bodyBytes != nil {
 		bodyReader = bytes.NewReader(bodyBytes)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bodyReader)
+	req, err := http.NewRequestWithContext(ctx, method, baseURL+path, bodyReader)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -189,48 +432,90 @@ func (c *Client) doRequest(ctx context.Context, method, path string, body interf
 	}
 
 	// Set headers
+	clientRequestID := newClientRequestID()
 	req.Header.Set("Authorization", "Bearer "+token)
-	if body != nil {
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set(ClientRequestIDHeader, clientRequestID)
+	if csiRequestID := logging.CSIRequestIDFromContext(ctx); csiRequestID != "" {
+		req.Header.Set(CSIRequestIDHeader, csiRequestID)
+	}
+	if bodyBytes != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
 
-	c.logger.Debug("Emma API request", map[string]interface{}{
-		"method": method,
-		"path":   path,
+	logger := c.logger.WithContext(ctx)
+	logger.Debug("Emma API request", map[string]interface{}{
+		"method":          method,
+		"path":            path,
+		"clientRequestId": clientRequestID,
 	})
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		timer.Observe(0)
-		c.logger.Error("Emma API request failed", err, map[string]interface{}{
-			"method": method,
-			"path":   path,
+		logger.Error("Emma API request failed", err, map[string]interface{}{
+			"method":          method,
+			"path":            path,
+			"clientRequestId": clientRequestID,
 		})
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
 
 	timer.Observe(resp.StatusCode)
-	c.logger.Debug("Emma API response", map[string]interface{}{
-		"method": method,
-		"path":   path,
-		"status": resp.StatusCode,
-	})
-
-	// If we get 401 Unauthorized, the token might have expired
-	// Return the response so caller can handle it
-	if resp.StatusCode == http.StatusUnauthorized {
-		c.logger.Warn("Received 401 Unauthorized, token may have expired", map[string]interface{}{
-			"method": method,
-			"path":   path,
-		})
+	fields := map[string]interface{}{
+		"method":          method,
+		"path":            path,
+		"status":          resp.StatusCode,
+		"clientRequestId": clientRequestID,
+	}
+	if requestID := RequestID(resp); requestID != "" {
+		fields["requestId"] = requestID
+	}
+	// Missing volumes are routine during deletes, other error statuses are worth a warning
+	if resp.StatusCode >= http.StatusBadRequest && resp.StatusCode != http.StatusNotFound {
+		logger.Warn("Emma API request returned an error status", fields)
+	} else {
+		logger.Debug("Emma API response", fields)
 	}
 
 	return resp, nil
 }
 
+// invalidateAccessToken forces the next getAccessToken call to obtain a new token
+func (c *Client) invalidateAccessToken() {
+	c.tokenMutex.Lock()
+	defer c.tokenMutex.Unlock()
+	c.tokenExpiry = time.Time{}
+}
+
+// sdkError wraps an SDK call error, classifying authentication failures and annotating
+// it with the Emma request ID
+func sdkError(httpResp *http.Response, err error) error {
+	if httpResp == nil || httpResp.StatusCode < http.StatusBadRequest {
+		return withRequestID(err, httpResp)
+	}
+
+	body := []byte(err.Error())
+	var bodyErr interface{ Body() []byte }
+	if errors.As(err, &bodyErr) && len(bodyErr.Body()) > 0 {
+		body = bodyErr.Body()
+	}
+	apiErr := newAPIError("", httpResp, body)
+
+	switch httpResp.StatusCode {
+	case http.StatusUnauthorized:
+		metrics.RecordAuthFailure("unauthorized")
+		return fmt.Errorf("%w: %w", ErrUnauthorized, apiErr)
+	case http.StatusForbidden:
+		metrics.RecordAuthFailure("forbidden")
+		return fmt.Errorf("%w: %w", ErrPermissionDenied, apiErr)
+	}
+	return apiErr
+}
+
 // CreateVolume creates a new volume using direct API call
-func (c *Client) CreateVolume(ctx context.Context, name string, sizeGB int32, volumeType string, dataCenterID string) (*VolumeResponse, error) {
-	klog.V(4).Infof("Creating volume: %s, size: %dGB, type: %s, datacenter: %s",
+func (c *Client) CreateVolume(ctx context.Context, name string, sizeGB int32, volumeType string, dataCenterID string, tags []VolumeTag) (*VolumeResponse, error) {
+	logging.Klog(ctx).V(4).Infof("Creating volume: %s, size: %dGB, type: %s, datacenter: %s",
 		name, sizeGB, volumeType, dataCenterID)
 
 	req := &VolumeCreateRequest{
@@ -238,9 +523,10 @@ func (c *Client) CreateVolume(ctx context.Context, name string, sizeGB int32, vo
 		VolumeGb:     sizeGB,
 		VolumeType:   volumeType,
 		DataCenterID: dataCenterID,
+		Tags:         tags,
 	}
 
-	resp, err := c.doRequest(ctx, "POST", "/v1/volumes", req)
+	resp, err := c.doRegionalRequest(ctx, dataCenterID, "POST", "/v1/volumes", req)
 	if err != nil {
 		return nil, err
 	}
@@ -248,7 +534,13 @@ func (c *Client) CreateVolume(ctx context.Context, name string, sizeGB int32, vo
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to create volume: status %d, body: %s", resp.StatusCode, string(body))
+		apiErr := newAPIError("create volume", resp, body)
+		// A server error may arrive after Emma created the volume, so only an explicit
+		// capacity error is safe to retry in another datacenter
+		if apiErr.outOfCapacity() {
+			return nil, fmt.Errorf("%w: %s: %w", ErrDataCenterUnavailable, dataCenterID, apiErr)
+		}
+		return nil, apiErr
 	}
 
 	var volume VolumeResponse
@@ -256,28 +548,30 @@ func (c *Client) CreateVolume(ctx context.Context, name string, sizeGB int32, vo
 		return nil, fmt.Errorf("failed to decode volume response: %w", err)
 	}
 
-	klog.V(4).Infof("Volume created successfully: ID=%d, status=%s", volume.ID, volume.Status)
+	c.endpoints.rememberVolume(&volume)
+	logging.Klog(ctx).V(4).Infof("Volume created successfully: ID=%d, status=%s", volume.ID, volume.Status)
 	return &volume, nil
 }
 
 // GetVolume retrieves a volume by ID using direct API call
 func (c *Client) GetVolume(ctx context.Context, volumeID int32) (*VolumeResponse, error) {
-	klog.V(5).Infof("Getting volume: %d", volumeID)
+	logging.Klog(ctx).V(5).Infof("Getting volume: %d", volumeID)
 
 	path := fmt.Sprintf("/v1/volumes/%d", volumeID)
-	resp, err := c.doRequest(ctx, "GET", path, nil)
+	resp, err := c.doRegionalRequest(ctx, c.endpoints.volumeDataCenter(volumeID), "GET", path, nil)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("volume %d not found", volumeID)
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%w: %d: %w", ErrVolumeNotFound, volumeID, newAPIError("get volume", resp, body))
 	}
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to get volume: status %d, body: %s", resp.StatusCode, string(body))
+		return nil, newAPIError("get volume", resp, body)
 	}
 
 	var volume VolumeResponse
@@ -285,61 +579,152 @@ func (c *Client) GetVolume(ctx context.Context, volumeID int32) (*VolumeResponse
 		return nil, fmt.Errorf("failed to decode volume response: %w", err)
 	}
 
+	c.endpoints.rememberVolume(&volume)
 	return &volume, nil
 }
 
+// ListVolumesOptions filters the volumes returned by ListVolumesFiltered
+type ListVolumesOptions struct {
+	// DataCenterID restricts results to volumes in a data center
+	DataCenterID string
+
+	// NamePrefix restricts results to volumes whose name starts with the prefix
+	NamePrefix string
+}
+
 // ListVolumes lists all volumes using direct API call
 func (c *Client) ListVolumes(ctx context.Context) ([]*VolumeResponse, error) {
-	klog.V(5).Info("Listing volumes")
+	return c.ListVolumesFiltered(ctx, ListVolumesOptions{})
+}
+
+// ListVolumesFiltered lists volumes matching the given options. Filters are sent to the
+// Emma API as query parameters and also applied to the response, so results are correct
+// even where the API ignores a parameter.
+func (c *Client) ListVolumesFiltered(ctx context.Context, opts ListVolumesOptions) ([]*VolumeResponse, error) {
+	logging.Klog(ctx).V(5).Infof("Listing volumes (dataCenterId=%q, namePrefix=%q)", opts.DataCenterID, opts.NamePrefix)
 
-	resp, err := c.doRequest(ctx, "GET", "/v1/volumes", nil)
+	query := url.Values{}
+	if opts.DataCenterID != "" {
+		query.Set("dataCenterId", opts.DataCenterID)
+	}
+	if opts.NamePrefix != "" {
+		query.Set("name", opts.NamePrefix)
+	}
+
+	var volumes []*VolumeResponse
+	for page := 1; ; page++ {
+		if page > 1 {
+			query.Set("page", strconv.Itoa(page))
+		}
+		pageVolumes, pagination, err := c.listVolumesPage(ctx, opts.DataCenterID, query)
+		if err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, pageVolumes...)
+		if pagination == nil || page >= pagination.TotalPages || page >= maxVolumeListPages || len(pageVolumes) == 0 {
+			break
+		}
+		if pagination.PerPage > 0 {
+			query.Set("perPage", strconv.Itoa(pagination.PerPage))
+		}
+	}
+
+	filtered := volumes[:0]
+	for _, volume := range volumes {
+		if opts.DataCenterID != "" && volume.DataCenterID != opts.DataCenterID {
+			continue
+		}
+		if opts.NamePrefix != "" && !strings.HasPrefix(volume.Name, opts.NamePrefix) {
+			continue
+		}
+		c.endpoints.rememberVolume(volume)
+		filtered = append(filtered, volume)
+	}
+
+	logging.Klog(ctx).V(5).Infof("Listed %d volumes", len(filtered))
+	return filtered, nil
+}
+
+// maxVolumeListPages bounds how many Emma pages a single volume listing fetches
+const maxVolumeListPages = 1000
+
+// volumeListPagination is the pagination block of a paged volume list response
+type volumeListPagination struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"perPage"`
+	Total      int `json:"total"`
+	TotalPages int `json:"totalPages"`
+}
+
+// listVolumesPage fetches one page of volumes. The Emma API returns either a plain array
+// of every volume or a {volumes, pagination} envelope; the pagination is nil for the former.
+func (c *Client) listVolumesPage(ctx context.Context, dataCenterID string, query url.Values) ([]*VolumeResponse, *volumeListPagination, error) {
+	path := "/v1/volumes"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	resp, err := c.doRegionalRequest(ctx, dataCenterID, "GET", path, nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read volumes response: %w", err)
+	}
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to list volumes: status %d, body: %s", resp.StatusCode, string(body))
+		return nil, nil, newAPIError("list volumes", resp, body)
 	}
 
 	var volumes []*VolumeResponse
-	if err := json.NewDecoder(resp.Body).Decode(&volumes); err != nil {
-		return nil, fmt.Errorf("failed to decode volumes response: %w", err)
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		var paged struct {
+			Volumes    []*VolumeResponse     `json:"volumes"`
+			Pagination *volumeListPagination `json:"pagination"`
+		}
+		if err := json.Unmarshal(body, &paged); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode volumes response: %w", err)
+		}
+		return paged.Volumes, paged.Pagination, nil
 	}
-
-	klog.V(5).Infof("Listed %d volumes", len(volumes))
-	return volumes, nil
+	if err := json.Unmarshal(body, &volumes); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode volumes response: %w", err)
+	}
+	return volumes, nil, nil
 }
 
 // DeleteVolume deletes a volume using direct API call
 func (c *Client) DeleteVolume(ctx context.Context, volumeID int32) error {
-	klog.V(4).Infof("Deleting volume: %d", volumeID)
+	logging.Klog(ctx).V(4).Infof("Deleting volume: %d", volumeID)
 
 	path := fmt.Sprintf("/v1/volumes/%d", volumeID)
-	resp, err := c.doRequest(ctx, "DELETE", path, nil)
+	resp, err := c.doRegionalRequest(ctx, c.endpoints.volumeDataCenter(volumeID), "DELETE", path, nil)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotFound {
-		klog.V(4).Infof("Volume %d not found, considering it already deleted", volumeID)
+		logging.Klog(ctx).V(4).Infof("Volume %d not found, considering it already deleted", volumeID)
+		c.endpoints.forgetVolume(volumeID)
 		return nil
 	}
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to delete volume: status %d, body: %s", resp.StatusCode, string(body))
+		return newAPIError("delete volume", resp, body)
 	}
 
-	klog.V(4).Infof("Volume %d deleted successfully", volumeID)
+	c.endpoints.forgetVolume(volumeID)
+	logging.Klog(ctx).V(4).Infof("Volume %d deleted successfully", volumeID)
 	return nil
 }
 
 // ResizeVolume resizes a volume using direct API call
 func (c *Client) ResizeVolume(ctx context.Context, volumeID int32, newSizeGB int32) error {
-	klog.V(4).Infof("Resizing volume %d to %dGB", volumeID, newSizeGB)
+	logging.Klog(ctx).V(4).Infof("Resizing volume %d to %dGB", volumeID, newSizeGB)
 
 	path := fmt.Sprintf("/v1/volumes/%d/actions", volumeID)
 	req := map[string]interface{}{
@@ -347,7 +732,7 @@ func (c *Client) ResizeVolume(ctx context.Context, volumeID int32, newSizeGB int
 		"sizeGb": newSizeGB,
 	}
 
-	resp, err := c.doRequest(ctx, "POST", path, req)
+	resp, err := c.doRegionalRequest(ctx, c.endpoints.volumeDataCenter(volumeID), "POST", path, req)
 	if err != nil {
 		return err
 	}
@@ -355,16 +740,17 @@ func (c *Client) ResizeVolume(ctx context.Context, volumeID int32, newSizeGB int
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to resize volume: status %d, body: %s", resp.StatusCode, string(body))
+		return newAPIError("resize volume", resp, body)
 	}
 
-	klog.V(4).Infof("Volume %d resize initiated successfully", volumeID)
+	logging.Klog(ctx).V(4).Infof("Volume %d resize initiated successfully", volumeID)
 	return nil
 }
 
-// AttachVolume attaches a volume to a VM using direct API call with retry logic
+// AttachVolume attaches a volume to a VM. VM actions rejected while the VM is in a
+// transitional state are retried under the client's VM action retry policy.
 func (c *Client) AttachVolume(ctx context.Context, vmID int32, volumeID int32) error {
-	klog.V(4).Infof("Attaching volume %d to VM %d", volumeID, vmID)
+	logging.Klog(ctx).V(4).Infof("Attaching volume %d to VM %d", volumeID, vmID)
 
 	path := fmt.Sprintf("/v1/vms/%d/actions", vmID)
 	req := &VMActionRequest{
@@ -372,85 +758,31 @@ func (c *Client) AttachVolume(ctx context.Context, vmID int32, volumeID int32) e
 		VolumeID: &volumeID,
 	}
 
-	// Optimized retry logic for VM state conflicts
-	// Emma VMs can be in transitional states during startup/operations
-	// Use shorter initial delays with faster ramp-up
-	maxRetries := 12
-	initialDelay := 1 * time.Second
-	maxDelay := 15 * time.Second
-
 	startTime := time.Now()
+	resp, err := c.doRegionalRequest(withRetryPolicy(ctx, c.vmActionRetry), c.endpoints.volumeDataCenter(volumeID), "POST", path, req)
+	if err != nil {
+		return err
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
 
-	for attempt := 0; attempt <= maxRetries; attempt++ {
-		resp, err := c.doRequest(ctx, "POST", path, req)
-		if err != nil {
-			// Check if it's a token error and retry once
-			if attempt == 0 && (resp == nil || resp.StatusCode == http.StatusUnauthorized) {
-				klog.V(4).Info("Possible token issue, refreshing and retrying")
-				_, _ = c.getAccessToken(ctx)
-				continue
-			}
-			return err
-		}
-
-		body, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
-
-		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusAccepted {
-			elapsed := time.Since(startTime)
-			klog.V(4).Infof("Volume %d attach to VM %d initiated successfully (took %v, %d attempts)",
-				volumeID, vmID, elapsed, attempt+1)
-			return nil
-		}
-
-		// Handle 409 CONFLICT - VM in transitional state
-		if resp.StatusCode == http.StatusConflict {
-			if attempt < maxRetries {
-				// Optimized backoff: start fast, ramp up gradually
-				// 1s, 2s, 3s, 5s, 8s, 12s, 15s, 15s...
-				var delay time.Duration
-				if attempt < 3 {
-					delay = initialDelay * time.Duration(attempt+1)
-				} else {
-					delay = initialDelay * time.Duration(1<<uint(attempt-2))
-				}
-				if delay > maxDelay {
-					delay = maxDelay
-				}
-
-				klog.V(4).Infof("VM %d not ready for attach (attempt %d/%d), retrying in %v: %s",
-					vmID, attempt+1, maxRetries+1, delay, string(body))
-
-				select {
-				case <-ctx.Done():
-					return ctx.Err()
-				case <-time.After(delay):
-					continue
-				}
-			}
-		}
-
-		// Handle 400 BAD_REQUEST - might be a transient issue
-		if resp.StatusCode == http.StatusBadRequest && attempt < 3 {
-			klog.V(4).Infof("Bad request on attempt %d, retrying after 2s: %s", attempt+1, string(body))
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case <-time.After(2 * time.Second):
-				continue
-			}
-		}
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusAccepted {
+		logging.Klog(ctx).V(4).Infof("Volume %d attach to VM %d initiated successfully (took %v)", volumeID, vmID, time.Since(startTime))
+		return nil
+	}
 
-		// Non-retryable error or max retries exceeded
-		return fmt.Errorf("failed to attach volume: status %d, body: %s", resp.StatusCode, string(body))
+	// VM was deleted or recreated with a new ID
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: VM %d: %w", ErrVMNotFound, vmID, newAPIError("attach volume", resp, body))
 	}
 
-	return fmt.Errorf("failed to attach volume after %d retries: VM not ready", maxRetries+1)
+	return newAPIError("attach volume", resp, body)
 }
 
-// DetachVolume detaches a volume from a VM using direct API call with retry logic
+// DetachVolume detaches a volume from a VM. VM actions rejected while the VM is in a
+// transitional state are retried under the client's VM action retry policy.
 func (c *Client) DetachVolume(ctx context.Context, vmID int32, volumeID int32) error {
-	klog.V(4).Infof("Detaching volume %d from VM %d", volumeID, vmID)
+	logging.Klog(ctx).V(4).Infof("Detaching volume %d from VM %d", volumeID, vmID)
 
 	path := fmt.Sprintf("/v1/vms/%d/actions", vmID)
 	req := &VMActionRequest{
@@ -458,91 +790,34 @@ func (c *Client) DetachVolume(ctx context.Context, vmID int32, volumeID int32) e
 		VolumeID: &volumeID,
 	}
 
-	// Optimized retry logic for VM state conflicts
-	maxRetries := 12
-	initialDelay := 1 * time.Second
-	maxDelay := 15 * time.Second
-
 	startTime := time.Now()
+	resp, err := c.doRegionalRequest(withRetryPolicy(ctx, c.vmActionRetry), c.endpoints.volumeDataCenter(volumeID), "POST", path, req)
+	if err != nil {
+		return err
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
 
-	for attempt := 0; attempt <= maxRetries; attempt++ {
-		resp, err := c.doRequest(ctx, "POST", path, req)
-		if err != nil {
-			// Check if it's a token error and retry once
-			if attempt == 0 && (resp == nil || resp.StatusCode == http.StatusUnauthorized) {
-				klog.V(4).Info("Possible token issue, refreshing and retrying")
-				_, _ = c.getAccessToken(ctx)
-				continue
-			}
-			return err
-		}
-
-		body, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
-
-		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusAccepted {
-			elapsed := time.Since(startTime)
-			klog.V(4).Infof("Volume %d detach from VM %d initiated successfully (took %v, %d attempts)",
-				volumeID, vmID, elapsed, attempt+1)
-			return nil
-		}
-
-		// Handle 409 CONFLICT - VM in transitional state
-		if resp.StatusCode == http.StatusConflict {
-			if attempt < maxRetries {
-				// Optimized backoff: start fast, ramp up gradually
-				var delay time.Duration
-				if attempt < 3 {
-					delay = initialDelay * time.Duration(attempt+1)
-				} else {
-					delay = initialDelay * time.Duration(1<<uint(attempt-2))
-				}
-				if delay > maxDelay {
-					delay = maxDelay
-				}
-
-				klog.V(4).Infof("VM %d not ready for detach (attempt %d/%d), retrying in %v: %s",
-					vmID, attempt+1, maxRetries+1, delay, string(body))
-
-				select {
-				case <-ctx.Done():
-					return ctx.Err()
-				case <-time.After(delay):
-					continue
-				}
-			}
-		}
-
-		// Handle 400 BAD_REQUEST - might be a transient issue
-		if resp.StatusCode == http.StatusBadRequest && attempt < 3 {
-			klog.V(4).Infof("Bad request on attempt %d, retrying after 2s: %s", attempt+1, string(body))
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case <-time.After(2 * time.Second):
-				continue
-			}
-		}
-
-		// Non-retryable error or max retries exceeded
-		return fmt.Errorf("failed to detach volume: status %d, body: %s", resp.StatusCode, string(body))
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusAccepted {
+		logging.Klog(ctx).V(4).Infof("Volume %d detach from VM %d initiated successfully (took %v)", volumeID, vmID, time.Since(startTime))
+		return nil
 	}
 
-	return fmt.Errorf("failed to detach volume after %d retries: VM not ready", maxRetries+1)
+	return newAPIError("detach volume", resp, body)
 }
 
 // GetVM retrieves a VM by ID
 func (c *Client) GetVM(ctx context.Context, vmID int32) (*emma.Vm, error) {
-	klog.V(5).Infof("Getting VM: %d", vmID)
+	logging.Klog(ctx).V(5).Infof("Getting VM: %d", vmID)
 
-	// Ensure we have a valid token
-	if _, err := c.getAccessToken(ctx); err != nil {
-		return nil, fmt.Errorf("failed to get access token: %w", err)
+	authCtx, err := c.authContext(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	vm, _, err := c.apiClient.VirtualMachinesAPI.GetVm(c.ctx, vmID).Execute()
+	vm, httpResp, err := c.apiClient.VirtualMachinesAPI.GetVm(authCtx, vmID).Execute()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get VM: %w", err)
+		return nil, fmt.Errorf("failed to get VM: %w", sdkError(httpResp, err))
 	}
 
 	return vm, nil
@@ -550,232 +825,227 @@ func (c *Client) GetVM(ctx context.Context, vmID int32) (*emma.Vm, error) {
 
 // ListVMs lists all VMs
 func (c *Client) ListVMs(ctx context.Context) ([]emma.Vm, error) {
-	klog.V(5).Info("Listing VMs")
+	logging.Klog(ctx).V(5).Info("Listing VMs")
 
-	// Ensure we have a valid token
-	if _, err := c.getAccessToken(ctx); err != nil {
-		return nil, fmt.Errorf("failed to get access token: %w", err)
+	authCtx, err := c.authContext(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	vms, _, err := c.apiClient.VirtualMachinesAPI.GetVms(c.ctx).Execute()
+	vms, httpResp, err := c.apiClient.VirtualMachinesAPI.GetVms(authCtx).Execute()
 	if err != nil {
-		return nil, fmt.Errorf("failed to list VMs: %w", err)
+		return nil, fmt.Errorf("failed to list VMs: %w", sdkError(httpResp, err))
 	}
 
-	klog.V(5).Infof("Listed %d VMs", len(vms))
+	logging.Klog(ctx).V(5).Infof("Listed %d VMs", len(vms))
 	return vms, nil
 }
 
 // ListKubernetesClusters lists all Kubernetes clusters
 func (c *Client) ListKubernetesClusters(ctx context.Context) ([]emma.Kubernetes, error) {
-	klog.V(5).Info("Listing Kubernetes clusters")
+	logging.Klog(ctx).V(5).Info("Listing Kubernetes clusters")
 
-	// Ensure we have a valid token
-	if _, err := c.getAccessToken(ctx); err != nil {
-		return nil, fmt.Errorf("failed to get access token: %w", err)
+	authCtx, err := c.authContext(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	clusters, _, err := c.apiClient.KubernetesClustersAPI.GetKubernetesClusters(c.ctx).Execute()
+	clusters, httpResp, err := c.apiClient.KubernetesClustersAPI.GetKubernetesClusters(authCtx).Execute()
 	if err != nil {
-		return nil, fmt.Errorf("failed to list Kubernetes clusters: %w", err)
+		return nil, fmt.Errorf("failed to list Kubernetes clusters: %w", sdkError(httpResp, err))
 	}
 
-	klog.V(5).Infof("Listed %d Kubernetes clusters", len(clusters))
+	logging.Klog(ctx).V(5).Infof("Listed %d Kubernetes clusters", len(clusters))
 	return clusters, nil
 }
 
 // GetKubernetesCluster retrieves a specific Kubernetes cluster by ID
 func (c *Client) GetKubernetesCluster(ctx context.Context, clusterID int32) (*emma.Kubernetes, error) {
-	klog.V(5).Infof("Getting Kubernetes cluster: %d", clusterID)
+	logging.Klog(ctx).V(5).Infof("Getting Kubernetes cluster: %d", clusterID)
 
-	// Ensure we have a valid token
-	if _, err := c.getAccessToken(ctx); err != nil {
-		return nil, fmt.Errorf("failed to get access token: %w", err)
+	authCtx, err := c.authContext(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	cluster, _, err := c.apiClient.KubernetesClustersAPI.GetKubernetesCluster(c.ctx, clusterID).Execute()
+	cluster, httpResp, err := c.apiClient.KubernetesClustersAPI.GetKubernetesCluster(authCtx, clusterID).Execute()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get Kubernetes cluster: %w", err)
+		return nil, fmt.Errorf("failed to get Kubernetes cluster: %w", sdkError(httpResp, err))
 	}
 
 	return cluster, nil
 }
 
-// GetDataCenters retrieves all available data centers
+// GetDataCenters retrieves all available data centers. It always asks the Emma API, which
+// makes it suitable as a health check, and refreshes the datacenter cache.
 func (c *Client) GetDataCenters(ctx context.Context) ([]emma.DataCenter, error) {
-	klog.V(5).Info("Getting data centers")
+	logging.Klog(ctx).V(5).Info("Getting data centers")
 
-	// Ensure we have a valid token
-	if _, err := c.getAccessToken(ctx); err != nil {
-		return nil, fmt.Errorf("failed to get access token: %w", err)
+	authCtx, err := c.authContext(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	dataCenters, _, err := c.apiClient.DataCentersAPI.GetDataCenters(c.ctx).Execute()
+	dataCenters, httpResp, err := c.apiClient.DataCentersAPI.GetDataCenters(authCtx).Execute()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get data centers: %w", err)
+		return nil, fmt.Errorf("failed to get data centers: %w", sdkError(httpResp, err))
 	}
 
-	klog.V(5).Infof("Retrieved %d data centers", len(dataCenters))
+	c.dataCenters.replace(dataCenters)
+	logging.Klog(ctx).V(5).Infof("Retrieved %d data centers", len(dataCenters))
 	return dataCenters, nil
 }
 
-// GetDataCenter retrieves a specific data center by ID
+// GetDataCenter retrieves a specific data center by ID, from the cache when it was looked
+// up recently
 func (c *Client) GetDataCenter(ctx context.Context, dataCenterID string) (*emma.DataCenter, error) {
-	klog.V(5).Infof("Getting data center: %s", dataCenterID)
+	if dc, ok := c.dataCenters.get(dataCenterID); ok {
+		logging.Klog(ctx).V(5).Infof("Data center %s served from cache", dataCenterID)
+		return dc, nil
+	}
+	logging.Klog(ctx).V(5).Infof("Getting data center: %s", dataCenterID)
 
-	// Ensure we have a valid token
-	if _, err := c.getAccessToken(ctx); err != nil {
-		return nil, fmt.Errorf("failed to get access token: %w", err)
+	authCtx, err := c.authContext(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	dc, _, err := c.apiClient.DataCentersAPI.GetDataCenter(c.ctx, dataCenterID).Execute()
+	dc, httpResp, err := c.apiClient.DataCentersAPI.GetDataCenter(authCtx, dataCenterID).Execute()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get data center: %w", err)
+		if httpResp != nil && httpResp.StatusCode == http.StatusNotFound {
+			c.dataCenters.invalidate(dataCenterID)
+		}
+		return nil, fmt.Errorf("failed to get data center: %w", sdkError(httpResp, err))
 	}
 
+	c.dataCenters.put(*dc)
 	return dc, nil
 }
 
-// GetVolumeConfigs retrieves available volume configurations
+// GetVolumeConfigs retrieves the volume configurations Emma offers, following all pages.
+// They are cached for the datacenter cache TTL.
 func (c *Client) GetVolumeConfigs(ctx context.Context) ([]emma.VolumeConfiguration, error) {
-	klog.V(5).Info("Getting volume configs")
-
-	// Ensure we have a valid token
-	if _, err := c.getAccessToken(ctx); err != nil {
-		return nil, fmt.Errorf("failed to get access token: %w", err)
+	if configs, ok := c.volumeConfigs.get(); ok {
+		return configs, nil
 	}
+	logging.Klog(ctx).V(5).Info("Getting volume configs")
 
-	_, _, err := c.apiClient.VolumesConfigurationsAPI.GetSystemVolumeConfigs(c.ctx).Execute()
+	authCtx, err := c.authContext(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get volume configs: %w", err)
+		return nil, err
+	}
+
+	var all []emma.VolumeConfiguration
+	for page := int32(0); page < maxVolumeConfigsPages; page++ {
+		configs, httpResp, err := c.apiClient.VolumesConfigurationsAPI.GetSystemVolumeConfigs(authCtx).
+			Page(page).Size(volumeConfigsPageSize).Execute()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get volume configs: %w", sdkError(httpResp, err))
+		}
+		all = append(all, configs.GetContent()...)
+		if configs.GetLast() || len(configs.GetContent()) == 0 || page+1 >= configs.GetTotalPages() {
+			break
+		}
+	}
+
+	logging.Klog(ctx).V(5).Infof("Retrieved %d volume configs", len(all))
+	c.volumeConfigs.put(all, c.dataCenters)
+	return all, nil
+}
+
+// PermissionCheck is the result of probing one area of the Emma API at startup
+type PermissionCheck struct {
+	Area string
+	Err  error
+}
+
+// CheckPermissions performs a harmless read call in each API area the driver depends on,
+// so missing scopes are reported at startup instead of at the first CreateVolume
+func (c *Client) CheckPermissions(ctx context.Context) []PermissionCheck {
+	checks := []struct {
+		area string
+		call func() error
+	}{
+		{"datacenters", func() error { _, err := c.GetDataCenters(ctx); return err }},
+		{"volumes", func() error { _, err := c.ListVolumes(ctx); return err }},
+		{"vms", func() error { _, err := c.ListVMs(ctx); return err }},
+		{"kubernetes", func() error { _, err := c.ListKubernetesClusters(ctx); return err }},
+	}
+
+	results := make([]PermissionCheck, 0, len(checks))
+	for _, check := range checks {
+		err := check.call()
+		if err != nil {
+			logging.Klog(ctx).V(4).Infof("Permission check for %s failed: %v", check.area, err)
+		}
+		results = append(results, PermissionCheck{Area: check.area, Err: err})
 	}
 
-	// Note: The SDK response structure needs to be verified
-	// For now, return empty slice as this is primarily used for validation
-	klog.V(5).Info("Volume configs retrieved successfully")
-	return []emma.VolumeConfiguration{}, nil
+	return results
 }
 
 // ValidateDataCenter checks if a data center ID is valid
 func (c *Client) ValidateDataCenter(ctx context.Context, dataCenterID string) error {
-	klog.V(5).Infof("Validating data center: %s", dataCenterID)
+	logging.Klog(ctx).V(5).Infof("Validating data center: %s", dataCenterID)
 
 	_, err := c.GetDataCenter(ctx, dataCenterID)
 	if err != nil {
 		return fmt.Errorf("data center %s not found: %w", dataCenterID, err)
 	}
 
-	klog.V(5).Infof("Data center %s is valid", dataCenterID)
+	logging.Klog(ctx).V(5).Infof("Data center %s is valid", dataCenterID)
 	return nil
 }
 
 // WaitForVolumeStatus polls until volume reaches desired status or timeout
 func (c *Client) WaitForVolumeStatus(ctx context.Context, volumeID int32, desiredStatus string, timeout time.Duration) error {
-	klog.V(4).Infof("Waiting for volume %d to reach status %s (timeout: %v)", volumeID, desiredStatus, timeout)
-
-	deadline := time.Now().Add(timeout)
-	pollInterval := 5 * time.Second
-
-	for {
-		if time.Now().After(deadline) {
-			return fmt.Errorf("timeout waiting for volume %d to reach status %s", volumeID, desiredStatus)
-		}
-
-		volume, err := c.GetVolume(ctx, volumeID)
-		if err != nil {
-			return fmt.Errorf("failed to get volume status: %w", err)
-		}
-
-		klog.V(5).Infof("Volume %d current status: %s", volumeID, volume.Status)
-
-		if volume.Status == desiredStatus {
-			klog.V(4).Infof("Volume %d reached desired status: %s", volumeID, desiredStatus)
-			return nil
+	logging.Klog(ctx).V(4).Infof("Waiting for volume %d to reach status %s (timeout: %v)", volumeID, desiredStatus, timeout)
+
+	return c.waitForVolumeState(ctx, volumeID, timeout, "reach status "+desiredStatus, func(volume *VolumeResponse) waitOutcome {
+		switch volume.Status {
+		case desiredStatus:
+			return waitDone
+		case "FAILED":
+			return waitFailed
+		default:
+			return waitPending
 		}
+	})
+}
 
-		if volume.Status == "FAILED" {
-			return fmt.Errorf("volume %d entered FAILED state", volumeID)
-		}
+// WaitForVolumeSettled polls until the volume leaves transient states such as BUSY or
+// PROCESSING and returns it, so it can be changed without conflicting with Emma's own work
+func (c *Client) WaitForVolumeSettled(ctx context.Context, volumeID int32, timeout time.Duration) (*VolumeResponse, error) {
+	logging.Klog(ctx).V(4).Infof("Waiting for volume %d to leave transient states (timeout: %v)", volumeID, timeout)
 
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(pollInterval):
-			// Continue polling
+	var settled *VolumeResponse
+	err := c.waitForVolumeState(ctx, volumeID, timeout, "settle", func(volume *VolumeResponse) waitOutcome {
+		if transientVolumeStatuses[volume.Status] {
+			return waitPending
 		}
+		settled = volume
+		return waitDone
+	})
+	if err != nil {
+		return nil, err
 	}
+	return settled, nil
 }
 
 // WaitForVolumeAttachment polls until volume is attached to the specified VM
 func (c *Client) WaitForVolumeAttachment(ctx context.Context, volumeID int32, vmID int32, timeout time.Duration) error {
-	klog.V(4).Infof("Waiting for volume %d to attach to VM %d (timeout: %v)", volumeID, vmID, timeout)
-
-	deadline := time.Now().Add(timeout)
-	pollInterval := 5 * time.Second
-
-	for {
-		if time.Now().After(deadline) {
-			return fmt.Errorf("timeout waiting for volume %d to attach to VM %d", volumeID, vmID)
-		}
-
-		volume, err := c.GetVolume(ctx, volumeID)
-		if err != nil {
-			return fmt.Errorf("failed to get volume status: %w", err)
-		}
-
-		klog.V(5).Infof("Volume %d status: %s, attachedTo: %v", volumeID, volume.Status, volume.AttachedToID)
-
-		if volume.Status == "ACTIVE" && volume.AttachedToID != nil && *volume.AttachedToID == vmID {
-			klog.V(4).Infof("Volume %d successfully attached to VM %d", volumeID, vmID)
-			return nil
-		}
-
-		if volume.Status == "FAILED" {
-			return fmt.Errorf("volume %d entered FAILED state during attachment", volumeID)
-		}
+	logging.Klog(ctx).V(4).Infof("Waiting for volume %d to attach to VM %d (timeout: %v)", volumeID, vmID, timeout)
 
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(pollInterval):
-			// Continue polling
-		}
-	}
+	return c.waitForVolumeState(ctx, volumeID, timeout, fmt.Sprintf("attach to VM %d", vmID), func(volume *VolumeResponse) waitOutcome {
+		return classifyVolumeState(attachStateTable, volume, vmID)
+	})
 }
 
 // WaitForVolumeDetachment polls until volume is detached
 func (c *Client) WaitForVolumeDetachment(ctx context.Context, volumeID int32, timeout time.Duration) error {
-	klog.V(4).Infof("Waiting for volume %d to detach (timeout: %v)", volumeID, timeout)
-
-	deadline := time.Now().Add(timeout)
-	pollInterval := 5 * time.Second
-
-	for {
-		if time.Now().After(deadline) {
-			return fmt.Errorf("timeout waiting for volume %d to detach", volumeID)
-		}
-
-		volume, err := c.GetVolume(ctx, volumeID)
-		if err != nil {
-			return fmt.Errorf("failed to get volume status: %w", err)
-		}
+	logging.Klog(ctx).V(4).Infof("Waiting for volume %d to detach (timeout: %v)", volumeID, timeout)
 
-		klog.V(5).Infof("Volume %d status: %s, attachedTo: %v", volumeID, volume.Status, volume.AttachedToID)
-
-		if volume.Status == "AVAILABLE" && volume.AttachedToID == nil {
-			klog.V(4).Infof("Volume %d successfully detached", volumeID)
-			return nil
-		}
-
-		if volume.Status == "FAILED" {
-			return fmt.Errorf("volume %d entered FAILED state during detachment", volumeID)
-		}
-
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(pollInterval):
-			// Continue polling
-		}
-	}
+	return c.waitForVolumeState(ctx, volumeID, timeout, "detach", func(volume *VolumeResponse) waitOutcome {
+		return classifyVolumeState(detachStateTable, volume, 0)
+	})
 }
@@ -3,25 +3,54 @@ package emma
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	emma "github.com/emma-community/emma-go-sdk"
+
 	"github.com/emma-csi-driver/pkg/logging"
 )
 
 // newTestClient creates a test client with a mock server
 func newTestClient(server *httptest.Server) *Client {
+	retry := NewRetryTransport(http.DefaultTransport, fastRetryPolicy(DefaultRetryPolicy()))
 	return &Client{
-		baseURL:     server.URL,
-		httpClient:  &http.Client{Timeout: 5 * time.Second},
-		accessToken: "test-token",
-		tokenExpiry: time.Now().Add(1 * time.Hour),
-		logger:      logging.NewLogger("test-client"),
+		baseURL:       server.URL,
+		httpClient:    &http.Client{Timeout: 5 * time.Second, Transport: retry},
+		accessToken:   "test-token",
+		tokenExpiry:   time.Now().Add(1 * time.Hour),
+		logger:        logging.NewLogger("test-client"),
+		retry:         retry,
+		vmActionRetry: fastRetryPolicy(VMActionRetryPolicy()),
+		pollInterval:  time.Millisecond,
 	}
 }
 
+// fastRetryPolicy shortens the backoff of a retry policy for tests
+func fastRetryPolicy(policy RetryPolicy) RetryPolicy {
+	policy.InitialBackoff = time.Millisecond
+	policy.MaxBackoff = 5 * time.Millisecond
+	return policy
+}
+
+// newTestSDKClient creates a test client whose SDK calls also go to the mock server
+func newTestSDKClient(server *httptest.Server) *Client {
+	config := emma.NewConfiguration()
+	config.Servers = emma.ServerConfigurations{{URL: server.URL}}
+	config.HTTPClient = server.Client()
+
+	client := newTestClient(server)
+	client.apiClient = emma.NewAPIClient(config)
+	return client
+}
+
 // TestCreateVolume tests volume creation
 func TestCreateVolume(t *testing.T) {
 	tests := []struct {
@@ -75,7 +104,7 @@ func TestCreateVolume(t *testing.T) {
 			defer server.Close()
 
 			client := newTestClient(server)
-			volume, err := client.CreateVolume(context.Background(), tt.volumeName, tt.sizeGB, tt.volumeType, tt.dataCenterID)
+			volume, err := client.CreateVolume(context.Background(), tt.volumeName, tt.sizeGB, tt.volumeType, tt.dataCenterID, nil)
 
 			if tt.expectError && err == nil {
 				t.Error("expected error but got none")
@@ -394,6 +423,273 @@ func TestListVolumes(t *testing.T) {
 	}
 }
 
+// TestListVolumesFiltered tests that filters are sent as query parameters and applied to results
+func TestListVolumesFiltered(t *testing.T) {
+	var gotQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.RawQuery
+		w.WriteHeader(http.StatusOK)
+		// Return unfiltered results to simulate an API that ignores the parameters
+		json.NewEncoder(w).Encode([]*VolumeResponse{
+			{ID: 1, Name: "pvc-a", DataCenterID: "aws-eu-west-2"},
+			{ID: 2, Name: "pvc-b", DataCenterID: "aws-us-east-1"},
+			{ID: 3, Name: "manual", DataCenterID: "aws-eu-west-2"},
+		})
+	}))
+	defer server.Close()
+
+	client := newTestClient(server)
+	volumes, err := client.ListVolumesFiltered(context.Background(), ListVolumesOptions{
+		DataCenterID: "aws-eu-west-2",
+		NamePrefix:   "pvc-",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if gotQuery != "dataCenterId=aws-eu-west-2&name=pvc-" {
+		t.Errorf("unexpected query: %s", gotQuery)
+	}
+	if len(volumes) != 1 || volumes[0].ID != 1 {
+		t.Errorf("expected only volume 1, got %+v", volumes)
+	}
+}
+
+// TestListVolumesPaged tests that every page of a paged volume list response is fetched
+func TestListVolumesPaged(t *testing.T) {
+	var gotQueries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQueries = append(gotQueries, r.URL.RawQuery)
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page == 0 {
+			page = 1
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"volumes": []*VolumeResponse{
+				{ID: int32(page*10 + 1), Name: "pvc-a"},
+				{ID: int32(page*10 + 2), Name: "pvc-b"},
+			},
+			"pagination": map[string]int{"page": page, "perPage": 2, "total": 5, "totalPages": 3},
+		})
+	}))
+	defer server.Close()
+
+	client := newTestClient(server)
+	volumes, err := client.ListVolumes(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectedQueries := []string{"", "page=2&perPage=2", "page=3&perPage=2"}
+	if !reflect.DeepEqual(gotQueries, expectedQueries) {
+		t.Errorf("expected queries %v, got %v", expectedQueries, gotQueries)
+	}
+	if len(volumes) != 6 || volumes[5].ID != 32 {
+		t.Errorf("expected 6 volumes across 3 pages, got %+v", volumes)
+	}
+}
+
+// TestAuthFailures tests that permission errors are permanent and not retried
+func TestAuthFailures(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	client := newTestClient(server)
+
+	t.Run("403 on GET", func(t *testing.T) {
+		requests = 0
+		_, err := client.GetVolume(context.Background(), 123)
+		if !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("expected ErrPermissionDenied, got %v", err)
+		}
+		if requests != 1 {
+			t.Errorf("expected 1 request, got %d", requests)
+		}
+	})
+
+	t.Run("403 on attach is not retried", func(t *testing.T) {
+		requests = 0
+		err := client.AttachVolume(context.Background(), 456, 123)
+		if !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("expected ErrPermissionDenied, got %v", err)
+		}
+		if requests != 1 {
+			t.Errorf("expected 1 request, got %d", requests)
+		}
+	})
+}
+
+// TestAttachVolumeVMNotFound tests that attaching to a missing VM returns ErrVMNotFound
+func TestAttachVolumeVMNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := newTestClient(server)
+	err := client.AttachVolume(context.Background(), 456, 123)
+	if !errors.Is(err, ErrVMNotFound) {
+		t.Errorf("expected ErrVMNotFound, got %v", err)
+	}
+}
+
+// TestAPIErrors tests that unsuccessful responses carry their HTTP status and body
+func TestAPIErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		call       func(c *Client) error
+		sdk        bool
+		notFound   bool
+		permission bool
+	}{
+		{name: "resize conflict", status: http.StatusConflict, call: func(c *Client) error { return c.ResizeVolume(context.Background(), 1, 20) }},
+		{name: "delete server error", status: http.StatusInternalServerError, call: func(c *Client) error { return c.DeleteVolume(context.Background(), 1) }},
+		{name: "get volume not found", status: http.StatusNotFound, notFound: true, call: func(c *Client) error {
+			_, err := c.GetVolume(context.Background(), 1)
+			return err
+		}},
+		{name: "SDK call", status: http.StatusServiceUnavailable, sdk: true, call: func(c *Client) error {
+			_, err := c.GetVM(context.Background(), 1)
+			return err
+		}},
+		{name: "forbidden", status: http.StatusForbidden, permission: true, call: func(c *Client) error {
+			_, err := c.ListVolumes(context.Background())
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(`{"message":"boom"}`))
+			}))
+			defer server.Close()
+
+			client := newTestClient(server)
+			if tt.sdk {
+				client = newTestSDKClient(server)
+			}
+			err := tt.call(client)
+			if tt.notFound {
+				if !errors.Is(err, ErrVolumeNotFound) {
+					t.Errorf("expected ErrVolumeNotFound, got %v", err)
+				}
+				return
+			}
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("expected an APIError, got %v", err)
+			}
+			if apiErr.StatusCode != tt.status || HTTPStatus(err) != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, apiErr.StatusCode)
+			}
+			if !strings.Contains(apiErr.Body, "boom") {
+				t.Errorf("expected the response body, got %q", apiErr.Body)
+			}
+			if errors.Is(err, ErrPermissionDenied) != tt.permission {
+				t.Errorf("expected ErrPermissionDenied=%v, got %v", tt.permission, err)
+			}
+		})
+	}
+}
+
+// TestWaitForVolumeAttachmentFailed tests that a FAILED volume is reported as ErrVolumeFailed
+func TestWaitForVolumeAttachmentFailed(t *testing.T) {
+	vmID := int32(456)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(VolumeResponse{ID: 123, Status: "FAILED", AttachedToID: &vmID})
+	}))
+	defer server.Close()
+
+	client := newTestClient(server)
+	err := client.WaitForVolumeAttachment(context.Background(), 123, vmID, time.Minute)
+	if !errors.Is(err, ErrVolumeFailed) {
+		t.Errorf("expected ErrVolumeFailed, got %v", err)
+	}
+}
+
+// TestWaitForVolumeDetachmentFailed tests that a FAILED volume is waited on until the detach
+// shows up, and fails once it is detached and still FAILED
+func TestWaitForVolumeDetachmentFailed(t *testing.T) {
+	vmID := int32(456)
+	tests := []struct {
+		name        string
+		detached    VolumeResponse
+		expectedErr error
+	}{
+		{name: "becomes available", detached: VolumeResponse{ID: 123, Status: "AVAILABLE"}},
+		{name: "stays failed", detached: VolumeResponse{ID: 123, Status: "FAILED"}, expectedErr: ErrVolumeFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			polls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				polls++
+				w.WriteHeader(http.StatusOK)
+				if polls <= 3 {
+					json.NewEncoder(w).Encode(VolumeResponse{ID: 123, Status: "FAILED", AttachedToID: &vmID})
+					return
+				}
+				json.NewEncoder(w).Encode(tt.detached)
+			}))
+			defer server.Close()
+
+			client := newTestClient(server)
+			err := client.WaitForVolumeDetachment(context.Background(), 123, time.Minute)
+			if !errors.Is(err, tt.expectedErr) || (tt.expectedErr == nil && err != nil) {
+				t.Errorf("expected %v, got %v", tt.expectedErr, err)
+			}
+			if polls != 4 {
+				t.Errorf("expected 4 polls, got %d", polls)
+			}
+		})
+	}
+}
+
+// TestCreateVolumeDataCenterUnavailable tests that only capacity error codes are retryable elsewhere
+func TestCreateVolumeDataCenterUnavailable(t *testing.T) {
+	tests := []struct {
+		name        string
+		statusCode  int
+		body        string
+		unavailable bool
+	}{
+		{name: "server error", statusCode: http.StatusGatewayTimeout, body: "gateway timeout", unavailable: false},
+		{name: "out of capacity code", statusCode: http.StatusUnprocessableEntity, body: `{"error":{"code":"OUT_OF_CAPACITY","message":"Datacenter is full"}}`, unavailable: true},
+		{name: "insufficient capacity code", statusCode: http.StatusConflict, body: `{"code":"INSUFFICIENT_CAPACITY","message":"No capacity left"}`, unavailable: true},
+		{name: "capacity in free text", statusCode: http.StatusBadRequest, body: `{"code":"INVALID_PARAMETER","message":"capacity must be at least 1GB"}`, unavailable: false},
+		{name: "bad request", statusCode: http.StatusBadRequest, body: "invalid size", unavailable: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statusCode)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			client := newTestClient(server)
+			_, err := client.CreateVolume(context.Background(), "test", 10, "ssd", "aws-eu-west-2", nil)
+			if err == nil {
+				t.Fatal("expected error but got none")
+			}
+			if errors.Is(err, ErrDataCenterUnavailable) != tt.unavailable {
+				t.Errorf("expected unavailable=%v, got error %v", tt.unavailable, err)
+			}
+		})
+	}
+}
+
 // TestGetAccessToken tests token management
 func TestGetAccessToken(t *testing.T) {
 	t.Run("valid token", func(t *testing.T) {
@@ -411,3 +707,219 @@ func TestGetAccessToken(t *testing.T) {
 		}
 	})
 }
+
+// TestConcurrentSDKAccess exercises concurrent SDK lookups while the token is refreshed.
+// Run with -race to detect unsynchronized access to shared client state.
+func TestConcurrentSDKAccess(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/issue-token"), strings.HasSuffix(r.URL.Path, "/refresh-token"):
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"accessToken":  "refreshed-token",
+				"refreshToken": "refresh-token",
+				"expiresIn":    3600,
+			})
+		case r.URL.Path == "/v1/data-centers":
+			json.NewEncoder(w).Encode([]map[string]interface{}{{"id": "aws-eu-west-2"}})
+		case r.URL.Path == "/v1/vms":
+			json.NewEncoder(w).Encode([]map[string]interface{}{{"id": 1}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := newTestSDKClient(server)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := client.GetDataCenters(ctx); err != nil {
+				errs <- err
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			if i%5 == 0 {
+				client.invalidateAccessToken()
+			}
+			if _, err := client.ListVMs(ctx); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// TestRequestIDInErrors tests that Emma's request ID is carried by API errors
+func TestRequestIDInErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		status     int
+		expectedID string
+	}{
+		{"request id header", "X-Request-Id", http.StatusInternalServerError, "req-123"},
+		{"correlation id header", "X-Correlation-Id", http.StatusBadRequest, "corr-456"},
+		{"forbidden", "X-Request-Id", http.StatusForbidden, "req-789"},
+		{"no header", "", http.StatusInternalServerError, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.header != "" {
+					w.Header().Set(tt.header, tt.expectedID)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(`{"message":"boom"}`))
+			}))
+			defer server.Close()
+
+			client := newTestClient(server)
+			_, err := client.GetVolume(context.Background(), 1)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if got := logging.RequestIDFromError(err); got != tt.expectedID {
+				t.Errorf("expected request ID %q, got %q", tt.expectedID, got)
+			}
+			if tt.expectedID != "" && !strings.Contains(err.Error(), tt.expectedID) {
+				t.Errorf("expected error message to contain %q, got %v", tt.expectedID, err)
+			}
+			if tt.status == http.StatusForbidden && !errors.Is(err, ErrPermissionDenied) {
+				t.Errorf("expected ErrPermissionDenied to survive annotation, got %v", err)
+			}
+		})
+	}
+}
+
+// TestRequestIDIgnoresEchoedClientID tests that a response echoing the client request ID
+// in X-Request-Id is not reported as Emma's request ID
+func TestRequestIDIgnoresEchoedClientID(t *testing.T) {
+	tests := []struct {
+		name        string
+		correlation string
+		expectedID  string
+	}{
+		{name: "echo only", expectedID: ""},
+		{name: "echo and correlation id", correlation: "corr-1", expectedID: "corr-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Request-Id", r.Header.Get(ClientRequestIDHeader))
+				if tt.correlation != "" {
+					w.Header().Set("X-Correlation-Id", tt.correlation)
+				}
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`{"message":"boom"}`))
+			}))
+			defer server.Close()
+
+			client := newTestClient(server)
+			_, err := client.GetVolume(context.Background(), 1)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if got := logging.RequestIDFromError(err); got != tt.expectedID {
+				t.Errorf("expected request ID %q, got %q", tt.expectedID, got)
+			}
+		})
+	}
+}
+
+// TestRequestHeaders tests that SDK and raw requests carry the User-Agent and a unique client request ID
+func TestRequestHeaders(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ua := r.Header.Get("User-Agent"); ua != "emma-csi-driver/v9.9.9" {
+			t.Errorf("%s: User-Agent = %q", r.URL.Path, ua)
+		}
+		id := r.Header.Get(ClientRequestIDHeader)
+		mu.Lock()
+		if path, ok := seen[id]; ok || id == "" {
+			t.Errorf("%s: client request ID %q missing or already used by %s", r.URL.Path, id, path)
+		}
+		seen[id] = r.URL.Path
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/issue-token":
+			_, _ = w.Write([]byte(`{"accessToken":"token","refreshToken":"refresh","expiresIn":600}`))
+		default:
+			_ = json.NewEncoder(w).Encode(VolumeResponse{ID: 42, Status: "AVAILABLE"})
+		}
+	}))
+	defer server.Close()
+
+	SetVersion("v9.9.9")
+	defer SetVersion("dev")
+
+	client, err := NewClientWithTransport(server.URL, "client-id", "client-secret", nil)
+	if err != nil {
+		t.Fatalf("NewClientWithTransport() error = %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetVolume(context.Background(), 42); err != nil {
+			t.Fatalf("GetVolume() error = %v", err)
+		}
+	}
+	if len(seen) != 3 {
+		t.Errorf("expected 3 requests with distinct IDs, got %v", seen)
+	}
+}
+
+// TestCSIRequestIDHeader tests that requests carry the CSI request ID of their context
+func TestCSIRequestIDHeader(t *testing.T) {
+	tests := []struct {
+		name         string
+		csiRequestID string
+	}{
+		{name: "CSI call", csiRequestID: "6f1c2d3e-csi-call"},
+		{name: "no CSI call"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get(CSIRequestIDHeader); r.URL.Path != "/v1/issue-token" && got != tt.csiRequestID {
+					t.Errorf("%s: %s = %q, expected %q", r.URL.Path, CSIRequestIDHeader, got, tt.csiRequestID)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				switch r.URL.Path {
+				case "/v1/issue-token":
+					_, _ = w.Write([]byte(`{"accessToken":"token","refreshToken":"refresh","expiresIn":600}`))
+				default:
+					_ = json.NewEncoder(w).Encode(VolumeResponse{ID: 42, Status: "AVAILABLE"})
+				}
+			}))
+			defer server.Close()
+
+			client, err := NewClientWithTransport(server.URL, "client-id", "client-secret", nil)
+			if err != nil {
+				t.Fatalf("NewClientWithTransport() error = %v", err)
+			}
+			ctx := context.Background()
+			if tt.csiRequestID != "" {
+				ctx = logging.WithCSIRequestID(ctx, tt.csiRequestID)
+			}
+			if _, err := client.GetVolume(ctx, 42); err != nil {
+				t.Fatalf("GetVolume() error = %v", err)
+			}
+		})
+	}
+}
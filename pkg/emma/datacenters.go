@@ -0,0 +1,106 @@
+package emma
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	emma "github.com/emma-community/emma-go-sdk"
+)
+
+// DefaultDataCenterCacheTTL is how long datacenter lookups are served from the cache
+const DefaultDataCenterCacheTTL = 10 * time.Minute
+
+// dataCenterCacheJitter spreads the expiry of entries cached together by up to this
+// fraction of the TTL, so they are not refreshed in one burst
+const dataCenterCacheJitter = 0.1
+
+// dataCenterCache holds datacenter metadata, which rarely changes, so a burst of volume
+// creations validates its datacenter once. Only datacenters that exist are cached; a
+// lookup of an unknown ID always asks the Emma API.
+type dataCenterCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]dataCenterEntry
+	now     func() time.Time
+}
+
+// dataCenterEntry is a cached datacenter and its expiry
+type dataCenterEntry struct {
+	dataCenter emma.DataCenter
+	expires    time.Time
+}
+
+// SetDataCenterCacheTTL sets how long datacenter lookups and volume configurations are
+// cached, 0 disables the cache. It must be called before the client is used.
+func (c *Client) SetDataCenterCacheTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		c.dataCenters = nil
+		c.volumeConfigs = nil
+		return
+	}
+	c.dataCenters = newDataCenterCache(ttl)
+	c.volumeConfigs = &volumeConfigsCache{}
+}
+
+// newDataCenterCache creates an empty datacenter cache
+func newDataCenterCache(ttl time.Duration) *dataCenterCache {
+	return &dataCenterCache{
+		ttl:     ttl,
+		entries: make(map[string]dataCenterEntry),
+		now:     time.Now,
+	}
+}
+
+// get returns a cached datacenter that has not expired
+func (d *dataCenterCache) get(id string) (*emma.DataCenter, bool) {
+	if d == nil {
+		return nil, false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entry, ok := d.entries[id]
+	if !ok || !d.now().Before(entry.expires) {
+		return nil, false
+	}
+	dataCenter := entry.dataCenter
+	return &dataCenter, true
+}
+
+// replace caches the full list of datacenters, dropping those no longer listed
+func (d *dataCenterCache) replace(dataCenters []emma.DataCenter) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	d.entries = make(map[string]dataCenterEntry, len(dataCenters))
+	d.mu.Unlock()
+	d.put(dataCenters...)
+}
+
+// put caches datacenters with a jittered expiry
+func (d *dataCenterCache) put(dataCenters ...emma.DataCenter) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	for _, dataCenter := range dataCenters {
+		if dataCenter.GetId() == "" {
+			continue
+		}
+		jitter := time.Duration(rand.Int63n(int64(float64(d.ttl)*dataCenterCacheJitter) + 1))
+		d.entries[dataCenter.GetId()] = dataCenterEntry{dataCenter: dataCenter, expires: now.Add(d.ttl + jitter)}
+	}
+}
+
+// invalidate drops a datacenter the Emma API no longer knows
+func (d *dataCenterCache) invalidate(id string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.entries, id)
+}
@@ -0,0 +1,94 @@
+package emma
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestDataCenterCache tests that datacenter validations are served from the cache until they expire
+func TestDataCenterCache(t *testing.T) {
+	var lookups, lists atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/v1/data-centers":
+			lists.Add(1)
+			_, _ = w.Write([]byte(`[{"id":"aws-eu-central-1"},{"id":"gcp-europe-west3"}]`))
+		case strings.HasPrefix(r.URL.Path, "/v1/data-centers/"):
+			lookups.Add(1)
+			id := strings.TrimPrefix(r.URL.Path, "/v1/data-centers/")
+			if id == "missing" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte(`{"id":"` + id + `"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := newTestSDKClient(server)
+	client.SetDataCenterCacheTTL(time.Minute)
+	now := time.Now()
+	client.dataCenters.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if err := client.ValidateDataCenter(ctx, "aws-eu-west-1"); err != nil {
+			t.Fatalf("ValidateDataCenter() error = %v", err)
+		}
+	}
+	if got := lookups.Load(); got != 1 {
+		t.Errorf("expected 1 lookup for repeated validations, got %d", got)
+	}
+
+	// Entries expire after the TTL and its jitter
+	now = now.Add(time.Minute + 7*time.Second)
+	if err := client.ValidateDataCenter(ctx, "aws-eu-west-1"); err != nil {
+		t.Fatalf("ValidateDataCenter() error = %v", err)
+	}
+	if got := lookups.Load(); got != 2 {
+		t.Errorf("expected an expired entry to be looked up again, got %d lookups", got)
+	}
+
+	// Unknown datacenters are never cached
+	for i := 0; i < 2; i++ {
+		if err := client.ValidateDataCenter(ctx, "missing"); err == nil {
+			t.Error("expected an error for a missing datacenter")
+		}
+	}
+	if got := lookups.Load(); got != 4 {
+		t.Errorf("expected missing datacenters to be looked up each time, got %d lookups", got)
+	}
+
+	// Listing always asks the API and fills the cache
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetDataCenters(ctx); err != nil {
+			t.Fatalf("GetDataCenters() error = %v", err)
+		}
+	}
+	if err := client.ValidateDataCenter(ctx, "gcp-europe-west3"); err != nil {
+		t.Fatalf("ValidateDataCenter() error = %v", err)
+	}
+	if got := lists.Load(); got != 2 {
+		t.Errorf("expected every listing to reach the API, got %d", got)
+	}
+	if got := lookups.Load(); got != 4 {
+		t.Errorf("expected a listed datacenter to be served from the cache, got %d lookups", got)
+	}
+
+	// A disabled cache asks the API every time
+	client.SetDataCenterCacheTTL(0)
+	if err := client.ValidateDataCenter(ctx, "gcp-europe-west3"); err != nil {
+		t.Fatalf("ValidateDataCenter() error = %v", err)
+	}
+	if got := lookups.Load(); got != 5 {
+		t.Errorf("expected a lookup with the cache disabled, got %d lookups", got)
+	}
+}
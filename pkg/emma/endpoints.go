@@ -0,0 +1,175 @@
+package emma
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultEndpointCooldown is how long a regional endpoint is bypassed after it fails
+const DefaultEndpointCooldown = time.Minute
+
+// regionalEndpoints routes volume requests to per-datacenter API base URLs. A regional
+// endpoint that fails is bypassed for a cooldown period, during which requests go to the
+// global endpoint.
+type regionalEndpoints struct {
+	mu             sync.Mutex
+	urls           map[string]string
+	unhealthyUntil map[string]time.Time
+	volumes        map[int32]string
+	cooldown       time.Duration
+	now            func() time.Time
+}
+
+// ParseRegionalEndpoints parses a comma-separated list of datacenter=URL pairs
+func ParseRegionalEndpoints(value string) (map[string]string, error) {
+	endpoints := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		dataCenterID, rawURL, ok := strings.Cut(entry, "=")
+		dataCenterID = strings.TrimSpace(dataCenterID)
+		rawURL = strings.TrimRight(strings.TrimSpace(rawURL), "/")
+		if !ok || dataCenterID == "" || rawURL == "" {
+			return nil, fmt.Errorf("invalid endpoint %q: expected datacenter=URL", entry)
+		}
+		parsed, err := url.Parse(rawURL)
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid endpoint URL %q for datacenter %s", rawURL, dataCenterID)
+		}
+		if _, exists := endpoints[dataCenterID]; exists {
+			return nil, fmt.Errorf("duplicate endpoint for datacenter %s", dataCenterID)
+		}
+		endpoints[dataCenterID] = rawURL
+	}
+	return endpoints, nil
+}
+
+// SetRegionalEndpoints routes volume operations for the given datacenters to regional API
+// base URLs. Requests for other datacenters, and requests made while a regional endpoint
+// is failing, use the global base URL.
+func (c *Client) SetRegionalEndpoints(endpoints map[string]string, cooldown time.Duration) {
+	if len(endpoints) == 0 {
+		c.endpoints = nil
+		return
+	}
+	if cooldown <= 0 {
+		cooldown = DefaultEndpointCooldown
+	}
+	c.endpoints = &regionalEndpoints{
+		urls:           endpoints,
+		unhealthyUntil: make(map[string]time.Time),
+		volumes:        make(map[int32]string),
+		cooldown:       cooldown,
+		now:            time.Now,
+	}
+}
+
+// baseURL returns the regional base URL for a datacenter, or "" when the datacenter has
+// no regional endpoint or it is cooling down after a failure
+func (e *regionalEndpoints) baseURL(dataCenterID string) string {
+	if e == nil || dataCenterID == "" {
+		return ""
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	regionalURL, ok := e.urls[dataCenterID]
+	if !ok {
+		return ""
+	}
+	if until, failed := e.unhealthyUntil[dataCenterID]; failed {
+		if e.now().Before(until) {
+			return ""
+		}
+		delete(e.unhealthyUntil, dataCenterID)
+	}
+	return regionalURL
+}
+
+// markUnhealthy bypasses a datacenter's regional endpoint for the cooldown period
+func (e *regionalEndpoints) markUnhealthy(dataCenterID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.unhealthyUntil[dataCenterID] = e.now().Add(e.cooldown)
+}
+
+// rememberVolume records a volume's datacenter so later requests by volume ID can be routed
+func (e *regionalEndpoints) rememberVolume(volume *VolumeResponse) {
+	if e == nil || volume == nil || volume.DataCenterID == "" {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.volumes[volume.ID] = volume.DataCenterID
+}
+
+// forgetVolume drops a deleted volume's datacenter
+func (e *regionalEndpoints) forgetVolume(volumeID int32) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.volumes, volumeID)
+}
+
+// volumeDataCenter returns the datacenter of a volume, if known
+func (e *regionalEndpoints) volumeDataCenter(volumeID int32) string {
+	if e == nil {
+		return ""
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.volumes[volumeID]
+}
+
+// regionalFailure reports whether a regional endpoint response means the endpoint is unhealthy.
+// An error caused by the caller's own cancellation or deadline says nothing about the endpoint.
+func regionalFailure(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		return ctx.Err() == nil
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// withoutRegionalRetries leaves the failures regionalFailure detects to the fallback to
+// the global endpoint, instead of retrying an unhealthy regional endpoint first
+func (c *Client) withoutRegionalRetries(ctx context.Context) context.Context {
+	policy, ok := ctx.Value(retryPolicyKey{}).(RetryPolicy)
+	if !ok {
+		if c.retry == nil {
+			return ctx
+		}
+		policy = c.retry.policy
+	}
+
+	policy.RetryErrors = false
+	var statuses []int
+	for _, status := range policy.Statuses {
+		if !regionalFailure(ctx, &http.Response{StatusCode: status}, nil) {
+			statuses = append(statuses, status)
+		}
+	}
+	policy.Statuses = statuses
+	return withRetryPolicy(ctx, policy)
+}
+
+// idempotentMethod reports whether a request can be safely resent to another endpoint
+func idempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodDelete:
+		return true
+	}
+	return false
+}
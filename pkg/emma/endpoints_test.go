@@ -0,0 +1,147 @@
+package emma
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestParseRegionalEndpoints tests parsing of datacenter=URL endpoint mappings
+func TestParseRegionalEndpoints(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		expected    map[string]string
+		expectError bool
+	}{
+		{name: "empty", value: "", expected: map[string]string{}},
+		{
+			name:  "multiple",
+			value: "aws-eu-central-1=https://eu.api.example.com/external/, gcp-us-east1=https://us.api.example.com/external",
+			expected: map[string]string{
+				"aws-eu-central-1": "https://eu.api.example.com/external",
+				"gcp-us-east1":     "https://us.api.example.com/external",
+			},
+		},
+		{name: "missing URL", value: "aws-eu-central-1=", expectError: true},
+		{name: "missing separator", value: "https://eu.api.example.com", expectError: true},
+		{name: "invalid scheme", value: "dc=ftp://eu.api.example.com", expectError: true},
+		{name: "duplicate", value: "dc=https://a.example.com,dc=https://b.example.com", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRegionalEndpoints(tt.value)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != len(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, got)
+			}
+			for dc, url := range tt.expected {
+				if got[dc] != url {
+					t.Errorf("expected %s for %s, got %s", url, dc, got[dc])
+				}
+			}
+		})
+	}
+}
+
+// TestRegionalEndpointFallback tests routing to a regional endpoint and falling back to
+// the global endpoint while it fails
+func TestRegionalEndpointFallback(t *testing.T) {
+	var regionalHits, globalHits atomic.Int32
+	var regionalDown atomic.Bool
+
+	regional := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		regionalHits.Add(1)
+		if regionalDown.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"id": 7, "status": "AVAILABLE", "dataCenterId": "dc-1"}`))
+	}))
+	defer regional.Close()
+
+	global := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		globalHits.Add(1)
+		w.Write([]byte(`{"id": 7, "status": "AVAILABLE", "dataCenterId": "dc-1"}`))
+	}))
+	defer global.Close()
+
+	client := newTestClient(global)
+	client.SetRegionalEndpoints(map[string]string{"dc-1": regional.URL}, time.Hour)
+
+	// The volume's datacenter is unknown until it is first seen
+	if _, err := client.GetVolume(context.Background(), 7); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if globalHits.Load() != 1 || regionalHits.Load() != 0 {
+		t.Fatalf("expected first lookup on the global endpoint, got global=%d regional=%d", globalHits.Load(), regionalHits.Load())
+	}
+
+	if _, err := client.GetVolume(context.Background(), 7); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if regionalHits.Load() != 1 {
+		t.Fatalf("expected lookup on the regional endpoint, got %d regional hits", regionalHits.Load())
+	}
+
+	// A failing regional endpoint falls back to the global one and is then bypassed
+	regionalDown.Store(true)
+	if _, err := client.GetVolume(context.Background(), 7); err != nil {
+		t.Fatalf("expected fallback to succeed, got %v", err)
+	}
+	if _, err := client.GetVolume(context.Background(), 7); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if regionalHits.Load() != 2 || globalHits.Load() != 3 {
+		t.Errorf("expected regional endpoint bypassed after failure, got global=%d regional=%d", globalHits.Load(), regionalHits.Load())
+	}
+
+	// The regional endpoint is used again after the cooldown
+	regionalDown.Store(false)
+	client.endpoints.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, err := client.GetVolume(context.Background(), 7); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if regionalHits.Load() != 3 {
+		t.Errorf("expected regional endpoint used after cooldown, got %d regional hits", regionalHits.Load())
+	}
+}
+
+// TestRegionalEndpointCallerCancel tests that a request the caller gives up on does not mark
+// the regional endpoint unhealthy
+func TestRegionalEndpointCallerCancel(t *testing.T) {
+	regional := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer regional.Close()
+
+	global := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 7, "status": "AVAILABLE", "dataCenterId": "dc-1"}`))
+	}))
+	defer global.Close()
+
+	client := newTestClient(global)
+	client.SetRegionalEndpoints(map[string]string{"dc-1": regional.URL}, time.Hour)
+	client.endpoints.rememberVolume(&VolumeResponse{ID: 7, DataCenterID: "dc-1"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.GetVolume(ctx, 7); err == nil {
+		t.Fatal("expected the caller's deadline to fail the request")
+	}
+	if got := client.endpoints.baseURL("dc-1"); got != regional.URL {
+		t.Errorf("expected the regional endpoint to stay healthy, got base URL %q", got)
+	}
+}
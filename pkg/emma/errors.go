@@ -0,0 +1,208 @@
+package emma
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrorCategory classifies Emma API errors, so callers can react to the kind of failure
+// without matching status codes or message text
+type ErrorCategory string
+
+// Error categories
+const (
+	CategoryInvalidRequest     ErrorCategory = "InvalidRequest"
+	CategoryUnauthenticated    ErrorCategory = "Unauthenticated"
+	CategoryPermissionDenied   ErrorCategory = "PermissionDenied"
+	CategoryNotFound           ErrorCategory = "NotFound"
+	CategoryConflict           ErrorCategory = "Conflict"
+	CategoryFailedPrecondition ErrorCategory = "FailedPrecondition"
+	CategoryQuotaExceeded      ErrorCategory = "QuotaExceeded"
+	CategoryRateLimited        ErrorCategory = "RateLimited"
+	CategoryTimeout            ErrorCategory = "Timeout"
+	CategoryUnavailable        ErrorCategory = "Unavailable"
+	CategoryInternal           ErrorCategory = "Internal"
+	CategoryUnknown            ErrorCategory = "Unknown"
+)
+
+// quotaMarkers are phrases in Emma error codes and messages that mean the account or
+// datacenter is out of quota or capacity, which retrying the same request will not fix soon
+var quotaMarkers = []string{"quota", "limit exceeded", "limit_exceeded", "insufficient capacity", "out of capacity"}
+
+// errorCodeCategories maps Emma error codes to categories where the code is more precise
+// than the HTTP status
+var errorCodeCategories = map[string]ErrorCategory{
+	"INVALID_REQUEST":       CategoryInvalidRequest,
+	"INVALID_PARAMETER":     CategoryInvalidRequest,
+	"UNPROCESSABLE_ENTITY":  CategoryInvalidRequest,
+	"NOT_FOUND":             CategoryNotFound,
+	"CONFLICT":              CategoryConflict,
+	"ALREADY_ATTACHED":      CategoryFailedPrecondition,
+	"QUOTA_EXCEEDED":        CategoryQuotaExceeded,
+	"INSUFFICIENT_CAPACITY": CategoryQuotaExceeded,
+	"OUT_OF_CAPACITY":       CategoryQuotaExceeded,
+	"RATE_LIMIT_EXCEEDED":   CategoryRateLimited,
+	"SERVICE_UNAVAILABLE":   CategoryUnavailable,
+	"INTERNAL_ERROR":        CategoryInternal,
+}
+
+// capacityErrorCodes are the Emma error codes for a datacenter that cannot host more
+// volumes, where another datacenter may still succeed
+var capacityErrorCodes = map[string]bool{
+	"INSUFFICIENT_CAPACITY": true,
+	"OUT_OF_CAPACITY":       true,
+}
+
+// APIError is an unsuccessful response of the Emma API, with Emma's JSON error payload
+// parsed when the response has one
+type APIError struct {
+	// Op is the failed operation, such as "create volume", or empty for SDK calls whose
+	// callers add the operation themselves
+	Op string
+
+	// StatusCode is the HTTP status of the response
+	StatusCode int
+
+	// Code and Message are the machine-readable error code and description from the
+	// payload, empty when the body is not an Emma error payload
+	Code    string
+	Message string
+
+	// Body is the raw response body
+	Body string
+
+	// requestID is the Emma request ID from the response headers or payload
+	requestID string
+}
+
+func (e *APIError) Error() string {
+	var b strings.Builder
+	if e.Op != "" {
+		fmt.Fprintf(&b, "failed to %s: ", e.Op)
+	}
+	fmt.Fprintf(&b, "status %d", e.StatusCode)
+	switch {
+	case e.Code != "" && e.Message != "":
+		fmt.Fprintf(&b, ": %s: %s", e.Code, e.Message)
+	case e.Message != "":
+		fmt.Fprintf(&b, ": %s", e.Message)
+	default:
+		fmt.Fprintf(&b, ", body: %s", e.Body)
+	}
+	if e.requestID != "" {
+		fmt.Fprintf(&b, " (Emma request ID: %s)", e.requestID)
+	}
+	return b.String()
+}
+
+// RequestID returns the Emma request ID, picked up by structured logging
+func (e *APIError) RequestID() string {
+	return e.requestID
+}
+
+// outOfCapacity reports whether Emma's error code says the datacenter is out of capacity
+func (e *APIError) outOfCapacity() bool {
+	return capacityErrorCodes[strings.ToUpper(e.Code)]
+}
+
+// Category classifies the error by its Emma error code, message and HTTP status
+func (e *APIError) Category() ErrorCategory {
+	text := strings.ToLower(e.Code + " " + e.Message)
+	if e.Code == "" && e.Message == "" {
+		text = strings.ToLower(e.Body)
+	}
+	for _, marker := range quotaMarkers {
+		if strings.Contains(text, marker) {
+			return CategoryQuotaExceeded
+		}
+	}
+	if category, ok := errorCodeCategories[strings.ToUpper(e.Code)]; ok {
+		return category
+	}
+
+	switch e.StatusCode {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return CategoryInvalidRequest
+	case http.StatusUnauthorized:
+		return CategoryUnauthenticated
+	case http.StatusForbidden:
+		return CategoryPermissionDenied
+	case http.StatusNotFound:
+		return CategoryNotFound
+	case http.StatusConflict:
+		return CategoryConflict
+	case http.StatusPreconditionFailed:
+		return CategoryFailedPrecondition
+	case http.StatusTooManyRequests:
+		return CategoryRateLimited
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return CategoryTimeout
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return CategoryUnavailable
+	case http.StatusInternalServerError:
+		return CategoryInternal
+	}
+	return CategoryUnknown
+}
+
+// errorPayload is Emma's JSON error response. Most endpoints nest the fields in an
+// "error" object, some return them at the top level.
+type errorPayload struct {
+	Error *errorFields `json:"error"`
+	errorFields
+}
+
+// errorFields are the fields of an Emma error payload
+type errorFields struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Detail    string `json:"detail"`
+	RequestID string `json:"requestId"`
+}
+
+// newAPIError returns the error for an unsuccessful response, parsing Emma's error payload
+// and taking the request ID from the response headers, or else from the payload
+func newAPIError(op string, resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{Op: op, StatusCode: resp.StatusCode, Body: string(body), requestID: RequestID(resp)}
+
+	var payload errorPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return apiErr
+	}
+	fields := payload.errorFields
+	if payload.Error != nil {
+		fields = *payload.Error
+	}
+	apiErr.Code = fields.Code
+	apiErr.Message = fields.Message
+	if apiErr.Message == "" {
+		apiErr.Message = fields.Detail
+	}
+	if apiErr.requestID == "" {
+		apiErr.requestID = fields.RequestID
+	}
+	return apiErr
+}
+
+// HTTPStatus returns the HTTP status of the Emma API response behind err, or 0 when err
+// is not an Emma API error response
+func HTTPStatus(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// Category returns the category of the Emma API error behind err, or CategoryUnknown when
+// err is not an Emma API error response
+func Category(err error) ErrorCategory {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Category()
+	}
+	return CategoryUnknown
+}
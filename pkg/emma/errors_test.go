@@ -0,0 +1,103 @@
+package emma
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/emma-csi-driver/pkg/logging"
+)
+
+// TestNewAPIError tests parsing Emma error payloads
+func TestNewAPIError(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		header    string
+		body      string
+		code      string
+		message   string
+		requestID string
+		category  ErrorCategory
+	}{
+		{
+			name:     "nested payload",
+			status:   http.StatusBadRequest,
+			body:     `{"error":{"code":"INVALID_PARAMETER","message":"Volume size 15GB is not supported"}}`,
+			code:     "INVALID_PARAMETER",
+			message:  "Volume size 15GB is not supported",
+			category: CategoryInvalidRequest,
+		},
+		{
+			name:      "flat payload with request ID",
+			status:    http.StatusConflict,
+			body:      `{"code":"ALREADY_ATTACHED","message":"Volume is attached to VM 7","requestId":"req-1"}`,
+			code:      "ALREADY_ATTACHED",
+			message:   "Volume is attached to VM 7",
+			requestID: "req-1",
+			category:  CategoryFailedPrecondition,
+		},
+		{
+			name:      "header request ID wins",
+			status:    http.StatusServiceUnavailable,
+			header:    "req-header",
+			body:      `{"message":"maintenance","requestId":"req-body"}`,
+			message:   "maintenance",
+			requestID: "req-header",
+			category:  CategoryUnavailable,
+		},
+		{
+			name:     "problem detail",
+			status:   http.StatusUnprocessableEntity,
+			body:     `{"title":"Unprocessable","detail":"Volume quota exceeded for datacenter"}`,
+			message:  "Volume quota exceeded for datacenter",
+			category: CategoryQuotaExceeded,
+		},
+		{
+			name:     "plain text body",
+			status:   http.StatusTooManyRequests,
+			body:     "slow down",
+			category: CategoryRateLimited,
+		},
+		{
+			name:     "unknown status",
+			status:   http.StatusTeapot,
+			body:     "{}",
+			category: CategoryUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			if tt.header != "" {
+				resp.Header.Set("X-Request-Id", tt.header)
+			}
+			apiErr := newAPIError("create volume", resp, []byte(tt.body))
+			if apiErr.Code != tt.code || apiErr.Message != tt.message {
+				t.Errorf("expected code %q and message %q, got %q and %q", tt.code, tt.message, apiErr.Code, apiErr.Message)
+			}
+			if apiErr.RequestID() != tt.requestID {
+				t.Errorf("expected request ID %q, got %q", tt.requestID, apiErr.RequestID())
+			}
+			if apiErr.Category() != tt.category {
+				t.Errorf("expected category %s, got %s", tt.category, apiErr.Category())
+			}
+
+			// Wrapped errors keep their category and request ID
+			err := fmt.Errorf("%w: %w", ErrDataCenterUnavailable, apiErr)
+			if Category(err) != tt.category || logging.RequestIDFromError(err) != tt.requestID {
+				t.Errorf("expected category and request ID to survive wrapping, got %s and %q", Category(err), logging.RequestIDFromError(err))
+			}
+			if tt.message != "" && !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("expected the message in %q", err.Error())
+			}
+		})
+	}
+
+	if Category(errors.New("connection refused")) != CategoryUnknown {
+		t.Error("expected errors other than API errors to be uncategorized")
+	}
+}
@@ -0,0 +1,172 @@
+package emma
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// DefaultAccount is the account label of the client built from the controller's own credentials
+const DefaultAccount = "default"
+
+// PoolOptions configures the clients created by a ClientPool
+type PoolOptions struct {
+	// TLS secures every client's connections
+	TLS TLSOptions
+
+	// QPS and Burst bound each account's raw API requests, 0 QPS is unlimited
+	QPS   float32
+	Burst int
+
+	// Configure is applied to each new client, e.g. to set regional endpoints
+	Configure func(*Client)
+}
+
+// ClientPool holds one Emma client per set of credentials, so a controller can serve
+// StorageClasses mapping to different Emma accounts. Each account has its own access
+// token, request rate limit and metrics label; callers using the same credentials share
+// them.
+type ClientPool struct {
+	mu      sync.Mutex
+	baseURL string
+	opts    PoolOptions
+	clients map[string]*Client
+
+	// pending holds the clients being created, so concurrent requests with the same
+	// credentials authenticate once without blocking other accounts
+	pending map[string]*poolCall
+
+	// newClient is replaceable in tests
+	newClient func(baseURL, clientID, clientSecret string, tlsOpts TLSOptions) (*Client, error)
+}
+
+// poolCall is the creation of a client for a credential key, shared by concurrent callers
+type poolCall struct {
+	done   chan struct{}
+	client *Client
+	err    error
+}
+
+// NewClientPool creates an empty client pool for an Emma API base URL
+func NewClientPool(baseURL string, opts PoolOptions) *ClientPool {
+	return &ClientPool{
+		baseURL:   baseURL,
+		opts:      opts,
+		clients:   make(map[string]*Client),
+		pending:   make(map[string]*poolCall),
+		newClient: NewClientWithTLSOptions,
+	}
+}
+
+// CredentialKey identifies a set of credentials without revealing the secret
+func CredentialKey(clientID, clientSecret string) string {
+	sum := sha256.Sum256([]byte(clientID + "\x00" + clientSecret))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Add registers an existing client for its credentials under an account label
+func (p *ClientPool) Add(clientID, clientSecret, account string, client *Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prepare(client, account)
+	p.clients[CredentialKey(clientID, clientSecret)] = client
+}
+
+// Get returns the client for a set of credentials, creating and authenticating it on
+// first use. New clients are labeled with the credential key in metrics. The pool is not
+// locked while a client authenticates, concurrent callers with the same credentials wait
+// for the one creating it.
+func (p *ClientPool) Get(ctx context.Context, clientID, clientSecret string) (*Client, error) {
+	if clientID == "" || clientSecret == "" {
+		return nil, fmt.Errorf("client ID and secret are required")
+	}
+	key := CredentialKey(clientID, clientSecret)
+
+	p.mu.Lock()
+	if client, ok := p.clients[key]; ok {
+		p.mu.Unlock()
+		return client, nil
+	}
+	call, creating := p.pending[key]
+	if !creating {
+		if err := ctx.Err(); err != nil {
+			p.mu.Unlock()
+			return nil, err
+		}
+		call = &poolCall{done: make(chan struct{})}
+		p.pending[key] = call
+	}
+	p.mu.Unlock()
+
+	if !creating {
+		p.create(call, key, clientID, clientSecret)
+	}
+	select {
+	case <-call.done:
+		return call.client, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// create creates and authenticates the client of a pending call and completes the call
+func (p *ClientPool) create(call *poolCall, key, clientID, clientSecret string) {
+	client, err := p.newClient(p.baseURL, clientID, clientSecret, p.opts.TLS)
+
+	p.mu.Lock()
+	delete(p.pending, key)
+	if err != nil {
+		call.err = fmt.Errorf("failed to create Emma client for account %s: %w", key, err)
+	} else {
+		p.prepare(client, key)
+		p.clients[key] = client
+		call.client = client
+	}
+	p.mu.Unlock()
+	close(call.done)
+
+	if err == nil {
+		client.logger.Info("Emma client added to pool", map[string]interface{}{"account": key})
+	}
+}
+
+// Len returns the number of accounts in the pool
+func (p *ClientPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.clients)
+}
+
+// prepare applies the pool options to a client
+func (p *ClientPool) prepare(client *Client, account string) {
+	client.account = account
+	client.SetRateLimit(p.opts.QPS, p.opts.Burst)
+	if p.opts.Configure != nil {
+		p.opts.Configure(client)
+	}
+}
+
+// SetRateLimit bounds the client's raw API requests to qps with bursts up to burst.
+// A qps of 0 or less removes the limit. It must be called before the client is used.
+func (c *Client) SetRateLimit(qps float32, burst int) {
+	if qps <= 0 {
+		c.limiter = nil
+		return
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	c.limiter = flowcontrol.NewTokenBucketRateLimiter(qps, burst)
+}
+
+// accountLabel returns the metrics label of the client's account
+func (c *Client) accountLabel() string {
+	if c.account == "" {
+		return DefaultAccount
+	}
+	return c.account
+}
@@ -0,0 +1,164 @@
+package emma
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emma-csi-driver/pkg/logging"
+)
+
+func newTestPool(t *testing.T, opts PoolOptions) (*ClientPool, *int) {
+	t.Helper()
+	created := 0
+	pool := NewClientPool("https://api.example.com", opts)
+	pool.newClient = func(baseURL, clientID, clientSecret string, tlsOpts TLSOptions) (*Client, error) {
+		created++
+		return &Client{baseURL: baseURL, logger: logging.NewLogger("test-pool")}, nil
+	}
+	return pool, &created
+}
+
+func TestClientPoolReusesClientPerCredentials(t *testing.T) {
+	pool, created := newTestPool(t, PoolOptions{})
+	ctx := context.Background()
+
+	a1, err := pool.Get(ctx, "id-a", "secret-a")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	a2, _ := pool.Get(ctx, "id-a", "secret-a")
+	b, _ := pool.Get(ctx, "id-a", "secret-b")
+
+	if a1 != a2 {
+		t.Error("expected the same client for the same credentials")
+	}
+	if a1 == b {
+		t.Error("expected different clients for different secrets")
+	}
+	if *created != 2 || pool.Len() != 2 {
+		t.Errorf("created = %d, Len() = %d, want 2 and 2", *created, pool.Len())
+	}
+	if a1.accountLabel() != CredentialKey("id-a", "secret-a") {
+		t.Errorf("account label = %q, want the credential key", a1.accountLabel())
+	}
+}
+
+func TestClientPoolAddAndConfigure(t *testing.T) {
+	configured := 0
+	pool, created := newTestPool(t, PoolOptions{
+		QPS:       5,
+		Burst:     2,
+		Configure: func(*Client) { configured++ },
+	})
+
+	existing := &Client{logger: logging.NewLogger("test-pool")}
+	pool.Add("id", "secret", DefaultAccount, existing)
+
+	got, err := pool.Get(context.Background(), "id", "secret")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got != existing || *created != 0 {
+		t.Error("expected the added client to be returned without creating a new one")
+	}
+	if got.accountLabel() != DefaultAccount || got.limiter == nil || configured != 1 {
+		t.Errorf("account = %q, limiter set = %v, configured = %d", got.accountLabel(), got.limiter != nil, configured)
+	}
+}
+
+func TestClientPoolAuthenticatesOutsideLock(t *testing.T) {
+	pool := NewClientPool("https://api.example.com", PoolOptions{})
+	release := make(chan struct{})
+	var mu sync.Mutex
+	created := map[string]int{}
+	pool.newClient = func(baseURL, clientID, clientSecret string, tlsOpts TLSOptions) (*Client, error) {
+		mu.Lock()
+		created[clientID]++
+		mu.Unlock()
+		if clientID == "id-slow" {
+			<-release
+		}
+		return &Client{baseURL: baseURL, logger: logging.NewLogger("test-pool")}, nil
+	}
+
+	results := make(chan *Client, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			client, err := pool.Get(context.Background(), "id-slow", "secret")
+			if err != nil {
+				t.Errorf("Get() error = %v", err)
+			}
+			results <- client
+		}()
+	}
+
+	// Another account is served while the slow one authenticates
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := pool.Get(ctx, "id-fast", "secret"); err != nil {
+		t.Fatalf("Get() for another account error = %v", err)
+	}
+
+	// A caller giving up does not wait for the slow authentication
+	canceled, cancelWait := context.WithCancel(context.Background())
+	cancelWait()
+	if _, err := pool.Get(canceled, "id-slow", "secret"); err == nil {
+		t.Error("expected an error for a canceled context")
+	}
+
+	close(release)
+	first, second := <-results, <-results
+	if first == nil || first != second {
+		t.Error("expected concurrent callers to share one client")
+	}
+	if created["id-slow"] != 1 {
+		t.Errorf("created %d clients for the same credentials, want 1", created["id-slow"])
+	}
+}
+
+func TestClientPoolRejectsEmptyCredentials(t *testing.T) {
+	pool, _ := newTestPool(t, PoolOptions{})
+	if _, err := pool.Get(context.Background(), "id", ""); err == nil {
+		t.Error("expected an error for an empty secret")
+	}
+}
+
+func TestCredentialKeyHidesSecret(t *testing.T) {
+	key := CredentialKey("id", "super-secret")
+	if key == CredentialKey("id", "other-secret") {
+		t.Error("expected different keys for different secrets")
+	}
+	if len(key) != 16 {
+		t.Errorf("key length = %d, want 16", len(key))
+	}
+}
+
+func TestClientRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(server)
+	client.SetRateLimit(10, 1)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := client.ListVolumes(context.Background()); err != nil {
+			t.Fatalf("ListVolumes() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("3 requests at 10 qps with burst 1 took %v, want at least 150ms", elapsed)
+	}
+
+	client.SetRateLimit(0, 0)
+	if client.limiter != nil {
+		t.Error("expected SetRateLimit(0) to remove the limit")
+	}
+}
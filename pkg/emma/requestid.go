@@ -0,0 +1,120 @@
+package emma
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"k8s.io/klog/v2"
+
+	"github.com/emma-csi-driver/pkg/logging"
+)
+
+// ClientRequestIDHeader carries the ID the driver assigns to each Emma API request, so a
+// request can be correlated between the driver's logs and Emma's
+const ClientRequestIDHeader = "X-Request-ID"
+
+// CSIRequestIDHeader carries the ID of the CSI call an Emma API request is made for, so
+// every request one CSI call triggers can be found in Emma's logs
+const CSIRequestIDHeader = "X-CSI-Request-ID"
+
+// userAgent identifies the driver to the Emma API
+var userAgent = "emma-csi-driver/dev"
+
+// SetVersion sets the driver version reported in the User-Agent of Emma API requests
+func SetVersion(version string) {
+	userAgent = "emma-csi-driver/" + version
+}
+
+// requestIDHeaders are the response headers that may carry Emma's server-side request
+// ID, in order of preference
+var requestIDHeaders = []string{"X-Request-Id", "X-Correlation-Id", "X-Trace-Id", "X-Amzn-Requestid"}
+
+// RequestID returns the server-side request ID of an Emma API response, or "". X-Request-Id
+// is also the header the driver sends its client request ID in, so a value that merely
+// echoes the ID that was sent is not taken as Emma's.
+func RequestID(resp *http.Response) string {
+	if resp == nil {
+		return ""
+	}
+	var sentID string
+	if resp.Request != nil {
+		sentID = resp.Request.Header.Get(ClientRequestIDHeader)
+	}
+	for _, header := range requestIDHeaders {
+		if id := resp.Header.Get(header); id != "" && id != sentID {
+			return id
+		}
+	}
+	return ""
+}
+
+// requestIDError annotates an API error with the request ID Emma assigned to the failed
+// request, so support tickets can reference the exact backend request
+type requestIDError struct {
+	err       error
+	requestID string
+}
+
+func (e *requestIDError) Error() string {
+	return fmt.Sprintf("%v (Emma request ID: %s)", e.err, e.requestID)
+}
+
+func (e *requestIDError) Unwrap() error {
+	return e.err
+}
+
+// RequestID returns the Emma request ID, picked up by structured logging
+func (e *requestIDError) RequestID() string {
+	return e.requestID
+}
+
+// withRequestID annotates err with the response's request ID, if it has one
+func withRequestID(err error, resp *http.Response) error {
+	if err == nil {
+		return nil
+	}
+	if id := RequestID(resp); id != "" {
+		return &requestIDError{err: err, requestID: id}
+	}
+	return err
+}
+
+// newClientRequestID generates the ID of a request to the Emma API
+func newClientRequestID() string {
+	return uuid.NewString()
+}
+
+// headerTransport sets the User-Agent, a client request ID and the CSI request ID of the
+// request context on every Emma API request, including the SDK's, and logs the SDK
+// requests it assigns an ID to
+type headerTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip sends a copy of the request with the driver's headers
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", userAgent)
+	csiRequestID := logging.CSIRequestIDFromContext(req.Context())
+	if csiRequestID != "" {
+		req.Header.Set(CSIRequestIDHeader, csiRequestID)
+	}
+
+	// Raw requests are assigned an ID and logged by sendRequest
+	id := req.Header.Get(ClientRequestIDHeader)
+	if id != "" {
+		return t.next.RoundTrip(req)
+	}
+	id = newClientRequestID()
+	req.Header.Set(ClientRequestIDHeader, id)
+	klog.V(4).Infof("Emma API request %s %s (request ID %s, CSI request ID %s)", req.Method, req.URL.Path, id, csiRequestID)
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		klog.V(4).Infof("Emma API request %s %s failed (request ID %s): %v", req.Method, req.URL.Path, id, err)
+		return nil, err
+	}
+	klog.V(4).Infof("Emma API response %s %s: %d (request ID %s, Emma request ID %s)", req.Method, req.URL.Path, resp.StatusCode, id, RequestID(resp))
+	return resp, nil
+}
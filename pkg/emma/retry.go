@@ -0,0 +1,271 @@
+package emma
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emma-csi-driver/pkg/metrics"
+	"k8s.io/klog/v2"
+)
+
+// maxRetryAfter caps the wait a Retry-After header can ask for
+const maxRetryAfter = time.Minute
+
+// RetryPolicy configures which Emma API requests a RetryTransport retries and how long
+// it waits between attempts
+type RetryPolicy struct {
+	// Statuses are the response status codes that are retried
+	Statuses []int
+
+	// StatusRetries caps the retries of individual status codes below MaxRetries
+	StatusRetries map[int]int
+
+	// MaxRetries bounds the retries of one request, 0 disables retries
+	MaxRetries int
+
+	// RetryErrors retries transport errors such as reset connections
+	RetryErrors bool
+
+	// NonIdempotent also retries methods other than GET, HEAD and DELETE, for requests
+	// that are safe to repeat such as VM actions rejected while the VM is busy
+	NonIdempotent bool
+
+	// InitialBackoff is the wait before the first retry without Retry-After, doubled for
+	// each further retry up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// Jitter adds a random wait of up to this fraction of the backoff
+	Jitter float64
+
+	// AttemptTimeout bounds each attempt including reading its response, 0 is unbounded
+	AttemptTimeout time.Duration
+}
+
+// DefaultRetryPolicy retries idempotent requests rejected with 429 or failing with a
+// gateway error or a transport error
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Statuses:       []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+		MaxRetries:     3,
+		RetryErrors:    true,
+		InitialBackoff: time.Second,
+		MaxBackoff:     15 * time.Second,
+		Jitter:         0.5,
+		AttemptTimeout: 30 * time.Second,
+	}
+}
+
+// VMActionRetryPolicy retries VM attach and detach actions. Emma rejects them with 409
+// while the VM is in a transitional state and occasionally with a transient 400.
+func VMActionRetryPolicy() RetryPolicy {
+	policy := DefaultRetryPolicy()
+	policy.Statuses = append(policy.Statuses, http.StatusConflict, http.StatusBadRequest)
+	policy.StatusRetries = map[int]int{http.StatusBadRequest: 3}
+	policy.MaxRetries = 12
+	policy.NonIdempotent = true
+	policy.Jitter = 0.2
+	return policy
+}
+
+// retryPolicyKey is the context key of a per-request retry policy
+type retryPolicyKey struct{}
+
+// withRetryPolicy has the requests made with ctx retried under policy instead of the
+// transport's default
+func withRetryPolicy(ctx context.Context, policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+// RetryTransport retries Emma API requests according to a RetryPolicy. It waits as long
+// as a Retry-After header asks or a jittered exponential backoff without one, and stops
+// waiting when the request's context is done. Requests can override the default policy
+// through their context.
+type RetryTransport struct {
+	next   http.RoundTripper
+	policy RetryPolicy
+	now    func() time.Time
+}
+
+// NewRetryTransport wraps next, or http.DefaultTransport when nil, with retries under policy
+func NewRetryTransport(next http.RoundTripper, policy RetryPolicy) *RetryTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &RetryTransport{next: next, policy: policy, now: time.Now}
+}
+
+// SetPolicy replaces the default retry policy. It must be called before the transport is used.
+func (t *RetryTransport) SetPolicy(policy RetryPolicy) {
+	t.policy = policy
+}
+
+// RoundTrip sends a request, retrying it while the policy allows
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	policy, ok := req.Context().Value(retryPolicyKey{}).(RetryPolicy)
+	if !ok {
+		policy = t.policy
+	}
+
+	for attempt := 0; ; attempt++ {
+		attemptReq, cancel, err := policy.attemptRequest(req, attempt)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := t.next.RoundTrip(attemptReq)
+		if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+			metrics.RecordAPIRateLimited(req.Method, req.URL.Path)
+		}
+
+		reason, retry := policy.shouldRetry(req, resp, err, attempt)
+		if !retry || req.Context().Err() != nil {
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+
+		retryAfter := ""
+		if resp != nil {
+			retryAfter = resp.Header.Get("Retry-After")
+			// Drain the body so the connection can be reused
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		cancel()
+
+		delay := policy.delay(retryAfter, attempt, t.now())
+		metrics.RecordAPIRetry(req.Method, req.URL.Path, reason)
+		klog.V(4).Infof("Retrying Emma API request %s %s after %s in %v (retry %d/%d)", req.Method, req.URL.Path, reason, delay, attempt+1, policy.MaxRetries)
+
+		if err := sleepContext(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// attemptRequest prepares one attempt of a request, bounded by the attempt timeout and
+// with a fresh copy of the body for retries. The request itself is not modified.
+func (p RetryPolicy) attemptRequest(req *http.Request, attempt int) (*http.Request, context.CancelFunc, error) {
+	ctx, cancel := req.Context(), context.CancelFunc(func() {})
+	if p.AttemptTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, p.AttemptTimeout)
+	}
+	attemptReq := req.WithContext(ctx)
+	if attempt > 0 && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+			return nil, nil, err
+		}
+		attemptReq.Body = body
+	}
+	return attemptReq, cancel, nil
+}
+
+// cancelBody ends the attempt context of a returned response once its body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// shouldRetry decides whether a request is retried after its attempt, returning the
+// retry reason for metrics: the status code or "error"
+func (p RetryPolicy) shouldRetry(req *http.Request, resp *http.Response, err error, attempt int) (string, bool) {
+	if attempt >= p.MaxRetries || !p.canResend(req) {
+		return "", false
+	}
+	if err != nil {
+		return "error", p.RetryErrors
+	}
+	for _, status := range p.Statuses {
+		if resp.StatusCode != status {
+			continue
+		}
+		if limit, ok := p.StatusRetries[status]; ok && attempt >= limit {
+			return "", false
+		}
+		return strconv.Itoa(status), true
+	}
+	return "", false
+}
+
+// canResend reports whether the policy allows sending a request again
+func (p RetryPolicy) canResend(req *http.Request) bool {
+	if !p.NonIdempotent && !idempotentMethod(req.Method) {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// delay returns how long to wait before a retry, from the Retry-After header in seconds
+// or as an HTTP date, else the jittered exponential backoff of the attempt
+func (p RetryPolicy) delay(retryAfter string, attempt int, now time.Time) time.Duration {
+	if delay, ok := parseRetryAfter(retryAfter, now); ok {
+		if delay > maxRetryAfter {
+			delay = maxRetryAfter
+		}
+		return delay
+	}
+
+	delay := p.InitialBackoff
+	for i := 0; i < attempt && (p.MaxBackoff <= 0 || delay < p.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	// Jitter spreads out the controller and node plugins retrying together
+	if jitter := int64(float64(delay) * p.Jitter); jitter > 0 {
+		delay += time.Duration(rand.Int63n(jitter))
+	}
+	return delay
+}
+
+// parseRetryAfter parses a Retry-After header value in delay seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	delay := date.Sub(now)
+	if delay < 0 {
+		delay = 0
+	}
+	return delay, true
+}
+
+// sleepContext waits for the delay or until the context is done
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,161 @@
+package emma
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestParseRetryAfter tests parsing Retry-After in seconds and as an HTTP date
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		value  string
+		delay  time.Duration
+		parsed bool
+	}{
+		{name: "seconds", value: "30", delay: 30 * time.Second, parsed: true},
+		{name: "zero", value: "0", delay: 0, parsed: true},
+		{name: "HTTP date", value: now.Add(90 * time.Second).Format(http.TimeFormat), delay: 90 * time.Second, parsed: true},
+		{name: "past HTTP date", value: now.Add(-time.Minute).Format(http.TimeFormat), delay: 0, parsed: true},
+		{name: "empty", value: ""},
+		{name: "negative", value: "-5"},
+		{name: "garbage", value: "soon"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, parsed := parseRetryAfter(tt.value, now)
+			if parsed != tt.parsed || delay != tt.delay {
+				t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", tt.value, delay, parsed, tt.delay, tt.parsed)
+			}
+		})
+	}
+}
+
+// TestRetryTransport tests which responses are retried under the default and VM action policies
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		policy       *RetryPolicy
+		status       int
+		failures     int32
+		retryAfter   string
+		wantStatus   int
+		wantRequests int32
+	}{
+		{name: "429 retried after Retry-After", method: http.MethodGet, status: http.StatusTooManyRequests, failures: 2, retryAfter: "0", wantStatus: http.StatusOK, wantRequests: 3},
+		{name: "503 retried with backoff", method: http.MethodGet, status: http.StatusServiceUnavailable, failures: 1, wantStatus: http.StatusOK, wantRequests: 2},
+		{name: "gives up after max retries", method: http.MethodGet, status: http.StatusTooManyRequests, failures: 10, retryAfter: "0", wantStatus: http.StatusTooManyRequests, wantRequests: 4},
+		{name: "500 not retried", method: http.MethodGet, status: http.StatusInternalServerError, failures: 1, wantStatus: http.StatusInternalServerError, wantRequests: 1},
+		{name: "POST not retried by default", method: http.MethodPost, status: http.StatusTooManyRequests, failures: 1, retryAfter: "0", wantStatus: http.StatusTooManyRequests, wantRequests: 1},
+		{name: "VM action 409 retried", method: http.MethodPost, policy: ptr(VMActionRetryPolicy()), status: http.StatusConflict, failures: 5, wantStatus: http.StatusOK, wantRequests: 6},
+		{name: "VM action 400 retried 3 times", method: http.MethodPost, policy: ptr(VMActionRetryPolicy()), status: http.StatusBadRequest, failures: 10, wantStatus: http.StatusBadRequest, wantRequests: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if body, _ := io.ReadAll(r.Body); r.Method == http.MethodPost && string(body) != "{}" {
+					t.Errorf("request body = %q, want it resent on every attempt", body)
+				}
+				if atomic.AddInt32(&requests, 1) <= tt.failures {
+					if tt.retryAfter != "" {
+						w.Header().Set("Retry-After", tt.retryAfter)
+					}
+					w.WriteHeader(tt.status)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			client := &http.Client{Transport: NewRetryTransport(nil, fastRetryPolicy(DefaultRetryPolicy()))}
+			ctx := context.Background()
+			if tt.policy != nil {
+				ctx = withRetryPolicy(ctx, fastRetryPolicy(*tt.policy))
+			}
+
+			var body io.Reader
+			if tt.method == http.MethodPost {
+				body = strings.NewReader("{}")
+			}
+			req, err := http.NewRequestWithContext(ctx, tt.method, server.URL+"/v1/vms/1/actions", body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := atomic.LoadInt32(&requests); got != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+// TestRetryTransportContext tests that a retry wait ends with the request context
+func TestRetryTransportContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: NewRetryTransport(nil, DefaultRetryPolicy())}
+	start := time.Now()
+	if _, err := client.Do(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the request to end with its context, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("request took %v, want it to end with its context", elapsed)
+	}
+}
+
+// TestAttachVolumeRetriesConflict tests that attach retries while the VM is busy
+func TestAttachVolumeRetriesConflict(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	client := newTestClient(server)
+	if err := client.AttachVolume(context.Background(), 456, 123); err != nil {
+		t.Fatalf("AttachVolume() error = %v", err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 3 {
+		t.Errorf("requests = %d, want 3", got)
+	}
+}
+
+// ptr returns a pointer to a copy of v
+func ptr[T any](v T) *T {
+	return &v
+}
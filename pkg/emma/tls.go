@@ -0,0 +1,160 @@
+package emma
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// pinPrefix is the optional prefix of a public key pin, as used by HPKP and curl
+const pinPrefix = "sha256/"
+
+// TLSOptions controls how connections to the Emma API are secured. The zero value
+// keeps the Go defaults.
+type TLSOptions struct {
+	// MinVersion is the minimum accepted TLS version (tls.VersionTLS12, tls.VersionTLS13), 0 keeps the Go default
+	MinVersion uint16
+
+	// PinnedKeys are base64 SHA-256 hashes of the SubjectPublicKeyInfo of certificates that must
+	// appear in the server's verified chain. Empty disables pinning.
+	PinnedKeys []string
+
+	// RootCAs overrides the system certificate pool when set
+	RootCAs *x509.CertPool
+
+	// Certificates are client certificates presented to endpoints requiring mutual TLS
+	Certificates []tls.Certificate
+
+	// InsecureSkipVerify disables server certificate verification. It exists for test
+	// endpoints only and cannot be combined with pinning.
+	InsecureSkipVerify bool
+}
+
+// ParseTLSVersion parses a TLS version name ("1.2" or "1.3"), empty returns 0
+func ParseTLSVersion(version string) (uint16, error) {
+	switch strings.TrimPrefix(strings.TrimSpace(version), "TLS") {
+	case "":
+		return 0, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version %q (supported: 1.2, 1.3)", version)
+	}
+}
+
+// ParsePinnedKeys parses a comma-separated list of base64 SHA-256 public key pins,
+// each optionally prefixed with "sha256/"
+func ParsePinnedKeys(value string) ([]string, error) {
+	var pins []string
+	for _, field := range strings.Split(value, ",") {
+		pin := strings.TrimPrefix(strings.TrimSpace(field), pinPrefix)
+		if pin == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pin %q: %w", field, err)
+		}
+		if len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("invalid pin %q: expected a %d byte SHA-256 hash, got %d bytes", field, sha256.Size, len(decoded))
+		}
+		pins = append(pins, pin)
+	}
+	return pins, nil
+}
+
+// LoadCABundle returns the system certificate pool extended with the PEM certificates in
+// the file at path, for Emma endpoints behind a private CA
+func LoadCABundle(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in CA bundle %s", path)
+	}
+	return pool, nil
+}
+
+// LoadClientCertificate loads a PEM client certificate and its private key for mutual TLS
+func LoadClientCertificate(certFile, keyFile string) (tls.Certificate, error) {
+	if certFile == "" || keyFile == "" {
+		return tls.Certificate{}, errors.New("both a client certificate and a key file are required")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// Validate rejects contradicting options
+func (o TLSOptions) Validate() error {
+	if o.InsecureSkipVerify && len(o.PinnedKeys) > 0 {
+		return errors.New("certificate pinning requires certificate verification")
+	}
+	if o.InsecureSkipVerify && o.RootCAs != nil {
+		return errors.New("a CA bundle has no effect without certificate verification")
+	}
+	return nil
+}
+
+// PublicKeyPin returns the base64 SHA-256 pin of a certificate's public key
+func PublicKeyPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// tlsConfig builds the TLS configuration for the options, or nil when they are empty
+func (o TLSOptions) tlsConfig() *tls.Config {
+	if o.MinVersion == 0 && len(o.PinnedKeys) == 0 && o.RootCAs == nil && len(o.Certificates) == 0 && !o.InsecureSkipVerify {
+		return nil
+	}
+
+	config := &tls.Config{
+		MinVersion:         o.MinVersion,
+		RootCAs:            o.RootCAs,
+		Certificates:       o.Certificates,
+		InsecureSkipVerify: o.InsecureSkipVerify, //nolint:gosec // opt-in for test endpoints
+	}
+	if len(o.PinnedKeys) > 0 {
+		pins := make(map[string]bool, len(o.PinnedKeys))
+		for _, pin := range o.PinnedKeys {
+			pins[pin] = true
+		}
+		// VerifyConnection runs after normal chain verification, so pins are
+		// matched against certificates that already chain to a trusted root
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			for _, chain := range state.VerifiedChains {
+				for _, cert := range chain {
+					if pins[PublicKeyPin(cert)] {
+						return nil
+					}
+				}
+			}
+			return errors.New("emma API certificate does not match any pinned public key")
+		}
+	}
+	return config
+}
+
+// newTransport returns an HTTP transport applying the TLS options. It sends requests
+// through the proxy configured by HTTPS_PROXY, HTTP_PROXY and NO_PROXY.
+func newTransport(opts TLSOptions) http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.TLSClientConfig = opts.tlsConfig()
+	return transport
+}
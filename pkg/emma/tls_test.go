@@ -0,0 +1,201 @@
+package emma
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestParsePinnedKeys tests parsing of public key pins
+func TestParsePinnedKeys(t *testing.T) {
+	valid := "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+
+	tests := []struct {
+		name        string
+		input       string
+		expected    int
+		expectError bool
+	}{
+		{name: "empty", input: "", expected: 0},
+		{name: "single", input: valid, expected: 1},
+		{name: "prefixed list", input: "sha256/" + valid + ", " + valid, expected: 2},
+		{name: "not base64", input: "not-a-pin!", expectError: true},
+		{name: "wrong length", input: "c2hvcnQ=", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pins, err := ParsePinnedKeys(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Error("expected error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(pins) != tt.expected {
+				t.Errorf("expected %d pins, got %d", tt.expected, len(pins))
+			}
+		})
+	}
+}
+
+// TestParseTLSVersion tests parsing of minimum TLS versions
+func TestParseTLSVersion(t *testing.T) {
+	tests := map[string]uint16{"": 0, "1.2": tls.VersionTLS12, "TLS1.3": tls.VersionTLS13}
+	for input, expected := range tests {
+		version, err := ParseTLSVersion(input)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", input, err)
+		}
+		if version != expected {
+			t.Errorf("expected version %x for %q, got %x", expected, input, version)
+		}
+	}
+	if _, err := ParseTLSVersion("1.0"); err == nil {
+		t.Error("expected error for TLS 1.0")
+	}
+}
+
+// TestPinnedTransport tests that connections are only accepted when the server key is pinned
+func TestPinnedTransport(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	serverPin := PublicKeyPin(server.Certificate())
+
+	tests := []struct {
+		name        string
+		opts        TLSOptions
+		expectError string
+	}{
+		{
+			name: "matching pin",
+			opts: TLSOptions{RootCAs: roots, PinnedKeys: []string{serverPin}},
+		},
+		{
+			name:        "mismatched pin",
+			opts:        TLSOptions{RootCAs: roots, PinnedKeys: []string{"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="}},
+			expectError: "pinned public key",
+		},
+		{
+			name: "minimum TLS 1.2",
+			opts: TLSOptions{RootCAs: roots, MinVersion: tls.VersionTLS12},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{Transport: newTransport(tt.opts)}
+			resp, err := client.Get(server.URL)
+			if tt.expectError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectError) {
+					t.Errorf("expected error containing %q, got %v", tt.expectError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp.Body.Close()
+		})
+	}
+}
+
+// TestPrivateCAAndClientCertificate tests trusting a CA bundle and presenting a client certificate
+func TestPrivateCAAndClientCertificate(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	// The test server's certificate stands in for both the private CA and the client certificate
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	key, err := x509.MarshalPKCS8PrivateKey(server.TLS.Certificates[0].PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, data := range map[string][]byte{
+		caFile:   certPEM,
+		certFile: certPEM,
+		keyFile:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}),
+	} {
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	roots, err := LoadCABundle(caFile)
+	if err != nil {
+		t.Fatalf("LoadCABundle() error = %v", err)
+	}
+	cert, err := LoadClientCertificate(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("LoadClientCertificate() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		opts       TLSOptions
+		wantStatus int
+		wantError  bool
+	}{
+		{name: "untrusted CA", opts: TLSOptions{MinVersion: tls.VersionTLS12}, wantError: true},
+		{name: "CA bundle", opts: TLSOptions{RootCAs: roots}, wantStatus: http.StatusUnauthorized},
+		{name: "CA bundle and client certificate", opts: TLSOptions{RootCAs: roots, Certificates: []tls.Certificate{cert}}, wantStatus: http.StatusOK},
+		{name: "insecure", opts: TLSOptions{InsecureSkipVerify: true}, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{Transport: newTransport(tt.opts)}
+			resp, err := client.Get(server.URL)
+			if tt.wantError {
+				if err == nil {
+					resp.Body.Close()
+					t.Error("expected a certificate verification error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+
+	if _, err := LoadCABundle(keyFile); err == nil {
+		t.Error("expected an error for a CA bundle without certificates")
+	}
+	if _, err := LoadClientCertificate(certFile, ""); err == nil {
+		t.Error("expected an error for a client certificate without a key")
+	}
+	if err := (TLSOptions{InsecureSkipVerify: true, PinnedKeys: []string{PublicKeyPin(server.Certificate())}}).Validate(); err == nil {
+		t.Error("expected pinning without verification to be rejected")
+	}
+}
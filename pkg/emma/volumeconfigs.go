@@ -0,0 +1,140 @@
+package emma
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	emma "github.com/emma-community/emma-go-sdk"
+)
+
+// volumeConfigsPageSize is the page size used to list volume configurations
+const volumeConfigsPageSize = 100
+
+// maxVolumeConfigsPages bounds the pages listed, in case the API never reports the last page
+const maxVolumeConfigsPages = 100
+
+// volumeConfigsCache holds the volume configurations, which change as rarely as the
+// datacenters. It shares the datacenter cache TTL.
+type volumeConfigsCache struct {
+	mu      sync.Mutex
+	configs []emma.VolumeConfiguration
+	expires time.Time
+}
+
+// get returns the cached volume configurations until they expire
+func (v *volumeConfigsCache) get() ([]emma.VolumeConfiguration, bool) {
+	if v == nil {
+		return nil, false
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.configs == nil || !time.Now().Before(v.expires) {
+		return nil, false
+	}
+	return v.configs, true
+}
+
+// put caches volume configurations for the TTL of the datacenter cache
+func (v *volumeConfigsCache) put(configs []emma.VolumeConfiguration, dataCenters *dataCenterCache) {
+	if v == nil || dataCenters == nil || len(configs) == 0 {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.configs = configs
+	v.expires = time.Now().Add(dataCenters.ttl)
+}
+
+// VolumeOfferings indexes the volume sizes Emma offers by datacenter and volume type
+type VolumeOfferings struct {
+	// sizes maps datacenter ID and lower case volume type to the offered sizes in GB, ascending
+	sizes map[string]map[string][]int32
+}
+
+// NewVolumeOfferings indexes volume configurations
+func NewVolumeOfferings(configs []emma.VolumeConfiguration) VolumeOfferings {
+	offerings := VolumeOfferings{sizes: make(map[string]map[string][]int32)}
+	for _, config := range configs {
+		dataCenterID, volumeType, sizeGB := config.GetDataCenterId(), strings.ToLower(config.GetVolumeType()), config.GetVolumeGb()
+		if dataCenterID == "" || volumeType == "" || sizeGB <= 0 {
+			continue
+		}
+		types, ok := offerings.sizes[dataCenterID]
+		if !ok {
+			types = make(map[string][]int32)
+			offerings.sizes[dataCenterID] = types
+		}
+		types[volumeType] = append(types[volumeType], sizeGB)
+	}
+	for _, types := range offerings.sizes {
+		for volumeType, sizes := range types {
+			sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+			types[volumeType] = dedupeSizes(sizes)
+		}
+	}
+	return offerings
+}
+
+// Types returns the volume types offered in a datacenter, sorted
+func (o VolumeOfferings) Types(dataCenterID string) []string {
+	types := make([]string, 0, len(o.sizes[dataCenterID]))
+	for volumeType := range o.sizes[dataCenterID] {
+		types = append(types, volumeType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Sizes returns the sizes in GB offered for a volume type in a datacenter, ascending
+func (o VolumeOfferings) Sizes(dataCenterID, volumeType string) []int32 {
+	return o.sizes[dataCenterID][strings.ToLower(volumeType)]
+}
+
+// MinGB returns the smallest size offered for a volume type in a datacenter, 0 when none
+func (o VolumeOfferings) MinGB(dataCenterID, volumeType string) int32 {
+	if sizes := o.Sizes(dataCenterID, volumeType); len(sizes) > 0 {
+		return sizes[0]
+	}
+	return 0
+}
+
+// MaxGB returns the largest size offered for a volume type in a datacenter, 0 when none
+func (o VolumeOfferings) MaxGB(dataCenterID, volumeType string) int32 {
+	if sizes := o.Sizes(dataCenterID, volumeType); len(sizes) > 0 {
+		return sizes[len(sizes)-1]
+	}
+	return 0
+}
+
+// Validate checks that a volume type is offered in a datacenter and the size lies between
+// the smallest and largest offered sizes. Datacenters without any configurations are not
+// validated, as Emma may not list every datacenter.
+func (o VolumeOfferings) Validate(dataCenterID, volumeType string, sizeGB int32) error {
+	if len(o.sizes[dataCenterID]) == 0 {
+		return nil
+	}
+	sizes := o.Sizes(dataCenterID, volumeType)
+	if len(sizes) == 0 {
+		return fmt.Errorf("volume type %q is not offered in datacenter %s (offered types: %s)",
+			volumeType, dataCenterID, strings.Join(o.Types(dataCenterID), ", "))
+	}
+	if minGB, maxGB := sizes[0], sizes[len(sizes)-1]; sizeGB < minGB || sizeGB > maxGB {
+		return fmt.Errorf("%dGB %s volumes are not offered in datacenter %s (offered sizes: %dGB to %dGB)",
+			sizeGB, volumeType, dataCenterID, minGB, maxGB)
+	}
+	return nil
+}
+
+// dedupeSizes removes repeated sizes from an ascending slice
+func dedupeSizes(sizes []int32) []int32 {
+	unique := sizes[:0]
+	for i, size := range sizes {
+		if i == 0 || size != sizes[i-1] {
+			unique = append(unique, size)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,119 @@
+package emma
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	emma "github.com/emma-community/emma-go-sdk"
+)
+
+// TestGetVolumeConfigs tests that all pages of volume configurations are listed and cached
+func TestGetVolumeConfigs(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/system-volumes-configs" {
+			http.NotFound(w, r)
+			return
+		}
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		switch page := r.URL.Query().Get("page"); page {
+		case "0":
+			_, _ = w.Write([]byte(`{"content":[{"dataCenterId":"dc-1","volumeType":"ssd","volumeGb":16}],"totalPages":2,"last":false}`))
+		case "1":
+			_, _ = w.Write([]byte(`{"content":[{"dataCenterId":"dc-1","volumeType":"ssd","volumeGb":1024}],"totalPages":2,"last":true}`))
+		default:
+			t.Errorf("unexpected page %q", page)
+			_, _ = w.Write([]byte(`{"content":[],"last":true}`))
+		}
+	}))
+	defer server.Close()
+
+	client := newTestSDKClient(server)
+	client.SetDataCenterCacheTTL(time.Minute)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		configs, err := client.GetVolumeConfigs(ctx)
+		if err != nil {
+			t.Fatalf("GetVolumeConfigs() error = %v", err)
+		}
+		if len(configs) != 2 {
+			t.Fatalf("expected 2 configs from both pages, got %d", len(configs))
+		}
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("expected 2 page requests with the second listing cached, got %d", got)
+	}
+
+	client.SetDataCenterCacheTTL(0)
+	if _, err := client.GetVolumeConfigs(ctx); err != nil {
+		t.Fatalf("GetVolumeConfigs() error = %v", err)
+	}
+	if got := requests.Load(); got != 4 {
+		t.Errorf("expected the disabled cache to list again, got %d requests", got)
+	}
+}
+
+// TestVolumeOfferings tests validation of volume types and sizes against the offered configurations
+func TestVolumeOfferings(t *testing.T) {
+	config := func(dc, volumeType string, gb int32) emma.VolumeConfiguration {
+		return emma.VolumeConfiguration{DataCenterId: &dc, VolumeType: &volumeType, VolumeGb: &gb}
+	}
+	offerings := NewVolumeOfferings([]emma.VolumeConfiguration{
+		config("dc-1", "ssd", 1024),
+		config("dc-1", "ssd", 8),
+		config("dc-1", "SSD", 8),
+		config("dc-1", "hdd", 4096),
+		config("dc-2", "ssd-plus", 64),
+	})
+
+	if got, want := offerings.Types("dc-1"), []string{"hdd", "ssd"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Types() = %v, want %v", got, want)
+	}
+	if got, want := offerings.Sizes("dc-1", "SSD"), []int32{8, 1024}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Sizes() = %v, want %v", got, want)
+	}
+	if got := offerings.MinGB("dc-1", "ssd"); got != 8 {
+		t.Errorf("MinGB() = %d, want 8", got)
+	}
+	if got := offerings.MaxGB("dc-1", "ssd"); got != 1024 {
+		t.Errorf("MaxGB() = %d, want 1024", got)
+	}
+
+	tests := []struct {
+		name       string
+		dataCenter string
+		volumeType string
+		sizeGB     int32
+		wantErr    string
+	}{
+		{name: "offered", dataCenter: "dc-1", volumeType: "ssd", sizeGB: 64},
+		{name: "type is case insensitive", dataCenter: "dc-1", volumeType: "SSD", sizeGB: 8},
+		{name: "type not offered", dataCenter: "dc-2", volumeType: "ssd", sizeGB: 64, wantErr: `volume type "ssd" is not offered in datacenter dc-2 (offered types: ssd-plus)`},
+		{name: "too small", dataCenter: "dc-1", volumeType: "hdd", sizeGB: 1024, wantErr: "1024GB hdd volumes are not offered in datacenter dc-1 (offered sizes: 4096GB to 4096GB)"},
+		{name: "too large", dataCenter: "dc-1", volumeType: "ssd", sizeGB: 2048, wantErr: "offered sizes: 8GB to 1024GB"},
+		{name: "unlisted datacenter", dataCenter: "dc-3", volumeType: "ssd", sizeGB: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := offerings.Validate(tt.dataCenter, tt.volumeType, tt.sizeGB)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
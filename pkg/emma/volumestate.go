@@ -0,0 +1,230 @@
+package emma
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/emma-csi-driver/pkg/metrics"
+)
+
+// ErrVolumeBusy is returned when a volume stays in a transient state, such as BUSY or
+// PROCESSING, for the whole wait budget
+var ErrVolumeBusy = errors.New("volume busy")
+
+// transientVolumeStatuses are states Emma reports while it is working on a volume. They
+// are always waited out; the volume cannot be changed until it leaves them.
+var transientVolumeStatuses = map[string]bool{
+	"BUSY":       true,
+	"PROCESSING": true,
+}
+
+// knownVolumeStatuses are the settled states the driver waits for
+var knownVolumeStatuses = map[string]bool{
+	"AVAILABLE": true,
+	"ACTIVE":    true,
+	"FAILED":    true,
+}
+
+// IsTransientVolumeStatus reports whether Emma is still working on a volume in this status
+func IsTransientVolumeStatus(status string) bool {
+	return transientVolumeStatuses[status]
+}
+
+// waitOutcome is the result of classifying a polled volume state against a wait goal
+type waitOutcome int
+
+const (
+	// waitPending keeps polling
+	waitPending waitOutcome = iota
+	// waitDone ends the wait successfully
+	waitDone
+	// waitSettle ends the wait successfully once the state persists for settleObservations polls.
+	// It is used for inconsistent states the Emma API is known to report transiently.
+	waitSettle
+	// waitFailed ends the wait with an error
+	waitFailed
+)
+
+// defaultPollInterval is the delay between volume state polls
+const defaultPollInterval = 5 * time.Second
+
+// settleObservations is how many consecutive polls a waitSettle state must persist
+const settleObservations = 3
+
+// attachment describes a volume's AttachedToID relative to the VM being waited on
+type attachment int
+
+const (
+	attachedAny attachment = iota
+	attachedNone
+	attachedTarget
+	attachedOther
+)
+
+// volumeStateRule maps a volume status and attachment to a wait outcome.
+// An empty status matches any status.
+type volumeStateRule struct {
+	status     string
+	attachment attachment
+	outcome    waitOutcome
+}
+
+// attachStateTable decides the outcome of waiting for a volume to attach to a VM
+var attachStateTable = []volumeStateRule{
+	{status: "FAILED", attachment: attachedAny, outcome: waitFailed},
+	{status: "BUSY", attachment: attachedAny, outcome: waitPending},
+	{status: "PROCESSING", attachment: attachedAny, outcome: waitPending},
+	{status: "ACTIVE", attachment: attachedTarget, outcome: waitDone},
+	{status: "", attachment: attachedOther, outcome: waitFailed},
+	// Attachment recorded but status not yet updated
+	{status: "AVAILABLE", attachment: attachedTarget, outcome: waitSettle},
+	{status: "", attachment: attachedAny, outcome: waitPending},
+}
+
+// detachStateTable decides the outcome of waiting for a volume to detach
+var detachStateTable = []volumeStateRule{
+	// A detach of a FAILED volume keeps reporting FAILED until the attachment clears
+	{status: "FAILED", attachment: attachedNone, outcome: waitFailed},
+	{status: "FAILED", attachment: attachedAny, outcome: waitPending},
+	{status: "BUSY", attachment: attachedAny, outcome: waitPending},
+	{status: "PROCESSING", attachment: attachedAny, outcome: waitPending},
+	{status: "AVAILABLE", attachment: attachedNone, outcome: waitDone},
+	// Attachment released but status not yet updated
+	{status: "ACTIVE", attachment: attachedNone, outcome: waitSettle},
+	{status: "", attachment: attachedAny, outcome: waitPending},
+}
+
+// classifyVolumeState returns the outcome of the first rule in table matching the volume
+func classifyVolumeState(table []volumeStateRule, volume *VolumeResponse, vmID int32) waitOutcome {
+	current := attachedNone
+	if volume.AttachedToID != nil {
+		current = attachedOther
+		if *volume.AttachedToID == vmID {
+			current = attachedTarget
+		}
+	}
+
+	for _, rule := range table {
+		if rule.status != "" && rule.status != volume.Status {
+			continue
+		}
+		if rule.attachment != attachedAny && rule.attachment != current {
+			continue
+		}
+		return rule.outcome
+	}
+
+	return waitPending
+}
+
+// isInconsistentVolumeState reports status and attachment combinations that contradict each other
+func isInconsistentVolumeState(volume *VolumeResponse) bool {
+	switch volume.Status {
+	case "ACTIVE":
+		return volume.AttachedToID == nil
+	case "AVAILABLE":
+		return volume.AttachedToID != nil
+	}
+	return false
+}
+
+// recordVolumeObservation logs and counts inconsistent volume states for Emma-side debugging
+func recordVolumeObservation(volume *VolumeResponse) {
+	if !isInconsistentVolumeState(volume) {
+		return
+	}
+
+	attachedTo := "none"
+	if volume.AttachedToID != nil {
+		attachedTo = fmt.Sprintf("%d", *volume.AttachedToID)
+	}
+	klog.Warningf("Emma API reported inconsistent state for volume %d: status %s, attachedTo %s", volume.ID, volume.Status, attachedTo)
+	metrics.RecordInconsistentVolumeState(volume.Status, volume.AttachedToID != nil)
+}
+
+// waitForVolumeState polls a volume until classify reports a final outcome or the timeout expires
+func (c *Client) waitForVolumeState(ctx context.Context, volumeID int32, timeout time.Duration, goal string, classify func(*VolumeResponse) waitOutcome) error {
+	release, err := c.acquireWaiter(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot wait for volume %d to %s: %w", volumeID, goal, err)
+	}
+	defer release()
+
+	deadline := time.Now().Add(timeout)
+	pollInterval := c.pollInterval
+	if pollInterval == 0 {
+		pollInterval = defaultPollInterval
+	}
+	settled := 0
+	lastStatus := ""
+
+	for {
+		if time.Now().After(deadline) {
+			return waitTimeoutError(volumeID, goal, timeout, lastStatus)
+		}
+
+		volume, err := c.GetVolume(ctx, volumeID)
+		if err != nil {
+			return fmt.Errorf("failed to get volume status: %w", err)
+		}
+
+		klog.V(5).Infof("Volume %d status: %s, attachedTo: %v", volumeID, volume.Status, volume.AttachedToID)
+		recordVolumeObservation(volume)
+		if volume.Status != lastStatus && !transientVolumeStatuses[volume.Status] && !knownVolumeStatuses[volume.Status] {
+			klog.Warningf("Volume %d is in unrecognized status %q while waiting to %s, treating it as in progress", volumeID, volume.Status, goal)
+		}
+		lastStatus = volume.Status
+
+		switch classify(volume) {
+		case waitDone:
+			klog.V(4).Infof("Volume %d reached goal: %s", volumeID, goal)
+			return nil
+		case waitSettle:
+			settled++
+			if settled >= settleObservations {
+				klog.Warningf("Volume %d treated as done (%s) after %d consistent observations of status %s, attachedTo %v",
+					volumeID, goal, settled, volume.Status, volume.AttachedToID)
+				return nil
+			}
+		case waitFailed:
+			if volume.Status == "FAILED" {
+				return fmt.Errorf("%w: volume %d cannot %s, attachedTo %v", ErrVolumeFailed, volumeID, goal, formatAttachedTo(volume.AttachedToID))
+			}
+			return fmt.Errorf("volume %d cannot %s: status %s, attachedTo %v", volumeID, goal, volume.Status, formatAttachedTo(volume.AttachedToID))
+		default:
+			settled = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+			// Continue polling
+		}
+	}
+}
+
+// waitTimeoutError describes a wait that ran out of time, naming the state that blocked it
+func waitTimeoutError(volumeID int32, goal string, timeout time.Duration, lastStatus string) error {
+	switch {
+	case transientVolumeStatuses[lastStatus]:
+		return fmt.Errorf("%w: timeout waiting for volume %d to %s: still %s after %v", ErrVolumeBusy, volumeID, goal, lastStatus, timeout)
+	case lastStatus != "" && !knownVolumeStatuses[lastStatus]:
+		return fmt.Errorf("timeout waiting for volume %d to %s: stuck in unrecognized status %s after %v", volumeID, goal, lastStatus, timeout)
+	case lastStatus != "":
+		return fmt.Errorf("timeout waiting for volume %d to %s: last status %s after %v", volumeID, goal, lastStatus, timeout)
+	}
+	return fmt.Errorf("timeout waiting for volume %d to %s", volumeID, goal)
+}
+
+// formatAttachedTo formats an optional VM ID for messages
+func formatAttachedTo(vmID *int32) string {
+	if vmID == nil {
+		return "none"
+	}
+	return fmt.Sprintf("%d", *vmID)
+}
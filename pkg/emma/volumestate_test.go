@@ -0,0 +1,94 @@
+package emma
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClassifyVolumeState(t *testing.T) {
+	vm := func(id int32) *int32 { return &id }
+
+	tests := []struct {
+		name     string
+		table    []volumeStateRule
+		volume   VolumeResponse
+		vmID     int32
+		expected waitOutcome
+	}{
+		{name: "attach done", table: attachStateTable, volume: VolumeResponse{Status: "ACTIVE", AttachedToID: vm(7)}, vmID: 7, expected: waitDone},
+		{name: "attach ACTIVE without attachment keeps waiting", table: attachStateTable, volume: VolumeResponse{Status: "ACTIVE"}, vmID: 7, expected: waitPending},
+		{name: "attach AVAILABLE with attachment settles", table: attachStateTable, volume: VolumeResponse{Status: "AVAILABLE", AttachedToID: vm(7)}, vmID: 7, expected: waitSettle},
+		{name: "attach to other VM fails", table: attachStateTable, volume: VolumeResponse{Status: "ACTIVE", AttachedToID: vm(8)}, vmID: 7, expected: waitFailed},
+		{name: "attach in progress", table: attachStateTable, volume: VolumeResponse{Status: "ATTACHING"}, vmID: 7, expected: waitPending},
+		{name: "attach failed", table: attachStateTable, volume: VolumeResponse{Status: "FAILED", AttachedToID: vm(7)}, vmID: 7, expected: waitFailed},
+		{name: "detach done", table: detachStateTable, volume: VolumeResponse{Status: "AVAILABLE"}, expected: waitDone},
+		{name: "detach ACTIVE without attachment settles", table: detachStateTable, volume: VolumeResponse{Status: "ACTIVE"}, expected: waitSettle},
+		{name: "detach still attached", table: detachStateTable, volume: VolumeResponse{Status: "ACTIVE", AttachedToID: vm(7)}, expected: waitPending},
+		{name: "detach AVAILABLE with attachment keeps waiting", table: detachStateTable, volume: VolumeResponse{Status: "AVAILABLE", AttachedToID: vm(7)}, expected: waitPending},
+		{name: "detach failed", table: detachStateTable, volume: VolumeResponse{Status: "FAILED"}, expected: waitFailed},
+		{name: "detach FAILED while attached keeps waiting", table: detachStateTable, volume: VolumeResponse{Status: "FAILED", AttachedToID: vm(7)}, expected: waitPending},
+		{name: "attach BUSY on other VM keeps waiting", table: attachStateTable, volume: VolumeResponse{Status: "BUSY", AttachedToID: vm(8)}, vmID: 7, expected: waitPending},
+		{name: "detach PROCESSING keeps waiting", table: detachStateTable, volume: VolumeResponse{Status: "PROCESSING"}, expected: waitPending},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyVolumeState(tt.table, &tt.volume, tt.vmID); got != tt.expected {
+				t.Errorf("expected outcome %d, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestIsInconsistentVolumeState(t *testing.T) {
+	vmID := int32(7)
+
+	tests := []struct {
+		name     string
+		volume   VolumeResponse
+		expected bool
+	}{
+		{name: "active and attached", volume: VolumeResponse{Status: "ACTIVE", AttachedToID: &vmID}},
+		{name: "available and detached", volume: VolumeResponse{Status: "AVAILABLE"}},
+		{name: "active without attachment", volume: VolumeResponse{Status: "ACTIVE"}, expected: true},
+		{name: "available with attachment", volume: VolumeResponse{Status: "AVAILABLE", AttachedToID: &vmID}, expected: true},
+		{name: "transitional state", volume: VolumeResponse{Status: "BUSY"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isInconsistentVolumeState(&tt.volume); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestWaitTimeoutError(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   string
+		busy     bool
+		contains string
+	}{
+		{name: "transient", status: "BUSY", busy: true, contains: "still BUSY"},
+		{name: "processing", status: "PROCESSING", busy: true, contains: "still PROCESSING"},
+		{name: "unrecognized", status: "MIGRATING", contains: "unrecognized status MIGRATING"},
+		{name: "known", status: "ACTIVE", contains: "last status ACTIVE"},
+		{name: "never observed", contains: "timeout waiting for volume 5 to detach"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := waitTimeoutError(5, "detach", time.Minute, tt.status)
+			if errors.Is(err, ErrVolumeBusy) != tt.busy {
+				t.Errorf("expected ErrVolumeBusy=%v, got %v", tt.busy, err)
+			}
+			if !strings.Contains(err.Error(), tt.contains) {
+				t.Errorf("expected error to contain %q, got %q", tt.contains, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package emma
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/emma-csi-driver/pkg/metrics"
+)
+
+// ErrTooManyWaiters is returned when the limit of concurrent volume state waits is reached.
+// It is retryable: the caller should back off and try the operation again.
+var ErrTooManyWaiters = errors.New("too many concurrent volume waits")
+
+// waiterKey marks a context that already holds a waiter slot
+type waiterKey struct{}
+
+// SetMaxWaiters caps the number of concurrent volume state waits, each of which polls the
+// Emma API. Waits beyond the cap fail fast with ErrTooManyWaiters. Zero means unlimited.
+// It must be called before the client is used.
+func (c *Client) SetMaxWaiters(limit int) {
+	if limit <= 0 {
+		c.waiters = nil
+		return
+	}
+	c.waiters = make(chan struct{}, limit)
+}
+
+// ReserveWaiter takes a waiter slot up front for an operation that will wait on a volume,
+// so it can be rejected before any change is made in Emma. Waits made with the returned
+// context use the reserved slot. The release function must be called when done.
+func (c *Client) ReserveWaiter(ctx context.Context) (context.Context, func(), error) {
+	release, err := c.acquireWaiter(ctx)
+	if err != nil {
+		return ctx, func() {}, err
+	}
+	return context.WithValue(ctx, waiterKey{}, true), release, nil
+}
+
+// acquireWaiter takes a waiter slot without blocking, unless the context already holds one
+func (c *Client) acquireWaiter(ctx context.Context) (func(), error) {
+	if c.waiters == nil || ctx.Value(waiterKey{}) != nil {
+		return func() {}, nil
+	}
+
+	select {
+	case c.waiters <- struct{}{}:
+		metrics.AddVolumeWaiters(1)
+		return func() {
+			<-c.waiters
+			metrics.AddVolumeWaiters(-1)
+		}, nil
+	default:
+		metrics.RecordVolumeWaiterRejected()
+		return nil, fmt.Errorf("%w: limit of %d reached", ErrTooManyWaiters, cap(c.waiters))
+	}
+}
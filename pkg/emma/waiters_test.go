@@ -0,0 +1,45 @@
+package emma
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestMaxWaiters tests that waits beyond the limit are rejected and reservations are reused
+func TestMaxWaiters(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 1, "status": "AVAILABLE"}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(server)
+	client.SetMaxWaiters(1)
+
+	reserved, release, err := client.ReserveWaiter(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The reserved context waits with its own slot
+	if err := client.WaitForVolumeStatus(reserved, 1, "AVAILABLE", time.Minute); err != nil {
+		t.Errorf("expected wait with reservation to succeed, got %v", err)
+	}
+
+	// Other waits are rejected while the only slot is held
+	err = client.WaitForVolumeStatus(context.Background(), 1, "AVAILABLE", time.Minute)
+	if !errors.Is(err, ErrTooManyWaiters) {
+		t.Errorf("expected ErrTooManyWaiters, got %v", err)
+	}
+	if _, _, err := client.ReserveWaiter(context.Background()); !errors.Is(err, ErrTooManyWaiters) {
+		t.Errorf("expected ErrTooManyWaiters, got %v", err)
+	}
+
+	release()
+	if err := client.WaitForVolumeStatus(context.Background(), 1, "AVAILABLE", time.Minute); err != nil {
+		t.Errorf("expected wait after release to succeed, got %v", err)
+	}
+}
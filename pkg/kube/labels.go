@@ -0,0 +1,64 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Node labels carrying Emma metadata
+const (
+	LabelVMID       = "emma.ms/vm-id"
+	LabelDataCenter = "emma.ms/datacenter"
+	LabelProvider   = "emma.ms/provider"
+)
+
+// PVC annotations selecting an existing Emma volume that CreateVolume imports instead of
+// creating a new one
+const (
+	AnnotationExistingVolumeID   = "emma.ms/existing-volume-id"
+	AnnotationExistingVolumeName = "emma.ms/existing-volume-name"
+)
+
+// EmmaNodeLabels returns the Emma metadata labels for a node. Empty values are omitted.
+// The provider is derived from the datacenter ID, which has the form {provider}-{region}.
+func EmmaNodeLabels(vmID, dataCenterID string) map[string]string {
+	labels := map[string]string{}
+	if vmID != "" {
+		labels[LabelVMID] = vmID
+	}
+	if dataCenterID != "" {
+		labels[LabelDataCenter] = dataCenterID
+		if provider, _, ok := strings.Cut(dataCenterID, "-"); ok && provider != "" {
+			labels[LabelProvider] = provider
+		}
+	}
+	return labels
+}
+
+// LabelNode merges labels into the labels of the named node
+func LabelNode(ctx context.Context, client kubernetes.Interface, nodeName string, labels map[string]string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": labels,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build node label patch: %w", err)
+	}
+
+	if _, err := client.CoreV1().Nodes().Patch(ctx, nodeName, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to label node %s: %w", nodeName, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package kube
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestEmmaNodeLabels(t *testing.T) {
+	tests := []struct {
+		name         string
+		vmID         string
+		dataCenterID string
+		expected     map[string]string
+	}{
+		{
+			name:         "all metadata",
+			vmID:         "123",
+			dataCenterID: "aws-eu-west-2",
+			expected: map[string]string{
+				LabelVMID:       "123",
+				LabelDataCenter: "aws-eu-west-2",
+				LabelProvider:   "aws",
+			},
+		},
+		{
+			name:         "datacenter without provider prefix",
+			dataCenterID: "local",
+			expected:     map[string]string{LabelDataCenter: "local"},
+		},
+		{
+			name:     "nothing known",
+			expected: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := EmmaNodeLabels(tt.vmID, tt.dataCenterID)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestLabelNode(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"existing": "label"}},
+	})
+
+	labels := EmmaNodeLabels("123", "aws-eu-west-2")
+	if err := LabelNode(context.Background(), client, "node-1", labels); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	node, err := client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if node.Labels["existing"] != "label" {
+		t.Error("expected existing label to be preserved")
+	}
+	for key, value := range labels {
+		if node.Labels[key] != value {
+			t.Errorf("expected label %s=%s, got %q", key, value, node.Labels[key])
+		}
+	}
+
+	if err := LabelNode(context.Background(), client, "missing", labels); err == nil {
+		t.Error("expected error for missing node")
+	}
+}
@@ -0,0 +1,77 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+// Leader election defaults, matching the CSI sidecars
+const (
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRenewDeadline = 10 * time.Second
+	DefaultRetryPeriod   = 5 * time.Second
+)
+
+// LeaderElectionConfig configures a leader election on a Lease
+type LeaderElectionConfig struct {
+	// Namespace and Name identify the Lease
+	Namespace string
+	Name      string
+
+	// Identity is this replica's name in the Lease, usually the pod name
+	Identity string
+
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// RunLeaderElection campaigns for the Lease until ctx is done, standing again whenever
+// leadership is lost. onStartedLeading runs with a context cancelled when leadership is
+// lost; onStoppedLeading runs after every term and on shutdown. The Lease is released on
+// shutdown so another replica takes over without waiting for it to expire.
+func RunLeaderElection(ctx context.Context, client kubernetes.Interface, cfg LeaderElectionConfig, onStartedLeading func(context.Context), onStoppedLeading func()) error {
+	if cfg.Namespace == "" || cfg.Name == "" || cfg.Identity == "" {
+		return fmt.Errorf("leader election needs a lease namespace, name and identity")
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: cfg.Namespace, Name: cfg.Name},
+		Client:     client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: cfg.Identity},
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   cfg.LeaseDuration,
+		RenewDeadline:   cfg.RenewDeadline,
+		RetryPeriod:     cfg.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            cfg.Name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: onStartedLeading,
+			OnStoppedLeading: onStoppedLeading,
+			OnNewLeader: func(identity string) {
+				if identity != cfg.Identity {
+					klog.Infof("Leader of %s/%s is %s", cfg.Namespace, cfg.Name, identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to configure leader election: %w", err)
+	}
+
+	go func() {
+		for ctx.Err() == nil {
+			elector.Run(ctx)
+		}
+	}()
+	return nil
+}
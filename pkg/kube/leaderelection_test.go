@@ -0,0 +1,61 @@
+package kube
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestRunLeaderElection tests taking the Lease, running while leading and releasing it on shutdown
+func TestRunLeaderElection(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	cfg := LeaderElectionConfig{
+		Namespace:     "kube-system",
+		Name:          "emma-csi-controller",
+		Identity:      "controller-0",
+		LeaseDuration: 2 * time.Second,
+		RenewDeadline: time.Second,
+		RetryPeriod:   100 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	stopped := make(chan struct{}, 1)
+	err := RunLeaderElection(ctx, client, cfg,
+		func(context.Context) { close(started) },
+		func() { stopped <- struct{}{} },
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for leadership")
+	}
+	lease, err := client.CoordinationV1().Leases("kube-system").Get(context.Background(), "emma-csi-controller", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get lease: %v", err)
+	}
+	if holder := lease.Spec.HolderIdentity; holder == nil || *holder != "controller-0" {
+		t.Errorf("expected lease held by controller-0, got %v", holder)
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for leadership to stop")
+	}
+}
+
+func TestRunLeaderElectionRequiresLease(t *testing.T) {
+	err := RunLeaderElection(context.Background(), fake.NewSimpleClientset(), LeaderElectionConfig{Name: "lease", Identity: "controller-0"}, func(context.Context) {}, func() {})
+	if err == nil {
+		t.Error("expected an error without a lease namespace")
+	}
+}
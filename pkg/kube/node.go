@@ -0,0 +1,26 @@
+package kube
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// TaintToBeDeletedByClusterAutoscaler is set by the cluster autoscaler on nodes it is removing
+	TaintToBeDeletedByClusterAutoscaler = "ToBeDeletedByClusterAutoscaler"
+)
+
+// IsNodeBeingDeleted reports whether a node is being deleted or drained for removal
+// by the cluster autoscaler, along with a human-readable reason
+func IsNodeBeingDeleted(node *v1.Node) (bool, string) {
+	if node.DeletionTimestamp != nil {
+		return true, "node has a deletion timestamp"
+	}
+
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == TaintToBeDeletedByClusterAutoscaler {
+			return true, "node is being removed by the cluster autoscaler"
+		}
+	}
+
+	return false, ""
+}
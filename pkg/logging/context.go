@@ -0,0 +1,29 @@
+package logging
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// csiRequestIDKey is the context key of the CSI request ID
+type csiRequestIDKey struct{}
+
+// NewCSIRequestID generates the ID of a CSI call
+func NewCSIRequestID() string {
+	return uuid.NewString()
+}
+
+// WithCSIRequestID returns a copy of ctx carrying the ID of the CSI call it serves
+func WithCSIRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, csiRequestIDKey{}, id)
+}
+
+// CSIRequestIDFromContext returns the CSI request ID carried by ctx, or ""
+func CSIRequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(csiRequestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,68 @@
+package logging
+
+import (
+	"context"
+
+	"k8s.io/klog/v2"
+)
+
+// ContextKlog logs through klog, prefixing messages with the CSI request ID of a context
+// so text logs of a call can be correlated like the structured ones
+type ContextKlog struct {
+	prefix string
+}
+
+// Klog returns a klog logger for the CSI call ctx serves. Without a CSI request ID messages
+// are logged unchanged.
+func Klog(ctx context.Context) ContextKlog {
+	if id := CSIRequestIDFromContext(ctx); id != "" {
+		return ContextKlog{prefix: "[" + csiRequestIDField + "=" + id + "] "}
+	}
+	return ContextKlog{}
+}
+
+// Info logs args at the info level
+func (k ContextKlog) Info(args ...interface{}) {
+	klog.InfoDepth(1, append([]interface{}{k.prefix}, args...)...)
+}
+
+// Infof logs a formatted message at the info level
+func (k ContextKlog) Infof(format string, args ...interface{}) {
+	klog.InfofDepth(1, k.prefix+format, args...)
+}
+
+// Warningf logs a formatted message at the warning level
+func (k ContextKlog) Warningf(format string, args ...interface{}) {
+	klog.WarningfDepth(1, k.prefix+format, args...)
+}
+
+// Errorf logs a formatted message at the error level
+func (k ContextKlog) Errorf(format string, args ...interface{}) {
+	klog.ErrorfDepth(1, k.prefix+format, args...)
+}
+
+// V returns a logger for messages at a klog verbosity level
+func (k ContextKlog) V(level klog.Level) ContextVerbose {
+	return ContextVerbose{verbose: klog.V(level), prefix: k.prefix}
+}
+
+// ContextVerbose logs at a klog verbosity level, see ContextKlog
+type ContextVerbose struct {
+	verbose klog.Verbose
+	prefix  string
+}
+
+// Enabled reports whether the verbosity level is logged
+func (v ContextVerbose) Enabled() bool {
+	return v.verbose.Enabled()
+}
+
+// Info logs args if the verbosity level is enabled
+func (v ContextVerbose) Info(args ...interface{}) {
+	v.verbose.InfoDepth(1, append([]interface{}{v.prefix}, args...)...)
+}
+
+// Infof logs a formatted message if the verbosity level is enabled
+func (v ContextVerbose) Infof(format string, args ...interface{}) {
+	v.verbose.InfofDepth(1, v.prefix+format, args...)
+}
@@ -1,7 +1,9 @@
 package logging
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"time"
@@ -24,22 +26,29 @@ type Logger struct {
 	component string
 	level     LogLevel
 	jsonMode  bool
+
+	// csiRequestID is added to every entry of a logger bound to a CSI call
+	csiRequestID string
 }
 
 // LogEntry represents a structured log entry
 type LogEntry struct {
-	Timestamp  string                 `json:"timestamp"`
-	Level      string                 `json:"level"`
-	Component  string                 `json:"component"`
-	Message    string                 `json:"message"`
-	Operation  string                 `json:"operation,omitempty"`
-	VolumeID   string                 `json:"volumeId,omitempty"`
-	NodeID     string                 `json:"nodeId,omitempty"`
-	DurationMS int64                  `json:"duration_ms,omitempty"`
-	Error      string                 `json:"error,omitempty"`
-	Fields     map[string]interface{} `json:"fields,omitempty"`
+	Timestamp    string                 `json:"timestamp"`
+	Level        string                 `json:"level"`
+	Component    string                 `json:"component"`
+	Message      string                 `json:"message"`
+	Operation    string                 `json:"operation,omitempty"`
+	CSIRequestID string                 `json:"csiRequestId,omitempty"`
+	VolumeID     string                 `json:"volumeId,omitempty"`
+	NodeID       string                 `json:"nodeId,omitempty"`
+	DurationMS   int64                  `json:"duration_ms,omitempty"`
+	Error        string                 `json:"error,omitempty"`
+	Fields       map[string]interface{} `json:"fields,omitempty"`
 }
 
+// csiRequestIDField is the log field of the CSI request ID
+const csiRequestIDField = "csiRequestId"
+
 var (
 	globalLevel    = InfoLevel
 	globalJSONMode = false
@@ -75,6 +84,18 @@ func NewLogger(component string) *Logger {
 	}
 }
 
+// WithContext returns a logger adding the CSI request ID carried by ctx, if any, to every
+// entry, so the log lines of one CSI call can be correlated
+func (l *Logger) WithContext(ctx context.Context) *Logger {
+	id := CSIRequestIDFromContext(ctx)
+	if id == "" {
+		return l
+	}
+	bound := *l
+	bound.csiRequestID = id
+	return &bound
+}
+
 // Debug logs a debug message
 func (l *Logger) Debug(msg string, fields ...map[string]interface{}) {
 	if !l.shouldLog(DebugLevel) {
@@ -114,11 +135,24 @@ func (l *Logger) Error(msg string, err error, fields ...map[string]interface{})
 
 	if err != nil {
 		mergedFields["error"] = err.Error()
+		if requestID := RequestIDFromError(err); requestID != "" {
+			mergedFields["requestId"] = requestID
+		}
 	}
 
 	l.log(ErrorLevel, msg, mergedFields)
 }
 
+// RequestIDFromError returns the backend request ID carried by an error chain, or "".
+// Errors carry one by implementing RequestID() string.
+func RequestIDFromError(err error) string {
+	var withID interface{ RequestID() string }
+	if errors.As(err, &withID) {
+		return withID.RequestID()
+	}
+	return ""
+}
+
 // WithOperation creates a new logger with operation context
 func (l *Logger) WithOperation(operation string) *OperationLogger {
 	return &OperationLogger{
@@ -143,6 +177,16 @@ func (l *Logger) shouldLog(level LogLevel) bool {
 
 // log writes a log entry
 func (l *Logger) log(level LogLevel, msg string, fields ...map[string]interface{}) {
+	if l.csiRequestID != "" {
+		merged := map[string]interface{}{csiRequestIDField: l.csiRequestID}
+		if len(fields) > 0 {
+			for k, v := range fields[0] {
+				merged[k] = v
+			}
+		}
+		fields = []map[string]interface{}{merged}
+	}
+
 	if l.jsonMode {
 		entry := LogEntry{
 			Timestamp: time.Now().UTC().Format(time.RFC3339),
@@ -158,6 +202,9 @@ func (l *Logger) log(level LogLevel, msg string, fields ...map[string]interface{
 			if op, ok := fields[0]["operation"].(string); ok {
 				entry.Operation = op
 			}
+			if id, ok := fields[0][csiRequestIDField].(string); ok {
+				entry.CSIRequestID = id
+			}
 			if vid, ok := fields[0]["volumeId"].(string); ok {
 				entry.VolumeID = vid
 			}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	attachAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "volume_attach_age_seconds"),
+		"Seconds since the current attachment of a volume was established (controller bookkeeping)",
+		[]string{"volume_id"},
+		nil,
+	)
+
+	attachAgeMu     sync.RWMutex
+	attachAgeSource func() map[string]time.Time
+)
+
+// attachAgeCollector reports the attach age of every attached volume at scrape time
+type attachAgeCollector struct{}
+
+// Describe implements prometheus.Collector
+func (attachAgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- attachAgeDesc
+}
+
+// Collect implements prometheus.Collector
+func (attachAgeCollector) Collect(ch chan<- prometheus.Metric) {
+	attachAgeMu.RLock()
+	source := attachAgeSource
+	attachAgeMu.RUnlock()
+	if source == nil {
+		return
+	}
+
+	now := time.Now()
+	for volumeID, attachedAt := range source() {
+		ch <- prometheus.MustNewConstMetric(attachAgeDesc, prometheus.GaugeValue, now.Sub(attachedAt).Seconds(), volumeID)
+	}
+}
+
+// SetAttachAgeSource sets the function providing the attach time of each attached volume
+func SetAttachAgeSource(source func() map[string]time.Time) {
+	attachAgeMu.Lock()
+	defer attachAgeMu.Unlock()
+
+	attachAgeSource = source
+}
@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// DefaultOperationBuckets cover fast idempotent CSI calls (~50ms) up to the 5 minute operation timeouts
+	DefaultOperationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 180, 300}
+
+	// DefaultAPIRequestBuckets cover sub-100ms Emma API calls up to the 30s HTTP client timeout
+	DefaultAPIRequestBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30}
+
+	// DefaultAttachBuckets cover volume attach/detach from a few seconds up to the 5 minute wait timeout
+	DefaultAttachBuckets = []float64{1, 2.5, 5, 10, 15, 20, 30, 45, 60, 90, 120, 180, 240, 300}
+)
+
+// BucketConfig holds histogram bucket boundaries in seconds. Nil fields keep the defaults.
+type BucketConfig struct {
+	Operation  []float64
+	APIRequest []float64
+	Attach     []float64
+}
+
+// ParseBuckets parses a comma-separated list of bucket boundaries in seconds.
+// An empty string returns nil so that the default buckets are kept.
+func ParseBuckets(value string) ([]float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(value, ",")
+	buckets := make([]float64, 0, len(parts))
+	for _, part := range parts {
+		bucket, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket %q: %w", part, err)
+		}
+		if bucket <= 0 {
+			return nil, fmt.Errorf("invalid bucket %q: must be positive", part)
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	if !sort.Float64sAreSorted(buckets) {
+		return nil, fmt.Errorf("buckets must be in increasing order: %s", value)
+	}
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] == buckets[i-1] {
+			return nil, fmt.Errorf("duplicate bucket %v", buckets[i])
+		}
+	}
+
+	return buckets, nil
+}
+
+// ConfigureBuckets replaces the duration histograms with ones using the given buckets.
+// It must be called during startup, before any metrics are recorded.
+func ConfigureBuckets(cfg BucketConfig) {
+	if cfg.Operation != nil {
+		prometheus.Unregister(operationDuration)
+		operationDuration = newOperationDuration(cfg.Operation)
+		prometheus.MustRegister(operationDuration)
+	}
+
+	if cfg.APIRequest != nil {
+		prometheus.Unregister(apiRequestDuration)
+		apiRequestDuration = newAPIRequestDuration(cfg.APIRequest)
+		prometheus.MustRegister(apiRequestDuration)
+	}
+
+	if cfg.Attach != nil {
+		prometheus.Unregister(volumeAttachDuration)
+		prometheus.Unregister(volumeDetachDuration)
+		volumeAttachDuration = newVolumeAttachDuration(cfg.Attach)
+		volumeDetachDuration = newVolumeDetachDuration(cfg.Attach)
+		prometheus.MustRegister(volumeAttachDuration)
+		prometheus.MustRegister(volumeDetachDuration)
+	}
+}
+
+func newOperationDuration(buckets []float64) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of CSI operations in seconds",
+			Buckets:   buckets,
+		},
+		[]string{"operation"},
+	)
+}
+
+func newAPIRequestDuration(buckets []float64) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "api_request_duration_seconds",
+			Help:      "Duration of Emma API requests in seconds",
+			Buckets:   buckets,
+		},
+		[]string{"method", "endpoint"},
+	)
+}
+
+func newVolumeAttachDuration(buckets []float64) prometheus.Histogram {
+	return prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "volume_attach_duration_seconds",
+			Help:      "Duration of volume attach operations in seconds",
+			Buckets:   buckets,
+		},
+	)
+}
+
+func newVolumeDetachDuration(buckets []float64) prometheus.Histogram {
+	return prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "volume_detach_duration_seconds",
+			Help:      "Duration of volume detach operations in seconds",
+			Buckets:   buckets,
+		},
+	)
+}
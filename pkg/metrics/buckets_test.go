@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseBuckets(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []float64
+		wantErr bool
+	}{
+		{
+			name:  "empty keeps defaults",
+			value: "",
+			want:  nil,
+		},
+		{
+			name:  "valid buckets",
+			value: "0.01, 0.1,1,10",
+			want:  []float64{0.01, 0.1, 1, 10},
+		},
+		{
+			name:    "not a number",
+			value:   "0.1,abc",
+			wantErr: true,
+		},
+		{
+			name:    "not increasing",
+			value:   "1,0.5",
+			wantErr: true,
+		},
+		{
+			name:    "duplicate",
+			value:   "1,1",
+			wantErr: true,
+		},
+		{
+			name:    "non-positive",
+			value:   "0,1",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseBuckets(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseBuckets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseBuckets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigureBuckets(t *testing.T) {
+	defer ConfigureBuckets(BucketConfig{
+		Operation:  DefaultOperationBuckets,
+		APIRequest: DefaultAPIRequestBuckets,
+		Attach:     DefaultAttachBuckets,
+	})
+
+	ConfigureBuckets(BucketConfig{
+		Operation:  []float64{1, 2},
+		APIRequest: []float64{0.1},
+		Attach:     []float64{10, 20},
+	})
+
+	// Recording after reconfiguration must use the re-registered collectors
+	RecordOperation("CreateVolume", "success", 0)
+	RecordAPIRequest("default", "GET", "/v1/volumes", "OK", 0)
+	RecordVolumeAttach(0)
+	RecordVolumeDetach(0)
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "emma_csi_operation_duration_seconds" {
+			continue
+		}
+		buckets := family.GetMetric()[0].GetHistogram().GetBucket()
+		if len(buckets) != 2 {
+			t.Errorf("expected 2 operation buckets, got %d", len(buckets))
+		}
+		return
+	}
+	t.Error("operation duration histogram not registered")
+}
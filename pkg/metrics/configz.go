@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+var (
+	configzMutex sync.RWMutex
+	configz      = make(map[string]interface{})
+)
+
+// SetConfigz publishes a value under the given key on the /configz endpoint
+func SetConfigz(key string, value interface{}) {
+	configzMutex.Lock()
+	defer configzMutex.Unlock()
+	configz[key] = value
+}
+
+// configzHandler serves all published configz values as JSON
+func configzHandler(w http.ResponseWriter, r *http.Request) {
+	configzMutex.RLock()
+	defer configzMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(configz); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+var (
+	featurezMutex    sync.RWMutex
+	featurezProvider func() interface{}
+)
+
+// SetFeaturez sets the function whose result is served as JSON on the /featurez endpoint
+func SetFeaturez(provider func() interface{}) {
+	featurezMutex.Lock()
+	defer featurezMutex.Unlock()
+	featurezProvider = provider
+}
+
+// featurezHandler serves the advertised capabilities and enabled features as JSON
+func featurezHandler(w http.ResponseWriter, r *http.Request) {
+	featurezMutex.RLock()
+	provider := featurezProvider
+	featurezMutex.RUnlock()
+	if provider == nil {
+		http.Error(w, "features not available yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(provider()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
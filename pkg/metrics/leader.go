@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	controllerLeader = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "controller_leader",
+			Help:      "Whether this controller replica holds the leader lease (1 = leader, 0 = follower), reported with leader election only",
+		},
+	)
+
+	leaderMutex  sync.RWMutex
+	leaderStatus = LeaderStatus{Leader: true}
+)
+
+// LeaderStatus is the leader election state served on the /leaderz endpoint
+type LeaderStatus struct {
+	LeaderElection bool   `json:"leaderElection"`
+	Leader         bool   `json:"leader"`
+	Identity       string `json:"identity,omitempty"`
+}
+
+// EnableLeaderElection marks this replica as taking part in leader election under identity,
+// starting as a follower. Without it the replica is always the leader. Call it once.
+func EnableLeaderElection(identity string) {
+	prometheus.MustRegister(controllerLeader)
+
+	leaderMutex.Lock()
+	defer leaderMutex.Unlock()
+	leaderStatus = LeaderStatus{LeaderElection: true, Identity: identity}
+	controllerLeader.Set(0)
+}
+
+// SetLeader records whether this replica holds the leader lease
+func SetLeader(leader bool) {
+	leaderMutex.Lock()
+	defer leaderMutex.Unlock()
+	leaderStatus.Leader = leader
+	if leader {
+		controllerLeader.Set(1)
+	} else {
+		controllerLeader.Set(0)
+	}
+}
+
+// IsLeader reports whether this replica holds the leader lease, or runs without leader election
+func IsLeader() bool {
+	leaderMutex.RLock()
+	defer leaderMutex.RUnlock()
+	return leaderStatus.Leader
+}
+
+// leaderzHandler serves the leader election state, with status 503 on followers so the
+// endpoint can tell which replica leads
+func leaderzHandler(w http.ResponseWriter, r *http.Request) {
+	leaderMutex.RLock()
+	current := leaderStatus
+	leaderMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !current.Leader {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(current)
+}
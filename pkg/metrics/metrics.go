@@ -1,7 +1,10 @@
 package metrics
 
 import (
+	"fmt"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -19,41 +22,129 @@ var (
 		prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      "operations_total",
-			Help:      "Total number of CSI operations",
+			Help:      "Total number of CSI operations by gRPC status code",
 		},
 		[]string{"operation", "status"},
 	)
 
-	operationDuration = prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Namespace: namespace,
-			Name:      "operation_duration_seconds",
-			Help:      "Duration of CSI operations in seconds",
-			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10), // 0.1s to ~102s
-		},
-		[]string{"operation"},
-	)
+	operationDuration = newOperationDuration(DefaultOperationBuckets)
 
 	// API request metrics
 	apiRequestsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      "api_requests_total",
-			Help:      "Total number of Emma API requests",
+			Help:      "Total number of Emma API requests by Emma account",
 		},
-		[]string{"method", "endpoint", "status"},
+		[]string{"account", "method", "endpoint", "status"},
 	)
 
-	apiRequestDuration = prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
+	apiRequestDuration = newAPIRequestDuration(DefaultAPIRequestBuckets)
+
+	apiAuthFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
 			Namespace: namespace,
-			Name:      "api_request_duration_seconds",
-			Help:      "Duration of Emma API requests in seconds",
-			Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10), // 0.05s to ~51s
+			Name:      "api_auth_failures_total",
+			Help:      "Total number of Emma API authentication failures by type (unauthorized, forbidden)",
+		},
+		[]string{"type"},
+	)
+
+	apiRateLimitedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "api_rate_limited_total",
+			Help:      "Total number of Emma API responses with status 429 Too Many Requests",
 		},
 		[]string{"method", "endpoint"},
 	)
 
+	apiRetriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "api_retries_total",
+			Help:      "Total number of Emma API request retries by reason (response status code or error)",
+		},
+		[]string{"method", "endpoint", "reason"},
+	)
+
+	apiPermissionReady = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "api_permission_ready",
+			Help:      "Whether the Emma API credentials passed the startup permission check for an API area (1 = ok, 0 = failed)",
+		},
+		[]string{"area"},
+	)
+
+	dataCenterFallbacksTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "datacenter_fallbacks_total",
+			Help:      "Total number of volume creations retried in a fallback datacenter",
+		},
+		[]string{"from", "to"},
+	)
+
+	attachNodeChangesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "volume_attach_node_changes_total",
+			Help:      "Total number of volume attachments to a different node than the previous attachment",
+		},
+	)
+
+	inconsistentVolumeStatesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "api_inconsistent_volume_states_total",
+			Help:      "Total number of Emma API volume observations whose status contradicts the attachment",
+		},
+		[]string{"status", "attached"},
+	)
+
+	attachRemediationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "volume_attach_remediations_total",
+			Help:      "Total number of detach-and-retry remediations of attaches that left the volume FAILED, by result",
+		},
+		[]string{"result"},
+	)
+
+	deleteQueueWaiting = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "delete_queue_waiting",
+			Help:      "Number of DeleteVolume calls waiting for a delete worker",
+		},
+	)
+
+	volumeWaitersActive = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "volume_waiters_active",
+			Help:      "Number of volume state waits currently polling the Emma API",
+		},
+	)
+
+	volumeWaitersRejectedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "volume_waiters_rejected_total",
+			Help:      "Total number of operations rejected because the volume waiter limit was reached",
+		},
+	)
+
+	webhookNotificationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "webhook_notifications_total",
+			Help:      "Total number of volume lifecycle webhook notifications by event type and result (success, error, dropped)",
+		},
+		[]string{"event", "result"},
+	)
+
 	// Volume state metrics
 	volumesTotal = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -65,22 +156,48 @@ var (
 	)
 
 	// Volume operation specific metrics
-	volumeAttachDuration = prometheus.NewHistogram(
+	volumeAttachDuration = newVolumeAttachDuration(DefaultAttachBuckets)
+	volumeDetachDuration = newVolumeDetachDuration(DefaultAttachBuckets)
+
+	// Node operation metrics
+	nodeOperationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "node_operation_duration_seconds",
+			Help:      "Duration of CSI node operations in seconds by filesystem type and gRPC status code",
+			Buckets:   DefaultOperationBuckets,
+		},
+		[]string{"operation", "fs_type", "status"},
+	)
+
+	deviceDiscoveryDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "device_discovery_duration_seconds",
+			Help:      "Duration of finding the block device of an attached volume on the node in seconds",
+			Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60},
+		},
+		[]string{"status"},
+	)
+
+	volumeFormatDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: namespace,
-			Name:      "volume_attach_duration_seconds",
-			Help:      "Duration of volume attach operations in seconds",
-			Buckets:   prometheus.ExponentialBuckets(1, 2, 10), // 1s to ~1024s
+			Name:      "volume_format_duration_seconds",
+			Help:      "Duration of mkfs on a volume in seconds by filesystem type and size range in GB (<10, <100, <1000, >=1000)",
+			Buckets:   []float64{0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300, 600},
 		},
+		[]string{"fs_type", "size_gb"},
 	)
 
-	volumeDetachDuration = prometheus.NewHistogram(
+	volumeSizeOverhead = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: namespace,
-			Name:      "volume_detach_duration_seconds",
-			Help:      "Duration of volume detach operations in seconds",
-			Buckets:   prometheus.ExponentialBuckets(1, 2, 10), // 1s to ~1024s
+			Name:      "volume_size_overhead_gib",
+			Help:      "GiB provisioned beyond the requested capacity of each new volume, from rounding to sizes Emma accepts, by datacenter",
+			Buckets:   []float64{0, 1, 4, 16, 64, 256, 1024},
 		},
+		[]string{"datacenter"},
 	)
 )
 
@@ -90,23 +207,110 @@ func init() {
 	prometheus.MustRegister(operationDuration)
 	prometheus.MustRegister(apiRequestsTotal)
 	prometheus.MustRegister(apiRequestDuration)
+	prometheus.MustRegister(apiAuthFailuresTotal)
+	prometheus.MustRegister(apiRateLimitedTotal)
+	prometheus.MustRegister(apiRetriesTotal)
+	prometheus.MustRegister(apiPermissionReady)
+	prometheus.MustRegister(dataCenterFallbacksTotal)
+	prometheus.MustRegister(attachNodeChangesTotal)
+	prometheus.MustRegister(inconsistentVolumeStatesTotal)
+	prometheus.MustRegister(attachRemediationsTotal)
+	prometheus.MustRegister(deleteQueueWaiting)
+	prometheus.MustRegister(volumeWaitersActive)
+	prometheus.MustRegister(volumeWaitersRejectedTotal)
+	prometheus.MustRegister(webhookNotificationsTotal)
 	prometheus.MustRegister(volumesTotal)
 	prometheus.MustRegister(volumeAttachDuration)
 	prometheus.MustRegister(volumeDetachDuration)
+	prometheus.MustRegister(volumeFormatDuration)
+	prometheus.MustRegister(volumeSizeOverhead)
+	prometheus.MustRegister(nodeOperationDuration)
+	prometheus.MustRegister(deviceDiscoveryDuration)
+	prometheus.MustRegister(attachAgeCollector{})
 }
 
-// RecordOperation records a CSI operation
+// RecordOperation records a CSI operation with its gRPC status code
 func RecordOperation(operation string, status string, duration time.Duration) {
 	operationsTotal.WithLabelValues(operation, status).Inc()
 	operationDuration.WithLabelValues(operation).Observe(duration.Seconds())
 }
 
-// RecordAPIRequest records an Emma API request
-func RecordAPIRequest(method, endpoint, status string, duration time.Duration) {
-	apiRequestsTotal.WithLabelValues(method, endpoint, status).Inc()
+// RecordNodeOperation records a node operation on a volume of a filesystem type with its
+// gRPC status code
+func RecordNodeOperation(operation, fsType, status string, duration time.Duration) {
+	nodeOperationDuration.WithLabelValues(operation, fsType, status).Observe(duration.Seconds())
+}
+
+// RecordAPIRequest records an Emma API request made with an account's credentials
+func RecordAPIRequest(account, method, endpoint, status string, duration time.Duration) {
+	apiRequestsTotal.WithLabelValues(account, method, endpoint, status).Inc()
 	apiRequestDuration.WithLabelValues(method, endpoint).Observe(duration.Seconds())
 }
 
+// RecordAuthFailure records an Emma API authentication failure
+func RecordAuthFailure(failureType string) {
+	apiAuthFailuresTotal.WithLabelValues(failureType).Inc()
+}
+
+// RecordAPIRateLimited records an Emma API request rejected with 429 Too Many Requests
+func RecordAPIRateLimited(method, endpoint string) {
+	apiRateLimitedTotal.WithLabelValues(method, endpoint).Inc()
+}
+
+// RecordAPIRetry records the retry of an Emma API request
+func RecordAPIRetry(method, endpoint, reason string) {
+	apiRetriesTotal.WithLabelValues(method, endpoint, reason).Inc()
+}
+
+// SetAPIPermissionReady records the result of the startup permission check for an API area
+func SetAPIPermissionReady(area string, ready bool) {
+	value := 0.0
+	if ready {
+		value = 1.0
+	}
+	apiPermissionReady.WithLabelValues(area).Set(value)
+}
+
+// RecordDataCenterFallback records a volume creation retried in a fallback datacenter
+func RecordDataCenterFallback(from, to string) {
+	dataCenterFallbacksTotal.WithLabelValues(from, to).Inc()
+}
+
+// RecordAttachNodeChange records a volume attached to a different node than before
+func RecordAttachNodeChange() {
+	attachNodeChangesTotal.Inc()
+}
+
+// RecordInconsistentVolumeState records an Emma volume observation with contradicting status and attachment
+func RecordInconsistentVolumeState(status string, attached bool) {
+	inconsistentVolumeStatesTotal.WithLabelValues(status, strconv.FormatBool(attached)).Inc()
+}
+
+// RecordAttachRemediation records a detach-and-retry remediation of a FAILED attach
+func RecordAttachRemediation(result string) {
+	attachRemediationsTotal.WithLabelValues(result).Inc()
+}
+
+// AddDeleteQueueWaiting adjusts the number of DeleteVolume calls waiting for a worker
+func AddDeleteQueueWaiting(delta float64) {
+	deleteQueueWaiting.Add(delta)
+}
+
+// AddVolumeWaiters adjusts the number of active volume state waits
+func AddVolumeWaiters(delta float64) {
+	volumeWaitersActive.Add(delta)
+}
+
+// RecordVolumeWaiterRejected records an operation rejected at the volume waiter limit
+func RecordVolumeWaiterRejected() {
+	volumeWaitersRejectedTotal.Inc()
+}
+
+// RecordWebhookNotification records the result of a webhook notification
+func RecordWebhookNotification(event, result string) {
+	webhookNotificationsTotal.WithLabelValues(event, result).Inc()
+}
+
 // SetVolumeCount sets the count of volumes by status
 func SetVolumeCount(status string, count float64) {
 	volumesTotal.WithLabelValues(status).Set(count)
@@ -122,42 +326,57 @@ func RecordVolumeDetach(duration time.Duration) {
 	volumeDetachDuration.Observe(duration.Seconds())
 }
 
-// OperationTimer helps track operation duration
-type OperationTimer struct {
-	operation string
-	startTime time.Time
+// RecordVolumeFormat records a volume format duration
+func RecordVolumeFormat(fsType string, sizeGB int64, duration time.Duration) {
+	volumeFormatDuration.WithLabelValues(fsType, formatSizeRange(sizeGB)).Observe(duration.Seconds())
 }
 
-// NewOperationTimer creates a new operation timer
-func NewOperationTimer(operation string) *OperationTimer {
-	return &OperationTimer{
-		operation: operation,
-		startTime: time.Now(),
+// formatSizeRange maps a volume size to one of a few coarse ranges, keeping the size label
+// of the format metric bounded
+func formatSizeRange(sizeGB int64) string {
+	switch {
+	case sizeGB < 10:
+		return "<10"
+	case sizeGB < 100:
+		return "<100"
+	case sizeGB < 1000:
+		return "<1000"
+	default:
+		return ">=1000"
 	}
 }
 
-// ObserveSuccess records a successful operation
-func (t *OperationTimer) ObserveSuccess() {
-	duration := time.Since(t.startTime)
-	RecordOperation(t.operation, "success", duration)
+// RecordVolumeSizeOverhead records the bytes a new volume was provisioned with beyond its
+// requested capacity
+func RecordVolumeSizeOverhead(dataCenterID string, overheadBytes int64) {
+	volumeSizeOverhead.WithLabelValues(dataCenterID).Observe(float64(overheadBytes) / (1 << 30))
 }
 
-// ObserveError records a failed operation
-func (t *OperationTimer) ObserveError() {
-	duration := time.Since(t.startTime)
-	RecordOperation(t.operation, "error", duration)
+// RecordDeviceDiscovery records the duration of a device discovery
+func RecordDeviceDiscovery(err error, duration time.Duration) {
+	deviceDiscoveryDuration.WithLabelValues(statusLabel(err)).Observe(duration.Seconds())
+}
+
+// statusLabel returns the status label value for an operation result
+func statusLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
 }
 
 // APIRequestTimer helps track API request duration
 type APIRequestTimer struct {
+	account   string
 	method    string
 	endpoint  string
 	startTime time.Time
 }
 
-// NewAPIRequestTimer creates a new API request timer
-func NewAPIRequestTimer(method, endpoint string) *APIRequestTimer {
+// NewAPIRequestTimer creates a new API request timer for an Emma account
+func NewAPIRequestTimer(account, method, endpoint string) *APIRequestTimer {
 	return &APIRequestTimer{
+		account:   account,
 		method:    method,
 		endpoint:  endpoint,
 		startTime: time.Now(),
@@ -171,15 +390,19 @@ func (t *APIRequestTimer) Observe(statusCode int) {
 	if status == "" {
 		status = "unknown"
 	}
-	RecordAPIRequest(t.method, t.endpoint, status, duration)
+	RecordAPIRequest(t.account, t.method, t.endpoint, status, duration)
 }
 
-// StartMetricsServer starts the Prometheus metrics HTTP server
-func StartMetricsServer(addr string) error {
+// StartMetricsServer starts the Prometheus metrics HTTP server in the background. The
+// caller stops it with Shutdown.
+func StartMetricsServer(addr string) (*http.Server, error) {
 	klog.Infof("Starting metrics server on %s", addr)
 
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/configz", configzHandler)
+	mux.HandleFunc("/featurez", featurezHandler)
+	mux.HandleFunc("/leaderz", leaderzHandler)
 
 	// Add health check endpoint
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -192,12 +415,18 @@ func StartMetricsServer(addr string) error {
 		Handler: mux,
 	}
 
+	// Listen before returning, so a port already in use fails startup
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
 			klog.Errorf("Metrics server error: %v", err)
 		}
 	}()
 
 	klog.Info("Metrics server started successfully")
-	return nil
+	return server, nil
 }
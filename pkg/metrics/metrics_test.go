@@ -0,0 +1,23 @@
+package metrics
+
+import "testing"
+
+func TestFormatSizeRange(t *testing.T) {
+	tests := []struct {
+		sizeGB   int64
+		expected string
+	}{
+		{sizeGB: 1, expected: "<10"},
+		{sizeGB: 10, expected: "<100"},
+		{sizeGB: 99, expected: "<100"},
+		{sizeGB: 500, expected: "<1000"},
+		{sizeGB: 1000, expected: ">=1000"},
+		{sizeGB: 16384, expected: ">=1000"},
+	}
+
+	for _, tt := range tests {
+		if got := formatSizeRange(tt.sizeGB); got != tt.expected {
+			t.Errorf("formatSizeRange(%d) = %q, expected %q", tt.sizeGB, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package mount
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// luksFilesystemType is the type blkid reports for a LUKS container
+const luksFilesystemType = "crypto_LUKS"
+
+// EncryptedDeviceName returns the device-mapper name of a volume's opened LUKS container
+func EncryptedDeviceName(volumeID string) string {
+	return "emma-csi-" + volumeID
+}
+
+// mapperPath returns the /dev/mapper path of a device-mapper name
+func mapperPath(name string) string {
+	return filepath.Join("/dev/mapper", name)
+}
+
+// OpenEncryptedDevice opens the LUKS container on source as name and returns the path of
+// the decrypted device. A blank device is LUKS-formatted first; a device holding anything
+// else is only formatted with force. A device expected to be formatted is never formatted,
+// it must already hold a LUKS container. An already open container is reused.
+func (m *LinuxMounter) OpenEncryptedDevice(source, name, passphrase string, force, expectFormatted bool) (string, error) {
+	devicePath := mapperPath(name)
+	if m.isBlockDevice(devicePath) {
+		klog.V(4).Infof("Encrypted device %s is already open at %s", source, devicePath)
+		return devicePath, nil
+	}
+
+	existingFS, err := m.getFilesystemType(source)
+	if err != nil {
+		return "", fmt.Errorf("failed to check existing filesystem: %w", err)
+	}
+
+	switch {
+	case existingFS == luksFilesystemType:
+		klog.V(4).Infof("Device %s already holds a LUKS container", source)
+	case expectFormatted && existingFS == "":
+		return "", fmt.Errorf("%w: no LUKS container found on %s, refusing to format a volume expected to contain data", ErrNotFormatted, source)
+	case expectFormatted:
+		return "", fmt.Errorf("%w: %s holds %s, not a LUKS container, refusing to format a volume expected to contain data", ErrNotFormatted, source, existingFS)
+	case existingFS == "":
+		klog.V(4).Infof("Formatting device %s as a LUKS container", source)
+		if err := m.luksFormat(source, passphrase); err != nil {
+			return "", err
+		}
+	case force:
+		klog.Warningf("Formatting device %s, which holds %s, as a LUKS container as forced", source, existingFS)
+		if err := m.luksFormat(source, passphrase); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("%w: %s holds %s, not a LUKS container, refusing to encrypt it", ErrFilesystemMismatch, source, existingFS)
+	}
+
+	klog.V(4).Infof("Opening LUKS container on %s as %s", source, name)
+	if err := m.cryptsetup(passphrase, "open", "--type", "luks", "--key-file", "-", source, name); err != nil {
+		return "", err
+	}
+	return devicePath, nil
+}
+
+// CloseEncryptedDevice closes the LUKS container opened as name, if it is open
+func (m *LinuxMounter) CloseEncryptedDevice(name string) error {
+	if !m.isBlockDevice(mapperPath(name)) {
+		return nil
+	}
+
+	klog.V(4).Infof("Closing encrypted device %s", name)
+	return m.cryptsetup("", "close", name)
+}
+
+// ResizeEncryptedDevice grows the opened LUKS container name to the size of its device and
+// returns the path of the decrypted device, "" when the container is not open. passphrase
+// may be empty when the volume key is in the kernel keyring.
+func (m *LinuxMounter) ResizeEncryptedDevice(name, passphrase string) (string, error) {
+	devicePath := mapperPath(name)
+	if !m.isBlockDevice(devicePath) {
+		return "", nil
+	}
+
+	klog.V(4).Infof("Resizing encrypted device %s", name)
+	args := []string{"resize", name}
+	if passphrase != "" {
+		args = append(args, "--key-file", "-")
+	}
+	if err := m.cryptsetup(passphrase, args...); err != nil {
+		return "", err
+	}
+	return devicePath, nil
+}
+
+// luksFormat formats source as a LUKS2 container protected by passphrase
+func (m *LinuxMounter) luksFormat(source, passphrase string) error {
+	return m.cryptsetup(passphrase, "luksFormat", "--batch-mode", "--type", "luks2", "--key-file", "-", source)
+}
+
+// cryptsetup runs cryptsetup, passing the passphrase, if any, on stdin so it never shows
+// up in the process list
+func (m *LinuxMounter) cryptsetup(passphrase string, args ...string) error {
+	cmd := m.formatter.Exec.Command("cryptsetup", args...)
+	if passphrase != "" {
+		cmd.SetStdin(strings.NewReader(passphrase))
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("cryptsetup %s failed: %w, output: %s", args[0], err, string(output))
+	}
+	return nil
+}
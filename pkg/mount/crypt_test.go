@@ -0,0 +1,152 @@
+package mount
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	mountutils "k8s.io/mount-utils"
+	utilexec "k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+)
+
+func TestLinuxMounterOpenEncryptedDevice(t *testing.T) {
+	blkid := "blkid -p -s TYPE -s PTTYPE -o export /dev/vdb"
+	luksFormat := "cryptsetup luksFormat --batch-mode --type luks2 --key-file - /dev/vdb"
+	open := "cryptsetup open --type luks --key-file - /dev/vdb emma-csi-5"
+
+	tests := []struct {
+		name            string
+		isOpen          bool
+		force           bool
+		expectFormatted bool
+		commands        []fakeCommand
+		wantCalls       []string
+		wantErr         error
+	}{
+		{
+			name:      "blank device is formatted and opened",
+			commands:  []fakeCommand{{err: testingexec.FakeExitError{Status: 2}}, {}, {}},
+			wantCalls: []string{blkid, luksFormat, open},
+		},
+		{
+			name:      "LUKS container is opened without formatting",
+			commands:  []fakeCommand{{output: "DEVNAME=/dev/vdb\nTYPE=crypto_LUKS\n"}, {}},
+			wantCalls: []string{blkid, open},
+		},
+		{
+			name:   "open container is reused",
+			isOpen: true,
+		},
+		{
+			name:      "plain filesystem is not encrypted",
+			commands:  []fakeCommand{{output: "DEVNAME=/dev/vdb\nTYPE=ext4\n"}},
+			wantCalls: []string{blkid},
+			wantErr:   ErrFilesystemMismatch,
+		},
+		{
+			name:      "forced format encrypts a plain filesystem",
+			force:     true,
+			commands:  []fakeCommand{{output: "DEVNAME=/dev/vdb\nTYPE=ext4\n"}, {}, {}},
+			wantCalls: []string{blkid, luksFormat, open},
+		},
+		{
+			name:            "blank device expected to be formatted is not formatted",
+			expectFormatted: true,
+			commands:        []fakeCommand{{err: testingexec.FakeExitError{Status: 2}}},
+			wantCalls:       []string{blkid},
+			wantErr:         ErrNotFormatted,
+		},
+		{
+			name:            "device expected to be formatted is not forced",
+			force:           true,
+			expectFormatted: true,
+			commands:        []fakeCommand{{output: "DEVNAME=/dev/vdb\nTYPE=ext4\n"}},
+			wantCalls:       []string{blkid},
+			wantErr:         ErrNotFormatted,
+		},
+		{
+			name:            "LUKS container expected to be formatted is opened",
+			expectFormatted: true,
+			commands:        []fakeCommand{{output: "DEVNAME=/dev/vdb\nTYPE=crypto_LUKS\n"}, {}},
+			wantCalls:       []string{blkid, open},
+		},
+		{
+			name:      "open failures are returned",
+			commands:  []fakeCommand{{output: "DEVNAME=/dev/vdb\nTYPE=crypto_LUKS\n"}, {output: "No key available", err: testingexec.FakeExitError{Status: 2}}},
+			wantCalls: []string{blkid, open},
+			wantErr:   testingexec.FakeExitError{Status: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			m := newLinuxMounter(mountutils.NewFakeMounter(nil), newFakeExec(tt.commands, &calls))
+			m.blockDevice = func(path string) bool { return tt.isOpen && path == "/dev/mapper/emma-csi-5" }
+
+			devicePath, err := m.OpenEncryptedDevice("/dev/vdb", EncryptedDeviceName("5"), "secret", tt.force, tt.expectFormatted)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("expected %v, got %v", tt.wantErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if devicePath != "/dev/mapper/emma-csi-5" {
+				t.Errorf("expected /dev/mapper/emma-csi-5, got %s", devicePath)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("expected commands %q, got %q", tt.wantCalls, calls)
+			}
+		})
+	}
+}
+
+func TestLinuxMounterCryptsetupPassphraseOnStdin(t *testing.T) {
+	var stdin []string
+	fake := &testingexec.FakeExec{}
+	for i := 0; i < 2; i++ {
+		fake.CommandScript = append(fake.CommandScript, func(cmd string, args ...string) utilexec.Cmd {
+			fakeCmd := &testingexec.FakeCmd{}
+			fakeCmd.CombinedOutputScript = []testingexec.FakeAction{func() ([]byte, []byte, error) {
+				input := ""
+				if fakeCmd.Stdin != nil {
+					data, _ := io.ReadAll(fakeCmd.Stdin)
+					input = string(data)
+				}
+				stdin = append(stdin, input)
+				return nil, nil, nil
+			}}
+			return testingexec.InitFakeCmd(fakeCmd, cmd, args...)
+		})
+	}
+	m := newLinuxMounter(mountutils.NewFakeMounter(nil), fake)
+	m.blockDevice = func(path string) bool { return true }
+
+	if _, err := m.ResizeEncryptedDevice("emma-csi-5", "secret"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := m.CloseEncryptedDevice("emma-csi-5"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"secret", ""}; !reflect.DeepEqual(stdin, expected) {
+		t.Errorf("expected stdin %q, got %q", expected, stdin)
+	}
+}
+
+func TestLinuxMounterEncryptedDeviceNotOpen(t *testing.T) {
+	var calls []string
+	m := newLinuxMounter(mountutils.NewFakeMounter(nil), newFakeExec(nil, &calls))
+	m.blockDevice = func(path string) bool { return false }
+
+	if err := m.CloseEncryptedDevice("emma-csi-5"); err != nil {
+		t.Errorf("unexpected error closing a closed device: %v", err)
+	}
+	if devicePath, err := m.ResizeEncryptedDevice("emma-csi-5", ""); err != nil || devicePath != "" {
+		t.Errorf("expected no device for a closed container, got %q, %v", devicePath, err)
+	}
+	if len(calls) != 0 {
+		t.Errorf("expected no commands, got %q", calls)
+	}
+}
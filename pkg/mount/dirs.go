@@ -0,0 +1,67 @@
+package mount
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// DefaultDirMode is the mode of staging and target directories created by the mounter
+const DefaultDirMode os.FileMode = 0750
+
+// DirOptions controls how the mounter creates staging and target directories
+type DirOptions struct {
+	// Mode is applied to created directories regardless of the process umask
+	Mode os.FileMode
+
+	// SELinuxContext is set on created directories with chcon when not empty
+	// (e.g. system_u:object_r:container_file_t:s0)
+	SELinuxContext string
+}
+
+// chcon is replaced in tests
+var chcon = func(context, path string) ([]byte, error) {
+	return exec.Command("chcon", context, path).CombinedOutput()
+}
+
+// ParseDirMode parses an octal directory mode such as "0750"
+func ParseDirMode(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid directory mode %q: %w", value, err)
+	}
+	if mode > 0777 {
+		return 0, fmt.Errorf("invalid directory mode %q: only permission bits are allowed", value)
+	}
+	if mode&0700 != 0700 {
+		return 0, fmt.Errorf("invalid directory mode %q: owner needs rwx to mount on the directory", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// makeMountDir creates a mount directory with the mounter's mode and SELinux context.
+// Existing directories are left untouched.
+func (m *LinuxMounter) makeMountDir(path string) error {
+	opts := m.dirOptions
+
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(path, opts.Mode); err != nil {
+		return err
+	}
+	// MkdirAll is subject to the umask, set the leaf mode explicitly
+	if err := os.Chmod(path, opts.Mode); err != nil {
+		return fmt.Errorf("failed to set mode %#o on %s: %w", opts.Mode, path, err)
+	}
+
+	if opts.SELinuxContext != "" {
+		if output, err := chcon(opts.SELinuxContext, path); err != nil {
+			return fmt.Errorf("failed to set SELinux context %s on %s: %w, output: %s", opts.SELinuxContext, path, err, string(output))
+		}
+	}
+
+	return nil
+}
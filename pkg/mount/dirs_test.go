@@ -0,0 +1,82 @@
+package mount
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// TestParseDirMode tests parsing of directory modes
+func TestParseDirMode(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    os.FileMode
+		expectError bool
+	}{
+		{input: "0750", expected: 0750},
+		{input: "755", expected: 0755},
+		{input: "0700", expected: 0700},
+		{input: "0650", expectError: true},
+		{input: "4755", expectError: true},
+		{input: "rwx", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			mode, err := ParseDirMode(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error for %q", tt.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if mode != tt.expected {
+				t.Errorf("expected %#o, got %#o", tt.expected, mode)
+			}
+		})
+	}
+}
+
+// TestMakeMountDir tests that created directories get the configured mode and context
+func TestMakeMountDir(t *testing.T) {
+	defer func(orig func(string, string) ([]byte, error)) { chcon = orig }(chcon)
+
+	var labeled []string
+	chcon = func(context, path string) ([]byte, error) {
+		labeled = append(labeled, context+" "+path)
+		return nil, nil
+	}
+
+	oldMask := syscall.Umask(0077)
+	defer syscall.Umask(oldMask)
+
+	m := &LinuxMounter{dirOptions: DirOptions{Mode: 0755, SELinuxContext: "system_u:object_r:container_file_t:s0"}}
+
+	path := filepath.Join(t.TempDir(), "staging", "vol")
+	if err := m.makeMountDir(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("expected mode 0755 despite umask, got %#o", info.Mode().Perm())
+	}
+	if len(labeled) != 1 || labeled[0] != "system_u:object_r:container_file_t:s0 "+path {
+		t.Errorf("expected SELinux context to be set once on %s, got %v", path, labeled)
+	}
+
+	// Existing directories are not relabeled
+	if err := m.makeMountDir(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(labeled) != 1 {
+		t.Errorf("expected existing directory to be left untouched, got %v", labeled)
+	}
+}
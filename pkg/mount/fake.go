@@ -0,0 +1,167 @@
+package mount
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FakeMounter is an in-memory Mounter for tests and for running the node plugin without
+// block devices, as the csi-sanity harness does. Every volume gets a device named after
+// it, formatting and mounting only update the fake's tables, and mount targets are never
+// touched beyond the directories the caller creates.
+type FakeMounter struct {
+	mu sync.Mutex
+
+	// mounts maps mount targets to their source
+	mounts map[string]string
+
+	// filesystems maps devices to the filesystem formatted on them
+	filesystems map[string]string
+
+	// encrypted maps the names of open LUKS containers to their device
+	encrypted map[string]string
+}
+
+// NewFakeMounter creates a fake with no mounts
+func NewFakeMounter() *FakeMounter {
+	return &FakeMounter{
+		mounts:      make(map[string]string),
+		filesystems: make(map[string]string),
+		encrypted:   make(map[string]string),
+	}
+}
+
+// fakeDevicePath is the device the fake reports for a volume
+func fakeDevicePath(volumeID string) string {
+	return "/dev/fake-" + volumeID
+}
+
+// MountPoints returns the mount targets and their sources
+func (f *FakeMounter) MountPoints() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	mounts := make(map[string]string, len(f.mounts))
+	for target, source := range f.mounts {
+		mounts[target] = source
+	}
+	return mounts
+}
+
+// Mount records the mount, creating the target directory like the real mounter
+func (f *FakeMounter) Mount(source, target, fstype string, options []string) error {
+	if err := os.MkdirAll(target, DefaultDirMode); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mounts[filepath.Clean(target)] = source
+	return nil
+}
+
+func (f *FakeMounter) Unmount(target string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.mounts, filepath.Clean(target))
+	return nil
+}
+
+// IsLikelyNotMountPoint returns the error of a missing path, like the real mounter
+func (f *FakeMounter) IsLikelyNotMountPoint(path string) (bool, error) {
+	if _, err := os.Stat(path); err != nil {
+		return true, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, mounted := f.mounts[filepath.Clean(path)]
+	return !mounted, nil
+}
+
+func (f *FakeMounter) FormatAndMount(source, target, fstype string, options []string) error {
+	f.mu.Lock()
+	if existing, ok := f.filesystems[source]; ok && existing != fstype {
+		f.mu.Unlock()
+		return fmt.Errorf("%w: %s holds %s, not %s, refusing to reformat it", ErrFilesystemMismatch, source, existing, fstype)
+	}
+	f.filesystems[source] = fstype
+	f.mu.Unlock()
+	return f.Mount(source, target, fstype, options)
+}
+
+func (f *FakeMounter) ReformatAndMount(source, target, fstype string, options []string) error {
+	f.mu.Lock()
+	f.filesystems[source] = fstype
+	f.mu.Unlock()
+	return f.Mount(source, target, fstype, options)
+}
+
+func (f *FakeMounter) MountFormatted(source, target, fstype string, options []string) error {
+	f.mu.Lock()
+	existing := f.filesystems[source]
+	f.mu.Unlock()
+	if existing != fstype {
+		return fmt.Errorf("%w: %s does not hold %s", ErrNotFormatted, source, fstype)
+	}
+	return f.Mount(source, target, fstype, options)
+}
+
+func (f *FakeMounter) GetDevicePath(volumeID string, hints DeviceHints) (string, error) {
+	return fakeDevicePath(volumeID), nil
+}
+
+func (f *FakeMounter) CheckDevice(device string, hints DeviceHints) error {
+	return nil
+}
+
+func (f *FakeMounter) ResizeFilesystem(devicePath, fstype string) error {
+	return nil
+}
+
+// ResizeFilesystemAtPath resizes the filesystem mounted at a path, as xfs requires
+func (f *FakeMounter) ResizeFilesystemAtPath(mountPath, fstype string) error {
+	return nil
+}
+
+// GetVolumeStats reports an empty 1GiB filesystem
+func (f *FakeMounter) GetVolumeStats(path string) (*VolumeStats, error) {
+	return &VolumeStats{
+		AvailableBytes:  1 << 30,
+		TotalBytes:      1 << 30,
+		AvailableInodes: 65536,
+		TotalInodes:     65536,
+	}, nil
+}
+
+func (f *FakeMounter) SyncFilesystem(path string) error {
+	return nil
+}
+
+func (f *FakeMounter) FreezeFilesystem(path string) error {
+	return nil
+}
+
+func (f *FakeMounter) OpenEncryptedDevice(source, name, passphrase string, force, expectFormatted bool) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.encrypted[name] = source
+	return "/dev/mapper/" + name, nil
+}
+
+func (f *FakeMounter) CloseEncryptedDevice(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.encrypted, name)
+	return nil
+}
+
+func (f *FakeMounter) ResizeEncryptedDevice(name, passphrase string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.encrypted[name]; !ok {
+		return "", nil
+	}
+	return "/dev/mapper/" + name, nil
+}
+
+var _ Mounter = (*FakeMounter)(nil)
@@ -0,0 +1,58 @@
+package mount
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// Flush modes applied to a staged filesystem before it is unmounted
+const (
+	// FlushModeNone unmounts without any extra flushing
+	FlushModeNone = "none"
+
+	// FlushModeSync syncs the filesystem before unmounting
+	FlushModeSync = "sync"
+
+	// FlushModeFreeze syncs the filesystem and, for xfs, freezes and thaws it to flush the log
+	FlushModeFreeze = "fsfreeze"
+)
+
+// ValidateFlushMode checks that mode is a supported flush mode
+func ValidateFlushMode(mode string) error {
+	switch mode {
+	case FlushModeNone, FlushModeSync, FlushModeFreeze:
+		return nil
+	default:
+		return fmt.Errorf("unsupported flush mode %q (supported: %s, %s, %s)", mode, FlushModeNone, FlushModeSync, FlushModeFreeze)
+	}
+}
+
+// SyncFilesystem flushes dirty data of the filesystem mounted at path
+func (m *LinuxMounter) SyncFilesystem(path string) error {
+	cmd := exec.Command("sync", "-f", path)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("sync failed: %w, output: %s", err, string(output))
+	}
+
+	return nil
+}
+
+// FreezeFilesystem freezes and immediately thaws the filesystem mounted at path.
+// Freezing forces all dirty data and metadata to disk.
+func (m *LinuxMounter) FreezeFilesystem(path string) error {
+	cmd := exec.Command("fsfreeze", "-f", path)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("fsfreeze failed: %w, output: %s", err, string(output))
+	}
+
+	// Always thaw, a frozen filesystem blocks the subsequent unmount
+	cmd = exec.Command("fsfreeze", "-u", path)
+	output, err = cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("fsfreeze thaw failed: %w, output: %s", err, string(output))
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package mount
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/emma-csi-driver/pkg/metrics"
+)
+
+// FormatOptions controls how mkfs is run so that formatting large volumes
+// does not starve other workloads on the node of IO
+type FormatOptions struct {
+	// LazyInit defers ext4 inode table and journal initialization to the kernel after mount
+	LazyInit bool
+
+	// NoDiscard skips discarding device blocks at mkfs time
+	NoDiscard bool
+
+	// IoniceClass runs mkfs under ionice with this scheduling class (1 realtime, 2 best-effort, 3 idle), empty disables
+	IoniceClass string
+
+	// Nice runs mkfs under nice with this niceness adjustment, 0 disables
+	Nice int
+
+	// MaxConcurrent limits the number of mkfs processes running at once, 0 means unlimited
+	MaxConcurrent int
+}
+
+// buildFormatCommand returns the command line used to format a device
+func buildFormatCommand(device, fstype string, opts FormatOptions) ([]string, error) {
+	var mkfs []string
+	switch fstype {
+	case "ext4":
+		// -F forces formatting without prompting
+		mkfs = []string{"mkfs.ext4", "-F"}
+		var extended []string
+		if opts.LazyInit {
+			extended = append(extended, "lazy_itable_init=1", "lazy_journal_init=1")
+		}
+		if opts.NoDiscard {
+			extended = append(extended, "nodiscard")
+		}
+		if len(extended) > 0 {
+			mkfs = append(mkfs, "-E", strings.Join(extended, ","))
+		}
+	case "xfs":
+		// -f forces formatting
+		mkfs = []string{"mkfs.xfs", "-f"}
+		if opts.NoDiscard {
+			mkfs = append(mkfs, "-K")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported filesystem type: %s", fstype)
+	}
+	mkfs = append(mkfs, device)
+
+	var args []string
+	if opts.IoniceClass != "" {
+		args = append(args, "ionice", "-c", opts.IoniceClass)
+	}
+	if opts.Nice != 0 {
+		args = append(args, "nice", "-n", strconv.Itoa(opts.Nice))
+	}
+
+	return append(args, mkfs...), nil
+}
+
+// formatDevice formats a device with the specified filesystem
+func (m *LinuxMounter) formatDevice(device, fstype string) error {
+	args, err := buildFormatCommand(device, fstype, m.formatOptions)
+	if err != nil {
+		return err
+	}
+
+	if m.formatSem != nil {
+		// A stuck mkfs must not hold up staging forever, kubelet retries the call
+		timer := time.NewTimer(m.formatSlotWait)
+		select {
+		case m.formatSem <- struct{}{}:
+			timer.Stop()
+		case <-timer.C:
+			return fmt.Errorf("%w: %d mkfs runs did not finish within %v", ErrFormatBusy, cap(m.formatSem), m.formatSlotWait)
+		}
+		defer func() { <-m.formatSem }()
+	}
+
+	sizeGB := m.deviceSizeGB(device)
+	klog.V(4).Infof("Formatting %s (%dGB) with: %s", device, sizeGB, strings.Join(args, " "))
+
+	start := time.Now()
+	output, err := m.formatter.Exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("format failed: %w, output: %s", err, string(output))
+	}
+
+	duration := time.Since(start)
+	metrics.RecordVolumeFormat(fstype, sizeGB, duration)
+	klog.Infof("Formatted %s (%dGB) with %s in %v", device, sizeGB, fstype, duration)
+
+	return nil
+}
+
+// deviceSizeGB returns the size of a block device in GiB, or 0 if it cannot be determined
+func (m *LinuxMounter) deviceSizeGB(device string) int64 {
+	bytes, err := m.deviceSizeBytes(device)
+	if err != nil {
+		klog.V(4).Infof("Failed to get size of %s: %v", device, err)
+		return 0
+	}
+
+	return (bytes + (1 << 30) - 1) >> 30
+}
+
+// deviceSizeBytes returns the size of a block device in bytes
+func (m *LinuxMounter) deviceSizeBytes(device string) (int64, error) {
+	output, err := m.formatter.Exec.Command("blockdev", "--getsize64", device).Output()
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(strings.TrimSpace(string(output)), 10, 64)
+}
@@ -0,0 +1,85 @@
+package mount
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	mountutils "k8s.io/mount-utils"
+	testingexec "k8s.io/utils/exec/testing"
+)
+
+func TestBuildFormatCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		fstype   string
+		opts     FormatOptions
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name:     "ext4 defaults",
+			fstype:   "ext4",
+			expected: []string{"mkfs.ext4", "-F", "/dev/sdb"},
+		},
+		{
+			name:     "xfs defaults",
+			fstype:   "xfs",
+			expected: []string{"mkfs.xfs", "-f", "/dev/sdb"},
+		},
+		{
+			name:     "ext4 lazy init without discard",
+			fstype:   "ext4",
+			opts:     FormatOptions{LazyInit: true, NoDiscard: true},
+			expected: []string{"mkfs.ext4", "-F", "-E", "lazy_itable_init=1,lazy_journal_init=1,nodiscard", "/dev/sdb"},
+		},
+		{
+			name:     "xfs without discard ignores lazy init",
+			fstype:   "xfs",
+			opts:     FormatOptions{LazyInit: true, NoDiscard: true},
+			expected: []string{"mkfs.xfs", "-f", "-K", "/dev/sdb"},
+		},
+		{
+			name:     "ionice and nice wrappers",
+			fstype:   "ext4",
+			opts:     FormatOptions{IoniceClass: "3", Nice: 10},
+			expected: []string{"ionice", "-c", "3", "nice", "-n", "10", "mkfs.ext4", "-F", "/dev/sdb"},
+		},
+		{
+			name:    "unsupported filesystem",
+			fstype:  "btrfs",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := buildFormatCommand("/dev/sdb", tt.fstype, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildFormatCommand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(args, tt.expected) {
+				t.Errorf("buildFormatCommand() = %v, want %v", args, tt.expected)
+			}
+		})
+	}
+}
+
+// TestFormatDeviceWaitsForSlotWithTimeout tests that formatting gives up with a retryable
+// error when all mkfs slots stay busy
+func TestFormatDeviceWaitsForSlotWithTimeout(t *testing.T) {
+	fake := &testingexec.FakeExec{}
+	m := newLinuxMounter(mountutils.NewFakeMounter(nil), fake)
+	m.formatSem = make(chan struct{}, 1)
+	m.formatSlotWait = 10 * time.Millisecond
+	m.formatSem <- struct{}{}
+
+	err := m.formatDevice("/dev/vdb", "ext4")
+	if !errors.Is(err, ErrFormatBusy) {
+		t.Fatalf("expected ErrFormatBusy, got %v", err)
+	}
+	if fake.CommandCalls != 0 {
+		t.Errorf("expected no command to run, got %d", fake.CommandCalls)
+	}
+}
@@ -1,14 +1,46 @@
 package mount
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"k8s.io/klog/v2"
+	mountutils "k8s.io/mount-utils"
+	utilexec "k8s.io/utils/exec"
+)
+
+// ErrNotFormatted is returned by MountFormatted and OpenEncryptedDevice when a device expected
+// to contain data does not hold the expected filesystem or LUKS container
+var ErrNotFormatted = errors.New("device not formatted as expected")
+
+// ErrFormatBusy is returned by FormatAndMount when the device needs formatting but no mkfs
+// slot freed up in time, so the caller can retry later
+var ErrFormatBusy = errors.New("too many concurrent formats")
+
+// ErrFilesystemMismatch is returned by FormatAndMount when the device already holds a
+// different filesystem, or a partition table, which it will not format over
+var ErrFilesystemMismatch = errors.New("device holds a different filesystem")
+
+const (
+	// filesystemProbeAttempts is how many times MountFormatted probes a device for a filesystem
+	filesystemProbeAttempts = 3
+
+	// filesystemProbeInterval is the delay between filesystem probes
+	filesystemProbeInterval = time.Second
+
+	// deviceRescanInterval is how often device discovery rescans the buses and triggers
+	// udev while waiting for a device
+	deviceRescanInterval = 5 * time.Second
+
+	// formatSlotWait is how long formatting waits for a free mkfs slot, well within the
+	// time kubelet gives NodeStageVolume
+	formatSlotWait = time.Minute
 )
 
 // Mounter provides mount operations
@@ -22,17 +54,93 @@ type Mounter interface {
 	// IsLikelyNotMountPoint checks if a path is not a mount point
 	IsLikelyNotMountPoint(path string) (bool, error)
 
-	// FormatAndMount formats the device and mounts it
+	// FormatAndMount formats the device if it holds no filesystem and mounts it. A device
+	// holding a different filesystem is never formatted.
 	FormatAndMount(source, target, fstype string, options []string) error
 
-	// GetDevicePath discovers the device path for a volume
-	GetDevicePath(volumeID string) (string, error)
+	// ReformatAndMount formats the device unless it already holds fstype, destroying any
+	// other filesystem on it, and mounts it
+	ReformatAndMount(source, target, fstype string, options []string) error
+
+	// MountFormatted mounts a device that must already hold a filesystem of fstype,
+	// never formatting it
+	MountFormatted(source, target, fstype string, options []string) error
+
+	// GetDevicePath discovers the device path for a volume, using the hints from its
+	// publish context when there are any
+	GetDevicePath(volumeID string, hints DeviceHints) (string, error)
+
+	// CheckDevice verifies that a previously discovered device is still the one hints
+	// identify
+	CheckDevice(device string, hints DeviceHints) error
 
 	// ResizeFilesystem resizes the filesystem on the device
 	ResizeFilesystem(devicePath, fstype string) error
 
 	// GetVolumeStats returns volume statistics
 	GetVolumeStats(path string) (*VolumeStats, error)
+
+	// SyncFilesystem flushes dirty data of the filesystem mounted at path
+	SyncFilesystem(path string) error
+
+	// FreezeFilesystem freezes and thaws the filesystem mounted at path
+	FreezeFilesystem(path string) error
+
+	// OpenEncryptedDevice opens, LUKS-formatting a blank device first unless it is expected
+	// to be formatted, the LUKS container on source as name and returns the path of the
+	// decrypted device
+	OpenEncryptedDevice(source, name, passphrase string, force, expectFormatted bool) (string, error)
+
+	// CloseEncryptedDevice closes the LUKS container opened as name, if it is open
+	CloseEncryptedDevice(name string) error
+
+	// ResizeEncryptedDevice grows the opened LUKS container name to its device and returns
+	// the path of the decrypted device, "" when it is not open
+	ResizeEncryptedDevice(name, passphrase string) (string, error)
+}
+
+// DeviceHints are the attachment details the controller reports for a volume, which
+// identify its device without guessing from attachment order
+type DeviceHints struct {
+	// Serial is the disk serial number the VM sees
+	Serial string
+
+	// ProviderVolumeID is the volume's identifier at the underlying cloud provider
+	ProviderVolumeID string
+
+	// LUN is the SCSI logical unit number of the disk on Azure VMs
+	LUN *int
+
+	// SizeBytes is the expected size of the disk, 0 when unknown. It does not identify a
+	// device, but rules out candidates of the newest device heuristics.
+	SizeBytes int64
+}
+
+// resolvable reports whether the hints can identify a device. Azure resource IDs, which
+// contain slashes, do not appear in device names and need the LUN instead.
+func (h DeviceHints) resolvable() bool {
+	return h.Serial != "" || h.LUN != nil || (h.ProviderVolumeID != "" && !strings.Contains(h.ProviderVolumeID, "/"))
+}
+
+// String describes the hints that are set, e.g. "serial abc, LUN 2"
+func (h DeviceHints) String() string {
+	var parts []string
+	if h.Serial != "" {
+		parts = append(parts, "serial "+h.Serial)
+	}
+	if h.LUN != nil {
+		parts = append(parts, fmt.Sprintf("LUN %d", *h.LUN))
+	}
+	if h.ProviderVolumeID != "" {
+		parts = append(parts, "provider volume ID "+h.ProviderVolumeID)
+	}
+	if h.SizeBytes > 0 {
+		parts = append(parts, fmt.Sprintf("size %d bytes", h.SizeBytes))
+	}
+	if len(parts) == 0 {
+		return "no hints"
+	}
+	return strings.Join(parts, ", ")
 }
 
 // VolumeStats represents volume usage statistics
@@ -46,11 +154,75 @@ type VolumeStats struct {
 }
 
 // LinuxMounter implements Mounter for Linux systems
-type LinuxMounter struct{}
+type LinuxMounter struct {
+	// formatter mounts, unmounts and probes devices through k8s.io/mount-utils, and runs
+	// mkfs and resize tools through its Exec
+	formatter *mountutils.SafeFormatAndMount
+
+	formatOptions FormatOptions
+
+	// dirOptions controls how staging and target directories are created
+	dirOptions DirOptions
+
+	// disableUdev skips udevadm trigger/settle during device discovery
+	disableUdev bool
+
+	// formatSem limits concurrent mkfs runs when MaxConcurrent is set
+	formatSem chan struct{}
+
+	// formatSlotWait is how long formatting waits for a free formatSem slot
+	formatSlotWait time.Duration
+
+	// root prefixes the /dev, /sys and /proc paths used by device discovery, "" is the
+	// real root; tests point it at a fake tree
+	root string
+
+	// blockDevice overrides the block device check of a root-prefixed path, used by tests
+	// whose fake /dev holds regular files
+	blockDevice func(path string) bool
+}
 
 // NewMounter creates a new mounter
 func NewMounter() Mounter {
-	return &LinuxMounter{}
+	return newLinuxMounter(mountutils.New(""), utilexec.New())
+}
+
+// MounterOptions configures a mounter created by NewMounterWithOptions
+type MounterOptions struct {
+	// Format controls how mkfs is run
+	Format FormatOptions
+
+	// Dirs controls how staging and target directories are created
+	Dirs DirOptions
+
+	// DisableUdev makes device discovery rely on sysfs scanning alone, without udevadm
+	DisableUdev bool
+}
+
+// NewMounterWithOptions creates a new mounter with the given options
+func NewMounterWithOptions(opts MounterOptions) Mounter {
+	m := newLinuxMounter(mountutils.New(""), utilexec.New())
+	m.formatOptions = opts.Format
+	if opts.Format.MaxConcurrent > 0 {
+		m.formatSem = make(chan struct{}, opts.Format.MaxConcurrent)
+		m.formatSlotWait = formatSlotWait
+	}
+	if opts.Dirs.Mode == 0 {
+		opts.Dirs.Mode = DefaultDirMode
+	}
+	m.dirOptions = opts.Dirs
+	m.disableUdev = opts.DisableUdev
+	klog.Infof("Mount directories: mode %#o, SELinux context %q", opts.Dirs.Mode, opts.Dirs.SELinuxContext)
+	return m
+}
+
+// newLinuxMounter creates a mounter on top of a mount-utils mounter and exec, which
+// tests replace with fakes
+func newLinuxMounter(mounter mountutils.Interface, exec utilexec.Interface) *LinuxMounter {
+	return &LinuxMounter{
+		formatter:  mountutils.NewSafeFormatAndMount(mounter, exec),
+		dirOptions: DirOptions{Mode: DefaultDirMode},
+	}
 }
 
 // Mount mounts source to target
@@ -58,24 +230,14 @@ func (m *LinuxMounter) Mount(source, target, fstype string, options []string) er
 	klog.V(4).Infof("Mounting %s to %s with fstype %s and options %v", source, target, fstype, options)
 
 	// Create target directory if it doesn't exist
-	if err := os.MkdirAll(target, 0750); err != nil {
+	if err := m.makeMountDir(target); err != nil {
 		return fmt.Errorf("failed to create target directory: %w", err)
 	}
 
-	// Build mount command
-	args := []string{}
-	if fstype != "" {
-		args = append(args, "-t", fstype)
-	}
-	if len(options) > 0 {
-		args = append(args, "-o", strings.Join(options, ","))
-	}
-	args = append(args, source, target)
-
-	cmd := exec.Command("mount", args...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("mount failed: %w, output: %s", err, string(output))
+	// mount-utils splits bind mounts with other options into a bind and a remount, so
+	// read-only bind mounts are really read-only
+	if err := m.formatter.Mount(source, target, fstype, options); err != nil {
+		return fmt.Errorf("mount failed: %w", err)
 	}
 
 	klog.V(4).Infof("Successfully mounted %s to %s", source, target)
@@ -86,41 +248,48 @@ func (m *LinuxMounter) Mount(source, target, fstype string, options []string) er
 func (m *LinuxMounter) Unmount(target string) error {
 	klog.V(4).Infof("Unmounting %s", target)
 
-	cmd := exec.Command("umount", target)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("unmount failed: %w, output: %s", err, string(output))
+	if err := m.formatter.Unmount(target); err != nil {
+		return fmt.Errorf("unmount failed: %w", err)
 	}
 
 	klog.V(4).Infof("Successfully unmounted %s", target)
 	return nil
 }
 
-// IsLikelyNotMountPoint checks if a path is not a mount point
+// IsLikelyNotMountPoint checks if a path is not a mount point. A corrupted mount, such as
+// one whose device was detached underneath it, is unmounted and reported as not mounted,
+// so staging and publishing mount it again and unstaging and unpublishing clean it up.
 func (m *LinuxMounter) IsLikelyNotMountPoint(path string) (bool, error) {
-	// Check if path exists
-	_, err := os.Stat(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return true, nil
-		}
-		return false, err
+	notMnt, err := m.formatter.IsLikelyNotMountPoint(path)
+	if err == nil {
+		return notMnt, nil
 	}
-
-	// Use findmnt to check if it's a mount point
-	cmd := exec.Command("findmnt", "-o", "TARGET", "-n", "-M", path)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		// If findmnt returns error, it's likely not a mount point
+	if os.IsNotExist(err) {
 		return true, nil
 	}
+	if !mountutils.IsCorruptedMnt(err) {
+		return false, err
+	}
 
-	// If output is not empty, it's a mount point
-	return len(strings.TrimSpace(string(output))) == 0, nil
+	klog.Warningf("Mount at %s is corrupted (%v), unmounting it", path, err)
+	if unmountErr := m.formatter.Unmount(path); unmountErr != nil {
+		return false, fmt.Errorf("failed to unmount corrupted mount at %s: %w", path, unmountErr)
+	}
+	return true, nil
 }
 
-// FormatAndMount formats the device and mounts it
+// FormatAndMount formats the device if it holds no filesystem and mounts it
 func (m *LinuxMounter) FormatAndMount(source, target, fstype string, options []string) error {
+	return m.formatAndMount(source, target, fstype, options, false)
+}
+
+// ReformatAndMount formats the device unless it already holds fstype and mounts it
+func (m *LinuxMounter) ReformatAndMount(source, target, fstype string, options []string) error {
+	return m.formatAndMount(source, target, fstype, options, true)
+}
+
+// formatAndMount formats a blank device, or with force any device not holding fstype, and mounts it
+func (m *LinuxMounter) formatAndMount(source, target, fstype string, options []string, force bool) error {
 	klog.V(4).Infof("Formatting and mounting %s to %s with fstype %s", source, target, fstype)
 
 	// Check if device is already formatted
@@ -129,199 +298,132 @@ func (m *LinuxMounter) FormatAndMount(source, target, fstype string, options []s
 		return fmt.Errorf("failed to check existing filesystem: %w", err)
 	}
 
-	// Format if not already formatted or if filesystem type doesn't match
-	if existingFS == "" || existingFS != fstype {
+	switch {
+	case existingFS == "":
 		klog.V(4).Infof("Formatting device %s with %s", source, fstype)
 		if err := m.formatDevice(source, fstype); err != nil {
 			return fmt.Errorf("failed to format device: %w", err)
 		}
-	} else {
+	case existingFS == fstype:
 		klog.V(4).Infof("Device %s already formatted with %s", source, fstype)
+	case force:
+		klog.Warningf("Reformatting device %s, which holds %s, with %s as forced", source, existingFS, fstype)
+		if err := m.formatDevice(source, fstype); err != nil {
+			return fmt.Errorf("failed to format device: %w", err)
+		}
+	default:
+		return fmt.Errorf("%w: %s holds %s, not %s, refusing to reformat it", ErrFilesystemMismatch, source, existingFS, fstype)
 	}
 
 	// Mount the device
 	return m.Mount(source, target, fstype, options)
 }
 
-// getFilesystemType returns the filesystem type of a device
-func (m *LinuxMounter) getFilesystemType(device string) (string, error) {
-	cmd := exec.Command("blkid", "-o", "value", "-s", "TYPE", device)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		// Device might not be formatted yet
-		return "", nil
+// MountFormatted mounts a device that is expected to contain data. blkid is retried a few
+// times because probing can transiently fail right after attach, and the device is never
+// formatted: a missing or different filesystem is an error.
+func (m *LinuxMounter) MountFormatted(source, target, fstype string, options []string) error {
+	klog.V(4).Infof("Mounting existing %s filesystem on %s to %s", fstype, source, target)
+
+	var existingFS string
+	for attempt := 0; attempt < filesystemProbeAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(filesystemProbeInterval)
+		}
+		fs, err := m.getFilesystemType(source)
+		if err != nil {
+			return fmt.Errorf("failed to check existing filesystem: %w", err)
+		}
+		if existingFS = fs; existingFS != "" {
+			break
+		}
 	}
 
-	return strings.TrimSpace(string(output)), nil
+	if err := checkExpectedFilesystem(source, existingFS, fstype); err != nil {
+		return err
+	}
+	return m.Mount(source, target, fstype, options)
 }
 
-// formatDevice formats a device with the specified filesystem
-func (m *LinuxMounter) formatDevice(device, fstype string) error {
-	var cmd *exec.Cmd
-
-	switch fstype {
-	case "ext4":
-		// -F forces formatting without prompting
-		cmd = exec.Command("mkfs.ext4", "-F", device)
-	case "xfs":
-		// -f forces formatting
-		cmd = exec.Command("mkfs.xfs", "-f", device)
-	default:
-		return fmt.Errorf("unsupported filesystem type: %s", fstype)
+// checkExpectedFilesystem verifies that a device expected to contain data holds fstype
+func checkExpectedFilesystem(source, existingFS, fstype string) error {
+	if existingFS == "" {
+		return fmt.Errorf("%w: no filesystem found on %s, refusing to format a volume expected to contain data", ErrNotFormatted, source)
 	}
-
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("format failed: %w, output: %s", err, string(output))
+	if existingFS != fstype {
+		return fmt.Errorf("%w: %s has a %s filesystem, not %s, refusing to reformat a volume expected to contain data", ErrNotFormatted, source, existingFS, fstype)
 	}
-
 	return nil
 }
 
-// GetDevicePath discovers the device path for a volume
-func (m *LinuxMounter) GetDevicePath(volumeID string) (string, error) {
+// getFilesystemType returns the filesystem type of a device, "" when it is unformatted.
+// A partitioned device reports a non-empty placeholder so it is never formatted over.
+func (m *LinuxMounter) getFilesystemType(device string) (string, error) {
+	return m.formatter.GetDiskFormat(device)
+}
+
+// GetDevicePath discovers the device path for a volume. With resolvable hints the device
+// is looked up by them alone; otherwise the device strategy of the VM looks for links
+// naming the volume and, since Emma volume IDs rarely appear in device names, for the
+// newest unused device.
+func (m *LinuxMounter) GetDevicePath(volumeID string, hints DeviceHints) (string, error) {
 	klog.V(4).Infof("Discovering device path for volume %s", volumeID)
 
-	// Emma.ms provisions VMs on different cloud providers, each with different device naming:
-	//
-	// AWS: NVMe devices
-	//   - /dev/disk/by-id/nvme-Amazon_Elastic_Block_Store_vol*
-	//   - Actual device: /dev/nvme1n1, /dev/nvme2n1, etc.
-	//
-	// GCP: SCSI devices with Google naming
-	//   - /dev/disk/by-id/google-<disk-name>
-	//   - /dev/disk/by-id/scsi-0Google_PersistentDisk_*
-	//   - Actual device: /dev/sdb, /dev/sdc, etc.
-	//
-	// Azure: SCSI devices with Microsoft naming
-	//   - /dev/disk/by-id/scsi-*
-	//   - /dev/disk/by-lun/* (LUN-based)
-	//   - Actual device: /dev/sdc, /dev/sdd, etc.
-	//
-	// Legacy/KVM: Virtio devices
-	//   - /dev/disk/by-id/virtio-<volumeID>
-	//   - Actual device: /dev/vdb, /dev/vdc, etc.
-
-	// Primary device path for virtio (legacy/KVM)
-	primaryPath := "/dev/disk/by-id/virtio-" + volumeID
-
-	// Alternative paths to check for different cloud providers
-	alternativePaths := []string{
-		// GCP patterns
-		"/dev/disk/by-id/google-" + volumeID,
-		"/dev/disk/by-id/scsi-0Google_PersistentDisk_" + volumeID,
-
-		// Azure patterns (SCSI)
-		"/dev/disk/by-id/scsi-" + volumeID,
-
-		// Legacy QEMU/KVM patterns
-		"/dev/disk/by-id/scsi-0QEMU_QEMU_HARDDISK_" + volumeID,
-		"/dev/disk/by-id/ata-QEMU_HARDDISK_" + volumeID,
+	if hints.resolvable() {
+		return m.waitForHintedDevice(volumeID, hints)
 	}
 
+	strategy := currentDeviceStrategy()
+	links := strategy.links(volumeID)
+
 	// Wait for device to appear (up to 90 seconds with exponential backoff)
 	// Reduced from 180s to stay well within Kubernetes' 120s mount timeout
-	// We'll try multiple strategies in parallel rather than waiting longer
 	maxWait := 90 * time.Second
 	deadline := time.Now().Add(maxWait)
 	checkInterval := 200 * time.Millisecond
-	lastUdevTrigger := time.Time{}
-
-	klog.V(4).Infof("Waiting for device to appear for volume %s (timeout: %v)", volumeID, maxWait)
 
-	// IMPORTANT: On Emma.ms with AWS, the volume ID (e.g., 93801) does NOT appear in the device name
-	// AWS uses its own volume IDs (e.g., vol0d3199dae8c585cb0) which are different from Emma's IDs
-	// Therefore, we need to use the "newest device" strategy immediately
+	klog.V(4).Infof("Waiting for device to appear for volume %s with the %s strategy (timeout: %v)", volumeID, strategy.name(), maxWait)
 
 	// Give the device a moment to appear after attachment (2 seconds)
 	klog.V(4).Infof("Waiting 2 seconds for device to appear after attachment")
 	time.Sleep(2 * time.Second)
 
-	// Trigger udev immediately to ensure device symlinks are created
-	klog.V(4).Infof("Triggering initial udev rescan")
-	_ = exec.Command("udevadm", "trigger", "--subsystem-match=block").Run()
-	_ = exec.Command("udevadm", "settle", "--timeout=5").Run()
-	lastUdevTrigger = time.Now()
+	// Rescan the buses and trigger udev immediately so the disk and its symlinks show up
+	klog.V(4).Infof("Triggering initial bus and udev rescan")
+	m.rescanDevices()
+	lastUdevTrigger := time.Now()
 
-	// Try to find device by newest attachment first (works for all cloud providers)
-	// This is the most reliable method when volume IDs don't match device names
-	klog.V(4).Infof("Attempting to find device by newest attachment (Emma volume ID may not match cloud provider device name)")
-
-	// Try NVMe first (AWS)
-	if device, err := m.findNVMeDevice(volumeID); err == nil {
-		klog.Infof("Found NVMe device %s for volume %s via newest device scan", device, volumeID)
-		return device, nil
-	}
-
-	// Try cloud provider devices (GCP, Azure)
-	if device, err := m.findCloudProviderDevice(volumeID); err == nil {
-		klog.Infof("Found cloud provider device %s for volume %s via newest device scan", device, volumeID)
+	if device, err := strategy.scan(m, volumeID, hints.SizeBytes); err == nil {
+		klog.Infof("Found device %s for volume %s via %s device scan", device, volumeID, strategy.name())
 		return device, nil
 	}
 
-	// If newest device strategy didn't work, fall back to polling for expected paths
-	klog.V(4).Infof("Newest device scan didn't find device, falling back to polling for expected paths")
+	// If the scan didn't work, fall back to polling for the links naming the volume
+	klog.V(4).Infof("Device scan didn't find device, falling back to polling for %v", links)
 
 	iteration := 0
 	for time.Now().Before(deadline) {
 		iteration++
 
-		// Trigger udev early and periodically (every 10 seconds)
-		if time.Since(lastUdevTrigger) > 10*time.Second {
-			klog.V(5).Infof("Triggering udev rescan (iteration %d)", iteration)
-			_ = exec.Command("udevadm", "trigger", "--subsystem-match=block").Run()
-			_ = exec.Command("udevadm", "settle", "--timeout=5").Run()
+		// Rescan the buses and trigger udev periodically
+		if time.Since(lastUdevTrigger) > deviceRescanInterval {
+			klog.V(5).Infof("Triggering bus and udev rescan (iteration %d)", iteration)
+			m.rescanDevices()
 			lastUdevTrigger = time.Now()
 		}
 
-		// First, try the primary path
-		if _, err := os.Stat(primaryPath); err == nil {
-			if m.isBlockDevice(primaryPath) {
-				// Resolve symlink to get actual device
-				realPath, err := filepath.EvalSymlinks(primaryPath)
-				if err == nil {
-					klog.Infof("Found device %s -> %s for volume %s after %d iterations", primaryPath, realPath, volumeID, iteration)
-					return realPath, nil
-				}
-				klog.Infof("Found device %s for volume %s after %d iterations", primaryPath, volumeID, iteration)
-				return primaryPath, nil
-			}
-		}
-
-		// Try alternative paths
-		for _, altPath := range alternativePaths {
-			if _, err := os.Stat(altPath); err == nil {
-				if m.isBlockDevice(altPath) {
-					realPath, err := filepath.EvalSymlinks(altPath)
-					if err == nil {
-						klog.Infof("Found device %s -> %s for volume %s after %d iterations", altPath, realPath, volumeID, iteration)
-						return realPath, nil
-					}
-					klog.Infof("Found device %s for volume %s after %d iterations", altPath, volumeID, iteration)
-					return altPath, nil
-				}
+		for _, link := range links {
+			if device, err := m.findDeviceByLink(link); err == nil {
+				klog.Infof("Found device %s -> %s for volume %s after %d iterations", link, device, volumeID, iteration)
+				return device, nil
 			}
 		}
 
-		// Every 5 seconds, try the newest device strategy again
+		// Every 5 seconds, try the device scan again
 		if iteration%25 == 0 { // Every ~5 seconds
-			klog.V(4).Infof("Retrying newest device scan (iteration %d)", iteration)
-
-			// Try NVMe first (common on AWS)
-			if device, err := m.findNVMeDevice(volumeID); err == nil {
-				klog.Infof("Found NVMe device %s for volume %s via periodic scan", device, volumeID)
-				return device, nil
-			}
-
-			// Try cloud provider devices (GCP, Azure)
-			if device, err := m.findCloudProviderDevice(volumeID); err == nil {
-				klog.Infof("Found cloud provider device %s for volume %s via periodic scan", device, volumeID)
-				return device, nil
-			}
-
-			// Try serial scan (virtio)
-			if device, err := m.findDeviceBySerial(volumeID); err == nil {
-				klog.Infof("Found device %s by serial scan for volume %s", device, volumeID)
+			klog.V(4).Infof("Retrying %s device scan (iteration %d)", strategy.name(), iteration)
+			if device, err := strategy.scan(m, volumeID, hints.SizeBytes); err == nil {
+				klog.Infof("Found device %s for volume %s via periodic %s device scan", device, volumeID, strategy.name())
 				return device, nil
 			}
 		}
@@ -334,12 +436,12 @@ func (m *LinuxMounter) GetDevicePath(volumeID string) (string, error) {
 		}
 	}
 
-	// Last resort: scan all block devices and try to match by serial or cloud provider patterns
+	// Last resort: scan once more
 	klog.Warningf("Device not found at expected paths after %v, performing final scan for volume %s", maxWait, volumeID)
 
 	// List what devices we can see for debugging
 	klog.V(4).Info("Available devices in /dev/disk/by-id/:")
-	if entries, err := os.ReadDir("/dev/disk/by-id/"); err == nil {
+	if entries, err := os.ReadDir(m.hostPath("/dev/disk/by-id/")); err == nil {
 		for i, entry := range entries {
 			if i < 20 { // Limit output
 				klog.V(4).Infof("  - %s", entry.Name())
@@ -347,178 +449,230 @@ func (m *LinuxMounter) GetDevicePath(volumeID string) (string, error) {
 		}
 	}
 
-	// Try to find by serial (virtio devices)
-	if device, err := m.findDeviceBySerial(volumeID); err == nil {
-		klog.Infof("Found device %s by serial scan for volume %s", device, volumeID)
+	if device, err := strategy.scan(m, volumeID, hints.SizeBytes); err == nil {
+		klog.Infof("Found device %s for volume %s via final %s device scan", device, volumeID, strategy.name())
 		return device, nil
 	}
 
-	// Try to find NVMe device (AWS)
-	if device, err := m.findNVMeDevice(volumeID); err == nil {
-		klog.Infof("Found NVMe device %s for volume %s", device, volumeID)
-		return device, nil
+	if hints.SizeBytes > 0 {
+		return "", fmt.Errorf("timeout waiting for device for volume %s after %v - no unused device of %d bytes appeared on node (device strategy %s)", volumeID, maxWait, hints.SizeBytes, strategy.name())
 	}
+	return "", fmt.Errorf("timeout waiting for device for volume %s after %v - device never appeared on node (device strategy %s)", volumeID, maxWait, strategy.name())
+}
 
-	// Try to find cloud provider device (GCP, Azure, etc.)
-	if device, err := m.findCloudProviderDevice(volumeID); err == nil {
-		klog.Infof("Found cloud provider device %s for volume %s", device, volumeID)
-		return device, nil
-	}
+// waitForHintedDevice polls for the device identified by hints. It never falls back to
+// the newest device heuristics, which can pick another volume's disk.
+func (m *LinuxMounter) waitForHintedDevice(volumeID string, hints DeviceHints) (string, error) {
+	maxWait := 90 * time.Second
+	deadline := time.Now().Add(maxWait)
+	checkInterval := 200 * time.Millisecond
+	lastUdevTrigger := time.Time{}
 
-	return "", fmt.Errorf("timeout waiting for device for volume %s after %v - device never appeared on node", volumeID, maxWait)
-}
+	klog.V(4).Infof("Waiting for device of volume %s with %s", volumeID, hints)
 
-// findDeviceBySerial scans all block devices to find one matching the volume ID
-func (m *LinuxMounter) findDeviceBySerial(volumeID string) (string, error) {
-	// Check /sys/block for all block devices
-	blockDevices, err := filepath.Glob("/sys/block/vd*")
-	if err == nil {
-		for _, sysPath := range blockDevices {
-			serialPath := filepath.Join(sysPath, "serial")
-			if data, err := os.ReadFile(serialPath); err == nil {
-				serial := strings.TrimSpace(string(data))
-				if serial == volumeID {
-					deviceName := filepath.Base(sysPath)
-					devicePath := "/dev/" + deviceName
-					if m.isBlockDevice(devicePath) {
-						return devicePath, nil
-					}
-				}
-			}
+	for time.Now().Before(deadline) {
+		if device, err := m.findDeviceByHints(hints); err == nil {
+			klog.Infof("Found device %s for volume %s from its publish context", device, volumeID)
+			return device, nil
 		}
-	}
 
-	// Also check sd* devices
-	blockDevices, err = filepath.Glob("/sys/block/sd*")
-	if err == nil {
-		for _, sysPath := range blockDevices {
-			serialPath := filepath.Join(sysPath, "serial")
-			if data, err := os.ReadFile(serialPath); err == nil {
-				serial := strings.TrimSpace(string(data))
-				if serial == volumeID {
-					deviceName := filepath.Base(sysPath)
-					devicePath := "/dev/" + deviceName
-					if m.isBlockDevice(devicePath) {
-						return devicePath, nil
-					}
-				}
-			}
+		// Rescan right after the first miss, then periodically
+		if time.Since(lastUdevTrigger) > deviceRescanInterval {
+			m.rescanDevices()
+			lastUdevTrigger = time.Now()
+		}
+
+		time.Sleep(checkInterval)
+		if checkInterval < 1*time.Second {
+			checkInterval = checkInterval * 3 / 2
 		}
 	}
 
-	return "", fmt.Errorf("device not found for volume %s", volumeID)
+	return "", fmt.Errorf("timeout waiting for device of volume %s with %s after %v", volumeID, hints, maxWait)
 }
 
-// findNVMeDevice scans /dev/disk/by-id/ for NVMe devices that might match the volume
-func (m *LinuxMounter) findNVMeDevice(volumeID string) (string, error) {
-	// On Emma.ms with AWS-style NVMe, devices appear as:
-	// /dev/disk/by-id/nvme-Amazon_Elastic_Block_Store_vol<hex_id>
-	// We need to scan all NVMe devices and check which one was attached most recently
-	// or matches our volume ID in some way
+// findDeviceByHints looks up the device identified by hints
+func (m *LinuxMounter) findDeviceByHints(hints DeviceHints) (string, error) {
+	if hints.Serial != "" {
+		if device, err := m.findDeviceBySerial(hints.Serial); err == nil {
+			return device, nil
+		}
+		if device, err := m.findDeviceByLink("virtio-" + hints.Serial); err == nil {
+			return device, nil
+		}
+	}
 
-	pattern := "/dev/disk/by-id/nvme-Amazon_Elastic_Block_Store_vol*"
-	matches, err := filepath.Glob(pattern)
-	if err != nil {
-		return "", fmt.Errorf("failed to glob NVMe devices: %w", err)
+	if hints.LUN != nil {
+		if device, err := m.findDeviceByAzureLUN(*hints.LUN); err == nil {
+			return device, nil
+		}
 	}
 
-	if len(matches) == 0 {
-		return "", fmt.Errorf("no NVMe devices found")
+	if hints.ProviderVolumeID != "" && !strings.Contains(hints.ProviderVolumeID, "/") {
+		links := []string{
+			// AWS: the EBS volume ID without its dash, e.g. vol0abc for vol-0abc
+			"nvme-Amazon_Elastic_Block_Store_" + strings.ReplaceAll(hints.ProviderVolumeID, "-", ""),
+			// GCP: the disk name
+			"google-" + hints.ProviderVolumeID,
+			"scsi-0Google_PersistentDisk_" + hints.ProviderVolumeID,
+		}
+		for _, link := range links {
+			if device, err := m.findDeviceByLink(link); err == nil {
+				return device, nil
+			}
+		}
+		// AWS: the identify controller data, for hosts without the EBS udev links
+		if isEBSVolumeID(hints.ProviderVolumeID) {
+			if device, err := m.findNVMeDeviceByEBSVolumeID(hints.ProviderVolumeID); err == nil {
+				return device, nil
+			}
+		}
 	}
 
-	// Find the most recently created device (likely our newly attached volume)
-	// BUT exclude devices that are already in use (mounted or have partitions)
-	var newestDevice string
-	var newestTime time.Time
+	return "", fmt.Errorf("no device matches %s", hints)
+}
 
-	for _, match := range matches {
-		// Skip partition links (they contain -part)
-		if strings.Contains(match, "-part") || strings.Contains(match, "_1") {
-			continue
-		}
+// azureLUNLinks are the links udev rules create for an Azure data disk LUN: the Azure
+// Linux agent's 66-azure-storage.rules, azure-vm-utils and generic by-lun rules
+var azureLUNLinks = []string{
+	"/dev/disk/azure/scsi1/lun%d",
+	"/dev/disk/azure/data/by-lun/%d",
+	"/dev/disk/by-lun/%d",
+}
 
-		info, err := os.Lstat(match)
-		if err != nil {
-			continue
-		}
+// azureDataDiskController is the VMBus device ID of the SCSI controller holding Azure data
+// disks. The OS and resource disks sit on other controllers.
+const azureDataDiskController = "f8b3781b-1e82-4818-a1c3-63d806ec15bb"
 
-		// Resolve symlink to get actual device
-		realPath, err := filepath.EvalSymlinks(match)
+// findDeviceByAzureLUN resolves the link udev rules create for an Azure data disk LUN, or
+// without the rules the data disk with that LUN in sysfs. Only data disks have these, so
+// the OS and resource disks can never match.
+func (m *LinuxMounter) findDeviceByAzureLUN(lun int) (string, error) {
+	for _, pattern := range azureLUNLinks {
+		device, err := m.resolveDevice(m.hostPath(fmt.Sprintf(pattern, lun)))
 		if err != nil {
 			continue
 		}
+		if !m.isBlockDevice(device) {
+			return "", fmt.Errorf("%s is not a block device", device)
+		}
+		return device, nil
+	}
+	return m.findAzureDataDiskInSysfs(lun)
+}
 
-		if !m.isBlockDevice(realPath) {
+// findAzureDataDiskInSysfs finds the SCSI disk on the Azure data disk controller whose
+// address (host:channel:target:lun) has the given LUN
+func (m *LinuxMounter) findAzureDataDiskInSysfs(lun int) (string, error) {
+	disks, err := filepath.Glob(m.hostPath("/sys/block/sd*"))
+	if err != nil {
+		return "", err
+	}
+	for _, disk := range disks {
+		scsiDevice, err := filepath.EvalSymlinks(filepath.Join(disk, "device"))
+		if err != nil || !strings.Contains(scsiDevice, "/"+azureDataDiskController+"/") {
 			continue
 		}
-
-		// CRITICAL: Skip devices that are already in use
-		// Check if device has partitions (indicates it's a system disk)
-		deviceName := filepath.Base(realPath)
-		if m.hasPartitions(deviceName) {
-			klog.V(5).Infof("Skipping NVMe device %s -> %s (has partitions, likely system disk)", match, realPath)
+		address := strings.Split(filepath.Base(scsiDevice), ":")
+		if len(address) != 4 || address[3] != strconv.Itoa(lun) {
 			continue
 		}
+		device := "/dev/" + filepath.Base(disk)
+		if m.isBlockDevice(device) {
+			return device, nil
+		}
+	}
+	return "", fmt.Errorf("no Azure data disk with LUN %d", lun)
+}
 
-		// Check if device is mounted (indicates it's in use)
-		if m.isMounted(realPath) {
-			klog.V(5).Infof("Skipping NVMe device %s -> %s (already mounted)", match, realPath)
+// findDeviceByLink resolves a /dev/disk/by-id link to its block device
+func (m *LinuxMounter) findDeviceByLink(name string) (string, error) {
+	device, err := m.resolveDevice(m.hostPath("/dev/disk/by-id/" + name))
+	if err != nil {
+		return "", err
+	}
+	if !m.isBlockDevice(device) {
+		return "", fmt.Errorf("%s is not a block device", device)
+	}
+	return device, nil
+}
+
+// findDeviceBySerial scans all block devices to find one matching the volume ID
+func (m *LinuxMounter) findDeviceBySerial(volumeID string) (string, error) {
+	// Check /sys/block for virtio devices, then SCSI devices
+	for _, pattern := range []string{"/sys/block/vd*", "/sys/block/sd*"} {
+		blockDevices, err := filepath.Glob(m.hostPath(pattern))
+		if err != nil {
 			continue
 		}
-
-		modTime := info.ModTime()
-		if newestDevice == "" || modTime.After(newestTime) {
-			newestDevice = realPath
-			newestTime = modTime
-			klog.V(5).Infof("Found NVMe candidate: %s -> %s (mtime: %v)", match, realPath, modTime)
+		for _, sysPath := range blockDevices {
+			serialPath := filepath.Join(sysPath, "serial")
+			if data, err := os.ReadFile(serialPath); err == nil {
+				serial := strings.TrimSpace(string(data))
+				if serial == volumeID {
+					deviceName := filepath.Base(sysPath)
+					devicePath := "/dev/" + deviceName
+					if m.isBlockDevice(devicePath) {
+						return devicePath, nil
+					}
+				}
+			}
 		}
 	}
 
-	if newestDevice != "" {
-		klog.V(4).Infof("Selected newest NVMe device: %s (mtime: %v)", newestDevice, newestTime)
-		return newestDevice, nil
-	}
+	return "", fmt.Errorf("device not found for volume %s", volumeID)
+}
 
-	return "", fmt.Errorf("no suitable NVMe device found")
+// findNVMeDevice returns the newest unused AWS NVMe device, skipping devices whose size
+// differs from expectedSize when it is set
+func (m *LinuxMounter) findNVMeDevice(volumeID string, expectedSize int64) (string, error) {
+	// On Emma.ms with AWS-style NVMe, devices appear as:
+	// /dev/disk/by-id/nvme-Amazon_Elastic_Block_Store_vol<hex_id>
+	// The Emma volume ID does not appear in the name, so the most recently attached
+	// unused device is assumed to be the volume
+	return m.findNewestDevice("NVMe", awsNVMePatterns, expectedSize)
 }
 
-// findCloudProviderDevice scans for devices from GCP, Azure, or other cloud providers
-func (m *LinuxMounter) findCloudProviderDevice(volumeID string) (string, error) {
+// findCloudProviderDevice returns the newest unused device from GCP, Azure, or other cloud
+// providers, skipping devices whose size differs from expectedSize when it is set
+func (m *LinuxMounter) findCloudProviderDevice(volumeID string, expectedSize int64) (string, error) {
 	klog.V(4).Infof("Scanning for cloud provider devices for volume %s", volumeID)
 
-	// Patterns to check for different cloud providers
-	patterns := []string{
-		// GCP patterns
-		"/dev/disk/by-id/google-*",
-		"/dev/disk/by-id/scsi-0Google_PersistentDisk_*",
-
-		// Azure patterns
-		"/dev/disk/by-id/scsi-*",
-
-		// Generic SCSI patterns
-		"/dev/disk/by-id/scsi-3*",
-	}
+	// GCP patterns first, then Azure and generic SCSI patterns
+	patterns := append(append([]string{}, gcpPDPatterns...), scsiPatterns...)
+	return m.findNewestDevice("cloud provider", patterns, expectedSize)
+}
 
+// findNewestDevice returns the block device of the most recently created link matching
+// patterns, excluding devices that are already in use (mounted or partitioned) and
+// devices whose size differs from expectedSize when it is set
+func (m *LinuxMounter) findNewestDevice(kind string, patterns []string, expectedSize int64) (string, error) {
+	// The patterns overlap, so each link is only considered once
 	var allMatches []string
+	seen := make(map[string]bool)
 	for _, pattern := range patterns {
-		matches, err := filepath.Glob(pattern)
-		if err == nil {
-			allMatches = append(allMatches, matches...)
+		matches, err := filepath.Glob(m.hostPath(pattern))
+		if err != nil {
+			continue
+		}
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				allMatches = append(allMatches, match)
+			}
 		}
 	}
 
 	if len(allMatches) == 0 {
-		return "", fmt.Errorf("no cloud provider devices found")
+		return "", fmt.Errorf("no %s devices found", kind)
 	}
 
-	// Find the most recently created device (likely our newly attached volume)
-	// BUT exclude devices that are already in use (mounted or have partitions)
 	var newestDevice string
 	var newestTime time.Time
 
 	for _, match := range allMatches {
 		// Skip partition links
-		if strings.Contains(match, "-part") || strings.Contains(match, "_1") {
+		if name := filepath.Base(match); strings.Contains(name, "-part") || strings.Contains(name, "_1") {
 			continue
 		}
 
@@ -528,7 +682,7 @@ func (m *LinuxMounter) findCloudProviderDevice(volumeID string) (string, error)
 		}
 
 		// Resolve symlink to get actual device
-		realPath, err := filepath.EvalSymlinks(match)
+		realPath, err := m.resolveDevice(match)
 		if err != nil {
 			continue
 		}
@@ -538,14 +692,20 @@ func (m *LinuxMounter) findCloudProviderDevice(volumeID string) (string, error)
 		}
 
 		// CRITICAL: Skip devices that are already in use
+		// Check if device has partitions (indicates it's a system disk)
 		deviceName := filepath.Base(realPath)
 		if m.hasPartitions(deviceName) {
-			klog.V(5).Infof("Skipping cloud device %s -> %s (has partitions, likely system disk)", match, realPath)
+			klog.V(5).Infof("Skipping %s device %s -> %s (has partitions, likely system disk)", kind, match, realPath)
 			continue
 		}
 
+		// Check if device is mounted (indicates it's in use)
 		if m.isMounted(realPath) {
-			klog.V(5).Infof("Skipping cloud device %s -> %s (already mounted)", match, realPath)
+			klog.V(5).Infof("Skipping %s device %s -> %s (already mounted)", kind, match, realPath)
+			continue
+		}
+
+		if !m.deviceSizeMatches(realPath, expectedSize) {
 			continue
 		}
 
@@ -553,21 +713,95 @@ func (m *LinuxMounter) findCloudProviderDevice(volumeID string) (string, error)
 		if newestDevice == "" || modTime.After(newestTime) {
 			newestDevice = realPath
 			newestTime = modTime
-			klog.V(5).Infof("Found cloud device candidate: %s -> %s (mtime: %v)", match, realPath, modTime)
+			klog.V(5).Infof("Found %s candidate: %s -> %s (mtime: %v)", kind, match, realPath, modTime)
 		}
 	}
 
 	if newestDevice != "" {
-		klog.V(4).Infof("Selected newest cloud provider device: %s (mtime: %v)", newestDevice, newestTime)
+		klog.V(4).Infof("Selected newest %s device: %s (mtime: %v)", kind, newestDevice, newestTime)
 		return newestDevice, nil
 	}
 
-	return "", fmt.Errorf("no suitable cloud provider device found")
+	return "", fmt.Errorf("no suitable %s device found", kind)
+}
+
+// deviceSizeMatches reports whether a device is within 1% of expectedSize, which absorbs
+// rounding by the cloud provider. An unknown expected or device size matches.
+func (m *LinuxMounter) deviceSizeMatches(device string, expectedSize int64) bool {
+	if expectedSize <= 0 {
+		return true
+	}
+	size, err := m.deviceSizeBytes(device)
+	if err != nil {
+		klog.V(4).Infof("Failed to get size of candidate device %s, not checking it: %v", device, err)
+		return true
+	}
+	diff := size - expectedSize
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > expectedSize/100 {
+		klog.V(4).Infof("Skipping device %s (size %d bytes, expected %d)", device, size, expectedSize)
+		return false
+	}
+	return true
+}
+
+// CheckDevice verifies that a previously discovered device is still the one the hints
+// identify: the device their serial, LUN or provider volume ID resolves to, e.g. through a
+// /dev/disk/by-id link, and of the size in hints. Device names are reused after a detach,
+// so without identifying hints the device cannot be verified and must be discovered again.
+func (m *LinuxMounter) CheckDevice(device string, hints DeviceHints) error {
+	if !m.isBlockDevice(device) {
+		return fmt.Errorf("%s is not a block device", device)
+	}
+	if !hints.resolvable() {
+		return fmt.Errorf("%s cannot be verified without a serial, LUN or provider volume ID", device)
+	}
+	identified, err := m.findDeviceByHints(hints)
+	if err != nil {
+		return err
+	}
+	if identified != device {
+		return fmt.Errorf("%s is now %s", hints, identified)
+	}
+	if !m.deviceSizeMatches(device, hints.SizeBytes) {
+		return fmt.Errorf("%s is not %d bytes", device, hints.SizeBytes)
+	}
+	return nil
+}
+
+// hostPath returns path under the mounter's root
+func (m *LinuxMounter) hostPath(path string) string {
+	if m.root == "" {
+		return path
+	}
+	return filepath.Join(m.root, path)
+}
+
+// resolveDevice resolves a root-prefixed /dev/disk link to the host path of its device
+func (m *LinuxMounter) resolveDevice(link string) (string, error) {
+	realPath, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return "", err
+	}
+	if m.root == "" {
+		return realPath, nil
+	}
+	rel, err := filepath.Rel(m.root, realPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("%s resolves outside %s", link, m.root)
+	}
+	return "/" + rel, nil
 }
 
 // isBlockDevice checks if a path is a block device
 func (m *LinuxMounter) isBlockDevice(path string) bool {
-	fileInfo, err := os.Stat(path)
+	if m.blockDevice != nil {
+		return m.blockDevice(m.hostPath(path))
+	}
+
+	fileInfo, err := os.Stat(m.hostPath(path))
 	if err != nil {
 		return false
 	}
@@ -582,7 +816,7 @@ func (m *LinuxMounter) hasPartitions(deviceName string) bool {
 	// Check if /sys/block/{device}/*/partition exists
 	// This indicates the device has partitions
 	pattern := fmt.Sprintf("/sys/block/%s/*/partition", deviceName)
-	matches, err := filepath.Glob(pattern)
+	matches, err := filepath.Glob(m.hostPath(pattern))
 	if err != nil {
 		return false
 	}
@@ -592,25 +826,32 @@ func (m *LinuxMounter) hasPartitions(deviceName string) bool {
 // isMounted checks if a device is currently mounted
 func (m *LinuxMounter) isMounted(devicePath string) bool {
 	// Read /proc/mounts to check if device is mounted
-	data, err := os.ReadFile("/proc/mounts")
+	data, err := os.ReadFile(m.hostPath("/proc/mounts"))
 	if err != nil {
 		return false
 	}
 
-	// Check if device path appears in mounts
-	return strings.Contains(string(data), devicePath)
+	// Compare the source field exactly, so /dev/nvme1n1 is not taken for mounted
+	// because /dev/nvme1n10 is
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == devicePath {
+			return true
+		}
+	}
+	return false
 }
 
 // ResizeFilesystem resizes the filesystem on the device
 func (m *LinuxMounter) ResizeFilesystem(devicePath, fstype string) error {
 	klog.V(4).Infof("Resizing filesystem on %s (type: %s)", devicePath, fstype)
 
-	var cmd *exec.Cmd
+	var cmd utilexec.Cmd
 
 	switch fstype {
 	case "ext4":
 		// resize2fs for ext4
-		cmd = exec.Command("resize2fs", devicePath)
+		cmd = m.formatter.Exec.Command("resize2fs", devicePath)
 	case "xfs":
 		// xfs_growfs for xfs (requires mount point, not device)
 		// This should be called with the mount point, not the device
@@ -632,12 +873,12 @@ func (m *LinuxMounter) ResizeFilesystem(devicePath, fstype string) error {
 func (m *LinuxMounter) ResizeFilesystemAtPath(mountPath, fstype string) error {
 	klog.V(4).Infof("Resizing filesystem at %s (type: %s)", mountPath, fstype)
 
-	var cmd *exec.Cmd
+	var cmd utilexec.Cmd
 
 	switch fstype {
 	case "xfs":
 		// xfs_growfs requires the mount point
-		cmd = exec.Command("xfs_growfs", mountPath)
+		cmd = m.formatter.Exec.Command("xfs_growfs", mountPath)
 	default:
 		return fmt.Errorf("ResizeFilesystemAtPath only supports xfs, got: %s", fstype)
 	}
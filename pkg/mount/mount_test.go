@@ -0,0 +1,198 @@
+package mount
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	mountutils "k8s.io/mount-utils"
+	utilexec "k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+)
+
+func TestCheckExpectedFilesystem(t *testing.T) {
+	tests := []struct {
+		name       string
+		existingFS string
+		fstype     string
+		wantErr    bool
+	}{
+		{name: "matching filesystem", existingFS: "ext4", fstype: "ext4"},
+		{name: "no filesystem", existingFS: "", fstype: "ext4", wantErr: true},
+		{name: "different filesystem", existingFS: "xfs", fstype: "ext4", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkExpectedFilesystem("/dev/vdb", tt.existingFS, tt.fstype)
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrNotFormatted) {
+				t.Errorf("expected ErrNotFormatted, got %v", err)
+			}
+		})
+	}
+}
+
+// fakeCommand is the scripted result of one command run by the mounter
+type fakeCommand struct {
+	output string
+	err    error
+}
+
+// newFakeExec returns an exec that answers commands in order and records their command lines
+func newFakeExec(commands []fakeCommand, calls *[]string) *testingexec.FakeExec {
+	fake := &testingexec.FakeExec{}
+	for _, command := range commands {
+		command := command
+		fake.CommandScript = append(fake.CommandScript, func(cmd string, args ...string) utilexec.Cmd {
+			*calls = append(*calls, strings.Join(append([]string{cmd}, args...), " "))
+			action := func() ([]byte, []byte, error) { return []byte(command.output), nil, command.err }
+			return testingexec.InitFakeCmd(&testingexec.FakeCmd{
+				CombinedOutputScript: []testingexec.FakeAction{action},
+				OutputScript:         []testingexec.FakeAction{action},
+			}, cmd, args...)
+		})
+	}
+	return fake
+}
+
+func TestLinuxMounterFormatAndMount(t *testing.T) {
+	unformatted := fakeCommand{err: testingexec.FakeExitError{Status: 2}}
+	blkid := "blkid -p -s TYPE -s PTTYPE -o export /dev/vdb"
+
+	tests := []struct {
+		name      string
+		formatted bool
+		force     bool
+		commands  []fakeCommand
+		wantCalls []string
+		wantErr   error
+		wantMount bool
+	}{
+		{
+			name:      "unformatted device is formatted and mounted",
+			commands:  []fakeCommand{unformatted, {}, {output: "10737418240\n"}},
+			wantCalls: []string{blkid, "blockdev --getsize64 /dev/vdb", "mkfs.ext4 -F /dev/vdb"},
+			wantMount: true,
+		},
+		{
+			name:      "formatted device is mounted without formatting",
+			commands:  []fakeCommand{{output: "DEVNAME=/dev/vdb\nTYPE=ext4\n"}},
+			wantCalls: []string{blkid},
+			wantMount: true,
+		},
+		{
+			name:      "different filesystem is not reformatted",
+			commands:  []fakeCommand{{output: "DEVNAME=/dev/vdb\nTYPE=xfs\n"}},
+			wantCalls: []string{blkid},
+			wantErr:   ErrFilesystemMismatch,
+		},
+		{
+			name:      "partitioned device is not formatted",
+			commands:  []fakeCommand{{output: "DEVNAME=/dev/vdb\nPTTYPE=gpt\n"}},
+			wantCalls: []string{blkid},
+			wantErr:   ErrFilesystemMismatch,
+		},
+		{
+			name:      "forced reformat formats a different filesystem",
+			force:     true,
+			commands:  []fakeCommand{{output: "DEVNAME=/dev/vdb\nTYPE=xfs\n"}, {}, {output: "10737418240\n"}},
+			wantCalls: []string{blkid, "blockdev --getsize64 /dev/vdb", "mkfs.ext4 -F /dev/vdb"},
+			wantMount: true,
+		},
+		{
+			name:      "forced reformat keeps a matching filesystem",
+			force:     true,
+			commands:  []fakeCommand{{output: "DEVNAME=/dev/vdb\nTYPE=ext4\n"}},
+			wantCalls: []string{blkid},
+			wantMount: true,
+		},
+		{
+			name:      "expected filesystem is mounted",
+			formatted: true,
+			commands:  []fakeCommand{{output: "DEVNAME=/dev/vdb\nTYPE=ext4\n"}},
+			wantCalls: []string{blkid},
+			wantMount: true,
+		},
+		{
+			name:      "partitioned device is never treated as formatted",
+			formatted: true,
+			commands:  []fakeCommand{{output: "DEVNAME=/dev/vdb\nPTTYPE=gpt\n"}},
+			wantCalls: []string{blkid},
+			wantErr:   ErrNotFormatted,
+		},
+		{
+			name:      "probe failures are returned",
+			commands:  []fakeCommand{{err: testingexec.FakeExitError{Status: 4}}},
+			wantCalls: []string{blkid},
+			wantErr:   testingexec.FakeExitError{Status: 4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			fakeMounter := mountutils.NewFakeMounter(nil)
+			m := newLinuxMounter(fakeMounter, newFakeExec(tt.commands, &calls))
+			target := filepath.Join(t.TempDir(), "staging")
+
+			var err error
+			switch {
+			case tt.formatted:
+				err = m.MountFormatted("/dev/vdb", target, "ext4", []string{"noatime"})
+			case tt.force:
+				err = m.ReformatAndMount("/dev/vdb", target, "ext4", []string{"noatime"})
+			default:
+				err = m.FormatAndMount("/dev/vdb", target, "ext4", []string{"noatime"})
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("expected %v, got %v", tt.wantErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("expected commands %q, got %q", tt.wantCalls, calls)
+			}
+
+			notMnt, err := m.IsLikelyNotMountPoint(target)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if notMnt == tt.wantMount {
+				t.Errorf("expected mounted %v, got %v", tt.wantMount, !notMnt)
+			}
+		})
+	}
+}
+
+func TestLinuxMounterUnmount(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "target")
+	fakeMounter := mountutils.NewFakeMounter(nil)
+	m := newLinuxMounter(fakeMounter, &testingexec.FakeExec{})
+
+	if err := m.Mount("/staging", target, "", []string{"bind", "ro"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if notMnt, err := m.IsLikelyNotMountPoint(target); err != nil || notMnt {
+		t.Fatalf("expected %s to be mounted, got notMnt %v, err %v", target, notMnt, err)
+	}
+	if err := m.Unmount(target); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if notMnt, err := m.IsLikelyNotMountPoint(target); err != nil || !notMnt {
+		t.Errorf("expected %s to be unmounted, got notMnt %v, err %v", target, notMnt, err)
+	}
+
+	if notMnt, err := m.IsLikelyNotMountPoint(filepath.Join(t.TempDir(), "missing")); err != nil || !notMnt {
+		t.Errorf("expected a missing path to be reported as not mounted, got notMnt %v, err %v", notMnt, err)
+	}
+}
@@ -0,0 +1,80 @@
+package mount
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// nvmeIDCtrl returns the identify controller data of an NVMe device as nvme-cli JSON,
+// replaced in tests
+var nvmeIDCtrl = func(device string) ([]byte, error) {
+	return exec.Command("nvme", "id-ctrl", "-o", "json", device).Output()
+}
+
+// isEBSVolumeID reports whether id looks like an AWS EBS volume ID such as vol-0abc
+func isEBSVolumeID(id string) bool {
+	return strings.HasPrefix(id, "vol")
+}
+
+// normalizeEBSVolumeID strips the dash AWS leaves out of the NVMe serial number, so
+// vol-0abc and vol0abc compare equal
+func normalizeEBSVolumeID(id string) string {
+	return strings.ReplaceAll(strings.TrimSpace(id), "-", "")
+}
+
+// findNVMeDeviceByEBSVolumeID finds the NVMe namespace whose controller serial number is
+// the EBS volume ID. EBS controllers report the volume ID, without its dash, as the serial
+// in their identify controller data, which sysfs exposes and nvme-cli can read directly.
+func (m *LinuxMounter) findNVMeDeviceByEBSVolumeID(volumeID string) (string, error) {
+	want := normalizeEBSVolumeID(volumeID)
+
+	matches, err := filepath.Glob(m.hostPath("/sys/block/nvme*n*"))
+	if err != nil {
+		return "", fmt.Errorf("failed to glob NVMe devices: %w", err)
+	}
+
+	for _, sysPath := range matches {
+		deviceName := filepath.Base(sysPath)
+		devicePath := "/dev/" + deviceName
+		if !m.isBlockDevice(devicePath) {
+			continue
+		}
+
+		serial, err := m.nvmeSerial(sysPath, devicePath)
+		if err != nil {
+			klog.V(5).Infof("Cannot read NVMe serial of %s: %v", devicePath, err)
+			continue
+		}
+		if normalizeEBSVolumeID(serial) == want {
+			return devicePath, nil
+		}
+	}
+
+	return "", fmt.Errorf("no NVMe device has EBS volume ID %s", volumeID)
+}
+
+// nvmeSerial returns the controller serial number of an NVMe namespace from sysfs, falling
+// back to nvme-cli when sysfs does not expose it
+func (m *LinuxMounter) nvmeSerial(sysPath, devicePath string) (string, error) {
+	if data, err := os.ReadFile(filepath.Join(sysPath, "device", "serial")); err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	output, err := nvmeIDCtrl(m.hostPath(devicePath))
+	if err != nil {
+		return "", fmt.Errorf("nvme id-ctrl failed: %w", err)
+	}
+	var idCtrl struct {
+		SerialNumber string `json:"sn"`
+	}
+	if err := json.Unmarshal(output, &idCtrl); err != nil {
+		return "", fmt.Errorf("failed to parse nvme id-ctrl output: %w", err)
+	}
+	return strings.TrimSpace(idCtrl.SerialNumber), nil
+}
@@ -0,0 +1,82 @@
+package mount
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFindNVMeDeviceByEBSVolumeID(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(h *fakeHost)
+		idCtrl   map[string]string
+		expected string
+	}{
+		{
+			name: "serial in sysfs picks its disk, not the newest",
+			setup: func(h *fakeHost) {
+				h.addDisk("nvme1n1", "")
+				h.addDisk("nvme2n1", "")
+				h.writeFile("sys/block/nvme1n1/device/serial", "vol0aaa\n")
+				h.writeFile("sys/block/nvme2n1/device/serial", "vol0bbb\n")
+			},
+			expected: "/dev/nvme1n1",
+		},
+		{
+			name: "serial from nvme id-ctrl",
+			setup: func(h *fakeHost) {
+				h.addDisk("nvme1n1", "")
+				h.addDisk("nvme2n1", "")
+			},
+			idCtrl: map[string]string{
+				"/dev/nvme1n1": `{"vid":7439,"sn":"vol0bbb            ","mn":"Amazon Elastic Block Store"}`,
+				"/dev/nvme2n1": `{"vid":7439,"sn":"vol0aaa            ","mn":"Amazon Elastic Block Store"}`,
+			},
+			expected: "/dev/nvme2n1",
+		},
+		{
+			name: "no matching serial",
+			setup: func(h *fakeHost) {
+				h.addDisk("nvme1n1", "")
+				h.writeFile("sys/block/nvme1n1/device/serial", "vol0bbb\n")
+			},
+		},
+		{
+			name: "unreadable serials are skipped",
+			setup: func(h *fakeHost) {
+				h.addDisk("nvme1n1", "")
+			},
+			idCtrl: map[string]string{"/dev/nvme1n1": "not json"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newFakeHost(t)
+			tt.setup(h)
+
+			nvmeIDCtrl = func(device string) ([]byte, error) {
+				for name, output := range tt.idCtrl {
+					if device == h.root+name {
+						return []byte(output), nil
+					}
+				}
+				return nil, fmt.Errorf("no such device %s", device)
+			}
+
+			device, err := h.mounter().findNVMeDeviceByEBSVolumeID("vol-0aaa")
+			if tt.expected == "" {
+				if err == nil {
+					t.Errorf("expected no device, got %s", device)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if device != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, device)
+			}
+		})
+	}
+}
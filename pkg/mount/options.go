@@ -0,0 +1,40 @@
+package mount
+
+import "strings"
+
+// UnsafeMountOptions are mount options that can be used to escalate privileges on the host,
+// for example by running setuid binaries or opening device nodes from a crafted volume
+var UnsafeMountOptions = []string{"suid", "dev"}
+
+// SanitizeMountOptions removes unsafe mount options that are not explicitly allowed and
+// adds their negation, e.g. nosuid, since a filesystem may default to the unsafe behavior.
+// It returns the options to use and the options that were removed.
+func SanitizeMountOptions(options []string, allowed map[string]bool) ([]string, []string) {
+	var kept, removed []string
+	present := make(map[string]bool, len(options))
+	for _, option := range options {
+		name := strings.ToLower(strings.TrimSpace(option))
+		if isUnsafeMountOption(name) && !allowed[name] {
+			removed = append(removed, option)
+			continue
+		}
+		present[name] = true
+		kept = append(kept, option)
+	}
+	for _, unsafe := range UnsafeMountOptions {
+		if !present[unsafe] && !present["no"+unsafe] {
+			kept = append(kept, "no"+unsafe)
+		}
+	}
+	return kept, removed
+}
+
+// isUnsafeMountOption reports whether option is in UnsafeMountOptions
+func isUnsafeMountOption(option string) bool {
+	for _, unsafe := range UnsafeMountOptions {
+		if option == unsafe {
+			return true
+		}
+	}
+	return false
+}
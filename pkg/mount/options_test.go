@@ -0,0 +1,57 @@
+package mount
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSanitizeMountOptions(t *testing.T) {
+	tests := []struct {
+		name            string
+		options         []string
+		allowed         map[string]bool
+		expectedKept    []string
+		expectedRemoved []string
+	}{
+		{
+			name:         "safe options kept",
+			options:      []string{"noatime", "nosuid", "nodev"},
+			expectedKept: []string{"noatime", "nosuid", "nodev"},
+		},
+		{
+			name:         "no options",
+			expectedKept: []string{"nosuid", "nodev"},
+		},
+		{
+			name:            "unsafe options replaced",
+			options:         []string{"noatime", "suid", "DEV"},
+			expectedKept:    []string{"noatime", "nosuid", "nodev"},
+			expectedRemoved: []string{"suid", "DEV"},
+		},
+		{
+			name:            "explicitly allowed option kept",
+			options:         []string{"suid", "dev"},
+			allowed:         map[string]bool{"suid": true},
+			expectedKept:    []string{"suid", "nodev"},
+			expectedRemoved: []string{"dev"},
+		},
+		{
+			name:         "allowed option not requested",
+			options:      []string{"noatime"},
+			allowed:      map[string]bool{"suid": true, "dev": true},
+			expectedKept: []string{"noatime", "nosuid", "nodev"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, removed := SanitizeMountOptions(tt.options, tt.allowed)
+			if !reflect.DeepEqual(kept, tt.expectedKept) {
+				t.Errorf("expected kept %v, got %v", tt.expectedKept, kept)
+			}
+			if !reflect.DeepEqual(removed, tt.expectedRemoved) {
+				t.Errorf("expected removed %v, got %v", tt.expectedRemoved, removed)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package mount
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"k8s.io/klog/v2"
+)
+
+// busRescanEnabled controls whether device discovery rescans SCSI hosts and NVMe controllers
+var busRescanEnabled atomic.Bool
+
+func init() {
+	busRescanEnabled.Store(true)
+}
+
+// SetBusRescan selects whether device discovery asks the kernel to rescan SCSI hosts and
+// NVMe controllers for newly attached disks
+func SetBusRescan(enabled bool) {
+	busRescanEnabled.Store(enabled)
+	if enabled {
+		klog.Infof("Device discovery: SCSI and NVMe bus rescans enabled")
+	} else {
+		klog.Infof("Device discovery: SCSI and NVMe bus rescans disabled")
+	}
+}
+
+// rescanBuses asks the kernel to look for new disks on every SCSI host and NVMe controller.
+// Hot-plugged disks on Azure and GCP backed VMs sometimes only show up after a rescan.
+// Failures are logged and ignored, the device wait continues either way.
+func (m *LinuxMounter) rescanBuses() {
+	if !busRescanEnabled.Load() {
+		return
+	}
+
+	// "- - -" scans all channels, targets and LUNs of a SCSI host
+	scsiHosts, _ := filepath.Glob(m.hostPath("/sys/class/scsi_host/host*/scan"))
+	for _, scan := range scsiHosts {
+		if err := os.WriteFile(scan, []byte("- - -"), 0200); err != nil {
+			klog.V(4).Infof("SCSI rescan via %s failed: %v", scan, err)
+		}
+	}
+
+	// rescan_controller re-reads the namespace list of an NVMe controller
+	nvmeControllers, _ := filepath.Glob(m.hostPath("/sys/class/nvme/nvme*/rescan_controller"))
+	for _, rescan := range nvmeControllers {
+		if err := os.WriteFile(rescan, []byte("1"), 0200); err != nil {
+			klog.V(4).Infof("NVMe rescan via %s failed: %v", rescan, err)
+		}
+	}
+
+	klog.V(5).Infof("Rescanned %d SCSI hosts and %d NVMe controllers", len(scsiHosts), len(nvmeControllers))
+}
+
+// rescanDevices rescans the SCSI and NVMe buses and then has udev create the device links
+func (m *LinuxMounter) rescanDevices() {
+	m.rescanBuses()
+	m.triggerUdev()
+}
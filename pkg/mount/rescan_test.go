@@ -0,0 +1,48 @@
+package mount
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRescanBuses tests that every SCSI host and NVMe controller is asked to rescan
+func TestRescanBuses(t *testing.T) {
+	defer SetBusRescan(true)
+
+	h := newFakeHost(t)
+	files := map[string]string{
+		"sys/class/scsi_host/host0/scan":         "- - -",
+		"sys/class/scsi_host/host1/scan":         "- - -",
+		"sys/class/nvme/nvme0/rescan_controller": "1",
+	}
+	reset := func() {
+		for path := range files {
+			h.writeFile(path, "")
+		}
+	}
+	read := func(path string) string {
+		data, err := os.ReadFile(filepath.Join(h.root, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	reset()
+	h.mounter().rescanBuses()
+	for path, expected := range files {
+		if got := read(path); got != expected {
+			t.Errorf("expected %q written to %s, got %q", expected, path, got)
+		}
+	}
+
+	SetBusRescan(false)
+	reset()
+	h.mounter().rescanBuses()
+	for path := range files {
+		if got := read(path); got != "" {
+			t.Errorf("expected no rescan of %s when disabled, got %q", path, got)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package mount
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+// Device strategies for volumes attached without identifying hints
+const (
+	// DeviceStrategyAuto detects the hypervisor or cloud from DMI data
+	DeviceStrategyAuto = "auto"
+
+	// DeviceStrategyGeneric tries the heuristics of every provider
+	DeviceStrategyGeneric = "generic"
+
+	// DeviceStrategyVirtio matches the volume ID against virtio and QEMU disk serials
+	DeviceStrategyVirtio = "virtio"
+
+	// DeviceStrategyAWSNVMe picks the newest unused EBS NVMe device
+	DeviceStrategyAWSNVMe = "aws-nvme"
+
+	// DeviceStrategyGCPPD picks the newest unused Google persistent disk
+	DeviceStrategyGCPPD = "gcp-pd"
+
+	// DeviceStrategyAzureLUN picks the newest unused Azure data disk LUN
+	DeviceStrategyAzureLUN = "azure-lun"
+)
+
+// Device link patterns of the providers Emma provisions VMs on
+var (
+	// AWS: /dev/disk/by-id/nvme-Amazon_Elastic_Block_Store_vol* -> /dev/nvme1n1
+	awsNVMePatterns = []string{"/dev/disk/by-id/nvme-Amazon_Elastic_Block_Store_vol*"}
+
+	// GCP: /dev/disk/by-id/google-<disk-name> and scsi-0Google_PersistentDisk_* -> /dev/sdb
+	gcpPDPatterns = []string{
+		"/dev/disk/by-id/google-*",
+		"/dev/disk/by-id/scsi-0Google_PersistentDisk_*",
+	}
+
+	// Azure and generic SCSI: /dev/disk/by-id/scsi-* -> /dev/sdc
+	scsiPatterns = []string{
+		"/dev/disk/by-id/scsi-*",
+		"/dev/disk/by-id/scsi-3*",
+	}
+
+	// Azure: the udev rules link data disks only, never the OS or resource disk
+	azureLUNPatterns = []string{
+		"/dev/disk/azure/scsi1/lun*",
+		"/dev/disk/azure/data/by-lun/*",
+		"/dev/disk/by-lun/*",
+	}
+)
+
+// deviceStrategy finds the device of a volume attached without identifying hints on one
+// kind of VM
+type deviceStrategy interface {
+	// name identifies the strategy in logs and the --device-strategy flag
+	name() string
+
+	// links returns the /dev/disk/by-id links that would name the volume's device directly
+	links(volumeID string) []string
+
+	// scan looks for the volume's device among the attached devices
+	scan(m *LinuxMounter, volumeID string, expectedSize int64) (string, error)
+}
+
+// virtioStrategy finds KVM/QEMU disks, whose serial is the Emma volume ID
+type virtioStrategy struct{}
+
+func (virtioStrategy) name() string { return DeviceStrategyVirtio }
+
+func (virtioStrategy) links(volumeID string) []string {
+	return []string{
+		"virtio-" + volumeID,
+		"scsi-0QEMU_QEMU_HARDDISK_" + volumeID,
+		"ata-QEMU_HARDDISK_" + volumeID,
+	}
+}
+
+func (virtioStrategy) scan(m *LinuxMounter, volumeID string, expectedSize int64) (string, error) {
+	return m.findDeviceBySerial(volumeID)
+}
+
+// awsNVMeStrategy finds EBS volumes, whose device names carry the AWS volume ID rather
+// than the Emma one
+type awsNVMeStrategy struct{}
+
+func (awsNVMeStrategy) name() string { return DeviceStrategyAWSNVMe }
+
+func (awsNVMeStrategy) links(volumeID string) []string { return nil }
+
+func (awsNVMeStrategy) scan(m *LinuxMounter, volumeID string, expectedSize int64) (string, error) {
+	return m.findNVMeDevice(volumeID, expectedSize)
+}
+
+// gcpPDStrategy finds Google persistent disks
+type gcpPDStrategy struct{}
+
+func (gcpPDStrategy) name() string { return DeviceStrategyGCPPD }
+
+func (gcpPDStrategy) links(volumeID string) []string {
+	return []string{
+		"google-" + volumeID,
+		"scsi-0Google_PersistentDisk_" + volumeID,
+	}
+}
+
+func (gcpPDStrategy) scan(m *LinuxMounter, volumeID string, expectedSize int64) (string, error) {
+	return m.findNewestDevice("GCP persistent disk", gcpPDPatterns, expectedSize)
+}
+
+// azureLUNStrategy finds Azure data disks through the LUN links of the Azure udev rules,
+// falling back to SCSI links on images without the rules
+type azureLUNStrategy struct{}
+
+func (azureLUNStrategy) name() string { return DeviceStrategyAzureLUN }
+
+func (azureLUNStrategy) links(volumeID string) []string {
+	return []string{"scsi-" + volumeID}
+}
+
+func (azureLUNStrategy) scan(m *LinuxMounter, volumeID string, expectedSize int64) (string, error) {
+	if device, err := m.findNewestDevice("Azure LUN", azureLUNPatterns, expectedSize); err == nil {
+		return device, nil
+	}
+	return m.findNewestDevice("Azure SCSI", scsiPatterns, expectedSize)
+}
+
+// genericStrategy combines the heuristics of every provider, for VMs whose provider is
+// not detected
+type genericStrategy struct{}
+
+func (genericStrategy) name() string { return DeviceStrategyGeneric }
+
+func (genericStrategy) links(volumeID string) []string {
+	return []string{
+		"virtio-" + volumeID,
+		"google-" + volumeID,
+		"scsi-0Google_PersistentDisk_" + volumeID,
+		"scsi-" + volumeID,
+		"scsi-0QEMU_QEMU_HARDDISK_" + volumeID,
+		"ata-QEMU_HARDDISK_" + volumeID,
+	}
+}
+
+func (genericStrategy) scan(m *LinuxMounter, volumeID string, expectedSize int64) (string, error) {
+	// Try NVMe first (AWS), then cloud provider devices (GCP, Azure), then serials (virtio)
+	if device, err := m.findNVMeDevice(volumeID, expectedSize); err == nil {
+		return device, nil
+	}
+	if device, err := m.findCloudProviderDevice(volumeID, expectedSize); err == nil {
+		return device, nil
+	}
+	return m.findDeviceBySerial(volumeID)
+}
+
+// deviceStrategies are the strategies selectable with --device-strategy
+var deviceStrategies = map[string]deviceStrategy{
+	DeviceStrategyGeneric:  genericStrategy{},
+	DeviceStrategyVirtio:   virtioStrategy{},
+	DeviceStrategyAWSNVMe:  awsNVMeStrategy{},
+	DeviceStrategyGCPPD:    gcpPDStrategy{},
+	DeviceStrategyAzureLUN: azureLUNStrategy{},
+}
+
+var (
+	deviceStrategyMu     sync.RWMutex
+	activeDeviceStrategy deviceStrategy = genericStrategy{}
+
+	// dmiDir holds the DMI data used to detect the provider, replaced in tests
+	dmiDir = "/sys/class/dmi/id"
+)
+
+// ConfigureDeviceStrategy selects how device discovery finds volumes attached without
+// identifying hints, detecting the provider for DeviceStrategyAuto. It returns the name
+// of the strategy in use.
+func ConfigureDeviceStrategy(name string) (string, error) {
+	var strategy deviceStrategy
+	switch name {
+	case DeviceStrategyAuto, "":
+		strategy = detectDeviceStrategy(dmiDir)
+		klog.Infof("Device discovery: detected %s device strategy", strategy.name())
+	default:
+		var ok bool
+		if strategy, ok = deviceStrategies[name]; !ok {
+			return "", fmt.Errorf("unsupported device strategy %q (supported: %s, %s, %s, %s, %s, %s)", name,
+				DeviceStrategyAuto, DeviceStrategyGeneric, DeviceStrategyVirtio, DeviceStrategyAWSNVMe, DeviceStrategyGCPPD, DeviceStrategyAzureLUN)
+		}
+		klog.Infof("Device discovery: using %s device strategy", strategy.name())
+	}
+
+	deviceStrategyMu.Lock()
+	activeDeviceStrategy = strategy
+	deviceStrategyMu.Unlock()
+	return strategy.name(), nil
+}
+
+// currentDeviceStrategy returns the configured device strategy
+func currentDeviceStrategy() deviceStrategy {
+	deviceStrategyMu.RLock()
+	defer deviceStrategyMu.RUnlock()
+	return activeDeviceStrategy
+}
+
+// detectDeviceStrategy picks the strategy of the hypervisor or cloud named in the DMI data
+// under dir, falling back to the generic strategy when it is unknown or unreadable
+func detectDeviceStrategy(dir string) deviceStrategy {
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(data))
+	}
+	sysVendor := read("sys_vendor")
+	productName := read("product_name")
+	biosVendor := read("bios_vendor")
+	biosVersion := read("bios_version")
+	klog.V(4).Infof("DMI data: sys_vendor=%q product_name=%q bios_vendor=%q bios_version=%q", sysVendor, productName, biosVendor, biosVersion)
+
+	switch {
+	case strings.HasPrefix(sysVendor, "Amazon EC2") || strings.HasPrefix(biosVendor, "Amazon EC2") || strings.Contains(strings.ToLower(biosVersion), "amazon"):
+		return awsNVMeStrategy{}
+	case sysVendor == "Google" || productName == "Google Compute Engine":
+		return gcpPDStrategy{}
+	case sysVendor == "Microsoft Corporation" && productName == "Virtual Machine":
+		return azureLUNStrategy{}
+	case sysVendor == "QEMU" || sysVendor == "OpenStack Foundation" || sysVendor == "Red Hat" || strings.HasPrefix(productName, "KVM") || strings.HasPrefix(productName, "Standard PC"):
+		return virtioStrategy{}
+	}
+	return genericStrategy{}
+}
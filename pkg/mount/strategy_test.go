@@ -0,0 +1,118 @@
+package mount
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestDetectDeviceStrategy tests picking the device strategy from DMI data
+func TestDetectDeviceStrategy(t *testing.T) {
+	tests := []struct {
+		name     string
+		dmi      map[string]string
+		expected string
+	}{
+		{name: "AWS Nitro", dmi: map[string]string{"sys_vendor": "Amazon EC2", "product_name": "m5.large"}, expected: DeviceStrategyAWSNVMe},
+		{name: "AWS Xen", dmi: map[string]string{"sys_vendor": "Xen", "bios_version": "4.11.amazon"}, expected: DeviceStrategyAWSNVMe},
+		{name: "GCP", dmi: map[string]string{"sys_vendor": "Google", "product_name": "Google Compute Engine"}, expected: DeviceStrategyGCPPD},
+		{name: "Azure", dmi: map[string]string{"sys_vendor": "Microsoft Corporation", "product_name": "Virtual Machine"}, expected: DeviceStrategyAzureLUN},
+		{name: "KVM", dmi: map[string]string{"sys_vendor": "QEMU", "product_name": "Standard PC (i440FX + PIIX, 1996)"}, expected: DeviceStrategyVirtio},
+		{name: "OpenStack", dmi: map[string]string{"sys_vendor": "OpenStack Foundation", "product_name": "OpenStack Nova"}, expected: DeviceStrategyVirtio},
+		{name: "Hyper-V outside Azure", dmi: map[string]string{"sys_vendor": "Microsoft Corporation", "product_name": "Surface"}, expected: DeviceStrategyGeneric},
+		{name: "no DMI data", dmi: nil, expected: DeviceStrategyGeneric},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, value := range tt.dmi {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := detectDeviceStrategy(dir).name(); got != tt.expected {
+				t.Errorf("expected %s strategy, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestConfigureDeviceStrategy tests device strategy selection
+func TestConfigureDeviceStrategy(t *testing.T) {
+	defer ConfigureDeviceStrategy(DeviceStrategyGeneric)
+	defer func(orig string) { dmiDir = orig }(dmiDir)
+	dmiDir = t.TempDir()
+
+	tests := []struct {
+		name        string
+		strategy    string
+		expected    string
+		expectError bool
+	}{
+		{name: "auto without DMI data", strategy: DeviceStrategyAuto, expected: DeviceStrategyGeneric},
+		{name: "empty is auto", strategy: "", expected: DeviceStrategyGeneric},
+		{name: "explicit", strategy: DeviceStrategyAzureLUN, expected: DeviceStrategyAzureLUN},
+		{name: "invalid", strategy: "floppy", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ConfigureDeviceStrategy(tt.strategy)
+			if tt.expectError {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.expected || currentDeviceStrategy().name() != tt.expected {
+				t.Errorf("expected %s strategy, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestDeviceStrategyScan tests that each strategy only considers its provider's devices
+func TestDeviceStrategyScan(t *testing.T) {
+	h := newFakeHost(t)
+	h.addDisk("vdb", "12345")
+	h.addDisk("nvme1n1", "")
+	h.addLink(nvmeLinkPrefix+"0aaa", "nvme1n1")
+	h.addDisk("sdb", "")
+	h.addLink("google-data-disk", "sdb")
+	h.addDisk("sdc", "")
+	h.addDisk("sdd", "")
+	h.addAzureLUN(0, "sdc")
+	time.Sleep(10 * time.Millisecond)
+	h.addAzureLUN(1, "sdd")
+	h.mount("/dev/sdd", "/var/lib/kubelet/plugins/other")
+	m := h.mounter()
+
+	tests := []struct {
+		strategy deviceStrategy
+		expected string
+	}{
+		{strategy: virtioStrategy{}, expected: "/dev/vdb"},
+		{strategy: awsNVMeStrategy{}, expected: "/dev/nvme1n1"},
+		{strategy: gcpPDStrategy{}, expected: "/dev/sdb"},
+		// The newest LUN is mounted by another volume
+		{strategy: azureLUNStrategy{}, expected: "/dev/sdc"},
+		{strategy: genericStrategy{}, expected: "/dev/nvme1n1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy.name(), func(t *testing.T) {
+			device, err := tt.strategy.scan(m, "12345", 0)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if device != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, device)
+			}
+		})
+	}
+}
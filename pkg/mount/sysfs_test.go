@@ -0,0 +1,682 @@
+package mount
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	mountutils "k8s.io/mount-utils"
+	utilexec "k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+)
+
+// fakeHost is a temporary /dev, /sys and /proc tree for device discovery tests. Device
+// nodes are regular files, which the mounter is told to treat as block devices.
+type fakeHost struct {
+	t      *testing.T
+	root   string
+	mounts []string
+}
+
+func newFakeHost(t *testing.T) *fakeHost {
+	t.Helper()
+	h := &fakeHost{t: t, root: t.TempDir()}
+
+	// Never run the host's nvme-cli against the fake tree
+	original := nvmeIDCtrl
+	nvmeIDCtrl = func(device string) ([]byte, error) {
+		return nil, fmt.Errorf("nvme-cli is not available in tests")
+	}
+	t.Cleanup(func() { nvmeIDCtrl = original })
+
+	for _, dir := range []string{"dev/disk/by-id", "sys/block", "proc"} {
+		h.mkdir(dir)
+	}
+	h.writeMounts()
+	return h
+}
+
+func (h *fakeHost) mkdir(dir string) {
+	h.t.Helper()
+	if err := os.MkdirAll(filepath.Join(h.root, dir), 0755); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+func (h *fakeHost) writeFile(path, content string) {
+	h.t.Helper()
+	h.mkdir(filepath.Dir(path))
+	if err := os.WriteFile(filepath.Join(h.root, path), []byte(content), 0644); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// addDisk creates /dev/<name> and /sys/block/<name> with an optional serial and partitions
+func (h *fakeHost) addDisk(name, serial string, partitions ...string) {
+	h.t.Helper()
+	h.writeFile("dev/"+name, "")
+	h.mkdir("sys/block/" + name)
+	if serial != "" {
+		h.writeFile("sys/block/"+name+"/serial", serial+"\n")
+	}
+	for i, partition := range partitions {
+		h.writeFile("dev/"+partition, "")
+		h.writeFile("sys/block/"+name+"/"+partition+"/partition", strconv.Itoa(i+1)+"\n")
+	}
+}
+
+// addLink creates a /dev/disk/by-id link to a device. Links are created with increasing
+// modification times, so the last one added is the newest.
+func (h *fakeHost) addLink(link, device string) {
+	h.t.Helper()
+	path := filepath.Join(h.root, "dev/disk/by-id", link)
+	if err := os.Symlink("../../"+device, path); err != nil {
+		h.t.Fatal(err)
+	}
+	// Symlink mtimes cannot be set portably, so space out their creation instead
+	time.Sleep(10 * time.Millisecond)
+}
+
+// addAzureLUN creates the /dev/disk/azure/scsi1 link the Azure udev rules create for a data disk
+func (h *fakeHost) addAzureLUN(lun int, device string) {
+	h.t.Helper()
+	h.mkdir("dev/disk/azure/scsi1")
+	path := filepath.Join(h.root, "dev/disk/azure/scsi1", "lun"+strconv.Itoa(lun))
+	if err := os.Symlink("../../../"+device, path); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// addLUNLink creates a /dev/disk/by-lun link as generic udev rules create it
+func (h *fakeHost) addLUNLink(lun int, device string) {
+	h.t.Helper()
+	h.mkdir("dev/disk/by-lun")
+	if err := os.Symlink("../../"+device, filepath.Join(h.root, "dev/disk/by-lun", strconv.Itoa(lun))); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// addSCSIAddress links /sys/block/<name>/device to a SCSI device at address
+// (host:channel:target:lun) on a VMBus controller
+func (h *fakeHost) addSCSIAddress(name, controller, address string) {
+	h.t.Helper()
+	dir := filepath.Join("sys/devices/VMBUS:00", controller, "host"+strings.Split(address, ":")[0], address)
+	h.mkdir(dir)
+	if err := os.Symlink(filepath.Join(h.root, dir), filepath.Join(h.root, "sys/block", name, "device")); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// mount records a device in /proc/mounts
+func (h *fakeHost) mount(device, target string) {
+	h.t.Helper()
+	h.mounts = append(h.mounts, device+" "+target+" ext4 rw,relatime 0 0")
+	h.writeMounts()
+}
+
+func (h *fakeHost) writeMounts() {
+	h.t.Helper()
+	h.writeFile("proc/mounts", strings.Join(h.mounts, "\n")+"\n")
+}
+
+func (h *fakeHost) mounter() *LinuxMounter {
+	return &LinuxMounter{
+		root: h.root,
+		blockDevice: func(path string) bool {
+			info, err := os.Stat(path)
+			return err == nil && info.Mode().IsRegular() && strings.HasPrefix(path, filepath.Join(h.root, "dev"))
+		},
+	}
+}
+
+const nvmeLinkPrefix = "nvme-Amazon_Elastic_Block_Store_vol"
+
+func TestFindNVMeDevice(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(h *fakeHost)
+		expected string
+	}{
+		{
+			name:  "no NVMe devices",
+			setup: func(h *fakeHost) {},
+		},
+		{
+			name: "single data disk",
+			setup: func(h *fakeHost) {
+				h.addDisk("nvme1n1", "")
+				h.addLink(nvmeLinkPrefix+"0aaa", "nvme1n1")
+			},
+			expected: "/dev/nvme1n1",
+		},
+		{
+			name: "multiple candidates picks the newest link",
+			setup: func(h *fakeHost) {
+				h.addDisk("nvme1n1", "")
+				h.addDisk("nvme2n1", "")
+				h.addLink(nvmeLinkPrefix+"0aaa", "nvme1n1")
+				h.addLink(nvmeLinkPrefix+"0bbb", "nvme2n1")
+			},
+			expected: "/dev/nvme2n1",
+		},
+		{
+			name: "partitioned system disk is skipped even when newest",
+			setup: func(h *fakeHost) {
+				h.addDisk("nvme1n1", "")
+				h.addDisk("nvme0n1", "", "nvme0n1p1", "nvme0n1p2")
+				h.addLink(nvmeLinkPrefix+"0aaa", "nvme1n1")
+				h.addLink(nvmeLinkPrefix+"0sys", "nvme0n1")
+			},
+			expected: "/dev/nvme1n1",
+		},
+		{
+			name: "only a partitioned system disk",
+			setup: func(h *fakeHost) {
+				h.addDisk("nvme0n1", "", "nvme0n1p1")
+				h.addLink(nvmeLinkPrefix+"0sys", "nvme0n1")
+			},
+		},
+		{
+			name: "partition links are skipped",
+			setup: func(h *fakeHost) {
+				h.addDisk("nvme1n1", "")
+				h.addDisk("nvme0n1", "", "nvme0n1p1")
+				h.addLink(nvmeLinkPrefix+"0aaa", "nvme1n1")
+				h.addLink(nvmeLinkPrefix+"0sys-part1", "nvme0n1p1")
+			},
+			expected: "/dev/nvme1n1",
+		},
+		{
+			name: "already mounted disk is skipped even when newest",
+			setup: func(h *fakeHost) {
+				h.addDisk("nvme1n1", "")
+				h.addDisk("nvme2n1", "")
+				h.addLink(nvmeLinkPrefix+"0aaa", "nvme1n1")
+				h.addLink(nvmeLinkPrefix+"0bbb", "nvme2n1")
+				h.mount("/dev/nvme2n1", "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/other/globalmount")
+			},
+			expected: "/dev/nvme1n1",
+		},
+		{
+			name: "mounted device with a longer name does not hide a free one",
+			setup: func(h *fakeHost) {
+				h.addDisk("nvme1n1", "")
+				h.addLink(nvmeLinkPrefix+"0aaa", "nvme1n1")
+				h.mount("/dev/nvme1n10", "/mnt/data")
+			},
+			expected: "/dev/nvme1n1",
+		},
+		{
+			name: "dangling link is skipped",
+			setup: func(h *fakeHost) {
+				h.addDisk("nvme1n1", "")
+				h.addLink(nvmeLinkPrefix+"0aaa", "nvme1n1")
+				h.addLink(nvmeLinkPrefix+"0gone", "nvme9n1")
+			},
+			expected: "/dev/nvme1n1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newFakeHost(t)
+			tt.setup(h)
+
+			device, err := h.mounter().findNVMeDevice("12345", 0)
+			if tt.expected == "" {
+				if err == nil {
+					t.Errorf("expected no device, got %s", device)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if device != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, device)
+			}
+		})
+	}
+}
+
+func TestFindCloudProviderDevice(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(h *fakeHost)
+		expected string
+	}{
+		{
+			name:  "no cloud provider devices",
+			setup: func(h *fakeHost) {},
+		},
+		{
+			name: "GCP disk next to partitioned boot disk",
+			setup: func(h *fakeHost) {
+				h.addDisk("sdb", "")
+				h.addDisk("sda", "", "sda1")
+				h.addLink("google-data-disk", "sdb")
+				h.addLink("google-persistent-disk-0", "sda")
+				h.addLink("scsi-0Google_PersistentDisk_persistent-disk-0", "sda")
+			},
+			expected: "/dev/sdb",
+		},
+		{
+			name: "multiple Azure candidates picks the newest link",
+			setup: func(h *fakeHost) {
+				h.addDisk("sdc", "")
+				h.addDisk("sdd", "")
+				h.addLink("scsi-3600224801111", "sdc")
+				h.addLink("scsi-3600224802222", "sdd")
+			},
+			expected: "/dev/sdd",
+		},
+		{
+			name: "mounted disk is skipped",
+			setup: func(h *fakeHost) {
+				h.addDisk("sdc", "")
+				h.addDisk("sdd", "")
+				h.addLink("scsi-3600224801111", "sdc")
+				h.addLink("scsi-3600224802222", "sdd")
+				h.mount("/dev/sdd", "/mnt/resource")
+			},
+			expected: "/dev/sdc",
+		},
+		{
+			name: "only system and mounted disks",
+			setup: func(h *fakeHost) {
+				h.addDisk("sda", "", "sda1", "sda2")
+				h.addDisk("sdb", "")
+				h.addLink("scsi-0Google_PersistentDisk_boot", "sda")
+				h.addLink("google-scratch", "sdb")
+				h.mount("/dev/sdb", "/mnt/scratch")
+			},
+		},
+		{
+			name: "partition links are skipped",
+			setup: func(h *fakeHost) {
+				h.addDisk("sda", "", "sda1")
+				h.addDisk("sdb", "")
+				h.addLink("google-data", "sdb")
+				h.addLink("google-boot-part1", "sda1")
+			},
+			expected: "/dev/sdb",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newFakeHost(t)
+			tt.setup(h)
+
+			device, err := h.mounter().findCloudProviderDevice("12345", 0)
+			if tt.expected == "" {
+				if err == nil {
+					t.Errorf("expected no device, got %s", device)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if device != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, device)
+			}
+		})
+	}
+}
+
+// withDeviceSizes makes the mounter's blockdev report the given sizes in bytes by device path
+func withDeviceSizes(m *LinuxMounter, sizes map[string]int64) *LinuxMounter {
+	fake := &testingexec.FakeExec{}
+	for i := 0; i < 20; i++ {
+		fake.CommandScript = append(fake.CommandScript, func(cmd string, args ...string) utilexec.Cmd {
+			device := args[len(args)-1]
+			return testingexec.InitFakeCmd(&testingexec.FakeCmd{
+				OutputScript: []testingexec.FakeAction{func() ([]byte, []byte, error) {
+					size, ok := sizes[device]
+					if !ok {
+						return nil, nil, testingexec.FakeExitError{Status: 1}
+					}
+					return []byte(strconv.FormatInt(size, 10) + "\n"), nil, nil
+				}},
+			}, cmd, args...)
+		})
+	}
+	m.formatter = mountutils.NewSafeFormatAndMount(mountutils.NewFakeMounter(nil), fake)
+	return m
+}
+
+func TestFindDeviceChecksSize(t *testing.T) {
+	const gib = int64(1) << 30
+
+	h := newFakeHost(t)
+	h.addDisk("nvme1n1", "")
+	h.addDisk("nvme2n1", "")
+	h.addLink(nvmeLinkPrefix+"0aaa", "nvme1n1")
+	h.addLink(nvmeLinkPrefix+"0bbb", "nvme2n1")
+	h.addDisk("sdb", "")
+	h.addLink("scsi-0Google_PersistentDisk_data", "sdb")
+	m := withDeviceSizes(h.mounter(), map[string]int64{"/dev/nvme1n1": 8 * gib, "/dev/nvme2n1": 16 * gib, "/dev/sdb": 8*gib + 4096})
+
+	// The newest device is another volume's disk of a different size
+	if device, err := m.findNVMeDevice("12345", 8*gib); err != nil || device != "/dev/nvme1n1" {
+		t.Errorf("expected the 8GiB /dev/nvme1n1, got %q, %v", device, err)
+	}
+	if device, err := m.findNVMeDevice("12345", 32*gib); err == nil {
+		t.Errorf("expected no device of 32GiB, got %s", device)
+	}
+	if device, err := m.findNVMeDevice("12345", 0); err != nil || device != "/dev/nvme2n1" {
+		t.Errorf("expected the newest device without an expected size, got %q, %v", device, err)
+	}
+
+	// Rounding by the provider is tolerated
+	if device, err := m.findCloudProviderDevice("12345", 8*gib); err != nil || device != "/dev/sdb" {
+		t.Errorf("expected /dev/sdb within the size tolerance, got %q, %v", device, err)
+	}
+	if device, err := m.findCloudProviderDevice("12345", 10*gib); err == nil {
+		t.Errorf("expected no device of 10GiB, got %s", device)
+	}
+}
+
+func TestCheckDevice(t *testing.T) {
+	const gib = int64(1) << 30
+
+	h := newFakeHost(t)
+	h.addDisk("vdb", "12345")
+	h.addDisk("vdc", "67890")
+	h.addDisk("sdc", "")
+	m := withDeviceSizes(h.mounter(), map[string]int64{"/dev/vdb": 8 * gib, "/dev/vdc": 8 * gib, "/dev/sdc": 8 * gib})
+
+	tests := []struct {
+		name        string
+		device      string
+		hints       DeviceHints
+		expectError bool
+	}{
+		{name: "no hints", device: "/dev/vdb", expectError: true},
+		{name: "size only", device: "/dev/vdb", hints: DeviceHints{SizeBytes: 8 * gib}, expectError: true},
+		{name: "matching serial and size", device: "/dev/vdb", hints: DeviceHints{Serial: "12345", SizeBytes: 8 * gib}},
+		{name: "serial moved to another device", device: "/dev/vdb", hints: DeviceHints{Serial: "67890"}, expectError: true},
+		{name: "unknown serial", device: "/dev/vdb", hints: DeviceHints{Serial: "11111"}, expectError: true},
+		{name: "other size", device: "/dev/vdb", hints: DeviceHints{Serial: "12345", SizeBytes: 16 * gib}, expectError: true},
+		{name: "device without serial", device: "/dev/sdc", hints: DeviceHints{Serial: "12345"}, expectError: true},
+		{name: "missing device", device: "/dev/vdd", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.CheckDevice(tt.device, tt.hints)
+			if tt.expectError && err == nil {
+				t.Error("expected error")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestFindDeviceBySerial(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(h *fakeHost)
+		expected string
+	}{
+		{
+			name: "virtio disk with matching serial",
+			setup: func(h *fakeHost) {
+				h.addDisk("vda", "", "vda1")
+				h.addDisk("vdb", "11111")
+				h.addDisk("vdc", "12345")
+			},
+			expected: "/dev/vdc",
+		},
+		{
+			name: "SCSI disk with matching serial",
+			setup: func(h *fakeHost) {
+				h.addDisk("sda", "99999", "sda1")
+				h.addDisk("sdb", "12345")
+			},
+			expected: "/dev/sdb",
+		},
+		{
+			name: "serial must match exactly",
+			setup: func(h *fakeHost) {
+				h.addDisk("vdb", "123456")
+				h.addDisk("vdc", "1234")
+			},
+		},
+		{
+			name: "disks without serials",
+			setup: func(h *fakeHost) {
+				h.addDisk("vdb", "")
+				h.addDisk("sdb", "")
+			},
+		},
+		{
+			name: "matching serial without a device node",
+			setup: func(h *fakeHost) {
+				h.addDisk("vdb", "12345")
+				if err := os.Remove(filepath.Join(h.root, "dev/vdb")); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "NVMe disks are not scanned by serial",
+			setup: func(h *fakeHost) {
+				h.addDisk("nvme1n1", "12345")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newFakeHost(t)
+			tt.setup(h)
+
+			device, err := h.mounter().findDeviceBySerial("12345")
+			if tt.expected == "" {
+				if err == nil {
+					t.Errorf("expected no device, got %s", device)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if device != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, device)
+			}
+		})
+	}
+}
+
+func TestFindDeviceByHints(t *testing.T) {
+	tests := []struct {
+		name     string
+		hints    DeviceHints
+		setup    func(h *fakeHost)
+		expected string
+	}{
+		{
+			name:  "serial in sysfs",
+			hints: DeviceHints{Serial: "abc123"},
+			setup: func(h *fakeHost) {
+				h.addDisk("vdb", "abc123")
+				h.addDisk("vdc", "other")
+			},
+			expected: "/dev/vdb",
+		},
+		{
+			name:  "serial in a virtio link",
+			hints: DeviceHints{Serial: "abc123"},
+			setup: func(h *fakeHost) {
+				h.addDisk("vdb", "")
+				h.addLink("virtio-abc123", "vdb")
+			},
+			expected: "/dev/vdb",
+		},
+		{
+			name:  "AWS volume ID picks its disk, not the newest",
+			hints: DeviceHints{ProviderVolumeID: "vol-0aaa"},
+			setup: func(h *fakeHost) {
+				h.addDisk("nvme1n1", "")
+				h.addDisk("nvme2n1", "")
+				h.addLink(nvmeLinkPrefix+"0aaa", "nvme1n1")
+				h.addLink(nvmeLinkPrefix+"0bbb", "nvme2n1")
+			},
+			expected: "/dev/nvme1n1",
+		},
+		{
+			name:  "AWS volume ID without udev links uses the NVMe serial",
+			hints: DeviceHints{ProviderVolumeID: "vol-0aaa"},
+			setup: func(h *fakeHost) {
+				h.addDisk("nvme1n1", "")
+				h.addDisk("nvme2n1", "")
+				h.writeFile("sys/block/nvme1n1/device/serial", "vol0aaa\n")
+				h.writeFile("sys/block/nvme2n1/device/serial", "vol0bbb\n")
+			},
+			expected: "/dev/nvme1n1",
+		},
+		{
+			name:  "GCP disk name",
+			hints: DeviceHints{ProviderVolumeID: "pvc-data"},
+			setup: func(h *fakeHost) {
+				h.addDisk("sdb", "")
+				h.addDisk("sdc", "")
+				h.addLink("scsi-0Google_PersistentDisk_pvc-data", "sdb")
+				h.addLink("google-other", "sdc")
+			},
+			expected: "/dev/sdb",
+		},
+		{
+			name:  "provider volume ID is used when the serial is not visible",
+			hints: DeviceHints{Serial: "missing", ProviderVolumeID: "vol-0aaa"},
+			setup: func(h *fakeHost) {
+				h.addDisk("nvme1n1", "")
+				h.addLink(nvmeLinkPrefix+"0aaa", "nvme1n1")
+			},
+			expected: "/dev/nvme1n1",
+		},
+		{
+			name:  "no matching device does not guess",
+			hints: DeviceHints{ProviderVolumeID: "vol-0ccc"},
+			setup: func(h *fakeHost) {
+				h.addDisk("nvme1n1", "")
+				h.addLink(nvmeLinkPrefix+"0aaa", "nvme1n1")
+			},
+		},
+		{
+			name:  "Azure LUN picks its disk, not the newest",
+			hints: DeviceHints{LUN: intPtr(0)},
+			setup: func(h *fakeHost) {
+				h.addDisk("sdc", "")
+				h.addDisk("sdd", "")
+				h.addLink("scsi-3600224801111", "sdc")
+				h.addLink("scsi-3600224802222", "sdd")
+				h.addAzureLUN(0, "sdc")
+				h.addAzureLUN(1, "sdd")
+			},
+			expected: "/dev/sdc",
+		},
+		{
+			name:  "Azure LUN through a generic by-lun link",
+			hints: DeviceHints{LUN: intPtr(1)},
+			setup: func(h *fakeHost) {
+				h.addDisk("sdc", "")
+				h.addDisk("sdd", "")
+				h.addLUNLink(0, "sdc")
+				h.addLUNLink(1, "sdd")
+			},
+			expected: "/dev/sdd",
+		},
+		{
+			name:  "Azure LUN from sysfs without udev links",
+			hints: DeviceHints{LUN: intPtr(1)},
+			setup: func(h *fakeHost) {
+				h.addDisk("sda", "")
+				h.addDisk("sdc", "")
+				h.addDisk("sdd", "")
+				h.addSCSIAddress("sda", "00000000-0001-8899-0000-000000000000", "0:0:0:1")
+				h.addSCSIAddress("sdc", azureDataDiskController, "3:0:0:0")
+				h.addSCSIAddress("sdd", azureDataDiskController, "3:0:0:1")
+			},
+			expected: "/dev/sdd",
+		},
+		{
+			name:  "Azure LUN in sysfs ignores disks off the data disk controller",
+			hints: DeviceHints{LUN: intPtr(1)},
+			setup: func(h *fakeHost) {
+				h.addDisk("sda", "")
+				h.addDisk("sdc", "")
+				h.addSCSIAddress("sda", "00000000-0001-8899-0000-000000000000", "0:0:0:1")
+				h.addSCSIAddress("sdc", azureDataDiskController, "3:0:0:0")
+			},
+		},
+		{
+			name:  "Azure LUN without a link does not guess",
+			hints: DeviceHints{LUN: intPtr(2), ProviderVolumeID: "/subscriptions/s/resourceGroups/rg/providers/Microsoft.Compute/disks/d"},
+			setup: func(h *fakeHost) {
+				h.addDisk("sdc", "")
+				h.addLink("scsi-3600224801111", "sdc")
+				h.addAzureLUN(0, "sdc")
+			},
+		},
+		{
+			name:  "Azure resource IDs are not matched against links",
+			hints: DeviceHints{ProviderVolumeID: "/subscriptions/s/resourceGroups/rg/providers/Microsoft.Compute/disks/d"},
+			setup: func(h *fakeHost) {
+				h.addDisk("sdc", "")
+				h.addLink("scsi-3600224801111", "sdc")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newFakeHost(t)
+			tt.setup(h)
+
+			device, err := h.mounter().findDeviceByHints(tt.hints)
+			if tt.expected == "" {
+				if err == nil {
+					t.Errorf("expected no device, got %s", device)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if device != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, device)
+			}
+		})
+	}
+}
+
+func TestDeviceHintsResolvable(t *testing.T) {
+	tests := []struct {
+		hints    DeviceHints
+		expected bool
+	}{
+		{DeviceHints{}, false},
+		{DeviceHints{Serial: "abc"}, true},
+		{DeviceHints{ProviderVolumeID: "vol-0abc"}, true},
+		{DeviceHints{ProviderVolumeID: "/subscriptions/s/disks/d"}, false},
+		{DeviceHints{ProviderVolumeID: "/subscriptions/s/disks/d", LUN: intPtr(0)}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.hints.resolvable(); got != tt.expected {
+			t.Errorf("%+v: expected resolvable %v, got %v", tt.hints, tt.expected, got)
+		}
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
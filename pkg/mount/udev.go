@@ -0,0 +1,62 @@
+package mount
+
+import (
+	"fmt"
+	"os/exec"
+
+	"k8s.io/klog/v2"
+)
+
+// Udev modes for device discovery
+const (
+	// UdevModeAuto uses udevadm when it is found in PATH
+	UdevModeAuto = "auto"
+
+	// UdevModeEnabled always runs udevadm trigger/settle
+	UdevModeEnabled = "enabled"
+
+	// UdevModeDisabled never runs udevadm and relies on sysfs scanning alone
+	UdevModeDisabled = "disabled"
+)
+
+// lookPath is replaced in tests
+var lookPath = exec.LookPath
+
+// ParseUdevMode decides whether device discovery runs udevadm trigger/settle in a udev mode
+// and logs the choice
+func ParseUdevMode(mode string) (bool, error) {
+	var enabled bool
+	switch mode {
+	case UdevModeAuto, "":
+		path, err := lookPath("udevadm")
+		enabled = err == nil
+		if enabled {
+			klog.Infof("Device discovery: using udevadm at %s", path)
+		} else {
+			klog.Warningf("Device discovery: udevadm not found, relying on sysfs scanning only")
+		}
+	case UdevModeEnabled:
+		enabled = true
+		klog.Infof("Device discovery: udevadm enabled")
+	case UdevModeDisabled:
+		klog.Infof("Device discovery: udevadm disabled, relying on sysfs scanning only")
+	default:
+		return false, fmt.Errorf("unsupported udev mode %q (supported: %s, %s, %s)", mode, UdevModeAuto, UdevModeEnabled, UdevModeDisabled)
+	}
+	return enabled, nil
+}
+
+// triggerUdev asks udev to re-process block devices so /dev/disk/by-id symlinks
+// are created, a no-op when udevadm is disabled
+func (m *LinuxMounter) triggerUdev() {
+	if m.disableUdev {
+		return
+	}
+
+	if out, err := exec.Command("udevadm", "trigger", "--subsystem-match=block").CombinedOutput(); err != nil {
+		klog.Warningf("udevadm trigger failed: %v: %s", err, string(out))
+	}
+	if out, err := exec.Command("udevadm", "settle", "--timeout=5").CombinedOutput(); err != nil {
+		klog.Warningf("udevadm settle failed: %v: %s", err, string(out))
+	}
+}
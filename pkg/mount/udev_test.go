@@ -0,0 +1,50 @@
+package mount
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestParseUdevMode tests udev mode selection
+func TestParseUdevMode(t *testing.T) {
+	defer func(orig func(string) (string, error)) { lookPath = orig }(lookPath)
+
+	tests := []struct {
+		name        string
+		mode        string
+		found       bool
+		expected    bool
+		expectError bool
+	}{
+		{name: "auto with udevadm", mode: UdevModeAuto, found: true, expected: true},
+		{name: "auto without udevadm", mode: UdevModeAuto, found: false, expected: false},
+		{name: "enabled without udevadm", mode: UdevModeEnabled, found: false, expected: true},
+		{name: "disabled with udevadm", mode: UdevModeDisabled, found: true, expected: false},
+		{name: "invalid", mode: "sometimes", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookPath = func(string) (string, error) {
+				if tt.found {
+					return "/usr/bin/udevadm", nil
+				}
+				return "", errors.New("not found")
+			}
+
+			enabled, err := ParseUdevMode(tt.mode)
+			if tt.expectError {
+				if err == nil {
+					t.Error("expected error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if enabled != tt.expected {
+				t.Errorf("expected enabled=%v, got %v", tt.expected, enabled)
+			}
+		})
+	}
+}
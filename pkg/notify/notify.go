@@ -0,0 +1,47 @@
+package notify
+
+import "time"
+
+// Event types sent for volume lifecycle operations
+const (
+	// EventVolumeCreated is sent when a new volume becomes available
+	EventVolumeCreated = "volume.created"
+
+	// EventVolumeDeleted is sent when a volume is deleted
+	EventVolumeDeleted = "volume.deleted"
+
+	// EventVolumeAttachFailed is sent when Emma fails to attach a volume to a node
+	EventVolumeAttachFailed = "volume.attach_failed"
+
+	// EventVolumeExpansionCompleted is sent when a volume has been resized
+	EventVolumeExpansionCompleted = "volume.expansion_completed"
+)
+
+// Event describes a volume lifecycle operation
+type Event struct {
+	Type          string    `json:"type"`
+	Time          time.Time `json:"time"`
+	Driver        string    `json:"driver,omitempty"`
+	VolumeID      string    `json:"volumeId"`
+	VolumeName    string    `json:"volumeName,omitempty"`
+	NodeID        string    `json:"nodeId,omitempty"`
+	DataCenterID  string    `json:"dataCenterId,omitempty"`
+	CapacityBytes int64     `json:"capacityBytes,omitempty"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// Notifier delivers volume lifecycle events to an external system
+type Notifier interface {
+	// Notify queues an event for delivery without blocking the operation that caused it
+	Notify(event Event)
+}
+
+// Nop returns a notifier that discards all events
+func Nop() Notifier {
+	return nopNotifier{}
+}
+
+// nopNotifier discards events when no notification target is configured
+type nopNotifier struct{}
+
+func (nopNotifier) Notify(Event) {}
@@ -0,0 +1,155 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/emma-csi-driver/pkg/metrics"
+)
+
+const (
+	// SignatureHeader carries the HMAC-SHA256 of the request body, as "sha256=<hex>"
+	SignatureHeader = "X-Emma-CSI-Signature"
+
+	// EventHeader carries the event type, so receivers can route without parsing the body
+	EventHeader = "X-Emma-CSI-Event"
+
+	// webhookQueueSize is how many events wait for delivery before new ones are dropped
+	webhookQueueSize = 100
+
+	// webhookAttempts is how many times an event is posted before it is given up
+	webhookAttempts = 3
+
+	// webhookTimeout bounds a single delivery attempt
+	webhookTimeout = 10 * time.Second
+)
+
+// WebhookNotifier posts events as JSON to a URL, signed with an HMAC secret. Events are
+// delivered in order by a background worker; when the receiver falls behind, new events
+// are dropped rather than slowing down volume operations.
+type WebhookNotifier struct {
+	url    string
+	secret []byte
+	client *http.Client
+	events chan Event
+
+	// retryInterval is the delay before the first retry, doubled for each further one
+	retryInterval time.Duration
+}
+
+// NewWebhookNotifier creates a notifier posting to webhookURL and starts its delivery
+// worker. An empty secret sends unsigned requests.
+func NewWebhookNotifier(webhookURL, secret string) (*WebhookNotifier, error) {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook URL: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q: must be an absolute http or https URL", webhookURL)
+	}
+
+	w := &WebhookNotifier{
+		url:           webhookURL,
+		secret:        []byte(secret),
+		client:        &http.Client{Timeout: webhookTimeout},
+		events:        make(chan Event, webhookQueueSize),
+		retryInterval: time.Second,
+	}
+	go w.run()
+	return w, nil
+}
+
+// Notify queues an event for delivery, dropping it when the queue is full
+func (w *WebhookNotifier) Notify(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	select {
+	case w.events <- event:
+	default:
+		klog.Warningf("Webhook queue full, dropping %s event for volume %s", event.Type, event.VolumeID)
+		metrics.RecordWebhookNotification(event.Type, "dropped")
+	}
+}
+
+// run delivers queued events until the process exits
+func (w *WebhookNotifier) run() {
+	for event := range w.events {
+		err := w.deliver(context.Background(), event)
+		if err != nil {
+			klog.Warningf("Failed to deliver %s webhook for volume %s: %v", event.Type, event.VolumeID, err)
+		}
+		metrics.RecordWebhookNotification(event.Type, metricsResult(err))
+	}
+}
+
+// deliver posts an event, retrying failed attempts with backoff
+func (w *WebhookNotifier) deliver(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	interval := w.retryInterval
+	for attempt := 1; ; attempt++ {
+		err = w.post(ctx, event.Type, body)
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+		klog.V(4).Infof("Webhook attempt %d for %s event failed, retrying in %v: %v", attempt, event.Type, interval, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+		interval *= 2
+	}
+}
+
+// post sends one signed request, treating any non-2xx response as a failure
+func (w *WebhookNotifier) post(ctx context.Context, eventType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, eventType)
+	if len(w.secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(w.secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the signature header value of body: "sha256=" and the hex HMAC-SHA256
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// metricsResult returns the result label of a delivery
+func metricsResult(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
@@ -0,0 +1,102 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewWebhookNotifierValidatesURL(t *testing.T) {
+	for _, webhookURL := range []string{"", "hooks.example.com/csi", "ftp://hooks.example.com", "https://"} {
+		if _, err := NewWebhookNotifier(webhookURL, ""); err == nil {
+			t.Errorf("expected error for webhook URL %q", webhookURL)
+		}
+	}
+}
+
+func TestWebhookNotifierSignsEvents(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	notifier, err := NewWebhookNotifier(server.URL, "hmac-secret")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	notifier.Notify(Event{Type: EventVolumeCreated, VolumeID: "42", VolumeName: "pvc-1", CapacityBytes: 1 << 30})
+
+	var req *http.Request
+	select {
+	case req = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not called")
+	}
+	body := <-bodies
+
+	if got := req.Header.Get(EventHeader); got != EventVolumeCreated {
+		t.Errorf("expected %s header %q, got %q", EventHeader, EventVolumeCreated, got)
+	}
+	if got, expected := req.Header.Get(SignatureHeader), Sign([]byte("hmac-secret"), body); got != expected {
+		t.Errorf("expected signature %q, got %q", expected, got)
+	}
+
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		t.Fatalf("invalid event body %s: %v", body, err)
+	}
+	if event.Type != EventVolumeCreated || event.VolumeID != "42" || event.VolumeName != "pvc-1" || event.CapacityBytes != 1<<30 {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if event.Time.IsZero() {
+		t.Error("expected the event time to be set")
+	}
+}
+
+func TestWebhookNotifierDeliverRetries(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < webhookAttempts {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if r.Header.Get(SignatureHeader) != "" {
+			t.Error("expected no signature without a secret")
+		}
+	}))
+	defer server.Close()
+
+	notifier := &WebhookNotifier{url: server.URL, client: server.Client(), retryInterval: time.Millisecond}
+	if err := notifier.deliver(context.Background(), Event{Type: EventVolumeDeleted, VolumeID: "42"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls.Load() != webhookAttempts {
+		t.Errorf("expected %d attempts, got %d", webhookAttempts, calls.Load())
+	}
+
+	calls.Store(-10)
+	if err := notifier.deliver(context.Background(), Event{Type: EventVolumeDeleted, VolumeID: "42"}); err == nil {
+		t.Error("expected an error once all attempts fail")
+	}
+}
+
+func TestWebhookNotifierDropsWhenQueueFull(t *testing.T) {
+	notifier := &WebhookNotifier{events: make(chan Event, 1)}
+	notifier.Notify(Event{Type: EventVolumeCreated, VolumeID: "1"})
+	notifier.Notify(Event{Type: EventVolumeCreated, VolumeID: "2"})
+
+	if queued := len(notifier.events); queued != 1 {
+		t.Fatalf("expected 1 queued event, got %d", queued)
+	}
+	if event := <-notifier.events; event.VolumeID != "1" {
+		t.Errorf("expected the first event to be kept, got volume %s", event.VolumeID)
+	}
+}
@@ -0,0 +1,245 @@
+//go:build e2e
+// +build e2e
+
+package e2e
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/emma-csi-driver/pkg/emma"
+)
+
+const (
+	topologyDataCenterKey = "topology.csi.emma.ms/datacenter"
+	csiDriverName         = "csi.emma.ms"
+)
+
+// TestTopologyScheduling tests that a WaitForFirstConsumer volume restricted by
+// allowedTopologies is provisioned in the datacenter of the node the pod lands on.
+// This test additionally requires:
+// - E2E_DATACENTER_ID set to a datacenter that has at least one labeled node
+// - EMMA_CLIENT_ID/EMMA_CLIENT_SECRET to verify the volume via the Emma API (optional)
+func TestTopologyScheduling(t *testing.T) {
+	if os.Getenv("E2E_TEST") == "" {
+		t.Skip("Skipping e2e test: E2E_TEST not set")
+	}
+
+	dataCenterID := os.Getenv("E2E_DATACENTER_ID")
+	if dataCenterID == "" {
+		t.Skip("Skipping topology e2e test: E2E_DATACENTER_ID not set")
+	}
+
+	client := getKubernetesClient(t)
+	ctx := context.Background()
+	namespace := "default"
+	testName := "emma-csi-topology-test-" + time.Now().Format("20060102-150405")
+
+	// Make sure there is a node in the target datacenter to schedule onto
+	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{
+		LabelSelector: topologyDataCenterKey + "=" + dataCenterID,
+	})
+	if err != nil {
+		t.Fatalf("failed to list nodes: %v", err)
+	}
+	if len(nodes.Items) == 0 {
+		t.Skipf("Skipping topology e2e test: no nodes labeled %s=%s", topologyDataCenterKey, dataCenterID)
+	}
+
+	// Create StorageClass restricted to the target datacenter
+	bindingMode := storagev1.VolumeBindingWaitForFirstConsumer
+	reclaimPolicy := v1.PersistentVolumeReclaimDelete
+	sc := &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: testName,
+		},
+		Provisioner: csiDriverName,
+		Parameters: map[string]string{
+			"type":         "ssd",
+			"dataCenterId": dataCenterID,
+		},
+		ReclaimPolicy:     &reclaimPolicy,
+		VolumeBindingMode: &bindingMode,
+		AllowedTopologies: []v1.TopologySelectorTerm{
+			{
+				MatchLabelExpressions: []v1.TopologySelectorLabelRequirement{
+					{
+						Key:    topologyDataCenterKey,
+						Values: []string{dataCenterID},
+					},
+				},
+			},
+		},
+	}
+
+	t.Logf("Creating StorageClass: %s", testName)
+	if _, err := client.StorageV1().StorageClasses().Create(ctx, sc, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create StorageClass: %v", err)
+	}
+
+	// Create PVC
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      testName,
+			Namespace: namespace,
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{
+				v1.ReadWriteOnce,
+			},
+			Resources: v1.VolumeResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceStorage: resource.MustParse("1Gi"),
+				},
+			},
+			StorageClassName: stringPtr(testName),
+		},
+	}
+
+	t.Logf("Creating PVC: %s", testName)
+	if _, err := client.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, pvc, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create PVC: %v", err)
+	}
+
+	// Clean up
+	defer func() {
+		t.Logf("Cleaning up resources")
+		_ = client.CoreV1().Pods(namespace).Delete(ctx, testName, metav1.DeleteOptions{})
+		_ = client.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, testName, metav1.DeleteOptions{})
+		_ = client.StorageV1().StorageClasses().Delete(ctx, testName, metav1.DeleteOptions{})
+	}()
+
+	// Create pod pinned to the target datacenter
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      testName,
+			Namespace: namespace,
+		},
+		Spec: v1.PodSpec{
+			NodeSelector: map[string]string{
+				topologyDataCenterKey: dataCenterID,
+			},
+			Containers: []v1.Container{
+				{
+					Name:    "test-container",
+					Image:   "busybox",
+					Command: []string{"sh", "-c", "sleep 3600"},
+					VolumeMounts: []v1.VolumeMount{
+						{
+							Name:      "test-volume",
+							MountPath: "/data",
+						},
+					},
+				},
+			},
+			Volumes: []v1.Volume{
+				{
+					Name: "test-volume",
+					VolumeSource: v1.VolumeSource{
+						PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+							ClaimName: testName,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	t.Logf("Creating pod: %s", testName)
+	if _, err := client.CoreV1().Pods(namespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create pod: %v", err)
+	}
+
+	// Wait for pod to be running
+	t.Log("Waiting for pod to be running...")
+	timeout := time.After(5 * time.Minute)
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	var runningPod *v1.Pod
+	for runningPod == nil {
+		select {
+		case <-timeout:
+			t.Fatal("timeout waiting for pod to be running")
+		case <-ticker.C:
+			p, err := client.CoreV1().Pods(namespace).Get(ctx, testName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get pod: %v", err)
+			}
+			if p.Status.Phase == v1.PodRunning {
+				runningPod = p
+				continue
+			}
+			t.Logf("Pod status: %s", p.Status.Phase)
+		}
+	}
+
+	// The pod must have landed on a node in the target datacenter
+	node, err := client.CoreV1().Nodes().Get(ctx, runningPod.Spec.NodeName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get node %s: %v", runningPod.Spec.NodeName, err)
+	}
+	if got := node.Labels[topologyDataCenterKey]; got != dataCenterID {
+		t.Fatalf("pod scheduled to node %s in datacenter %q, expected %q", node.Name, got, dataCenterID)
+	}
+
+	// The provisioned volume must be in the same datacenter
+	boundPVC, err := client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, testName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get PVC: %v", err)
+	}
+	pv, err := client.CoreV1().PersistentVolumes().Get(ctx, boundPVC.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get PV %s: %v", boundPVC.Spec.VolumeName, err)
+	}
+	if pv.Spec.CSI == nil {
+		t.Fatalf("PV %s is not a CSI volume", pv.Name)
+	}
+	if got := pv.Spec.CSI.VolumeAttributes["dataCenterId"]; got != dataCenterID {
+		t.Errorf("PV %s volume context datacenter = %q, expected %q", pv.Name, got, dataCenterID)
+	}
+
+	verifyEmmaVolumeDataCenter(t, ctx, pv.Spec.CSI.VolumeHandle, dataCenterID)
+}
+
+// verifyEmmaVolumeDataCenter checks the volume's datacenter through the Emma API when credentials are available
+func verifyEmmaVolumeDataCenter(t *testing.T, ctx context.Context, volumeHandle, dataCenterID string) {
+	clientID := os.Getenv("EMMA_CLIENT_ID")
+	clientSecret := os.Getenv("EMMA_CLIENT_SECRET")
+	if clientID == "" || clientSecret == "" {
+		t.Log("EMMA_CLIENT_ID/EMMA_CLIENT_SECRET not set, skipping Emma API verification")
+		return
+	}
+
+	apiURL := os.Getenv("EMMA_API_URL")
+	if apiURL == "" {
+		apiURL = "https://api.emma.ms/external"
+	}
+
+	emmaClient, err := emma.NewClient(apiURL, clientID, clientSecret)
+	if err != nil {
+		t.Fatalf("failed to create Emma client: %v", err)
+	}
+
+	volumeID, err := strconv.ParseInt(volumeHandle, 10, 32)
+	if err != nil {
+		t.Fatalf("invalid volume handle %q: %v", volumeHandle, err)
+	}
+
+	volume, err := emmaClient.GetVolume(ctx, int32(volumeID))
+	if err != nil {
+		t.Fatalf("failed to get Emma volume %d: %v", volumeID, err)
+	}
+	if volume.DataCenterID != dataCenterID {
+		t.Errorf("Emma volume %d is in datacenter %q, expected %q", volumeID, volume.DataCenterID, dataCenterID)
+	}
+	t.Logf("Emma volume %d is in datacenter %s", volumeID, volume.DataCenterID)
+}
@@ -0,0 +1,66 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emma-csi-driver/pkg/emma"
+)
+
+// cassetteDir holds the recorded Emma API interactions, one cassette per test
+const cassetteDir = "testdata/cassettes"
+
+// newClient creates an Emma client for a test. EMMA_CASSETTE_MODE selects how it talks to
+// the API: unset uses the real API, "record" additionally records the interactions to the
+// test's cassette, and "replay" answers them from the cassette without credentials.
+func newClient(t *testing.T) *emma.Client {
+	t.Helper()
+
+	mode := os.Getenv("EMMA_CASSETTE_MODE")
+	clientID := os.Getenv("EMMA_CLIENT_ID")
+	clientSecret := os.Getenv("EMMA_CLIENT_SECRET")
+	if mode != emma.CassetteModeReplay && (clientID == "" || clientSecret == "") {
+		t.Skip("Skipping integration test: EMMA_CLIENT_ID and EMMA_CLIENT_SECRET not set")
+	}
+	apiURL := os.Getenv("EMMA_API_URL")
+	if apiURL == "" {
+		apiURL = "https://api.emma.ms/external"
+	}
+
+	if mode == "" {
+		client, err := emma.NewClient(apiURL, clientID, clientSecret)
+		if err != nil {
+			t.Fatalf("failed to create Emma client: %v", err)
+		}
+		return client
+	}
+
+	path := filepath.Join(cassetteDir, strings.ReplaceAll(t.Name(), "/", "_")+".json")
+	if _, err := os.Stat(path); mode == emma.CassetteModeReplay && os.IsNotExist(err) {
+		// Skipping would let replay runs pass without checking anything
+		t.Fatalf("no cassette recorded at %s, record it with EMMA_CASSETTE_MODE=record", path)
+	}
+	transport, err := emma.NewCassetteTransport(path, mode, nil)
+	if err != nil {
+		t.Fatalf("failed to open cassette: %v", err)
+	}
+	if mode == emma.CassetteModeReplay {
+		// A recorded request the client no longer makes is contract drift too
+		t.Cleanup(func() {
+			if unused := transport.Unused(); len(unused) > 0 {
+				t.Errorf("%d recorded interactions were not replayed, first: %s %s", len(unused), unused[0].Method, unused[0].URL)
+			}
+		})
+	}
+
+	client, err := emma.NewClientWithTransport(apiURL, clientID, clientSecret, transport)
+	if err != nil {
+		t.Fatalf("failed to create Emma client: %v", err)
+	}
+	return client
+}
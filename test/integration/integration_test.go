@@ -5,41 +5,23 @@ package integration
 
 import (
 	"context"
-	"os"
 	"testing"
 	"time"
-
-	"github.com/emma-csi-driver/pkg/emma"
 )
 
 // TestVolumeLifecycle tests the complete volume lifecycle against Emma API
 // This test requires real Emma API credentials and should be run with:
 // go test -tags=integration ./test/integration/...
+// or replayed from a recorded cassette with EMMA_CASSETTE_MODE=replay
 func TestVolumeLifecycle(t *testing.T) {
-	// Skip if not running integration tests
-	if os.Getenv("EMMA_CLIENT_ID") == "" || os.Getenv("EMMA_CLIENT_SECRET") == "" {
-		t.Skip("Skipping integration test: EMMA_CLIENT_ID and EMMA_CLIENT_SECRET not set")
-	}
-
-	clientID := os.Getenv("EMMA_CLIENT_ID")
-	clientSecret := os.Getenv("EMMA_CLIENT_SECRET")
-	apiURL := os.Getenv("EMMA_API_URL")
-	if apiURL == "" {
-		apiURL = "https://api.emma.ms/external"
-	}
-
-	// Create Emma client
-	client, err := emma.NewClient(apiURL, clientID, clientSecret)
-	if err != nil {
-		t.Fatalf("failed to create Emma client: %v", err)
-	}
+	client := newClient(t)
 
 	ctx := context.Background()
 
 	// Test 1: Create volume
 	t.Run("CreateVolume", func(t *testing.T) {
 		volumeName := "test-volume-" + time.Now().Format("20060102-150405")
-		volume, err := client.CreateVolume(ctx, volumeName, 10, "ssd", "aws-eu-west-2")
+		volume, err := client.CreateVolume(ctx, volumeName, 10, "ssd", "aws-eu-west-2", nil)
 		if err != nil {
 			t.Fatalf("failed to create volume: %v", err)
 		}
@@ -76,21 +58,7 @@ func TestVolumeLifecycle(t *testing.T) {
 
 // TestConcurrentOperations tests concurrent volume operations
 func TestConcurrentOperations(t *testing.T) {
-	if os.Getenv("EMMA_CLIENT_ID") == "" || os.Getenv("EMMA_CLIENT_SECRET") == "" {
-		t.Skip("Skipping integration test: EMMA_CLIENT_ID and EMMA_CLIENT_SECRET not set")
-	}
-
-	clientID := os.Getenv("EMMA_CLIENT_ID")
-	clientSecret := os.Getenv("EMMA_CLIENT_SECRET")
-	apiURL := os.Getenv("EMMA_API_URL")
-	if apiURL == "" {
-		apiURL = "https://api.emma.ms/external"
-	}
-
-	client, err := emma.NewClient(apiURL, clientID, clientSecret)
-	if err != nil {
-		t.Fatalf("failed to create Emma client: %v", err)
-	}
+	client := newClient(t)
 
 	ctx := context.Background()
 
@@ -102,7 +70,7 @@ func TestConcurrentOperations(t *testing.T) {
 	for i := 0; i < numVolumes; i++ {
 		go func(index int) {
 			volumeName := "test-concurrent-" + time.Now().Format("20060102-150405")
-			volume, err := client.CreateVolume(ctx, volumeName, 10, "ssd", "aws-eu-west-2")
+			volume, err := client.CreateVolume(ctx, volumeName, 10, "ssd", "aws-eu-west-2", nil)
 			if err != nil {
 				errors <- err
 				return
@@ -129,21 +97,7 @@ func TestConcurrentOperations(t *testing.T) {
 
 // TestFailureScenarios tests various failure scenarios
 func TestFailureScenarios(t *testing.T) {
-	if os.Getenv("EMMA_CLIENT_ID") == "" || os.Getenv("EMMA_CLIENT_SECRET") == "" {
-		t.Skip("Skipping integration test: EMMA_CLIENT_ID and EMMA_CLIENT_SECRET not set")
-	}
-
-	clientID := os.Getenv("EMMA_CLIENT_ID")
-	clientSecret := os.Getenv("EMMA_CLIENT_SECRET")
-	apiURL := os.Getenv("EMMA_API_URL")
-	if apiURL == "" {
-		apiURL = "https://api.emma.ms/external"
-	}
-
-	client, err := emma.NewClient(apiURL, clientID, clientSecret)
-	if err != nil {
-		t.Fatalf("failed to create Emma client: %v", err)
-	}
+	client := newClient(t)
 
 	ctx := context.Background()
 
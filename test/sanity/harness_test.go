@@ -0,0 +1,201 @@
+package sanity
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	emmasdk "github.com/emma-community/emma-go-sdk"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/emma-csi-driver/internal/fakeemma"
+	"github.com/emma-csi-driver/pkg/driver"
+	"github.com/emma-csi-driver/pkg/mount"
+)
+
+const (
+	// nodeID is the node plugin's ID, a numeric Emma VM ID the controller uses as is
+	nodeID   = "1"
+	nodeVMID = 1
+
+	// dataCenterID is the datacenter volumes are created in
+	dataCenterID = "aws-eu-west-2"
+)
+
+// harness is a controller and node plugin served on a local unix socket, backed by the fake
+// Emma API and a fake mounter
+type harness struct {
+	endpoint string
+	emma     *fakeemma.API
+	mounter  *mount.FakeMounter
+
+	// dir holds the socket and is free for staging and target paths
+	dir string
+}
+
+// startDriver runs the driver in all modes on a unix socket until the test ends
+func startDriver(t *testing.T) *harness {
+	t.Helper()
+
+	// Unix socket paths are limited to about 100 bytes, shorter than some test temp dirs
+	dir, err := os.MkdirTemp("", "emma-csi-sanity")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "csi.sock")
+
+	// NodeGetInfo reports the datacenter of the environment without an Emma client
+	t.Setenv("EMMA_DATACENTER_ID", dataCenterID)
+
+	h := &harness{
+		endpoint: "unix://" + socket,
+		emma:     fakeemma.New(),
+		mounter:  mount.NewFakeMounter(),
+		dir:      dir,
+	}
+
+	drv, err := driver.NewDriver(nodeID, h.endpoint, driver.WithMounter(h.mounter))
+	if err != nil {
+		t.Fatalf("failed to create driver: %v", err)
+	}
+	// Volumes attach only to the node plugin's VM, so other node IDs are NotFound
+	h.emma.VMs = []emmasdk.Vm{{Id: emmasdk.PtrInt32(nodeVMID)}}
+	controller := driver.NewControllerService(drv, h.emma)
+	controller.SetDefaultDataCenter(dataCenterID)
+	node := driver.NewNodeService(drv)
+	if err := node.SetStateFile(filepath.Join(dir, "node-state.json")); err != nil {
+		t.Fatalf("failed to set node state file: %v", err)
+	}
+	drv.SetIdentityService(driver.NewIdentityService(drv))
+	drv.SetControllerService(controller)
+	drv.SetNodeService(node)
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen on %s: %v", socket, err)
+	}
+	drv.SetListener(listener)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- drv.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("driver failed: %v", err)
+		}
+	})
+
+	return h
+}
+
+// dial connects to the harness endpoint
+func (h *harness) dial(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+	conn, err := grpc.Dial(h.endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to connect to %s: %v", h.endpoint, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// TestHarnessVolumeLifecycle runs a volume through every CSI call kubelet and the sidecars
+// make, checking the harness serves a working driver even without the sanity suite
+func TestHarnessVolumeLifecycle(t *testing.T) {
+	h := startDriver(t)
+	conn := h.dial(t)
+	ctx := context.Background()
+	identity, controller, node := csi.NewIdentityClient(conn), csi.NewControllerClient(conn), csi.NewNodeClient(conn)
+
+	if _, err := identity.Probe(ctx, &csi.ProbeRequest{}); err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+	health, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil || health.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("expected the health service to report SERVING, got %v (%v)", health.GetStatus(), err)
+	}
+	info, err := node.NodeGetInfo(ctx, &csi.NodeGetInfoRequest{})
+	if err != nil || info.GetNodeId() != nodeID {
+		t.Fatalf("expected node ID %s, got %v (%v)", nodeID, info.GetNodeId(), err)
+	}
+
+	capability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	created, err := controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "pvc-lifecycle",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 30},
+		VolumeCapabilities: []*csi.VolumeCapability{capability},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	volumeID := created.GetVolume().GetVolumeId()
+
+	published, err := controller.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId:         volumeID,
+		NodeId:           info.GetNodeId(),
+		VolumeCapability: capability,
+		VolumeContext:    created.GetVolume().GetVolumeContext(),
+	})
+	if err != nil {
+		t.Fatalf("ControllerPublishVolume failed: %v", err)
+	}
+
+	stagingPath, targetPath := filepath.Join(h.dir, "staging"), filepath.Join(h.dir, "target")
+	for _, path := range []string{stagingPath, targetPath} {
+		if err := os.MkdirAll(path, 0750); err != nil {
+			t.Fatalf("failed to create %s: %v", path, err)
+		}
+	}
+	if _, err := node.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          volumeID,
+		PublishContext:    published.GetPublishContext(),
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  capability,
+		VolumeContext:     created.GetVolume().GetVolumeContext(),
+	}); err != nil {
+		t.Fatalf("NodeStageVolume failed: %v", err)
+	}
+	if _, err := node.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:          volumeID,
+		PublishContext:    published.GetPublishContext(),
+		StagingTargetPath: stagingPath,
+		TargetPath:        targetPath,
+		VolumeCapability:  capability,
+		VolumeContext:     created.GetVolume().GetVolumeContext(),
+	}); err != nil {
+		t.Fatalf("NodePublishVolume failed: %v", err)
+	}
+	if mounts := h.mounter.MountPoints(); mounts[targetPath] != stagingPath {
+		t.Errorf("expected %s bind mounted at %s, got %v", stagingPath, targetPath, mounts)
+	}
+
+	if _, err := node.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: volumeID, TargetPath: targetPath}); err != nil {
+		t.Fatalf("NodeUnpublishVolume failed: %v", err)
+	}
+	if _, err := node.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: volumeID, StagingTargetPath: stagingPath}); err != nil {
+		t.Fatalf("NodeUnstageVolume failed: %v", err)
+	}
+	if mounts := h.mounter.MountPoints(); len(mounts) != 0 {
+		t.Errorf("expected no mounts after unstaging, got %v", mounts)
+	}
+	if _, err := controller.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{VolumeId: volumeID, NodeId: nodeID}); err != nil {
+		t.Fatalf("ControllerUnpublishVolume failed: %v", err)
+	}
+	if _, err := controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID}); err != nil {
+		t.Fatalf("DeleteVolume failed: %v", err)
+	}
+
+	volumes, err := h.emma.ListVolumes(ctx)
+	if err != nil || len(volumes) != 0 {
+		t.Errorf("expected no volumes left in Emma, got %v (%v)", volumes, err)
+	}
+}
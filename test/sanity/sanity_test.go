@@ -0,0 +1,52 @@
+package sanity
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kubernetes-csi/csi-test/v5/pkg/sanity"
+)
+
+// TestSanity runs the csi-sanity conformance suite against the controller and node plugin,
+// checking idempotency, error codes and pagination as the CSI spec requires
+func TestSanity(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping the csi-sanity suite in short mode")
+	}
+	h := startDriver(t)
+
+	config := sanity.NewTestConfig()
+	config.Address = h.endpoint
+	config.TargetPath = filepath.Join(h.dir, "target")
+	config.StagingPath = filepath.Join(h.dir, "staging")
+	config.TestVolumeSize = 1 << 30
+	config.TestVolumeExpandSize = 2 << 30
+	config.IdempotentCount = 2
+	config.IDGen = &numericIDs{next: 900000}
+
+	sanity.Test(t, config)
+}
+
+// numericIDs generates the numeric volume and node IDs the driver expects, so requests for
+// volumes that do not exist fail with NotFound rather than InvalidArgument
+type numericIDs struct {
+	next int64
+}
+
+func (g *numericIDs) GenerateUniqueValidVolumeID() string {
+	return fmt.Sprint(atomic.AddInt64(&g.next, 1))
+}
+
+func (g *numericIDs) GenerateInvalidVolumeID() string {
+	return "invalid-volume-id"
+}
+
+func (g *numericIDs) GenerateUniqueValidNodeID() string {
+	return fmt.Sprint(atomic.AddInt64(&g.next, 1))
+}
+
+func (g *numericIDs) GenerateInvalidNodeID() string {
+	return "invalid-node-id"
+}